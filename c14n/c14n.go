@@ -0,0 +1,337 @@
+// Package c14n implements Canonical XML 1.0 and Exclusive XML
+// Canonicalization 1.0 over simplexml/dom trees.
+//
+// Canonical forms are what XML signatures digest, so two trees that mean
+// the same thing must canonicalize to the same bytes however they were
+// written.  The dom package does not keep the prefixes a document was
+// written with, so the prefix of each name is recovered from the xmlns
+// attributes in scope, preferring the default namespace.  Every namespace
+// used must therefore be declared by an xmlns attribute on the element or
+// one of its ancestors, as it is in any parsed document.
+//
+// Trees have no comments or processing instructions, and keep a single
+// run of Content per element written before the children, so the
+// WithComments variants give the same results as the plain ones, and
+// whitespace the parser dropped is not part of the canonical form.
+// Exclusive canonicalization only depends on the names used inside the
+// subtree, so it is not affected by where the encoder moves namespace
+// declarations and is the one to use for trees that are written out and
+// parsed back.
+//
+// For some basic usage examples, see c14n_test.go
+package c14n
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+// Algorithm identifiers, as used in XML signatures.
+const (
+	Inclusive             = "http://www.w3.org/TR/2001/REC-xml-c14n-20010315"
+	InclusiveWithComments = Inclusive + "#WithComments"
+	Exclusive             = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	ExclusiveWithComments = Exclusive + "WithComments"
+)
+
+// Options controls canonicalization.
+type Options struct {
+	// Exclusive selects Exclusive XML Canonicalization, which only writes
+	// the namespace declarations each element uses.
+	Exclusive bool
+	// InclusivePrefixes lists prefixes that exclusive canonicalization
+	// treats the inclusive way, writing their declarations whenever they
+	// are in scope.  "#default" stands for the default namespace.
+	InclusivePrefixes []string
+	// Exclude, if not nil, is called for every element, and elements it
+	// returns true for are left out along with their subtrees.
+	Exclude func(*dom.Element) bool
+}
+
+// ForAlgorithm returns the Options for one of the algorithm identifiers,
+// or an error if the algorithm is not known.
+func ForAlgorithm(alg string) (*Options, error) {
+	switch alg {
+	case Inclusive, InclusiveWithComments:
+		return &Options{}, nil
+	case Exclusive, ExclusiveWithComments:
+		return &Options{Exclusive: true}, nil
+	}
+	return nil, fmt.Errorf("c14n: unsupported algorithm %s", alg)
+}
+
+// scope maps prefixes to namespace URIs, with "" for the default
+// namespace.
+type scope map[string]string
+
+func (s scope) with(e *dom.Element) scope {
+	res := s
+	copied := false
+	for _, a := range e.Attributes {
+		prefix, ok := declares(a.Name.Space, a.Name.Local)
+		if !ok {
+			continue
+		}
+		if !copied {
+			res = scope{}
+			for k, v := range s {
+				res[k] = v
+			}
+			copied = true
+		}
+		res[prefix] = a.Value
+	}
+	return res
+}
+
+// declares reports whether an attribute with the given name is a
+// namespace declaration, and for which prefix.
+func declares(space, local string) (string, bool) {
+	switch {
+	case space == "xmlns":
+		return local, true
+	case space == "" && local == "xmlns":
+		return "", true
+	}
+	return "", false
+}
+
+// prefix returns the prefix to write uri with.  Attributes are never in
+// the default namespace.
+func (s scope) prefix(e *dom.Element, uri string, attr bool) (string, error) {
+	switch {
+	case uri == "":
+		return "", nil
+	case uri == dom.NS_XML:
+		return "xml", nil
+	case !attr && s[""] == uri:
+		return "", nil
+	}
+	found := []string{}
+	for p, u := range s {
+		if p != "" && u == uri {
+			found = append(found, p)
+		}
+	}
+	if len(found) == 0 {
+		return "", fmt.Errorf("c14n: %s: no prefix is declared for namespace %s", e.Path(), uri)
+	}
+	sort.Strings(found)
+	return found[0], nil
+}
+
+func qname(prefix, local string) string {
+	if prefix == "" {
+		return local
+	}
+	return prefix + ":" + local
+}
+
+type canonicalizer struct {
+	opts      *Options
+	inclusive map[string]bool
+	buf       interface{ WriteString(string) (int, error) }
+}
+
+// Canonicalize returns the canonical form of the subtree rooted at e.
+// Namespace declarations and xml: attributes inherited from the
+// ancestors of e are taken into account as the algorithm requires.  opts
+// can be nil to use Canonical XML 1.0.
+func Canonicalize(e *dom.Element, opts *Options) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := canonicalize(&buf, e, opts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Write writes the canonical form of the subtree rooted at e to w, as
+// Canonicalize would return it, without holding all of it in memory.
+func Write(w io.Writer, e *dom.Element, opts *Options) error {
+	bw := bufio.NewWriter(w)
+	if err := canonicalize(bw, e, opts); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Fingerprint returns the digest h makes of the exclusive canonical
+// form of the subtree rooted at e, after resetting h, so that trees can
+// be deduplicated or checked for changes without keeping serialized
+// copies of them around.  Trees that mean the same thing have the same
+// fingerprint, however they were written and wherever they are in their
+// documents.  For another canonical form, use Write with h.
+func Fingerprint(e *dom.Element, h hash.Hash) ([]byte, error) {
+	h.Reset()
+	if err := Write(h, e, &Options{Exclusive: true}); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+func canonicalize(w interface{ WriteString(string) (int, error) }, e *dom.Element, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	c := &canonicalizer{opts: opts, inclusive: map[string]bool{}, buf: w}
+	for _, p := range opts.InclusivePrefixes {
+		if p == "#default" {
+			p = ""
+		}
+		c.inclusive[p] = true
+	}
+	ancestors := e.Ancestors()
+	s := scope{}
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		s = s.with(ancestors[i])
+	}
+	var inherited []attr
+	if !opts.Exclusive {
+		inherited = xmlAttrs(ancestors)
+	}
+	return c.element(e, s, scope{}, inherited)
+}
+
+type attr struct {
+	space, local, qname, value string
+}
+
+// xmlAttrs returns the xml: attributes in effect from ancestors, nearest
+// first winning, which inclusive canonicalization copies to the top
+// element of a subtree.
+func xmlAttrs(ancestors []*dom.Element) []attr {
+	res := []attr{}
+	seen := map[string]bool{}
+	for _, a := range ancestors {
+		for _, at := range a.Attributes {
+			if at.Name.Space == dom.NS_XML && !seen[at.Name.Local] {
+				seen[at.Name.Local] = true
+				res = append(res, attr{dom.NS_XML, at.Name.Local, "xml:" + at.Name.Local, at.Value})
+			}
+		}
+	}
+	return res
+}
+
+// element writes e.  s is the namespace scope of the parent of e in the
+// tree, and rendered holds the declarations already written by the
+// output ancestors of e.
+func (c *canonicalizer) element(e *dom.Element, s, rendered scope, inherited []attr) error {
+	if c.opts.Exclude != nil && c.opts.Exclude(e) {
+		return nil
+	}
+	s = s.with(e)
+	prefix, err := s.prefix(e, e.Name.Space, false)
+	if err != nil {
+		return err
+	}
+	attrs := []attr{}
+	own := map[string]bool{}
+	used := map[string]string{prefix: e.Name.Space}
+	for _, a := range e.Attributes {
+		if _, ok := declares(a.Name.Space, a.Name.Local); ok {
+			continue
+		}
+		p, err := s.prefix(e, a.Name.Space, true)
+		if err != nil {
+			return err
+		}
+		if p != "" {
+			used[p] = a.Name.Space
+		}
+		if a.Name.Space == dom.NS_XML {
+			own[a.Name.Local] = true
+		}
+		attrs = append(attrs, attr{a.Name.Space, a.Name.Local, qname(p, a.Name.Local), a.Value})
+	}
+	for _, a := range inherited {
+		if !own[a.local] {
+			attrs = append(attrs, a)
+		}
+	}
+	// Work out which declarations to write.
+	wanted := scope{}
+	if c.opts.Exclusive {
+		for p, u := range used {
+			wanted[p] = u
+		}
+		for p := range c.inclusive {
+			if u, ok := s[p]; ok {
+				wanted[p] = u
+			}
+		}
+	} else {
+		for p, u := range s {
+			wanted[p] = u
+		}
+		delete(wanted, "xml")
+	}
+	decls := []string{}
+	next, copied := rendered, false
+	for p, u := range wanted {
+		if rendered[p] == u {
+			continue
+		}
+		if p != "" && u == "" {
+			// Undeclaring a prefix is only possible in XML 1.1.
+			continue
+		}
+		if !copied {
+			next, copied = scope{}, true
+			for k, v := range rendered {
+				next[k] = v
+			}
+		}
+		next[p] = u
+		decls = append(decls, p)
+	}
+	sort.Strings(decls)
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].space != attrs[j].space {
+			return attrs[i].space < attrs[j].space
+		}
+		return attrs[i].local < attrs[j].local
+	})
+	name := qname(prefix, e.Name.Local)
+	c.buf.WriteString("<" + name)
+	for _, p := range decls {
+		decl := "xmlns"
+		if p != "" {
+			decl += ":" + p
+		}
+		c.buf.WriteString(" " + decl + "=\"" + escapeAttr(next[p]) + "\"")
+	}
+	for _, a := range attrs {
+		c.buf.WriteString(" " + a.qname + "=\"" + escapeAttr(a.value) + "\"")
+	}
+	c.buf.WriteString(">")
+	c.buf.WriteString(escapeText(string(e.Content)))
+	for _, child := range e.Children() {
+		if err := c.element(child, s, next, nil); err != nil {
+			return err
+		}
+	}
+	c.buf.WriteString("</" + name + ">")
+	return nil
+}
+
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", "\"", "&quot;",
+		"\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+func escapeAttr(s string) string {
+	return attrEscaper.Replace(s)
+}
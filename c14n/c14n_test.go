@@ -0,0 +1,115 @@
+package c14n
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"strings"
+	"testing"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+const sample = `<a:root xmlns:a="urn:a" xmlns:b="urn:b" xmlns:c="urn:c" xml:lang="en">
+  <a:child z="2" b:attr="1" a="x&quot;&#9;y">
+    x &amp; y &gt; z&#13;
+    <plain xmlns="urn:d"><inner/><c:leaf/><none xmlns=""/></plain>
+  </a:child>
+</a:root>`
+
+func child(t *testing.T) *dom.Element {
+	doc, err := dom.Parse(strings.NewReader(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc.Root().Children()[0]
+}
+
+func TestCanonicalize(t *testing.T) {
+	e := child(t)
+	for _, test := range []struct {
+		opts *Options
+		want string
+	}{
+		{nil,
+			`<a:child xmlns:a="urn:a" xmlns:b="urn:b" xmlns:c="urn:c" a="x&quot;&#x9;y" z="2" xml:lang="en" b:attr="1">x &amp; y &gt; z` +
+				`<plain xmlns="urn:d"><inner></inner><c:leaf></c:leaf><none xmlns=""></none></plain></a:child>`},
+		{&Options{Exclusive: true},
+			`<a:child xmlns:a="urn:a" xmlns:b="urn:b" a="x&quot;&#x9;y" z="2" b:attr="1">x &amp; y &gt; z` +
+				`<plain xmlns="urn:d"><inner></inner><c:leaf xmlns:c="urn:c"></c:leaf><none xmlns=""></none></plain></a:child>`},
+		{&Options{Exclusive: true, InclusivePrefixes: []string{"c", "#default", "missing"}},
+			`<a:child xmlns:a="urn:a" xmlns:b="urn:b" xmlns:c="urn:c" a="x&quot;&#x9;y" z="2" b:attr="1">x &amp; y &gt; z` +
+				`<plain xmlns="urn:d"><inner></inner><c:leaf></c:leaf><none xmlns=""></none></plain></a:child>`},
+		{&Options{Exclusive: true, Exclude: func(e *dom.Element) bool { return e.Name.Local == "plain" }},
+			`<a:child xmlns:a="urn:a" xmlns:b="urn:b" a="x&quot;&#x9;y" z="2" b:attr="1">x &amp; y &gt; z</a:child>`},
+	} {
+		res, err := Canonicalize(e, test.opts)
+		if err != nil || string(res) != test.want {
+			t.Errorf("Options %+v:\nwant %s\ngot  %s, %v", test.opts, test.want, res, err)
+		}
+	}
+}
+
+func TestEncoderRoundTrip(t *testing.T) {
+	// The encoder moves every declaration to the root, which exclusive
+	// canonicalization does not notice.
+	e := child(t)
+	want, err := Canonicalize(e, &Options{Exclusive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := dom.Parse(strings.NewReader(e.Parent().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Canonicalize(doc.Root().Children()[0], &Options{Exclusive: true})
+	if err != nil || string(got) != string(want) {
+		t.Errorf("want %s\ngot  %s, %v", want, got, err)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	e := child(t)
+	want, err := Canonicalize(e, &Options{Exclusive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(want)
+	h := sha256.New()
+	h.Write([]byte("stale"))
+	got, err := Fingerprint(e, h)
+	if err != nil || !bytes.Equal(got, sum[:]) {
+		t.Errorf("Fingerprint should digest the exclusive canonical form, got %x, %v", got, err)
+	}
+	doc, err := dom.Parse(strings.NewReader(`<a:child xmlns:a="urn:a" xmlns:b="urn:b" b:attr='1' z='2' a="x&#34;&#x9;y">` +
+		`x &amp; y &gt; z&#13;<plain xmlns="urn:d"><inner/><c:leaf xmlns:c="urn:c"/><none xmlns=""/></plain></a:child>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	same, err := Fingerprint(doc.Root(), sha256.New())
+	if err != nil || !bytes.Equal(same, got) {
+		t.Errorf("The same tree written differently should have the same fingerprint")
+	}
+	doc.Root().Attr("z", "", "3")
+	if changed, _ := Fingerprint(doc.Root(), sha256.New()); bytes.Equal(changed, got) {
+		t.Errorf("A changed tree should have a different fingerprint")
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, e, &Options{Exclusive: true}); err != nil || !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("Write should write what Canonicalize returns, got %s, %v", buf.Bytes(), err)
+	}
+	if _, err := Fingerprint(dom.Elem("x", "urn:undeclared"), sha256.New()); err == nil {
+		t.Errorf("Expected an undeclared namespace to be rejected")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	if _, err := Canonicalize(dom.Elem("x", "urn:undeclared"), nil); err == nil {
+		t.Errorf("Expected an undeclared namespace to be rejected")
+	}
+	if _, err := ForAlgorithm("urn:bogus"); err == nil {
+		t.Errorf("Expected an unknown algorithm to be rejected")
+	}
+	if opts, err := ForAlgorithm(ExclusiveWithComments); err != nil || !opts.Exclusive {
+		t.Errorf("Unexpected options %+v, %v", opts, err)
+	}
+}
@@ -0,0 +1,458 @@
+// Package catalog implements OASIS XML Catalogs 1.1, which map the public
+// and system identifiers of external entities and DTDs, and other URIs
+// such as schema locations, to local copies.  Resolving through a
+// catalog lets documents that refer to remote resources be processed
+// offline and always against the same files.
+//
+// All the entry types are supported: system, rewriteSystem,
+// systemSuffix, public, uri, rewriteURI, uriSuffix, the delegate
+// entries, nextCatalog and group, along with xml:base and prefer.
+// Catalogs named by nextCatalog and delegate entries are read the first
+// time they are needed, through the same Opener as the catalog that
+// names them, and catalogs that cannot be read or parsed are skipped, as
+// the spec requires.  Public identifiers given as urn:publicid: URNs are
+// unwrapped.
+//
+// Opener has the same signature as xinclude.Resolver, so the Opener
+// method can sit in front of xinclude.FS:
+//
+//	cat, err := catalog.Open("catalog.xml", catalog.Opener(xinclude.FS(fsys)))
+//	p := &xinclude.Processor{Resolver: xinclude.Resolver(cat.Opener(catalog.Opener(xinclude.FS(fsys))))}
+//
+// For some basic usage examples, see catalog_test.go
+package catalog
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+// NS_CATALOG is the namespace of catalog files.
+const NS_CATALOG = "urn:oasis:names:tc:entity:xmlns:xml:catalog"
+
+// Opener reads the resource at location.  Its Resolve method makes it a
+// dom.Resolver.
+type Opener func(location string) (io.ReadCloser, error)
+
+// Resolve calls o.
+func (o Opener) Resolve(location string) (io.ReadCloser, error) {
+	return o(location)
+}
+
+type entry struct {
+	kind string
+	// match is the identifier, prefix or suffix the entry matches.
+	match string
+	// target is what the entry maps to: a URI, a rewrite prefix, or the
+	// location of a catalog.
+	target string
+	// public is set if the entry was in the scope of prefer="public".
+	public bool
+}
+
+// set holds the catalogs reached from one catalog, so that each one is
+// only read once.
+type set struct {
+	mu       sync.Mutex
+	open     Opener
+	catalogs map[string]*Catalog
+}
+
+func (s *set) get(location string) *Catalog {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.catalogs[location]; ok {
+		return c
+	}
+	// Record the attempt first, so that loops of catalogs end.
+	s.catalogs[location] = nil
+	if s.open == nil {
+		return nil
+	}
+	r, err := s.open(location)
+	if err != nil {
+		return nil
+	}
+	defer r.Close()
+	doc, err := dom.Parse(r)
+	if err != nil {
+		return nil
+	}
+	c, err := load(doc, location, s)
+	if err != nil {
+		return nil
+	}
+	s.catalogs[location] = c
+	return c
+}
+
+// Catalog is a parsed catalog file.  A Catalog is safe for concurrent use.
+type Catalog struct {
+	entries []entry
+	set     *set
+}
+
+// Open reads the catalog at location with open, which is also used to
+// read the catalogs it refers to.
+func Open(location string, open Opener) (*Catalog, error) {
+	r, err := open(location)
+	if err != nil {
+		return nil, fmt.Errorf("catalog: %v", err)
+	}
+	defer r.Close()
+	return Parse(r, location, open)
+}
+
+// Parse reads a catalog from r.  base is the location of the catalog,
+// which relative URIs in it are resolved against.  open reads the
+// catalogs it refers to, and may be nil if there are none.
+func Parse(r io.Reader, base string, open Opener) (*Catalog, error) {
+	doc, err := dom.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	return Load(doc, base, open)
+}
+
+// Load is like Parse, but takes an already parsed Document.
+func Load(doc *dom.Document, base string, open Opener) (*Catalog, error) {
+	s := &set{open: open, catalogs: map[string]*Catalog{}}
+	c, err := load(doc, base, s)
+	if err != nil {
+		return nil, err
+	}
+	s.catalogs[base] = c
+	return c, nil
+}
+
+func load(doc *dom.Document, base string, s *set) (*Catalog, error) {
+	root := doc.Root()
+	if root == nil || root.Name.Space != NS_CATALOG || root.Name.Local != "catalog" {
+		return nil, fmt.Errorf("catalog: %s is not a catalog", base)
+	}
+	c := &Catalog{set: s}
+	if err := c.add(root, base, true); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func attr(e *dom.Element, name, space string) (string, bool) {
+	if a := e.GetAttr(name, space, "*"); len(a) > 0 {
+		return a[0].Value, true
+	}
+	return "", false
+}
+
+func resolve(base, ref string) (string, error) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("catalog: bad base %q: %v", base, err)
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("catalog: bad URI %q: %v", ref, err)
+	}
+	if b.Scheme == "" && b.Host == "" && r.Scheme == "" && r.Host == "" && !strings.HasPrefix(r.Path, "/") {
+		// Keep relative locations relative, so that they can be read
+		// from an fs.FS.  Trailing slashes matter for bases and rewrite
+		// prefixes, so they are kept too.
+		res := path.Join(path.Dir(b.Path), r.Path)
+		if strings.HasSuffix(r.Path, "/") {
+			res += "/"
+		}
+		return res, nil
+	}
+	return b.ResolveReference(r).String(), nil
+}
+
+// attrs maps each entry type to its match and target attributes.
+var attrs = map[string][2]string{
+	"public":         {"publicId", "uri"},
+	"system":         {"systemId", "uri"},
+	"rewriteSystem":  {"systemIdStartString", "rewritePrefix"},
+	"systemSuffix":   {"systemIdSuffix", "uri"},
+	"delegatePublic": {"publicIdStartString", "catalog"},
+	"delegateSystem": {"systemIdStartString", "catalog"},
+	"uri":            {"name", "uri"},
+	"rewriteURI":     {"uriStartString", "rewritePrefix"},
+	"uriSuffix":      {"uriSuffix", "uri"},
+	"delegateURI":    {"uriStartString", "catalog"},
+	"nextCatalog":    {"", "catalog"},
+}
+
+// add adds the entries in e, a catalog or group element.
+func (c *Catalog) add(e *dom.Element, base string, public bool) error {
+	var err error
+	if b, ok := attr(e, "base", dom.NS_XML); ok {
+		if base, err = resolve(base, b); err != nil {
+			return err
+		}
+	}
+	if p, ok := attr(e, "prefer", ""); ok {
+		public = p == "public"
+	}
+	for _, child := range e.Children() {
+		if child.Name.Space != NS_CATALOG {
+			continue
+		}
+		kind := child.Name.Local
+		if kind == "group" {
+			if err := c.add(child, base, public); err != nil {
+				return err
+			}
+			continue
+		}
+		names, ok := attrs[kind]
+		if !ok {
+			continue
+		}
+		ent := entry{kind: kind, public: public}
+		if names[0] != "" {
+			if ent.match, ok = attr(child, names[0], ""); !ok {
+				return fmt.Errorf("catalog: %v: %s needs a %s", child.Pos(), kind, names[0])
+			}
+		}
+		target, ok := attr(child, names[1], "")
+		if !ok {
+			return fmt.Errorf("catalog: %v: %s needs a %s", child.Pos(), kind, names[1])
+		}
+		entryBase := base
+		if b, ok := attr(child, "base", dom.NS_XML); ok {
+			if entryBase, err = resolve(base, b); err != nil {
+				return err
+			}
+		}
+		if ent.target, err = resolve(entryBase, target); err != nil {
+			return err
+		}
+		if kind == "public" || kind == "delegatePublic" {
+			ent.match = normalizePublic(ent.match)
+		} else {
+			ent.match = normalizeSystem(ent.match)
+		}
+		c.entries = append(c.entries, ent)
+	}
+	return nil
+}
+
+func normalizePublic(id string) string {
+	return strings.Join(strings.Fields(id), " ")
+}
+
+// normalizeSystem percent-encodes the characters that are not allowed in
+// URIs, as the spec requires for system identifiers and URIs.
+func normalizeSystem(id string) string {
+	var b strings.Builder
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if c <= 0x20 || c >= 0x7f || strings.IndexByte(`"<>\^`+"`{|}", c) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+const publicIDURN = "urn:publicid:"
+
+var unwrapper = strings.NewReplacer(
+	"+", " ", ":", "//", ";", "::", "%2B", "+", "%3A", ":", "%2F", "/",
+	"%3B", ";", "%27", "'", "%3F", "?", "%23", "#", "%25", "%")
+
+// unwrap turns a urn:publicid: URN back into the public identifier it
+// holds.
+func unwrap(id string) (string, bool) {
+	if len(id) < len(publicIDURN) || !strings.EqualFold(id[:len(publicIDURN)], publicIDURN) {
+		return id, false
+	}
+	return unwrapper.Replace(id[len(publicIDURN):]), true
+}
+
+// lookup finds what the entries of type exact, rewrite and suffix map id
+// to, in that order of preference.
+func (c *Catalog) lookup(id, exact, rewrite, suffix string) (string, bool) {
+	best, bestLen := "", -1
+	for _, e := range c.entries {
+		if e.kind == exact && e.match == id {
+			return e.target, true
+		}
+	}
+	for _, e := range c.entries {
+		if e.kind == rewrite && strings.HasPrefix(id, e.match) && len(e.match) > bestLen {
+			best, bestLen = e.target+id[len(e.match):], len(e.match)
+		}
+	}
+	if bestLen >= 0 {
+		return best, true
+	}
+	for _, e := range c.entries {
+		if e.kind == suffix && strings.HasSuffix(id, e.match) && len(e.match) > bestLen {
+			best, bestLen = e.target, len(e.match)
+		}
+	}
+	return best, bestLen >= 0
+}
+
+// delegates returns the catalogs named by entries of type kind whose
+// prefix id starts with, longest prefix first.
+func (c *Catalog) delegates(id, kind string) []*Catalog {
+	matches := []entry{}
+	for _, e := range c.entries {
+		if e.kind == kind && strings.HasPrefix(id, e.match) {
+			matches = append(matches, e)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return len(matches[i].match) > len(matches[j].match) })
+	res := []*Catalog{}
+	seen := map[string]bool{}
+	for _, e := range matches {
+		if seen[e.target] {
+			continue
+		}
+		seen[e.target] = true
+		if d := c.set.get(e.target); d != nil {
+			res = append(res, d)
+		}
+	}
+	return res
+}
+
+// next returns the catalogs named by nextCatalog entries.
+func (c *Catalog) next() []*Catalog {
+	res := []*Catalog{}
+	for _, e := range c.entries {
+		if e.kind == "nextCatalog" {
+			if n := c.set.get(e.target); n != nil {
+				res = append(res, n)
+			}
+		}
+	}
+	return res
+}
+
+// entity resolves an external identifier.  done is set when delegation
+// has ended the search whether or not anything was found.
+func (c *Catalog) entity(public, system string, seen map[*Catalog]bool) (res string, found, done bool) {
+	if seen[c] {
+		return "", false, false
+	}
+	seen[c] = true
+	if system != "" {
+		if res, ok := c.lookup(system, "system", "rewriteSystem", "systemSuffix"); ok {
+			return res, true, true
+		}
+		if ds := c.delegates(system, "delegateSystem"); len(ds) > 0 {
+			for _, d := range ds {
+				if res, ok, _ := d.entity("", system, map[*Catalog]bool{}); ok {
+					return res, true, true
+				}
+			}
+			return "", false, true
+		}
+	}
+	if public != "" {
+		for _, e := range c.entries {
+			if e.kind == "public" && e.match == public && (e.public || system == "") {
+				return e.target, true, true
+			}
+		}
+		if ds := c.delegates(public, "delegatePublic"); len(ds) > 0 {
+			for _, d := range ds {
+				if res, ok, _ := d.entity(public, "", map[*Catalog]bool{}); ok {
+					return res, true, true
+				}
+			}
+			return "", false, true
+		}
+	}
+	for _, n := range c.next() {
+		if res, ok, done := n.entity(public, system, seen); ok || done {
+			return res, ok, done
+		}
+	}
+	return "", false, false
+}
+
+// ResolveEntity maps the external identifier of an entity or DTD to the
+// URI the catalog gives for it.  Either identifier can be empty.
+func (c *Catalog) ResolveEntity(publicID, systemID string) (string, bool) {
+	if p, ok := unwrap(publicID); ok {
+		publicID = p
+	}
+	if p, ok := unwrap(systemID); ok {
+		if publicID == "" || publicID == p {
+			publicID = p
+		}
+		systemID = ""
+	}
+	res, ok, _ := c.entity(normalizePublic(publicID), normalizeSystem(systemID), map[*Catalog]bool{})
+	return res, ok
+}
+
+// ResolveSystem maps a system identifier to the URI the catalog gives
+// for it.  It is equivalent to:
+//
+//	c.ResolveEntity("", systemID)
+func (c *Catalog) ResolveSystem(systemID string) (string, bool) {
+	return c.ResolveEntity("", systemID)
+}
+
+func (c *Catalog) uri(uri string, seen map[*Catalog]bool) (res string, found, done bool) {
+	if seen[c] {
+		return "", false, false
+	}
+	seen[c] = true
+	if res, ok := c.lookup(uri, "uri", "rewriteURI", "uriSuffix"); ok {
+		return res, true, true
+	}
+	if ds := c.delegates(uri, "delegateURI"); len(ds) > 0 {
+		for _, d := range ds {
+			if res, ok, _ := d.uri(uri, map[*Catalog]bool{}); ok {
+				return res, true, true
+			}
+		}
+		return "", false, true
+	}
+	for _, n := range c.next() {
+		if res, ok, done := n.uri(uri, seen); ok || done {
+			return res, ok, done
+		}
+	}
+	return "", false, false
+}
+
+// ResolveURI maps a URI that is not an entity identifier, such as a
+// schema location or a namespace name, to the URI the catalog gives for
+// it.  urn:publicid: URNs are resolved as public identifiers.
+func (c *Catalog) ResolveURI(uri string) (string, bool) {
+	if p, ok := unwrap(uri); ok {
+		return c.ResolveEntity(p, "")
+	}
+	res, ok, _ := c.uri(normalizeSystem(uri), map[*Catalog]bool{})
+	return res, ok
+}
+
+// Opener returns an Opener that maps locations through the catalog, first
+// as URIs and then as system identifiers, before reading them with open.
+// Locations the catalog does not know about are passed to open as they
+// are.
+func (c *Catalog) Opener(open Opener) Opener {
+	return func(location string) (io.ReadCloser, error) {
+		if res, ok := c.ResolveURI(location); ok {
+			return open(res)
+		}
+		if res, ok := c.ResolveSystem(location); ok {
+			return open(res)
+		}
+		return open(location)
+	}
+}
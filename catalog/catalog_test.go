@@ -0,0 +1,129 @@
+package catalog
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/VictorLowther/simplexml/xinclude"
+)
+
+var files = fstest.MapFS{
+	"catalog.xml": {Data: []byte(`<catalog xmlns="urn:oasis:names:tc:entity:xmlns:xml:catalog" prefer="public">
+  <public publicId="-//Example//DTD  Book//EN" uri="dtd/book.dtd"/>
+  <system systemId="http://example.com/dtd/book.dtd" uri="dtd/book-system.dtd"/>
+  <rewriteSystem systemIdStartString="http://example.com/" uri="ignored" rewritePrefix="mirror/"/>
+  <rewriteSystem systemIdStartString="http://example.com/long/" rewritePrefix="long/"/>
+  <systemSuffix systemIdSuffix="/chapter.dtd" uri="dtd/chapter.dtd"/>
+  <group prefer="system" xml:base="schemas/">
+    <public publicId="-//Example//DTD Hidden//EN" uri="hidden.dtd"/>
+    <uri name="http://example.com/schema/book.xsd" uri="book.xsd"/>
+  </group>
+  <uriSuffix uriSuffix="/common.xsd" uri="schemas/common.xsd"/>
+  <delegatePublic publicIdStartString="-//Other//" catalog="other/catalog.xml"/>
+  <delegateURI uriStartString="http://other.org/" catalog="other/catalog.xml"/>
+  <nextCatalog catalog="next.xml"/>
+  <nextCatalog catalog="missing.xml"/>
+</catalog>`)},
+	"other/catalog.xml": {Data: []byte(`<catalog xmlns="urn:oasis:names:tc:entity:xmlns:xml:catalog">
+  <public publicId="-//Other//DTD Thing//EN" uri="thing.dtd"/>
+  <uri name="http://other.org/a.xml" uri="a.xml"/>
+</catalog>`)},
+	"next.xml": {Data: []byte(`<catalog xmlns="urn:oasis:names:tc:entity:xmlns:xml:catalog">
+  <system systemId="http://elsewhere.net/x.dtd" uri="local/x.dtd"/>
+  <public publicId="-//Other//DTD Unreachable//EN" uri="unreachable.dtd"/>
+  <nextCatalog catalog="catalog.xml"/>
+</catalog>`)},
+	"book.xml":            {Data: []byte(`<book xmlns:xi="http://www.w3.org/2001/XInclude"><xi:include href="http://other.org/a.xml"/></book>`)},
+	"other/a.xml":         {Data: []byte(`<a>from the catalog</a>`)},
+	"schemas/common.xsd":  {Data: []byte(`<schema/>`)},
+	"dtd/book-system.dtd": {Data: []byte(`<!ELEMENT book ANY>`)},
+}
+
+func open(t *testing.T) *Catalog {
+	c, err := Open("catalog.xml", Opener(xinclude.FS(files)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestResolve(t *testing.T) {
+	c := open(t)
+	for _, test := range []struct {
+		kind, public, system string
+		want                 string
+	}{
+		{"entity", "-//Example//DTD Book//EN", "", "dtd/book.dtd"},
+		{"entity", "-//Example//DTD Book//EN", "http://example.com/dtd/book.dtd", "dtd/book-system.dtd"},
+		{"entity", "-//Example//DTD Book//EN", "http://nowhere/book.dtd", "dtd/book.dtd"},
+		{"entity", "", "urn:publicid:-:Example:DTD+Book:EN", "dtd/book.dtd"},
+		{"entity", "-//Example//DTD Hidden//EN", "", "schemas/hidden.dtd"},
+		{"entity", "-//Example//DTD Hidden//EN", "http://nowhere/hidden.dtd", ""},
+		{"entity", "-//Other//DTD Thing//EN", "", "other/thing.dtd"},
+		{"entity", "-//Other//DTD Unreachable//EN", "", ""},
+		{"system", "", "http://example.com/dtd/other.dtd", "mirror/dtd/other.dtd"},
+		{"system", "", "http://example.com/long/a.dtd", "long/a.dtd"},
+		{"system", "", "http://nowhere/x/chapter.dtd", "dtd/chapter.dtd"},
+		{"system", "", "http://elsewhere.net/x.dtd", "local/x.dtd"},
+		{"system", "", "http://nowhere/at/all.dtd", ""},
+		{"uri", "", "http://example.com/schema/book.xsd", "schemas/book.xsd"},
+		{"uri", "", "http://anywhere/common.xsd", "schemas/common.xsd"},
+		{"uri", "", "http://other.org/a.xml", "other/a.xml"},
+		{"uri", "", "http://other.org/b.xml", ""},
+		{"uri", "", "urn:publicid:-:Example:DTD+Book:EN", "dtd/book.dtd"},
+	} {
+		var got string
+		var ok bool
+		switch test.kind {
+		case "entity":
+			got, ok = c.ResolveEntity(test.public, test.system)
+		case "system":
+			got, ok = c.ResolveSystem(test.system)
+		case "uri":
+			got, ok = c.ResolveURI(test.system)
+		}
+		if got != test.want || ok != (test.want != "") {
+			t.Errorf("%s %q %q: got %q %v, want %q", test.kind, test.public, test.system, got, ok, test.want)
+		}
+	}
+}
+
+func TestOpener(t *testing.T) {
+	c := open(t)
+	resolver := xinclude.Resolver(c.Opener(Opener(xinclude.FS(files))))
+	r, err := resolver("http://example.com/dtd/book.dtd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(r)
+	r.Close()
+	if string(b) != `<!ELEMENT book ANY>` {
+		t.Errorf("read %q", b)
+	}
+	f, _ := files.Open("book.xml")
+	defer f.Close()
+	doc, err := xinclude.Parse(f, "book.xml", resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(doc.Root().String(), "from the catalog") {
+		t.Errorf("include was not resolved through the catalog: %s", doc.Root())
+	}
+	if _, err := resolver("http://nowhere/at/all.dtd"); err == nil {
+		t.Errorf("expected unknown locations to be passed through and fail")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, src := range []string{
+		`<notcatalog/>`,
+		`<catalog xmlns="urn:oasis:names:tc:entity:xmlns:xml:catalog"><system uri="x"/></catalog>`,
+		`<catalog xmlns="urn:oasis:names:tc:entity:xmlns:xml:catalog"><public publicId="x"/></catalog>`,
+	} {
+		if _, err := Parse(strings.NewReader(src), "c.xml", nil); err == nil {
+			t.Errorf("expected an error for %s", src)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+var diffCommand = &command{
+	name:    "diff",
+	summary: "report how two documents differ",
+	run:     runDiff,
+}
+
+func runDiff(c *cli, args []string) int {
+	fs := c.flags("diff", "[-unordered [-key attrs]] old new")
+	unordered := fs.Bool("unordered", false, "ignore the order of child elements")
+	key := fs.String("key", "", "match up children with -unordered by the comma-separated `attrs`, such as id")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	docs := [2]*dom.Document{}
+	for i, name := range fs.Args() {
+		doc, err := c.parse(name)
+		if err == nil && doc.Root() == nil {
+			err = dom.ErrNoRootElement
+		}
+		if err != nil {
+			c.report(name, err)
+			return 2
+		}
+		docs[i] = doc
+	}
+	opts := dom.CompareOptions{Unordered: *unordered}
+	if *key != "" {
+		opts.Key = dom.KeyAttrs(strings.Split(*key, ",")...)
+	}
+	changes := dom.CompareWith(docs[0].Root(), docs[1].Root(), opts)
+	for _, ch := range changes {
+		fmt.Fprintln(c.stdout, ch)
+	}
+	if len(changes) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+var fmtCommand = &command{
+	name:    "fmt",
+	summary: "reformat files with the dom package's pretty printer",
+	run:     runFmt,
+}
+
+// sortAttrs sorts the attributes of every element in the tree rooted at
+// e by namespace and name.
+func sortAttrs(e *dom.Element) {
+	for _, n := range e.All() {
+		sort.SliceStable(n.Attributes, func(i, j int) bool {
+			a, b := n.Attributes[i].Name, n.Attributes[j].Name
+			if a.Space != b.Space {
+				return a.Space < b.Space
+			}
+			return a.Local < b.Local
+		})
+	}
+}
+
+// format returns doc as fmt would write it.
+func format(doc *dom.Document, indent string, decl bool) ([]byte, error) {
+	opts := []dom.Option{dom.WithStrict()}
+	if indent != "" {
+		opts = append(opts, dom.WithIndent(indent))
+	}
+	if !decl {
+		if doc.Root() == nil {
+			return nil, nil
+		}
+		return doc.Root().BytesWith(opts...)
+	}
+	out, err := doc.BytesWith(opts...)
+	if err == nil && indent == "" {
+		out = append(out, '\n')
+	}
+	return out, err
+}
+
+// lost returns what formatting src, which parsed as doc, into out loses,
+// or "" if it loses nothing but whitespace.  Comments and processing
+// instructions inside the root element are dropped by the parser, and so
+// is all but the last run of text of elements with mixed content.
+func lost(src, out []byte, doc *dom.Document) (string, error) {
+	d := xml.NewDecoder(bytes.NewReader(src))
+	d.Strict = true
+	d.CharsetReader = func(_ string, r io.Reader) (io.Reader, error) { return r, nil }
+	// texts counts the runs of text in each open element, and children
+	// whether it has child elements.
+	type open struct {
+		name     string
+		texts    int
+		children bool
+	}
+	stack := []open{}
+	for {
+		line, _ := d.InputPos()
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if len(stack) > 0 {
+				stack[len(stack)-1].children = true
+			}
+			stack = append(stack, open{name: t.Name.Local})
+		case xml.EndElement:
+			e := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if e.texts > 1 || (e.texts > 0 && e.children) {
+				return fmt.Sprintf("the mixed content of the %s element on line %d", e.name, line), nil
+			}
+		case xml.CharData:
+			if len(stack) > 0 && len(bytes.TrimSpace(t)) > 0 {
+				stack[len(stack)-1].texts++
+			}
+		case xml.Comment:
+			if len(stack) > 0 {
+				return fmt.Sprintf("the comment on line %d", line), nil
+			}
+		case xml.ProcInst:
+			if len(stack) > 0 {
+				return fmt.Sprintf("the processing instruction on line %d", line), nil
+			}
+		}
+	}
+	again, err := dom.Parse(bytes.NewReader(out))
+	if err != nil {
+		return "", err
+	}
+	if (doc.Root() == nil) != (again.Root() == nil) {
+		return "the root element", nil
+	}
+	if doc.Root() != nil {
+		if changes := dom.Compare(doc.Root(), again.Root()); len(changes) > 0 {
+			return changes[0].String(), nil
+		}
+	}
+	return "", nil
+}
+
+func runFmt(c *cli, args []string) int {
+	fs := c.flags("fmt", "[-w | -l] [-indent s] [-sort] [-decl=false] [files]")
+	write := fs.Bool("w", false, "write the result back to the files instead of to standard output")
+	list := fs.Bool("l", false, "list the files whose formatting differs instead of printing them, and exit with status 1 if there are any")
+	indent := fs.String("indent", "  ", "indentation for each level of nesting, or \"\" to write everything on one line")
+	sorted := fs.Bool("sort", false, "sort attributes by name")
+	decl := fs.Bool("decl", true, "write the XML declaration and the DOCTYPE")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	status := 0
+	for _, name := range files(fs.Args()) {
+		if *write && name == "-" {
+			fmt.Fprintf(c.stderr, "simplexml fmt: cannot use -w with standard input\n")
+			return 2
+		}
+		src, err := c.read(name)
+		if err != nil {
+			c.report(name, err)
+			status = 2
+			continue
+		}
+		doc, err := dom.Parse(bytes.NewReader(src))
+		if err == nil {
+			if *sorted && doc.Root() != nil {
+				sortAttrs(doc.Root())
+			}
+			var out []byte
+			if out, err = format(doc, *indent, *decl); err == nil {
+				switch {
+				case *list:
+					if !bytes.Equal(src, out) {
+						fmt.Fprintln(c.stdout, name)
+						if status == 0 {
+							status = 1
+						}
+					}
+				case *write:
+					if bytes.Equal(src, out) {
+						break
+					}
+					var loss string
+					if loss, err = lost(src, out, doc); err == nil && loss != "" {
+						err = errors.New("not rewritten, since formatting would lose " + loss)
+					}
+					if err == nil {
+						err = writeFile(name, out)
+					}
+				default:
+					_, err = c.stdout.Write(out)
+				}
+			}
+		}
+		if err != nil {
+			c.report(name, err)
+			status = 2
+		}
+	}
+	return status
+}
+
+// writeFile replaces the contents of the named file, keeping its
+// permissions.
+func writeFile(name string, data []byte) error {
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, data, info.Mode().Perm())
+}
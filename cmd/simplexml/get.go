@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/xpath"
+)
+
+var getCommand = &command{
+	name:    "get",
+	summary: "print what an XPath expression selects",
+	run:     runGet,
+}
+
+// namespaces is a flag.Value collecting prefix=uri bindings.
+type namespaces struct {
+	env *xpath.Env
+}
+
+func (ns namespaces) String() string {
+	return ""
+}
+
+func (ns namespaces) Set(s string) error {
+	prefix, uri, ok := strings.Cut(s, "=")
+	if !ok || prefix == "" {
+		return fmt.Errorf("%q is not prefix=uri", s)
+	}
+	ns.env.Namespace(prefix, uri)
+	return nil
+}
+
+// show returns what get prints for n.
+func show(n xpath.Node, raw bool) (string, error) {
+	var e *dom.Element
+	switch n.Type() {
+	case xpath.ElementNode, xpath.RootNode:
+		if raw || n.Element() == nil {
+			return n.Value() + "\n", nil
+		}
+		e = n.Element()
+	default:
+		return n.Value() + "\n", nil
+	}
+	// Print the subtree with the prefixes its ancestors declared for it,
+	// rather than the ones the Encoder would make up.
+	cp := e.Export().Import()
+	for a := e.Parent(); a != nil; a = a.Parent() {
+		for _, attr := range a.Attributes {
+			if attr.Name.Space == "xmlns" && len(cp.GetAttr(attr.Name.Local, "xmlns", "*")) == 0 {
+				cp.AddAttr(attr)
+			}
+		}
+	}
+	return cp.StringWith(dom.WithIndent("  "))
+}
+
+func runGet(c *cli, args []string) int {
+	fs := c.flags("get", "[-r] [-ns prefix=uri]... expr [files]")
+	raw := fs.Bool("r", false, "print the text of the elements selected, instead of their XML")
+	env := xpath.NewEnv()
+	fs.Var(namespaces{env}, "ns", "bind prefix to the namespace uri in expressions, which can be repeated")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	expr, err := xpath.Compile(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(c.stderr, "simplexml get: %v\n", err)
+		return 2
+	}
+	// Like grep, get fails if nothing was found.
+	status := 1
+	for _, name := range files(fs.Args()[1:]) {
+		doc, err := c.parse(name)
+		if err != nil {
+			c.report(name, err)
+			status = 2
+			continue
+		}
+		v, err := expr.EvaluateDocument(doc, env)
+		if err != nil {
+			c.report(name, err)
+			status = 2
+			continue
+		}
+		nodes, ok := v.(xpath.NodeSet)
+		if !ok {
+			fmt.Fprintln(c.stdout, xpath.String(v))
+			if status == 1 {
+				status = 0
+			}
+			continue
+		}
+		for _, n := range nodes {
+			out, err := show(n, *raw)
+			if err != nil {
+				c.report(name, err)
+				status = 2
+				break
+			}
+			fmt.Fprint(c.stdout, out)
+			if status == 1 {
+				status = 0
+			}
+		}
+	}
+	return status
+}
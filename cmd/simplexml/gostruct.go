@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/schema"
+)
+
+var gostructCommand = &command{
+	name:    "gostruct",
+	summary: "write Go structs for the documents a schema, or sample documents, describe",
+	run:     runGostruct,
+}
+
+func runGostruct(c *cli, args []string) int {
+	fs := c.flags("gostruct", "[-pkg name] [-xsd file | files]")
+	pkg := fs.String("pkg", "main", "the `name` of the package the structs are in")
+	xsd := fs.String("xsd", "", "write structs for the XML Schema in `file`, instead of one inferred from the files")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	var s *schema.Schema
+	if *xsd != "" {
+		if fs.NArg() > 0 {
+			fmt.Fprintf(c.stderr, "simplexml gostruct: cannot use both -xsd and sample files\n")
+			return 2
+		}
+		f, err := os.Open(*xsd)
+		if err != nil {
+			fmt.Fprintf(c.stderr, "simplexml gostruct: %v\n", err)
+			return 2
+		}
+		defer f.Close()
+		if s, err = schema.Parse(f); err != nil {
+			c.report(*xsd, err)
+			return 2
+		}
+	} else {
+		docs := []*dom.Document{}
+		for _, name := range files(fs.Args()) {
+			doc, err := c.parse(name)
+			if err != nil {
+				c.report(name, err)
+				return 2
+			}
+			docs = append(docs, doc)
+		}
+		xsd, err := schema.Infer(docs...)
+		if err == nil {
+			s, err = schema.Load(xsd)
+		}
+		if err != nil {
+			fmt.Fprintf(c.stderr, "simplexml gostruct: %v\n", err)
+			return 1
+		}
+	}
+	src, err := s.GoStructs(*pkg)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "simplexml gostruct: %v\n", err)
+		return 1
+	}
+	c.stdout.Write(src)
+	return 0
+}
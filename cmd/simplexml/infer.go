@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/schema"
+)
+
+var inferCommand = &command{
+	name:    "infer",
+	summary: "draft an XML Schema that sample documents are valid against",
+	run:     runInfer,
+}
+
+func runInfer(c *cli, args []string) int {
+	fs := c.flags("infer", "[files]")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	docs := []*dom.Document{}
+	for _, name := range files(fs.Args()) {
+		doc, err := c.parse(name)
+		if err != nil {
+			c.report(name, err)
+			return 2
+		}
+		docs = append(docs, doc)
+	}
+	xsd, err := schema.Infer(docs...)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "simplexml infer: %v\n", err)
+		return 1
+	}
+	out, err := xsd.BytesWith(dom.WithIndent("  "))
+	if err != nil {
+		fmt.Fprintf(c.stderr, "simplexml infer: %v\n", err)
+		return 1
+	}
+	c.stdout.Write(out)
+	return 0
+}
@@ -0,0 +1,135 @@
+// Command simplexml works with XML files using the same packages Go code
+// built on simplexml does, so the results match.
+//
+// Usage:
+//
+//	simplexml <command> [flags] [files]
+//
+// The commands are:
+//
+//	fmt	reformat files with the dom package's pretty printer
+//	get	print what an XPath expression selects
+//	diff	report how two documents differ
+//	validate	check that documents are well-formed, and valid against a schema
+//	infer	draft an XML Schema that sample documents are valid against
+//	gostruct	write Go structs for the documents a schema, or sample documents, describe
+//	profile	report the element paths, attributes and values found in documents
+//
+// Commands read the files they are given, or standard input if there
+// are none or a file is named -.  Run simplexml <command> -h for the
+// flags each command takes.
+//
+// Since the dom package keeps a single piece of text per element, and
+// keeps comments and processing instructions only outside the root
+// element, the commands lose the rest of the text of elements with mixed
+// content, and the comments and processing instructions inside the
+// root.  fmt -w refuses to rewrite files it would lose any of them from.
+//
+// For some basic usage examples, see main_test.go
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+// command is one of the subcommands.
+type command struct {
+	name, summary string
+	run           func(c *cli, args []string) int
+}
+
+var commands = []*command{fmtCommand, getCommand, diffCommand, validateCommand, inferCommand, gostructCommand, profileCommand}
+
+// cli holds what the commands read from and write to.
+type cli struct {
+	stdin          io.Reader
+	stdout, stderr io.Writer
+}
+
+// flags returns a FlagSet for the named command, whose usage message
+// shows args after the flags.
+func (c *cli) flags(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(c.stderr, "usage: simplexml %s %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// read returns the contents of the named file, or of standard input if
+// name is -.
+func (c *cli) read(name string) ([]byte, error) {
+	if name == "-" {
+		return io.ReadAll(c.stdin)
+	}
+	return os.ReadFile(name)
+}
+
+// parse reads and parses the named file.
+func (c *cli) parse(name string) (*dom.Document, error) {
+	src, err := c.read(name)
+	if err != nil {
+		return nil, err
+	}
+	return dom.Parse(bytes.NewReader(src))
+}
+
+// report prints err as a diagnostic about the named file, in the
+// file:line:col: form editors understand when err says where the
+// problem is.
+func (c *cli) report(name string, err error) {
+	if name == "-" {
+		name = "<stdin>"
+	}
+	var derr *dom.Error
+	if errors.As(err, &derr) && derr.Pos.IsValid() {
+		fmt.Fprintf(c.stderr, "%s:%d:%d: %v\n", name, derr.Pos.Line, derr.Pos.Column, derr.Err)
+		return
+	}
+	fmt.Fprintf(c.stderr, "%s: %v\n", name, err)
+}
+
+// files returns the files named in args, or standard input if there are
+// none.
+func files(args []string) []string {
+	if len(args) == 0 {
+		return []string{"-"}
+	}
+	return args
+}
+
+func (c *cli) usage() int {
+	fmt.Fprintf(c.stderr, "usage: simplexml <command> [flags] [files]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(c.stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	return 2
+}
+
+// run runs the command in args, and returns the exit status.
+func (c *cli) run(args []string) int {
+	if len(args) == 0 {
+		return c.usage()
+	}
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(c, args[1:])
+		}
+	}
+	fmt.Fprintf(c.stderr, "simplexml: unknown command %s\n", args[0])
+	return c.usage()
+}
+
+func main() {
+	c := &cli{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr}
+	os.Exit(c.run(os.Args[1:]))
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// simplexml runs the command line in args with stdin as standard input,
+// and returns what it wrote and the exit status.
+func simplexml(stdin string, args ...string) (stdout, stderr string, status int) {
+	var out, errs bytes.Buffer
+	c := &cli{stdin: strings.NewReader(stdin), stdout: &out, stderr: &errs}
+	status = c.run(args)
+	return out.String(), errs.String(), status
+}
+
+// tempFile creates a file holding content, and returns its name.
+func tempFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestUsage(t *testing.T) {
+	if _, errs, status := simplexml("", "frob"); status != 2 || !strings.Contains(errs, "unknown command frob") || !strings.Contains(errs, "fmt") {
+		t.Errorf("unexpected result %d %q", status, errs)
+	}
+	if _, _, status := simplexml(""); status != 2 {
+		t.Errorf("expected a usage error, got %d", status)
+	}
+}
+
+func TestFmt(t *testing.T) {
+	src := "<?xml version=\"1.0\"?>\n<!DOCTYPE a>\n<a z=\"1\" b=\"2\"><b>x</b><c/></a>"
+	out, errs, status := simplexml(src, "fmt", "-sort", "-indent", "\t")
+	expect := "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<!DOCTYPE a>\n<a b=\"2\" z=\"1\">\n\t<b>x</b>\n\t<c/>\n</a>\n"
+	if out != expect || status != 0 {
+		t.Errorf("unexpected output %q, %q", out, errs)
+	}
+	if out, _, _ := simplexml(src, "fmt", "-indent", "", "-decl=false"); out != `<a z="1" b="2"><b>x</b><c/></a>` {
+		t.Errorf("unexpected output %q", out)
+	}
+	if _, errs, status := simplexml("<a>\n<b></a>", "fmt"); status != 2 || !strings.HasPrefix(errs, "<stdin>:2:") {
+		t.Errorf("expected a positioned error, got %d %q", status, errs)
+	}
+
+	formatted := "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<!DOCTYPE a>\n<a z=\"1\" b=\"2\">\n  <b>x</b>\n  <c/>\n</a>\n"
+	messy := tempFile(t, "messy.xml", src)
+	tidy := tempFile(t, "tidy.xml", formatted)
+	if out, _, status := simplexml("", "fmt", "-l", messy, tidy); out != messy+"\n" || status != 1 {
+		t.Errorf("unexpected listing %q %d", out, status)
+	}
+	if _, errs, status := simplexml("", "fmt", "-w", messy); status != 0 {
+		t.Fatalf("unexpected error %q", errs)
+	}
+	b, _ := os.ReadFile(messy)
+	if string(b) != formatted {
+		t.Errorf("unexpected file contents %q", b)
+	}
+	if _, _, status := simplexml("", "fmt", "-w"); status != 2 {
+		t.Errorf("-w should not work on standard input")
+	}
+	// Files that formatting would lose parts of are left alone.
+	for _, lossy := range []string{
+		"<!-- kept --><a>\n<!-- lost -->\n<b/></a>",
+		"<a><?pi lost?></a>",
+		"<a><p>a<b/>c</p></a>",
+	} {
+		name := tempFile(t, "lossy.xml", lossy)
+		if _, errs, status := simplexml("", "fmt", "-w", name); status != 2 || !strings.Contains(errs, "not rewritten") {
+			t.Errorf("%q: unexpected result %q %d", lossy, errs, status)
+		}
+		if b, _ := os.ReadFile(name); string(b) != lossy {
+			t.Errorf("%q was rewritten to %q", lossy, b)
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	src := `<o:order xmlns:o="urn:orders" id="7"><o:line sku="X"><o:qty>2</o:qty></o:line><o:line sku="Y"><o:qty>5</o:qty></o:line></o:order>`
+	checks := []struct {
+		args   []string
+		out    string
+		status int
+	}{
+		{[]string{"/*/@id"}, "7\n", 0},
+		{[]string{"-ns", "o=urn:orders", "//o:line[@sku='Y']"}, "<o:line sku=\"Y\" xmlns:o=\"urn:orders\">\n  <o:qty>5</o:qty>\n</o:line>\n", 0},
+		{[]string{"-r", "-ns", "o=urn:orders", "//o:qty"}, "2\n5\n", 0},
+		{[]string{"sum(//*[local-name()='qty'])"}, "7\n", 0},
+		{[]string{"//missing"}, "", 1},
+	}
+	for _, c := range checks {
+		out, errs, status := simplexml(src, append([]string{"get"}, c.args...)...)
+		if out != c.out || status != c.status {
+			t.Errorf("%v: unexpected result %q %d %q", c.args, out, status, errs)
+		}
+	}
+	if _, errs, status := simplexml(src, "get", "//["); status != 2 || errs == "" {
+		t.Errorf("expected an error for a bad expression, got %d", status)
+	}
+	if _, _, status := simplexml(src, "get", "-ns", "bad", "/"); status != 2 {
+		t.Errorf("expected an error for a bad -ns, got %d", status)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := tempFile(t, "old.xml", `<a x="1"><b>text</b><c/></a>`)
+	same := tempFile(t, "same.xml", "<a x=\"1\">\n  <b>text</b>\n  <c></c>\n</a>")
+	out, errs, status := simplexml(`<a x="2"><b>text</b></a>`, "diff", old, "-")
+	if status != 1 || out != "/a: attribute x changed from \"1\" to \"2\"\n/a/c: element removed\n" {
+		t.Errorf("unexpected result %d %q %q", status, out, errs)
+	}
+	if out, _, status := simplexml("", "diff", old, same); status != 0 || out != "" {
+		t.Errorf("expected no differences, got %d %q", status, out)
+	}
+	shuffled := tempFile(t, "shuffled.xml", `<a x="1"><c/><b>text</b></a>`)
+	if out, _, status := simplexml("", "diff", "-unordered", old, shuffled); status != 0 || out != "" {
+		t.Errorf("expected no unordered differences, got %d %q", status, out)
+	}
+	keyed := tempFile(t, "keyed.xml", `<a><u id="1" n="x"/><u id="2" n="y"/></a>`)
+	if out, _, status := simplexml(`<a><u id="2" n="z"/><u id="1" n="x"/></a>`, "diff", "-unordered", "-key", "id", keyed, "-"); status != 1 || out != "/a/u[2]: attribute n changed from \"y\" to \"z\"\n" {
+		t.Errorf("unexpected keyed differences %d %q", status, out)
+	}
+	if _, _, status := simplexml("", "diff", old); status != 2 {
+		t.Errorf("expected a usage error, got %d", status)
+	}
+	if _, errs, status := simplexml("<a>", "diff", old, "-"); status != 2 || !strings.HasPrefix(errs, "<stdin>:") {
+		t.Errorf("expected a parse error, got %d %q", status, errs)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	xsd := tempFile(t, "a.xsd", `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema">
+  <xs:element name="a">
+    <xs:complexType><xs:sequence><xs:element name="b" type="xs:int"/></xs:sequence></xs:complexType>
+  </xs:element>
+</xs:schema>`)
+	rnc := tempFile(t, "a.rnc", `start = element a { element b { xsd:int } }`)
+	good := tempFile(t, "good.xml", `<a><b>1</b></a>`)
+	bad := tempFile(t, "bad.xml", "<a>\n  <b>one</b>\n</a>")
+	for _, flag := range []string{"-xsd", "-rnc"} {
+		schema := xsd
+		if flag == "-rnc" {
+			schema = rnc
+		}
+		if _, errs, status := simplexml("", "validate", flag, schema, good); status != 0 {
+			t.Errorf("%s: unexpected failure %q", flag, errs)
+		}
+		_, errs, status := simplexml("", "validate", flag, schema, good, bad)
+		if status != 1 || !strings.HasPrefix(errs, bad+":2:3: /a/b: ") || strings.Count(errs, "\n") != 1 {
+			t.Errorf("%s: unexpected result %d %q", flag, status, errs)
+		}
+	}
+	dtd := "<!DOCTYPE a [<!ELEMENT a (b)><!ELEMENT b (#PCDATA)>]>\n"
+	if _, errs, status := simplexml(dtd+"<a><b>x</b></a>", "validate", "-dtd"); status != 0 {
+		t.Errorf("unexpected failure %q", errs)
+	}
+	if _, errs, status := simplexml(dtd+"<a><c/></a>", "validate", "-dtd"); status != 1 || !strings.HasPrefix(errs, "<stdin>:2:") {
+		t.Errorf("unexpected result %d %q", status, errs)
+	}
+	if _, errs, status := simplexml("<a>\n<b></a>", "validate"); status != 1 || !strings.HasPrefix(errs, "<stdin>:2:") {
+		t.Errorf("expected a well-formedness error, got %d %q", status, errs)
+	}
+	if _, _, status := simplexml("", "validate", "-xsd", xsd, "-rnc", rnc, good); status != 2 {
+		t.Errorf("expected a usage error, got %d", status)
+	}
+	if _, _, status := simplexml("", "validate", "-xsd", good+".missing", good); status != 2 {
+		t.Errorf("expected an error for a missing schema, got %d", status)
+	}
+}
+
+func TestInfer(t *testing.T) {
+	a := tempFile(t, "a.xml", `<a id="1"><b>2024-01-02</b><c/></a>`)
+	b := tempFile(t, "b.xml", `<a id="2"><b>2024-03-04</b></a>`)
+	out, errs, status := simplexml("", "infer", a, b)
+	if status != 0 {
+		t.Fatalf("unexpected result %d %q", status, errs)
+	}
+	for _, want := range []string{
+		`<xs:element name="b" type="xs:date"/>`,
+		`<xs:element ref="c" minOccurs="0"/>`,
+		`<xs:attribute name="id" type="xs:integer" use="required"/>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %s in\n%s", want, out)
+		}
+	}
+	xsd := tempFile(t, "a.xsd", out)
+	if _, errs, status := simplexml("", "validate", "-xsd", xsd, a, b); status != 0 {
+		t.Errorf("expected the samples to be valid, got %d %q", status, errs)
+	}
+	if _, _, status := simplexml("<a>", "infer"); status != 2 {
+		t.Errorf("expected a parse error, got %d", status)
+	}
+}
+
+func TestGostruct(t *testing.T) {
+	a := tempFile(t, "a.xml", `<a id="1"><b>x</b><b>y</b></a>`)
+	out, errs, status := simplexml("", "gostruct", "-pkg", "feed", a)
+	if status != 0 || !strings.Contains(out, "package feed\n") || !strings.Contains(out, "\tB       []string `xml:\"b\"`") {
+		t.Errorf("unexpected result %d %q %q", status, out, errs)
+	}
+	xsd := tempFile(t, "a.xsd", `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema">
+  <xs:element name="a"><xs:complexType><xs:attribute name="n" type="xs:boolean"/></xs:complexType></xs:element>
+</xs:schema>`)
+	if out, errs, status := simplexml("", "gostruct", "-xsd", xsd); status != 0 || !strings.Contains(out, "\tN       bool     `xml:\"n,attr,omitempty\"`") {
+		t.Errorf("unexpected result %d %q %q", status, out, errs)
+	}
+	if _, _, status := simplexml("", "gostruct", "-xsd", xsd, a); status != 2 {
+		t.Errorf("expected a usage error, got %d", status)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	a := tempFile(t, "a.xml", `<a><b n="1"/></a>`)
+	out, errs, status := simplexml(`<a><c>x</c></a>`, "profile", a, "-")
+	expect := "/a  count=2 in=2/2 max=1\n  b  count=1 in=1/2 max=1\n    @n  in=1/1 len=1 values=\"1\" (1)\n  c  count=1 in=1/2 max=1\n    text  len=1 values=\"x\" (1)\n"
+	if status != 0 || out != expect {
+		t.Errorf("unexpected result %d %q %q", status, out, errs)
+	}
+	if _, _, status := simplexml("<a>", "profile"); status != 1 {
+		t.Errorf("expected a parse error, got %d", status)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+var profileCommand = &command{
+	name:    "profile",
+	summary: "report the element paths, attributes and values found in documents",
+	run:     runProfile,
+}
+
+func runProfile(c *cli, args []string) int {
+	fs := c.flags("profile", "[files]")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	prof := dom.NewProfile()
+	status := 0
+	for _, name := range files(fs.Args()) {
+		doc, err := c.parse(name)
+		if err != nil {
+			c.report(name, err)
+			status = 1
+			continue
+		}
+		prof.Add(doc)
+	}
+	fmt.Fprint(c.stdout, prof)
+	return status
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/dtd"
+	"github.com/VictorLowther/simplexml/relaxng"
+	"github.com/VictorLowther/simplexml/schema"
+)
+
+var validateCommand = &command{
+	name:    "validate",
+	summary: "check that documents are well-formed, and valid against a schema",
+	run:     runValidate,
+}
+
+// violation is what the validators have in common.
+type violation struct {
+	pos       dom.Position
+	path, msg string
+}
+
+// validator checks a parsed document.
+type validator func(doc *dom.Document) ([]violation, error)
+
+// loadValidator returns the validator for the schema flags that were
+// set, or nil if none were.
+func loadValidator(xsd, rnc string, useDTD bool, dtdFile string) (validator, error) {
+	switch {
+	case xsd != "":
+		f, err := os.Open(xsd)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		s, err := schema.Parse(f)
+		if err != nil {
+			return nil, err
+		}
+		return func(doc *dom.Document) ([]violation, error) {
+			res := []violation{}
+			for _, v := range s.Validate(doc) {
+				res = append(res, violation{v.Pos, v.Path, v.Message})
+			}
+			return res, nil
+		}, nil
+	case rnc != "":
+		f, err := os.Open(rnc)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		s, err := relaxng.ParseCompact(f)
+		if err != nil {
+			return nil, err
+		}
+		return func(doc *dom.Document) ([]violation, error) {
+			res := []violation{}
+			for _, v := range s.Validate(doc) {
+				res = append(res, violation{v.Pos, v.Path, v.Message})
+			}
+			return res, nil
+		}, nil
+	case useDTD || dtdFile != "":
+		var external *dtd.DTD
+		if dtdFile != "" {
+			f, err := os.Open(dtdFile)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			if external, err = dtd.Parse(f); err != nil {
+				return nil, err
+			}
+		}
+		return func(doc *dom.Document) ([]violation, error) {
+			vs, err := dtd.Validate(doc, external)
+			res := []violation{}
+			for _, v := range vs {
+				res = append(res, violation{v.Pos, v.Path, v.Message})
+			}
+			return res, err
+		}, nil
+	}
+	return nil, nil
+}
+
+func runValidate(c *cli, args []string) int {
+	fs := c.flags("validate", "[-xsd file | -rnc file | -dtd | -dtdfile file] [files]")
+	xsd := fs.String("xsd", "", "validate against the XML Schema in `file`")
+	rnc := fs.String("rnc", "", "validate against the RELAX NG compact syntax schema in `file`")
+	useDTD := fs.Bool("dtd", false, "validate against the DTD in each document's DOCTYPE")
+	dtdFile := fs.String("dtdfile", "", "validate against the DTD in `file`, merged with the one in the DOCTYPE if there is one")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	n := 0
+	fs.Visit(func(f *flag.Flag) { n++ })
+	if n > 1 && !(n == 2 && *useDTD && *dtdFile != "") {
+		fmt.Fprintf(c.stderr, "simplexml validate: only one kind of schema can be used at a time\n")
+		return 2
+	}
+	validate, err := loadValidator(*xsd, *rnc, *useDTD, *dtdFile)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "simplexml validate: %v\n", err)
+		return 2
+	}
+	status := 0
+	for _, name := range files(fs.Args()) {
+		doc, err := c.parse(name)
+		if err == nil {
+			err = doc.WellFormed()
+		}
+		if err != nil {
+			c.report(name, err)
+			status = 1
+			continue
+		}
+		if validate == nil {
+			continue
+		}
+		vs, err := validate(doc)
+		if err != nil {
+			c.report(name, err)
+			status = 1
+			continue
+		}
+		if name == "-" {
+			name = "<stdin>"
+		}
+		for _, v := range vs {
+			fmt.Fprintf(c.stderr, "%s:%d:%d: %s: %s\n", name, v.pos.Line, v.pos.Column, v.path, v.msg)
+			status = 1
+		}
+	}
+	return status
+}
@@ -0,0 +1,86 @@
+package dom
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// SetDocumentURI records uri as the URI the tree node is in was read
+// from, as ParseOptions.BaseURI does for parsed trees, and returns node.
+// It is recorded on the topmost element of the tree, so it is lost if
+// that element is added to another tree.
+func (node *Element) SetDocumentURI(uri string) *Element {
+	top := node
+	for top.parent != nil {
+		top = top.parent
+	}
+	top.uri = uri
+	return node
+}
+
+// resolveURI resolves ref against base.  Unlike url.ResolveReference, it
+// keeps relative bases relative, so that the result can still be used
+// as a path, as with an fs.FS.
+func resolveURI(base, ref string) (string, error) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("dom: bad base URI %q: %v", base, err)
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("dom: bad URI %q: %v", ref, err)
+	}
+	if b.Scheme == "" && b.Host == "" && r.Scheme == "" && r.Host == "" && !strings.HasPrefix(r.Path, "/") {
+		if r.Path == "" {
+			return base, nil
+		}
+		res := path.Join(path.Dir(b.Path), r.Path)
+		if strings.HasSuffix(r.Path, "/") {
+			res += "/"
+		}
+		return res, nil
+	}
+	return b.ResolveReference(r).String(), nil
+}
+
+// BaseURI returns the base URI in effect at node: the URI of the
+// document, as recorded by ParseOptions.BaseURI or SetDocumentURI,
+// with the xml:base attributes of node and its ancestors resolved
+// against it in turn.  If neither gives an absolute URI, the result is
+// relative, or "" if there is nothing to go on.
+func (node *Element) BaseURI() (string, error) {
+	bases := []string{}
+	top := node
+	for n := node; n != nil; n = n.parent {
+		for _, a := range n.Attributes {
+			if a.Name.Space == NS_XML && a.Name.Local == "base" {
+				bases = append(bases, a.Value)
+				break
+			}
+		}
+		top = n
+	}
+	base := top.uri
+	var err error
+	for i := len(bases) - 1; i >= 0 && err == nil; i-- {
+		if base == "" {
+			base = bases[i]
+			continue
+		}
+		base, err = resolveURI(base, bases[i])
+	}
+	return base, err
+}
+
+// ResolveURI resolves ref, a URI found in node's Content or attributes,
+// against the base URI in effect at node, as in:
+//    href, err := link.ResolveURI(link.GetAttr("href", "", "*")[0].Value)
+func (node *Element) ResolveURI(ref string) (string, error) {
+	base, err := node.BaseURI()
+	if err != nil || base == "" {
+		return ref, err
+	}
+	return resolveURI(base, ref)
+}
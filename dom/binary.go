@@ -0,0 +1,120 @@
+package dom
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// The binary accessors read and write Content as xs:base64Binary and
+// xs:hexBinary, for documents that embed certificates, images and the
+// like in element text.  Whitespace anywhere in the text, such as the
+// line breaks base64 is often wrapped with, is ignored when reading.
+// Hex is written in upper case, which is the canonical form, and read in
+// either.  The Reader variants decode Content as they are read, and the
+// SetContentReader variants encode straight into Content, so that a
+// large payload is not held in memory twice.
+
+// ContentBytesBase64 decodes the Content of node as base64.
+func (node *Element) ContentBytesBase64() ([]byte, error) {
+	res, err := io.ReadAll(node.ContentReaderBase64())
+	if err != nil {
+		return nil, fmt.Errorf("dom: %s: invalid base64 content: %v", node.Path(), err)
+	}
+	return res, nil
+}
+
+// SetContentBytesBase64 sets the Content of node to b encoded as
+// base64.  The return value is node.
+func (node *Element) SetContentBytesBase64(b []byte) *Element {
+	content := make([]byte, base64.StdEncoding.EncodedLen(len(b)))
+	base64.StdEncoding.Encode(content, b)
+	return node.setContent(content)
+}
+
+// ContentReaderBase64 returns a Reader that decodes the Content of node
+// as base64.  Content must not change while it is being read.
+func (node *Element) ContentReaderBase64() io.Reader {
+	return base64.NewDecoder(base64.StdEncoding, &spaceSkipper{r: bytes.NewReader(node.Content)})
+}
+
+// SetContentReaderBase64 sets the Content of node to everything read
+// from r, encoded as base64.  If reading r fails, Content is left as it
+// was.
+func (node *Element) SetContentReaderBase64(r io.Reader) error {
+	var buf bytes.Buffer
+	w := base64.NewEncoder(base64.StdEncoding, &buf)
+	if _, err := io.Copy(w, r); err != nil {
+		return fmt.Errorf("dom: %s: %v", node.Path(), err)
+	}
+	w.Close()
+	node.setContent(buf.Bytes())
+	return nil
+}
+
+// ContentBytesHex decodes the Content of node as hex.
+func (node *Element) ContentBytesHex() ([]byte, error) {
+	res, err := io.ReadAll(node.ContentReaderHex())
+	if err != nil {
+		return nil, fmt.Errorf("dom: %s: invalid hex content: %v", node.Path(), err)
+	}
+	return res, nil
+}
+
+// SetContentBytesHex sets the Content of node to b encoded as hex.  The
+// return value is node.
+func (node *Element) SetContentBytesHex(b []byte) *Element {
+	content := make([]byte, hex.EncodedLen(len(b)))
+	hex.Encode(content, b)
+	return node.setContent(upperHex(content))
+}
+
+// ContentReaderHex returns a Reader that decodes the Content of node as
+// hex.  Content must not change while it is being read.
+func (node *Element) ContentReaderHex() io.Reader {
+	return hex.NewDecoder(&spaceSkipper{r: bytes.NewReader(node.Content)})
+}
+
+// SetContentReaderHex sets the Content of node to everything read from
+// r, encoded as hex.  If reading r fails, Content is left as it was.
+func (node *Element) SetContentReaderHex(r io.Reader) error {
+	var buf bytes.Buffer
+	if _, err := io.Copy(hex.NewEncoder(&buf), r); err != nil {
+		return fmt.Errorf("dom: %s: %v", node.Path(), err)
+	}
+	node.setContent(upperHex(buf.Bytes()))
+	return nil
+}
+
+// upperHex turns the lower case hex digits in b to upper case, in place.
+func upperHex(b []byte) []byte {
+	for i, c := range b {
+		if c >= 'a' && c <= 'f' {
+			b[i] = c - 'a' + 'A'
+		}
+	}
+	return b
+}
+
+// spaceSkipper reads from r, leaving out XML whitespace.
+type spaceSkipper struct {
+	r io.Reader
+}
+
+func (s *spaceSkipper) Read(p []byte) (int, error) {
+	for {
+		n, err := s.r.Read(p)
+		j := 0
+		for _, c := range p[:n] {
+			if !isTagSpace(c) {
+				p[j] = c
+				j++
+			}
+		}
+		if j > 0 || err != nil {
+			return j, err
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package dom
+
+import (
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"hash/maphash"
+	"sort"
+)
+
+// ChangeKind is the kind of a Change.
+type ChangeKind int
+
+const (
+	// ElementAdded is an element that is only in the new tree.
+	ElementAdded ChangeKind = iota
+	// ElementRemoved is an element that is only in the old tree.
+	ElementRemoved
+	// AttrAdded is an attribute that only the new element has.
+	AttrAdded
+	// AttrRemoved is an attribute that only the old element has.
+	AttrRemoved
+	// AttrChanged is an attribute whose value changed.
+	AttrChanged
+	// ContentChanged is an element whose Content changed.
+	ContentChanged
+)
+
+// Change is one of the differences between two trees, as Compare
+// reports them.
+type Change struct {
+	Kind ChangeKind
+	// Path is the path to the element that changed, in the same format
+	// as Element.Path.  It is the path in the old tree, except for
+	// ElementAdded, where it is the path to the new element in the new
+	// tree.
+	Path string
+	// Name is the name of the attribute, for attribute changes.
+	Name xml.Name
+	// Old and New are the values of the attribute, or the Contents of
+	// the element, before and after the change.
+	Old, New string
+}
+
+func clark(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return "{" + n.Space + "}" + n.Local
+}
+
+func (c Change) String() string {
+	switch c.Kind {
+	case ElementAdded:
+		return c.Path + ": element added"
+	case ElementRemoved:
+		return c.Path + ": element removed"
+	case AttrAdded:
+		return fmt.Sprintf("%s: attribute %s added with value %q", c.Path, clark(c.Name), c.New)
+	case AttrRemoved:
+		return fmt.Sprintf("%s: attribute %s removed, its value was %q", c.Path, clark(c.Name), c.Old)
+	case AttrChanged:
+		return fmt.Sprintf("%s: attribute %s changed from %q to %q", c.Path, clark(c.Name), c.Old, c.New)
+	}
+	return fmt.Sprintf("%s: content changed from %q to %q", c.Path, c.Old, c.New)
+}
+
+// Compare returns the differences between the tree rooted at from and
+// the one rooted at to, in document order.  Trees are compared the way they
+// mean the same thing: namespace prefixes, namespace declarations and
+// the order of attributes do not matter, while names, attribute values,
+// Content and the order of child elements do.  Children are matched up
+// by name, so that inserting or removing an element shows up as just
+// that rather than as changes to all of its following siblings.
+func Compare(from, to *Element) []Change {
+	return CompareWith(from, to, CompareOptions{})
+}
+
+// CompareOptions changes how CompareWith matches up children.
+type CompareOptions struct {
+	// Unordered makes the order of children not matter, for documents
+	// from systems that write elements in no particular order.  Each
+	// child is matched with one in the other tree with the same name and
+	// key, preferring one it is identical to, and otherwise the first
+	// one left in document order.
+	Unordered bool
+	// Key returns what tells an element apart from its siblings of the
+	// same name when Unordered is set, such as the value of an id
+	// attribute, so that elements with the same key are compared with
+	// each other and those whose key changed show up as removed and
+	// added.  If Key is nil, or returns "", elements are matched by name
+	// alone.  KeyAttrs makes a Key from attributes.
+	Key func(e *Element) string
+
+	// sums holds the subtrees hashed so far by sum, with seed.
+	seed maphash.Seed
+	sums map[*Element]uint64
+}
+
+// KeyAttrs returns a CompareOptions.Key that identifies elements by the
+// values of their attributes called names, with no namespace.
+func KeyAttrs(names ...string) func(e *Element) string {
+	return func(e *Element) string {
+		key := ""
+		for _, name := range names {
+			for _, a := range e.Attributes {
+				if a.Name.Space == "" && a.Name.Local == name {
+					key += name + "=" + a.Value + "\x00"
+				}
+			}
+		}
+		return key
+	}
+}
+
+// CompareWith is like Compare, but matches up children as opts says.
+// Changes are still reported in document order: those to the elements
+// in from in its order, followed by the elements only in to in its.
+func CompareWith(from, to *Element, opts CompareOptions) []Change {
+	res := []Change{}
+	if from.Name != to.Name {
+		return append(res, Change{Kind: ElementRemoved, Path: from.Path()}, Change{Kind: ElementAdded, Path: to.Path()})
+	}
+	return opts.compare(from, to, res)
+}
+
+// attrMap returns the attributes of e, without namespace declarations.
+func attrMap(e *Element) map[xml.Name]string {
+	res := map[xml.Name]string{}
+	for _, a := range e.Attributes {
+		if !isXmlnsAttr(a) {
+			res[a.Name] = a.Value
+		}
+	}
+	return res
+}
+
+func sortNames(names []xml.Name) {
+	sort.Slice(names, func(i, j int) bool {
+		if names[i].Space != names[j].Space {
+			return names[i].Space < names[j].Space
+		}
+		return names[i].Local < names[j].Local
+	})
+}
+
+// compare appends the differences between from and to, which have the
+// same name, to res.
+func (opts *CompareOptions) compare(from, to *Element, res []Change) []Change {
+	path := from.Path()
+	oldAttrs, newAttrs := attrMap(from), attrMap(to)
+	names := []xml.Name{}
+	for n := range oldAttrs {
+		names = append(names, n)
+	}
+	for n := range newAttrs {
+		if _, ok := oldAttrs[n]; !ok {
+			names = append(names, n)
+		}
+	}
+	sortNames(names)
+	for _, n := range names {
+		ov, inOld := oldAttrs[n]
+		nv, inNew := newAttrs[n]
+		switch {
+		case !inNew:
+			res = append(res, Change{Kind: AttrRemoved, Path: path, Name: n, Old: ov})
+		case !inOld:
+			res = append(res, Change{Kind: AttrAdded, Path: path, Name: n, New: nv})
+		case ov != nv:
+			res = append(res, Change{Kind: AttrChanged, Path: path, Name: n, Old: ov, New: nv})
+		}
+	}
+	if string(from.Content) != string(to.Content) {
+		res = append(res, Change{Kind: ContentChanged, Path: path, Old: string(from.Content), New: string(to.Content)})
+	}
+
+	// Spilled children are read without being paged in.
+	a, b := from.mustKids(), to.mustKids()
+	if opts.Unordered {
+		return opts.compareUnordered(a, b, res)
+	}
+
+	// Match up the children with a longest common subsequence of their
+	// names.
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i].Name == b[j].Name:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i].Name == b[j].Name:
+			res = opts.compare(a[i], b[j], res)
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			res = append(res, Change{Kind: ElementRemoved, Path: a[i].Path()})
+			i++
+		default:
+			res = append(res, Change{Kind: ElementAdded, Path: b[j].Path()})
+			j++
+		}
+	}
+	return res
+}
+
+// compareUnordered appends the differences between the children a and b
+// to res, matching them up regardless of their order.
+func (opts *CompareOptions) compareUnordered(a, b []*Element, res []Change) []Change {
+	type group struct {
+		name xml.Name
+		key  string
+	}
+	groupOf := func(e *Element) group {
+		g := group{name: e.Name}
+		if opts.Key != nil {
+			g.key = opts.Key(e)
+		}
+		return g
+	}
+	// left holds the children of b that have not been matched yet, by
+	// group, in document order.
+	left := map[group][]*Element{}
+	for _, e := range b {
+		g := groupOf(e)
+		left[g] = append(left[g], e)
+	}
+	match := map[*Element]*Element{}
+	groups := make([]group, len(a))
+	for i, e := range a {
+		groups[i] = groupOf(e)
+	}
+	// Identical elements first, so that moving one of several unkeyed
+	// siblings about does not pair it with a different one.
+	for i, e := range a {
+		cands := left[groups[i]]
+		for j, c := range cands {
+			if opts.sum(e) == opts.sum(c) {
+				match[e] = c
+				left[groups[i]] = append(cands[:j:j], cands[j+1:]...)
+				break
+			}
+		}
+	}
+	for i, e := range a {
+		if cands := left[groups[i]]; match[e] == nil && len(cands) > 0 {
+			match[e] = cands[0]
+			left[groups[i]] = cands[1:]
+		}
+	}
+	matched := map[*Element]bool{}
+	for _, e := range a {
+		c := match[e]
+		if c == nil {
+			res = append(res, Change{Kind: ElementRemoved, Path: e.Path()})
+			continue
+		}
+		matched[c] = true
+		res = opts.compare(e, c, res)
+	}
+	for _, e := range b {
+		if !matched[e] {
+			res = append(res, Change{Kind: ElementAdded, Path: e.Path()})
+		}
+	}
+	return res
+}
+
+// sum returns a hash of the tree rooted at e that is the same for trees
+// compare finds no differences between, so that finding identical
+// children does not take a full comparison of each pair.
+func (opts *CompareOptions) sum(e *Element) uint64 {
+	if s, ok := opts.sums[e]; ok {
+		return s
+	}
+	if opts.sums == nil {
+		opts.seed, opts.sums = maphash.MakeSeed(), map[*Element]uint64{}
+	}
+	var h maphash.Hash
+	h.SetSeed(opts.seed)
+	hashName(&h, e.Name)
+	attrs := attrMap(e)
+	names := make([]xml.Name, 0, len(attrs))
+	for n := range attrs {
+		names = append(names, n)
+	}
+	sortNames(names)
+	for _, n := range names {
+		hashName(&h, n)
+		h.WriteString(attrs[n])
+		h.WriteByte(0)
+	}
+	h.Write(e.Content)
+	h.WriteByte(0)
+	kids := e.mustKids()
+	sums := make([]uint64, len(kids))
+	for i, c := range kids {
+		sums[i] = opts.sum(c)
+	}
+	if opts.Unordered {
+		sort.Slice(sums, func(i, j int) bool { return sums[i] < sums[j] })
+	}
+	var b [8]byte
+	for _, s := range sums {
+		binary.LittleEndian.PutUint64(b[:], s)
+		h.Write(b[:])
+	}
+	s := h.Sum64()
+	opts.sums[e] = s
+	return s
+}
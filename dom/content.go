@@ -0,0 +1,342 @@
+package dom
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The typed accessors read and write Content using the lexical forms of
+// the corresponding XML Schema datatypes, so they interoperate with
+// documents validated against a schema.  Leading and trailing whitespace
+// is ignored when reading.
+
+func (node *Element) text() string {
+	return strings.TrimSpace(string(node.Content))
+}
+
+func (node *Element) setText(s string) *Element {
+	return node.setContent([]byte(s))
+}
+
+// setContent makes content the text of node, in place of what it had,
+// streamed or not.
+func (node *Element) setContent(content []byte) *Element {
+	node.Content = content
+	node.stream = nil
+	node.touch()
+	return node
+}
+
+// Int parses the Content of node as an xs:integer.
+func (node *Element) Int() (int64, error) {
+	res, err := parseInt(node.text())
+	return res, node.contentErr(err)
+}
+
+// SetInt sets the Content of node to i.  The return value is node.
+func (node *Element) SetInt(i int64) *Element {
+	return node.setText(strconv.FormatInt(i, 10))
+}
+
+// Float parses the Content of node as an xs:double, which includes the
+// special values INF, -INF and NaN.
+func (node *Element) Float() (float64, error) {
+	res, err := parseFloat(node.text())
+	return res, node.contentErr(err)
+}
+
+// SetFloat sets the Content of node to f.  The return value is node.
+func (node *Element) SetFloat(f float64) *Element {
+	return node.setText(formatFloat(f))
+}
+
+// Bool parses the Content of node as an xs:boolean, which is one of
+// true, false, 1 and 0.
+func (node *Element) Bool() (bool, error) {
+	res, err := parseBool(node.text())
+	return res, node.contentErr(err)
+}
+
+// SetBool sets the Content of node to b.  The return value is node.
+func (node *Element) SetBool(b bool) *Element {
+	return node.setText(strconv.FormatBool(b))
+}
+
+// Time parses the Content of node with time.Parse.  If layout is empty,
+// time.RFC3339Nano is used, which reads xs:dateTime values with a time
+// zone.
+func (node *Element) Time(layout string) (time.Time, error) {
+	res, err := parseTime(node.text(), layout)
+	return res, node.contentErr(err)
+}
+
+// SetTime sets the Content of node to t formatted with layout, or with
+// time.RFC3339Nano if layout is empty.  The return value is node.
+func (node *Element) SetTime(t time.Time, layout string) *Element {
+	return node.setText(formatTime(t, layout))
+}
+
+func (node *Element) contentErr(err error) error {
+	if err != nil {
+		return fmt.Errorf("dom: %s: %v", node.Path(), err)
+	}
+	return nil
+}
+
+func parseInt(s string) (int64, error) {
+	res, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not an integer", s)
+	}
+	return res, nil
+}
+
+func parseFloat(s string) (float64, error) {
+	switch s {
+	case "INF", "+INF":
+		return math.Inf(1), nil
+	case "-INF":
+		return math.Inf(-1), nil
+	case "NaN":
+		return math.NaN(), nil
+	}
+	// strconv also accepts things like "inf", "0x1p3" and "1_000",
+	// which xs:double does not.
+	if strings.IndexFunc(s, func(r rune) bool {
+		return !strings.ContainsRune("0123456789+-.eE", r)
+	}) == -1 {
+		if res, err := strconv.ParseFloat(s, 64); err == nil {
+			return res, nil
+		}
+	}
+	return 0, fmt.Errorf("%q is not a number", s)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "INF"
+	case math.IsInf(f, -1):
+		return "-INF"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func parseBool(s string) (bool, error) {
+	switch s {
+	case "true", "1":
+		return true, nil
+	case "false", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("%q is not a boolean", s)
+}
+
+func parseTime(s, layout string) (time.Time, error) {
+	if layout == "" {
+		layout = time.RFC3339Nano
+	}
+	return time.Parse(layout, s)
+}
+
+func formatTime(t time.Time, layout string) string {
+	if layout == "" {
+		layout = time.RFC3339Nano
+	}
+	return t.Format(layout)
+}
+
+// The typed attribute accessors read and write attribute values the same
+// way the typed accessors above do Content.  The plain ones fail if the
+// attribute is missing or its value is not valid, and the Default ones
+// return def instead, for optional attributes such as those in
+// configuration files.  The setters add the attribute, or replace its
+// value if it is already there.
+
+// attrValue returns the value of the attribute of node called name in
+// space, with leading and trailing whitespace trimmed.
+func (node *Element) attrValue(name, space string) (string, error) {
+	for _, a := range node.Attributes {
+		if a.Name.Local == name && a.Name.Space == space {
+			return strings.TrimSpace(a.Value), nil
+		}
+	}
+	return "", fmt.Errorf("dom: %s: no attribute %s", node.Path(), name)
+}
+
+func (node *Element) attrErr(name string, err error) error {
+	if err != nil {
+		return fmt.Errorf("dom: %s: attribute %s: %v", node.Path(), name, err)
+	}
+	return nil
+}
+
+// AttrInt parses the attribute of node called name in space as an
+// xs:integer.
+func (node *Element) AttrInt(name, space string) (int64, error) {
+	v, err := node.attrValue(name, space)
+	if err != nil {
+		return 0, err
+	}
+	res, err := parseInt(v)
+	return res, node.attrErr(name, err)
+}
+
+// AttrIntDefault is like AttrInt, but returns def if the attribute is
+// missing or not an integer.
+func (node *Element) AttrIntDefault(name, space string, def int64) int64 {
+	if res, err := node.AttrInt(name, space); err == nil {
+		return res
+	}
+	return def
+}
+
+// SetAttrInt sets the attribute of node called name in space to i.  The
+// return value is node.
+func (node *Element) SetAttrInt(name, space string, i int64) *Element {
+	return node.Attr(name, space, strconv.FormatInt(i, 10))
+}
+
+// AttrFloat parses the attribute of node called name in space as an
+// xs:double.
+func (node *Element) AttrFloat(name, space string) (float64, error) {
+	v, err := node.attrValue(name, space)
+	if err != nil {
+		return 0, err
+	}
+	res, err := parseFloat(v)
+	return res, node.attrErr(name, err)
+}
+
+// AttrFloatDefault is like AttrFloat, but returns def if the attribute
+// is missing or not a number.
+func (node *Element) AttrFloatDefault(name, space string, def float64) float64 {
+	if res, err := node.AttrFloat(name, space); err == nil {
+		return res
+	}
+	return def
+}
+
+// SetAttrFloat sets the attribute of node called name in space to f.
+// The return value is node.
+func (node *Element) SetAttrFloat(name, space string, f float64) *Element {
+	return node.Attr(name, space, formatFloat(f))
+}
+
+// AttrBool parses the attribute of node called name in space as an
+// xs:boolean.
+func (node *Element) AttrBool(name, space string) (bool, error) {
+	v, err := node.attrValue(name, space)
+	if err != nil {
+		return false, err
+	}
+	res, err := parseBool(v)
+	return res, node.attrErr(name, err)
+}
+
+// AttrBoolDefault is like AttrBool, but returns def if the attribute is
+// missing or not a boolean.
+func (node *Element) AttrBoolDefault(name, space string, def bool) bool {
+	if res, err := node.AttrBool(name, space); err == nil {
+		return res
+	}
+	return def
+}
+
+// SetAttrBool sets the attribute of node called name in space to b.  The
+// return value is node.
+func (node *Element) SetAttrBool(name, space string, b bool) *Element {
+	return node.Attr(name, space, strconv.FormatBool(b))
+}
+
+// AttrTime parses the attribute of node called name in space with
+// time.Parse, using time.RFC3339Nano if layout is empty.
+func (node *Element) AttrTime(name, space, layout string) (time.Time, error) {
+	v, err := node.attrValue(name, space)
+	if err != nil {
+		return time.Time{}, err
+	}
+	res, err := parseTime(v, layout)
+	return res, node.attrErr(name, err)
+}
+
+// AttrTimeDefault is like AttrTime, but returns def if the attribute is
+// missing or not a valid time.
+func (node *Element) AttrTimeDefault(name, space, layout string, def time.Time) time.Time {
+	if res, err := node.AttrTime(name, space, layout); err == nil {
+		return res
+	}
+	return def
+}
+
+// SetAttrTime sets the attribute of node called name in space to t
+// formatted with layout, or with time.RFC3339Nano if layout is empty.
+// The return value is node.
+func (node *Element) SetAttrTime(name, space string, t time.Time, layout string) *Element {
+	return node.Attr(name, space, formatTime(t, layout))
+}
+
+// IsNil reports whether node has an xsi:nil attribute that is true,
+// which marks it as an explicit null rather than an empty value.
+func (node *Element) IsNil() bool {
+	for _, a := range node.Attributes {
+		if a.Name.Space == NS_XSI && a.Name.Local == "nil" {
+			v := strings.TrimSpace(a.Value)
+			return v == "true" || v == "1"
+		}
+	}
+	return false
+}
+
+// SetNil marks node as an explicit null with xsi:nil="true", and drops
+// its Content and children, which a nil element must not have.  If no
+// prefix is bound to the XML Schema instance namespace where node is,
+// an xmlns:xsi declaration is added too, so that the attribute comes
+// out as xsi:nil.  SetNil(false) removes the xsi:nil attribute.
+// The return value is node.
+func (node *Element) SetNil(isNil bool) *Element {
+	if !isNil {
+		attrs := node.Attributes[:0]
+		for _, a := range node.Attributes {
+			if a.Name.Space != NS_XSI || a.Name.Local != "nil" {
+				attrs = append(attrs, a)
+			}
+		}
+		if len(attrs) != len(node.Attributes) {
+			node.Attributes = attrs
+			node.touch()
+		}
+		return node
+	}
+	if len(node.children) > 0 || node.Content != nil || node.spill != nil || node.stream != nil {
+		for _, c := range node.children {
+			c.parent = nil
+		}
+		node.children, node.spill = nil, nil
+		node.Content, node.stream = nil, nil
+		// The xsi:nil attribute may be there already, in which case
+		// Attr changes nothing and does not touch node.
+		node.touch()
+	}
+	if _, bound := node.lookupPrefix("xsi"); !bound && !node.declares(NS_XSI) {
+		node.Attr("xsi", "xmlns", NS_XSI)
+	}
+	return node.Attr("nil", NS_XSI, "true")
+}
+
+// declares reports whether a prefix is bound to space where node is.
+func (node *Element) declares(space string) bool {
+	for n := node; n != nil; n = n.parent {
+		for _, a := range n.Attributes {
+			if a.Name.Space == "xmlns" && a.Value == space {
+				return true
+			}
+		}
+	}
+	return false
+}
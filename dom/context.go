@@ -0,0 +1,65 @@
+package dom
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// deadliner is implemented by writers such as net.Conn, whose blocked
+// writes can be interrupted.
+type deadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// EncodeContext encodes this part of the tree to w with an Encoder set up
+// by opts, and flushes it.  ctx is checked before each element is
+// written, so that encoding to a slow peer can be abandoned, in which
+// case the *Error returned wraps ctx.Err().  If w has a SetWriteDeadline
+// method, as network connections do, a write blocked on a stalled peer
+// is interrupted as soon as ctx is done, and the connection should not
+// be used afterwards.
+func (node *Element) EncodeContext(ctx context.Context, w io.Writer, opts ...Option) error {
+	return encodeContext(ctx, w, node.Encode, opts)
+}
+
+// EncodeContext is like Element.EncodeContext for a whole Document.
+func (doc *Document) EncodeContext(ctx context.Context, w io.Writer, opts ...Option) error {
+	return encodeContext(ctx, w, doc.Encode, opts)
+}
+
+func encodeContext(ctx context.Context, w io.Writer, encode func(*Encoder) error, opts []Option) error {
+	if err := ctx.Err(); err != nil {
+		return &Error{Op: "encode", Err: err}
+	}
+	e := NewEncoder(w, opts...)
+	e.ctx = ctx
+	if d, ok := w.(deadliner); ok {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				d.SetWriteDeadline(time.Unix(1, 0))
+			case <-done:
+			}
+		}()
+	}
+	err := encode(e)
+	if err == nil {
+		err = e.Flush()
+	}
+	if err != nil && ctx.Err() != nil {
+		// Whatever went wrong, such as an interrupted write, went
+		// wrong because of ctx.
+		if perr, ok := err.(*Error); ok {
+			perr.Err = ctx.Err()
+			return perr
+		}
+		return &Error{Op: "encode", Err: ctx.Err()}
+	}
+	if _, ok := err.(*Error); err != nil && !ok {
+		err = &Error{Op: "encode", Err: err}
+	}
+	return err
+}
@@ -4,7 +4,9 @@
 // processing.  Specifically:
 //
 // 1. We ignore comments and document processing directives.  They are stripped
-// out as part of document processing.
+// out as part of document processing, except for the DOCTYPE declaration,
+// and comments and processing instructions outside the root element, which
+// are kept on the Document.
 //
 // 2. We do not have seperate Text fields.  Instead, each Element has a single
 // Contents field which holds the contents of the last enclosed text in a tag.
@@ -13,12 +15,117 @@ package dom
 
 import (
 	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
 )
 
 // A Document represents an entire XML document.  Documents hold the root
 // Element.
 type Document struct {
-	root *Element
+	root    *Element
+	doctype string
+	indexed bool
+	index   *Index
+	// prolog and epilog are the comments and processing instructions
+	// before and after the root, and doctypeAt is how many of prolog
+	// came before the DOCTYPE declaration.
+	prolog, epilog []Misc
+	doctypeAt      int
+	decl           Declaration
+	// source is the input the document was parsed from, if KeepSource
+	// was set.
+	source *source
+}
+
+// Declaration holds the pseudo-attributes of the XML declaration at the
+// start of a document, as they were written there.  Fields that were
+// not given are empty.
+type Declaration struct {
+	// Version is 1.1 for XML 1.1 documents, which the parser reads as
+	// XML 1.1: NEL and U+2028 are line ends, and references to control
+	// characters such as &#x1; are allowed.  Documents encoded with a
+	// Version of 1.1 have those characters written as references, as
+	// 1.1 requires.
+	Version string
+	// Encoding is the encoding the source document said it was in.
+	// Documents are always encoded in UTF-8, so that is what the
+	// declaration Encode writes says, whatever Encoding is.
+	Encoding string
+	// Standalone is "yes" or "no".
+	Standalone string
+}
+
+// parseDeclaration reads the pseudo-attributes out of inst, the
+// instruction of an XML declaration.
+func parseDeclaration(inst string) Declaration {
+	res := Declaration{}
+	for {
+		inst = strings.TrimLeft(inst, " \t\r\n")
+		eq := strings.IndexByte(inst, '=')
+		if eq < 0 {
+			return res
+		}
+		name := strings.TrimSpace(inst[:eq])
+		inst = strings.TrimLeft(inst[eq+1:], " \t\r\n")
+		if inst == "" || (inst[0] != '"' && inst[0] != '\'') {
+			return res
+		}
+		end := strings.IndexByte(inst[1:], inst[0])
+		if end < 0 {
+			return res
+		}
+		value := inst[1 : end+1]
+		inst = inst[end+2:]
+		switch name {
+		case "version":
+			res.Version = value
+		case "encoding":
+			res.Encoding = value
+		case "standalone":
+			res.Standalone = value
+		}
+	}
+}
+
+// validVersion reports whether v is an XML version number, such as 1.0.
+func validVersion(v string) bool {
+	if !strings.HasPrefix(v, "1.") || len(v) == 2 {
+		return false
+	}
+	return strings.Trim(v[2:], "0123456789") == ""
+}
+
+// Declaration returns what the XML declaration of doc said, if it was
+// parsed and had one.
+func (doc *Document) Declaration() Declaration {
+	return doc.decl
+}
+
+// SetDeclaration sets the XML declaration Encode writes.  The Version
+// defaults to 1.0, and standalone is only written if it is set.  Encode
+// fails if either is not valid.
+func (doc *Document) SetDeclaration(decl Declaration) {
+	doc.decl = decl
+}
+
+// A Misc is a comment or a processing instruction outside the root
+// element of a Document, such as a license header or an
+// xml-stylesheet instruction.
+type Misc struct {
+	// Target is the target of a processing instruction, and is empty
+	// for a comment.
+	Target string
+	// Text is the text of a comment, or the instruction of a
+	// processing instruction.
+	Text string
+}
+
+func (m Misc) token() xml.Token {
+	if m.Target == "" {
+		return xml.Comment(m.Text)
+	}
+	return xml.ProcInst{Target: m.Target, Inst: []byte(m.Text)}
 }
 
 // CreateDocument creates a new XML document.
@@ -37,20 +144,158 @@ func (doc *Document) SetRoot(node *Element) {
 	doc.root = node
 }
 
+// Doctype returns the text of the document type declaration between the
+// leading "<!" and the closing ">", or an empty string if the document
+// does not have one.
+func (doc *Document) Doctype() string {
+	return doc.doctype
+}
+
+// SetDoctype sets the document type declaration.  The passed string
+// should start with DOCTYPE, as returned by Doctype.
+func (doc *Document) SetDoctype(doctype string) {
+	doc.doctype = doctype
+}
+
+// RemoveAll removes every element of doc that match returns true for, as
+// Element.RemoveAll does, and returns the removed elements in document
+// order.  If the root element matches, doc is left without one.
+func (doc *Document) RemoveAll(match func(*Element) bool) []*Element {
+	if doc.root == nil {
+		return []*Element{}
+	}
+	if match(doc.root) {
+		root := doc.root
+		doc.root = nil
+		doc.index = nil
+		return []*Element{root}
+	}
+	return doc.root.RemoveAll(match)
+}
+
+// Prolog returns the comments and processing instructions that come
+// before the root element, not counting the XML declaration.
+func (doc *Document) Prolog() []Misc {
+	return doc.prolog
+}
+
+// SetProlog sets the comments and processing instructions that come
+// before the root element.  When parsed, the ones that came before the
+// DOCTYPE declaration are written before it; the ones set here are all
+// written after it.
+func (doc *Document) SetProlog(items []Misc) {
+	doc.prolog = items
+	doc.doctypeAt = 0
+}
+
+// Epilog returns the comments and processing instructions that come
+// after the root element.
+func (doc *Document) Epilog() []Misc {
+	return doc.epilog
+}
+
+// SetEpilog sets the comments and processing instructions that come
+// after the root element.
+func (doc *Document) SetEpilog(items []Misc) {
+	doc.epilog = items
+}
+
+// take replaces the contents of doc with those of other.
+func (doc *Document) take(other *Document) {
+	doc.root = other.root
+	doc.doctype = other.doctype
+	doc.prolog, doc.epilog, doc.doctypeAt = other.prolog, other.epilog, other.doctypeAt
+	doc.decl = other.decl
+	doc.source = other.source
+	doc.index = nil
+}
+
+// writeMisc writes items, each on a line of its own when pretty-printing.
+func writeMisc(e *Encoder, items []Misc) error {
+	for _, m := range items {
+		// encoding/xml checks the rest.
+		if m.Target == "" && (strings.Contains(m.Text, "--") || strings.HasSuffix(m.Text, "-")) {
+			return fmt.Errorf("dom: comment %q contains --", m.Text)
+		}
+		enc := xml.NewEncoder(e)
+		if err := enc.EncodeToken(m.token()); err != nil {
+			return err
+		}
+		if err := enc.Flush(); err != nil {
+			return err
+		}
+		if err := e.prettyEnd(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeDeclaration writes the XML declaration decl stands for.  The
+// encoding is always UTF-8, since that is what the Encoder writes.
+func writeDeclaration(e *Encoder, decl Declaration) error {
+	version := decl.Version
+	if version == "" {
+		version = "1.0"
+	}
+	if !validVersion(version) {
+		return fmt.Errorf("dom: invalid XML version %q", version)
+	}
+	e.xml11 = version == "1.1"
+	if sa := decl.Standalone; sa != "" && sa != "yes" && sa != "no" {
+		return fmt.Errorf("dom: standalone must be yes or no, not %q", sa)
+	}
+	res := `<?xml version="` + version + `" encoding="UTF-8"`
+	if decl.Standalone != "" {
+		res += ` standalone="` + decl.Standalone + `"`
+	}
+	if _, err := e.WriteString(res + "?>"); err != nil {
+		return err
+	}
+	return e.prettyEnd()
+}
+
+// encodeProlog writes what comes before the root of doc: the XML
+// declaration, the DOCTYPE declaration and the prolog.
+func (doc *Document) encodeProlog(e *Encoder) error {
+	if err := writeDeclaration(e, doc.decl); err != nil {
+		return err
+	}
+	at := doc.doctypeAt
+	if at > len(doc.prolog) {
+		at = len(doc.prolog)
+	}
+	if err := writeMisc(e, doc.prolog[:at]); err != nil {
+		return err
+	}
+	if doc.doctype != "" {
+		if _, err := e.WriteString("<!" + doc.doctype + ">"); err != nil {
+			return err
+		}
+		if err := e.prettyEnd(); err != nil {
+			return err
+		}
+	}
+	return writeMisc(e, doc.prolog[at:])
+}
+
 // Encode encodes the entire Document using the passed-in Encoder.
 // The output is a well-formed XML document.
 func (doc *Document) Encode(e *Encoder) (err error) {
-	_, err = e.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>")
-	if err != nil {
-		return err
-	}
-	if err = e.prettyEnd(); err != nil {
+	defer func() {
+		if _, ok := err.(*Error); err != nil && !ok {
+			err = &Error{Op: "encode", Err: err}
+		}
+	}()
+	if err = doc.encodeProlog(e); err != nil {
 		return err
 	}
 	if doc.root != nil {
-		return doc.root.Encode(e)
+		if err = doc.root.Encode(e); err != nil {
+			return err
+		}
 	}
-	return nil
+	return writeMisc(e, doc.epilog)
 }
 
 // Bytes encodes a Document into a byte array.  The document will be
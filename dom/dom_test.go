@@ -1,11 +1,40 @@
 package dom
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
 	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
 	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+	"unsafe"
 )
 
 type tc struct {
@@ -71,7 +100,7 @@ var testCases = []tc{
 		creator: func() *Document {
 			doc := CreateDocument()
 			root := Elem("root", "")
-			node1 := ElemC("node1", "","this is a text content")
+			node1 := ElemC("node1", "", "this is a text content")
 			root.AddChild(node1)
 			doc.SetRoot(root)
 			return doc
@@ -95,8 +124,8 @@ var testCases = []tc{
 			return doc
 		},
 		sample: `<?xml version="1.0" encoding="UTF-8"?>
-<root xmlns:ns0="http://schemas.xmlsoap.org/ws/2004/08/addressing">
- <ns0:node1>this is a text content</ns0:node1>
+<root xmlns:ns1="http://schemas.xmlsoap.org/ws/2004/08/addressing">
+ <ns1:node1>this is a text content</ns1:node1>
 </root>
 `,
 	},
@@ -276,4 +305,3735 @@ func TestParseTooManyRootElements(t *testing.T) {
 	if err.Error() != TooManyRootElements {
 		t.Errorf("Expected TooManyRootElements, got %v", err)
 	}
+	if !errors.Is(err, ErrTooManyRootElements) {
+		t.Errorf("Expected ErrTooManyRootElements, got %v", err)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	doc := parseDoc()
+	if doc.Index() != nil {
+		t.Error("Index should be nil until indexing is enabled")
+	}
+	doc.EnableIndex()
+	idx := doc.Index()
+	if res := idx.ByName(xml.Name{Local: "node2"}); len(res) != 3 {
+		t.Errorf("Expected 3 node2 elements, got %d", len(res))
+	}
+	if res := idx.ByLocalName("root"); len(res) != 1 || res[0] != doc.Root() {
+		t.Errorf("Expected to find the root element by local name, got %v", res)
+	}
+	if res := idx.ByAttr(xml.Name{Local: "order"}); len(res) != 3 {
+		t.Errorf("Expected 3 elements with an order attribute, got %d", len(res))
+	}
+	res := idx.ByAttrValue(xml.Name{Local: "order"}, "2")
+	if len(res) != 1 || string(res[0].Content) != "I am Groot" {
+		t.Errorf("Expected to find Groot by attribute value, got %v", res)
+	}
+	// Index order is document order, not breadth order.
+	res = idx.ByAttr(xml.Name{Local: "idx"})
+	if len(res) != 6 || res[2].Name.Local != "sub" {
+		t.Errorf("Expected index to be in document order")
+	}
+	if doc.Index() != idx {
+		t.Error("Index was rebuilt without the tree changing")
+	}
+
+	node1 := doc.Root().Children()[0]
+	node1.AddChild(Elem("new", "").Attr("id", NS_XML, "fresh"))
+	idx = doc.Index()
+	if res := idx.ByName(xml.Name{Local: "new"}); len(res) != 1 {
+		t.Errorf("Index was not rebuilt after adding a child")
+	}
+	if e := idx.ByID("fresh"); e == nil || e.Name.Local != "new" {
+		t.Errorf("Expected to find new element by xml:id, got %v", e)
+	}
+	node1.RemoveChild(idx.ByID("fresh"))
+	if doc.Index().ByID("fresh") != nil {
+		t.Error("Index was not rebuilt after removing a child")
+	}
+	node1.Attr("foo", "", "baz")
+	if res := doc.Index().ByAttrValue(xml.Name{Local: "foo"}, "baz"); len(res) != 1 {
+		t.Error("Index was not rebuilt after replacing an attribute value")
+	}
+	node1.Content = []byte("direct")
+	node1.Name.Local = "renamed"
+	if res := doc.Index().ByLocalName("renamed"); len(res) != 0 {
+		t.Error("Direct field changes should not be noticed without Reindex")
+	}
+	doc.Reindex()
+	if res := doc.Index().ByLocalName("renamed"); len(res) != 1 {
+		t.Error("Reindex did not rebuild the index")
+	}
+	doc.DisableIndex()
+	if doc.Index() != nil {
+		t.Error("Index should be nil after disabling indexing")
+	}
+}
+
+func TestReplaceKeepsAllChildren(t *testing.T) {
+	other := Elem("other", "").AddChildren(Elem("a", ""), Elem("b", ""), Elem("c", ""))
+	node := Elem("node", "").Replace(other)
+	names := []string{}
+	for _, c := range node.Children() {
+		names = append(names, c.Name.Local)
+	}
+	if strings.Join(names, ",") != "a,b,c" {
+		t.Errorf("Expected Replace to move children a,b,c, got %v", names)
+	}
+}
+
+func TestPositions(t *testing.T) {
+	doc := parseDoc()
+	root := doc.Root()
+	if p := root.Pos(); p.Line != 2 || p.Column != 1 || p.Offset != 39 {
+		t.Errorf("Expected root at 2:1 (offset 39), got %v (offset %d)", p, p.Offset)
+	}
+	sub := root.Children()[0].Children()[0]
+	if p := sub.Pos(); p.Line != 4 || p.Column != 3 {
+		t.Errorf("Expected sub at 4:3, got %v", p)
+	}
+	if testDoc[sub.Pos().Offset] != '<' {
+		t.Errorf("Expected offset of sub to point at its start tag")
+	}
+	if p := Elem("new", "").Pos(); p.IsValid() || p.String() != "-" {
+		t.Errorf("Expected created elements to have no position, got %v", p)
+	}
+}
+
+func TestWellFormed(t *testing.T) {
+	if err := parseDoc().WellFormed(); err != nil {
+		t.Errorf("Expected parsed document to be well-formed, got %v", err)
+	}
+	bad := map[string]*Element{
+		"element name":   Elem("1st", ""),
+		"attribute name": Elem("a", "").Attr("b c", "", "x"),
+		"content":        ElemC("a", "", "bell\x07"),
+		"utf-8":          ElemC("a", "", "\xff"),
+		"attribute":      Elem("a", "").Attr("b", "", "\x00"),
+		"nested":         Elem("a", "").AddChild(ElemC("b", "", "\uFFFE")),
+	}
+	dup := Elem("a", "")
+	dup.Attributes = append(dup.Attributes, Attr("b", "", "1"), Attr("b", "", "2"))
+	bad["duplicate"] = dup
+	for what, e := range bad {
+		if err := e.WellFormed(); err == nil {
+			t.Errorf("%s: expected an error", what)
+		}
+	}
+	if err := CreateDocument().WellFormed(); err == nil {
+		t.Errorf("Expected a document with no root to be rejected")
+	}
+	var b bytes.Buffer
+	enc := NewEncoder(&b)
+	enc.Strict()
+	if err := bad["content"].Encode(enc); err == nil {
+		t.Errorf("Expected strict encoding to fail")
+	}
+	enc = NewEncoder(&b)
+	enc.Strict()
+	if err := ElemC("a", "", "fine").Encode(enc); err != nil {
+		t.Errorf("Unexpected strict encoding error %v", err)
+	}
+}
+
+func TestTypedContent(t *testing.T) {
+	if i, err := ElemC("count", "", " 42\n").Int(); err != nil || i != 42 {
+		t.Errorf("Expected 42, got %v, %v", i, err)
+	}
+	if _, err := ElemC("count", "", "4 2").Int(); err == nil {
+		t.Errorf("Expected an error for a bad integer")
+	}
+	for s, want := range map[string]float64{"1.5": 1.5, "-1e3": -1000, "INF": math.Inf(1), "-INF": math.Inf(-1)} {
+		if f, err := ElemC("f", "", s).Float(); err != nil || f != want {
+			t.Errorf("%s: expected %v, got %v, %v", s, want, f, err)
+		}
+	}
+	for _, s := range []string{"inf", "0x10", "1_0", ""} {
+		if _, err := ElemC("f", "", s).Float(); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+	if f, err := ElemC("f", "", "NaN").Float(); err != nil || !math.IsNaN(f) {
+		t.Errorf("Expected NaN, got %v, %v", f, err)
+	}
+	for s, want := range map[string]bool{"true": true, "1": true, "false": false, "0": false} {
+		if b, err := ElemC("b", "", s).Bool(); err != nil || b != want {
+			t.Errorf("%s: expected %v, got %v, %v", s, want, b, err)
+		}
+	}
+	if _, err := ElemC("b", "", "yes").Bool(); err == nil {
+		t.Errorf("Expected an error for a bad boolean")
+	}
+	e := Elem("v", "")
+	if string(e.SetInt(-7).Content) != "-7" ||
+		string(e.SetFloat(0.25).Content) != "0.25" ||
+		string(e.SetFloat(math.Inf(-1)).Content) != "-INF" ||
+		string(e.SetBool(true).Content) != "true" {
+		t.Errorf("Unexpected formatted content %q", e.Content)
+	}
+	when := time.Date(2013, 4, 1, 12, 30, 0, 0, time.UTC)
+	if got, err := e.SetTime(when, "").Time(""); err != nil || !got.Equal(when) {
+		t.Errorf("Expected %v, got %v, %v (content %q)", when, got, err, e.Content)
+	}
+	if got, err := ElemC("d", "", "2013-04-01").Time("2006-01-02"); err != nil || got.Day() != 1 {
+		t.Errorf("Unexpected date %v, %v", got, err)
+	}
+}
+
+func TestTypedAttrs(t *testing.T) {
+	e := Elem("server", "").Attr("port", "", " 8080 ").Attr("ratio", "", "0.5").
+		Attr("tls", "", "1").Attr("since", "", "2013-04-01").Attr("n", "urn:x", "7").Attr("bad", "", "x")
+	if i, err := e.AttrInt("port", ""); err != nil || i != 8080 {
+		t.Errorf("Expected 8080, got %v, %v", i, err)
+	}
+	if f, err := e.AttrFloat("ratio", ""); err != nil || f != 0.5 {
+		t.Errorf("Expected 0.5, got %v, %v", f, err)
+	}
+	if b, err := e.AttrBool("tls", ""); err != nil || !b {
+		t.Errorf("Expected true, got %v, %v", b, err)
+	}
+	if d, err := e.AttrTime("since", "", "2006-01-02"); err != nil || d.Year() != 2013 {
+		t.Errorf("Unexpected time %v, %v", d, err)
+	}
+	if i, err := e.AttrInt("n", "urn:x"); err != nil || i != 7 {
+		t.Errorf("Expected the namespaced attribute, got %v, %v", i, err)
+	}
+	if _, err := e.AttrInt("n", ""); err == nil || err.Error() != "dom: /server: no attribute n" {
+		t.Errorf("Expected a missing attribute error, got %v", err)
+	}
+	if _, err := e.AttrBool("bad", ""); err == nil || err.Error() != `dom: /server: attribute bad: "x" is not a boolean` {
+		t.Errorf("Expected an invalid attribute error, got %v", err)
+	}
+	when := time.Date(2013, 4, 1, 12, 30, 0, 0, time.UTC)
+	if e.AttrIntDefault("missing", "", 3) != 3 || e.AttrIntDefault("bad", "", 4) != 4 || e.AttrIntDefault("port", "", 0) != 8080 ||
+		e.AttrFloatDefault("bad", "", 1.5) != 1.5 || !e.AttrBoolDefault("missing", "", true) ||
+		!e.AttrTimeDefault("bad", "", "", when).Equal(when) {
+		t.Errorf("Unexpected defaults")
+	}
+	v := Elem("v", "")
+	v.SetAttrInt("i", "", -7).SetAttrFloat("f", "", math.Inf(1)).SetAttrBool("b", "", false).SetAttrTime("t", "", when, "")
+	v.SetAttrInt("i", "", 9)
+	if got := v.String(); got != `<v i="9" f="INF" b="false" t="2013-04-01T12:30:00Z"/>`+"\n" {
+		t.Errorf("Unexpected attributes %q", got)
+	}
+	if got, err := v.AttrTime("t", "", ""); err != nil || !got.Equal(when) {
+		t.Errorf("Expected %v, got %v, %v", when, got, err)
+	}
+}
+
+type checkedLine struct {
+	SKU   string  `xml:"sku,attr"`
+	Qty   int     `xml:"qty"`
+	Price float64 `xml:"price"`
+}
+
+type checkedMeta struct {
+	Created time.Time `xml:"created"`
+}
+
+type checkedOrder struct {
+	XMLName xml.Name `xml:"urn:orders order"`
+	checkedMeta
+	ID       int           `xml:"id,attr"`
+	Customer string        `xml:"customer>name"`
+	Lines    []checkedLine `xml:"line"`
+	Note     string        `xml:"note"`
+	Ignored  string        `xml:"-"`
+}
+
+func TestCheckStruct(t *testing.T) {
+	src := `<order xmlns="urn:orders" id="7">
+  <created>2013-04-01T12:00:00Z</created>
+  <customer><name>Bob</name></customer>
+  <line sku="A"><qty>2</qty><price>1.5</price></line>
+  <line sku="B"><qty>1</qty><price>3</price></line>
+  <note>rush</note>
+</order>`
+	check := func(src string) []Mismatch {
+		doc, err := Parse(strings.NewReader(src))
+		if err != nil {
+			t.Fatalf("Cannot parse %s: %v", src, err)
+		}
+		res, err := CheckStruct(doc.Root(), &checkedOrder{})
+		if err != nil {
+			t.Fatalf("CheckStruct failed: %v", err)
+		}
+		return res
+	}
+	if res := check(src); len(res) != 0 {
+		t.Errorf("Expected no mismatches, got %v", res)
+	}
+	tests := []struct {
+		from, to string
+		path     string
+		message  string
+	}{
+		{`id="7"`, `id="seven"`, "/order", `attribute id value "seven" cannot be stored in field ID`},
+		{`id="7"`, `id="7" rush="yes"`, "/order", "attribute rush is not mapped to any field"},
+		{`<note>rush</note>`, `<notes>rush</notes>`, "/order/notes", "element notes is not mapped to any field"},
+		{`<note>rush</note>`, `<note>rush</note><note>now</note>`, "/order", "element note appears 2 times, but field Note holds one value"},
+		{`<qty>2</qty>`, `<qty>two</qty>`, "/order/line[1]/qty", `content "two" cannot be stored in field Qty`},
+		{`<name>Bob</name>`, `<name>Bob</name><id>3</id>`, "/order/customer/id", "element id is not mapped to any field"},
+		{`<price>3</price>`, `<price>3<cents/></price>`, "/order/line[2]/price/cents", "field Price only holds text"},
+		{`<created>2013-04-01T12:00:00Z</created>`, `<created>yesterday</created>`, "/order/created", "cannot be stored in field Created"},
+		{`<order xmlns="urn:orders"`, `<order xmlns="urn:other"`, "/order", "expected element order"},
+		{`<note>rush</note>`, `<note>rush</note>stray`, "/order", `text "stray" is not mapped to any field`},
+	}
+	for _, test := range tests {
+		res := check(strings.Replace(src, test.from, test.to, 1))
+		if len(res) != 1 || res[0].Path != test.path || !strings.Contains(res[0].Message, test.message) {
+			t.Errorf("Replacing %q with %q: expected %s: %s, got %v", test.from, test.to, test.path, test.message, res)
+		}
+	}
+	if _, err := CheckStruct(Elem("a", ""), 3); err == nil {
+		t.Errorf("Expected CheckStruct to need a struct")
+	}
+}
+
+func TestMaps(t *testing.T) {
+	src := `<o:order xmlns:o="urn:orders" id="1"><line>a</line><line>b</line><note>x</note><o:extra kind="k">text</o:extra></o:order>`
+	doc, err := Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &MapOptions{Namespaces: map[string]string{"urn:orders": "o"}, Lists: map[string]bool{"note": true}}
+	m := ToMap(doc.Root(), opts)
+	order, ok := m["o:order"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected an o:order map, got %v", m)
+	}
+	if order["@id"] != "1" || order["o:extra"].(map[string]interface{})["#text"] != "text" {
+		t.Errorf("Unexpected map %v", order)
+	}
+	if lines, ok := order["line"].([]interface{}); !ok || len(lines) != 2 || lines[1] != "b" {
+		t.Errorf("Expected a list of lines, got %v", order["line"])
+	}
+	if notes, ok := order["note"].([]interface{}); !ok || len(notes) != 1 {
+		t.Errorf("Expected note to be a list, got %v", order["note"])
+	}
+	e, err := FromMap(m, opts)
+	if err != nil {
+		t.Fatalf("FromMap failed: %v", err)
+	}
+	if e.Name.Space != "urn:orders" || len(e.Children()) != 4 || len(e.GetAttr("id", "", "1")) != 1 {
+		t.Errorf("Unexpected round trip %v", e)
+	}
+	e, err = FromMap(map[string]interface{}{"a": map[string]interface{}{"$b": 2.5, "_": true, "c": nil}},
+		&MapOptions{AttrPrefix: "$", TextKey: "_"})
+	if err != nil || string(e.Content) != "true" || len(e.GetAttr("b", "", "2.5")) != 1 || len(e.Children()) != 1 {
+		t.Errorf("Unexpected element %v, %v", e, err)
+	}
+	for _, m := range []map[string]interface{}{
+		{},
+		{"a": "1", "b": "2"},
+		{"p:a": "1"},
+		{"a": []interface{}{"1", "2"}},
+		{"a": map[string]interface{}{"@b": []interface{}{}}},
+		{"a": struct{}{}},
+	} {
+		if _, err := FromMap(m, nil); err == nil {
+			t.Errorf("Expected %v to fail", m)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	e := Elem("order", "").Attr("id", "", "1").AddChildren(ElemC("line", "", "a"), ElemC("line", "", "b"))
+	buf, err := ToJSON(e, nil)
+	if err != nil || string(buf) != `{"order":{"@id":"1","line":["a","b"]}}` {
+		t.Errorf("Unexpected JSON %s, %v", buf, err)
+	}
+	back, err := FromJSON([]byte(`{"order":{"@id":1e2,"count":12345678901234567890,"line":["a","b"],"ok":true}}`), nil)
+	if err != nil {
+		t.Fatalf("FromJSON failed: %v", err)
+	}
+	children := back.Children()
+	if len(back.GetAttr("id", "", "1e2")) != 1 || len(children) != 4 ||
+		string(children[0].Content) != "12345678901234567890" || string(children[3].Content) != "true" {
+		t.Errorf("Unexpected element %v", back)
+	}
+	for _, src := range []string{`[]`, `{"a":1} {"b":2}`, `{"a":`} {
+		if _, err := FromJSON([]byte(src), nil); err == nil {
+			t.Errorf("Expected %s to fail", src)
+		}
+	}
+}
+
+func TestYAML(t *testing.T) {
+	e := Elem("order", "").Attr("id", "", "1").AddChildren(ElemC("line", "", "a"), ElemC("line", "", "b"))
+	buf, err := ToYAML(e, nil)
+	if err != nil {
+		t.Fatalf("ToYAML failed: %v", err)
+	}
+	back, err := FromYAML(buf, nil)
+	if err != nil || back.String() != e.String() {
+		t.Errorf("Round trip through\n%s\ngave %v, %v", buf, back, err)
+	}
+	src := `
+order:
+  '@id': 0x10
+  base: &b
+    ok: yes
+  copy: *b
+  line: [a, b]
+  none: ~
+`
+	back, err = FromYAML([]byte(src), nil)
+	if err != nil {
+		t.Fatalf("FromYAML failed: %v", err)
+	}
+	children := back.Children()
+	if len(back.GetAttr("id", "", "0x10")) != 1 || len(children) != 5 ||
+		string(children[1].Children()[0].Content) != "yes" || len(children[4].Content) != 0 {
+		t.Errorf("Unexpected element %v", back)
+	}
+	laughs := "a: &a0 [x, x, x, x, x, x, x, x, x, x]\n"
+	for i := 1; i < 8; i++ {
+		laughs += fmt.Sprintf("a%d: &a%d [*a%d, *a%d, *a%d, *a%d, *a%d, *a%d, *a%d, *a%d, *a%d, *a%d]\n", i, i, i-1, i-1, i-1, i-1, i-1, i-1, i-1, i-1, i-1, i-1)
+	}
+	for _, src := range []string{`- a`, "a: 1\nb: 2", "a: [", "a:\n  b: 1\n  b: 2", "a: &x\n  b: *x\n", "a: &x [1, [*x]]", laughs} {
+		if _, err := FromYAML([]byte(src), nil); err == nil {
+			t.Errorf("Expected %q to fail", src)
+		}
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	src := `<envelope xmlns:o="urn:orders"><body>
+  <o:order id="7">
+    <created>2013-04-01T12:00:00Z</created>
+    <customer><name>Bob</name></customer>
+    <line sku="A"><qty>2</qty><price>1.5</price></line>
+    <line sku="B"><qty>1</qty><price>3</price></line>
+    <note>rush</note>
+  </o:order>
+</body></envelope>`
+	doc, err := Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := doc.Root().Children()[0].Children()[0]
+	var res checkedOrder
+	if err := order.Unmarshal(&res); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if res.ID != 7 || res.Customer != "Bob" || len(res.Lines) != 2 || res.Lines[1].Price != 3 ||
+		res.Note != "rush" || res.Created.Year() != 2013 {
+		t.Errorf("Unexpected result %+v", res)
+	}
+	if err := doc.Root().Unmarshal(&res); err == nil {
+		t.Errorf("Expected unmarshalling the envelope into an order to fail")
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	order := checkedOrder{
+		ID:       7,
+		Customer: "Bob",
+		Lines:    []checkedLine{{SKU: "A", Qty: 2, Price: 1.5}},
+	}
+	order.Created = time.Date(2013, 4, 1, 12, 0, 0, 0, time.UTC)
+	body := Elem("Body", "urn:envelope")
+	child, err := body.AddChildFromStruct(order)
+	if err != nil {
+		t.Fatalf("AddChildFromStruct failed: %v", err)
+	}
+	if child.Parent() != body || child.Name.Space != "urn:orders" || child.Pos().IsValid() {
+		t.Errorf("Unexpected child %v", child)
+	}
+	if len(child.Attributes) != 1 || len(child.GetAttr("id", "", "7")) != 1 {
+		t.Errorf("Expected only the id attribute, got %v", child.Attributes)
+	}
+	// The result survives a round trip through our own encoder.
+	doc, err := Parse(bytes.NewReader(body.Bytes()))
+	if err != nil {
+		t.Fatalf("Cannot parse encoded tree: %v", err)
+	}
+	var back checkedOrder
+	if err := doc.Root().Children()[0].Unmarshal(&back); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if back.ID != 7 || back.Customer != "Bob" || len(back.Lines) != 1 || !back.Created.Equal(order.Created) {
+		t.Errorf("Unexpected round trip %+v", back)
+	}
+	if _, err := Marshal([]checkedLine{{}, {}}); err == nil {
+		t.Errorf("Expected two elements to fail")
+	}
+	if _, err := Marshal(make(chan int)); err == nil {
+		t.Errorf("Expected a channel to fail")
+	}
+}
+
+func TestSQL(t *testing.T) {
+	var (
+		_ sql.Scanner   = &Document{}
+		_ driver.Valuer = &Document{}
+		_ sql.Scanner   = &Element{}
+		_ driver.Valuer = &Element{}
+	)
+	doc := CreateDocument()
+	if err := doc.Scan([]byte(`<a x="1"><b>text</b></a>`)); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	v, err := doc.Value()
+	if err != nil || v != `<?xml version="1.0" encoding="UTF-8"?><a x="1"><b>text</b></a>` {
+		t.Errorf("Unexpected value %q, %v", v, err)
+	}
+	if err := doc.Scan(nil); err != nil || doc.Root() != nil {
+		t.Errorf("Expected NULL to clear the document, got %v", err)
+	}
+	if v, err := doc.Value(); v != nil || err != nil {
+		t.Errorf("Expected a NULL value, got %v, %v", v, err)
+	}
+	if err := doc.Scan(42); err == nil {
+		t.Errorf("Expected an int not to scan")
+	}
+	parent := Elem("p", "")
+	e := Elem("old", "")
+	parent.AddChild(e)
+	if err := e.Scan(`<new y="2"><c/></new>`); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if e.Parent() != parent || e.Name.Local != "new" || e.Children()[0].Parent() != e {
+		t.Errorf("Unexpected element %v", parent)
+	}
+	if v, err := e.Value(); err != nil || v != `<new y="2"><c/></new>` {
+		t.Errorf("Unexpected value %q, %v", v, err)
+	}
+	if err := e.Scan(`<a/><b/>`); err == nil {
+		t.Errorf("Expected two elements not to scan")
+	}
+}
+
+func TestDocumentText(t *testing.T) {
+	var config struct {
+		Name string
+		Doc  *Document
+	}
+	src := `{"Name":"x","Doc":"<a b=\"1\"><c/></a>"}`
+	if err := json.Unmarshal([]byte(src), &config); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if config.Doc == nil || config.Doc.Root().Name.Local != "a" {
+		t.Fatalf("Unexpected document %v", config.Doc)
+	}
+	buf, err := json.Marshal(config)
+	var text map[string]string
+	if err == nil {
+		err = json.Unmarshal(buf, &text)
+	}
+	if err != nil || !strings.HasSuffix(text["Doc"], `<a b="1"><c/></a>`) {
+		t.Errorf("Unexpected JSON %s, %v", buf, err)
+	}
+	if buf, err := json.Marshal(CreateDocument()); err != nil || string(buf) != "null" {
+		t.Errorf("Expected an empty document to be null, got %s, %v", buf, err)
+	}
+	doc := CreateDocument()
+	if err := json.Unmarshal([]byte("null"), doc); err != nil || doc.Root() != nil {
+		t.Errorf("Unexpected result for null: %v", err)
+	}
+	if err := json.Unmarshal([]byte(`"<a>"`), doc); err == nil {
+		t.Errorf("Expected bad XML to fail")
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.TextVar(doc, "doc", CreateDocument(), "a document")
+	if err := fs.Parse([]string{"-doc", "<flag/>"}); err != nil || doc.Root().Name.Local != "flag" {
+		t.Errorf("Unexpected flag result %v, %v", doc, err)
+	}
+	raw, err := doc.MarshalText()
+	if err != nil || !strings.HasSuffix(string(raw), "<flag/>") {
+		t.Errorf("Unexpected text %s, %v", raw, err)
+	}
+}
+
+func TestExport(t *testing.T) {
+	doc := parseDoc()
+	doc.SetDoctype(`DOCTYPE root SYSTEM "root.dtd"`)
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(doc); err != nil {
+		t.Fatalf("Cannot gob-encode document: %v", err)
+	}
+	back := CreateDocument()
+	if err := gob.NewDecoder(&b).Decode(back); err != nil {
+		t.Fatalf("Cannot gob-decode document: %v", err)
+	}
+	if back.String() != doc.String() || back.Doctype() != doc.Doctype() {
+		t.Errorf("Round trip changed the document:\n%s\nvs\n%s", back, doc)
+	}
+	sub := back.Root().Children()[0].Children()[0]
+	if sub.Pos() != doc.Root().Children()[0].Children()[0].Pos() || sub.Parent().Parent() != back.Root() {
+		t.Errorf("Round trip lost positions or parents")
+	}
+	data := doc.Root().Export()
+	data.Children[0].Name.Local = "changed"
+	if doc.Root().Children()[0].Name.Local == "changed" {
+		t.Errorf("Export should copy the tree")
+	}
+	e := Elem("e", "")
+	buf, err := data.Import().MarshalBinary()
+	if err == nil {
+		err = e.UnmarshalBinary(buf)
+	}
+	if err != nil || e.Children()[0].Name.Local != "changed" {
+		t.Errorf("Unexpected element %v, %v", e, err)
+	}
+	if err := e.UnmarshalBinary([]byte("junk")); err == nil {
+		t.Errorf("Expected junk to fail to decode")
+	}
+}
+
+func TestEncodeXMLNamespace(t *testing.T) {
+	e := ElemC("p", "", "hi").Attr("lang", NS_XML, "en")
+	if s := string(e.Bytes()); s != "<p xml:lang=\"en\">hi</p>\n" {
+		t.Errorf("Unexpected encoding %q", s)
+	}
+}
+
+func TestEncodeAttrEscaping(t *testing.T) {
+	e := Elem("p", "").Attr("title", "", "a \"b\" & <c>")
+	doc, err := Parse(bytes.NewReader(e.Bytes()))
+	if err != nil {
+		t.Fatalf("Cannot parse %s: %v", e.Bytes(), err)
+	}
+	if v := doc.Root().Attributes[0].Value; v != "a \"b\" & <c>" {
+		t.Errorf("Unexpected attribute value %q", v)
+	}
+}
+
+func TestEncodePrefixClash(t *testing.T) {
+	// Both elements use the prefix a for different namespaces, which
+	// cannot be declared once on the root.
+	src := `<a:x xmlns:a="urn:one"><a:y xmlns:a="urn:two"/><ns0:z xmlns:ns0="urn:three"/></a:x>`
+	doc, err := Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := Parse(strings.NewReader(doc.String()))
+	if err != nil {
+		t.Fatalf("Cannot parse %s: %v", doc, err)
+	}
+	want := []string{"urn:one", "urn:two", "urn:three"}
+	for i, e := range back.Root().All() {
+		if e.Name.Space != want[i] {
+			t.Errorf("%s is in %s, not %s, in\n%s", e.Name.Local, e.Name.Space, want[i], doc)
+		}
+	}
+}
+
+func TestReplaceChild(t *testing.T) {
+	a, b, c := Elem("a", ""), Elem("b", ""), Elem("c", "")
+	root := Elem("root", "").AddChildren(a, b, c)
+	x, y := Elem("x", ""), Elem("y", "")
+	if res := root.ReplaceChild(b, x, y); res != b || b.Parent() != nil {
+		t.Errorf("Expected b to be replaced and detached")
+	}
+	names := ""
+	for _, e := range root.Children() {
+		names += e.Name.Local
+	}
+	if names != "axyc" || x.Parent() != root {
+		t.Errorf("Unexpected children %s", names)
+	}
+	if root.ReplaceChild(b, Elem("z", "")) != nil || len(root.Children()) != 4 {
+		t.Errorf("Replacing a non-child should do nothing")
+	}
+}
+
+func TestPool(t *testing.T) {
+	pool := NewPool()
+	opts := &ParseOptions{Pool: pool}
+	docs := []string{
+		`<a x="1"><b>text</b><c><d y="2">more</d></c></a>`,
+		`<z><y/><x q="r">t</x></z>`,
+		`<a><b/><c>only</c></a>`,
+	}
+	for i := 0; i < 20; i++ {
+		src := docs[i%len(docs)]
+		doc, err := ParseWithOptions(strings.NewReader(src), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain, _ := Parse(strings.NewReader(src))
+		if doc.Root().String() != plain.Root().String() {
+			t.Fatalf("pooled parse of %s gave:\n%s", src, doc.Root())
+		}
+		for _, e := range doc.Root().All() {
+			if len(e.Content) == 0 && e.Content != nil {
+				t.Fatalf("%s has non-nil empty Content", e.Name.Local)
+			}
+		}
+		pool.ReleaseDocument(doc)
+		if doc.Root() != nil {
+			t.Fatalf("ReleaseDocument left a root")
+		}
+	}
+	root := Elem("root", "")
+	child := ElemC("child", "", "x")
+	root.AddChild(child)
+	pool.Release(child)
+	if len(root.Children()) != 0 || child.Parent() != nil || len(child.Content) != 0 {
+		t.Errorf("Release did not detach and clear the element")
+	}
+}
+
+// benchDoc builds an attribute-light document of n records, the shape
+// RPC payloads tend to have.
+func benchDoc(n int) []byte {
+	var b bytes.Buffer
+	b.WriteString("<response>\n")
+	for i := 0; i < n; i++ {
+		b.WriteString("  <record>\n")
+		b.WriteString("    <id>" + strconv.Itoa(i) + "</id>\n")
+		b.WriteString("    <name>  record " + strconv.Itoa(i) + "  </name>\n")
+		b.WriteString("    <tags><tag>a</tag><tag>b</tag></tags>\n")
+		b.WriteString("    <empty/>\n")
+		b.WriteString("  </record>\n")
+	}
+	b.WriteString("</response>\n")
+	return b.Bytes()
+}
+
+func BenchmarkParse(b *testing.B) {
+	src := benchDoc(1000)
+	b.SetBytes(int64(len(src)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Parse(bytes.NewReader(src)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParsePool(b *testing.B) {
+	src := benchDoc(1000)
+	opts := &ParseOptions{Pool: NewPool()}
+	b.SetBytes(int64(len(src)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		doc, err := ParseWithOptions(bytes.NewReader(src), opts)
+		if err != nil {
+			b.Fatal(err)
+		}
+		opts.Pool.ReleaseDocument(doc)
+	}
+}
+
+func BenchmarkParseAttrs(b *testing.B) {
+	var buf bytes.Buffer
+	buf.WriteString("<items>")
+	for i := 0; i < 1000; i++ {
+		buf.WriteString(`<item id="` + strconv.Itoa(i) + `" kind="x" state="ok" flag="1"/>`)
+	}
+	buf.WriteString("</items>")
+	src := buf.Bytes()
+	b.SetBytes(int64(len(src)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Parse(bytes.NewReader(src)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestInternNames(t *testing.T) {
+	src := `<a:list xmlns:a="urn:a"><a:item a:id="1"/><a:item a:id="2"/></a:list>`
+	doc, err := ParseWithOptions(strings.NewReader(src), &ParseOptions{InternNames: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, _ := Parse(strings.NewReader(src))
+	if doc.Root().String() != plain.Root().String() {
+		t.Fatalf("interned parse gave:\n%s", doc.Root())
+	}
+	items := doc.Root().Children()
+	same := func(a, b string) bool { return unsafe.StringData(a) == unsafe.StringData(b) }
+	if !same(items[0].Name.Local, items[1].Name.Local) ||
+		!same(items[0].Name.Space, doc.Root().Name.Space) ||
+		!same(items[0].Attributes[0].Name.Local, items[1].Attributes[0].Name.Local) {
+		t.Errorf("names were not interned")
+	}
+}
+
+func BenchmarkParseIntern(b *testing.B) {
+	src := benchDoc(1000)
+	opts := &ParseOptions{InternNames: true}
+	b.SetBytes(int64(len(src)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseWithOptions(bytes.NewReader(src), opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestGrow(t *testing.T) {
+	e := Elem("wide", "")
+	if e.children != nil || e.Attributes != nil {
+		t.Errorf("CreateElement allocated slices")
+	}
+	e.AddChild(Elem("first", "")).Attr("a", "", "1")
+	e.Grow(100, 3)
+	if cap(e.children) < 101 || cap(e.Attributes) < 4 {
+		t.Errorf("Grow left capacities %d and %d", cap(e.children), cap(e.Attributes))
+	}
+	children, attrs := &e.children[0], &e.Attributes[0]
+	for i := 0; i < 100; i++ {
+		e.AddChild(Elem("c", ""))
+	}
+	e.Attr("b", "", "2").Attr("c", "", "3").Attr("d", "", "4")
+	if &e.children[0] != children || &e.Attributes[0] != attrs {
+		t.Errorf("adding after Grow reallocated")
+	}
+	if len(e.Children()) != 101 || e.Children()[0].Name.Local != "first" || len(e.Attributes) != 4 {
+		t.Errorf("Grow lost data: %s", e)
+	}
+}
+
+func TestSizeHints(t *testing.T) {
+	src := `<list a="1"><item/><item x="y"/><item/></list>`
+	doc, err := ParseWithOptions(strings.NewReader(src), &ParseOptions{ChildrenHint: 8, AttrsHint: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, _ := Parse(strings.NewReader(src))
+	if doc.Root().String() != plain.Root().String() {
+		t.Fatalf("parse with hints gave:\n%s", doc.Root())
+	}
+	root := doc.Root()
+	if cap(root.children) != 8 || cap(root.Attributes) < 4 || cap(root.children[1].Attributes) < 4 {
+		t.Errorf("hints were not applied")
+	}
+	if root.children[0].children != nil {
+		t.Errorf("leaves should not get room for children")
+	}
+	e := Elem("wide", "").ReserveChildren(50)
+	if cap(e.children) != 50 {
+		t.Errorf("ReserveChildren gave capacity %d", cap(e.children))
+	}
+	e.ReserveChildren(10)
+	if cap(e.children) != 50 {
+		t.Errorf("ReserveChildren shrank the capacity to %d", cap(e.children))
+	}
+}
+
+func bigTree(n int) *Element {
+	root := Elem("export", "urn:export")
+	for i := 0; i < n; i++ {
+		rec := Elem("record", "urn:export").Attr("id", "", strconv.Itoa(i))
+		rec.AddChild(ElemC("name", "", "record <"+strconv.Itoa(i)+">"))
+		if i%7 == 0 {
+			group := Elem("group", "")
+			for j := 0; j < 50; j++ {
+				group.AddChild(ElemC("member", "", strconv.Itoa(j)))
+			}
+			rec.AddChild(group)
+		}
+		root.AddChild(rec)
+	}
+	return Elem("wrapper", "").AddChild(root)
+}
+
+func encodeWith(t testing.TB, e *Element, pretty bool, workers int) string {
+	var b bytes.Buffer
+	enc := NewEncoder(&b)
+	if pretty {
+		enc.Pretty()
+	}
+	if workers > 0 {
+		enc.Parallel(workers)
+	}
+	if err := e.Encode(enc); err != nil {
+		t.Fatal(err)
+	}
+	enc.Flush()
+	return b.String()
+}
+
+func TestParallelEncode(t *testing.T) {
+	for _, n := range []int{3, 2000} {
+		tree := bigTree(n)
+		for _, pretty := range []bool{false, true} {
+			want := encodeWith(t, tree, pretty, 0)
+			for _, workers := range []int{2, 3, 8} {
+				if got := encodeWith(t, tree, pretty, workers); got != want {
+					t.Fatalf("%d records, pretty %v, %d workers: output differs", n, pretty, workers)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	tree := bigTree(20000)
+	for _, workers := range []int{0, 4} {
+		b.Run("workers="+strconv.Itoa(workers), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				encodeWith(b, tree, true, workers)
+			}
+		})
+	}
+}
+
+// sameTree reports where a and b differ, ignoring namespace declarations,
+// which the Encoder moves to the root.
+func sameTree(a, b *Element) string {
+	if a.Name != b.Name {
+		return fmt.Sprintf("name %v != %v", a.Name, b.Name)
+	}
+	if !bytes.Equal(a.Content, b.Content) {
+		return fmt.Sprintf("%s: content %q != %q", a.Path(), a.Content, b.Content)
+	}
+	attrs := func(e *Element) []xml.Attr {
+		res := []xml.Attr{}
+		for _, at := range e.Attributes {
+			if !isXmlnsAttr(at) {
+				res = append(res, at)
+			}
+		}
+		return res
+	}
+	if aa, ba := attrs(a), attrs(b); !reflect.DeepEqual(aa, ba) {
+		return fmt.Sprintf("%s: attributes %v != %v", a.Path(), aa, ba)
+	}
+	if len(a.children) != len(b.children) {
+		return fmt.Sprintf("%s: %d children != %d", a.Path(), len(a.children), len(b.children))
+	}
+	for i := range a.children {
+		if diff := sameTree(a.children[i], b.children[i]); diff != "" {
+			return diff
+		}
+	}
+	return ""
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	for _, tc := range testCases {
+		f.Add(tc.sample)
+	}
+	f.Add(`<a xmlns="urn:a" xmlns:b="urn:b"><b:c b:d="e&amp;f">text &lt; more</b:c><g xml:lang="en"/></a>`)
+	f.Add("<a>\r\n\tline\ttab &#xD; &#x9;</a>")
+	f.Fuzz(func(t *testing.T, src string) {
+		doc, err := Parse(strings.NewReader(src))
+		if err != nil || doc.Root() == nil {
+			return
+		}
+		first := doc.String()
+		if doc.WellFormed() != nil {
+			// The parser lets through some names that cannot be
+			// written back out, which is what Strict mode is for.
+			// Encoding them must still not panic.
+			return
+		}
+		again, err := Parse(strings.NewReader(first))
+		if err != nil {
+			t.Fatalf("cannot parse the encoding of %q: %v\n%s", src, err, first)
+		}
+		if diff := sameTree(doc.Root(), again.Root()); diff != "" {
+			t.Fatalf("round trip of %q changed the tree: %s\n%s", src, diff, first)
+		}
+		if second := again.String(); second != first {
+			t.Fatalf("encoding is not stable for %q:\n%s\n%s", src, first, second)
+		}
+	})
+}
+
+func FuzzEncode(f *testing.F) {
+	f.Add("root", "urn:x", "p", "attr", "a < b & \"c\"", "text ]]> \t\r\n")
+	f.Add("a", "", "xml", "lang", "en", "")
+	f.Add("0bad", "%", "xmlns", "", "\x00", "\xff")
+	f.Fuzz(func(t *testing.T, name, space, prefix, attr, value, content string) {
+		e := Elem(name, space).Attr(prefix, "xmlns", space).Attr(attr, space, value)
+		e.AddChild(ElemC("child", space, content))
+		var b bytes.Buffer
+		enc := NewEncoder(&b)
+		enc.Strict()
+		if err := e.Encode(enc); err != nil {
+			return
+		}
+		enc.Flush()
+		for _, s := range []string{name, prefix, attr} {
+			for _, r := range s {
+				if r >= utf8.RuneSelf {
+					// encoding/xml follows the name rules of
+					// older editions of XML 1.0, which allow
+					// fewer characters than WellFormed does.
+					return
+				}
+			}
+		}
+		doc, err := Parse(&b)
+		if err != nil {
+			t.Fatalf("strict encoding is not well-formed: %v\n%s", err, b.String())
+		}
+		want := e.Export().Import()
+		want.Content = bytes.TrimSpace(want.Content)
+		for _, c := range want.children {
+			c.Content = bytes.TrimSpace(c.Content)
+			if len(c.Content) == 0 {
+				c.Content = nil
+			}
+		}
+		if diff := sameTree(want, doc.Root()); diff != "" {
+			t.Fatalf("round trip changed the tree: %s\n%s", diff, b.String())
+		}
+	})
+}
+
+func TestParseAll(t *testing.T) {
+	const n = 200
+	i := 0
+	next := func() (io.Reader, error) {
+		if i == n {
+			return nil, io.EOF
+		}
+		i++
+		if i%50 == 0 {
+			return strings.NewReader("<broken>"), nil
+		}
+		return strings.NewReader(fmt.Sprintf("<doc n=\"%d\">%s</doc>", i-1, strings.Repeat("x", i%13))), nil
+	}
+	seen := 0
+	err := ParseAll(next, 4, nil, func(idx int, doc *Document, err error) error {
+		if idx != seen {
+			t.Fatalf("got document %d, expected %d", idx, seen)
+		}
+		seen++
+		if (idx+1)%50 == 0 {
+			if err == nil {
+				t.Errorf("document %d should not have parsed", idx)
+			}
+			return nil
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := doc.Root().GetAttr("n", "", "*")[0].Value; got != strconv.Itoa(idx) {
+			t.Errorf("document %d came back as %s", idx, got)
+		}
+		return nil
+	})
+	if err != nil || seen != n {
+		t.Fatalf("ParseAll returned %v after %d documents", err, seen)
+	}
+
+	i = 0
+	stop := errors.New("stop")
+	seen = 0
+	err = ParseAll(next, 3, nil, func(idx int, doc *Document, err error) error {
+		seen++
+		if idx == 10 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || seen != 11 || i > 11+2*3+1 {
+		t.Errorf("stopping gave %v after %d handled and %d read", err, seen, i)
+	}
+
+	bad := errors.New("bad input")
+	err = ParseAll(func() (io.Reader, error) { return nil, bad }, 2, nil, func(int, *Document, error) error { return nil })
+	if err != bad {
+		t.Errorf("expected the error from next, got %v", err)
+	}
+}
+
+func TestParseBytesZeroCopy(t *testing.T) {
+	src := []byte("<?xml version=\"1.0\"?>\n<doc  a='plain' b=\"x &amp; y\">\n  <p>borrowed  text</p>\n  <q lang = \"en\">a &lt; b</q>\n  <r><![CDATA[raw]]></r>\n  <s>first<t/>x &amp; y</s>\n</doc>")
+	orig := append([]byte(nil), src...)
+	doc, err := ParseBytesZeroCopy(src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, _ := Parse(bytes.NewReader(src))
+	if doc.Root().String() != plain.Root().String() {
+		t.Fatalf("zero-copy parse gave:\n%s", doc.Root())
+	}
+	inside := func(b []byte) bool {
+		if len(b) == 0 {
+			return false
+		}
+		start := uintptr(unsafe.Pointer(&src[0]))
+		p := uintptr(unsafe.Pointer(&b[0]))
+		return p >= start && p < start+uintptr(len(src))
+	}
+	root := doc.Root()
+	kids := root.Children()
+	if !inside(kids[0].Content) || inside(kids[1].Content) || inside(kids[2].Content) || inside(kids[3].Content) {
+		t.Errorf("text was borrowed or copied unexpectedly")
+	}
+	if !inside([]byte(root.Attributes[0].Value)) {
+		t.Errorf("attribute a was copied")
+	}
+	if kids[1].Attributes[0].Value != "en" || !inside(unsafe.Slice(unsafe.StringData(kids[1].Attributes[0].Value), 2)) {
+		t.Errorf("attribute lang was not borrowed")
+	}
+	kids[0].Content = append(kids[0].Content, " and more"...)
+	if !bytes.Equal(src, orig) {
+		t.Errorf("appending to borrowed Content changed the source")
+	}
+
+	// Input the CharsetReader changes is copied.
+	latin1 := func(label string, r io.Reader) (io.Reader, error) {
+		b, err := io.ReadAll(r)
+		runes := make([]rune, len(b))
+		for i, c := range b {
+			runes[i] = rune(c)
+		}
+		return strings.NewReader(string(runes)), err
+	}
+	text := strings.Repeat("\xE9", 100)
+	src = []byte("<?xml version='1.0' encoding='ISO-8859-1'?><d a='" + text + "'>" + text + "<e>x</e></d>")
+	for _, opts := range []*ParseOptions{{CharsetReader: latin1}, {CharsetReader: latin1, NormalizeAttrs: true, KeepSource: true}} {
+		doc, err = ParseBytesZeroCopy(src, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		root = doc.Root()
+		if want := strings.Repeat("é", 100); string(root.Content) != want || root.Attributes[0].Value != want || inside(root.Children()[0].Content) {
+			t.Errorf("unexpected tree %s", root)
+		}
+	}
+}
+
+func TestSkip(t *testing.T) {
+	src := `<order><id>42</id><customer><name>Ann</name><address><city>X</city></address></customer>
+<lines><line><sku>a1</sku><qty>2</qty></line><line><sku>b2</sku><qty>1</qty></line></lines><notes><note>n</note></notes></order>`
+	opts := &ParseOptions{Skip: KeepPaths("/order/id", "order/lines/*/sku", "/order/customer/address")}
+	doc, err := ParseWithOptions(strings.NewReader(src), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := Parse(strings.NewReader(`<order><id>42</id><customer><address><city>X</city></address></customer>
+<lines><line><sku>a1</sku></line><line><sku>b2</sku></line></lines></order>`))
+	if doc.Root().String() != want.Root().String() {
+		t.Errorf("unexpected tree:\n%s", doc.Root())
+	}
+	skipped := 0
+	opts = &ParseOptions{Skip: func(path []xml.Name) bool {
+		if path[len(path)-1].Local == "line" {
+			skipped++
+			return true
+		}
+		return false
+	}}
+	doc, err = ParseWithOptions(strings.NewReader(src), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped != 2 || len(doc.Root().Children()[2].Children()) != 0 {
+		t.Errorf("lines were not skipped: %s", doc.Root())
+	}
+	doc, err = ParseWithOptions(strings.NewReader(src), &ParseOptions{Skip: KeepPaths("/other")})
+	if err != nil || doc.Root() != nil {
+		t.Errorf("expected an empty document, got %v %v", doc, err)
+	}
+	if _, err := ParseWithOptions(strings.NewReader(`<a><b><c></b></a>`), &ParseOptions{Skip: KeepPaths("/a/x")}); err == nil {
+		t.Errorf("skipped subtrees must still be checked for syntax errors")
+	}
+}
+
+func TestStats(t *testing.T) {
+	src := `<a xmlns:p="urn:p"><b x="1" y="2">hello</b><c><d p:z="3">hi</d><d/></c></a>`
+	doc, _ := Parse(strings.NewReader(src))
+	s := Stats(doc.Root())
+	if s.Elements != 5 || s.Attributes != 4 || s.TextBytes != 7 || s.MaxDepth != 3 {
+		t.Errorf("unexpected stats %v", s)
+	}
+	if s.HeapBytes < 5*int64(unsafe.Sizeof(Element{})) {
+		t.Errorf("heap estimate %d is too small", s.HeapBytes)
+	}
+	if one := Stats(Elem("x", "")); one.Elements != 1 || one.MaxDepth != 1 || one.Attributes != 0 {
+		t.Errorf("unexpected stats for a lone element: %v", one)
+	}
+	wide := strings.Repeat(`<item name="n"/>`, 100)
+	plain, _ := Parse(strings.NewReader("<l>" + wide + "</l>"))
+	interned, _ := ParseWithOptions(strings.NewReader("<l>"+wide+"</l>"), &ParseOptions{InternNames: true})
+	if Stats(interned.Root()).HeapBytes >= Stats(plain.Root()).HeapBytes {
+		t.Errorf("interned names should be counted once")
+	}
+}
+
+func TestMaxTreeBytes(t *testing.T) {
+	src := "<l>" + strings.Repeat(`<item name="n">some text</item>`, 1000) + "</l>"
+	doc, err := ParseWithOptions(strings.NewReader(src), &ParseOptions{MaxTreeBytes: 1 << 20})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if size := Stats(doc.Root()).HeapBytes; size > 1<<20 {
+		t.Fatalf("tree of %d bytes was let through", size)
+	}
+	_, err = ParseWithOptions(strings.NewReader(src), &ParseOptions{MaxTreeBytes: 10000})
+	var tooLarge *TreeTooLargeError
+	if !errors.As(err, &tooLarge) {
+		t.Fatalf("expected a TreeTooLargeError, got %v", err)
+	}
+	if tooLarge.Limit != 10000 || !tooLarge.Pos.IsValid() {
+		t.Errorf("unexpected error %#v", tooLarge)
+	}
+	if _, err := ParseBytesZeroCopy([]byte(src), &ParseOptions{MaxTreeBytes: 10000}); !errors.As(err, &tooLarge) {
+		t.Errorf("expected a TreeTooLargeError from ParseBytesZeroCopy, got %v", err)
+	}
+}
+
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) { return 0, io.ErrClosedPipe }
+
+func TestError(t *testing.T) {
+	checks := []struct {
+		src, op, path string
+		line          int
+		cause         error
+	}{
+		{"<a>\n<b>\n<c></b></a>", "parse", "/a/b/c", 3, nil},
+		{"<a/><b/>", "parse", "/b", 1, ErrTooManyRootElements},
+		{"<a/>\n<", "parse", "", 2, nil},
+		{"<a><b>" + strings.Repeat("x", 1000) + "</b></a>", "parse", "/a/b", 1, nil},
+	}
+	for _, c := range checks {
+		_, err := ParseWithOptions(strings.NewReader(c.src), &ParseOptions{MaxTreeBytes: 1000})
+		var derr *Error
+		if !errors.As(err, &derr) {
+			t.Errorf("%q: expected an *Error, got %v", c.src, err)
+			continue
+		}
+		if derr.Op != c.op || derr.Path != c.path || derr.Pos.Line != c.line {
+			t.Errorf("%q: unexpected error %q", c.src, err)
+		}
+		if c.cause != nil && !errors.Is(err, c.cause) {
+			t.Errorf("%q: expected %v, got %v", c.src, c.cause, err)
+		}
+	}
+	var syntax *xml.SyntaxError
+	if _, err := Parse(strings.NewReader("<a><b></a>")); !errors.As(err, &syntax) {
+		t.Errorf("syntax errors should be unwrappable, got %v", err)
+	}
+
+	bad, b := Elem("a", ""), Elem("b", "")
+	b.Content = []byte("x\x01")
+	bad.AddChild(b)
+	e := NewEncoder(io.Discard)
+	e.Strict()
+	var derr *Error
+	if err := bad.Encode(e); !errors.As(err, &derr) || derr.Op != "check" || derr.Path != "/a/b" {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := CreateDocument().WellFormed(); !errors.Is(err, ErrNoRootElement) {
+		t.Errorf("expected ErrNoRootElement, got %v", err)
+	}
+	big := bigTree(2000)
+	e = NewEncoder(failWriter{})
+	if err := big.Encode(e); !errors.Is(err, io.ErrClosedPipe) || !errors.As(err, &derr) || derr.Op != "encode" || derr.Path == "" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestParseQName(t *testing.T) {
+	src := `<env:Envelope xmlns:env="urn:env" xmlns="urn:default"><env:Body xmlns:m="urn:m"><m:call xmlns=""/></env:Body></env:Envelope>`
+	doc, _ := Parse(strings.NewReader(src))
+	body := doc.Root().Children()[0]
+	call := body.Children()[0]
+	checks := []struct {
+		qname string
+		scope *Element
+		name  xml.Name
+	}{
+		{"env:Body", doc.Root(), xml.Name{Space: "urn:env", Local: "Body"}},
+		{"m:call", body, xml.Name{Space: "urn:m", Local: "call"}},
+		{"m:call", call, xml.Name{Space: "urn:m", Local: "call"}},
+		{"plain", body, xml.Name{Space: "urn:default", Local: "plain"}},
+		{"plain", call, xml.Name{Local: "plain"}},
+		{"xml:lang", nil, xml.Name{Space: NS_XML, Local: "lang"}},
+		{"plain", nil, xml.Name{Local: "plain"}},
+	}
+	for _, c := range checks {
+		name, err := ParseQName(c.qname, c.scope)
+		if err != nil || name != c.name {
+			t.Errorf("%s: expected %v, got %v, %v", c.qname, c.name, name, err)
+		}
+	}
+	for _, bad := range []string{"m:call", "a:b:c", ":x", "x:", "xmlns:x", "1x"} {
+		if _, err := ParseQName(bad, doc.Root()); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+	gen := doc.Root().gen
+	if call.SetName("urn:other", "reply"); call.Name != (xml.Name{Space: "urn:other", Local: "reply"}) || doc.Root().gen == gen {
+		t.Errorf("SetName did not rename the element")
+	}
+}
+
+func TestSyncDocument(t *testing.T) {
+	doc, _ := Parse(strings.NewReader(`<config><item xml:id="a">1</item></config>`))
+	doc.EnableIndex()
+	s := NewSyncDocument(doc)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			s.Write(func(doc *Document) error {
+				doc.Root().AddChild(Elem("item", "").Attr("id", NS_XML, strconv.Itoa(i)))
+				return nil
+			})
+		}(i)
+		go func() {
+			defer wg.Done()
+			s.Read(func(doc *Document) error {
+				if doc.Index().ByID("a") == nil {
+					t.Errorf("lost the first item")
+				}
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+	err := s.Read(func(doc *Document) error {
+		if n := len(doc.Index().ByLocalName("item")); n != 9 {
+			t.Errorf("expected 9 items, got %d", n)
+		}
+		return io.EOF
+	})
+	if err != io.EOF {
+		t.Errorf("Read should return what f returns, got %v", err)
+	}
+}
+
+func TestChildrenViews(t *testing.T) {
+	doc, _ := Parse(strings.NewReader(`<a><b/><c/></a>`))
+	root := doc.Root()
+	snap := root.Children()
+	if len(snap) != 2 || root.NumChildren() != 2 || root.Child(0) != snap[0] || root.Child(1) != snap[1] {
+		t.Fatalf("views disagree: %v, %d", snap, root.NumChildren())
+	}
+	root.AddChild(Elem("d", ""))
+	root.RemoveChild(snap[0])
+	if len(snap) != 2 || snap[0].Name.Local != "b" {
+		t.Errorf("the snapshot changed with the tree")
+	}
+	if root.NumChildren() != 2 || root.Child(0).Name.Local != "c" || root.Child(1).Name.Local != "d" {
+		t.Errorf("Child does not follow the tree")
+	}
+	snap = root.Children()
+	snap[0] = Elem("x", "")
+	if root.Child(0).Name.Local != "c" {
+		t.Errorf("changing the snapshot changed the tree")
+	}
+	if allocs := testing.AllocsPerRun(10, func() {
+		for i := 0; i < root.NumChildren(); i++ {
+			_ = root.Child(i)
+		}
+	}); allocs != 0 {
+		t.Errorf("Child allocates")
+	}
+}
+
+func TestOptions(t *testing.T) {
+	src := `<a><b x="1">text</b><c/></a>`
+	shared := []Option{WithInternNames(), WithMaxTreeBytes(1 << 20), WithIndent("\t")}
+	doc, err := Parse(strings.NewReader(src), shared...)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	out, err := doc.Root().StringWith(shared...)
+	if err != nil || out != "<a>\n\t<b x=\"1\">text</b>\n\t<c/>\n</a>\n" {
+		t.Errorf("unexpected output %q, %v", out, err)
+	}
+	if out, _ := doc.Root().StringWith(); out != src {
+		t.Errorf("StringWith should not pretty-print by default, got %q", out)
+	}
+	if out, _ := doc.Root().StringWith(WithPretty()); out != doc.Root().String() {
+		t.Errorf("WithPretty should match String, got %q", out)
+	}
+	if _, err := Parse(strings.NewReader(src), WithMaxTreeBytes(10)); err == nil {
+		t.Errorf("WithMaxTreeBytes was ignored")
+	}
+	if _, err := Parse(strings.NewReader(src), WithParseOptions(&ParseOptions{MaxTreeBytes: 10})); err == nil {
+		t.Errorf("WithParseOptions was ignored")
+	}
+	elems, err := ParseElements(strings.NewReader(src+src), WithSkip(KeepPaths("/a/c")))
+	if err != nil || len(elems) != 2 || len(elems[0].Children()) != 1 {
+		t.Errorf("unexpected elements %v, %v", elems, err)
+	}
+	bad := Elem("a", "")
+	bad.Content = []byte("\x01")
+	if _, err := bad.BytesWith(WithStrict()); err == nil {
+		t.Errorf("WithStrict was ignored")
+	}
+	var b bytes.Buffer
+	e := NewEncoder(&b, WithPretty())
+	doc.Encode(e)
+	e.Flush()
+	if b.String() != doc.String() {
+		t.Errorf("NewEncoder ignored WithPretty: %q", b.String())
+	}
+}
+
+// slowWriter cancels its context once enough has been written.
+type slowWriter struct {
+	n      int
+	cancel func()
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	if w.n += len(p); w.n > 8192 {
+		w.cancel()
+	}
+	return len(p), nil
+}
+
+func TestEncodeContext(t *testing.T) {
+	big := bigTree(2000)
+	var b bytes.Buffer
+	if err := big.EncodeContext(context.Background(), &b); err != nil || b.String() != encodeWith(t, big, false, 0) {
+		t.Fatalf("unexpected result %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &slowWriter{cancel: cancel}
+	err := big.EncodeContext(ctx, w)
+	var derr *Error
+	if !errors.Is(err, context.Canceled) || !errors.As(err, &derr) || derr.Path == "" {
+		t.Errorf("expected a cancelled encode, got %v", err)
+	}
+	if w.n > 8192+8192 {
+		t.Errorf("kept writing after being cancelled: %d bytes", w.n)
+	}
+	if err := CreateDocument().EncodeContext(ctx, io.Discard); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a cancelled encode, got %v", err)
+	}
+
+	// A peer that never reads.
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := big.EncodeContext(ctx, conn); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the stalled encode to time out, got %v", err)
+	}
+}
+
+func TestNamespaceOrder(t *testing.T) {
+	root := Elem("root", "urn:z")
+	for _, ns := range []string{"urn:m", "urn:a", "urn:q", "urn:b", "urn:y"} {
+		root.AddChild(Elem("c", ns).Attr("x", ns+":attrs", "1"))
+	}
+	root.Attr("zz", "xmlns", "urn:q").Attr("aa", "xmlns", "urn:y")
+	first := encodeWith(t, root, false, 0)
+	for i := 0; i < 20; i++ {
+		if out := encodeWith(t, root, false, 0); out != first {
+			t.Fatalf("output changed between runs:\n%s\n%s", first, out)
+		}
+	}
+	start := first[:strings.Index(first, ">")]
+	re := regexp.MustCompile(`xmlns:([^=]+)=`)
+	prefixes := []string{}
+	for _, m := range re.FindAllStringSubmatch(start, -1) {
+		prefixes = append(prefixes, m[1])
+	}
+	if len(prefixes) != 11 || !sort.StringsAreSorted(prefixes) {
+		t.Errorf("declarations are not sorted by prefix: %s", start)
+	}
+}
+
+func TestStringLimit(t *testing.T) {
+	doc, _ := Parse(strings.NewReader(`<a><b><c><d/><d/></c></b><e>text</e></a>`))
+	root := doc.Root()
+	if out := root.StringDepth(0); out != root.String() {
+		t.Errorf("StringDepth(0) should not cut anything, got %q", out)
+	}
+	expect := "<a>\n <b>\n  <!-- 3 elements left out -->\n </b>\n <e>text</e>\n</a>\n"
+	if out := root.StringDepth(2); out != expect {
+		t.Errorf("unexpected output %q", out)
+	}
+	if out := root.StringDepth(1); out != "<a>\n <!-- 5 elements left out -->\n</a>\n" {
+		t.Errorf("unexpected output %q", out)
+	}
+	big := bigTree(5000)
+	out := big.StringLimit(0, 100)
+	if len(out) != 103 || !strings.HasSuffix(out, "...") || !strings.HasPrefix(big.String(), out[:100]) {
+		t.Errorf("unexpected output %q", out)
+	}
+	if out := root.StringLimit(0, len(root.String())); out != root.String() {
+		t.Errorf("output that fits should not be cut, got %q", out)
+	}
+}
+
+func TestDump(t *testing.T) {
+	doc, _ := Parse(strings.NewReader("<order xmlns=\"urn:sales\" id=\"1\">\n  <line n=\"1\" qty=\"2\">\n    <sku>ABC-1234</sku>\n  </line>\n</order>"))
+	doc.Root().AddChild(Elem("note", ""))
+	expect := `{urn:sales}order attrs=2 text=0 pos=1:1
+  {urn:sales}line attrs=2 text=0 pos=2:3
+    {urn:sales}sku attrs=0 text=8 pos=3:5
+  note attrs=0 text=0 pos=-
+`
+	if out := doc.Root().Dump(); out != expect {
+		t.Errorf("unexpected dump:\n%s", out)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	from, _ := Parse(strings.NewReader(`<a:order xmlns:a="urn:o" id="1" status="new"><a:line sku="X">2</a:line><a:line sku="Y">1</a:line><a:note/></a:order>`))
+	to, _ := Parse(strings.NewReader(`<order xmlns="urn:o" status="sent" id="1" rush="yes"><line sku="X">3</line><gift/><line sku="Y">1</line></order>`))
+	changes := Compare(from.Root(), to.Root())
+	got := []string{}
+	for _, c := range changes {
+		got = append(got, c.String())
+	}
+	expect := []string{
+		`/order: attribute rush added with value "yes"`,
+		`/order: attribute status changed from "new" to "sent"`,
+		`/order/line[1]: content changed from "2" to "3"`,
+		`/order/gift: element added`,
+		`/order/note: element removed`,
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("unexpected changes:\n%s", strings.Join(got, "\n"))
+	}
+	if c := Compare(from.Root(), from.Root().Export().Import()); len(c) != 0 {
+		t.Errorf("a copy should not differ, got %v", c)
+	}
+	if c := Compare(Elem("a", ""), Elem("b", "")); len(c) != 2 || c[0].Kind != ElementRemoved || c[1].Kind != ElementAdded {
+		t.Errorf("unexpected changes %v", c)
+	}
+}
+
+func TestCompareUnordered(t *testing.T) {
+	from, _ := Parse(strings.NewReader(`<config><user id="a" role="admin"/><user id="b" role="dev"/><flag>x</flag><flag>y</flag><old/></config>`))
+	to, _ := Parse(strings.NewReader(`<config><flag>y</flag><user id="b" role="ops"/><new/><flag>x</flag><user id="a" role="admin"/></config>`))
+	strs := func(changes []Change) []string {
+		res := []string{}
+		for _, c := range changes {
+			res = append(res, c.String())
+		}
+		return res
+	}
+	got := strs(CompareWith(from.Root(), to.Root(), CompareOptions{Unordered: true, Key: KeyAttrs("id")}))
+	expect := []string{
+		`/config/user[2]: attribute role changed from "dev" to "ops"`,
+		`/config/old: element removed`,
+		`/config/new: element added`,
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("unexpected changes:\n%s", strings.Join(got, "\n"))
+	}
+	if n := len(Compare(from.Root(), to.Root())); n <= len(expect) {
+		t.Errorf("expected an ordered comparison to find more changes, got %d", n)
+	}
+	// Without a key, the users are matched in order, and a changed key
+	// shows up as an element removed and one added.
+	got = strs(CompareWith(from.Root(), to.Root(), CompareOptions{Unordered: true}))
+	if len(got) != 3 || got[0] != `/config/user[2]: attribute role changed from "dev" to "ops"` {
+		t.Errorf("unexpected unkeyed changes:\n%s", strings.Join(got, "\n"))
+	}
+	moved, _ := Parse(strings.NewReader(`<config><user id="c" role="dev"/></config>`))
+	got = strs(CompareWith(moved.Root(), Elem("config", "").AddChild(Elem("user", "").Attr("id", "", "d").Attr("role", "", "dev")), CompareOptions{Unordered: true, Key: KeyAttrs("id")}))
+	if !reflect.DeepEqual(got, []string{"/config/user: element removed", "/config/user: element added"}) {
+		t.Errorf("unexpected changes for a new key:\n%s", strings.Join(got, "\n"))
+	}
+
+	// Identical unkeyed siblings are found by hash, so deep trees of them
+	// in another order do not take a comparison of every pair at every
+	// level.
+	var build func(depth int, reverse bool, n string) *Element
+	build = func(depth int, reverse bool, n string) *Element {
+		if depth == 0 {
+			return ElemC("item", "", n)
+		}
+		e := Elem("item", "")
+		for i := 0; i < 12; i++ {
+			j := i
+			if reverse {
+				j = 11 - i
+			}
+			e.AddChild(build(depth-1, reverse, fmt.Sprintf("%s.%d", n, j)))
+		}
+		return e
+	}
+	a, b := build(4, false, "x"), build(4, true, "x")
+	if got := CompareWith(a, b, CompareOptions{Unordered: true}); len(got) != 0 {
+		t.Errorf("expected reordered trees to be the same, got %d changes, first %v", len(got), got[0])
+	}
+	b.Child(3).Child(4).Child(5).Child(6).Content = []byte("changed")
+	if got := strs(CompareWith(a, b, CompareOptions{Unordered: true})); len(got) != 1 || !strings.Contains(got[0], `to "changed"`) {
+		t.Errorf("unexpected changes:\n%s", strings.Join(got, "\n"))
+	}
+}
+
+func TestBaseURI(t *testing.T) {
+	src := `<feed xml:base="/blog/"><entry xml:base="2024/post.html"><link href="img/a.png"/></entry><entry xml:base="http://other.example/x/"><link href="../y"/></entry></feed>`
+	doc, _ := Parse(strings.NewReader(src), WithBaseURI("http://example.com/feeds/atom.xml"))
+	link1, link2 := doc.Root().Child(0).Child(0), doc.Root().Child(1).Child(0)
+	checks := []struct {
+		e         *Element
+		base, ref string
+	}{
+		{doc.Root(), "http://example.com/blog/", "http://example.com/blog/img/a.png"},
+		{link1, "http://example.com/blog/2024/post.html", "http://example.com/blog/2024/img/a.png"},
+		{link2, "http://other.example/x/", "http://other.example/y"},
+	}
+	for _, c := range checks {
+		base, err := c.e.BaseURI()
+		if err != nil || base != c.base {
+			t.Errorf("%s: expected base %s, got %s, %v", c.e.Path(), c.base, base, err)
+		}
+		href := "img/a.png"
+		if c.e == link2 {
+			href = "../y"
+		}
+		if ref, err := c.e.ResolveURI(href); err != nil || ref != c.ref {
+			t.Errorf("%s: expected %s, got %s, %v", c.e.Path(), c.ref, ref, err)
+		}
+	}
+
+	// Without a document URI, relative bases stay relative.
+	doc, _ = Parse(strings.NewReader(src))
+	if ref, _ := link1.ResolveURI("x"); ref != "http://example.com/blog/2024/x" {
+		t.Errorf("unexpected %s", ref)
+	}
+	rel := doc.Root().Child(0).Child(0)
+	if ref, _ := rel.ResolveURI("img/a.png"); ref != "/blog/2024/img/a.png" {
+		t.Errorf("unexpected %s", ref)
+	}
+	plain := Elem("a", "").SetDocumentURI("docs/index.xml")
+	if ref, _ := plain.ResolveURI("b.xml"); ref != "docs/b.xml" {
+		t.Errorf("unexpected %s", ref)
+	}
+	if ref, _ := Elem("a", "").ResolveURI("b.xml"); ref != "b.xml" {
+		t.Errorf("unexpected %s", ref)
+	}
+}
+
+func TestLang(t *testing.T) {
+	doc, _ := Parse(strings.NewReader(`<tu xml:lang="en"><tuv xml:lang="de"><seg>Hallo</seg></tuv><tuv xml:lang="en-US"><seg>Hi</seg></tuv><tuv xml:lang="pt-BR"><seg>Oi</seg></tuv><tuv><seg>Hello</seg></tuv><tuv xml:lang=""><seg>?</seg></tuv></tu>`))
+	tuvs := doc.Root().Children()
+	if l := tuvs[0].Child(0).Lang(); l != "de" {
+		t.Errorf("expected de, got %q", l)
+	}
+	if l := tuvs[3].Lang(); l != "en" {
+		t.Errorf("expected the inherited en, got %q", l)
+	}
+	if l := tuvs[4].Lang(); l != "" {
+		t.Errorf("an empty xml:lang should reset the language, got %q", l)
+	}
+	checks := map[string]string{
+		"de":            "Hallo",
+		"DE-at":         "Hallo",
+		"en-us":         "Hi",
+		"en":            "Hello",
+		"en-GB":         "Hello",
+		"pt":            "Oi",
+		"de-CH-x-local": "Hallo",
+		"fr":            "?",
+	}
+	for lang, expect := range checks {
+		if got := SelectByLang(tuvs, lang); got == nil || string(got.Child(0).Content) != expect {
+			t.Errorf("%s: expected %s, got %v", lang, expect, got)
+		}
+	}
+	if got := SelectByLang(tuvs[:3], "fr"); got != nil {
+		t.Errorf("expected no match, got %v", got)
+	}
+}
+
+func TestResolveRefs(t *testing.T) {
+	doc, _ := Parse(strings.NewReader(`<book><ch xml:id="intro"/><ch xml:id="body"><see ref="intro"/><also refs=" intro  body missing "/></ch><ch xml:id="intro"/></book>`))
+	types := func(e *Element, a xml.Attr) RefKind {
+		switch a.Name.Local {
+		case "ref":
+			return IDRefAttr
+		case "refs":
+			return IDRefsAttr
+		}
+		return XMLIDs(e, a)
+	}
+	refs := ResolveRefs(doc.Root(), types)
+	intro, body := doc.Root().Child(0), doc.Root().Child(1)
+	if len(refs.IDs) != 2 || refs.IDs["intro"] != intro || refs.IDs["body"] != body {
+		t.Errorf("unexpected IDs %v", refs.IDs)
+	}
+	if len(refs.Duplicates) != 1 || refs.Duplicates[0] != doc.Root().Child(2) {
+		t.Errorf("unexpected duplicates %v", refs.Duplicates)
+	}
+	if len(refs.Refs) != 4 || refs.Refs[0].From != body.Child(0) || refs.Refs[0].To != intro || refs.Refs[2].To != body {
+		t.Errorf("unexpected refs %v", refs.Refs)
+	}
+	if d := refs.Dangling(); len(d) != 1 || d[0].ID != "missing" || d[0].Attr.Local != "refs" {
+		t.Errorf("unexpected dangling refs %v", d)
+	}
+	if len(refs.To(intro)) != 2 || len(refs.From(body.Child(1))) != 3 || len(refs.To(doc.Root())) != 0 {
+		t.Errorf("unexpected graph")
+	}
+}
+
+func TestKeepEntityRefs(t *testing.T) {
+	src := `<!DOCTYPE doc SYSTEM "doc.dtd"><doc title="&product; guide"><p>Copyright &copy; &year; &amp; &#65; &company;</p></doc>`
+	if _, err := Parse(strings.NewReader(src)); err == nil {
+		t.Fatalf("unknown entities should fail without KeepEntityRefs")
+	}
+	doc, err := Parse(strings.NewReader(src), WithKeepEntityRefs())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	p := doc.Root().Child(0)
+	if refs := EntityRefs(string(p.Content)); !reflect.DeepEqual(refs, []string{"copy", "year", "company"}) {
+		t.Errorf("unexpected refs %v", refs)
+	}
+	if string(p.Content) != "Copyright "+EntityRef("copy")+" "+EntityRef("year")+" & A "+EntityRef("company") {
+		t.Errorf("unexpected content %q", p.Content)
+	}
+	if title := doc.Root().Attributes[0].Value; title != EntityRef("product")+" guide" {
+		t.Errorf("unexpected attribute %q", title)
+	}
+	out, _ := doc.Root().StringWith()
+	if out != `<doc title="&product; guide"><p>Copyright &copy; &year; &amp; A &company;</p></doc>` {
+		t.Errorf("unexpected output %s", out)
+	}
+	// References split across reads.
+	doc, err = Parse(iotest.OneByteReader(strings.NewReader(src)), WithKeepEntityRefs())
+	if err != nil || len(EntityRefs(string(doc.Root().Child(0).Content))) != 3 {
+		t.Errorf("unexpected result %v", err)
+	}
+	built := ElemC("p", "", "a "+EntityRef("nbsp")+" b").Attr("x", "", EntityRef("y"))
+	if out, _ := built.StringWith(); out != `<p x="&#xE000;y&#xE001;">a &#xE000;nbsp&#xE001; b</p>` {
+		t.Errorf("unexpected output %s", out)
+	}
+	built.SetEntityRefs(true, xml.Name{Local: "x"})
+	if out, _ := built.StringWith(); out != `<p x="&y;">a &nbsp; b</p>` {
+		t.Errorf("unexpected output %s", out)
+	}
+	var b bytes.Buffer
+	w := NewStreamWriter(&b)
+	w.OpenElement(xml.Name{Local: "q"})
+	w.EmitElement(built)
+	w.Text(EntityRef("z"))
+	w.EntityRef("z")
+	if err := w.Close(); err != nil || b.String() != `<q><p x="&y;">a &nbsp; b</p>&#xE000;z&#xE001;&z;</q>` {
+		t.Errorf("unexpected stream %s, %v", b.String(), err)
+	}
+	for _, e := range []*Element{built.Clone(), built.Export().Import(), NewInterner().InternElement(built).Import()} {
+		if content, attrs := e.HasEntityRefs(); !content || len(attrs) != 1 || attrs[0].Local != "x" {
+			t.Errorf("copies should keep their EntityRefs")
+		}
+	}
+
+	// Text that only looks like an EntityRef is escaped, unless the
+	// parser kept references in the same text.
+	src = `<a b="&#xE000;c&#xE001;">&#xE000;foo&#xE001; &#xE001;&#xE000;</a>`
+	doc, _ = Parse(strings.NewReader(src))
+	for _, opts := range [][]Option{nil, {WithStrict()}} {
+		if out, _ := doc.Root().StringWith(opts...); out != src {
+			t.Errorf("unexpected output %s", out)
+		}
+	}
+	doc, _ = Parse(strings.NewReader(`<a b="&x;">t<c/></a>`), WithKeepEntityRefs())
+	doc.Root().Attr("d", "", EntityRef("xxe")).Child(0).Content = []byte(EntityRef("xxe"))
+	if out, _ := doc.Root().StringWith(); out != `<a b="&x;" d="&#xE000;xxe&#xE001;">t<c>&#xE000;xxe&#xE001;</c></a>` {
+		t.Errorf("unexpected output %s", out)
+	}
+}
+
+func TestNormalizeAttrs(t *testing.T) {
+	src := "<doc a=\"one\ttwo\r\nthree\" b=\"x&#xA;y&#9;z\" c=\"  p  &amp;  q &#32; \" e=\"&ent;\n\"><in d=\"\nx\"/></doc>"
+	want := [][]string{{"one two three", "x\ny\tz", "  p  &  q   ", EntityRef("ent") + " "}, {" x"}}
+	check := func(doc *Document, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		for i, e := range []*Element{doc.Root(), doc.Root().Child(0)} {
+			for j, a := range e.Attributes {
+				if a.Value != want[i][j] {
+					t.Errorf("attribute %s: got %q, want %q", a.Name.Local, a.Value, want[i][j])
+				}
+			}
+		}
+	}
+	check(Parse(strings.NewReader(src), WithNormalizeAttrs(), WithKeepEntityRefs()))
+	check(Parse(iotest.OneByteReader(strings.NewReader(src)), WithNormalizeAttrs(), WithKeepEntityRefs()))
+	opts := &ParseOptions{NormalizeAttrs: true, KeepEntityRefs: true}
+	check(ParseBytesZeroCopy([]byte(src), opts))
+	doc, _ := Parse(strings.NewReader(src), WithKeepEntityRefs())
+	if v := doc.Root().Attributes[0].Value; v != "one\ttwo\nthree" {
+		t.Errorf("values should be left alone by default, got %q", v)
+	}
+	doc, _ = Parse(strings.NewReader(src), WithNormalizeAttrs(), WithKeepEntityRefs())
+	doc.Root().CollapseAttrs(func(e *Element, a xml.Attr) bool { return a.Name.Local != "a" })
+	if v := doc.Root().Attributes[2].Value; v != "p & q" {
+		t.Errorf("unexpected collapsed value %q", v)
+	}
+	if v := doc.Root().Attributes[1].Value; v != "x\ny\tz" {
+		t.Errorf("referenced whitespace should not collapse, got %q", v)
+	}
+	if v := doc.Root().Child(0).Attributes[0].Value; v != "x" {
+		t.Errorf("unexpected collapsed value %q", v)
+	}
+}
+
+func TestNil(t *testing.T) {
+	src := `<r xmlns:i="http://www.w3.org/2001/XMLSchema-instance"><a i:nil="true"/><b/><c i:nil=" 1 "/><d i:nil="false"/><e xsi:nil="true"/></r>`
+	doc, err := Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for i, want := range []bool{true, false, true, false, false} {
+		if got := doc.Root().Child(i).IsNil(); got != want {
+			t.Errorf("child %d: IsNil() = %v", i, got)
+		}
+	}
+	b := doc.Root().Child(1)
+	if !b.SetNil(true).IsNil() {
+		t.Errorf("SetNil(true) did not stick")
+	}
+	if len(b.GetAttr("xsi", "xmlns", "*")) != 0 {
+		t.Errorf("no declaration should be added when the namespace is bound")
+	}
+	if b.SetNil(false).IsNil() || len(b.Attributes) != 0 {
+		t.Errorf("SetNil(false) left %v", b.Attributes)
+	}
+	e := ElemC("price", "", "12").AddChild(Elem("cur", ""))
+	e.SetNil(true)
+	if out, _ := e.StringWith(); out != `<price xsi:nil="true" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"/>` {
+		t.Errorf("unexpected output %s", out)
+	}
+	// Emptying an element that was nil already still invalidates the
+	// Index.
+	doc.EnableIndex()
+	a := doc.Root().Child(0).AddChild(Elem("x", ""))
+	if len(doc.Index().ByLocalName("x")) != 1 {
+		t.Fatalf("x should be indexed")
+	}
+	a.SetNil(true)
+	if got := doc.Index().ByLocalName("x"); len(got) != 0 {
+		t.Errorf("Index still has the children SetNil dropped: %v", got)
+	}
+}
+
+func TestBinaryContent(t *testing.T) {
+	data := []byte("\x00\x01binary\xff payload")
+	e := Elem("blob", "").SetContentBytesBase64(data)
+	if string(e.Content) != base64.StdEncoding.EncodeToString(data) {
+		t.Errorf("unexpected base64 %s", e.Content)
+	}
+	e.Content = []byte("\n  AAFiaW5h\n  cnn/IHBh\r\n\teWxvYWQ=\n")
+	if got, err := e.ContentBytesBase64(); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("unexpected result %q, %v", got, err)
+	}
+	e.Content = []byte("AAF*")
+	if _, err := e.ContentBytesBase64(); err == nil {
+		t.Errorf("invalid base64 should fail")
+	}
+	if err := e.SetContentReaderBase64(iotest.OneByteReader(bytes.NewReader(data))); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got, _ := io.ReadAll(e.ContentReaderBase64()); !bytes.Equal(got, data) {
+		t.Errorf("unexpected round trip %q", got)
+	}
+	if err := e.SetContentReaderBase64(iotest.ErrReader(io.ErrUnexpectedEOF)); err == nil || !bytes.Equal(e.Content, []byte(base64.StdEncoding.EncodeToString(data))) {
+		t.Errorf("failed read should leave Content alone, got %v", err)
+	}
+	e.SetContentBytesHex([]byte{0xde, 0xad, 0x0b})
+	if string(e.Content) != "DEAD0B" {
+		t.Errorf("unexpected hex %s", e.Content)
+	}
+	e.Content = []byte(" de ad\n0B ")
+	if got, err := e.ContentBytesHex(); err != nil || !bytes.Equal(got, []byte{0xde, 0xad, 0x0b}) {
+		t.Errorf("unexpected result %x, %v", got, err)
+	}
+	if err := e.SetContentReaderHex(bytes.NewReader(data)); err != nil || string(e.Content) != strings.ToUpper(hex.EncodeToString(data)) {
+		t.Errorf("unexpected hex %s, %v", e.Content, err)
+	}
+	e.Content = []byte("ABC")
+	if _, err := e.ContentBytesHex(); err == nil {
+		t.Errorf("odd length hex should fail")
+	}
+}
+
+func TestWhitespacePolicy(t *testing.T) {
+	src := "<doc>\n <pre>  a\n  b </pre>\n <p>  c  </p>\n <code> </code>\n <p xml:space=\"preserve\"> d <b> </b></p>\n <pre xml:space=\"default\"> e </pre>\n</doc>"
+	content := func(doc *Document) []string {
+		res := []string{}
+		for _, e := range doc.Root().All() {
+			res = append(res, string(e.Content))
+		}
+		return res
+	}
+	doc, _ := Parse(strings.NewReader(src))
+	if got := content(doc); !reflect.DeepEqual(got, []string{"", "a\n  b", "c", "", "d", "e", ""}) {
+		t.Errorf("unexpected default content %q", got)
+	}
+	policy := &WhitespacePolicy{Preserve: []xml.Name{{Local: "pre"}, {Space: "*", Local: "code"}}}
+	for _, xmlSpace := range []bool{false, true} {
+		want := []string{"", "  a\n  b ", "c", " ", "d", " e ", ""}
+		if xmlSpace {
+			want[4], want[5], want[6] = " d ", "e", " "
+		}
+		policy.XMLSpace = xmlSpace
+		doc, err := Parse(strings.NewReader(src), WithWhitespace(policy))
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got := content(doc); !reflect.DeepEqual(got, want) {
+			t.Errorf("XMLSpace %v: unexpected content %q", xmlSpace, got)
+		}
+		doc, _ = ParseBytesZeroCopy([]byte(src), &ParseOptions{Whitespace: policy})
+		if got := content(doc); !reflect.DeepEqual(got, want) {
+			t.Errorf("XMLSpace %v: unexpected zero-copy content %q", xmlSpace, got)
+		}
+	}
+	paths := []string{}
+	policy = &WhitespacePolicy{Hint: func(path []xml.Name, preserve bool) bool {
+		paths = append(paths, path[len(path)-1].Local)
+		return len(path) == 2 && path[1].Local == "p"
+	}}
+	doc, _ = Parse(strings.NewReader(src), WithWhitespace(policy))
+	if got := content(doc); got[2] != "  c  " || got[1] != "a\n  b" || got[6] != "" {
+		t.Errorf("unexpected hinted content %q", got)
+	}
+	if len(paths) != 7 {
+		t.Errorf("unexpected calls %v", paths)
+	}
+}
+
+func TestProlog(t *testing.T) {
+	src := "<?xml version=\"1.0\"?>\n<!-- Copyright 2026 -->\n<!DOCTYPE doc>\n<?xml-stylesheet href=\"s.xsl\"?>\n<doc><!-- dropped --><a/></doc>\n<!-- end -->\n<?pi x?>\n"
+	doc, err := Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if want := []Misc{{Text: " Copyright 2026 "}, {Target: "xml-stylesheet", Text: `href="s.xsl"`}}; !reflect.DeepEqual(doc.Prolog(), want) {
+		t.Errorf("unexpected prolog %v", doc.Prolog())
+	}
+	if want := []Misc{{Text: " end "}, {Target: "pi", Text: "x"}}; !reflect.DeepEqual(doc.Epilog(), want) {
+		t.Errorf("unexpected epilog %v", doc.Epilog())
+	}
+	want := "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<!-- Copyright 2026 -->\n<!DOCTYPE doc>\n<?xml-stylesheet href=\"s.xsl\"?>\n<doc>\n <a/>\n</doc>\n<!-- end -->\n<?pi x?>\n"
+	if out := doc.String(); out != want {
+		t.Errorf("unexpected output %q", out)
+	}
+	if out, _ := doc.MarshalText(); string(out) != strings.ReplaceAll(strings.ReplaceAll(want, "\n ", ""), "\n", "") {
+		t.Errorf("unexpected compact output %q", out)
+	}
+	var copied Document
+	buf, _ := doc.MarshalBinary()
+	if err := copied.UnmarshalBinary(buf); err != nil || copied.String() != want {
+		t.Errorf("prolog lost in binary round trip: %v %q", err, copied.String())
+	}
+	doc.SetProlog([]Misc{{Text: "a -- b"}})
+	if _, err := doc.StringWith(); err == nil {
+		t.Errorf("a comment with -- should not encode")
+	}
+	doc.SetProlog([]Misc{{Target: "p", Text: "x"}})
+	doc.SetEpilog(nil)
+	if out, _ := doc.StringWith(); out != `<?xml version="1.0" encoding="UTF-8"?><!DOCTYPE doc><?p x?><doc><a/></doc>` {
+		t.Errorf("unexpected output %q", out)
+	}
+}
+
+func TestClone(t *testing.T) {
+	src := "<!-- header --><!DOCTYPE req><req id=\"1\"><item n=\"a\">x</item><item/></req><!-- end -->"
+	base, err := Parse(strings.NewReader(src), WithBaseURI("http://example.com/t.xml"))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	want := base.String()
+	doc := base.Clone()
+	if doc.String() != want {
+		t.Errorf("clone differs:\n%s\n%s", doc.String(), want)
+	}
+	if uri, _ := doc.Root().BaseURI(); uri != "http://example.com/t.xml" {
+		t.Errorf("base URI lost, got %q", uri)
+	}
+	if doc.Root().Child(0).Pos() != base.Root().Child(0).Pos() {
+		t.Errorf("positions lost")
+	}
+	doc.Root().Child(0).Content[0] = 'y'
+	doc.Root().Child(0).Attributes[0].Value = "b"
+	doc.Root().AddChild(Elem("extra", ""))
+	doc.Prolog()[0].Text = " changed "
+	if base.String() != want {
+		t.Errorf("changing the clone changed the original:\n%s", base.String())
+	}
+	if c := doc.Root().Child(1); c.Parent() != doc.Root() {
+		t.Errorf("bad parent in clone")
+	}
+	if sub := base.Root().Child(0).Clone(); sub.Parent() != nil || sub.String() != base.Root().Child(0).String() {
+		t.Errorf("unexpected element clone %s", sub)
+	}
+}
+
+func TestDeclaration(t *testing.T) {
+	src := "<?xml version='1.0' encoding=\"ISO-8859-1\"  standalone = 'yes' ?><doc/>"
+	doc, err := Parse(strings.NewReader(src), WithCharsetReader(func(label string, r io.Reader) (io.Reader, error) { return r, nil }))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if want := (Declaration{Version: "1.0", Encoding: "ISO-8859-1", Standalone: "yes"}); doc.Declaration() != want {
+		t.Errorf("unexpected declaration %+v", doc.Declaration())
+	}
+	if out, _ := doc.StringWith(); out != `<?xml version="1.0" encoding="UTF-8" standalone="yes"?><doc/>` {
+		t.Errorf("unexpected output %s", out)
+	}
+	if doc.Clone().Declaration() != doc.Declaration() {
+		t.Errorf("declaration not cloned")
+	}
+	doc, _ = Parse(strings.NewReader("<doc/>"))
+	if doc.Declaration() != (Declaration{}) {
+		t.Errorf("unexpected declaration %+v", doc.Declaration())
+	}
+	if out, _ := doc.StringWith(); out != `<?xml version="1.0" encoding="UTF-8"?><doc/>` {
+		t.Errorf("unexpected output %s", out)
+	}
+	for _, bad := range []Declaration{{Version: "2.0"}, {Version: `1.0"`}, {Standalone: "maybe"}} {
+		doc.SetDeclaration(bad)
+		if _, err := doc.StringWith(); err == nil {
+			t.Errorf("%+v should not encode", bad)
+		}
+	}
+}
+
+func TestGroupChildren(t *testing.T) {
+	doc, err := Parse(strings.NewReader(`<r><phone type="home">1</phone><name>n</name><phone type="work">2</phone><phone>3</phone><x:phone xmlns:x="urn:x" type="home">4</x:phone></r>`))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	contents := func(es []*Element) (res []string) {
+		for _, e := range es {
+			res = append(res, string(e.Content))
+		}
+		return res
+	}
+	m := doc.Root().ChildrenMap()
+	if len(m) != 2 || !reflect.DeepEqual(contents(m["phone"]), []string{"1", "2", "3", "4"}) || !reflect.DeepEqual(contents(m["name"]), []string{"n"}) {
+		t.Errorf("unexpected map %v", m)
+	}
+	g := doc.Root().GroupChildren("type")
+	if len(g) != 3 || !reflect.DeepEqual(contents(g["home"]), []string{"1", "4"}) || !reflect.DeepEqual(contents(g["work"]), []string{"2"}) || !reflect.DeepEqual(contents(g[""]), []string{"n", "3"}) {
+		t.Errorf("unexpected groups %v", g)
+	}
+	if len(Elem("e", "").ChildrenMap()) != 0 {
+		t.Errorf("unexpected children")
+	}
+}
+
+func TestRemoveAll(t *testing.T) {
+	doc, err := Parse(strings.NewReader(`<r><a><x/><b><x><x/></x></b></a><x/><c/></r>`))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	calls := 0
+	isX := func(e *Element) bool {
+		calls++
+		return e.Name.Local == "x"
+	}
+	removed := doc.RemoveAll(isX)
+	if len(removed) != 3 || calls != 7 {
+		t.Errorf("unexpected removal of %d elements with %d calls", len(removed), calls)
+	}
+	for _, e := range removed {
+		if e.Parent() != nil {
+			t.Errorf("removed element still has a parent")
+		}
+	}
+	if out, _ := doc.Root().StringWith(); out != "<r><a><b/></a><c/></r>" {
+		t.Errorf("unexpected output %s", out)
+	}
+	if len(doc.Root().RemoveAll(isX)) != 0 {
+		t.Errorf("nothing should be left to remove")
+	}
+	if got := doc.RemoveAll(func(e *Element) bool { return e.Name.Local == "r" }); len(got) != 1 || doc.Root() != nil {
+		t.Errorf("root should be removed")
+	}
+}
+
+func TestRenameAll(t *testing.T) {
+	doc, err := Parse(strings.NewReader(`<r xmlns:o="urn:old"><o:item><item/></o:item><o:other/></r>`))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	root := doc.Root()
+	if n := RenameAll(root, xml.Name{Space: "urn:old", Local: "item"}, xml.Name{Space: "urn:new", Local: "entry"}); n != 1 {
+		t.Errorf("expected 1 rename, got %d", n)
+	}
+	if n := RenameAll(root, xml.Name{Space: "*", Local: "item"}, xml.Name{Local: "line"}); n != 1 {
+		t.Errorf("expected 1 rename, got %d", n)
+	}
+	if root.Child(0).Name != (xml.Name{Space: "urn:new", Local: "entry"}) || root.Child(0).Child(0).Name.Local != "line" {
+		t.Errorf("unexpected names %s", root.Dump())
+	}
+	n := RenameFunc(root, func(e *Element) (xml.Name, bool) {
+		if e.Name.Space != "urn:old" {
+			return xml.Name{}, false
+		}
+		return xml.Name{Space: "urn:new", Local: e.Name.Local}, true
+	})
+	if n != 1 || root.Child(1).Name.Space != "urn:new" {
+		t.Errorf("unexpected rename of %d: %s", n, root.Dump())
+	}
+	if RenameAll(root, xml.Name{Local: "r"}, xml.Name{Local: "r"}) != 0 {
+		t.Errorf("renaming to the same name should not count")
+	}
+}
+
+func TestRewriteAttrs(t *testing.T) {
+	doc, err := Parse(strings.NewReader(`<r ID="1" xmlns:v="urn:vendor" v:track="x"><a Href="h" href="old" v:t="y"/><b/></r>`))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	n := RewriteAttrs(doc.Root(), func(owner *Element, a xml.Attr) (xml.Attr, bool) {
+		a.Name.Local = strings.ToLower(a.Name.Local)
+		return a, a.Name.Space != "urn:vendor"
+	})
+	if n != 4 {
+		t.Errorf("expected 4 changes, got %d", n)
+	}
+	if out, _ := doc.Root().StringWith(); out != `<r id="1" xmlns:v="urn:vendor"><a href="old"/><b/></r>` {
+		t.Errorf("unexpected output %s", out)
+	}
+	if RewriteAttrs(doc.Root(), func(owner *Element, a xml.Attr) (xml.Attr, bool) { return a, true }) != 0 {
+		t.Errorf("nothing should change")
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	doc, err := Parse(strings.NewReader(`<top><orders><order id="1"><sku>a</sku></order><order id="2"><sku>b</sku><sku>c</sku></order></orders><note>n</note></top>`))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	flat := Flatten(doc.Root().Child(0))
+	paths := []string{}
+	for _, f := range flat {
+		paths = append(paths, f.Path+"="+f.Text)
+	}
+	want := []string{"/orders=", "/orders/order[1]=", "/orders/order[1]/sku=a", "/orders/order[2]=", "/orders/order[2]/sku[1]=b", "/orders/order[2]/sku[2]=c"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("unexpected paths %q", paths)
+	}
+	if a := flat[3].Attrs; len(a) != 1 || a[0].Value != "2" {
+		t.Errorf("unexpected attributes %v", a)
+	}
+	paths = []string{}
+	for _, f := range Flatten(doc.Root()) {
+		paths = append(paths, f.Path)
+	}
+	elemPaths := []string{}
+	for _, e := range doc.Root().All() {
+		elemPaths = append(elemPaths, e.Path())
+	}
+	sort.Strings(paths)
+	sort.Strings(elemPaths)
+	if !reflect.DeepEqual(paths, elemPaths) {
+		t.Errorf("paths should match Element.Path: %q %q", paths, elemPaths)
+	}
+}
+
+func TestUnflatten(t *testing.T) {
+	root, err := Unflatten(nil, []PathValue{
+		{"order/@id", "7"},
+		{"/order/line[2]/sku", "b"},
+		{"order/note", "rush"},
+		{"order/line/sku", "a"},
+		{"order/line[2]/@qty", "3"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	want := `<order id="7"><line><sku>a</sku></line><line qty="3"><sku>b</sku></line><note>rush</note></order>`
+	if out, _ := root.StringWith(); out != want {
+		t.Errorf("unexpected tree %s", out)
+	}
+	// Flatten and Unflatten round trip.
+	entries := []PathValue{}
+	for _, f := range Flatten(root) {
+		for _, a := range f.Attrs {
+			entries = append(entries, PathValue{f.Path + "/@" + a.Name.Local, a.Value})
+		}
+		if f.Text != "" {
+			entries = append(entries, PathValue{f.Path, f.Text})
+		}
+	}
+	again, err := Unflatten(nil, entries)
+	if out, _ := again.StringWith(); err != nil || out != want {
+		t.Errorf("round trip gave %s, %v", out, err)
+	}
+	doc, _ := Parse(strings.NewReader(`<c xmlns="urn:c"><a>1</a></c>`))
+	if _, err := Unflatten(doc.Root(), []PathValue{{"c/a", "2"}, {"c/b", "3"}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if r := doc.Root(); r.NumChildren() != 2 || string(r.Child(0).Content) != "2" || r.Child(1).Name != (xml.Name{Space: "urn:c", Local: "b"}) || string(r.Child(1).Content) != "3" {
+		t.Errorf("unexpected update %s", r.Dump())
+	}
+	for _, bad := range []string{"x/a", "c/a[0]", "c/a[x]", "c/@", "c/1a", "@id", "c[2]"} {
+		if _, err := Unflatten(doc.Root(), []PathValue{{bad, "v"}}); err == nil {
+			t.Errorf("%q should fail", bad)
+		}
+	}
+}
+
+func TestParseUntrusted(t *testing.T) {
+	if doc, err := ParseUntrusted(strings.NewReader(`<?xml version="1.0" encoding="UTF-8"?><!-- ok --><a x="&amp;"><b>&#65;</b></a>`)); err != nil || doc.Root().Child(0).Content[0] != 'A' {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for _, src := range []string{
+		`<!DOCTYPE a [<!ENTITY x "y">]><a>&x;</a>`,
+		`<?xml-stylesheet href="s.xsl"?><a/>`,
+		`<a><?php echo 1 ?></a>`,
+		`<a><!DOCTYPE a></a>`,
+		`<?xml version="1.0" encoding="ISO-8859-1"?><a/>`,
+	} {
+		_, err := ParseUntrusted(strings.NewReader(src), WithKeepEntityRefs(), WithCharsetReader(func(label string, r io.Reader) (io.Reader, error) { return r, nil }))
+		if !errors.Is(err, ErrUntrustedInput) {
+			t.Errorf("%s: expected ErrUntrustedInput, got %v", src, err)
+		}
+	}
+	if _, err := Parse(strings.NewReader(`<a>&x;</a>`), WithUntrusted(), WithKeepEntityRefs()); err == nil {
+		t.Errorf("unknown entities should fail")
+	}
+	deep := strings.Repeat("<a>", UntrustedMaxDepth+1) + strings.Repeat("</a>", UntrustedMaxDepth+1)
+	var tooDeep *TreeTooDeepError
+	if _, err := ParseUntrusted(strings.NewReader(deep), WithMaxDepth(1000)); !errors.As(err, &tooDeep) || tooDeep.Limit != UntrustedMaxDepth {
+		t.Errorf("expected a TreeTooDeepError, got %v", err)
+	}
+	if _, err := Parse(strings.NewReader(deep)); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err := Parse(strings.NewReader("<a><b><c/></b></a>"), WithMaxDepth(2)); !errors.As(err, &tooDeep) {
+		t.Errorf("expected a TreeTooDeepError, got %v", err)
+	}
+	big := "<a>" + strings.Repeat(" ", 2000) + "</a>"
+	var tooLarge *TreeTooLargeError
+	if _, err := ParseUntrusted(strings.NewReader(big), WithMaxTreeBytes(1000)); !errors.As(err, &tooLarge) || tooLarge.Limit != 1000 {
+		t.Errorf("expected a TreeTooLargeError, got %v", err)
+	}
+}
+
+func TestStreamWriter(t *testing.T) {
+	doc, err := Parse(strings.NewReader(`<orders><order id="1"><item>a &amp; b</item><item/></order><order id="2">rush</order></orders>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	w := NewStreamWriter(&b, WithIndent("\t"))
+	w.WriteDeclaration(Declaration{})
+	w.OpenElement(xml.Name{Local: "orders"})
+	for _, c := range doc.Root().Children() {
+		if err := w.EmitElement(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := doc.BytesWith(WithIndent("\t"))
+	if b.String() != string(want) {
+		t.Errorf("Unexpected output\n%s\nwant\n%s", b.String(), want)
+	}
+
+	b.Reset()
+	w = NewStreamWriter(&b)
+	w.OpenElement(xml.Name{Space: "urn:a", Local: "a"}, Attr("p", "xmlns", "urn:a"))
+	w.Attr(xml.Name{Local: "x"}, "1")
+	w.OpenElement(xml.Name{Space: "urn:b", Local: "b"}, Attr("y", "urn:a", "2"))
+	w.Text("t")
+	w.CloseElement()
+	w.OpenElement(xml.Name{Space: "urn:b", Local: "c"}, Attr("xmlns", "", "urn:b"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := `<p:a x="1" xmlns:p="urn:a"><ns1:b p:y="2" xmlns:ns1="urn:b">t</ns1:b><ns2:c xmlns:ns2="urn:b"/></p:a>`; b.String() != got {
+		t.Errorf("Unexpected output %s", b.String())
+	}
+	if _, err := Parse(&b); err != nil {
+		t.Errorf("The output should parse: %v", err)
+	}
+
+	for name, f := range map[string]func(w *StreamWriter) error{
+		"no root": func(w *StreamWriter) error { return w.Close() },
+		"two roots": func(w *StreamWriter) error {
+			w.EmitElement(Elem("a", ""))
+			return w.OpenElement(xml.Name{Local: "b"})
+		},
+		"text outside": func(w *StreamWriter) error { return w.Text("x") },
+		"close":        func(w *StreamWriter) error { return w.CloseElement() },
+		"late attr": func(w *StreamWriter) error {
+			w.OpenElement(xml.Name{Local: "a"})
+			w.Text("x")
+			return w.Attr(xml.Name{Local: "x"}, "1")
+		},
+		"bad name": func(w *StreamWriter) error { return w.EmitElement(Elem("1a", "")) },
+		"duplicate": func(w *StreamWriter) error {
+			w.OpenElement(xml.Name{Local: "a"}, Attr("x", "", "1"))
+			w.Attr(xml.Name{Local: "x"}, "2")
+			return w.CloseElement()
+		},
+		"bad text": func(w *StreamWriter) error {
+			w.OpenElement(xml.Name{Local: "a"})
+			return w.Text("\x01")
+		},
+		"late decl": func(w *StreamWriter) error {
+			w.OpenElement(xml.Name{Local: "a"})
+			return w.WriteDeclaration(Declaration{})
+		},
+	} {
+		w := NewStreamWriter(io.Discard)
+		err := f(w)
+		var e *Error
+		if !errors.As(err, &e) || e.Op != "encode" {
+			t.Errorf("%s: expected an encode Error, got %v", name, err)
+		} else if w.Close() != err {
+			t.Errorf("%s: the error should stick", name)
+		}
+	}
+}
+
+func TestLogWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.xml")
+	root := xml.Name{Local: "events"}
+	opts := &LogOptions{Encoding: []Option{WithPretty()}}
+	l, err := OpenLog(path, root, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"one", "two"} {
+		if err := l.Write(ElemC("event", "", text)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<events>\n <event>one</event>\n <event>two</event>\n</events>\n"
+	if got, _ := os.ReadFile(path); string(got) != want {
+		t.Errorf("Unexpected log\n%s\nwant\n%s", got, want)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// A record cut short by a crash is dropped.
+	f, _ := os.OpenFile(path, os.O_RDWR, 0)
+	f.Truncate(int64(len(want) - len("</events>\n")))
+	f.Seek(0, io.SeekEnd)
+	f.WriteString(" <event>thr")
+	f.Close()
+	if l, err = OpenLog(path, root, opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Write(ElemC("event", "", "three")); err != nil {
+		t.Fatal(err)
+	}
+	want = strings.Replace(want, "</events>", " <event>three</event>\n</events>", 1)
+	if got, _ := os.ReadFile(path); string(got) != want {
+		t.Errorf("Unexpected repaired log\n%s\nwant\n%s", got, want)
+	}
+	l.Close()
+	if err := l.Write(Elem("event", "")); err == nil {
+		t.Errorf("Writing to a closed log should fail")
+	}
+	if _, err := OpenLog(path, xml.Name{Local: "other"}, nil); err == nil {
+		t.Errorf("Opening a log with the wrong root should fail")
+	}
+
+	path = filepath.Join(t.TempDir(), "rotated.xml")
+	if l, err = OpenLog(path, root, &LogOptions{MaxBytes: 60, Keep: 2}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := l.Write(ElemC("event", "", strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+	for file, want := range map[string]string{
+		path:        "<event>4</event>",
+		path + ".1": "<event>3</event>",
+		path + ".2": "<event>2</event>",
+	} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc, err := Parse(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := doc.Root().Child(0).String(); got != want+"\n" {
+			t.Errorf("%s: unexpected record %s", file, got)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Errorf("Only two rotated files should be kept")
+	}
+}
+
+type tokenSlice []xml.Token
+
+func (t *tokenSlice) Token() (xml.Token, error) {
+	if len(*t) == 0 {
+		return nil, io.EOF
+	}
+	tok := (*t)[0]
+	*t = (*t)[1:]
+	return tok, nil
+}
+
+func TestFromTokenReader(t *testing.T) {
+	d := xml.NewDecoder(strings.NewReader(`<?xml version="1.0"?><!-- c --><a xmlns:p="urn:p"> <p:b x="1">t</p:b></a><c/>`))
+	a, err := FromTokenReader(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := a.Child(0); got.Name.Space != "urn:p" || string(got.Content) != "t" || got.Pos().IsValid() {
+		t.Errorf("Unexpected element %v", got)
+	}
+	if c, err := FromTokenReader(d); err != nil || c.Name.Local != "c" {
+		t.Errorf("Expected the next element, got %v, %v", c, err)
+	}
+	if _, err := FromTokenReader(d); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+
+	attrs := []xml.Attr{{Name: xml.Name{Space: "xmlns", Local: "p"}, Value: "urn:p"}, {Name: xml.Name{Space: "p", Local: "x"}, Value: "1"}}
+	toks := tokenSlice{
+		xml.CharData("\n"),
+		xml.StartElement{Name: xml.Name{Space: "p", Local: "a"}, Attr: attrs},
+		xml.CharData("text"),
+		xml.EndElement{Name: xml.Name{Space: "p", Local: "a"}},
+	}
+	a, err = FromTokenReader(&toks, WithInternNames())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := a.StringWith(); got != `<p:a p:x="1" xmlns:p="urn:p">text</p:a>` {
+		t.Errorf("Unexpected element %s", got)
+	}
+	if attrs[1].Name.Space != "p" {
+		t.Errorf("The tokens should not be changed")
+	}
+
+	toks = tokenSlice{
+		xml.StartElement{Name: xml.Name{Local: "a"}},
+		xml.EndElement{Name: xml.Name{Local: "b"}},
+	}
+	var derr *Error
+	if _, err := FromTokenReader(&toks); !errors.As(err, &derr) || derr.Path != "/a" || derr.Pos.IsValid() {
+		t.Errorf("Expected an Error for unbalanced tokens, got %v", err)
+	}
+	toks = tokenSlice{xml.StartElement{Name: xml.Name{Local: "a"}}}
+	if _, err := FromTokenReader(&toks); !errors.As(err, &derr) {
+		t.Errorf("Expected an Error for missing end tokens, got %v", err)
+	}
+
+	// The options that do not work on text apply as they do to Parse.
+	deep := `<a><b><c><d>x</d></c></b></a>`
+	opened := 0
+	v := ValidatorFuncs{OnOpen: func(e *Element) error {
+		if opened++; e.Name.Local == "d" {
+			return errors.New("no d")
+		}
+		return nil
+	}}
+	if _, err := FromTokenReader(xml.NewDecoder(strings.NewReader(deep)), WithValidator(v)); !errors.As(err, &derr) || derr.Op != "validate" || opened != 4 {
+		t.Errorf("Expected the Validator to stop at d, got %v", err)
+	}
+	if _, err := FromTokenReader(xml.NewDecoder(strings.NewReader(deep)), WithUntrusted(), WithMaxDepth(1000)); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	deep = strings.Repeat("<a>", UntrustedMaxDepth+1) + strings.Repeat("</a>", UntrustedMaxDepth+1)
+	if _, err := FromTokenReader(xml.NewDecoder(strings.NewReader(deep)), WithUntrusted(), WithMaxDepth(1000)); err == nil {
+		t.Errorf("WithUntrusted should limit the depth")
+	}
+	if _, err := FromTokenReader(xml.NewDecoder(strings.NewReader(`<?pi x?><a/>`)), WithUntrusted()); !errors.Is(err, ErrUntrustedInput) {
+		t.Errorf("Expected ErrUntrustedInput, got %v", err)
+	}
+	spool, err := NewSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+	a, err = FromTokenReader(xml.NewDecoder(strings.NewReader(`<a><b><c/></b></a>`)), WithSpool(spool, 0))
+	if err != nil || !a.Child(0).Spilled() || a.Child(0).Pos().IsValid() {
+		t.Errorf("Unexpected tree %v, %v", a, err)
+	}
+}
+
+func TestEncoderProgress(t *testing.T) {
+	root := Elem("root", "")
+	for i := 0; i < 9; i++ {
+		root.AddChild(ElemC("item", "", strconv.Itoa(i)))
+	}
+	var reports []Progress
+	doc := CreateDocument()
+	doc.SetRoot(root)
+	out, err := doc.BytesWith(WithPretty(), WithProgress(3, func(p Progress) { reports = append(reports, p) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	elements := []int{}
+	for _, p := range reports {
+		elements = append(elements, p.Elements)
+	}
+	if !reflect.DeepEqual(elements, []int{3, 6, 9, 10}) {
+		t.Errorf("Unexpected reports %v", elements)
+	}
+	if last := reports[len(reports)-1]; last.Bytes != int64(len(out)) || last.Elapsed < 0 {
+		t.Errorf("The last report should count all %d bytes, got %+v", len(out), last)
+	}
+
+	for i := 0; i < 3000; i++ {
+		root.AddChild(Elem("more", ""))
+	}
+	reports = nil
+	want, _ := root.BytesWith()
+	got, err := root.BytesWith(WithParallel(4), WithProgress(1000, func(p Progress) { reports = append(reports, p) }))
+	if err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("Progress should not change the output, got %v", err)
+	}
+	for i := 1; i < len(reports); i++ {
+		if reports[i].Elements <= reports[i-1].Elements || reports[i].Bytes < reports[i-1].Bytes {
+			t.Errorf("Reports should go forward, got %+v after %+v", reports[i], reports[i-1])
+		}
+	}
+	if len(reports) < 2 || reports[len(reports)-1].Elements != 3010 {
+		t.Errorf("Unexpected reports %+v", reports)
+	}
+}
+
+func TestEncoderUndeclared(t *testing.T) {
+	var b bytes.Buffer
+	e := NewEncoder(&b)
+	first := Elem("a", "urn:a")
+	second := Elem("b", "urn:b").AddChildren(Elem("c", "urn:a"), Elem("d", "urn:c").Attr("x", "urn:b", "1"))
+	second.Attr("q", "xmlns", "urn:c")
+	third := Elem("e", "urn:c")
+	for _, node := range []*Element{first, second, third} {
+		if err := node.Encode(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.Flush()
+	want := `<ns1:a xmlns:ns1="urn:a"/>` +
+		`<ns2:b xmlns:ns2="urn:b" xmlns:q="urn:c"><ns1:c/><q:d ns2:x="1"/></ns2:b>` +
+		`<ns3:e xmlns:ns3="urn:c"/>`
+	if b.String() != want {
+		t.Errorf("Unexpected output\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestSpool(t *testing.T) {
+	spool, err := NewSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := `<root><rec id="1"><a>x &amp; y</a><b xmlns:p="urn:p"><p:c>  z  </p:c></b></rec><rec id="2"/></root>`
+	plain, _ := Parse(strings.NewReader(src))
+	doc, err := Parse(strings.NewReader(src), WithSpool(spool, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := doc.Root().children[0]
+	if !rec.Spilled() || rec.children != nil || doc.Root().children[1].Spilled() {
+		t.Fatalf("The children of the root should be spilled")
+	}
+	// The namespaces below spilled elements are read from the Spool, so
+	// they are declared on the root as usual.
+	if got, want := mustString(t, doc.Root()), mustString(t, plain.Root()); got != want {
+		t.Errorf("Unexpected output %s, want %s", got, want)
+	}
+	stream := func(node *Element) string {
+		var b bytes.Buffer
+		w := NewStreamWriter(&b)
+		if err := w.EmitElement(node); err != nil || w.Close() != nil {
+			t.Fatal(err)
+		}
+		return b.String()
+	}
+	if got := stream(doc.Root()); got != stream(plain.Root()) {
+		t.Errorf("Unexpected streamed output %s", got)
+	}
+	if !rec.Spilled() {
+		t.Errorf("Encoding should not page in")
+	}
+	if rec.NumChildren() != 2 || rec.Spilled() || rec.Child(0).Parent() != rec {
+		t.Errorf("NumChildren should page in")
+	}
+	if !reflect.DeepEqual(rec.Export(), plain.Root().Child(0).Export()) {
+		t.Errorf("The paged in tree should be the same as before")
+	}
+	if err := spool.Spill(rec); err != nil || !rec.Spilled() {
+		t.Fatalf("Cannot spill again: %v", err)
+	}
+	spool.Close()
+	var derr *Error
+	if err := rec.PageIn(); !errors.As(err, &derr) || derr.Op != "spill" {
+		t.Errorf("Paging in from a closed Spool should fail, got %v", err)
+	}
+}
+
+func TestSpoolWalks(t *testing.T) {
+	spool, err := NewSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+	var b strings.Builder
+	b.WriteString(`<root xmlns="urn:r">`)
+	for i := 0; i < 40; i++ {
+		fmt.Fprintf(&b, `<group n="%d">`, i%3)
+		for j := 0; j < 40; j++ {
+			fmt.Fprintf(&b, `<item xmlns:q="urn:q%d" q:id="%d"><q:v>%d</q:v></item>`, j%4, j, i*j)
+		}
+		b.WriteString(`</group>`)
+	}
+	b.WriteString(`</root>`)
+	plain, _ := Parse(strings.NewReader(b.String()))
+	doc, _ := Parse(strings.NewReader(b.String()))
+	want := mustString(t, plain.Root())
+
+	// Spilling bottom up leaves what was spilled already in the Spool.
+	group := doc.Root().Child(0)
+	for _, item := range group.Children() {
+		if err := spool.Spill(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	item := group.Child(0)
+	if err := spool.Spill(group); err != nil {
+		t.Fatal(err)
+	}
+	if !item.Spilled() {
+		t.Errorf("Spilling the parent paged its spilled children in")
+	}
+	group.PageIn()
+	if !group.Child(0).Spilled() || group.Child(0).Child(0).Name.Local != "v" {
+		t.Errorf("Children spilled before their parent should come back spilled")
+	}
+	for _, g := range doc.Root().Children() {
+		if err := spool.Spill(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Encoding in parallel reads the spilled subtrees on other goroutines.
+	var out bytes.Buffer
+	e := NewEncoder(&out)
+	e.Parallel(4)
+	if err := doc.Root().Encode(e); err != nil || e.Flush() != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Errorf("Unexpected parallel output %s", out.String())
+	}
+	if got := mustString(t, doc.Root()); got != want {
+		t.Errorf("Unexpected output %s", got)
+	}
+	if changes := Compare(plain.Root(), doc.Root()); len(changes) != 0 {
+		t.Errorf("Unexpected changes %v", changes)
+	}
+	if !doc.Root().Child(1).Spilled() {
+		t.Fatalf("Encoding and comparing should not page in")
+	}
+
+	// The walks that return Elements page them in.
+	g := doc.Root().Child(1)
+	if m := g.ChildrenMap(); len(m["item"]) != 40 || m["item"][0].Parent() != g {
+		t.Errorf("Unexpected ChildrenMap %v", m)
+	}
+	if m := doc.Root().GroupChildren("n"); len(m["2"]) != 13 || len(m["2"][0].ChildrenMap()["item"]) != 40 {
+		t.Errorf("Unexpected GroupChildren %v", m)
+	}
+	removed := doc.Root().RemoveAll(func(e *Element) bool {
+		return e.Name.Local == "item" && e.Attributes[1].Value == "3"
+	})
+	if len(removed) != 40 || removed[0].Parent() != nil || doc.Root().Child(3).NumChildren() != 39 {
+		t.Errorf("RemoveAll removed %d elements", len(removed))
+	}
+
+	// A walk pages everything in, and Evict spills it again.
+	doc, _ = Parse(strings.NewReader(b.String()), WithSpool(spool, 0))
+	doc.EnableIndex()
+	doc.Index()
+	if doc.Root().Child(0).Spilled() {
+		t.Fatalf("The Index should have paged the tree in")
+	}
+	if err := spool.Evict(doc.Root(), 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range doc.Root().children {
+		if !g.Spilled() {
+			t.Fatalf("Evict left %s in memory", g.Path())
+		}
+	}
+	if got := mustString(t, doc.Root()); got != want {
+		t.Errorf("Unexpected output after Evict %s", got)
+	}
+
+	// Spilled text is streamed from the Spool, and is pointed at when
+	// the element it is in is spilled.
+	g = doc.Root().Child(0)
+	text := g.Child(0).Child(0)
+	if err := spool.SpillContent(text); err != nil || text.Content != nil || !text.ContentStreamed() || text.ContentSize() != 1 {
+		t.Fatalf("Unexpected text %q, %v", text.Content, err)
+	}
+	if err := spool.Evict(doc.Root(), 1); err != nil {
+		t.Fatal(err)
+	}
+	text = g.Child(0).Child(0)
+	if !text.ContentStreamed() {
+		t.Errorf("Spilled text should come back streamed")
+	}
+	if got := mustString(t, doc.Root()); got != want {
+		t.Errorf("Unexpected output with spilled text %s", got)
+	}
+	if err := text.LoadContent(); err != nil || string(text.Content) != "0" {
+		t.Errorf("Unexpected text %q, %v", text.Content, err)
+	}
+}
+
+func mustString(t *testing.T, e *Element) string {
+	res, err := e.StringWith()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestXML11(t *testing.T) {
+	src := "<?xml version=\"1.1\" encoding=\"UTF-8\"?>\n<doc a=\"x&#x1;y\"><t>a&#1;b\u0085c\r\u0085d\u2028e&#x85;f</t>" +
+		"<c><![CDATA[&#1;\u0085x]]></c><!-- &#2; --></doc>"
+	doc, err := Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if doc.Declaration().Version != "1.1" {
+		t.Errorf("unexpected declaration %+v", doc.Declaration())
+	}
+	root := doc.Root()
+	if a := root.Attributes[0].Value; a != "x\x01y" {
+		t.Errorf("unexpected attribute %q", a)
+	}
+	if c := string(root.Child(0).Content); c != "a\x01b\nc\nd\ne\u0085f" {
+		t.Errorf("unexpected content %q", c)
+	}
+	if c := string(root.Child(1).Content); c != "&#1;\nx" {
+		t.Errorf("unexpected CDATA content %q", c)
+	}
+	want := `<?xml version="1.1" encoding="UTF-8"?><doc a="x&#x1;y"><t>a&#x1;b&#xA;c&#xA;d&#xA;e&#x85;f</t><c>&amp;#1;&#xA;x</c></doc>`
+	out, err := doc.StringWith(WithStrict())
+	if err != nil || out != want {
+		t.Fatalf("unexpected output %s, %v", out, err)
+	}
+	again, err := Parse(strings.NewReader(out))
+	if err != nil || !reflect.DeepEqual(again.Root().Child(0).Content, root.Child(0).Content) {
+		t.Errorf("output does not read back the same: %v", err)
+	}
+	if err := doc.WellFormed(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if root.WellFormed() == nil {
+		t.Errorf("control characters should not be well-formed in XML 1.0")
+	}
+	doc.SetDeclaration(Declaration{})
+	if _, err := doc.StringWith(WithStrict()); err == nil {
+		t.Errorf("control characters should not encode in XML 1.0")
+	}
+	doc, err = Parse(strings.NewReader("<?xml version='1.1' encoding='ISO-8859-1'?><d>&#1; </d>"),
+		WithCharsetReader(func(label string, r io.Reader) (io.Reader, error) { return r, nil }))
+	if err != nil || string(doc.Root().Content) != "\x01" {
+		t.Errorf("unexpected result %v", err)
+	}
+	for _, bad := range []string{
+		"<?xml version=\"1.1\"?><d>\u0080</d>",
+		"<?xml version=\"1.1\"?><d>\uFDD1</d>",
+		"<?xml version=\"1.1\"?><d>&#0;</d>",
+		"<?xml version=\"1.0\"?><d>&#1;</d>",
+	} {
+		if _, err := Parse(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q should not parse", bad)
+		}
+	}
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestParseResponse(t *testing.T) {
+	response := func(contentType string, body []byte) (*http.Response, *closeRecorder) {
+		b := &closeRecorder{Reader: bytes.NewReader(body)}
+		resp := &http.Response{Header: http.Header{}, Body: b, ContentLength: int64(len(body))}
+		if contentType != "" {
+			resp.Header.Set("Content-Type", contentType)
+		}
+		return resp, b
+	}
+	utf16le := func(s string) []byte {
+		res := []byte{0xFF, 0xFE}
+		for _, u := range utf16.Encode([]rune(s)) {
+			res = append(res, byte(u), byte(u>>8))
+		}
+		return res
+	}
+	for _, test := range []struct {
+		contentType string
+		body        []byte
+	}{
+		{"text/xml; charset=ISO-8859-1", []byte("<?xml version='1.0' encoding='UTF-8'?><d>caf\xE9</d>")},
+		{"application/xml", []byte("<?xml version='1.0' encoding='latin1'?><d>caf\xE9</d>")},
+		{"application/xml", []byte("<?xml version='1.0'?><d>caf\xC3\xA9</d>")},
+		{"", utf16le("<?xml version='1.0' encoding='UTF-16'?><d>caf\u00E9</d>")},
+		{"text/xml; charset=utf-16le", utf16le("<d>caf\u00E9</d>")[2:]},
+	} {
+		resp, body := response(test.contentType, test.body)
+		doc, err := ParseResponse(resp)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.contentType, err)
+			continue
+		}
+		if got := string(doc.Root().Content); got != "caf\u00E9" || !body.closed {
+			t.Errorf("%s: unexpected content %q, closed %v", test.contentType, got, body.closed)
+		}
+	}
+	big := []byte("<d>" + strings.Repeat("x", 100) + "</d>")
+	resp, _ := response("text/xml", big)
+	var tooLarge *TreeTooLargeError
+	if _, err := ParseResponse(resp, WithMaxInputBytes(50)); !errors.As(err, &tooLarge) {
+		t.Errorf("expected a TreeTooLargeError, got %v", err)
+	}
+	resp, body := response("text/xml", big)
+	resp.ContentLength = -1
+	if _, err := ParseResponse(resp, WithMaxInputBytes(50)); !errors.As(err, &tooLarge) || !body.closed {
+		t.Errorf("expected a TreeTooLargeError, got %v", err)
+	}
+	resp, _ = response("text/xml; charset=ISO-8859-1", []byte("<d/>"))
+	if _, err := ParseResponse(resp, WithUntrusted()); !errors.Is(err, ErrUntrustedInput) {
+		t.Errorf("untrusted responses should be UTF-8, got %v", err)
+	}
+	resp, _ = response("text/xml; charset=koi8-r", []byte("<d/>"))
+	if _, err := ParseResponse(resp, WithCharsetReader(nil)); err == nil {
+		t.Errorf("unknown charsets should fail without a CharsetReader")
+	}
+}
+
+func TestServeXML(t *testing.T) {
+	doc, err := Parse(strings.NewReader(`<d><e>text</e></d>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?><d><e>text</e></d>`
+	w := httptest.NewRecorder()
+	if err := ServeXML(w, doc); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != ContentTypeXML || w.Body.String() != want {
+		t.Errorf("unexpected response %d %v %s", w.Code, w.Header(), w.Body)
+	}
+	w = httptest.NewRecorder()
+	w.Header().Set("Content-Type", "text/xml")
+	if err := RenderXML(w, http.StatusCreated, doc); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusCreated || w.Header().Get("Content-Type") != "text/xml" ||
+		w.Header().Get("Content-Length") != strconv.Itoa(len(want)) || w.Body.String() != want {
+		t.Errorf("unexpected response %d %v %s", w.Code, w.Header(), w.Body)
+	}
+	doc.Root().Content = []byte("\x01")
+	w = httptest.NewRecorder()
+	if err := RenderXML(w, 0, doc, WithStrict()); err == nil || w.Body.Len() != 0 || w.Header().Get("Content-Length") != "" {
+		t.Errorf("a failed render should write nothing, got %v, %q", err, w.Body)
+	}
+}
+
+func TestStanzaReader(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewStanzaReader(pr, WithMaxTreeBytes(1024))
+	go pw.Write([]byte(`<?xml version="1.0"?><stream:stream xmlns="jabber:client" xmlns:stream="http://etherx.jabber.org/streams" to="example.com">`))
+	stream, err := r.Stream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream.Name.Local != "stream" || stream.Name.Space != "http://etherx.jabber.org/streams" || len(stream.Attributes) != 3 {
+		t.Errorf("unexpected stream %v %v", stream.Name, stream.Attributes)
+	}
+	if r.Declaration().Version != "1.0" {
+		t.Errorf("unexpected declaration %v", r.Declaration())
+	}
+	// Each stanza has to be returned while the stream is still open, or
+	// the test hangs.
+	for i := 0; i < 3; i++ {
+		go pw.Write([]byte(fmt.Sprintf(" <message id='%d'><body>hello %d</body></message>", i, i)))
+		stanza, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := stanza.Children()
+		if stanza.Name.Space != "jabber:client" || stanza.Attributes[0].Value != strconv.Itoa(i) ||
+			len(body) != 1 || string(body[0].Content) != fmt.Sprintf("hello %d", i) || stanza.Parent() != nil {
+			t.Errorf("unexpected stanza %d: %s", i, stanza)
+		}
+	}
+	// The stanzas together are over MaxTreeBytes, but each is not.
+	go pw.Write([]byte(`</stream:stream>`))
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF again, got %v", err)
+	}
+
+	r = NewStanzaReader(strings.NewReader(`<s><a><b></a></s>`))
+	if _, err := r.Next(); err == nil || !strings.Contains(err.Error(), "/s/a/b") {
+		t.Errorf("expected an error at /s/a/b, got %v", err)
+	}
+	r = NewStanzaReader(strings.NewReader(`<s><a>`+strings.Repeat("x", 2048)+`</a></s>`), WithMaxTreeBytes(1024))
+	var tooLarge *TreeTooLargeError
+	if _, err := r.Next(); !errors.As(err, &tooLarge) {
+		t.Errorf("expected a TreeTooLargeError, got %v", err)
+	}
+	r = NewStanzaReader(strings.NewReader(`<!-- nothing -->`))
+	if _, err := r.Stream(); err != io.EOF {
+		t.Errorf("expected io.EOF from an empty stream, got %v", err)
+	}
+}
+
+func TestPushParser(t *testing.T) {
+	src := `<?xml version="1.0"?><r a="1"><s id="1">one</s><s id="2">two</s>` + "\n" + `<s id="3"><t/></s></r>`
+	p := NewPushParser(nil)
+	for i := 0; i < len(src); i += 3 {
+		end := i + 3
+		if end > len(src) {
+			end = len(src)
+		}
+		if err := p.Feed([]byte(src[i:end])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	doc, err := p.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := Parse(strings.NewReader(src)); doc.String() != want.String() {
+		t.Errorf("expected %s, got %s", want, doc)
+	}
+	if err := p.Feed([]byte("<r/>")); err != ErrFinished {
+		t.Errorf("expected ErrFinished, got %v", err)
+	}
+
+	seen := []string{}
+	p = NewPushParser(func(s *Element) error {
+		seen = append(seen, s.Attributes[0].Value)
+		return nil
+	})
+	// Each stanza is handled by the Feed that completes it.
+	for i, chunk := range []string{`<r a="1"><s id="1">o`, `ne</s><s id="2"`, `>two</s>`, "\n", `<s id="3"><t/></s></r>`} {
+		if err := p.Feed([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+		if want := []int{0, 1, 2, 2, 3}[i]; len(seen) != want {
+			t.Errorf("after chunk %d: expected %d stanzas, got %v", i, want, seen)
+		}
+	}
+	doc, err = p.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Root().Name.Local != "r" || len(doc.Root().Children()) != 0 {
+		t.Errorf("unexpected root %s", doc.Root())
+	}
+
+	stop := errors.New("stop")
+	p = NewPushParser(func(s *Element) error { return stop })
+	if err := p.Feed([]byte(`<r><s/>`)); err != stop {
+		t.Errorf("expected the handler's error, got %v", err)
+	}
+	if err := p.Feed([]byte(`<s/>`)); err != stop {
+		t.Errorf("expected the handler's error again, got %v", err)
+	}
+	p.Finish()
+
+	p = NewPushParser(nil)
+	p.Feed([]byte(`<r><s>`))
+	if _, err := p.Finish(); err == nil {
+		t.Errorf("expected an error for a truncated document")
+	}
+}
+
+func TestCleanNamespaces(t *testing.T) {
+	for _, c := range []struct {
+		in, out string
+		n       int
+	}{
+		{`<a><b xmlns:x="urn:x"><x:c xmlns:x="urn:x" xmlns:y="urn:y"/></b><b xmlns:x="urn:x"/></a>`,
+			`<a xmlns:x="urn:x"><b><x:c/></b><b/></a>`, 5},
+		// Another prefix for the same namespace is dropped, unless a
+		// value uses it.
+		{`<a xmlns:x="urn:x"><x:b xmlns:z="urn:x"><z:c/></x:b><x:b xmlns:z="urn:x" t="z:v"/></a>`,
+			`<a xmlns:x="urn:x" xmlns:z="urn:x"><x:b><x:c/></x:b><x:b t="z:v"/></a>`, 2},
+		{`<a xmlns:x="urn:x"><x:b xmlns:z="urn:x"><z:c/></x:b><x:b t="z:v" xmlns:z="urn:x"/><b/></a>`,
+			`<a xmlns:x="urn:x"><x:b><x:c/></x:b><x:b t="z:v" xmlns:z="urn:x"/><b/></a>`, 1},
+		// Redeclaring a prefix for another namespace keeps both.
+		{`<x:a xmlns:x="urn:x" xmlns:u="urn:u"><x:b xmlns:x="urn:y"/></x:a>`,
+			`<x:a xmlns:x="urn:x"><x:b xmlns:x="urn:y"/></x:a>`, 1},
+		{`<a xmlns:xs="http://www.w3.org/2001/XMLSchema"><b type="xs:string"/></a>`,
+			`<a xmlns:xs="http://www.w3.org/2001/XMLSchema"><b type="xs:string"/></a>`, 0},
+		{`<a><b xmlns:x="urn:x" xmlns:y="urn:y" xmlns:z="urn:z"><x:c/><y:c/><z:c/></b></a>`,
+			`<a xmlns:x="urn:x" xmlns:y="urn:y" xmlns:z="urn:z"><b><x:c/><y:c/><z:c/></b></a>`, 3},
+	} {
+		doc, err := Parse(strings.NewReader(c.in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := CleanNamespaces(doc.Root())
+		var got []string
+		for _, e := range doc.Root().All() {
+			for _, a := range e.Attributes {
+				if p, ok := declPrefix(a); ok {
+					got = append(got, e.Name.Local+":"+p+"="+a.Value)
+				}
+			}
+		}
+		want, err := Parse(strings.NewReader(c.out))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expected []string
+		for _, e := range want.Root().All() {
+			for _, a := range e.Attributes {
+				if p, ok := declPrefix(a); ok {
+					expected = append(expected, e.Name.Local+":"+p+"="+a.Value)
+				}
+			}
+		}
+		if n != c.n || !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: expected %d changes leaving %v, got %d leaving %v", c.in, c.n, expected, n, got)
+		}
+		if n := CleanNamespaces(doc.Root()); n != 0 {
+			t.Errorf("%s: cleaning again made %d changes", c.in, n)
+		}
+	}
+}
+
+func TestEncoderWidth(t *testing.T) {
+	doc, err := Parse(strings.NewReader(`<config><server><host>example.com</host><port>8080</port>` +
+		`<motd>Welcome to the example server, please behave</motd><pad/><empty/></server></config>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Whitespace around Content is kept on one line.
+	doc.Root().Children()[0].Children()[3].Content = []byte("  x  ")
+	got, err := doc.Root().BytesWith(WithWidth(30), WithIndent("  "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<config>
+  <server>
+    <host>example.com</host>
+    <port>8080</port>
+    <motd>
+      Welcome to the example server, please behave
+    </motd>
+    <pad>  x  </pad>
+    <empty/>
+  </server>
+</config>
+`
+	if string(got) != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+	doc.Root().Children()[0].Children()[3].Content = []byte("x")
+	back, err := Parse(bytes.NewReader(got))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.Root().String() != doc.Root().String() {
+		t.Errorf("%s does not parse back to the same tree", got)
+	}
+}
+
+func TestResolvers(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.xml"), []byte("<a/>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	abs, _ := filepath.Abs(filepath.Join(dir, "a.xml"))
+	dr := DirResolver(dir)
+	for _, uri := range []string{"a.xml", "file://" + filepath.ToSlash(abs)} {
+		r, err := dr.Resolve(uri)
+		if err != nil {
+			t.Errorf("%s: %v", uri, err)
+			continue
+		}
+		b, _ := io.ReadAll(r)
+		r.Close()
+		if string(b) != "<a/>" {
+			t.Errorf("%s: unexpected content %q", uri, b)
+		}
+	}
+	for _, uri := range []string{"../a.xml", "/etc/passwd", "file:///etc/passwd", "http://example.com/a.xml"} {
+		if _, err := dr.Resolve(uri); err == nil {
+			t.Errorf("expected %s to be refused", uri)
+		}
+	}
+
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		if r.URL.Path != "/b.xml" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, "<b/>")
+	}))
+	defer srv.Close()
+	hr := NewHTTPResolver(srv.Client())
+	for i := 0; i < 2; i++ {
+		r, err := hr.Resolve(srv.URL + "/b.xml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(r)
+		if string(b) != "<b/>" {
+			t.Errorf("unexpected content %q", b)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("expected one fetch, got %d", fetches)
+	}
+	if _, err := hr.Resolve(srv.URL + "/missing.xml"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected a 404, got %v", err)
+	}
+	hr.MaxBytes = 2
+	if _, err := hr.Resolve(srv.URL + "/b.xml?again"); err == nil {
+		t.Errorf("expected a response over MaxBytes to fail")
+	}
+
+	mr := MultiResolver(dr, hr)
+	for _, uri := range []string{"a.xml", srv.URL + "/b.xml"} {
+		if r, err := mr.Resolve(uri); err != nil {
+			t.Errorf("%s: %v", uri, err)
+		} else {
+			r.Close()
+		}
+	}
+	if _, err := mr.Resolve("nowhere.xml"); err == nil {
+		t.Errorf("expected nowhere.xml not to resolve")
+	}
+}
+
+func TestProfile(t *testing.T) {
+	prof := NewProfile()
+	for _, src := range []string{
+		`<order id="7"><line sku="X"><qty>2</qty></line><line sku="Y"><qty>5</qty></line></order>`,
+		`<order id="12" xmlns:x="urn:x"><line sku="X"><qty>2</qty></line><x:note x:by="Jo Smith">x</x:note></order>`,
+	} {
+		doc, err := Parse(strings.NewReader(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		prof.Add(doc)
+	}
+	expect := `/order  count=2 in=2/2 max=1
+  @id  in=2/2 len=1-2 values="12" (1), "7" (1)
+  line  count=3 in=2/2 max=2
+    @sku  in=3/3 len=1 values="X" (2), "Y" (1)
+    qty  count=3 in=3/3 max=1
+      text  len=1 values="2" (2), "5" (1)
+  {urn:x}note  count=1 in=1/2 max=1
+    @{urn:x}by  in=1/1 len=8 values="Jo Smith" (1)
+    text  len=1 values="x" (1)
+`
+	if got := prof.String(); got != expect {
+		t.Errorf("unexpected report\n%s", got)
+	}
+	if p := prof.Path("/order/{urn:x}note"); p == nil || !p.Optional() || prof.Path("/order/line").Optional() {
+		t.Errorf("unexpected optionality")
+	}
+	if len(prof.Paths()) != 4 {
+		t.Errorf("expected 4 paths, got %d", len(prof.Paths()))
+	}
+	many := NewProfile()
+	for i := 0; i < 30; i++ {
+		doc, _ := Parse(strings.NewReader(fmt.Sprintf(`<a d="2024-01-%02d"/>`, i%28+1)))
+		many.Add(doc)
+	}
+	v := many.Path("/a").Attributes[0].Values
+	if v.Values != nil || v.Patterns["9-9-9"] != 30 || !reflect.DeepEqual(v.Common(1), []string{`"9-9-9" (30)`}) {
+		t.Errorf("expected the values to give way to patterns, got %v %v", v.Values, v.Patterns)
+	}
+	if got := valuePattern("Hello, world 42"); got != "A, a 9" {
+		t.Errorf("unexpected pattern %q", got)
+	}
+}
+
+func TestStreamedContent(t *testing.T) {
+	// A long text with a two-byte character across each chunk boundary.
+	text := strings.Repeat("x", streamChunk-1) + "é<&" + strings.Repeat("y", streamChunk) + "é"
+	want := ElemC("a", "", text)
+	e := Elem("a", "").SetContentStreamAt(strings.NewReader(text), int64(len(text)))
+	for i := 0; i < 2; i++ {
+		if e.String() != want.String() {
+			t.Fatalf("streamed text was encoded differently")
+		}
+	}
+	if !e.ContentStreamed() || e.ContentSize() != int64(len(text)) || e.Content != nil {
+		t.Errorf("unexpected streamed state %v %d", e.ContentStreamed(), e.ContentSize())
+	}
+	var buf bytes.Buffer
+	s := NewStreamWriter(&buf)
+	if err := s.EmitElement(e); err != nil || s.Flush() != nil || buf.String() != strings.TrimSpace(want.String()) {
+		t.Errorf("StreamWriter did not write the streamed text: %v", err)
+	}
+	if err := e.LoadContent(); err != nil || string(e.Content) != text || e.ContentStreamed() {
+		t.Errorf("LoadContent failed: %v", err)
+	}
+
+	data := make([]byte, 100000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	b := Elem("bin", "").SetContentStreamBase64(bytes.NewReader(data), int64(len(data)))
+	encoded := base64.StdEncoding.EncodeToString(data)
+	if b.ContentSize() != int64(len(encoded)) || b.String() != ElemC("bin", "", encoded).String() {
+		t.Errorf("base64 was not streamed as expected")
+	}
+	r, err := b.OpenContent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(r); err != nil || string(got) != encoded {
+		t.Errorf("OpenContent did not encode the stream: %v", err)
+	}
+
+	once := Elem("a", "").SetContentStream(strings.NewReader("once"), -1)
+	if got, err := once.BytesWith(); err != nil || string(got) != "<a>once</a>" {
+		t.Errorf("unexpected first encoding %q %v", got, err)
+	}
+	if _, err := once.BytesWith(); !errors.Is(err, ErrContentRead) {
+		t.Errorf("expected ErrContentRead, got %v", err)
+	}
+	once.Content = []byte("set")
+	if once.ContentStreamed() || once.String() != "<a>set</a>\n" {
+		t.Errorf("setting Content did not replace the stream: %s", once)
+	}
+	empty := Elem("a", "").SetContentStream(strings.NewReader(""), 0)
+	if got := empty.String(); got != "<a></a>\n" {
+		t.Errorf("unexpected encoding of an empty stream %q", got)
+	}
+
+	// Whatever replaces the text replaces the stream.
+	secret := func() *Element {
+		return Elem("blob", "").SetContentStreamAt(strings.NewReader("SECRET"), 6)
+	}
+	for name, e := range map[string]*Element{
+		"SetNil":        secret().SetNil(true),
+		"SetInt":        secret().SetInt(1),
+		"SetContentHex": secret().SetContentBytesHex([]byte{1}),
+		"Replace":       secret().Replace(Elem("blob", "")),
+	} {
+		if got := e.String(); strings.Contains(got, "SECRET") || e.ContentStreamed() {
+			t.Errorf("%s kept the streamed text: %s", name, got)
+		}
+	}
+	if e := Elem("blob", "").Replace(secret()); !strings.Contains(e.String(), "SECRET") {
+		t.Errorf("Replace did not take the streamed text")
+	}
+	target := secret()
+	if err := target.Scan("<blob/>"); err != nil || target.String() != "<blob/>\n" {
+		t.Errorf("Scan kept the streamed text: %s, %v", target.String(), err)
+	}
+
+	// Spilling the element streamed text is in copies it into the Spool.
+	spool, err := NewSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+	root := Elem("r", "").AddChildren(
+		Elem("w", "").AddChild(Elem("a", "").SetContentStream(strings.NewReader(text), -1)),
+		Elem("w", "").AddChild(Elem("bin", "").SetContentStreamBase64(bytes.NewReader(data), int64(len(data)))))
+	if err := spool.Evict(root, 1); err != nil {
+		t.Fatal(err)
+	}
+	wantRoot := Elem("r", "").AddChildren(Elem("w", "").AddChild(want), Elem("w", "").AddChild(ElemC("bin", "", encoded)))
+	for i := 0; i < 2; i++ {
+		if root.String() != wantRoot.String() {
+			t.Fatalf("Spilling lost the streamed text")
+		}
+	}
+}
+
+func TestPreserveSource(t *testing.T) {
+	src := `<?xml version='1.0'?>
+<!-- head -->
+<conf xmlns="urn:c" xmlns:x='urn:x' >
+  <!-- the port -->
+  <port   v = '1' >8080</port>
+  <x:name>a &amp; b</x:name>
+  <empty />
+  <list>
+    <i>1</i>
+    <i>2</i>
+  </list>
+</conf>
+<!-- tail -->
+`
+	encode := func(doc *Document) string {
+		var b bytes.Buffer
+		if err := doc.EncodePreserving(&b); err != nil {
+			t.Fatal(err)
+		}
+		return b.String()
+	}
+	doc, err := Parse(strings.NewReader(src), WithKeepSource())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := encode(doc); got != src {
+		t.Errorf("untouched document was not copied:\n%s", got)
+	}
+	zc, err := ParseBytesZeroCopy([]byte(src), &ParseOptions{KeepSource: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := encode(zc); got != src {
+		t.Errorf("untouched zero-copy document was not copied:\n%s", got)
+	}
+
+	root := doc.Root()
+	kids := root.Children()
+	kids[0].Content = []byte("9090")
+	if got := encode(doc); got != strings.Replace(src, ">8080<", ">9090<", 1) {
+		t.Errorf("changing a leaf changed more than its text:\n%s", got)
+	}
+	kids[0].Attributes[0].Value = "2"
+	kids[2].AddChild(Elem("new", "urn:c"))
+	kids[3].RemoveChild(kids[3].Children()[0])
+	kids[3].AddChild(ElemC("i", "urn:c", "3")).AddChild(ElemC("plain", "", "4"))
+	root.AddChild(ElemC("other", "urn:o", "z"))
+	want := `<?xml version='1.0'?>
+<!-- head -->
+<conf xmlns="urn:c" xmlns:x='urn:x' >
+  <!-- the port -->
+  <port v="2">9090</port>
+  <x:name>a &amp; b</x:name>
+  <empty><new/></empty>
+  <list>
+    <i>2</i>
+    <i>3</i>
+    <plain xmlns="">4</plain>
+  </list>
+  <ns0:other xmlns:ns0="urn:o">z</ns0:other>
+</conf>
+<!-- tail -->
+`
+	got := encode(doc)
+	if got != want {
+		t.Errorf("unexpected encoding of the edited document:\n%s", got)
+	}
+	back, err := Parse(strings.NewReader(got))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := Compare(root, back.Root()); len(changes) != 0 {
+		t.Errorf("edited document does not parse back the same: %v", changes)
+	}
+
+	// Moving an element out from under its namespace declarations
+	// writes it afresh.
+	doc, _ = Parse(strings.NewReader(`<a xmlns:p="urn:p"><b><p:c q='1'/></b></a>`), WithKeepSource())
+	c := doc.Root().Children()[0].Children()[0]
+	doc.Root().Children()[0].RemoveChild(c)
+	doc.SetRoot(c)
+	if got := encode(doc); got != `<p:c q="1" xmlns:p="urn:p"/>` {
+		t.Errorf("unexpected encoding of a moved element %q", got)
+	}
+
+	plain := ElemC("a", "", "b")
+	doc = CreateDocument()
+	doc.SetRoot(plain)
+	if got := encode(doc); got != `<?xml version="1.0" encoding="UTF-8"?><a>b</a>` {
+		t.Errorf("unexpected encoding without a source %q", got)
+	}
+}
+
+func TestFindInNamespace(t *testing.T) {
+	src := `<env xmlns="urn:soap" xmlns:wsse="urn:wsse" xmlns:wsu="urn:wsu">
+  <head>
+    <wsse:Security wsu:Id="s1">
+      <wsse:Token wsu:Id="t1" wsu:Created="now">x</wsse:Token>
+      <other/>
+    </wsse:Security>
+  </head>
+  <body wsu:Id="b1"><wsse:Ref/></body>
+</env>`
+	doc, err := Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, e := range doc.Root().FindAllInNamespace("urn:wsse") {
+		names = append(names, e.Name.Local)
+	}
+	if got := strings.Join(names, " "); got != "Security Token Ref" {
+		t.Errorf("unexpected elements in the namespace %q", got)
+	}
+	elements, attrs := doc.Root().FindAttrsInNamespace("urn:wsu")
+	found := []string{}
+	for i, a := range attrs {
+		found = append(found, elements[i].Name.Local+"@"+a.Name.Local+"="+a.Value)
+	}
+	if got := strings.Join(found, " "); got != "Security@Id=s1 Token@Id=t1 Token@Created=now body@Id=b1" {
+		t.Errorf("unexpected attributes in the namespace %q", got)
+	}
+	if e := doc.Root().FindAllInNamespace("xmlns"); len(e) != 0 {
+		t.Errorf("namespace declarations were found")
+	}
+	if _, attrs := doc.Root().FindAttrsInNamespace("xmlns"); len(attrs) != 0 {
+		t.Errorf("namespace declarations were found as attributes")
+	}
+
+	var first *Element
+	calls := 0
+	done := doc.Root().EachInNamespace("urn:wsse", func(e *Element, attr *xml.Attr) bool {
+		calls++
+		if attr == nil && e.Name.Local == "Token" {
+			first = e
+			return false
+		}
+		return true
+	})
+	if done || first == nil || first.Name.Local != "Token" || calls != 2 {
+		t.Errorf("EachInNamespace did not stop at the first Token: %v %d", done, calls)
+	}
+	doc.Root().EachInNamespace("urn:wsu", func(e *Element, attr *xml.Attr) bool {
+		if attr != nil {
+			attr.Value += "!"
+		}
+		return true
+	})
+	if a := doc.Root().Children()[1].GetAttr("Id", "urn:wsu", "b1!"); len(a) != 1 {
+		t.Errorf("attributes could not be changed through EachInNamespace")
+	}
+}
+
+func TestTransactions(t *testing.T) {
+	src := `<order id="7"><line sku="X">2</line><line sku="Y">5</line></order>`
+	doc, err := Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := doc.Root().String()
+	root := doc.Root()
+	first, second := root.Children()[0], root.Children()[1]
+	doc.EnableIndex()
+	tx := doc.Begin()
+	added := ElemC("note", "", "rush")
+	root.AddChild(added)
+	root.RemoveChild(first)
+	second.Content = []byte("6")
+	second.Attributes[0].Value = "Z"
+	root.Attr("state", "", "sent")
+	second.Name.Local = "item"
+	doc.SetProlog([]Misc{{Text: " changed "}})
+	if len(doc.Index().ByLocalName("note")) != 1 {
+		t.Fatalf("the index did not see the changes")
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if got := doc.Root().String(); got != before {
+		t.Errorf("rollback did not restore the tree:\n%s", got)
+	}
+	if doc.Root() != root || root.Children()[0] != first || root.Children()[1] != second || first.Parent() != root {
+		t.Errorf("rollback did not restore the same elements")
+	}
+	if added.Parent() != nil {
+		t.Errorf("an element added during the transaction is still attached")
+	}
+	if len(doc.Prolog()) != 0 {
+		t.Errorf("rollback did not restore the prolog")
+	}
+	if len(doc.Index().ByLocalName("note")) != 0 || len(doc.Index().ByLocalName("line")) != 2 {
+		t.Errorf("the index was not brought up to date by the rollback")
+	}
+	if err := tx.Rollback(); err != ErrTxDone {
+		t.Errorf("expected ErrTxDone, got %v", err)
+	}
+
+	// Nested transactions are savepoints.
+	outer := doc.Begin()
+	first.Content = []byte("3")
+	inner := first.Begin()
+	first.Content = []byte("4")
+	first.AddChild(Elem("x", ""))
+	inner.Rollback()
+	if string(first.Content) != "3" || first.NumChildren() != 0 {
+		t.Errorf("inner rollback did not go back to the savepoint: %s", first)
+	}
+	outer.Commit()
+	if string(first.Content) != "3" {
+		t.Errorf("commit lost the changes")
+	}
+
+	err = doc.Atomically(func() error {
+		root.RemoveChild(second)
+		root.AddChild(Elem("bad", ""))
+		return errors.New("invalid")
+	})
+	if err == nil || root.NumChildren() != 2 || root.Children()[1] != second {
+		t.Errorf("Atomically did not roll back a failed edit: %v %s", err, root)
+	}
+	if err := doc.Atomically(func() error { root.Attr("ok", "", "1"); return nil }); err != nil || len(root.GetAttr("ok", "", "1")) != 1 {
+		t.Errorf("Atomically did not keep a successful edit: %v", err)
+	}
+}
+
+func TestWatchDocument(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.xml")
+	write := func(content string, at time.Time) {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, at, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	write(`<config port="1"/>`, now)
+	port := func(w *Watcher) string {
+		res := ""
+		w.Document().Read(func(doc *Document) error {
+			res = doc.Root().Attributes[0].Value
+			return nil
+		})
+		return res
+	}
+	results := make(chan error, 10)
+	validate := func(doc *Document) error {
+		if doc.Root().Attributes[0].Value == "0" {
+			return errors.New("port 0")
+		}
+		return nil
+	}
+	w, err := WatchDocument(path, func(doc *Document, err error) {
+		if err == nil && doc.Root().Name.Local != "config" {
+			err = errors.New("callback was given the wrong document")
+		}
+		results <- err
+	}, WithWatchInterval(5*time.Millisecond), WithDebounce(10*time.Millisecond), WithValidate(validate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if got := port(w); got != "1" {
+		t.Fatalf("unexpected first port %q", got)
+	}
+	next := func() error {
+		select {
+		case err := <-results:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("the change was not seen")
+			return nil
+		}
+	}
+	write(`<config port="2"/>`, now.Add(time.Second))
+	if err := next(); err != nil || port(w) != "2" {
+		t.Errorf("the change was not swapped in: %v %q", err, port(w))
+	}
+	write(`<config port="3">`, now.Add(2*time.Second))
+	if err := next(); err == nil || port(w) != "2" {
+		t.Errorf("a broken file replaced the document: %v %q", err, port(w))
+	}
+	write(`<config port="0"/>`, now.Add(3*time.Second))
+	if err := next(); err == nil || !strings.Contains(err.Error(), "port 0") || port(w) != "2" {
+		t.Errorf("an invalid file replaced the document: %v %q", err, port(w))
+	}
+	os.Remove(path)
+	if err := next(); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected the file to be missing, got %v", err)
+	}
+	write(`<config port="4"/>`, now.Add(4*time.Second))
+	if err := w.Reload(); err != nil || port(w) != "4" {
+		t.Errorf("Reload did not read the file: %v %q", err, port(w))
+	}
+	w.Close()
+	if _, err := WatchDocument(filepath.Join(t.TempDir(), "missing.xml"), nil); err == nil {
+		t.Errorf("watching a missing file did not fail")
+	}
+}
+
+func TestXPointer(t *testing.T) {
+	src := `<book><intro id="intro"/><chapter xml:id="c1"><p>1</p><p id="x">2</p></chapter><chapter id="c1"><p>3</p></chapter></book>`
+	doc, err := Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ptr, want := range map[string]string{
+		"intro":                          `<intro id="intro"/>`,
+		" c1 ":                           `<chapter xml:id="c1"><p>1</p><p id="x">2</p></chapter>`,
+		"element(/1)":                    `<book><intro id="intro"/><chapter xml:id="c1"><p>1</p><p id="x">2</p></chapter><chapter id="c1"><p>3</p></chapter></book>`,
+		"element(/1/3/1)":                `<p>3</p>`,
+		"element(c1/2)":                  `<p id="x">2</p>`,
+		"element(missing) element(/1/1)": `<intro id="intro"/>`,
+		"xmlns(b=urn:b) xpointer(//b:p) element(/1/2/1)": `<p>1</p>`,
+		"foo(a^)b(c)^^) element(x)":                      `<p id="x">2</p>`,
+	} {
+		e, err := doc.XPointer(ptr)
+		if err != nil {
+			t.Errorf("%s: %v", ptr, err)
+			continue
+		}
+		if got, _ := e.StringWith(); got != want {
+			t.Errorf("%s: selected %s", ptr, got)
+		}
+	}
+	for _, ptr := range []string{"missing", "element(/2)", "element(/1/9)", "element(/0)", "element(/1", "element(a^b)", "1bad", "element()"} {
+		if e, err := doc.XPointer(ptr); err == nil {
+			t.Errorf("%s: expected an error, selected %s", ptr, e)
+		}
+	}
+
+	files := map[string]string{
+		"/docs/a.xml": `<a xml:base="/docs/a.xml"><link href="b.xml#element(/1/2)"/><self href="#element(/1/1)"/></a>`,
+		"/docs/b.xml": `<b><x/><y id="y"/></b>`,
+	}
+	resolver := ResolverFunc(func(uri string) (io.ReadCloser, error) {
+		u, _ := url.Parse(uri)
+		content, ok := files[u.Path]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return io.NopCloser(strings.NewReader(content)), nil
+	})
+	a, err := Parse(strings.NewReader(files["/docs/a.xml"]), WithBaseURI("http://example.com/docs/a.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := a.Root().Children()[0]
+	href := link.Attributes[0].Value
+	if e, err := link.FollowLink(resolver, href); err != nil || e.Name.Local != "y" {
+		t.Errorf("unexpected target of %s: %v %v", href, e, err)
+	}
+	if e, err := link.FollowLink(resolver, "b.xml"); err != nil || e.Name.Local != "b" {
+		t.Errorf("unexpected target of b.xml: %v %v", e, err)
+	}
+	if e, err := link.FollowLink(nil, "#element(/1/1)"); err != nil || e != link {
+		t.Errorf("unexpected target in the same document: %v %v", e, err)
+	}
+	if _, err := link.FollowLink(resolver, "c.xml#a"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a missing document, got %v", err)
+	}
+}
+
+func TestInterner(t *testing.T) {
+	response := func(device, temp string) *Document {
+		doc, err := Parse(strings.NewReader(`<response><device>` + device + `</device><sensors><s id="a"><unit>C</unit><v>` + temp + `</v></s><s id="b"><unit>C</unit><v>1</v></s></sensors><info><vendor>ACME</vendor><model>X1</model></info></response>`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return doc
+	}
+	in := NewInterner()
+	d1, d2 := response("one", "20"), response("two", "21")
+	i1, i2 := in.InternDocument(d1), in.InternDocument(d2)
+	if i1.Root == i2.Root {
+		t.Fatalf("different documents were interned the same")
+	}
+	if i1.Root.Children[2] != i2.Root.Children[2] {
+		t.Errorf("the same info subtree was not shared")
+	}
+	s1, s2 := i1.Root.Children[1], i2.Root.Children[1]
+	if s1 == s2 || s1.Children[1] != s2.Children[1] || s1.Children[0].Children[0] != s2.Children[0].Children[0] {
+		t.Errorf("the sensors were not shared as far as they are the same")
+	}
+	if s1.Children[0].Children[0] != s1.Children[1].Children[0] {
+		t.Errorf("the same unit in one document was not shared")
+	}
+	if i1.Root.Children[1].Children[0].Children[0].Pos.IsValid() {
+		t.Errorf("interned trees kept positions")
+	}
+	if got, want := i1.Import().Root().String(), d1.Root().String(); got != want {
+		t.Errorf("interned document does not import back the same:\n%s", got)
+	}
+	n := in.Len()
+	if i3 := in.InternDocument(response("one", "20")); i3.Root != i1.Root || in.Len() != n {
+		t.Errorf("interning the same document again made new subtrees")
+	}
+	if again := in.Intern(d2.Root().Export()); again != i2.Root {
+		t.Errorf("Intern did not find the interned tree")
+	}
+}
+
+func TestValidator(t *testing.T) {
+	src := `<a><b/><c><b/><b x="1"/></c></a>`
+	events := []string{}
+	v := ValidatorFuncs{
+		OnOpen: func(e *Element) error {
+			events = append(events, "open "+e.Path())
+			if e.Parent() != nil && len(e.Parent().Children()) > 1 {
+				t.Errorf("%s is in its parent before it is closed", e.Path())
+			}
+			return nil
+		},
+		OnClose: func(e *Element) error {
+			events = append(events, "close "+e.Path())
+			return nil
+		},
+	}
+	doc, err := Parse(strings.NewReader(src), WithValidator(v))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "open /a,open /a/b,close /a/b,open /a/c,open /a/c/b,close /a/c/b,open /a/c/b[2],close /a/c/b[2],close /a/c,close /a"
+	if got := strings.Join(events, ","); got != expect {
+		t.Errorf("Expected %s, got %s", expect, got)
+	}
+	if got := doc.Root().Children()[1].Children()[1].Path(); got != "/a/c/b[2]" {
+		t.Errorf("Unexpected path %s", got)
+	}
+	// An error stops the parse where it was found.
+	bad := errors.New("x is not allowed")
+	closed := 0
+	v = ValidatorFuncs{
+		OnOpen: func(e *Element) error {
+			if len(e.GetAttr("x", "*", "*")) > 0 {
+				return bad
+			}
+			return nil
+		},
+		OnClose: func(e *Element) error {
+			closed++
+			return nil
+		},
+	}
+	_, err = Parse(strings.NewReader(src), WithValidator(v))
+	var perr *Error
+	if !errors.Is(err, bad) || !errors.As(err, &perr) {
+		t.Fatalf("Expected the validator's error, got %v", err)
+	}
+	if perr.Op != "validate" || perr.Path != "/a/c/b" || perr.Pos.Column != 15 || closed != 2 {
+		t.Errorf("Unexpected error %v after %d elements", err, closed)
+	}
+}
+
+func ExampleDocument_EncodePreserving() {
+	src := "<config>\n  <port>8080</port> <!-- the default -->\n  <host   name='example.com'/>\n</config>"
+	doc, err := Parse(strings.NewReader(src), WithKeepSource())
+	if err != nil {
+		log.Fatal(err)
+	}
+	doc.Root().Child(0).SetInt(8081)
+	if err := doc.EncodePreserving(os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+	// Output:
+	// <config>
+	//   <port>8081</port> <!-- the default -->
+	//   <host   name='example.com'/>
+	// </config>
 }
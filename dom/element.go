@@ -1,10 +1,15 @@
 package dom
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/xml"
 	"fmt"
+	"io"
 	"log"
+	"sort"
+	"time"
+	"unicode/utf8"
 )
 
 // Element represents a node in an XML document.
@@ -18,17 +23,52 @@ type Element struct {
 	// instead of representing Text nodes seperately.
 	Content    []byte
 	Attributes []xml.Attr
+	// gen is bumped whenever the tree rooted at this element is changed
+	// through one of our methods.  It is only meaningful on the topmost
+	// element of a tree.
+	gen uint64
+	pos Position
+	// uri is the URI of the document the tree was read from.  Like gen,
+	// it is only meaningful on the topmost element.
+	uri string
+	// spill, if set, is where the children are in a Spool.
+	spill *spilled
+	// stream, if set, is where the text is while Content is nil.
+	stream *streamed
+	// refs, if set, is where the text holds EntityRefs.
+	refs *entityRefs
 }
 
 // CreateElement creates a new element with the passed-in xml.Name.
 // The created Element has no parent, no children, no content, and no
-// attributes.
+// attributes.  Nothing is allocated for children or attributes until
+// they are added; use Grow to make room for them up front.
 func CreateElement(n xml.Name) *Element {
-	return &Element{
-		Name:       n,
-		children:   []*Element{},
-		Attributes: []xml.Attr{},
+	return &Element{Name: n}
+}
+
+// ReserveChildren makes room for node to hold n children in total without
+// reallocating, for code that builds very wide elements one child at a
+// time.  The return value is node.
+func (node *Element) ReserveChildren(n int) *Element {
+	return node.Grow(n-len(node.children), 0)
+}
+
+// Grow makes room for at least children more children and attrs more
+// Attributes, so that adding that many will not have to reallocate.
+// The return value is node.
+func (node *Element) Grow(children, attrs int) *Element {
+	if free := cap(node.children) - len(node.children); children > free {
+		c := make([]*Element, len(node.children), len(node.children)+children)
+		copy(c, node.children)
+		node.children = c
+	}
+	if free := cap(node.Attributes) - len(node.Attributes); attrs > free {
+		a := make([]xml.Attr, len(node.Attributes), len(node.Attributes)+attrs)
+		copy(a, node.Attributes)
+		node.Attributes = a
 	}
+	return node
 }
 
 // Attr creates a new xml.Attr.  It is exactly equivalent to creating a new
@@ -68,14 +108,27 @@ func ElemC(name, space, content string) *Element {
 // child will be reparented if needed.
 // The return value is node.
 func (node *Element) AddChild(child *Element) *Element {
+	node.pageIn()
 	if child.parent != nil {
 		child.parent.RemoveChild(child)
+	} else {
+		child.touch()
 	}
 	child.parent = node
 	node.children = append(node.children, child)
+	node.touch()
 	return node
 }
 
+// touch records that the tree node is in has changed.
+func (node *Element) touch() {
+	top := node
+	for top.parent != nil {
+		top = top.parent
+	}
+	top.gen++
+}
+
 // GetAttr returns all the matching Attrs on the node.
 func (node *Element) GetAttr(name, space, val string) []xml.Attr {
 	res := []xml.Attr{}
@@ -104,10 +157,12 @@ func (node *Element) AddChildren(children ...*Element) *Element {
 // node will be returned.
 func (node *Element) Replace(other *Element) *Element {
 	node.Name = other.Name
-	node.Content = other.Content
+	node.Content, node.stream = other.Content, other.stream
 	node.Attributes = other.Attributes
-	node.children = []*Element{}
-	node.AddChildren(other.children...)
+	node.refs = other.refs
+	node.children, node.spill = nil, nil
+	node.AddChildren(other.Children()...)
+	node.touch()
 	return node
 }
 
@@ -127,18 +182,149 @@ func (node *Element) RemoveChild(child *Element) *Element {
 		return nil
 	}
 
+	node.touch()
 	copy(node.children[p:], node.children[p+1:])
 	node.children = node.children[0 : len(node.children)-1]
 	child.parent = nil
 	return child
 }
 
-// Children returns all the children of node.
+// ReplaceChild puts with in place of child among the children of node,
+// reparenting them as needed.  The replaced child will be returned if it
+// was actually a child of node, otherwise nil will be returned and node
+// is left unchanged.
+func (node *Element) ReplaceChild(child *Element, with ...*Element) *Element {
+	children := node.Children()
+	for i, v := range children {
+		if v != child {
+			continue
+		}
+		for _, c := range children[i:] {
+			node.RemoveChild(c)
+		}
+		node.AddChildren(with...)
+		node.AddChildren(children[i+1:]...)
+		return child
+	}
+	return nil
+}
+
+// Children returns all the children of node.  The slice is a snapshot
+// that belongs to the caller: it does not change when children are added
+// to or removed from node, and changing it does not change node.  The
+// Elements in it are not copies, though, so changes made to them show up
+// in the tree.  To look at the children without allocating anything, use
+// NumChildren and Child.
 func (node *Element) Children() (res []*Element) {
+	node.pageIn()
 	res = make([]*Element, 0, len(node.children))
 	return append(res, node.children...)
 }
 
+// NumChildren returns the number of children node has.
+func (node *Element) NumChildren() int {
+	node.pageIn()
+	return len(node.children)
+}
+
+// Child returns the i'th child of node, counting from 0.  Unlike
+// Children, it looks at the live tree, so adding or removing children
+// shifts the ones that come after them, as in:
+//    for i := 0; i < node.NumChildren(); i++ {
+//        c := node.Child(i)
+//        ...
+//    }
+// It panics if i is out of range, as indexing a slice would.
+func (node *Element) Child(i int) *Element {
+	node.pageIn()
+	return node.children[i]
+}
+
+// RemoveAll removes every descendant of node that match returns true
+// for, and returns the removed elements in document order.  Elements
+// inside a removed element go with it, and are neither passed to match
+// nor returned.  The whole tree is looked at before anything is removed,
+// so match sees it as it was.  A query from the search package, such as
+// search.Tag, can be used as match.
+func (node *Element) RemoveAll(match func(*Element) bool) []*Element {
+	res := []*Element{}
+	var walk func(e *Element)
+	walk = func(e *Element) {
+		e.pageIn()
+		for _, c := range e.children {
+			if match(c) {
+				res = append(res, c)
+			} else {
+				walk(c)
+			}
+		}
+	}
+	walk(node)
+	if len(res) == 0 {
+		return res
+	}
+	removed := make(map[*Element]bool, len(res))
+	for _, e := range res {
+		removed[e] = true
+	}
+	for _, e := range res {
+		parent := e.parent
+		if parent == nil {
+			// Already compacted.
+			continue
+		}
+		kept := parent.children[:0]
+		for _, c := range parent.children {
+			if removed[c] {
+				c.parent = nil
+			} else {
+				kept = append(kept, c)
+			}
+		}
+		for i := len(kept); i < len(parent.children); i++ {
+			parent.children[i] = nil
+		}
+		parent.children = kept
+	}
+	node.touch()
+	return res
+}
+
+// ChildrenMap returns the children of node grouped by their local name,
+// each group in document order.  It is handy for reading record-like
+// XML, as in:
+//    fields := record.ChildrenMap()
+//    for _, phone := range fields["phone"] {
+//        ...
+//    }
+func (node *Element) ChildrenMap() map[string][]*Element {
+	res := map[string][]*Element{}
+	node.pageIn()
+	for _, c := range node.children {
+		res[c.Name.Local] = append(res[c.Name.Local], c)
+	}
+	return res
+}
+
+// GroupChildren returns the children of node grouped by the value of
+// their attribute with the local name byAttr, each group in document
+// order.  Children that do not have the attribute are grouped under "".
+func (node *Element) GroupChildren(byAttr string) map[string][]*Element {
+	res := map[string][]*Element{}
+	node.pageIn()
+	for _, c := range node.children {
+		key := ""
+		for _, a := range c.Attributes {
+			if a.Name.Local == byAttr && a.Name.Space != "xmlns" {
+				key = a.Value
+				break
+			}
+		}
+		res[key] = append(res[key], c)
+	}
+	return res
+}
+
 // Descendants returns all descendants of node in breadth order.
 func (node *Element) Descendants() (res []*Element) {
 	res = make([]*Element, 0, len(node.children))
@@ -159,6 +345,11 @@ func (node *Element) All() []*Element {
 	return append([]*Element{node}, node.Descendants()...)
 }
 
+// Pos returns the position of node in the document it was parsed from.
+func (node *Element) Pos() Position {
+	return node.pos
+}
+
 // Parent returns the parent of this node. If there is no parent, returns nil.
 func (node *Element) Parent() *Element {
 	return node.parent
@@ -176,22 +367,59 @@ func (node *Element) Ancestors() (res []*Element) {
 	return res
 }
 
+// Path returns a /-separated path to node from the top of its tree.  Each
+// step is a local name, with a 1-based index if the element has siblings
+// with the same name.  An element a Validator is given, which is not
+// among the children of its parent yet, counts as coming after them.
+func (node *Element) Path() string {
+	steps := []string{}
+	for n := node; n != nil; n = n.parent {
+		step := n.Name.Local
+		if n.parent != nil {
+			idx, count := 0, 0
+			for _, c := range n.parent.children {
+				if c.Name == n.Name {
+					count++
+					if c == n {
+						idx = count
+					}
+				}
+			}
+			if idx == 0 {
+				count++
+				idx = count
+			}
+			if count > 1 {
+				step = fmt.Sprintf("%s[%d]", step, idx)
+			}
+		}
+		steps = append(steps, step)
+	}
+	res := ""
+	for i := len(steps) - 1; i >= 0; i-- {
+		res += "/" + steps[i]
+	}
+	return res
+}
+
 // AddAttr adds attr to node.
 // Duplicates are ignored. If attr has the same
 // name as a preexisting attribute, then it will replace
 // the preexsting attribute.
 // Return is node.
 func (node *Element) AddAttr(attr xml.Attr) *Element {
-	for _, a := range node.Attributes {
+	for i, a := range node.Attributes {
 		if a == attr {
 			return node
 		}
 		if a.Name == attr.Name {
-			a.Value = attr.Value
+			node.Attributes[i].Value = attr.Value
+			node.touch()
 			return node
 		}
 	}
 	node.Attributes = append(node.Attributes, attr)
+	node.touch()
 	return node
 }
 
@@ -213,7 +441,10 @@ func (node *Element) SetParent(parent *Element) *Element {
 	return node
 }
 
-func (node *Element) addNamespaces(encoder *Encoder) {
+// addNamespaces binds the namespaces of the tree rooted at node, reading
+// the parts of it that are spilled without paging them in, so that none
+// are left to bind once encoding has started.
+func (node *Element) addNamespaces(encoder *Encoder) error {
 	// See if any of our attribs are in the xmlns namespace.
 	// If they are, try to add them with their prefix
 	for _, a := range node.Attributes {
@@ -226,9 +457,16 @@ func (node *Element) addNamespaces(encoder *Encoder) {
 	for _, a := range node.Attributes {
 		encoder.addNamespace(a.Name.Space, "")
 	}
-	for _, c := range node.children {
-		c.addNamespaces(encoder)
+	children, err := node.kids()
+	if err != nil {
+		return err
 	}
+	for _, c := range children {
+		if err := c.addNamespaces(encoder); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func namespacedName(e *Encoder, name xml.Name) string {
@@ -238,6 +476,9 @@ func namespacedName(e *Encoder, name xml.Name) string {
 	if name.Space == "xmlns" {
 		return name.Space + ":" + name.Local
 	}
+	if name.Space == NS_XML {
+		return "xml:" + name.Local
+	}
 	prefix, found := e.nsURLMap[name.Space]
 	if !found {
 		log.Panicf("No prefix found in %v for namespace %s", e.nsURLMap, name.Space)
@@ -246,40 +487,90 @@ func namespacedName(e *Encoder, name xml.Name) string {
 }
 
 // Encode encodes an element using the passed-in Encoder. If an error occurs
-// during encoding, that error is returned.
+// during encoding, it is returned as an *Error.
 func (node *Element) Encode(e *Encoder) (err error) {
+	defer func() {
+		if _, ok := err.(*Error); err != nil && !ok {
+			err = &Error{Op: "encode", Path: node.Path(), Err: err}
+		}
+	}()
+	if e.ctx != nil {
+		if err = e.ctx.Err(); err != nil {
+			return err
+		}
+	}
 	// This could use some refactoring. but it works Well Enough(tm)
 	writeNamespaces := !e.started
+	if writeNamespaces && e.strict {
+		if err = node.wellFormedTree(e.xml11); err != nil {
+			return err
+		}
+	}
+	var prefixes []string
 	if writeNamespaces {
-		node.addNamespaces(e)
+		if err = node.addNamespaces(e); err != nil {
+			return err
+		}
 		e.started = true
+		// Declare the prefixes in order, so that the same tree
+		// always encodes the same way.
+		prefixes = make([]string, 0, len(e.nsPrefixMap))
+		for prefix := range e.nsPrefixMap {
+			prefixes = append(prefixes, prefix)
+		}
+		sort.Strings(prefixes)
+		if e.report != nil {
+			e.begun = time.Now()
+			defer func() {
+				if err == nil {
+					e.done()
+				}
+			}()
+		}
+		if e.workers > 1 {
+			e.sizes = map[*Element]int{}
+			countElements(node, e.sizes)
+		}
+	} else if prefixes = e.bindLocal(node); prefixes != nil {
+		defer e.unbind(prefixes)
 	}
-	err = e.spaces()
-	if err != nil {
-		return err
-	}
-	_, err = fmt.Fprintf(e, "<%s", namespacedName(e, node.Name))
-	if err != nil {
-		return err
-	}
-	for _, a := range node.Attributes {
-		if a.Name.Space == "xmlns" {
-			continue
+	if node.spill != nil {
+		// Read the children for as long as it takes to encode them.
+		if node.children, err = node.kids(); err != nil {
+			return err
 		}
-		_, err = fmt.Fprintf(e, " %s=\"%s\"", namespacedName(e, a.Name), a.Value)
+		defer func() { node.children = nil }()
+	}
+	// Leaves that do not fit in the width get their Content on a line
+	// of its own.
+	broken := false
+	if e.width > 0 && len(node.children) == 0 && len(node.Content) > 0 {
+		line, err := node.leaf(e, prefixes)
 		if err != nil {
 			return err
 		}
-	}
-	if writeNamespaces {
-		for prefix, uri := range e.nsPrefixMap {
-			_, err = fmt.Fprintf(e, " xmlns:%s=\"%s\"", prefix, uri)
-			if err != nil {
+		if e.depth*utf8.RuneCountInString(e.indent)+utf8.RuneCount(line) <= e.width && !bytes.ContainsRune(line, '\n') ||
+			len(bytes.TrimSpace(node.Content)) != len(node.Content) {
+			if err = e.spaces(); err != nil {
 				return err
 			}
+			if _, err = e.Write(line); err != nil {
+				return err
+			}
+			e.encoded(1)
+			return e.prettyEnd()
 		}
+		broken = true
+	}
+	err = e.spaces()
+	if err != nil {
+		return err
 	}
-	if len(node.children) == 0 && len(node.Content) == 0 {
+	if err = node.startTag(e, prefixes); err != nil {
+		return err
+	}
+	e.encoded(1)
+	if len(node.children) == 0 && len(node.Content) == 0 && !node.ContentStreamed() {
 		ctag := "/>"
 		if e.pretty {
 			ctag = "/>\n"
@@ -290,19 +581,48 @@ func (node *Element) Encode(e *Encoder) (err error) {
 		}
 		return
 	}
-	_, err = e.WriteString(">")
+	if _, err = e.WriteString(">"); err != nil {
+		return err
+	}
+	if broken {
+		e.depth++
+		if err = e.prettyEnd(); err != nil {
+			return err
+		}
+		if err = e.spaces(); err != nil {
+			return err
+		}
+	}
 	if len(node.Content) > 0 {
-		xml.EscapeText(e, node.Content)
+		if err = escapeRefs(e, node.Content, node.contentRefs()); err != nil {
+			return err
+		}
+	} else if node.ContentStreamed() {
+		if err = node.encodeStream(e); err != nil {
+			return err
+		}
+	}
+	if broken {
+		e.depth--
+		if err = e.prettyEnd(); err != nil {
+			return err
+		}
+		if err = e.spaces(); err != nil {
+			return err
+		}
 	}
 	if len(node.children) > 0 {
 		e.depth++
 		if err = e.prettyEnd(); err != nil {
 			return err
 		}
-		for _, c := range node.children {
-			if err = c.Encode(e); err != nil {
-				return err
-			}
+		if e.maxDepth > 0 && e.depth >= e.maxDepth {
+			err = e.elide(node)
+		} else {
+			err = e.encodeChildren(node)
+		}
+		if err != nil {
+			return err
 		}
 		e.depth--
 		if err = e.spaces(); err != nil {
@@ -316,6 +636,57 @@ func (node *Element) Encode(e *Encoder) (err error) {
 	return e.prettyEnd()
 }
 
+// startTag writes the start tag of node, but for the > that ends it,
+// declaring prefixes on it.
+func (node *Element) startTag(e *Encoder, prefixes []string) error {
+	if _, err := fmt.Fprintf(e, "<%s", namespacedName(e, node.Name)); err != nil {
+		return err
+	}
+	for _, a := range node.Attributes {
+		if a.Name.Space == "xmlns" {
+			continue
+		}
+		if _, err := fmt.Fprintf(e, " %s=\"", namespacedName(e, a.Name)); err != nil {
+			return err
+		}
+		if err := escapeRefs(e, []byte(a.Value), node.attrRefs(a.Name)); err != nil {
+			return err
+		}
+		if err := e.WriteByte('"'); err != nil {
+			return err
+		}
+	}
+	for _, prefix := range prefixes {
+		if _, err := fmt.Fprintf(e, " xmlns:%s=\"", prefix); err != nil {
+			return err
+		}
+		if err := xml.EscapeText(e, []byte(e.nsPrefixMap[prefix])); err != nil {
+			return err
+		}
+		if err := e.WriteByte('"'); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// leaf returns node, which has Content but no children, encoded on one
+// line as e would encode it.
+func (node *Element) leaf(e *Encoder, prefixes []string) ([]byte, error) {
+	var b bytes.Buffer
+	line := &Encoder{Writer: bufio.NewWriter(&b), nsPrefixMap: e.nsPrefixMap, nsURLMap: e.nsURLMap, xml11: e.xml11}
+	if err := node.startTag(line, prefixes); err != nil {
+		return nil, err
+	}
+	line.WriteByte('>')
+	if err := escapeRefs(line, node.Content, node.contentRefs()); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(line, "</%s>", namespacedName(line, node.Name))
+	err := line.Flush()
+	return b.Bytes(), err
+}
+
 // Bytes returns a pretty-printed XML encoding of this part of the tree.
 // The return is a byte array.
 func (node *Element) Bytes() []byte {
@@ -332,3 +703,48 @@ func (node *Element) Bytes() []byte {
 func (node *Element) String() string {
 	return string(node.Bytes())
 }
+
+// StringDepth is like String, but only shows depth levels of the tree,
+// starting with node.  The children of the elements on the last level
+// are replaced with a comment saying how many elements were left out.
+func (node *Element) StringDepth(depth int) string {
+	return node.StringLimit(depth, 0)
+}
+
+// StringLimit is like StringDepth, but also cuts the output short with
+// "..." once it is size bytes long, so that logging an element cannot
+// flood the logs.  A depth or size of 0 means no limit.
+func (node *Element) StringLimit(depth, size int) string {
+	var b bytes.Buffer
+	l := &limitWriter{w: &b, n: size}
+	e := NewEncoder(&b, WithPretty())
+	if size > 0 {
+		e = NewEncoder(l, WithPretty())
+	}
+	e.maxDepth = depth
+	// Encoding stops with an error once l is full.
+	node.Encode(e)
+	e.Flush()
+	if l.full {
+		b.WriteString("...")
+	}
+	return b.String()
+}
+
+// limitWriter writes the first n bytes written to it to w, and then
+// fails.
+type limitWriter struct {
+	w    io.Writer
+	n    int
+	full bool
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if len(p) > l.n {
+		l.w.Write(p[:l.n])
+		l.n, l.full = 0, true
+		return 0, io.ErrShortWrite
+	}
+	l.n -= len(p)
+	return l.w.Write(p)
+}
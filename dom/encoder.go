@@ -2,10 +2,13 @@ package dom
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"log"
-	"net/url"
+	"sort"
+	"strings"
+	"time"
 )
 
 // Encoder holds the state needed to encode the DOM into
@@ -14,21 +17,57 @@ type Encoder struct {
 	*bufio.Writer
 	depth           int
 	pretty          bool
+	indent          string
+	strict          bool
 	started         bool
 	namespacesAdded int
 	nsPrefixMap     map[string]string
 	nsURLMap        map[string]string
+	// workers and sizes are used in parallel mode.  sizes holds the
+	// number of elements in each subtree of the tree being encoded.
+	workers int
+	sizes   map[*Element]int
+	// ctx, if set, is checked before each element is encoded.
+	ctx context.Context
+	// maxDepth, if set, is the number of levels of the tree to encode.
+	maxDepth int
+	// width, if set, is how wide the lines leaves are written on can
+	// get before their Content is put on a line of its own.
+	width int
+	// out is the writer the Encoder was made for, and counter counts
+	// what is written to it once Progress is set.
+	out     io.Writer
+	counter *byteCounter
+	// report is called every every elements, as Progress says.
+	// elements is how many have been encoded, reported how many there
+	// were the last time report was called, and begun when the first
+	// one was.
+	report             func(Progress)
+	every              int
+	elements, reported int
+	begun              time.Time
+	// xml11 is set once an XML 1.1 declaration has been written, and
+	// makes text and attribute values be escaped as 1.1 allows.
+	xml11 bool
 }
 
 // NewEncoder returns a new Encoder that will output to the
-// passed-in io.Writer.
+// passed-in io.Writer, set up as opts say.
 //
 // The encoded docuemnt will have all namespace declarations lifted to the
-// root element of the document.
-func NewEncoder(writer io.Writer) *Encoder {
-	res := &Encoder{Writer: bufio.NewWriter(writer)}
+// root element of the document, sorted by prefix, so that the same tree
+// always encodes to the same bytes.  Namespaces get the prefixes the
+// xmlns attributes in the tree give them, or made up ones, ns1, ns2 and
+// so on, if there are none.  Elements encoded with the same Encoder
+// after the first, which have no root to declare namespaces on, declare
+// the ones they need that it did not on themselves, in the same way.
+func NewEncoder(writer io.Writer, opts ...Option) *Encoder {
+	res := &Encoder{Writer: bufio.NewWriter(writer), indent: " ", out: writer}
 	res.nsPrefixMap = make(map[string]string)
 	res.nsURLMap = make(map[string]string)
+	if len(opts) > 0 {
+		newSettings(opts).apply(res)
+	}
 	return res
 }
 
@@ -40,33 +79,118 @@ func (e *Encoder) Pretty() {
 	e.pretty = true
 }
 
-func (e *Encoder) addNamespace(ns string, prefix string) {
+// Indent puts the passed Encoder into pretty-print mode, using indent
+// for each level of nesting instead of a single space.
+func (e *Encoder) Indent(indent string) {
 	if e.started {
-		log.Panic("Cannot add element namespaces after encoding starts!")
+		log.Panic("xml: Encoding has started, cannot set Indent")
 	}
-	if ns == "" || ns == "xmlns" {
-		return
+	e.pretty = true
+	e.indent = indent
+}
+
+// Width puts the passed Encoder into pretty-print mode, and makes it keep
+// elements with Content but no children on one line, as in
+//    <port>8080</port>
+// only if that line, indentation included, is no more than width
+// characters wide.  Longer ones are written with their Content on a line
+// of its own, indented one level further, in the style of hand-edited
+// configuration files.  Since the parser trims the whitespace around
+// Content, this does not change what they parse to, but leaves whose
+// Content starts or ends with whitespace are kept on one line anyway.
+func (e *Encoder) Width(width int) {
+	if e.started {
+		log.Panic("xml: Encoding has started, cannot set Width")
 	}
-	if prefix != "" {
-		if _, found := e.nsURLMap[ns]; found {
-			delete(e.nsPrefixMap, e.nsURLMap[ns])
-			delete(e.nsURLMap, prefix)
-		}
-		e.nsPrefixMap[prefix] = ns
-		e.nsURLMap[ns] = prefix
-		return
+	e.pretty = true
+	e.width = width
+}
+
+// Strict puts the passed Encoder into strict mode, where trees are
+// checked with WellFormed before anything is written, and encoding fails
+// if they would not produce well-formed XML.
+func (e *Encoder) Strict() {
+	if e.started {
+		log.Panic("xml: Encoding has started, cannot set Strict flag")
+	}
+	e.strict = true
+}
+
+func (e *Encoder) addNamespace(ns string, prefix string) {
+	if e.started {
+		log.Panic("Cannot add element namespaces after encoding starts!")
 	}
+	e.bind(ns, prefix)
+}
 
+// bind gives ns a prefix, prefix itself if it can, unless ns has one
+// already, and returns the prefix it gave, or "" if it gave none.
+func (e *Encoder) bind(ns string, prefix string) string {
+	// The xml prefix is always bound, and must not be declared.
+	if ns == "" || ns == "xmlns" || ns == NS_XML {
+		return ""
+	}
 	if _, found := e.nsURLMap[ns]; found {
-		return
+		return ""
 	}
-	if _, err := url.Parse(ns); err != nil {
-		log.Panic(err)
+	// All the declarations end up on the root, so a prefix can only be
+	// bound once.  If it is bound to another namespace elsewhere in the
+	// tree, ns gets a prefix of its own.
+	// Names the parser let through, such as xmlns:0, cannot be used.
+	if _, found := e.nsPrefixMap[prefix]; prefix == "" || found || !IsNCName(prefix) || strings.HasPrefix(strings.ToLower(prefix), "xml") {
+		for {
+			e.namespacesAdded++
+			prefix = fmt.Sprintf("ns%v", e.namespacesAdded)
+			if _, found := e.nsPrefixMap[prefix]; !found {
+				break
+			}
+		}
 	}
-	prefix = fmt.Sprintf("ns%v", e.namespacesAdded)
-	e.namespacesAdded++
 	e.nsPrefixMap[prefix] = ns
 	e.nsURLMap[ns] = prefix
+	return prefix
+}
+
+// bindLocal gives prefixes to the namespaces node uses that the encoder
+// has none for, which happens when a tree is encoded after encoding has
+// started, and returns the prefixes it gave, sorted.  They are declared
+// on node, and only hold inside it.
+func (e *Encoder) bindLocal(node *Element) (prefixes []string) {
+	add := func(ns, prefix string) {
+		if p := e.bind(ns, prefix); p != "" {
+			prefixes = append(prefixes, p)
+		}
+	}
+	for _, a := range node.Attributes {
+		if a.Name.Space == "xmlns" {
+			add(a.Value, a.Name.Local)
+		}
+	}
+	add(node.Name.Space, "")
+	for _, a := range node.Attributes {
+		add(a.Name.Space, "")
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+// unbind drops the prefixes bindLocal gave.
+func (e *Encoder) unbind(prefixes []string) {
+	for _, p := range prefixes {
+		delete(e.nsURLMap, e.nsPrefixMap[p])
+		delete(e.nsPrefixMap, p)
+	}
+}
+
+// elide writes a comment in place of the children of node.
+func (e *Encoder) elide(node *Element) error {
+	if err := e.spaces(); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(e, "<!-- %d elements left out -->", len(node.Descendants())); err != nil {
+		return err
+	}
+	return e.prettyEnd()
 }
 
 func (e *Encoder) prettyEnd() error {
@@ -80,7 +204,7 @@ func (e *Encoder) prettyEnd() error {
 func (e *Encoder) spaces() error {
 	if e.pretty {
 		for i := 0; i < e.depth; i++ {
-			if _, err := e.WriteString(" "); err != nil {
+			if _, err := e.WriteString(e.indent); err != nil {
 				return err
 			}
 		}
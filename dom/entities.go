@@ -0,0 +1,217 @@
+package dom
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// Entity references are kept in Content and attribute values as the name
+// of the entity between these two private use characters.  Only the text
+// an Element says holds EntityRefs is read that way; see SetEntityRefs.
+const (
+	refStart = "\uE000"
+	refEnd   = "\uE001"
+)
+
+// EntityRef returns the text that stands for a reference to the named
+// entity in Content and attribute values, which the Encoder writes back
+// out as &name; where the Element says there are EntityRefs.  See
+// ParseOptions.KeepEntityRefs and Element.SetEntityRefs.
+func EntityRef(name string) string {
+	return refStart + name + refEnd
+}
+
+// entityRefs is where an Element holds EntityRefs: in its Content if
+// content is set, and in the values of the attributes named in attrs.
+type entityRefs struct {
+	content bool
+	attrs   []xml.Name
+}
+
+// SetEntityRefs says whether the Content of node holds EntityRefs, and
+// which of its attributes do, in place of what was said before.  The
+// parser says so of the text it keeps references in, and trees that are
+// built with EntityRefs have to say so themselves:
+//    p := ElemC("p", "", "a "+EntityRef("nbsp")+" b").SetEntityRefs(true)
+// Anywhere else, text that looks like an EntityRef is only text, and the
+// Encoder writes the characters it is made of as character references.
+func (node *Element) SetEntityRefs(content bool, attrs ...xml.Name) *Element {
+	node.refs = nil
+	if content || len(attrs) > 0 {
+		node.refs = &entityRefs{content: content, attrs: append([]xml.Name(nil), attrs...)}
+	}
+	return node
+}
+
+// HasEntityRefs reports whether the Content of node holds EntityRefs, and
+// which of its attributes do.
+func (node *Element) HasEntityRefs() (content bool, attrs []xml.Name) {
+	if node.refs == nil {
+		return false, nil
+	}
+	return node.refs.content, append([]xml.Name(nil), node.refs.attrs...)
+}
+
+// contentRefs reports whether the Content of node holds EntityRefs.
+func (node *Element) contentRefs() bool {
+	return node.refs != nil && node.refs.content
+}
+
+// attrRefs reports whether the value of the attribute called name holds
+// EntityRefs.
+func (node *Element) attrRefs(name xml.Name) bool {
+	return node.refs.attr(name)
+}
+
+// attr reports whether the value of the attribute called name holds
+// EntityRefs, if r, which may be nil, says where they are.
+func (r *entityRefs) attr(name xml.Name) bool {
+	if r == nil {
+		return false
+	}
+	for _, n := range r.attrs {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sameRefs reports whether a and b, either of which may be nil, say the
+// same text holds EntityRefs.
+func sameRefs(a, b *entityRefs) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.content != b.content || len(a.attrs) != len(b.attrs) {
+		return false
+	}
+	for i := range a.attrs {
+		if a.attrs[i] != b.attrs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// findRefs records which of the text of e, which was parsed with
+// KeepEntityRefs, holds the EntityRefs it was given.
+func findRefs(e *Element) {
+	content := bytes.Contains(e.Content, []byte(refStart))
+	var attrs []xml.Name
+	for _, a := range e.Attributes {
+		if strings.Contains(a.Value, refStart) {
+			attrs = append(attrs, a.Name)
+		}
+	}
+	e.refs = nil
+	if content || attrs != nil {
+		e.refs = &entityRefs{content: content, attrs: attrs}
+	}
+}
+
+// EntityRefs returns the names of the entities referred to in s, which
+// is Content or an attribute value, in order.
+func EntityRefs(s string) []string {
+	res := []string{}
+	for {
+		i := strings.Index(s, refStart)
+		if i < 0 {
+			return res
+		}
+		s = s[i+len(refStart):]
+		j := strings.Index(s, refEnd)
+		if j < 0 {
+			return res
+		}
+		if name := s[:j]; isName(name) {
+			res = append(res, name)
+		}
+		s = s[j+len(refEnd):]
+	}
+}
+
+// isName reports whether s is an XML Name, which entity names must be.
+func isName(s string) bool {
+	for _, part := range strings.Split(s, ":") {
+		if part != "" && !IsNCName(part) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// maxEntityName is how long an entity name entityScanner looks for can
+// be.
+const maxEntityName = 256
+
+// entityScanner watches the input going to a Decoder for references to
+// entities, and adds the ones the Decoder would not know about to its
+// Entity map before it gets to them, so that they come out of it as
+// EntityRefs.
+type entityScanner struct {
+	r      io.Reader
+	entity map[string]string
+	inRef  bool
+	name   []byte
+}
+
+var predefined = map[string]bool{"lt": true, "gt": true, "amp": true, "apos": true, "quot": true}
+
+func (s *entityScanner) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	for _, c := range p[:n] {
+		switch {
+		case c == '&':
+			s.inRef, s.name = true, s.name[:0]
+		case !s.inRef:
+		case c == ';':
+			s.inRef = false
+			if name := string(s.name); !predefined[name] && isName(name) {
+				s.entity[name] = EntityRef(name)
+			}
+		case c <= ' ' || c == '<' || c == '>' || c == '"' || c == '\'' || len(s.name) == maxEntityName:
+			s.inRef = false
+		default:
+			s.name = append(s.name, c)
+		}
+	}
+	return n, err
+}
+
+// escapeRefs writes s escaped as escapeText does.  If refs is set, the
+// EntityRefs in s are written as entity references.  The characters
+// EntityRefs are made of are written as character references anywhere
+// else, so that they cannot be read back as EntityRefs.
+func escapeRefs(w *Encoder, s []byte, refs bool) error {
+	for len(s) > 0 {
+		i := bytes.IndexAny(s, refStart+refEnd)
+		if i < 0 {
+			return escapeText(w, s)
+		}
+		if err := escapeText(w, s[:i]); err != nil {
+			return err
+		}
+		s = s[i:]
+		if refs && bytes.HasPrefix(s, []byte(refStart)) {
+			if j := bytes.Index(s, []byte(refEnd)); j >= len(refStart) && isName(string(s[len(refStart):j])) {
+				if _, err := w.Write([]byte("&" + string(s[len(refStart):j]) + ";")); err != nil {
+					return err
+				}
+				s = s[j+len(refEnd):]
+				continue
+			}
+		}
+		ref := "&#xE000;"
+		if bytes.HasPrefix(s, []byte(refEnd)) {
+			ref = "&#xE001;"
+		}
+		if _, err := w.WriteString(ref); err != nil {
+			return err
+		}
+		s = s[len(refStart):]
+	}
+	return nil
+}
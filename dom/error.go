@@ -0,0 +1,88 @@
+package dom
+
+import (
+	"errors"
+	"fmt"
+)
+
+// TooManyRootElements is the message of ErrTooManyRootElements, and of
+// the Errors caused by it.
+//
+// Deprecated: use errors.Is with ErrTooManyRootElements.
+const TooManyRootElements = "More than one root Element not allowed!"
+
+// ErrTooManyRootElements is the cause of the Error Parse returns for
+// documents with more than one root element.
+var ErrTooManyRootElements = errors.New(TooManyRootElements)
+
+// ErrNoRootElement is the cause of the Error WellFormed returns for
+// documents without a root element.
+var ErrNoRootElement = errors.New("document has no root element")
+
+// Error is the error returned when parsing, encoding or checking a tree
+// fails.  Use errors.Is and errors.As on it to get at the cause, which is
+// one of the Err variables in this package, a *TreeTooLargeError, a
+// *TreeTooDeepError, an *xml.SyntaxError, or whatever error reading or
+// writing returned.
+type Error struct {
+	// Op is what was being done: "parse", "encode", "check",
+	// "validate", for a ParseOptions.Validator, or "spill", for reading
+	// or writing a Spool.
+	Op string
+	// Pos is where in the input the problem was found, if it was found
+	// by the parser.
+	Pos Position
+	// Path is the path to the element the problem was found in, in the
+	// same format as Element.Path, or "" if it was found outside of all
+	// elements.  Elements in the paths the parser and StreamWriter
+	// report have no [n] suffixes.
+	Path string
+	// Err is the cause.
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Err == ErrTooManyRootElements {
+		// Callers have always compared this one with
+		// TooManyRootElements.
+		return TooManyRootElements
+	}
+	res := "dom: " + e.Op
+	if e.Path != "" {
+		res += " " + e.Path
+	}
+	if e.Pos.IsValid() {
+		res += " at " + e.Pos.String()
+	}
+	return res + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// TreeTooLargeError is the cause of the Error the parser returns when a
+// document would take up more than ParseOptions.MaxTreeBytes, or is
+// longer than MaxInputBytes.
+type TreeTooLargeError struct {
+	// Limit is the MaxTreeBytes or MaxInputBytes that was exceeded.
+	Limit int64
+	// Pos is where the element that went over MaxTreeBytes starts.  It
+	// is zero for the other limits.
+	Pos Position
+}
+
+func (e *TreeTooLargeError) Error() string {
+	return fmt.Sprintf("document takes up more than %d bytes", e.Limit)
+}
+
+// TreeTooDeepError is the cause of the Error the parser returns when
+// elements are nested deeper than ParseOptions.MaxDepth.
+type TreeTooDeepError struct {
+	// Limit is the MaxDepth that was exceeded.
+	Limit int
+}
+
+func (e *TreeTooDeepError) Error() string {
+	return fmt.Sprintf("elements are nested more than %d deep", e.Limit)
+}
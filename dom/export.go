@@ -0,0 +1,194 @@
+package dom
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/xml"
+)
+
+// ElementData is a copy of an Element tree made only of exported
+// fields, so that it can be encoded with encoding/gob, encoding/json or
+// anything else that works by reflection.
+type ElementData struct {
+	Name       xml.Name
+	Attributes []xml.Attr
+	Content    []byte
+	Children   []*ElementData
+	Pos        Position
+	// ContentRefs and RefAttrs say where the element holds EntityRefs,
+	// as Element.SetEntityRefs does.
+	ContentRefs bool
+	RefAttrs    []xml.Name
+}
+
+// DocumentData is a copy of a Document made only of exported fields.
+type DocumentData struct {
+	Doctype string
+	Root    *ElementData
+	// Prolog and Epilog are the comments and processing instructions
+	// around Root, and DoctypeAt is how many of Prolog come before
+	// Doctype.
+	Prolog, Epilog []Misc
+	DoctypeAt      int
+	Declaration    Declaration
+}
+
+// Export copies the tree rooted at node into an ElementData.
+func (node *Element) Export() *ElementData {
+	node.pageIn()
+	res := &ElementData{
+		Name:       node.Name,
+		Attributes: append([]xml.Attr(nil), node.Attributes...),
+		Content:    append([]byte(nil), node.Content...),
+		Pos:        node.pos,
+	}
+	res.ContentRefs, res.RefAttrs = node.HasEntityRefs()
+	for _, c := range node.children {
+		res.Children = append(res.Children, c.Export())
+	}
+	return res
+}
+
+// refs returns where data says the element holds EntityRefs.
+func (data *ElementData) refs() *entityRefs {
+	if !data.ContentRefs && len(data.RefAttrs) == 0 {
+		return nil
+	}
+	return &entityRefs{content: data.ContentRefs, attrs: data.RefAttrs}
+}
+
+// Import creates a new tree from data.
+func (data *ElementData) Import() *Element {
+	res := CreateElement(data.Name)
+	res.Attributes = append(res.Attributes, data.Attributes...)
+	res.Content = append([]byte(nil), data.Content...)
+	res.pos = data.Pos
+	res.SetEntityRefs(data.ContentRefs, data.RefAttrs...)
+	for _, c := range data.Children {
+		child := c.Import()
+		child.parent = res
+		res.children = append(res.children, child)
+	}
+	return res
+}
+
+// Clone returns a deep copy of the tree rooted at node, which is the top
+// of the copy.  The copy has the same Positions, and the same base URI
+// if node was at the top of a parsed tree.
+func (node *Element) Clone() *Element {
+	res := node.clone()
+	res.uri = node.uri
+	return res
+}
+
+func (node *Element) clone() *Element {
+	node.pageIn()
+	res := CreateElement(node.Name)
+	res.Attributes = append(res.Attributes, node.Attributes...)
+	if node.Content != nil {
+		res.Content = append([]byte(nil), node.Content...)
+	}
+	res.stream, res.refs = node.stream, node.refs
+	res.pos = node.pos
+	if len(node.children) > 0 {
+		res.children = make([]*Element, len(node.children))
+		for i, c := range node.children {
+			res.children[i] = c.clone()
+			res.children[i].parent = res
+		}
+	}
+	return res
+}
+
+// Clone returns a deep copy of doc, with its root element, XML and
+// DOCTYPE declarations, and the comments and processing instructions around the
+// root, so that documents can be made from a template without parsing
+// it again.
+func (doc *Document) Clone() *Document {
+	res := CreateDocument()
+	if doc.root != nil {
+		res.root = doc.root.Clone()
+	}
+	res.doctype = doc.doctype
+	res.prolog = append([]Misc(nil), doc.prolog...)
+	res.epilog = append([]Misc(nil), doc.epilog...)
+	res.doctypeAt = doc.doctypeAt
+	res.decl = doc.decl
+	return res
+}
+
+// Export copies doc into a DocumentData.
+func (doc *Document) Export() *DocumentData {
+	res := &DocumentData{
+		Doctype:     doc.doctype,
+		Prolog:      append([]Misc(nil), doc.prolog...),
+		Epilog:      append([]Misc(nil), doc.epilog...),
+		DoctypeAt:   doc.doctypeAt,
+		Declaration: doc.decl,
+	}
+	if doc.root != nil {
+		res.Root = doc.root.Export()
+	}
+	return res
+}
+
+// Import creates a new Document from data.
+func (data *DocumentData) Import() *Document {
+	res := CreateDocument()
+	res.doctype = data.Doctype
+	res.prolog = append([]Misc(nil), data.Prolog...)
+	res.epilog = append([]Misc(nil), data.Epilog...)
+	res.doctypeAt = data.DoctypeAt
+	res.decl = data.Declaration
+	if data.Root != nil {
+		res.root = data.Root.Import()
+	}
+	return res
+}
+
+func gobEncode(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, which also makes
+// Elements usable with encoding/gob.  The encoding is the gob encoding
+// of the ElementData for node, and is much cheaper to decode than XML.
+func (node *Element) MarshalBinary() ([]byte, error) {
+	return gobEncode(node.Export())
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.  It replaces
+// node with the tree encoded by MarshalBinary.  node keeps its place in
+// its tree.
+func (node *Element) UnmarshalBinary(buf []byte) error {
+	data := &ElementData{}
+	if err := gob.NewDecoder(bytes.NewReader(buf)).Decode(data); err != nil {
+		return err
+	}
+	parsed := data.Import()
+	node.Replace(parsed)
+	node.pos = parsed.pos
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, which also makes
+// Documents usable with encoding/gob.
+func (doc *Document) MarshalBinary() ([]byte, error) {
+	return gobEncode(doc.Export())
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.  It replaces
+// the contents of doc with the document encoded by MarshalBinary.
+func (doc *Document) UnmarshalBinary(buf []byte) error {
+	data := &DocumentData{}
+	if err := gob.NewDecoder(bytes.NewReader(buf)).Decode(data); err != nil {
+		return err
+	}
+	parsed := data.Import()
+	doc.take(parsed)
+	return nil
+}
@@ -0,0 +1,145 @@
+package dom
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A FlatNode is one element of a tree flattened by Flatten.
+type FlatNode struct {
+	// Path is the path to the element from the top of the flattened
+	// tree, in the form Element.Path returns.
+	Path string
+	// Attrs are the attributes of the element, and Text its Content.
+	Attrs []xml.Attr
+	Text  string
+}
+
+// Flatten returns a FlatNode for node and each of its descendants, in
+// document order, which projects a tree onto records for tabular sinks
+// such as CSV files or database tables.  The paths are relative to node,
+// so node's own path is just its name.
+func Flatten(node *Element) []FlatNode {
+	res := []FlatNode{}
+	var walk func(e *Element, path string)
+	walk = func(e *Element, path string) {
+		res = append(res, FlatNode{Path: path, Attrs: append([]xml.Attr(nil), e.Attributes...), Text: string(e.Content)})
+		children := e.mustKids()
+		counts := map[xml.Name]int{}
+		for _, c := range children {
+			counts[c.Name]++
+		}
+		seen := map[xml.Name]int{}
+		for _, c := range children {
+			step := c.Name.Local
+			if counts[c.Name] > 1 {
+				seen[c.Name]++
+				step = fmt.Sprintf("%s[%d]", step, seen[c.Name])
+			}
+			walk(c, path+"/"+step)
+		}
+	}
+	walk(node, "/"+node.Name.Local)
+	return res
+}
+
+// A PathValue is one entry for Unflatten.
+type PathValue struct {
+	Path, Value string
+}
+
+// Unflatten sets the value of each entry at its path in the tree rooted
+// at root, creating the elements along the way that are missing, and
+// returns root.  It is the inverse of Flatten, and drives document
+// generation from flat key/value configuration, as in:
+//    root, err := Unflatten(nil, []PathValue{
+//        {"order/@id", "7"},
+//        {"order/line[2]/sku", "b"},
+//        {"order/note", "rush"},
+//    })
+// Paths are /-separated steps of local names, where name[n] is the n'th
+// child called name, counting from 1, and a plain name is the first, as
+// in the paths Element.Path returns; the leading / is optional.  The
+// first step names root.  A last step of @name sets an attribute instead
+// of Content.  Children are matched by local name alone, and the ones
+// that are created, including any missing siblings before the n'th, get
+// the namespace of their parent.  If root is nil, a new one is made.
+// Entries are applied in order, so a new element comes after the
+// existing children of its parent.
+func Unflatten(root *Element, entries []PathValue) (*Element, error) {
+	for _, entry := range entries {
+		steps := strings.Split(strings.TrimPrefix(entry.Path, "/"), "/")
+		attr := ""
+		if last := steps[len(steps)-1]; strings.HasPrefix(last, "@") {
+			attr, steps = last[1:], steps[:len(steps)-1]
+			if !IsNCName(attr) {
+				return root, fmt.Errorf("dom: %q: invalid attribute name %q", entry.Path, attr)
+			}
+		}
+		if len(steps) == 0 {
+			return root, fmt.Errorf("dom: %q: path has no elements", entry.Path)
+		}
+		var e *Element
+		for i, step := range steps {
+			name, n, err := parseStep(step)
+			if err != nil {
+				return root, fmt.Errorf("dom: %q: %v", entry.Path, err)
+			}
+			if i == 0 {
+				if root == nil {
+					root = Elem(name, "")
+				}
+				if root.Name.Local != name || n != 1 {
+					return root, fmt.Errorf("dom: %q does not start at %s", entry.Path, root.Name.Local)
+				}
+				e = root
+				continue
+			}
+			e = e.nthChild(name, n)
+		}
+		if attr != "" {
+			e.Attr(attr, "", entry.Value)
+		} else {
+			e.Content = []byte(entry.Value)
+			e.touch()
+		}
+	}
+	return root, nil
+}
+
+// parseStep splits a step of an Unflatten path into its name and index.
+func parseStep(step string) (string, int, error) {
+	name, n := step, 1
+	if i := strings.IndexByte(step, '['); i >= 0 && strings.HasSuffix(step, "]") {
+		var err error
+		name = step[:i]
+		if n, err = strconv.Atoi(step[i+1 : len(step)-1]); err != nil || n < 1 {
+			return "", 0, fmt.Errorf("invalid index in %q", step)
+		}
+	}
+	if !IsNCName(name) {
+		return "", 0, fmt.Errorf("invalid step %q", step)
+	}
+	return name, n, nil
+}
+
+// nthChild returns the n'th child of node with the local name name,
+// adding as many as are missing.
+func (node *Element) nthChild(name string, n int) *Element {
+	node.pageIn()
+	for _, c := range node.children {
+		if c.Name.Local == name {
+			if n--; n == 0 {
+				return c
+			}
+		}
+	}
+	var c *Element
+	for ; n > 0; n-- {
+		c = Elem(name, node.Name.Space)
+		node.AddChild(c)
+	}
+	return c
+}
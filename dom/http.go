@@ -0,0 +1,243 @@
+package dom
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// DefaultMaxResponseBytes is how much of a response body ParseResponse
+// reads, unless ParseOptions.MaxInputBytes says otherwise.
+const DefaultMaxResponseBytes = 32 << 20
+
+// ParseResponse parses the body of resp as an XML document, and closes
+// it, as in:
+//    resp, err := http.Get(url)
+//    if err != nil {
+//        return err
+//    }
+//    doc, err := ParseResponse(resp)
+// A charset parameter in the Content-Type header says what the body is
+// encoded in, whatever its XML declaration says, as RFC 7303 has it.
+// Without one, a UTF-16 body is recognized by its byte order mark, and
+// otherwise the encoding in the declaration is used.  UTF-8, US-ASCII,
+// ISO-8859-1 and UTF-16 are decoded without a CharsetReader, and other
+// encodings are handed to the one in opts, which by default takes the
+// body to be UTF-8, as Parse does.  With WithUntrusted, bodies that say
+// they are in other encodings than UTF-8 are rejected instead.
+//
+// No more than MaxInputBytes of the body are read, or
+// DefaultMaxResponseBytes if that is not set, and a body that is longer,
+// or whose Content-Length says it is, fails with a *TreeTooLargeError.
+// The status of the response is not looked at, since error responses
+// often have XML bodies too.
+func ParseResponse(resp *http.Response, opts ...Option) (*Document, error) {
+	defer func() {
+		// Read a little of what is left, so that the connection can
+		// be used again if the body was all but done.
+		io.CopyN(io.Discard, resp.Body, 4<<10)
+		resp.Body.Close()
+	}()
+	s := newSettings(opts)
+	limit := s.parse.MaxInputBytes
+	if limit <= 0 {
+		limit = DefaultMaxResponseBytes
+	}
+	if resp.ContentLength > limit {
+		return nil, &Error{Op: "parse", Err: &TreeTooLargeError{Limit: limit}}
+	}
+	// The limit is on the body as it was sent, before it is decoded.
+	s.parse.MaxInputBytes = 0
+	var body io.Reader = &inputLimit{r: resp.Body, left: limit, limit: limit}
+	fallback := s.parse.CharsetReader
+	_, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	label := params["charset"]
+	if s.parse.Untrusted {
+		if label != "" && !isUTF8(label) {
+			return nil, &Error{Op: "parse", Err: fmt.Errorf("%w: encoding %s", ErrUntrustedInput, label)}
+		}
+		return ParseWithOptions(body, &s.parse)
+	}
+	if label == "" {
+		br := bufio.NewReader(body)
+		body = br
+		if bom, _ := br.Peek(2); string(bom) == "\xFE\xFF" || string(bom) == "\xFF\xFE" {
+			label = "utf-16"
+		}
+	}
+	if label == "" {
+		s.parse.CharsetReader = func(label string, r io.Reader) (io.Reader, error) {
+			return decodeCharset(label, r, fallback)
+		}
+	} else {
+		var err error
+		if body, err = decodeCharset(label, body, fallback); err != nil {
+			return nil, &Error{Op: "parse", Err: err}
+		}
+		// The body is UTF-8 now, whatever its declaration says.
+		s.parse.CharsetReader = func(label string, r io.Reader) (io.Reader, error) {
+			return r, nil
+		}
+	}
+	return ParseWithOptions(body, &s.parse)
+}
+
+// ContentTypeXML is the Content-Type ServeXML and RenderXML send, unless
+// the response already has one.
+const ContentTypeXML = "application/xml; charset=utf-8"
+
+// ServeXML writes doc as the body of a response, declaration and all,
+// encoded with an Encoder set up by opts, as in:
+//    func handler(w http.ResponseWriter, r *http.Request) {
+//        if err := ServeXML(w, doc, WithPretty()); err != nil {
+//            log.Print(err)
+//        }
+//    }
+// It sets the Content-Type to ContentTypeXML, unless it is already set.
+// The document is sent as it is encoded, so large documents are never
+// held in memory, but the headers have gone out by the time encoding
+// can fail, and all that can be done about it then is to log it.  Use
+// RenderXML to be able to send an error response instead.
+func ServeXML(w http.ResponseWriter, doc *Document, opts ...Option) error {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", ContentTypeXML)
+	}
+	e := NewEncoder(w, opts...)
+	err := doc.Encode(e)
+	if ferr := e.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// RenderXML is like ServeXML, but encodes doc in full before it writes
+// anything, so that it can send a Content-Length and status, which is
+// http.StatusOK if it is 0.  If encoding fails, nothing is written, and
+// the handler can send an error response.
+func RenderXML(w http.ResponseWriter, status int, doc *Document, opts ...Option) error {
+	b, err := doc.BytesWith(opts...)
+	if err != nil {
+		return err
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", ContentTypeXML)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	_, err = w.Write(b)
+	return err
+}
+
+func isUTF8(label string) bool {
+	return strings.EqualFold(label, "utf-8") || strings.EqualFold(label, "utf8")
+}
+
+// decodeCharset returns a reader with what r holds in the charset called
+// label as UTF-8, using fallback for the charsets it does not know.
+func decodeCharset(label string, r io.Reader, fallback func(string, io.Reader) (io.Reader, error)) (io.Reader, error) {
+	switch strings.ToLower(label) {
+	case "utf-8", "utf8", "us-ascii", "ascii":
+		return r, nil
+	case "iso-8859-1", "iso_8859-1", "latin1", "l1":
+		return &latin1Reader{r: bufio.NewReader(r)}, nil
+	case "utf-16", "utf-16be":
+		return &utf16Reader{r: bufio.NewReader(r), big: true, bom: true}, nil
+	case "utf-16le":
+		return &utf16Reader{r: bufio.NewReader(r), bom: true}, nil
+	}
+	if fallback == nil {
+		return nil, fmt.Errorf("unsupported charset %s", label)
+	}
+	return fallback(label, r)
+}
+
+// latin1Reader decodes ISO-8859-1, whose bytes are the first 256 code
+// points.
+type latin1Reader struct {
+	r   *bufio.Reader
+	out []byte
+	err error
+}
+
+func (l *latin1Reader) Read(p []byte) (int, error) {
+	for len(l.out) < len(p) && l.err == nil {
+		var b byte
+		if b, l.err = l.r.ReadByte(); l.err == nil {
+			l.out = utf8.AppendRune(l.out, rune(b))
+		}
+		if l.r.Buffered() == 0 {
+			break
+		}
+	}
+	n := copy(p, l.out)
+	l.out = l.out[:copy(l.out, l.out[n:])]
+	if n > 0 {
+		return n, nil
+	}
+	return 0, l.err
+}
+
+// utf16Reader decodes UTF-16 in the byte order big says, or the one its
+// byte order mark says if bom is set and it starts with one.
+type utf16Reader struct {
+	r        *bufio.Reader
+	big, bom bool
+	out      []byte
+	err      error
+}
+
+func (u *utf16Reader) unit() (rune, error) {
+	var b [2]byte
+	if _, err := io.ReadFull(u.r, b[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = fmt.Errorf("UTF-16 input ends in the middle of a character")
+		}
+		return 0, err
+	}
+	if u.big {
+		return rune(b[0])<<8 | rune(b[1]), nil
+	}
+	return rune(b[1])<<8 | rune(b[0]), nil
+}
+
+func (u *utf16Reader) Read(p []byte) (int, error) {
+	if u.bom {
+		u.bom = false
+		if b, _ := u.r.Peek(2); string(b) == "\xFE\xFF" || string(b) == "\xFF\xFE" {
+			u.big = b[0] == 0xFE
+			u.r.Discard(2)
+		}
+	}
+	for len(u.out) < len(p) && u.err == nil {
+		var c rune
+		if c, u.err = u.unit(); u.err != nil {
+			break
+		}
+		if utf16.IsSurrogate(c) {
+			var c2 rune
+			if c2, u.err = u.unit(); u.err != nil {
+				break
+			}
+			c = utf16.DecodeRune(c, c2)
+		}
+		u.out = utf8.AppendRune(u.out, c)
+		if u.r.Buffered() == 0 {
+			break
+		}
+	}
+	n := copy(p, u.out)
+	u.out = u.out[:copy(u.out, u.out[n:])]
+	if n > 0 {
+		return n, nil
+	}
+	return 0, u.err
+}
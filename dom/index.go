@@ -0,0 +1,135 @@
+package dom
+
+import (
+	"encoding/xml"
+)
+
+type attrKey struct {
+	name  xml.Name
+	value string
+}
+
+// Index is a set of lookup tables over all the Elements in a Document.
+// Every lookup returns Elements in document order.  Besides its own
+// methods, the Index is used by xpath's EvaluateDocument, SelectDocument
+// and id(), and by search.FindTag and FindAttr.
+//
+// An Index is only kept up to date with changes made through Element
+// methods.  If you change the Name, Content or Attributes fields of an
+// Element directly, call Document.Reindex.
+type Index struct {
+	root    *Element
+	gen     uint64
+	byName  map[xml.Name][]*Element
+	byLocal map[string][]*Element
+	byAttr  map[xml.Name][]*Element
+	byValue map[attrKey][]*Element
+	byID    map[string]*Element
+}
+
+func buildIndex(root *Element) *Index {
+	idx := &Index{
+		root:    root,
+		byName:  map[xml.Name][]*Element{},
+		byLocal: map[string][]*Element{},
+		byAttr:  map[xml.Name][]*Element{},
+		byValue: map[attrKey][]*Element{},
+		byID:    map[string]*Element{},
+	}
+	if root == nil {
+		return idx
+	}
+	idx.gen = root.gen
+	var walk func(e *Element)
+	walk = func(e *Element) {
+		idx.byName[e.Name] = append(idx.byName[e.Name], e)
+		idx.byLocal[e.Name.Local] = append(idx.byLocal[e.Name.Local], e)
+		for _, a := range e.Attributes {
+			idx.byAttr[a.Name] = append(idx.byAttr[a.Name], e)
+			k := attrKey{a.Name, a.Value}
+			idx.byValue[k] = append(idx.byValue[k], e)
+			if a.Name.Space == NS_XML && a.Name.Local == "id" {
+				if _, found := idx.byID[a.Value]; !found {
+					idx.byID[a.Value] = e
+				}
+			}
+		}
+		e.pageIn()
+		for _, c := range e.children {
+			walk(c)
+		}
+	}
+	walk(root)
+	return idx
+}
+
+func (idx *Index) current(root *Element) bool {
+	return idx.root == root && (root == nil || idx.gen == root.gen)
+}
+
+// Root returns the Element the Index was built from.
+func (idx *Index) Root() *Element {
+	return idx.root
+}
+
+// ByName returns all the Elements with the passed name.
+func (idx *Index) ByName(name xml.Name) []*Element {
+	return idx.byName[name]
+}
+
+// ByLocalName returns all the Elements with the passed local name,
+// no matter what namespace they are in.
+func (idx *Index) ByLocalName(local string) []*Element {
+	return idx.byLocal[local]
+}
+
+// ByAttr returns all the Elements that have an attribute with the passed
+// name.
+func (idx *Index) ByAttr(name xml.Name) []*Element {
+	return idx.byAttr[name]
+}
+
+// ByAttrValue returns all the Elements that have an attribute with the
+// passed name and value.
+func (idx *Index) ByAttrValue(name xml.Name, value string) []*Element {
+	return idx.byValue[attrKey{name, value}]
+}
+
+// ByID returns the Element whose xml:id attribute is id, or nil if there
+// is no such Element.  If several Elements share an id, the first one
+// wins.
+func (idx *Index) ByID(id string) *Element {
+	return idx.byID[id]
+}
+
+// EnableIndex turns on indexing for doc.  The Index is built the first
+// time it is asked for, and rebuilt after the tree changes.
+func (doc *Document) EnableIndex() {
+	doc.indexed = true
+}
+
+// DisableIndex turns off indexing for doc and drops any Index that was
+// built.
+func (doc *Document) DisableIndex() {
+	doc.indexed = false
+	doc.index = nil
+}
+
+// Reindex discards the current Index, forcing it to be rebuilt the next
+// time it is asked for.  It is only needed after changing Element fields
+// directly.
+func (doc *Document) Reindex() {
+	doc.index = nil
+}
+
+// Index returns an up-to-date Index for doc, or nil if indexing has not
+// been enabled with EnableIndex.
+func (doc *Document) Index() *Index {
+	if !doc.indexed {
+		return nil
+	}
+	if doc.index == nil || !doc.index.current(doc.root) {
+		doc.index = buildIndex(doc.root)
+	}
+	return doc.index
+}
@@ -0,0 +1,195 @@
+package dom
+
+import (
+	"bytes"
+	"encoding/xml"
+	"hash/maphash"
+	"sync"
+)
+
+// Interner stores ElementData trees with each distinct subtree held
+// once, however many trees it is in, for holding many documents that
+// are mostly the same, such as thousands of cached responses from
+// devices that differ only in a few leaves:
+//    in := NewInterner()
+//    for _, r := range responses {
+//        cache[r.Device] = in.InternDocument(r.Doc)
+//    }
+//    ...
+//    doc := cache[device].Import()
+// Interning a tree finds the subtrees of it that are the same as ones
+// interned before, with the same names, attributes, Content and
+// children, and returns a tree made of those, making new ones only for
+// the rest.  So two documents that differ in one leaf share everything
+// but the leaf and the elements above it.
+//
+// Since they are shared, the ElementData an Interner returns must never
+// be changed: Import them to get a tree to change.  Their Positions are
+// left out, since they would make the same subtree differ from one
+// document to the next.  An Interner keeps every subtree it has been
+// given for as long as it is kept itself, so a cache whose entries come
+// and go should start a new one from time to time, and intern what is
+// left into it.  It is safe for concurrent use.
+type Interner struct {
+	mu    sync.Mutex
+	seed  maphash.Seed
+	table map[uint64][]*ElementData
+	// sums holds the hash of each interned subtree.
+	sums map[*ElementData]uint64
+	// strs holds the names and values, which are shared as well.
+	strs  map[string]string
+	count int
+}
+
+// NewInterner returns an empty Interner.
+func NewInterner() *Interner {
+	return &Interner{seed: maphash.MakeSeed(), table: map[uint64][]*ElementData{}, sums: map[*ElementData]uint64{}, strs: map[string]string{}}
+}
+
+// Len returns how many distinct subtrees in holds.
+func (in *Interner) Len() int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.count
+}
+
+func (in *Interner) str(s string) string {
+	if res, ok := in.strs[s]; ok {
+		return res
+	}
+	in.strs[s] = s
+	return s
+}
+
+// sameData reports whether d has name, attrs, content, refs and
+// children, all of which are interned already.
+func sameData(d *ElementData, name xml.Name, attrs []xml.Attr, content []byte, refs *entityRefs, children []*ElementData) bool {
+	if d.Name != name || !bytes.Equal(d.Content, content) || len(d.Attributes) != len(attrs) || len(d.Children) != len(children) {
+		return false
+	}
+	if !sameRefs(d.refs(), refs) {
+		return false
+	}
+	for i := range attrs {
+		if d.Attributes[i] != attrs[i] {
+			return false
+		}
+	}
+	for i := range children {
+		if d.Children[i] != children[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// node returns the interned ElementData with name, attrs, content, refs
+// and children, whose children are interned already, making it if there
+// is none.  children is copied if it is kept, and attrs and content too.
+func (in *Interner) node(name xml.Name, attrs []xml.Attr, content []byte, refs *entityRefs, children []*ElementData) *ElementData {
+	var h maphash.Hash
+	h.SetSeed(in.seed)
+	hashName(&h, name)
+	for _, a := range attrs {
+		hashName(&h, a.Name)
+		h.WriteString(a.Value)
+		h.WriteByte(0)
+	}
+	h.Write(content)
+	h.WriteByte(0)
+	if refs != nil {
+		if refs.content {
+			h.WriteByte(1)
+		}
+		for _, a := range refs.attrs {
+			hashName(&h, a)
+		}
+	}
+	h.WriteByte(0)
+	// The children are interned, so their own hashes stand for them.
+	for _, c := range children {
+		sum := in.sums[c]
+		for i := 0; i < 8; i++ {
+			h.WriteByte(byte(sum >> (8 * i)))
+		}
+	}
+	sum := h.Sum64()
+	for _, d := range in.table[sum] {
+		if sameData(d, name, attrs, content, refs, children) {
+			return d
+		}
+	}
+	res := &ElementData{Name: xml.Name{Space: in.str(name.Space), Local: in.str(name.Local)}}
+	if len(attrs) > 0 {
+		res.Attributes = make([]xml.Attr, len(attrs))
+		for i, a := range attrs {
+			res.Attributes[i] = xml.Attr{Name: xml.Name{Space: in.str(a.Name.Space), Local: in.str(a.Name.Local)}, Value: in.str(a.Value)}
+		}
+	}
+	if len(content) > 0 {
+		res.Content = append([]byte(nil), content...)
+	}
+	if refs != nil {
+		res.ContentRefs = refs.content
+		for _, a := range refs.attrs {
+			res.RefAttrs = append(res.RefAttrs, xml.Name{Space: in.str(a.Space), Local: in.str(a.Local)})
+		}
+	}
+	if len(children) > 0 {
+		res.Children = append([]*ElementData(nil), children...)
+	}
+	in.table[sum] = append(in.table[sum], res)
+	in.sums[res] = sum
+	in.count++
+	return res
+}
+
+func (in *Interner) data(d *ElementData) *ElementData {
+	children := make([]*ElementData, len(d.Children))
+	for i, c := range d.Children {
+		children[i] = in.data(c)
+	}
+	return in.node(d.Name, d.Attributes, d.Content, d.refs(), children)
+}
+
+func (in *Interner) element(e *Element) *ElementData {
+	e.pageIn()
+	children := make([]*ElementData, len(e.children))
+	for i, c := range e.children {
+		children[i] = in.element(c)
+	}
+	return in.node(e.Name, e.Attributes, e.Content, e.refs, children)
+}
+
+// Intern returns data with each of its subtrees replaced by the
+// interned one that is the same, if there is one, and interned
+// otherwise.  data itself is not changed, nor kept.
+func (in *Interner) Intern(data *ElementData) *ElementData {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.data(data)
+}
+
+// InternElement exports the tree rooted at node into an ElementData, as
+// Export does, but interned, without making a full copy of it first.
+func (in *Interner) InternElement(node *Element) *ElementData {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.element(node)
+}
+
+// InternDocument exports doc into a DocumentData, as Document.Export
+// does, with the root interned.
+func (in *Interner) InternDocument(doc *Document) *DocumentData {
+	res := &DocumentData{
+		Doctype:     doc.doctype,
+		Prolog:      append([]Misc(nil), doc.prolog...),
+		Epilog:      append([]Misc(nil), doc.epilog...),
+		DoctypeAt:   doc.doctypeAt,
+		Declaration: doc.decl,
+	}
+	if doc.root != nil {
+		res.Root = in.InternElement(doc.root)
+	}
+	return res
+}
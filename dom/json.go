@@ -0,0 +1,32 @@
+package dom
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// ToJSON encodes the tree rooted at node as JSON, using the same mapping
+// as ToMap.  opts can be nil to use the defaults, which turn
+//    <order id="1"><line>a</line><line>b</line></order>
+// into:
+//    {"order":{"@id":"1","line":["a","b"]}}
+// Content and attribute values are always JSON strings.
+func ToJSON(node *Element, opts *MapOptions) ([]byte, error) {
+	return json.Marshal(ToMap(node, opts))
+}
+
+// FromJSON decodes a JSON object in the form produced by ToJSON into a
+// tree.  Numbers are copied into the tree exactly as they were written.
+func FromJSON(data []byte, opts *MapOptions) (*Element, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	m := map[string]interface{}{}
+	if err := decoder.Decode(&m); err != nil {
+		return nil, fmt.Errorf("dom: invalid JSON: %v", err)
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("dom: invalid JSON: more than one value")
+	}
+	return FromMap(m, opts)
+}
@@ -0,0 +1,67 @@
+package dom
+
+import (
+	"strings"
+)
+
+// Lang returns the language in effect at node, from the xml:lang
+// attribute of node or of its nearest ancestor that has one.  It is ""
+// if there is none, or if the nearest one is empty, which is how XML
+// says the language is unknown.
+func (node *Element) Lang() string {
+	for n := node; n != nil; n = n.parent {
+		for _, a := range n.Attributes {
+			if a.Name.Space == NS_XML && a.Name.Local == "lang" {
+				return a.Value
+			}
+		}
+	}
+	return ""
+}
+
+// SelectByLang returns the element of elems whose language, as Lang
+// returns it, best matches the language tag lang, for picking one of
+// several translations of the same thing, as in:
+//    title := SelectByLang(titles, "en-GB")
+// Tags are compared without regard to case.  The best match is an exact
+// one, then one for lang with subtags dropped from the end in turn, so
+// that en-GB matches en, then one for a more specific tag, so that en
+// matches en-US, and finally an element with no language at all.  The
+// first element wins among equally good matches.  It returns nil if
+// nothing matches.
+func SelectByLang(elems []*Element, lang string) *Element {
+	langs := make([]string, len(elems))
+	for i, e := range elems {
+		langs[i] = strings.ToLower(e.Lang())
+	}
+	find := func(match func(l string) bool) *Element {
+		for i, l := range langs {
+			if match(l) {
+				return elems[i]
+			}
+		}
+		return nil
+	}
+	want := strings.ToLower(lang)
+	for r := want; r != ""; {
+		if res := find(func(l string) bool { return l == r }); res != nil {
+			return res
+		}
+		i := strings.LastIndexByte(r, '-')
+		if i < 0 {
+			break
+		}
+		r = r[:i]
+		// Single letter subtags, such as the x in en-x-private,
+		// introduce the ones after them, and go with them.
+		if j := strings.LastIndexByte(r, '-'); j >= 0 && j == len(r)-2 {
+			r = r[:j]
+		}
+	}
+	if want != "" {
+		if res := find(func(l string) bool { return strings.HasPrefix(l, want+"-") }); res != nil {
+			return res
+		}
+	}
+	return find(func(l string) bool { return l == "" })
+}
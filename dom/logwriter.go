@@ -0,0 +1,284 @@
+package dom
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// LogOptions are the settings for OpenLog.
+type LogOptions struct {
+	// MaxBytes, if set, is the size a log file can grow to before it is
+	// rotated: it is renamed to path.1, the one that was path.1 to
+	// path.2, and so on, and a new one is started at path.
+	MaxBytes int64
+	// Keep is how many rotated files to keep, or 0 to keep them all.
+	Keep int
+	// Sync, if set, syncs the file to disk after each record.
+	Sync bool
+	// Encoding are the options the records are written with, as for
+	// NewStreamWriter.
+	Encoding []Option
+}
+
+// LogWriter appends records to an XML log file, which is a document
+// whose root element holds one child element per record.  The file is
+// well-formed XML after each record is written, so it can be read at
+// any time, and the closing tag of the root is written over by the next
+// record.  A LogWriter can be used from several goroutines at once.
+type LogWriter struct {
+	mu   sync.Mutex
+	path string
+	root xml.Name
+	opts LogOptions
+	f    *logFile
+	w    *StreamWriter
+	// end is where the closing tag of the root starts.
+	end int64
+	err error
+}
+
+// logFile is a log file that keeps track of the offset the next byte
+// written to it goes to.
+type logFile struct {
+	*os.File
+	pos int64
+}
+
+func (f *logFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	f.pos += int64(n)
+	return n, err
+}
+
+// OpenLog opens the log at path for appending, or creates it if it does
+// not exist, as in:
+//    l, err := OpenLog("events.xml", xml.Name{Local: "events"}, &LogOptions{MaxBytes: 64 << 20, Keep: 5})
+//    err = l.Write(ElemC("started", "", "ok"))
+// The file must be empty or a log with a root element called root.  If it was cut short,
+// as it will be if the process died while writing a record, it is cut
+// back to the end of the last complete record and the closing tag is
+// repaired.  opts can be nil.
+func OpenLog(path string, root xml.Name, opts *LogOptions) (*LogWriter, error) {
+	l := &LogWriter{path: path, root: root}
+	if opts != nil {
+		l.opts = *opts
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open opens the file at l.path, and sets l.w up to append to it.
+func (l *LogWriter) open() error {
+	f, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return l.fail(err)
+	}
+	end, records, err := scanLog(f, l.root)
+	if err == nil {
+		_, err = f.Seek(end, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return l.fail(err)
+	}
+	l.f = &logFile{File: f, pos: end}
+	// The start of the file is written the same way whether it is
+	// there or not, so that the writer is left in the same state.
+	var header io.Writer = io.Discard
+	if end == 0 {
+		header = l.f
+	}
+	l.w = NewStreamWriter(header, l.opts.Encoding...)
+	l.w.WriteDeclaration(Declaration{})
+	l.w.OpenElement(l.root)
+	if err = l.w.start(false); err == nil {
+		err = l.w.Flush()
+	}
+	if err != nil {
+		return l.fail(err)
+	}
+	l.w.e.Reset(l.f)
+	l.w.open[0].children = records
+	return l.finish()
+}
+
+// finish writes the closing tag of the root after what has been written
+// so far, and leaves the file ready for the next record.
+func (l *LogWriter) finish() error {
+	w := l.w
+	l.end = l.f.pos
+	f := w.open[0]
+	if f.children {
+		w.e.depth = 0
+		if err := w.e.spaces(); err != nil {
+			return l.fail(err)
+		}
+	}
+	if _, err := w.e.WriteString("</" + f.qname + ">"); err != nil {
+		return l.fail(err)
+	}
+	if err := w.e.prettyEnd(); err != nil {
+		return l.fail(err)
+	}
+	if err := w.e.Flush(); err != nil {
+		return l.fail(err)
+	}
+	if err := l.f.Truncate(l.f.pos); err != nil {
+		return l.fail(err)
+	}
+	if l.opts.Sync {
+		if err := l.f.Sync(); err != nil {
+			return l.fail(err)
+		}
+	}
+	_, err := l.f.Seek(l.end, io.SeekStart)
+	l.f.pos = l.end
+	return l.fail(err)
+}
+
+// fail makes err the error every later call returns, if it is not nil.
+func (l *LogWriter) fail(err error) error {
+	if err != nil && l.err == nil {
+		l.err = fmt.Errorf("dom: %s: %w", l.path, err)
+	}
+	return l.err
+}
+
+// Write writes record to the end of the log, rotating it first if it
+// has grown to LogOptions.MaxBytes.  If writing fails, the log is
+// unusable, but opening it again repairs it.
+func (l *LogWriter) Write(record *Element) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return l.err
+	}
+	if l.opts.MaxBytes > 0 && l.end >= l.opts.MaxBytes && l.w.open[0].children {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	if err := l.w.EmitElement(record); err != nil {
+		return l.fail(err)
+	}
+	if err := l.w.Flush(); err != nil {
+		return l.fail(err)
+	}
+	return l.finish()
+}
+
+// Rotate starts a new log file, as if the current one had grown to
+// LogOptions.MaxBytes.
+func (l *LogWriter) Rotate() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return l.err
+	}
+	return l.rotate()
+}
+
+func (l *LogWriter) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return l.fail(err)
+	}
+	n := 1
+	for ; l.opts.Keep == 0 || n < l.opts.Keep; n++ {
+		if _, err := os.Stat(fmt.Sprintf("%s.%d", l.path, n)); err != nil {
+			break
+		}
+	}
+	for ; n > 0; n-- {
+		if err := os.Rename(l.rotated(n-1), l.rotated(n)); err != nil {
+			return l.fail(err)
+		}
+	}
+	return l.open()
+}
+
+// rotated returns the name of the n'th rotated file, or of the log
+// itself for 0.
+func (l *LogWriter) rotated(n int) string {
+	if n == 0 {
+		return l.path
+	}
+	return fmt.Sprintf("%s.%d", l.path, n)
+}
+
+// Close closes the log file, which is left well-formed.
+func (l *LogWriter) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return l.err
+	}
+	err := l.f.Close()
+	l.f = nil
+	if l.err != nil {
+		return l.err
+	}
+	l.err = errors.New("dom: log is closed")
+	return err
+}
+
+// scanLog returns the offset of the end of the last complete record of
+// the log in f, or of the start tag of the root if there are none, and
+// whether there are any.  It returns 0 if f is empty.
+func scanLog(f *os.File, root xml.Name) (end int64, records bool, err error) {
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return 0, false, err
+	}
+	d := xml.NewDecoder(f)
+	depth := 0
+	for {
+		start := d.InputOffset()
+		tok, err := d.Token()
+		if err != nil {
+			break
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if depth == 0 && tok.Name != root {
+				return 0, false, fmt.Errorf("not a log of %s elements", root.Local)
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+			if depth == 1 {
+				records = true
+			}
+		case xml.CharData:
+			// Of the whitespace between records, only the line
+			// break pretty printing puts after each one is kept,
+			// since the writer puts its own indentation before them.
+			if depth == 1 && strings.TrimSpace(string(tok)) == "" {
+				if i := bytes.LastIndexByte(tok, '\n'); i >= 0 && records {
+					end = start + int64(i) + 1
+				}
+				continue
+			}
+		}
+		if depth == 0 {
+			if end > 0 {
+				break
+			}
+			continue
+		}
+		if depth == 1 {
+			end = d.InputOffset()
+		}
+	}
+	if end == 0 {
+		return 0, false, errors.New("not a log: no root element")
+	}
+	return end, records, nil
+}
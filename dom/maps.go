@@ -0,0 +1,251 @@
+package dom
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MapOptions controls how trees are converted to and from generic nested
+// maps by ToMap and FromMap.
+//
+// An element becomes a key in the map of its parent (or in the top-level
+// map, for the element being converted) named after the element.  If it
+// has no attributes and no children, its value is its Content as a
+// string.  Otherwise its value is a map holding its attributes under
+// AttrPrefix followed by the attribute name, its Content under TextKey,
+// and its children under their own names.  Children that appear more
+// than once, or whose names are in Lists, become a []interface{} of
+// values.  For example, with the default options:
+//    <order id="1"><line>a</line><line>b</line><note>x</note></order>
+// becomes:
+//    map[string]interface{}{
+//        "order": map[string]interface{}{
+//            "@id": "1",
+//            "line": []interface{}{"a", "b"},
+//            "note": "x",
+//        },
+//    }
+// Maps have no order, so FromMap creates attributes and children in the
+// order of their sorted keys.
+type MapOptions struct {
+	// AttrPrefix marks keys that hold attributes.  It defaults to "@".
+	AttrPrefix string
+	// TextKey is the key that holds Content.  It defaults to "#text".
+	TextKey string
+	// AlwaysList makes every child element a list, even when it only
+	// appears once.
+	AlwaysList bool
+	// Lists holds the names of elements that are always lists.
+	Lists map[string]bool
+	// Namespaces maps namespace URIs to the prefixes used for them in
+	// keys, as in "p:local".  Names in namespaces that are not in
+	// Namespaces are converted to just their local part.  FromMap uses
+	// the same prefixes to put elements and attributes in namespaces.
+	Namespaces map[string]string
+}
+
+func (opts *MapOptions) withDefaults() *MapOptions {
+	res := MapOptions{}
+	if opts != nil {
+		res = *opts
+	}
+	if res.AttrPrefix == "" {
+		res.AttrPrefix = "@"
+	}
+	if res.TextKey == "" {
+		res.TextKey = "#text"
+	}
+	return &res
+}
+
+func (opts *MapOptions) key(n xml.Name) string {
+	if prefix, ok := opts.Namespaces[n.Space]; ok && n.Space != "" {
+		return prefix + ":" + n.Local
+	}
+	return n.Local
+}
+
+func (opts *MapOptions) name(key string) (xml.Name, error) {
+	i := strings.Index(key, ":")
+	if i < 0 {
+		return xml.Name{Local: key}, nil
+	}
+	prefix := key[:i]
+	for uri, p := range opts.Namespaces {
+		if p == prefix {
+			return xml.Name{Space: uri, Local: key[i+1:]}, nil
+		}
+	}
+	return xml.Name{}, fmt.Errorf("dom: unknown namespace prefix %s in %q", prefix, key)
+}
+
+func (opts *MapOptions) isList(key string) bool {
+	return opts.AlwaysList || opts.Lists[key]
+}
+
+// ToMap converts the tree rooted at node to nested maps, following the
+// conventions described in MapOptions.  opts can be nil to use the
+// defaults.  The result has one key, for node itself.
+func ToMap(node *Element, opts *MapOptions) map[string]interface{} {
+	opts = opts.withDefaults()
+	return map[string]interface{}{opts.key(node.Name): opts.toValue(node)}
+}
+
+func (opts *MapOptions) toValue(node *Element) interface{} {
+	attrs := []xml.Attr{}
+	for _, a := range node.Attributes {
+		if !isXmlnsAttr(a) {
+			attrs = append(attrs, a)
+		}
+	}
+	children := node.Children()
+	if len(attrs) == 0 && len(children) == 0 {
+		return string(node.Content)
+	}
+	res := map[string]interface{}{}
+	for _, a := range attrs {
+		res[opts.AttrPrefix+opts.key(a.Name)] = a.Value
+	}
+	if len(node.Content) > 0 {
+		res[opts.TextKey] = string(node.Content)
+	}
+	counts := map[string]int{}
+	for _, c := range children {
+		counts[opts.key(c.Name)]++
+	}
+	for _, c := range children {
+		k := opts.key(c.Name)
+		v := opts.toValue(c)
+		if counts[k] == 1 && !opts.isList(k) {
+			res[k] = v
+			continue
+		}
+		list, _ := res[k].([]interface{})
+		res[k] = append(list, v)
+	}
+	return res
+}
+
+// FromMap converts nested maps back into a tree, following the
+// conventions described in MapOptions.  opts can be nil to use the
+// defaults.  m must have exactly one key, which names the element being
+// created.  Besides strings, values can be numbers, bools and nil, as
+// produced by encoding/json.
+func FromMap(m map[string]interface{}, opts *MapOptions) (*Element, error) {
+	opts = opts.withDefaults()
+	if len(m) != 1 {
+		return nil, fmt.Errorf("dom: FromMap needs a map with one key, not %d", len(m))
+	}
+	key := sortedKeys(m)[0]
+	res := []*Element{}
+	if err := opts.fromValue(key, m[key], &res); err != nil {
+		return nil, err
+	}
+	if len(res) != 1 {
+		return nil, fmt.Errorf("dom: %s must be a single element", key)
+	}
+	return res[0], nil
+}
+
+func scalar(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return "", true
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), true
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(v), true
+	case fmt.Stringer:
+		return v.String(), true
+	}
+	return "", false
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// fromValue appends the elements named key with value v to res.
+func (opts *MapOptions) fromValue(key string, v interface{}, res *[]*Element) error {
+	name, err := opts.name(key)
+	if err != nil {
+		return err
+	}
+	if s, ok := scalar(v); ok {
+		e := CreateElement(name)
+		e.Content = []byte(s)
+		*res = append(*res, e)
+		return nil
+	}
+	switch v := v.(type) {
+	case []interface{}:
+		for _, item := range v {
+			if _, ok := item.([]interface{}); ok {
+				return fmt.Errorf("dom: %s: lists cannot be nested", key)
+			}
+			if err := opts.fromValue(key, item, res); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []string:
+		for _, item := range v {
+			if err := opts.fromValue(key, item, res); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []map[string]interface{}:
+		for _, item := range v {
+			if err := opts.fromValue(key, item, res); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		e := CreateElement(name)
+		for _, k := range sortedKeys(v) {
+			switch {
+			case k == opts.TextKey:
+				s, ok := scalar(v[k])
+				if !ok {
+					return fmt.Errorf("dom: %s: text must be a scalar, not %T", key, v[k])
+				}
+				e.Content = []byte(s)
+			case strings.HasPrefix(k, opts.AttrPrefix):
+				s, ok := scalar(v[k])
+				if !ok {
+					return fmt.Errorf("dom: %s: attribute %s must be a scalar, not %T", key, k, v[k])
+				}
+				an, err := opts.name(k[len(opts.AttrPrefix):])
+				if err != nil {
+					return err
+				}
+				e.AddAttr(xml.Attr{Name: an, Value: s})
+			default:
+				children := []*Element{}
+				if err := opts.fromValue(k, v[k], &children); err != nil {
+					return err
+				}
+				e.AddChildren(children...)
+			}
+		}
+		*res = append(*res, e)
+		return nil
+	}
+	return fmt.Errorf("dom: %s: cannot convert a %T", key, v)
+}
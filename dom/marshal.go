@@ -0,0 +1,48 @@
+package dom
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+)
+
+// Marshal encodes v with xml.Marshal and returns the result as a tree,
+// so that typed values can be added to trees built by hand.  The
+// namespace declarations encoding/xml writes are dropped, since the
+// names in the tree carry their namespaces and the Encoder declares
+// them again.  v must encode to exactly one element.
+func Marshal(v interface{}) (*Element, error) {
+	buf, err := xml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	elements, err := ParseElements(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	if len(elements) != 1 {
+		return nil, fmt.Errorf("dom: %T marshalled to %d elements, not 1", v, len(elements))
+	}
+	for _, e := range elements[0].All() {
+		e.pos = Position{}
+		attrs := e.Attributes[:0]
+		for _, a := range e.Attributes {
+			if !isXmlnsAttr(a) {
+				attrs = append(attrs, a)
+			}
+		}
+		e.Attributes = attrs
+	}
+	return elements[0], nil
+}
+
+// AddChildFromStruct marshals v as with Marshal and adds the result to
+// node.  It returns the new child.
+func (node *Element) AddChildFromStruct(v interface{}) (*Element, error) {
+	child, err := Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	node.AddChild(child)
+	return child, nil
+}
@@ -1,5 +1,73 @@
 package dom
 
+import "encoding/xml"
+
 const NS_XS = "http://www.w3.org/2001/XMLSchema"
 const NS_XSI = "http://www.w3.org/2001/XMLSchema-instance"
 const NS_XSD = "http://www.w3.org/2001/XMLSchema-datatypes"
+const NS_XML = "http://www.w3.org/XML/1998/namespace"
+
+// EachInNamespace calls f for node and each of its descendants, in
+// document order, that is in the namespace uri, and for each of their
+// attributes that is, with attr nil for the element itself and pointing
+// into its Attributes for an attribute.  The attributes of an element
+// come after it, whether or not it is in uri itself.  Namespace
+// declarations are never in a namespace.  Walking stops as soon as f
+// returns false, and EachInNamespace returns false if it did.  It is the
+// iterator form of FindAllInNamespace and FindAttrsInNamespace, for
+// handling the extension elements in a namespace one at a time, or the
+// first of them, without collecting them all:
+//    root.EachInNamespace(wsse, func(e *Element, attr *xml.Attr) bool {
+//        if attr == nil && e.Name.Local == "Security" {
+//            header = e
+//            return false
+//        }
+//        return true
+//    })
+// f must not add or remove children of the elements it is called for.
+func (node *Element) EachInNamespace(uri string, f func(e *Element, attr *xml.Attr) bool) bool {
+	if node.Name.Space == uri && !f(node, nil) {
+		return false
+	}
+	for i := range node.Attributes {
+		if a := &node.Attributes[i]; a.Name.Space == uri && !isXmlnsAttr(*a) && !f(node, a) {
+			return false
+		}
+	}
+	node.pageIn()
+	for _, c := range node.children {
+		if !c.EachInNamespace(uri, f) {
+			return false
+		}
+	}
+	return true
+}
+
+// FindAllInNamespace returns node and its descendants that are in the
+// namespace uri, whatever their local names, in document order.
+func (node *Element) FindAllInNamespace(uri string) []*Element {
+	res := []*Element{}
+	node.EachInNamespace(uri, func(e *Element, attr *xml.Attr) bool {
+		if attr == nil {
+			res = append(res, e)
+		}
+		return true
+	})
+	return res
+}
+
+// FindAttrsInNamespace returns node and its descendants that have
+// attributes in the namespace uri, in document order, with the
+// attributes that are.  Elements appear once for each such attribute,
+// in the order of their Attributes.
+func (node *Element) FindAttrsInNamespace(uri string) (elements []*Element, attrs []xml.Attr) {
+	elements, attrs = []*Element{}, []xml.Attr{}
+	node.EachInNamespace(uri, func(e *Element, attr *xml.Attr) bool {
+		if attr != nil {
+			elements = append(elements, e)
+			attrs = append(attrs, *attr)
+		}
+		return true
+	})
+	return elements, attrs
+}
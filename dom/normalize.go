@@ -0,0 +1,205 @@
+package dom
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// recorder keeps what the Decoder has read from r since the parser last
+// said it was done with it, so that the source of a start tag can be
+// looked at after the Decoder has parsed it.
+type recorder struct {
+	r   io.Reader
+	buf []byte
+	// off is the offset of buf[0] in the input.
+	off int64
+	// keep is set by KeepSource, and makes the recorder keep all of it.
+	keep bool
+}
+
+func (r *recorder) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.buf = append(r.buf, p[:n]...)
+	return n, err
+}
+
+// span returns the input between offsets from and to, or nil if it is no
+// longer kept.
+func (r *recorder) span(from, to int64) []byte {
+	if from < r.off || from > to || to > r.off+int64(len(r.buf)) {
+		return nil
+	}
+	return r.buf[from-r.off : to-r.off]
+}
+
+// discard forgets the input up to offset to.
+func (r *recorder) discard(to int64) {
+	if r.keep || to <= r.off || to > r.off+int64(len(r.buf)) {
+		return
+	}
+	r.buf = append(r.buf[:0], r.buf[to-r.off:]...)
+	r.off = to
+}
+
+// consumed tells p's recorder, if it has one, that the input the Decoder
+// has read so far is not needed any more.
+func (p *parser) consumed() {
+	if p.rec != nil {
+		p.rec.discard(p.decoder.InputOffset())
+	}
+}
+
+// normalizeAttrs normalizes the values of attrs, the attributes of the
+// start tag that starts at pos and has just been read, as ParseOptions
+// NormalizeAttrs describes.
+func (p *parser) normalizeAttrs(attrs []xml.Attr, pos Position) {
+	var tag []byte
+	end := p.decoder.InputOffset()
+	if p.src != nil {
+		tag = p.raw(pos.Offset)
+	} else if p.rec != nil {
+		tag = p.rec.span(pos.Offset, end)
+	}
+	raw := rawValues(tag)
+	for i := range attrs {
+		if len(raw) == len(attrs) {
+			if v, ok := normalizeRaw(raw[i], attrs[i].Value); ok {
+				attrs[i].Value = v
+				continue
+			}
+		}
+		attrs[i].Value = normalizeDecoded(attrs[i].Value)
+	}
+}
+
+// normalizeDecoded normalizes value when its source is not known, so
+// that whitespace written as character references cannot be told apart
+// from the real thing, and becomes a space too.
+func normalizeDecoded(value string) string {
+	if !strings.ContainsAny(value, "\t\n\r") {
+		return value
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return ' '
+		}
+		return r
+	}, value)
+}
+
+// normalizeRaw normalizes the attribute value written as raw in the
+// source, which the Decoder decoded to value.  It reports false if raw
+// does not decode to value, which happens when the input went through
+// a CharsetReader.
+func normalizeRaw(raw []byte, value string) (string, bool) {
+	if bytes.IndexByte(raw, '&') < 0 && !bytes.ContainsAny(raw, "\t\n\r") {
+		return value, string(raw) == value
+	}
+	// res is the normalized value, and check is what the Decoder
+	// should have made of raw.
+	var res, check strings.Builder
+	for i := 0; i < len(raw); i++ {
+		switch c := raw[i]; c {
+		case '\r':
+			if i+1 < len(raw) && raw[i+1] == '\n' {
+				i++
+			}
+			res.WriteByte(' ')
+			check.WriteByte('\n')
+		case '\t', '\n':
+			res.WriteByte(' ')
+			check.WriteByte(c)
+		case '&':
+			end := bytes.IndexByte(raw[i:], ';')
+			if end < 0 {
+				return "", false
+			}
+			s, ok := decodeRef(string(raw[i+1 : i+end]))
+			if !ok {
+				return "", false
+			}
+			res.WriteString(s)
+			check.WriteString(s)
+			i += end
+		default:
+			res.WriteByte(c)
+			check.WriteByte(c)
+		}
+	}
+	if check.String() != value {
+		return "", false
+	}
+	return res.String(), true
+}
+
+var predefinedText = map[string]string{"lt": "<", "gt": ">", "amp": "&", "apos": "'", "quot": "\""}
+
+// decodeRef returns what the character or entity reference to name
+// stands for.  Other entities than the predefined ones can only have got
+// past the Decoder as EntityRefs.
+func decodeRef(name string) (string, bool) {
+	if s, ok := predefinedText[name]; ok {
+		return s, true
+	}
+	if !strings.HasPrefix(name, "#") {
+		return EntityRef(name), isName(name)
+	}
+	base, digits := 10, name[1:]
+	if strings.HasPrefix(digits, "x") {
+		base, digits = 16, digits[1:]
+	}
+	n, err := strconv.ParseUint(digits, base, 32)
+	if err != nil || !utf8.ValidRune(rune(n)) {
+		return "", false
+	}
+	return string(rune(n)), true
+}
+
+// CollapseAttrs finishes normalizing the attributes of node and all of
+// its descendants for which tokenized returns true, by trimming spaces
+// off the ends of their values and collapsing runs of spaces inside
+// them to one, as the XML spec says is done for attributes declared to
+// be of a type other than CDATA.  Only spaces are touched, so it is
+// meant for trees parsed with ParseOptions.NormalizeAttrs, whose
+// literal whitespace has already been turned into spaces.  A
+// dtd.DTD's Tokenized method can be used as tokenized.
+// The return value is node.
+func (node *Element) CollapseAttrs(tokenized func(e *Element, a xml.Attr) bool) *Element {
+	changed := false
+	for _, e := range node.All() {
+		for i, a := range e.Attributes {
+			if !tokenized(e, a) {
+				continue
+			}
+			if v := collapseSpaces(a.Value); v != a.Value {
+				e.Attributes[i].Value = v
+				changed = true
+			}
+		}
+	}
+	if changed {
+		node.touch()
+	}
+	return node
+}
+
+// collapseSpaces trims the spaces off the ends of s, and replaces each
+// run of them inside it with one.
+func collapseSpaces(s string) string {
+	s = strings.Trim(s, " ")
+	if !strings.Contains(s, "  ") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == ' ' && s[i-1] == ' ' {
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
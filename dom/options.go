@@ -0,0 +1,234 @@
+package dom
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"time"
+)
+
+// Option is a setting for Parse, ParseElements, NewEncoder, BytesWith,
+// StringWith or WatchDocument, as in:
+//    doc, err := Parse(r, WithInternNames(), WithMaxTreeBytes(1<<20))
+//    e := NewEncoder(w, WithIndent("\t"), WithStrict())
+// Options that have nothing to do with what they are passed to, such as
+// WithPretty for Parse, are ignored, so that one list of them can be
+// shared by code that both parses and encodes.
+type Option func(*settings)
+
+type settings struct {
+	parse   ParseOptions
+	pretty  bool
+	strict  bool
+	indent  string
+	width   int
+	workers int
+	every   int
+	report  func(Progress)
+	// interval, debounce and validate are for WatchDocument.
+	interval, debounce time.Duration
+	validate           func(*Document) error
+}
+
+func newSettings(opts []Option) *settings {
+	res := &settings{parse: *defaultOptions()}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+// WithParseOptions uses all the settings in opts, for code that already
+// has a ParseOptions.
+func WithParseOptions(opts *ParseOptions) Option {
+	return func(s *settings) { s.parse = *opts }
+}
+
+// WithCharsetReader sets ParseOptions.CharsetReader.
+func WithCharsetReader(f func(string, io.Reader) (io.Reader, error)) Option {
+	return func(s *settings) { s.parse.CharsetReader = f }
+}
+
+// WithPool sets ParseOptions.Pool.
+func WithPool(pool *Pool) Option {
+	return func(s *settings) { s.parse.Pool = pool }
+}
+
+// WithInternNames sets ParseOptions.InternNames.
+func WithInternNames() Option {
+	return func(s *settings) { s.parse.InternNames = true }
+}
+
+// WithSizeHints sets ParseOptions.ChildrenHint and AttrsHint.
+func WithSizeHints(children, attrs int) Option {
+	return func(s *settings) { s.parse.ChildrenHint, s.parse.AttrsHint = children, attrs }
+}
+
+// WithSkip sets ParseOptions.Skip.
+func WithSkip(skip func(path []xml.Name) bool) Option {
+	return func(s *settings) { s.parse.Skip = skip }
+}
+
+// WithKeepEntityRefs sets ParseOptions.KeepEntityRefs.
+func WithKeepEntityRefs() Option {
+	return func(s *settings) { s.parse.KeepEntityRefs = true }
+}
+
+// WithNormalizeAttrs sets ParseOptions.NormalizeAttrs.
+func WithNormalizeAttrs() Option {
+	return func(s *settings) { s.parse.NormalizeAttrs = true }
+}
+
+// WithWhitespace sets ParseOptions.Whitespace.
+func WithWhitespace(policy *WhitespacePolicy) Option {
+	return func(s *settings) { s.parse.Whitespace = policy }
+}
+
+// WithSpool sets ParseOptions.Spool and SpillDepth.
+func WithSpool(spool *Spool, depth int) Option {
+	return func(s *settings) { s.parse.Spool, s.parse.SpillDepth = spool, depth }
+}
+
+// WithBaseURI sets ParseOptions.BaseURI.
+func WithBaseURI(uri string) Option {
+	return func(s *settings) { s.parse.BaseURI = uri }
+}
+
+// WithMaxTreeBytes sets ParseOptions.MaxTreeBytes.
+func WithMaxTreeBytes(n int64) Option {
+	return func(s *settings) { s.parse.MaxTreeBytes = n }
+}
+
+// WithMaxInputBytes sets ParseOptions.MaxInputBytes.
+func WithMaxInputBytes(n int64) Option {
+	return func(s *settings) { s.parse.MaxInputBytes = n }
+}
+
+// WithMaxDepth sets ParseOptions.MaxDepth.
+func WithMaxDepth(n int) Option {
+	return func(s *settings) { s.parse.MaxDepth = n }
+}
+
+// WithUntrusted sets ParseOptions.Untrusted.
+func WithUntrusted() Option {
+	return func(s *settings) { s.parse.Untrusted = true }
+}
+
+// WithKeepSource sets ParseOptions.KeepSource.
+func WithKeepSource() Option {
+	return func(s *settings) { s.parse.KeepSource = true }
+}
+
+// WithValidator sets ParseOptions.Validator.
+func WithValidator(v Validator) Option {
+	return func(s *settings) { s.parse.Validator = v }
+}
+
+// WithPretty pretty-prints the output, as Encoder.Pretty does.
+func WithPretty() Option {
+	return func(s *settings) { s.pretty = true }
+}
+
+// WithIndent pretty-prints the output using indent for each level of
+// nesting, as Encoder.Indent does.
+func WithIndent(indent string) Option {
+	return func(s *settings) { s.pretty, s.indent = true, indent }
+}
+
+// WithWidth pretty-prints the output, keeping leaves on one line only if
+// they fit in width characters, as Encoder.Width does.
+func WithWidth(width int) Option {
+	return func(s *settings) { s.pretty, s.width = true, width }
+}
+
+// WithStrict checks trees before encoding them, as Encoder.Strict does.
+func WithStrict() Option {
+	return func(s *settings) { s.strict = true }
+}
+
+// WithParallel encodes large trees on several goroutines, as
+// Encoder.Parallel does.
+func WithParallel(workers int) Option {
+	return func(s *settings) { s.workers = workers }
+}
+
+// WithProgress reports on the progress of encoding, as Encoder.Progress
+// does.
+func WithProgress(every int, report func(Progress)) Option {
+	return func(s *settings) { s.every, s.report = every, report }
+}
+
+// WithWatchInterval makes WatchDocument check its file every interval,
+// instead of every second.
+func WithWatchInterval(interval time.Duration) Option {
+	return func(s *settings) { s.interval = interval }
+}
+
+// WithDebounce makes WatchDocument wait until its file has not changed
+// for d, instead of for 100ms, before reading it again.
+func WithDebounce(d time.Duration) Option {
+	return func(s *settings) { s.debounce = d }
+}
+
+// WithValidate makes WatchDocument check each new version of its file
+// with validate, and keep the old one if it fails.
+func WithValidate(validate func(*Document) error) Option {
+	return func(s *settings) { s.validate = validate }
+}
+
+// apply sets up e as s says.
+func (s *settings) apply(e *Encoder) {
+	if s.indent != "" {
+		e.Indent(s.indent)
+	} else if s.pretty {
+		e.Pretty()
+	}
+	if s.width > 0 {
+		e.Width(s.width)
+	}
+	if s.strict {
+		e.Strict()
+	}
+	if s.workers > 0 {
+		e.Parallel(s.workers)
+	}
+	if s.report != nil {
+		e.Progress(s.every, s.report)
+	}
+}
+
+// encode encodes with an Encoder set up by opts, and returns the output
+// along with the error, if encoding failed.
+func encode(encode func(*Encoder) error, opts []Option) ([]byte, error) {
+	var b bytes.Buffer
+	e := NewEncoder(&b, opts...)
+	err := encode(e)
+	if ferr := e.Flush(); err == nil {
+		err = ferr
+	}
+	return b.Bytes(), err
+}
+
+// BytesWith encodes this part of the tree with an Encoder set up by opts.
+// Unlike Bytes, it does not pretty-print unless asked to.
+func (node *Element) BytesWith(opts ...Option) ([]byte, error) {
+	return encode(node.Encode, opts)
+}
+
+// StringWith is like BytesWith but returns a string.
+func (node *Element) StringWith(opts ...Option) (string, error) {
+	b, err := node.BytesWith(opts...)
+	return string(b), err
+}
+
+// BytesWith encodes the Document with an Encoder set up by opts.  Unlike
+// Bytes, it does not pretty-print unless asked to.
+func (doc *Document) BytesWith(opts ...Option) ([]byte, error) {
+	return encode(doc.Encode, opts)
+}
+
+// StringWith is like BytesWith but returns a string.
+func (doc *Document) StringWith(opts ...Option) (string, error) {
+	b, err := doc.BytesWith(opts...)
+	return string(b), err
+}
@@ -0,0 +1,225 @@
+package dom
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"runtime"
+	"sync"
+)
+
+// parallelMin is the smallest number of elements worth handing out to
+// other goroutines.
+const parallelMin = 1024
+
+// Parallel puts the passed Encoder into parallel mode, where the sibling
+// subtrees of large trees are encoded concurrently by up to workers
+// goroutines, each into a buffer of its own, and the buffers written out
+// in order.  The output is the same as without Parallel.  If workers is
+// less than 1, runtime.GOMAXPROCS(0) is used.  The tree must not be
+// changed while it is being encoded.
+func (e *Encoder) Parallel(workers int) {
+	if e.started {
+		log.Panic("xml: Encoding has started, cannot set Parallel flag")
+	}
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	e.workers = workers
+}
+
+// countElements records the number of elements in each subtree of node.
+// Spilled subtrees are counted without being paged in, but only their
+// tops are recorded, since reading them again makes new Elements, and
+// keeping the ones read here would hold the subtrees in memory.
+func countElements(node *Element, sizes map[*Element]int) int {
+	n := 1
+	for _, c := range node.children {
+		n += countElements(c, sizes)
+	}
+	if node.spill != nil {
+		for _, c := range node.mustKids() {
+			n += countElements(c, nil)
+		}
+	}
+	if sizes != nil {
+		sizes[node] = n
+	}
+	return n
+}
+
+// split divides the children of node into groups of about the same size
+// to encode concurrently, or returns nil if node is not worth splitting.
+// A node whose children are mostly in one subtree is not, since that
+// subtree will be split further down instead.
+func (e *Encoder) split(node *Element) [][]*Element {
+	total := e.sizes[node] - 1
+	// The children of spilled nodes are read only to be encoded, and
+	// have no sizes.
+	if total < parallelMin || len(node.children) < 2 || node.spill != nil {
+		return nil
+	}
+	for _, c := range node.children {
+		if e.sizes[c] > total/2 {
+			return nil
+		}
+	}
+	target := (total + e.workers - 1) / e.workers
+	groups := [][]*Element{}
+	start, size := 0, 0
+	for i, c := range node.children {
+		size += e.sizes[c]
+		if size >= target || i == len(node.children)-1 {
+			groups = append(groups, node.children[start:i+1])
+			start, size = i+1, 0
+		}
+	}
+	return groups
+}
+
+func (e *Encoder) encodeChildren(node *Element) error {
+	if e.workers > 1 {
+		if groups := e.split(node); groups != nil {
+			return e.encodeGroups(groups)
+		}
+	}
+	for _, c := range node.children {
+		if err := c.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Encoder) encodeGroups(groups [][]*Element) error {
+	bufs := make([]bytes.Buffer, len(groups))
+	errs := make([]error, len(groups))
+	var wg sync.WaitGroup
+	for i := range groups {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// The namespaces are all settled once encoding has
+			// started, so the sub-encoders can share the maps.
+			sub := &Encoder{
+				Writer:      bufio.NewWriter(&bufs[i]),
+				depth:       e.depth,
+				pretty:      e.pretty,
+				indent:      e.indent,
+				width:       e.width,
+				xml11:       e.xml11,
+				ctx:         e.ctx,
+				maxDepth:    e.maxDepth,
+				started:     true,
+				nsPrefixMap: e.nsPrefixMap,
+				nsURLMap:    e.nsURLMap,
+			}
+			for _, c := range groups[i] {
+				if errs[i] = c.Encode(sub); errs[i] != nil {
+					return
+				}
+			}
+			errs[i] = sub.Flush()
+		}(i)
+	}
+	wg.Wait()
+	for i := range bufs {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		if _, err := e.Write(bufs[i].Bytes()); err != nil {
+			return err
+		}
+		n := 0
+		for _, c := range groups[i] {
+			n += e.sizes[c]
+		}
+		e.encoded(n)
+	}
+	return nil
+}
+
+type parsed struct {
+	doc *Document
+	err error
+}
+
+// ParseAll parses a stream of documents on up to workers goroutines, for
+// ingest pipelines with more documents than one core can keep up with.
+// next is called from one goroutine at a time to get each document, and
+// returns io.EOF when there are no more.  handle is called, again from
+// one goroutine at a time, with the index of each document, the parsed
+// Document and the parse error, in the same order the documents came
+// from next.  If workers is less than 1, runtime.GOMAXPROCS(0) is used.
+//
+// Only about twice workers documents are parsed ahead of the one being
+// handled, so memory use stays bounded however long the stream is.
+// ParseAll stops reading new documents as soon as next or handle returns
+// an error, and returns that error once the documents already started
+// are done.  Errors from parsing are only passed to handle.
+func ParseAll(next func() (io.Reader, error), workers int, opts *ParseOptions, handle func(i int, doc *Document, err error) error) error {
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	type job struct {
+		r   io.Reader
+		res chan parsed
+	}
+	jobs := make(chan job)
+	order := make(chan chan parsed, workers)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				doc, err := ParseWithOptions(j.r, opts)
+				j.res <- parsed{doc, err}
+			}
+		}()
+	}
+	handled := make(chan error, 1)
+	go func() {
+		var err error
+		i := 0
+		for res := range order {
+			p := <-res
+			if err == nil {
+				if err = handle(i, p.doc, p.err); err != nil {
+					close(stop)
+				}
+			}
+			i++
+		}
+		handled <- err
+	}()
+	var err error
+Feed:
+	for {
+		r, nerr := next()
+		if nerr == io.EOF {
+			break
+		}
+		if nerr != nil {
+			err = nerr
+			break
+		}
+		res := make(chan parsed, 1)
+		select {
+		case order <- res:
+		case <-stop:
+			break Feed
+		}
+		jobs <- job{r, res}
+	}
+	close(jobs)
+	close(order)
+	herr := <-handled
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	return herr
+}
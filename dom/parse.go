@@ -3,44 +3,495 @@ package dom
 import (
 	"bytes"
 	"encoding/xml"
-	"errors"
+	"fmt"
+	"hash/maphash"
 	"io"
+	"strings"
 )
 
-const TooManyRootElements = "More than one root Element not allowed!"
+// Position is the location of the start tag of a parsed Element in its
+// source document.  Line and Column start at 1, Offset is the byte offset
+// from the start of the input.  Elements that were not created by the
+// parser have a zero Position.
+type Position struct {
+	Line, Column int
+	Offset       int64
+}
+
+// IsValid reports whether the position was recorded by the parser.
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// token reads the next token from decoder, along with the position it
+// started at.
+func token(decoder *xml.Decoder) (xml.Token, Position, error) {
+	line, col := decoder.InputPos()
+	pos := Position{Line: line, Column: col, Offset: decoder.InputOffset()}
+	tok, err := decoder.Token()
+	return tok, pos, err
+}
+
+// textSlab is the size of the buffers the parser copies Content into.
+// Runs of text longer than a quarter of it get their own allocation.
+const textSlab = 4096
+
+// parser holds the state shared by all the elements of one parse.
+type parser struct {
+	decoder *xml.Decoder
+	pool    *Pool
+	// text is the unused part of the current buffer for Content.
+	text []byte
+	// names holds the interned name strings, if interning is on.
+	names                   map[string]string
+	childrenHint, attrsHint int
+	// src is the whole input, if the tree is to borrow from it.
+	src  []byte
+	skip func(path []xml.Name) bool
+	// path holds the names of the elements being parsed, if skip or
+	// a whitespace Hint is set.
+	path []xml.Name
+	// space is the WhitespacePolicy, and preserve is what it says about
+	// the element being parsed.
+	space    *WhitespacePolicy
+	preserve bool
+	// depth is how many elements deep the parser is, and maxDepth is
+	// ParseOptions.MaxDepth.
+	depth, maxDepth int
+	untrusted       bool
+	// tokens is set if the parser reads tokens that come from no input,
+	// so that there are no positions to give the elements.
+	tokens bool
+	// refs is set by KeepEntityRefs, so that the elements the parser
+	// keeps references in say where they are.
+	refs bool
+	// budget is what is left of MaxTreeBytes, if it was set.
+	limit, budget int64
+	// normalize is set by NormalizeAttrs, and rec keeps the source of
+	// start tags for it, unless src already has it.
+	normalize bool
+	rec       *recorder
+	// spool and spillDepth are ParseOptions.Spool and SpillDepth.
+	spool      *Spool
+	spillDepth int
+	// xml11 is what reads the input if it is an XML 1.1 document.
+	xml11 *xml11Filter
+	// inputs are what limit how much input is read, and baseURI is
+	// ParseOptions.BaseURI.
+	inputs  []*inputLimit
+	baseURI string
+	// spans records where each element was in the input, and seed is
+	// what its state is hashed with, if KeepSource is set.
+	spans map[*Element]*span
+	seed  maphash.Seed
+	// validator is ParseOptions.Validator, and current is the element
+	// being parsed, if it is set.
+	validator Validator
+	current   *Element
+}
+
+// charge takes n bytes out of p's budget, and fails once it runs out.
+func (p *parser) charge(n int64, pos Position) error {
+	if p.limit == 0 {
+		return nil
+	}
+	p.budget -= n
+	if p.budget < 0 {
+		return &Error{Op: "parse", Pos: pos, Err: &TreeTooLargeError{Limit: p.limit, Pos: pos}}
+	}
+	return nil
+}
+
+// raw returns the part of src from offset to where the decoder is, or
+// nil if src does not have it.
+func (p *parser) raw(offset int64) []byte {
+	end := p.decoder.InputOffset()
+	if offset < 0 || offset > end || end > int64(len(p.src)) {
+		return nil
+	}
+	return p.src[offset:end]
+}
+
+// fail turns err into an *Error, positioned where the decoder stopped.
+func (p *parser) fail(err error) *Error {
+	if res, ok := err.(*Error); ok {
+		return res
+	}
+	line, col := p.decoder.InputPos()
+	return &Error{Op: "parse", Pos: Position{Line: line, Column: col, Offset: p.decoder.InputOffset()}, Err: err}
+}
+
+// startCost is roughly the memory an Element for tok takes up, not counting
+// its children and Content.
+func startCost(tok xml.StartElement) int64 {
+	n := elementSize + int64(len(tok.Name.Space)+len(tok.Name.Local))
+	for _, a := range tok.Attr {
+		n += attrSize + int64(len(a.Name.Space)+len(a.Name.Local)+len(a.Value))
+	}
+	return n
+}
+
+// intern returns the copy of s that p has already seen, if there is one.
+func (p *parser) intern(s string) string {
+	if p.names == nil {
+		return s
+	}
+	if res, ok := p.names[s]; ok {
+		return res
+	}
+	p.names[s] = s
+	return s
+}
+
+func (p *parser) internName(n *xml.Name) {
+	n.Space = p.intern(n.Space)
+	n.Local = p.intern(n.Local)
+}
 
-func parseElement(decoder *xml.Decoder, tok xml.StartElement) (res *Element, err error) {
-	res = CreateElement(tok.Name)
-	for _, attr := range tok.Attr {
-		res.AddAttr(attr)
+// content copies text into Content for e, reusing the buffer e already
+// has if it is big enough and does not belong to the input.
+func (p *parser) content(e *Element, text []byte) {
+	switch {
+	case cap(e.Content) >= len(text) && p.src == nil:
+		e.Content = append(e.Content[:0], text...)
+	case len(text) > textSlab/4:
+		e.Content = append([]byte(nil), text...)
+	default:
+		if len(p.text) < len(text) {
+			p.text = make([]byte, textSlab)
+		}
+		// Cap the slice, so that appending to one Element's Content
+		// can never overwrite the next one's.
+		e.Content = p.text[:len(text):len(text)]
+		copy(e.Content, text)
+		p.text = p.text[len(text):]
 	}
+}
 
+// element parses the element that starts with tok.  It returns nil if
+// the element is skipped.
+func (p *parser) element(tok xml.StartElement, pos Position) (res *Element, err error) {
+	defer func() {
+		if err != nil {
+			perr := p.fail(err)
+			perr.Path = "/" + tok.Name.Local + perr.Path
+			err = perr
+		}
+	}()
+	if p.skip != nil || (p.space != nil && p.space.Hint != nil) {
+		p.path = append(p.path, tok.Name)
+		defer func() { p.path = p.path[:len(p.path)-1] }()
+	}
+	if p.skip != nil && p.skip(p.path) {
+		return nil, p.decoder.Skip()
+	}
+	if p.space != nil {
+		defer func(inherited bool) { p.preserve = inherited }(p.preserve)
+		p.preserve = p.space.preserve(tok, p.path, p.preserve)
+	}
+	if p.depth++; p.maxDepth > 0 && p.depth > p.maxDepth {
+		return nil, &Error{Op: "parse", Pos: pos, Err: &TreeTooDeepError{Limit: p.maxDepth}}
+	}
+	defer func() { p.depth-- }()
+	if err := p.charge(startCost(tok), pos); err != nil {
+		return nil, err
+	}
+	if p.names != nil {
+		p.internName(&tok.Name)
+		for i := range tok.Attr {
+			p.internName(&tok.Attr[i].Name)
+		}
+	}
+	if p.normalize {
+		p.normalizeAttrs(tok.Attr, pos)
+	}
+	if p.src != nil {
+		borrowValues(tok.Attr, p.raw(pos.Offset))
+	}
+	res = p.pool.element(tok.Name)
+	res.pos = pos
+	var sp *span
+	if p.spans != nil {
+		sp = &span{start: pos.Offset, startEnd: p.decoder.InputOffset()}
+		sp.last = sp.startEnd
+		p.spans[res] = sp
+	}
+	if unique(tok.Attr) {
+		// The decoder gives every StartElement its own Attr slice,
+		// so unless there is a pooled buffer or one sized by
+		// AttrsHint to copy into, it can be kept as it is.
+		res.Attributes = res.Attributes[:0]
+		res.Grow(0, p.attrsHint)
+		if cap(res.Attributes) >= len(tok.Attr) {
+			res.Attributes = append(res.Attributes, tok.Attr...)
+		} else {
+			res.Attributes = tok.Attr
+		}
+	} else {
+		for _, attr := range tok.Attr {
+			res.AddAttr(attr)
+		}
+	}
+	if p.validator != nil {
+		res.parent = p.current
+		if err := p.validator.Open(res); err != nil {
+			return nil, &Error{Op: "validate", Pos: pos, Err: err}
+		}
+		defer func(outer *Element) { p.current = outer }(p.current)
+		p.current = res
+	}
+	hasText := false
 	for {
-		newtok, err := decoder.Token()
+		p.consumed()
+		newtok, newpos, err := token(p.decoder)
 		if err != nil {
 			return nil, err
 		}
+		if p.tokens {
+			newpos = Position{}
+		}
+		if p.untrusted {
+			if err := untrusted(newtok, newpos); err != nil {
+				return nil, err
+			}
+		}
 		switch rt := newtok.(type) {
 		case xml.EndElement:
+			if !hasText {
+				// Drop the buffer a pooled Element may have come
+				// with, so that empty Content is always nil.
+				res.Content = nil
+			}
+			if p.xml11 != nil && p.xml11.mapped {
+				restoreControls(res)
+			}
+			if p.refs {
+				findRefs(res)
+			}
+			if sp != nil {
+				p.endSpan(res, sp, newpos)
+			}
+			if p.validator != nil {
+				if err := p.validator.Close(res); err != nil {
+					return nil, &Error{Op: "validate", Pos: pos, Err: err}
+				}
+			}
+			if p.spool != nil && p.depth == p.spillDepth {
+				if err := p.spool.Spill(res); err != nil {
+					return nil, err
+				}
+			}
 			return res, nil
 		case xml.CharData:
-			content := bytes.TrimSpace([]byte(rt.Copy()))
-			if len(content) > 0 {
-				res.Content = content
+			if p.preserve {
+				if err := p.charge(int64(len(rt)), pos); err != nil {
+					return nil, err
+				}
+				if p.src != nil {
+					if raw := p.raw(newpos.Offset); raw != nil && bytes.Equal(raw, rt) {
+						res.Content = raw[:len(raw):len(raw)]
+						hasText = true
+						break
+					}
+				}
+				p.content(res, rt)
+				hasText = true
+				break
+			}
+			if p.src != nil {
+				if raw := p.raw(newpos.Offset); raw != nil && bytes.Equal(raw, rt) {
+					if err := p.charge(int64(len(raw)), pos); err != nil {
+						return nil, err
+					}
+					hasText = borrowText(res, raw) || hasText
+					break
+				}
+			}
+			// Trim before copying, since most text between
+			// elements is only indentation.
+			if text := bytes.TrimSpace(rt); len(text) > 0 {
+				if err := p.charge(int64(len(text)), pos); err != nil {
+					return nil, err
+				}
+				p.content(res, text)
+				hasText = true
 			}
 		case xml.StartElement:
-			child, err := parseElement(decoder, rt)
+			child, err := p.element(rt, newpos)
 			if err != nil {
 				return nil, err
 			}
-			res.AddChild(child)
+			if child == nil {
+				break
+			}
+			if err := p.charge(pointerSize, pos); err != nil {
+				return nil, err
+			}
+			// child is brand new and res is not in a tree yet, so
+			// there is nothing for AddChild to detach or touch.  The
+			// parent may have been set already, for a Validator.
+			child.parent = res
+			if sp != nil {
+				sp.child(p.spans[child], res, len(res.children))
+			}
+			if cap(res.children) == 0 && p.childrenHint > 0 {
+				res.children = make([]*Element, 0, p.childrenHint)
+			}
+			res.children = append(res.children, child)
+		}
+	}
+}
+
+// unique reports whether all of attrs have different names.
+func unique(attrs []xml.Attr) bool {
+	for i := 1; i < len(attrs); i++ {
+		for j := 0; j < i; j++ {
+			if attrs[i].Name == attrs[j].Name {
+				return false
+			}
 		}
 	}
+	return true
 }
 
 // ParseOptions specifies some parsing options.
 type ParseOptions struct {
 	CharsetReader func(string, io.Reader)(io.Reader,error)
+	// Pool, if set, is where the parser gets its Elements from.  See
+	// Pool for how to give them back.
+	Pool *Pool
+	// InternNames makes all the Elements and attributes of a parsed
+	// document with the same name share the strings for it, which
+	// saves a lot of memory on large documents that keep using the same
+	// few names.
+	InternNames bool
+	// ChildrenHint and AttrsHint are the number of children and
+	// attributes elements are expected to have.  When set, the parser
+	// makes room for that many as soon as an element gets its first
+	// child, and for that many attributes in every element, which
+	// saves growing the slices step by step when elements are wide or
+	// will have attributes added after parsing.
+	ChildrenHint, AttrsHint int
+	// Skip, if set, is called before each element is parsed, with the
+	// names of the element's ancestors from the top of the document
+	// down, followed by its own name.  If it returns true, the element
+	// and everything in it are skipped over without building anything,
+	// which makes pulling a few fields out of huge documents cheap.
+	// path is only valid during the call.  See KeepPaths.
+	Skip func(path []xml.Name) bool
+	// KeepEntityRefs keeps references to entities other than the five
+	// XML predefines, such as ones declared in an external DTD, instead
+	// of failing on them.  They end up in Content and attribute values
+	// as EntityRefs, the elements they are in say where they are, as
+	// SetEntityRefs does, and the Encoder writes them back out as they
+	// were.  Any text with the characters EntityRefs are made of in it
+	// is taken to hold them.
+	KeepEntityRefs bool
+	// BaseURI is the URI the document was read from, which relative
+	// URIs in it are resolved against by Element.ResolveURI.
+	BaseURI string
+	// MaxTreeBytes, if positive, is roughly how much memory the parsed
+	// tree may take up, as Stats would estimate it.  Parsing stops with
+	// a *TreeTooLargeError as soon as the tree being built goes over
+	// it, so that a single pathological document cannot exhaust a
+	// service's memory.
+	MaxTreeBytes int64
+	// MaxInputBytes, if positive, is how much input the parser reads.
+	// Parsing stops with a *TreeTooLargeError once there is more, for
+	// inputs whose size is not known up front, such as the body of a
+	// response.
+	MaxInputBytes int64
+	// NormalizeAttrs normalizes attribute values the way the XML spec
+	// says a parser should, which encoding/xml does not do: each tab,
+	// newline and carriage return written in a value, with a CR LF pair
+	// counting as one, becomes a space, while ones written as character
+	// references such as &#xA; are kept.  Attributes of types other than
+	// CDATA also need their spaces collapsing, which depends on a DTD or
+	// schema; see Element.CollapseAttrs.
+	NormalizeAttrs bool
+	// MaxDepth, if positive, is how deep elements may be nested.
+	// Parsing stops with a *TreeTooDeepError at the first element that
+	// is nested deeper.
+	MaxDepth int
+	// Untrusted hardens the parser against hostile input, for documents
+	// from sources that are not trusted.  It is one switch that turns
+	// on everything below, whatever the other options say:
+	//
+	// DOCTYPE and other <! declarations are rejected, and so are
+	// processing instructions other than the XML declaration, with an
+	// Error caused by ErrUntrustedInput.
+	//
+	// KeepEntityRefs is off, so references to entities other than the
+	// predefined ones fail, and no entity is ever expanded.
+	//
+	// CharsetReader is not used, so no caller code runs on the input,
+	// and input in other encodings than UTF-8 is rejected.
+	//
+	// MaxTreeBytes and MaxDepth are at most UntrustedMaxTreeBytes and
+	// UntrustedMaxDepth, and the input may not be larger than
+	// MaxTreeBytes either.
+	//
+	// The parser never reads anything but its input, so there is no
+	// network or file access to turn off.  See ParseUntrusted.
+	Untrusted bool
+	// Whitespace, if set, decides which elements keep the whitespace in
+	// their text.  See WhitespacePolicy.
+	Whitespace *WhitespacePolicy
+	// Spool, if set, is where the parser spills each element that is
+	// SpillDepth levels down, the root being 1 level down, as soon as it
+	// has parsed everything in it, so that only the top levels of the
+	// document are ever held in memory.  SpillDepth defaults to 2, so
+	// that each child of the root is spilled.  See Spool.
+	Spool      *Spool
+	SpillDepth int
+	// KeepSource keeps the input and where each element was in it, so
+	// that Document.EncodePreserving can copy the parts of the document
+	// that have not been changed since as they were written.  It is
+	// ignored along with Skip or Spool, which leave parts of the input
+	// out of the tree, and for documents in XML 1.1 or in encodings other
+	// than UTF-8, which the parser does not read byte for byte.
+	KeepSource bool
+	// Validator, if set, checks each element as it is read, and can
+	// stop the parse at the first one that is not valid.  See Validator.
+	Validator Validator
+	// src is the input of ParseBytesZeroCopy.
+	src []byte
+}
+
+// KeepPaths returns a function for ParseOptions.Skip that keeps only the
+// elements at the given paths, with all of their contents, and their
+// ancestors.  Paths are /-separated local names from the top of the
+// document, and a step of * matches any name, as in:
+//    opts := &ParseOptions{Skip: KeepPaths("/order/id", "/order/lines/*/sku")}
+func KeepPaths(paths ...string) func(path []xml.Name) bool {
+	keep := make([][]string, len(paths))
+	for i, p := range paths {
+		keep[i] = strings.Split(strings.Trim(p, "/"), "/")
+	}
+	return func(path []xml.Name) bool {
+	Paths:
+		for _, k := range keep {
+			for i, step := range k {
+				if i == len(path) {
+					// path leads to a kept element.
+					return false
+				}
+				if step != "*" && step != path[i].Local {
+					continue Paths
+				}
+			}
+			// path is a kept element or inside one.
+			return false
+		}
+		return true
+	}
 }
 
 func defaultOptions() *ParseOptions {
@@ -55,58 +506,172 @@ func defaultOptions() *ParseOptions {
 // corrently.
 //
 // This assumes our input is always UTF-8, no matter what lies
-// the <?xml?> header says.
-func ParseElements(r io.Reader) (elements []*Element, err error) {
-	return ParseElementsWithOptions(r, defaultOptions())
+// the <?xml?> header says, unless opts include WithCharsetReader.
+func ParseElements(r io.Reader, opts ...Option) (elements []*Element, err error) {
+	return ParseElementsWithOptions(r, &newSettings(opts).parse)
 }
 
 // ParseElementsWithCharsetReader is like ParseElements but more options can
 // be specified.
 func ParseElementsWithOptions(r io.Reader, opts *ParseOptions) (elements []*Element, err error) {
+	elements, _, err = parseElements(r, opts)
+	return elements, err
+}
+
+// newParser sets up a parser for reading r as opts say.
+func newParser(r io.Reader, opts *ParseOptions) *parser {
 	if opts == nil {
 		opts = defaultOptions()
 	}
+	var inputs []*inputLimit
+	if opts.Untrusted {
+		opts = opts.hardened()
+		inputs = append(inputs, &inputLimit{r: r, left: opts.MaxTreeBytes, limit: opts.MaxTreeBytes})
+		r = inputs[len(inputs)-1]
+	}
+	if opts.MaxInputBytes > 0 {
+		inputs = append(inputs, &inputLimit{r: r, left: opts.MaxInputBytes, limit: opts.MaxInputBytes})
+		r = inputs[len(inputs)-1]
+	}
+	r, xml11 := sniffVersion(r)
+	var entity map[string]string
+	if opts.KeepEntityRefs {
+		entity = map[string]string{}
+		r = &entityScanner{r: r, entity: entity}
+	}
+	// The decoder only counts the input as src has it if nothing in
+	// between changes it.
+	src := opts.src
+	if xml11 != nil || entity != nil {
+		src = nil
+	}
+	var rec *recorder
+	keep := opts.KeepSource && opts.Skip == nil && opts.Spool == nil
+	if (opts.NormalizeAttrs || keep) && src == nil {
+		rec = &recorder{r: r, keep: keep}
+		r = rec
+	}
 	decoder := xml.NewDecoder(r)
 	decoder.Strict = true
+	decoder.Entity = entity
 	decoder.CharsetReader = opts.CharsetReader
+	if xml11 != nil && xml11.r == nil && decoder.CharsetReader != nil {
+		decoder.CharsetReader = xml11.charsetReader(decoder.CharsetReader)
+	}
+	p := tokenParser(decoder, opts)
+	p.src, p.normalize, p.rec, p.xml11, p.inputs = src, opts.NormalizeAttrs, rec, xml11, inputs
+	p.refs = entity != nil
+	if cr := decoder.CharsetReader; src != nil && cr != nil {
+		decoder.CharsetReader = func(label string, r io.Reader) (io.Reader, error) {
+			p.src = nil
+			return cr(label, r)
+		}
+	}
+	if keep {
+		p.spans = map[*Element]*span{}
+		p.seed = maphash.MakeSeed()
+	}
+	return p
+}
+
+// tokenParser sets up a parser for the tokens decoder returns, as the
+// options in opts that do not work on the text of a document say.
+func tokenParser(decoder *xml.Decoder, opts *ParseOptions) *parser {
+	p := &parser{decoder: decoder, pool: opts.Pool, childrenHint: opts.ChildrenHint, attrsHint: opts.AttrsHint, skip: opts.Skip, space: opts.Whitespace, maxDepth: opts.MaxDepth, untrusted: opts.Untrusted, spool: opts.Spool, spillDepth: opts.SpillDepth, baseURI: opts.BaseURI, validator: opts.Validator}
+	if p.spillDepth <= 0 {
+		p.spillDepth = 2
+	}
+	if opts.MaxTreeBytes > 0 {
+		p.limit, p.budget = opts.MaxTreeBytes, opts.MaxTreeBytes
+	}
+	if opts.InternNames {
+		p.names = map[string]string{}
+	}
+	return p
+}
+
+// parseElements does the work for ParseElementsWithOptions, and also
+// returns a Document holding what it found outside the elements: the
+// text of the last DOCTYPE declaration it saw, and the comments and
+// processing instructions.
+func parseElements(r io.Reader, opts *ParseOptions) (elements []*Element, outside *Document, err error) {
+	p := newParser(r, opts)
+	decoder := p.decoder
 	elements = []*Element{}
+	outside = CreateDocument()
 	for {
-		tok, err := decoder.Token()
+		p.consumed()
+		tok, pos, err := token(decoder)
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return elements, err
+			return elements, outside, p.fail(err)
+		}
+		if p.untrusted {
+			if err := untrusted(tok, pos); err != nil {
+				return elements, outside, err
+			}
 		}
+		var item *Misc
 		switch rt := tok.(type) {
 		case xml.StartElement:
-			element, err := parseElement(decoder, rt)
+			element, err := p.element(rt, pos)
 			if err != nil {
-				return elements, err
+				return elements, outside, err
+			}
+			if element != nil {
+				element.uri = p.baseURI
+				elements = append(elements, element)
+			}
+		case xml.Directive:
+			if bytes.HasPrefix(rt, []byte("DOCTYPE")) {
+				outside.doctype = string(rt)
+				outside.doctypeAt = len(outside.prolog)
+			}
+		case xml.Comment:
+			item = &Misc{Text: string(rt)}
+		case xml.ProcInst:
+			if rt.Target == "xml" {
+				outside.decl = parseDeclaration(string(rt.Inst))
+			} else {
+				item = &Misc{Target: rt.Target, Text: string(rt.Inst)}
 			}
-			elements = append(elements, element)
 		}
+		switch {
+		case item == nil:
+		case len(elements) == 0:
+			outside.prolog = append(outside.prolog, *item)
+		default:
+			outside.epilog = append(outside.epilog, *item)
+		}
+	}
+	if p.xml11 != nil {
+		outside.decl.Version = "1.1"
+	}
+	if p.spans != nil && len(elements) == 1 {
+		outside.keepSource(p, elements[0])
 	}
-	return elements, nil
+	return elements, outside, nil
 }
 
 // Parse parses the XML document from the passed io.Reader and
 // returns either a Document or an error if the io.Reader stream
-// could not be parsed as a well-formed XML document.
-func Parse(r io.Reader) (doc *Document, err error) {
-	return ParseWithOptions(r, defaultOptions())
+// could not be parsed as a well-formed XML document.  opts can change
+// how it is parsed, as ParseOptions would.
+func Parse(r io.Reader, opts ...Option) (doc *Document, err error) {
+	return ParseWithOptions(r, &newSettings(opts).parse)
 }
 
 // ParseWithOptions is like Parse but more options can be specified.
 func ParseWithOptions(r io.Reader, opts *ParseOptions) (doc *Document, err error) {
-	elements, err := ParseElementsWithOptions(r, opts)
+	elements, doc, err := parseElements(r, opts)
 	if err != nil {
 		return nil, err
 	}
 	if len(elements) > 1 {
-		return nil, errors.New(TooManyRootElements)
+		return nil, &Error{Op: "parse", Pos: elements[1].pos, Path: "/" + elements[1].Name.Local, Err: ErrTooManyRootElements}
 	}
-	doc = CreateDocument()
 	if len(elements) == 1 {
 		doc.SetRoot(elements[0])
 	}
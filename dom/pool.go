@@ -0,0 +1,78 @@
+package dom
+
+import (
+	"encoding/xml"
+	"sync"
+)
+
+// Pool recycles Elements, so that programs which parse and throw away
+// large numbers of documents put less pressure on the garbage collector.
+// Set the Pool field of ParseOptions to have the parser take Elements
+// from a Pool, and call Release or ReleaseDocument with trees that are no
+// longer needed to give their Elements back.  Released Elements keep
+// their Content, Attributes and children buffers, so parsing documents
+// of a similar shape again allocates very little.
+//
+// The zero value is an empty Pool ready to use.  A Pool is safe for
+// concurrent use, but the trees taken from it are no more so than any
+// other tree.
+type Pool struct {
+	elems sync.Pool
+}
+
+// NewPool creates a new, empty Pool.
+func NewPool() *Pool {
+	return &Pool{}
+}
+
+// element returns an empty Element named n.  p may be nil, in which case
+// the Element is newly allocated.
+func (p *Pool) element(n xml.Name) *Element {
+	if p == nil {
+		return CreateElement(n)
+	}
+	e, _ := p.elems.Get().(*Element)
+	if e == nil {
+		return CreateElement(n)
+	}
+	e.Name = n
+	return e
+}
+
+// Release gives node and all of its descendants back to the pool.  node
+// is removed from its parent first, if it has one.  None of the released
+// Elements, nor their Content or Attributes, may be used after Release
+// returns, and it is up to the caller to make sure nothing else still
+// refers to them.  Elements that did not come from a Pool can be released
+// too.
+func (p *Pool) Release(node *Element) {
+	if node.parent != nil {
+		node.parent.RemoveChild(node)
+	}
+	p.release(node)
+}
+
+func (p *Pool) release(e *Element) {
+	for i, c := range e.children {
+		p.release(c)
+		e.children[i] = nil
+	}
+	for i := range e.Attributes {
+		e.Attributes[i] = xml.Attr{}
+	}
+	*e = Element{
+		children:   e.children[:0],
+		Attributes: e.Attributes[:0],
+		Content:    e.Content[:0],
+	}
+	p.elems.Put(e)
+}
+
+// ReleaseDocument releases the root of doc, and leaves doc empty.
+func (p *Pool) ReleaseDocument(doc *Document) {
+	if doc.root != nil {
+		p.release(doc.root)
+	}
+	doc.root = nil
+	doc.index = nil
+}
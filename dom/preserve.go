@@ -0,0 +1,584 @@
+package dom
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"hash/maphash"
+	"io"
+	"strings"
+)
+
+// source is the input a Document was parsed from with KeepSource, and
+// where each of its elements was in it.
+type source struct {
+	src   []byte
+	seed  maphash.Seed
+	spans map[*Element]*span
+	// root is the span of the root element as parsed.
+	root *span
+	// decl, doctype, prolog and epilog are as they were parsed, so that
+	// the input around the root is only copied while they are the same.
+	decl           Declaration
+	doctype        string
+	prolog, epilog []Misc
+}
+
+// span is where an element was in the input, and what it was like then.
+type span struct {
+	// start and end are the offsets of the element, startEnd that of the
+	// end of its start tag and endStart that of the start of its end
+	// tag.  All three are the same for an empty-element tag.
+	start, startEnd, endStart, end int64
+	// before is where the input between the element and its previous
+	// sibling, or the start tag of its parent, starts, and last is
+	// where the input after its last child starts.
+	before, last int64
+	// parent and index are where the element was in the tree, and kids
+	// is how many children it had.
+	parent      *Element
+	index, kids int
+	// tag, ns and text are hashes of the name and attributes, the
+	// namespace declarations and the Content of the element, and hadText
+	// is set if the Content was not empty.
+	tag, ns, text uint64
+	hadText       bool
+}
+
+// child records that c, whose span is cs, is child number i of e, whose
+// span is sp.
+func (sp *span) child(cs *span, e *Element, i int) {
+	cs.parent, cs.index = e, i
+	cs.before = sp.last
+	sp.last = cs.end
+}
+
+// endSpan finishes the span of e, whose end tag was read at pos.
+func (p *parser) endSpan(e *Element, sp *span, pos Position) {
+	sp.endStart, sp.end = pos.Offset, p.decoder.InputOffset()
+	if len(e.children) == 0 {
+		sp.last = sp.endStart
+	}
+	sp.kids = len(e.children)
+	sp.tag, sp.ns, sp.text = tagHash(p.seed, e), nsHash(p.seed, e), textHash(p.seed, e)
+	sp.hadText = len(e.Content) > 0
+}
+
+func hashName(h *maphash.Hash, n xml.Name) {
+	h.WriteString(n.Space)
+	h.WriteByte(0)
+	h.WriteString(n.Local)
+	h.WriteByte(0)
+}
+
+func tagHash(seed maphash.Seed, e *Element) uint64 {
+	var h maphash.Hash
+	h.SetSeed(seed)
+	hashName(&h, e.Name)
+	for _, a := range e.Attributes {
+		hashName(&h, a.Name)
+		h.WriteString(a.Value)
+		h.WriteByte(0)
+	}
+	return h.Sum64()
+}
+
+func nsHash(seed maphash.Seed, e *Element) uint64 {
+	var h maphash.Hash
+	h.SetSeed(seed)
+	for _, a := range e.Attributes {
+		if isXmlnsAttr(a) {
+			hashName(&h, a.Name)
+			h.WriteString(a.Value)
+			h.WriteByte(0)
+		}
+	}
+	return h.Sum64()
+}
+
+func textHash(seed maphash.Seed, e *Element) uint64 {
+	var h maphash.Hash
+	h.SetSeed(seed)
+	h.Write(e.Content)
+	return h.Sum64()
+}
+
+// keepSource keeps what p recorded of the input in doc, whose root it
+// parsed.
+func (doc *Document) keepSource(p *parser, root *Element) {
+	if enc := strings.ToLower(doc.decl.Encoding); p.xml11 != nil || (enc != "" && enc != "utf-8" && enc != "utf8") {
+		return
+	}
+	src := p.src
+	if src == nil {
+		src = p.rec.buf
+	}
+	doc.source = &source{
+		src:     src,
+		seed:    p.seed,
+		spans:   p.spans,
+		root:    p.spans[root],
+		decl:    doc.decl,
+		doctype: doc.doctype,
+		prolog:  append([]Misc(nil), doc.prolog...),
+		epilog:  append([]Misc(nil), doc.epilog...),
+	}
+}
+
+func sameMisc(a, b []Misc) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// nsScope is a namespace declaration in the input, and the ones it is
+// inside of.
+type nsScope struct {
+	prefix, uri string
+	up          *nsScope
+}
+
+// bind returns s with prefix bound to uri.
+func (s *nsScope) bind(prefix, uri string) *nsScope {
+	return &nsScope{prefix: prefix, uri: uri, up: s}
+}
+
+// push returns s with the namespace declarations of e added.
+func (s *nsScope) push(e *Element) *nsScope {
+	for _, a := range e.Attributes {
+		switch {
+		case a.Name.Space == "xmlns":
+			s = s.bind(a.Name.Local, a.Value)
+		case a.Name.Space == "" && a.Name.Local == "xmlns":
+			s = s.bind("", a.Value)
+		}
+	}
+	return s
+}
+
+// lookup returns the namespace prefix is bound to, and whether it is
+// bound at all.  The empty prefix is the default namespace.
+func (s *nsScope) lookup(prefix string) (string, bool) {
+	for ; s != nil; s = s.up {
+		if s.prefix == prefix {
+			return s.uri, true
+		}
+	}
+	return "", false
+}
+
+// prefixFor returns a prefix other than the empty one that is bound to
+// uri, or "" if there is none.
+func (s *nsScope) prefixFor(uri string) string {
+	for b := s; b != nil; b = b.up {
+		if b.uri != uri || b.prefix == "" {
+			continue
+		}
+		if u, _ := s.lookup(b.prefix); u == uri {
+			return b.prefix
+		}
+	}
+	return ""
+}
+
+// preserver writes a Document parsed with KeepSource, copying what has
+// not changed from the input.
+type preserver struct {
+	*Encoder
+	s *source
+	// inner memoizes innerSame.
+	inner map[*Element]bool
+}
+
+// innerSame reports whether what is between the start and end tags of
+// node is as it was parsed, so that it can be copied from the input.
+func (p *preserver) innerSame(node *Element) bool {
+	if res, ok := p.inner[node]; ok {
+		return res
+	}
+	sp := p.s.spans[node]
+	res := sp != nil && node.stream == nil && node.spill == nil && len(node.children) == sp.kids &&
+		textHash(p.s.seed, node) == sp.text
+	for i, c := range node.children {
+		if !res {
+			break
+		}
+		cs := p.s.spans[c]
+		res = cs != nil && cs.parent == node && cs.index == i && p.same(c)
+	}
+	p.inner[node] = res
+	return res
+}
+
+// same reports whether node, all of it, is as it was parsed.
+func (p *preserver) same(node *Element) bool {
+	sp := p.s.spans[node]
+	return sp != nil && tagHash(p.s.seed, node) == sp.tag && p.innerSame(node)
+}
+
+func (p *preserver) copy(from, to int64) error {
+	_, err := p.Write(p.s.src[from:to])
+	return err
+}
+
+// placed reports whether node, a child of an element that is where it was
+// parsed, or the root if parent is nil, is where it was parsed too, with
+// the same namespace declarations, so that the prefixes in the input
+// still mean what they did.
+func (p *preserver) placed(node *Element) bool {
+	sp := p.s.spans[node]
+	return sp != nil && sp.parent == node.parent && nsHash(p.s.seed, node) == sp.ns
+}
+
+// indentOf returns the whitespace at the end of gap, the input between
+// two elements.
+func indentOf(gap []byte) []byte {
+	i := len(gap)
+	for i > 0 && isTagSpace(gap[i-1]) {
+		i--
+	}
+	return gap[i:]
+}
+
+// qnameOf returns the name in tag, a start tag in the input.
+func qnameOf(tag []byte) []byte {
+	name := tag[1:]
+	if i := bytes.IndexFunc(name, func(r rune) bool { return r < 128 && (isTagSpace(byte(r)) || r == '/' || r == '>') }); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// element writes node, which is placed if p.placed says so, inside the
+// namespace declarations of scope.
+func (p *preserver) element(node *Element, placed bool, scope *nsScope) error {
+	if !placed {
+		return p.fresh(node, scope)
+	}
+	if node.spill != nil {
+		// The children read from the Spool were not parsed, so they
+		// are written fresh.
+		kids, err := node.kids()
+		if err != nil {
+			return err
+		}
+		node.children = kids
+		defer func() { node.children = nil }()
+	}
+	sp := p.s.spans[node]
+	if p.same(node) {
+		return p.copy(sp.start, sp.end)
+	}
+	scope = scope.push(node)
+	empty := len(node.children) == 0 && len(node.Content) == 0 && !node.ContentStreamed()
+	selfClosed := sp.end == sp.startEnd
+	tagSame := tagHash(p.s.seed, node) == sp.tag
+	var name []byte
+	switch {
+	case tagSame && empty && selfClosed:
+		return p.copy(sp.start, sp.end)
+	case tagSame:
+		tag := p.s.src[sp.start:sp.startEnd]
+		name = qnameOf(tag)
+		if selfClosed {
+			tag = bytes.TrimRight(tag[:len(tag)-2], " \t\r\n")
+		}
+		if _, err := p.Write(tag); err != nil {
+			return err
+		}
+		if selfClosed {
+			if err := p.WriteByte('>'); err != nil {
+				return err
+			}
+		}
+	default:
+		var err error
+		var moved bool
+		if name, scope, moved, err = p.startTag(node, scope); err != nil {
+			return err
+		}
+		if empty {
+			_, err := p.WriteString("/>")
+			return err
+		}
+		if err := p.WriteByte('>'); err != nil {
+			return err
+		}
+		if moved {
+			// The default namespace has changed, so none of what is
+			// inside can be copied.
+			if err := p.content(node); err != nil {
+				return err
+			}
+			for _, c := range node.children {
+				if err := p.fresh(c, scope); err != nil {
+					return err
+				}
+			}
+			return p.endTag(name)
+		}
+	}
+	if err := p.inside(node, sp, scope); err != nil {
+		return err
+	}
+	if tagSame && !selfClosed {
+		return p.copy(sp.endStart, sp.end)
+	}
+	return p.endTag(name)
+}
+
+// inside writes what goes between the start and end tags of node, whose
+// span is sp.
+func (p *preserver) inside(node *Element, sp *span, scope *nsScope) error {
+	if p.innerSame(node) {
+		return p.copy(sp.startEnd, sp.endStart)
+	}
+	if sp.hadText || len(node.Content) > 0 || node.ContentStreamed() {
+		// Text and children were mixed, or there is text now, and the
+		// parser does not record where the text was among the children,
+		// so the text comes first, as Encode writes it.
+		if len(node.children) == 0 && sp.kids == 0 && sp.end != sp.startEnd {
+			// Keep the whitespace the text was trimmed of.
+			inner := p.s.src[sp.startEnd:sp.endStart]
+			if _, err := p.Write(inner[:len(inner)-len(bytes.TrimLeft(inner, " \t\r\n"))]); err != nil {
+				return err
+			}
+			if err := p.content(node); err != nil {
+				return err
+			}
+			_, err := p.Write(indentOf(inner))
+			return err
+		}
+		if err := p.content(node); err != nil {
+			return err
+		}
+		for _, c := range node.children {
+			if err := p.element(c, p.placed(c), scope); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// Children that were there keep the input before them, comments and
+	// all, and new ones get the indentation of the child before them.
+	var indent []byte
+	for _, c := range node.children {
+		if cs := p.s.spans[c]; cs != nil && cs.parent == node {
+			indent = indentOf(p.s.src[cs.before:cs.start])
+			break
+		}
+	}
+	for _, c := range node.children {
+		if cs := p.s.spans[c]; cs != nil && cs.parent == node {
+			gap := p.s.src[cs.before:cs.start]
+			if _, err := p.Write(gap); err != nil {
+				return err
+			}
+			indent = indentOf(gap)
+		} else if _, err := p.Write(indent); err != nil {
+			return err
+		}
+		if err := p.element(c, p.placed(c), scope); err != nil {
+			return err
+		}
+	}
+	if sp.kids > 0 {
+		return p.copy(sp.last, sp.endStart)
+	}
+	return nil
+}
+
+// content writes the Content of node, or its streamed text.
+func (p *preserver) content(node *Element) error {
+	if node.ContentStreamed() {
+		return node.encodeStream(p.Encoder)
+	}
+	return escapeRefs(p.Encoder, node.Content, node.contentRefs())
+}
+
+func (p *preserver) endTag(name []byte) error {
+	if _, err := p.WriteString("</"); err != nil {
+		return err
+	}
+	if _, err := p.Write(name); err != nil {
+		return err
+	}
+	return p.WriteByte('>')
+}
+
+// startTag writes the start tag of node, which has changed since it was
+// parsed, without the closing >, using the prefixes of scope and
+// declaring new ones where they are needed.  It returns the name it
+// wrote, the scope with the new prefixes and whether it had to change
+// the default namespace.
+func (p *preserver) startTag(node *Element, scope *nsScope) (name []byte, res *nsScope, moved bool, err error) {
+	res = scope
+	var decls []string
+	hint := ""
+	if sp := p.s.spans[node]; sp != nil {
+		if q := qnameOf(p.s.src[sp.start:sp.startEnd]); bytes.IndexByte(q, ':') > 0 {
+			hint = string(q[:bytes.IndexByte(q, ':')])
+		}
+	}
+	qname := func(n xml.Name, attr bool) string {
+		switch n.Space {
+		case "xmlns":
+			return "xmlns:" + n.Local
+		case NS_XML:
+			return "xml:" + n.Local
+		case "":
+			if def, _ := res.lookup(""); !attr && def != "" {
+				res = res.bind("", "")
+				decls = append(decls, ` xmlns=""`)
+				moved = true
+			}
+			return n.Local
+		}
+		if def, _ := res.lookup(""); !attr && def == n.Space {
+			return n.Local
+		}
+		prefix := res.prefixFor(n.Space)
+		for i := -1; prefix == ""; i++ {
+			try := fmt.Sprintf("ns%d", i)
+			if i < 0 {
+				// Try the prefix the element had in the input first.
+				try = hint
+			}
+			if _, bound := res.lookup(try); !bound && try != "" {
+				prefix = try
+				res = res.bind(prefix, n.Space)
+				var b strings.Builder
+				xml.EscapeText(&b, []byte(n.Space))
+				decls = append(decls, ` xmlns:`+prefix+`="`+b.String()+`"`)
+			}
+		}
+		return prefix + ":" + n.Local
+	}
+	name = []byte(qname(node.Name, false))
+	hint = ""
+	if _, err = fmt.Fprintf(p, "<%s", name); err != nil {
+		return
+	}
+	for _, a := range node.Attributes {
+		if _, err = fmt.Fprintf(p, " %s=\"", qname(a.Name, true)); err != nil {
+			return
+		}
+		if err = escapeRefs(p.Encoder, []byte(a.Value), node.attrRefs(a.Name)); err != nil {
+			return
+		}
+		if err = p.WriteByte('"'); err != nil {
+			return
+		}
+	}
+	for _, d := range decls {
+		if _, err = p.WriteString(d); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// fresh writes node, which was not parsed where it is now, and all of
+// it, with the prefixes of scope, declaring new ones where they are
+// needed.
+func (p *preserver) fresh(node *Element, scope *nsScope) error {
+	if node.spill != nil {
+		// Read the children for as long as it takes to write them.
+		kids, err := node.kids()
+		if err != nil {
+			return err
+		}
+		node.children = kids
+		defer func() { node.children = nil }()
+	}
+	name, scope, _, err := p.startTag(node, scope.push(node))
+	if err != nil {
+		return err
+	}
+	if len(node.children) == 0 && len(node.Content) == 0 && !node.ContentStreamed() {
+		_, err := p.WriteString("/>")
+		return err
+	}
+	if err := p.WriteByte('>'); err != nil {
+		return err
+	}
+	if err := p.content(node); err != nil {
+		return err
+	}
+	for _, c := range node.children {
+		if err := p.fresh(c, scope); err != nil {
+			return err
+		}
+	}
+	return p.endTag(name)
+}
+
+// EncodePreserving writes doc to w, copying the parts of it that have
+// not changed since it was parsed with KeepSource from the input, byte
+// for byte, so that a program that edits a hand-written file changes
+// only what it edits, rather than the quoting, indentation, prefixes and
+// comments of the whole file:
+//    doc, err := Parse(f, WithKeepSource())
+//    ...
+//    doc.Root().Child(0).SetInt(8081)
+//    err = doc.EncodePreserving(w)
+// An element that is where it was parsed, with the same namespace
+// declarations, is copied whole if nothing in it has changed.
+// Otherwise its start and end tags are copied if its name and attributes
+// are the same, and written with the prefixes the input declared if not,
+// and its children get the same treatment, each keeping the whitespace
+// and comments that came before it in the input.  Changed text is
+// escaped as Encode writes it, and comes before the children where text
+// and children were mixed, since the parser does not record where the
+// text went among them.  Elements that were not parsed where they are
+// now are written as Encode writes them, each declaring the namespaces
+// it uses.  The XML declaration, prolog and epilog are copied unless
+// they have changed.
+//
+// Changes are found by comparing the tree with hashes recorded while
+// parsing, so EncodePreserving reads all of it.  Documents that were not
+// parsed with KeepSource are written as Encode writes them.
+func (doc *Document) EncodePreserving(w io.Writer) (err error) {
+	defer func() {
+		if _, ok := err.(*Error); err != nil && !ok {
+			err = &Error{Op: "encode", Err: err}
+		}
+	}()
+	s := doc.source
+	if s == nil {
+		e := NewEncoder(w)
+		if err = doc.Encode(e); err != nil {
+			return err
+		}
+		return e.Flush()
+	}
+	p := &preserver{Encoder: NewEncoder(w), s: s, inner: map[*Element]bool{}}
+	if doc.decl == s.decl && doc.doctype == s.doctype && sameMisc(doc.prolog, s.prolog) {
+		err = p.copy(0, s.root.start)
+	} else {
+		err = doc.encodeProlog(p.Encoder)
+	}
+	if err != nil {
+		return err
+	}
+	if doc.root != nil {
+		if err = p.element(doc.root, p.placed(doc.root), nil); err != nil {
+			return err
+		}
+	}
+	if sameMisc(doc.epilog, s.epilog) {
+		err = p.copy(s.root.end, int64(len(s.src)))
+	} else {
+		err = writeMisc(p.Encoder, doc.epilog)
+	}
+	if err != nil {
+		return err
+	}
+	return p.Flush()
+}
@@ -0,0 +1,337 @@
+package dom
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// maxDistinct is how many distinct values a ValueProfile counts before it
+// gives up on them.
+const maxDistinct = 20
+
+// ValueProfile describes the values of one attribute or the text of one
+// element path across a corpus.
+type ValueProfile struct {
+	// Count is the number of values seen, and Empty how many of them
+	// were empty.
+	Count, Empty int
+	// MinLen and MaxLen are the lengths of the shortest and longest
+	// values, in bytes.
+	MinLen, MaxLen int
+	// Values counts each distinct value, until there are more than
+	// maxDistinct of them, after which it is nil.
+	Values map[string]int
+	// Patterns counts the shapes of the values, in which each run of
+	// digits is 9, each run of letters is a, or A if it starts with a
+	// capital, each run of spaces is one space and everything else is
+	// itself, so that 2024-01-02 and 1999-12-31 are both 9-9-9.
+	Patterns map[string]int
+}
+
+func (v *ValueProfile) add(s string) {
+	if v.Count == 0 || len(s) < v.MinLen {
+		v.MinLen = len(s)
+	}
+	if len(s) > v.MaxLen {
+		v.MaxLen = len(s)
+	}
+	v.Count++
+	if s == "" {
+		v.Empty++
+		return
+	}
+	if v.Patterns == nil {
+		v.Values, v.Patterns = map[string]int{}, map[string]int{}
+	}
+	if v.Values != nil {
+		v.Values[s]++
+		if len(v.Values) > maxDistinct {
+			v.Values = nil
+		}
+	}
+	v.Patterns[valuePattern(s)]++
+}
+
+// valuePattern returns the shape of s, as ValueProfile.Patterns counts
+// it.
+func valuePattern(s string) string {
+	var b strings.Builder
+	last := rune(0)
+	for _, r := range s {
+		var c rune
+		switch {
+		case unicode.IsDigit(r):
+			c = '9'
+		case unicode.IsUpper(r):
+			if last == 'a' || last == 'A' {
+				continue
+			}
+			c = 'A'
+		case unicode.IsLetter(r):
+			if last == 'A' {
+				continue
+			}
+			c = 'a'
+		case unicode.IsSpace(r):
+			c = ' '
+		default:
+			b.WriteRune(r)
+			last = 0
+			continue
+		}
+		if c != last {
+			b.WriteRune(c)
+		}
+		last = c
+	}
+	return b.String()
+}
+
+// Common returns the values of v, or failing that its patterns, from the
+// most to the least common, as "value (count)" strings, at most n of
+// them.
+func (v *ValueProfile) Common(n int) []string {
+	counts := v.Values
+	if counts == nil {
+		counts = v.Patterns
+	}
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	res := make([]string, len(keys))
+	for i, k := range keys {
+		res[i] = fmt.Sprintf("%q (%d)", k, counts[k])
+	}
+	return res
+}
+
+// AttrProfile describes one attribute of the elements at a path.
+type AttrProfile struct {
+	Name xml.Name
+	// Count is how many of the elements had the attribute.
+	Count  int
+	Values ValueProfile
+}
+
+// PathProfile describes the elements at one path across a corpus.
+type PathProfile struct {
+	// Path is a /-separated path of element names from the root, as
+	// Profile writes them.
+	Path string
+	Name xml.Name
+	// Count is how many elements there were at the path, Parents how
+	// many of the elements at the parent path, or for a root how many
+	// documents, had at least one of them, and MaxPerParent the most
+	// any of those had.
+	Count, Parents, MaxPerParent int
+	// Text describes the Content of the elements that had no children,
+	// and Mixed counts those that had both children and Content.
+	Text  ValueProfile
+	Mixed int
+	// Attributes holds a profile for each attribute the elements had,
+	// in the order they were first seen.  Namespace declarations are
+	// left out.
+	Attributes []*AttrProfile
+	attrs      map[xml.Name]*AttrProfile
+	prof       *Profile
+	parent     *PathProfile
+	children   []*PathProfile
+	depth      int
+}
+
+// Optional reports whether some of the elements at the parent path did
+// not have an element at p's path.
+func (p *PathProfile) Optional() bool {
+	if p.parent == nil {
+		return p.Parents < p.prof.Documents
+	}
+	return p.Parents < p.parent.Count
+}
+
+// Profile is an outline of a corpus of documents, for finding out what
+// the documents in a feed nobody has described actually hold before
+// writing code to process them:
+//    prof := NewProfile()
+//    for _, doc := range docs {
+//        prof.Add(doc)
+//    }
+//    fmt.Print(prof)
+// It records every distinct path of elements, how often it occurs and
+// whether it is always there, what attributes the elements at it have,
+// and what their values and text look like.
+type Profile struct {
+	// Documents is the number of documents added.
+	Documents int
+	paths     map[string]*PathProfile
+	roots     []*PathProfile
+}
+
+// NewProfile returns an empty Profile.
+func NewProfile() *Profile {
+	return &Profile{paths: map[string]*PathProfile{}}
+}
+
+// step returns the step of Path for an element called name whose parent
+// is in space.  Names are written in Clark notation where their
+// namespace differs from the parent's, so that paths are unambiguous but
+// mostly local names.
+func step(name xml.Name, space string) string {
+	if name.Space == space {
+		return name.Local
+	}
+	return "{" + name.Space + "}" + name.Local
+}
+
+func (prof *Profile) path(path string, name xml.Name, parent *PathProfile) *PathProfile {
+	p, ok := prof.paths[path]
+	if !ok {
+		p = &PathProfile{Path: path, Name: name, attrs: map[xml.Name]*AttrProfile{}, prof: prof, parent: parent}
+		prof.paths[path] = p
+		if parent == nil {
+			prof.roots = append(prof.roots, p)
+		} else {
+			p.depth = parent.depth + 1
+			parent.children = append(parent.children, p)
+		}
+	}
+	return p
+}
+
+func (prof *Profile) element(e *Element, p *PathProfile) {
+	p.Count++
+	for _, a := range e.Attributes {
+		if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") {
+			continue
+		}
+		ap, ok := p.attrs[a.Name]
+		if !ok {
+			ap = &AttrProfile{Name: a.Name}
+			p.attrs[a.Name] = ap
+			p.Attributes = append(p.Attributes, ap)
+		}
+		ap.Count++
+		ap.Values.add(a.Value)
+	}
+	children := e.mustKids()
+	if len(children) == 0 {
+		p.Text.add(string(e.Content))
+		return
+	}
+	if len(e.Content) > 0 {
+		p.Mixed++
+	}
+	counts := map[*PathProfile]int{}
+	for _, c := range children {
+		cp := prof.path(p.Path+"/"+step(c.Name, e.Name.Space), c.Name, p)
+		counts[cp]++
+		prof.element(c, cp)
+	}
+	for cp, n := range counts {
+		cp.Parents++
+		if n > cp.MaxPerParent {
+			cp.MaxPerParent = n
+		}
+	}
+}
+
+// Add adds doc to the corpus prof describes.
+func (prof *Profile) Add(doc *Document) {
+	prof.Documents++
+	root := doc.Root()
+	if root == nil {
+		return
+	}
+	p := prof.path("/"+step(root.Name, ""), root.Name, nil)
+	p.Parents++
+	p.MaxPerParent = 1
+	prof.element(root, p)
+}
+
+// Paths returns the profiles of the paths in the corpus, each followed by
+// those of its children, which are in the order they were first seen.
+func (prof *Profile) Paths() []*PathProfile {
+	res := []*PathProfile{}
+	var walk func(ps []*PathProfile)
+	walk = func(ps []*PathProfile) {
+		for _, p := range ps {
+			res = append(res, p)
+			walk(p.children)
+		}
+	}
+	walk(prof.roots)
+	return res
+}
+
+// Path returns the profile of path, or nil if no element was found at
+// it.
+func (prof *Profile) Path(path string) *PathProfile {
+	return prof.paths[path]
+}
+
+// String returns a report on the corpus, with a line for each path
+// giving how many elements there were, in how many of their parents,
+// and the most per parent, followed by lines for their attributes and
+// their text, as in:
+//    /order  count=2 in=2/2 max=1
+//      @id  in=2/2 len=1-2 values="12" (1), "7" (1)
+//      line  count=3 in=2/2 max=2
+//        @sku  in=3/3 len=1 values="X" (2), "Y" (1)
+//        qty  count=3 in=3/3 max=1
+//          text  len=1 values="2" (2), "5" (1)
+// Paths are indented by depth and give their last step only.  Where an
+// attribute or text takes too many values to list, its most common
+// patterns are given instead.
+func (prof *Profile) String() string {
+	var b strings.Builder
+	values := func(v *ValueProfile) {
+		kind := "values"
+		if v.Values == nil {
+			kind = "patterns"
+		}
+		fmt.Fprintf(&b, " len=%d", v.MinLen)
+		if v.MaxLen != v.MinLen {
+			fmt.Fprintf(&b, "-%d", v.MaxLen)
+		}
+		if v.Empty > 0 {
+			fmt.Fprintf(&b, " empty=%d", v.Empty)
+		}
+		if common := v.Common(5); len(common) > 0 {
+			fmt.Fprintf(&b, " %s=%s", kind, strings.Join(common, ", "))
+		}
+		b.WriteByte('\n')
+	}
+	for _, p := range prof.Paths() {
+		indent := strings.Repeat("  ", p.depth)
+		last, parents := p.Path, prof.Documents
+		if p.parent != nil {
+			last, parents = p.Path[len(p.parent.Path)+1:], p.parent.Count
+		}
+		fmt.Fprintf(&b, "%s%s  count=%d in=%d/%d max=%d", indent, last, p.Count, p.Parents, parents, p.MaxPerParent)
+		if p.Mixed > 0 {
+			fmt.Fprintf(&b, " mixed=%d", p.Mixed)
+		}
+		b.WriteByte('\n')
+		for _, a := range p.Attributes {
+			fmt.Fprintf(&b, "%s  @%s  in=%d/%d", indent, step(a.Name, ""), a.Count, p.Count)
+			values(&a.Values)
+		}
+		if p.Text.Count > p.Text.Empty {
+			fmt.Fprintf(&b, "%s  text ", indent)
+			values(&p.Text)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,83 @@
+package dom
+
+import (
+	"io"
+	"log"
+	"time"
+)
+
+// Progress is what an Encoder reports about an encoding in progress.
+type Progress struct {
+	// Elements is how many elements have been encoded, and Bytes how
+	// many bytes have been written for them and whatever was written
+	// before them, whether buffered or not.
+	Elements int
+	Bytes    int64
+	// Elapsed is the time since the first element was started.
+	Elapsed time.Duration
+}
+
+// byteCounter counts the bytes written to w.
+type byteCounter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Progress has the passed Encoder call report after every every
+// elements it encodes, and once more when it is done with the tree, so
+// that long exports can show how far along they are and how fast they
+// are going.  report is always called from the goroutine Encode was
+// called from, even in parallel mode, where it is called as each
+// group of subtrees is written out, so it may see more than every
+// elements at a time.  If every is less than 1, report is called after
+// each element.
+func (e *Encoder) Progress(every int, report func(Progress)) {
+	if e.started {
+		log.Panic("xml: Encoding has started, cannot set Progress")
+	}
+	if every < 1 {
+		every = 1
+	}
+	if e.counter == nil {
+		// Nothing has been written to the tree yet, but the XML
+		// declaration may have been.
+		e.Flush()
+		e.counter = &byteCounter{w: e.out}
+		e.Writer.Reset(e.counter)
+	}
+	e.every, e.report = every, report
+}
+
+// progress returns the Progress so far.
+func (e *Encoder) progress() Progress {
+	return Progress{Elements: e.elements, Bytes: e.counter.n + int64(e.Buffered()), Elapsed: time.Since(e.begun)}
+}
+
+// encoded counts n more elements as encoded, and reports them if it is
+// time to.
+func (e *Encoder) encoded(n int) {
+	if e.report == nil {
+		return
+	}
+	before := e.elements
+	e.elements += n
+	if e.elements/e.every > before/e.every {
+		e.reported = e.elements
+		e.report(e.progress())
+	}
+}
+
+// done reports the Progress at the end of the tree, unless it has just
+// been reported.
+func (e *Encoder) done() {
+	if e.report != nil && e.reported != e.elements {
+		e.reported = e.elements
+		e.report(e.progress())
+	}
+}
@@ -0,0 +1,152 @@
+package dom
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrFinished is returned by Feed once Finish has been called.
+var ErrFinished = errors.New("dom: parser already finished")
+
+// PushParser parses a document handed to it in chunks of any size, as
+// they arrive, instead of reading it off an io.Reader, for code that gets
+// its input pushed to it, such as an event loop reading a socket:
+//    p := NewPushParser(func(stanza *Element) error {
+//        ...
+//    })
+//    for chunk := range chunks {
+//        if err := p.Feed(chunk); err != nil {
+//            return err
+//        }
+//    }
+//    doc, err := p.Finish()
+// Everything that can be parsed from a chunk is parsed before Feed
+// returns, so the handler is called with each element as soon as the
+// chunk with its end tag has been fed.  The parser runs on a goroutine
+// of its own that waits for each chunk, which Finish stops, so Finish has
+// to be called even if the input is abandoned halfway through.
+//
+// A PushParser is not safe for use by more than one goroutine at a time.
+type PushParser struct {
+	handle func(*Element) error
+	opts   *ParseOptions
+	// chunks passes the input on to the parser, and is closed by Finish.
+	chunks chan []byte
+	// used is signalled when the parser has read all of a chunk.
+	used chan struct{}
+	// done is closed once the parser has stopped, after which doc and
+	// err hold what it found.
+	done     chan struct{}
+	doc      *Document
+	err      error
+	finished bool
+}
+
+// NewPushParser returns a PushParser, whose opts are the same as for
+// Parse.  If handle is nil, the document is built in full, and Finish
+// returns it.  Otherwise the document is read as a stream, as a
+// StanzaReader reads it, handle is called with each child of the root as
+// soon as it is complete, and the Document Finish returns has a root
+// with no children.  An error from handle stops the parser, and is
+// returned by Feed and Finish.
+func NewPushParser(handle func(*Element) error, opts ...Option) *PushParser {
+	return &PushParser{handle: handle, opts: &newSettings(opts).parse}
+}
+
+// pushReader reads the chunks fed to a PushParser.
+type pushReader struct {
+	p       *PushParser
+	chunk   []byte
+	started bool
+}
+
+func (r *pushReader) Read(b []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.started {
+			r.p.used <- struct{}{}
+		}
+		r.started = true
+		var ok bool
+		if r.chunk, ok = <-r.p.chunks; !ok {
+			return 0, io.EOF
+		}
+	}
+	n := copy(b, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+func (p *PushParser) start() {
+	if p.chunks != nil {
+		return
+	}
+	p.chunks, p.used, p.done = make(chan []byte), make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(p.done)
+		r := &pushReader{p: p}
+		if p.handle == nil {
+			p.doc, p.err = ParseWithOptions(r, p.opts)
+			return
+		}
+		s := &StanzaReader{r: r, opts: p.opts}
+		for {
+			stanza, err := s.Next()
+			if err == io.EOF {
+				p.doc = CreateDocument()
+				p.doc.SetDeclaration(s.Declaration())
+				if s.stream != nil {
+					p.doc.SetRoot(s.stream)
+				}
+				break
+			}
+			if err == nil {
+				err = p.handle(stanza)
+			}
+			if err != nil {
+				p.err = err
+				break
+			}
+		}
+	}()
+}
+
+// Feed parses the next chunk of the document.  The chunk is not used
+// once Feed returns, so its memory can be reused.  If the document is
+// not well-formed, or the handler fails, Feed returns the error, as do
+// all the calls to Feed after it.  Once the root element of a streamed
+// document has ended, the rest of what is fed is ignored.
+func (p *PushParser) Feed(chunk []byte) error {
+	if p.finished {
+		return ErrFinished
+	}
+	p.start()
+	if len(chunk) == 0 {
+		return nil
+	}
+	select {
+	case p.chunks <- chunk:
+	case <-p.done:
+		return p.err
+	}
+	select {
+	case <-p.used:
+		return nil
+	case <-p.done:
+		return p.err
+	}
+}
+
+// Finish tells the parser that the document has all been fed to it,
+// waits for it to parse what is left, and returns the Document, or the
+// error that stopped it.  A document that ends before its root element
+// does is an error, but one with no root element at all is not, as with
+// Parse.  Calling Finish again returns the same.
+func (p *PushParser) Finish() (*Document, error) {
+	if !p.finished {
+		p.finished = true
+		p.start()
+		close(p.chunks)
+	}
+	<-p.done
+	return p.doc, p.err
+}
@@ -0,0 +1,60 @@
+package dom
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// SetName renames node, and returns node.
+func (node *Element) SetName(space, local string) *Element {
+	node.Name = xml.Name{Space: space, Local: local}
+	node.touch()
+	return node
+}
+
+// lookupPrefix returns the namespace that prefix is bound to by the
+// declarations on node and its ancestors.  An empty prefix looks up the
+// default namespace.
+func (node *Element) lookupPrefix(prefix string) (string, bool) {
+	for n := node; n != nil; n = n.parent {
+		for _, a := range n.Attributes {
+			if (prefix == "" && a.Name.Space == "" && a.Name.Local == "xmlns") ||
+				(prefix != "" && a.Name.Space == "xmlns" && a.Name.Local == prefix) {
+				return a.Value, true
+			}
+		}
+	}
+	return "", false
+}
+
+// ParseQName resolves a prefixed name such as "soap:Body" against the
+// namespace declarations in scope at scope, that is the xmlns attributes
+// on scope and its ancestors, as in:
+//    name, err := ParseQName("soap:Body", envelope)
+// Names without a prefix are in the default namespace in scope, as
+// element names are, and the xml prefix is always bound.  scope may be
+// nil, in which case only those two kinds of names can be resolved.
+func ParseQName(qname string, scope *Element) (xml.Name, error) {
+	prefix, local := "", qname
+	if i := strings.IndexByte(qname, ':'); i >= 0 {
+		prefix, local = qname[:i], qname[i+1:]
+		if !IsNCName(prefix) {
+			return xml.Name{}, fmt.Errorf("dom: invalid prefix in name %q", qname)
+		}
+	}
+	if !IsNCName(local) {
+		return xml.Name{}, fmt.Errorf("dom: invalid name %q", qname)
+	}
+	switch prefix {
+	case "xml":
+		return xml.Name{Space: NS_XML, Local: local}, nil
+	case "xmlns":
+		return xml.Name{}, fmt.Errorf("dom: %q uses the reserved prefix xmlns", qname)
+	}
+	space, ok := scope.lookupPrefix(prefix)
+	if !ok && prefix != "" {
+		return xml.Name{}, fmt.Errorf("dom: unknown namespace prefix %s in %q", prefix, qname)
+	}
+	return xml.Name{Space: space, Local: local}, nil
+}
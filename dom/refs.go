@@ -0,0 +1,128 @@
+package dom
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+// RefKind is what an attribute is, as far as cross-references go.
+type RefKind int
+
+const (
+	// NotRef is an attribute that has nothing to do with
+	// cross-references.
+	NotRef RefKind = iota
+	// IDAttr is an attribute whose value identifies its element.
+	IDAttr
+	// IDRefAttr is an attribute whose value is the ID of another
+	// element.
+	IDRefAttr
+	// IDRefsAttr is an attribute whose value is a space-separated list
+	// of IDs.
+	IDRefsAttr
+)
+
+// RefTypes tells ResolveRefs what kind of attribute a, on e, is.  See
+// XMLIDs, and the dtd package for attributes declared in a DTD.
+type RefTypes func(e *Element, a xml.Attr) RefKind
+
+// XMLIDs is the RefTypes for documents that only use xml:id, and have no
+// references.
+func XMLIDs(e *Element, a xml.Attr) RefKind {
+	if a.Name.Space == NS_XML && a.Name.Local == "id" {
+		return IDAttr
+	}
+	return NotRef
+}
+
+// Ref is one reference from an element to an ID.
+type Ref struct {
+	// From is the element the reference is on, and Attr the name of
+	// the attribute it is in.
+	From *Element
+	Attr xml.Name
+	// ID is the ID referred to, and To the element with that ID, or
+	// nil if there is none.
+	ID string
+	To *Element
+}
+
+// Refs is the graph of references in a tree, as built by ResolveRefs.
+type Refs struct {
+	// IDs maps IDs to the elements they identify.  If several elements
+	// have the same ID, the first one gets it.
+	IDs map[string]*Element
+	// Duplicates holds the elements whose ID was already taken.
+	Duplicates []*Element
+	// Refs holds all the references, in document order.
+	Refs []Ref
+}
+
+// ResolveRefs finds the IDs in the tree rooted at node, and resolves the
+// references to them, with types saying which attributes are which.  It
+// takes a snapshot: the result does not change with the tree.
+func ResolveRefs(node *Element, types RefTypes) *Refs {
+	res := &Refs{IDs: map[string]*Element{}}
+	var walk func(e *Element)
+	walk = func(e *Element) {
+		for _, a := range e.Attributes {
+			switch types(e, a) {
+			case IDAttr:
+				id := strings.TrimSpace(a.Value)
+				if _, dup := res.IDs[id]; dup {
+					res.Duplicates = append(res.Duplicates, e)
+				} else {
+					res.IDs[id] = e
+				}
+			case IDRefAttr:
+				res.Refs = append(res.Refs, Ref{From: e, Attr: a.Name, ID: strings.TrimSpace(a.Value)})
+			case IDRefsAttr:
+				for _, id := range strings.Fields(a.Value) {
+					res.Refs = append(res.Refs, Ref{From: e, Attr: a.Name, ID: id})
+				}
+			}
+		}
+		e.pageIn()
+		for _, c := range e.children {
+			walk(c)
+		}
+	}
+	walk(node)
+	for i := range res.Refs {
+		res.Refs[i].To = res.IDs[res.Refs[i].ID]
+	}
+	return res
+}
+
+// Dangling returns the references to IDs no element has.
+func (r *Refs) Dangling() []Ref {
+	res := []Ref{}
+	for _, ref := range r.Refs {
+		if ref.To == nil {
+			res = append(res, ref)
+		}
+	}
+	return res
+}
+
+// From returns the references on e.
+func (r *Refs) From(e *Element) []Ref {
+	res := []Ref{}
+	for _, ref := range r.Refs {
+		if ref.From == e {
+			res = append(res, ref)
+		}
+	}
+	return res
+}
+
+// To returns the references to e.
+func (r *Refs) To(e *Element) []Ref {
+	res := []Ref{}
+	for _, ref := range r.Refs {
+		if ref.To == e {
+			res = append(res, ref)
+		}
+	}
+	return res
+}
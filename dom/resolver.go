@@ -0,0 +1,150 @@
+package dom
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Resolver opens the external resources documents refer to, such as the
+// external subset a DOCTYPE declaration names, the documents xi:include
+// elements include and the schema documents xs:include pulls in.  uri is
+// the reference already resolved against the base URI it was found
+// under, so it is often relative.  The features that follow references
+// only do so through the Resolver they are given, so that what a
+// document can reach is up to the application.
+type Resolver interface {
+	Resolve(uri string) (io.ReadCloser, error)
+}
+
+// ResolverFunc makes a function into a Resolver.
+type ResolverFunc func(uri string) (io.ReadCloser, error)
+
+// Resolve calls f.
+func (f ResolverFunc) Resolve(uri string) (io.ReadCloser, error) {
+	return f(uri)
+}
+
+// DirResolver returns a Resolver that reads relative URIs, and file:
+// URIs, from the directory dir.  It refuses other URIs, and those that
+// lead out of dir.
+func DirResolver(dir string) Resolver {
+	fsys := os.DirFS(dir)
+	return ResolverFunc(func(uri string) (io.ReadCloser, error) {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return nil, fmt.Errorf("dom: bad URI %q: %v", uri, err)
+		}
+		name := u.Path
+		switch {
+		case u.Scheme == "file" && (u.Host == "" || u.Host == "localhost"):
+			abs, err := filepath.Abs(dir)
+			if err != nil {
+				return nil, err
+			}
+			rel, err := filepath.Rel(abs, filepath.FromSlash(u.Path))
+			if err != nil {
+				return nil, &fs.PathError{Op: "open", Path: uri, Err: fs.ErrInvalid}
+			}
+			name = filepath.ToSlash(rel)
+		case u.Scheme != "" || u.Host != "":
+			return nil, &fs.PathError{Op: "open", Path: uri, Err: fs.ErrInvalid}
+		}
+		if !fs.ValidPath(name) {
+			return nil, &fs.PathError{Op: "open", Path: uri, Err: fs.ErrInvalid}
+		}
+		return fsys.Open(name)
+	})
+}
+
+// MultiResolver returns a Resolver that tries each of rs in turn, and
+// returns what the first one that succeeds opens, or the error of the
+// last one.
+func MultiResolver(rs ...Resolver) Resolver {
+	return ResolverFunc(func(uri string) (io.ReadCloser, error) {
+		err := fmt.Errorf("dom: cannot resolve %s", uri)
+		for _, r := range rs {
+			var res io.ReadCloser
+			if res, err = r.Resolve(uri); err == nil {
+				return res, nil
+			}
+		}
+		return nil, err
+	})
+}
+
+// HTTPResolver is a Resolver that fetches http and https URIs, and keeps
+// what it fetched, so that each one is only fetched once however many
+// documents refer to it.  Since it lets documents make requests, nothing
+// uses one unless the application asks it to.  An HTTPResolver is safe
+// for concurrent use.
+type HTTPResolver struct {
+	// Client makes the requests.  http.DefaultClient is used if it is
+	// nil.
+	Client *http.Client
+	// MaxBytes is the most that is read of a resource, and defaults to
+	// DefaultMaxResponseBytes.
+	MaxBytes int64
+	// NoCache stops resources from being kept.
+	NoCache bool
+	mu      sync.Mutex
+	cache   map[string][]byte
+}
+
+// NewHTTPResolver returns an HTTPResolver that makes its requests with
+// client.
+func NewHTTPResolver(client *http.Client) *HTTPResolver {
+	return &HTTPResolver{Client: client}
+}
+
+// Resolve fetches uri, unless it has been already.  Responses with other
+// statuses than 200 OK are errors, and are not kept.
+func (h *HTTPResolver) Resolve(uri string) (io.ReadCloser, error) {
+	if s := strings.ToLower(uri); !strings.HasPrefix(s, "http://") && !strings.HasPrefix(s, "https://") {
+		return nil, fmt.Errorf("dom: cannot fetch %s: not an http or https URI", uri)
+	}
+	h.mu.Lock()
+	b, ok := h.cache[uri]
+	h.mu.Unlock()
+	if ok {
+		return io.NopCloser(bytes.NewReader(b)), nil
+	}
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	limit := h.MaxBytes
+	if limit <= 0 {
+		limit = DefaultMaxResponseBytes
+	}
+	resp, err := client.Get(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("dom: fetching %s: %s", uri, resp.Status)
+	}
+	if b, err = io.ReadAll(io.LimitReader(resp.Body, limit+1)); err != nil {
+		return nil, fmt.Errorf("dom: fetching %s: %v", uri, err)
+	}
+	if int64(len(b)) > limit {
+		return nil, fmt.Errorf("dom: fetching %s: %w", uri, &TreeTooLargeError{Limit: limit})
+	}
+	if !h.NoCache {
+		h.mu.Lock()
+		if h.cache == nil {
+			h.cache = map[string][]byte{}
+		}
+		h.cache[uri] = b
+		h.mu.Unlock()
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
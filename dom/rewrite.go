@@ -0,0 +1,307 @@
+package dom
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+)
+
+// RenameAll renames every element in the tree rooted at node, node
+// included, that is called from to to, and returns how many it renamed.
+// A from.Space of "*" matches any namespace, as in:
+//    RenameAll(root, xml.Name{Space: "*", Local: "colour"}, xml.Name{Local: "color"})
+func RenameAll(node *Element, from, to xml.Name) int {
+	return RenameFunc(node, func(e *Element) (xml.Name, bool) {
+		if e.Name.Local == from.Local && (from.Space == "*" || e.Name.Space == from.Space) {
+			return to, true
+		}
+		return xml.Name{}, false
+	})
+}
+
+// RenameFunc calls rename for every element in the tree rooted at node,
+// node included, and gives each element it returns true for the name it
+// returns.  It returns how many elements it renamed.  The tree is walked
+// top down, so rename sees the new names of the ancestors of an element.
+func RenameFunc(node *Element, rename func(e *Element) (xml.Name, bool)) int {
+	n := 0
+	var walk func(e *Element)
+	walk = func(e *Element) {
+		if name, ok := rename(e); ok && name != e.Name {
+			e.Name = name
+			n++
+		}
+		e.pageIn()
+		for _, c := range e.children {
+			walk(c)
+		}
+	}
+	walk(node)
+	if n > 0 {
+		node.touch()
+	}
+	return n
+}
+
+// RewriteAttrs calls rewrite for every attribute of every element in the
+// tree rooted at node, node included, and replaces the attribute with
+// the one it returns, or drops the attribute if it returns false.  This
+// renames, changes or strips attributes across a whole tree in one pass,
+// as in:
+//    RewriteAttrs(root, func(owner *Element, a xml.Attr) (xml.Attr, bool) {
+//        a.Name.Local = strings.ToLower(a.Name.Local)
+//        return a, a.Name.Space != vendorNS
+//    })
+// If an attribute is renamed to the name of one of its owner's
+// attributes that is already kept, its value replaces that one's, as
+// with AddAttr.  It returns how many attributes were changed or dropped.
+func RewriteAttrs(node *Element, rewrite func(owner *Element, a xml.Attr) (xml.Attr, bool)) int {
+	n := 0
+	for _, e := range node.All() {
+		if len(e.Attributes) == 0 {
+			continue
+		}
+		// rewrite may look at the attributes of owner, so they are
+		// left alone until it has seen them all.
+		changed := 0
+		kept := make([]xml.Attr, 0, len(e.Attributes))
+	Attrs:
+		for _, a := range e.Attributes {
+			res, keep := rewrite(e, a)
+			if !keep {
+				changed++
+				continue
+			}
+			if res != a {
+				changed++
+			}
+			for i := range kept {
+				if kept[i].Name == res.Name {
+					kept[i].Value = res.Value
+					continue Attrs
+				}
+			}
+			kept = append(kept, res)
+		}
+		if changed > 0 {
+			e.Attributes = kept
+			n += changed
+		}
+	}
+	if n > 0 {
+		node.touch()
+	}
+	return n
+}
+
+// declPrefix returns the prefix a declares, if it is a namespace
+// declaration, with "" for the default namespace.
+func declPrefix(a xml.Attr) (string, bool) {
+	switch {
+	case a.Name.Space == "xmlns":
+		return a.Name.Local, true
+	case a.Name.Space == "" && a.Name.Local == "xmlns":
+		return "", true
+	}
+	return "", false
+}
+
+// usesPrefix reports whether an attribute value or the Content of e is a
+// prefixed name with prefix, such as the xs:string in xsi:type values,
+// which only the declaration of the prefix gives a meaning.
+func usesPrefix(e *Element, prefix string) bool {
+	if prefix == "" {
+		return false
+	}
+	for _, a := range e.Attributes {
+		if _, ok := declPrefix(a); !ok && strings.HasPrefix(strings.TrimSpace(a.Value), prefix+":") {
+			return true
+		}
+	}
+	return bytes.HasPrefix(e.Content, []byte(prefix+":"))
+}
+
+// declUsed reports whether the declaration of prefix on e, for ns, is
+// needed below it: whether anything in the tree rooted at e it is in
+// scope for is in ns, or has a value that uses prefix.  Declarations of
+// prefix for ns further down are dropped as repeats, so they do not hide
+// it.
+func declUsed(e *Element, prefix, ns string, top bool) bool {
+	if !top {
+		for _, a := range e.Attributes {
+			if p, ok := declPrefix(a); ok && p == prefix && a.Value != ns {
+				return false
+			}
+		}
+	}
+	if e.Name.Space == ns || usesPrefix(e, prefix) {
+		return true
+	}
+	for _, a := range e.Attributes {
+		if _, ok := declPrefix(a); !ok && a.Name.Space == ns {
+			return true
+		}
+	}
+	e.pageIn()
+	for _, c := range e.children {
+		if declUsed(c, prefix, ns, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// CleanNamespaces tidies up the namespace declarations in the tree rooted
+// at node, which tree surgery tends to leave scattered and repeated as
+// elements are moved between documents.  It moves declarations that all
+// the children of an element make onto the element, from the bottom of
+// the tree up, then drops the declarations that are already in scope
+// from further up, and those of namespaces that are in scope under
+// another prefix, and finally the ones nothing in their scope uses:
+//    <a><b xmlns:x="urn:x"><x:c xmlns:x="urn:x" xmlns:y="urn:y"/></b><b xmlns:x="urn:x"/></a>
+// becomes
+//    <a xmlns:x="urn:x"><b><x:c/></b><b/></a>
+// Since names are stored resolved, this does not change what any of them
+// mean, but the Encoder uses the prefixes the declarations give, and
+// ParseQName looks them up.  A declaration also counts as used if an
+// attribute value or Content in its scope starts with its prefix and a
+// colon, as QName values such as xsi:type="xs:string" do, and such
+// declarations are only moved, not dropped.  It returns how many
+// declarations it moved or dropped.
+func CleanNamespaces(node *Element) int {
+	n := hoistDecls(node)
+	// What is in scope from above node.
+	scope := map[string]string{}
+	var above []*Element
+	for p := node.parent; p != nil; p = p.parent {
+		above = append(above, p)
+	}
+	for i := len(above) - 1; i >= 0; i-- {
+		for _, a := range above[i].Attributes {
+			if prefix, ok := declPrefix(a); ok {
+				scope[prefix] = a.Value
+			}
+		}
+	}
+	n += dropDecls(node, scope)
+	if n > 0 {
+		node.touch()
+	}
+	return n
+}
+
+// hoistDecls moves the namespace declarations all the children of e make
+// onto e, which must not declare their prefixes itself or use them in
+// its values, and returns how many it moved.
+func hoistDecls(e *Element) int {
+	n := 0
+	e.pageIn()
+	for _, c := range e.children {
+		n += hoistDecls(c)
+	}
+	if len(e.children) == 0 {
+		return n
+	}
+	// removeAttr compacts the Attributes of the first child in place.
+	for _, a := range append([]xml.Attr(nil), e.children[0].Attributes...) {
+		prefix, ok := declPrefix(a)
+		if !ok || usesPrefix(e, prefix) {
+			continue
+		}
+		shared := true
+		for _, ea := range e.Attributes {
+			if p, ok := declPrefix(ea); ok && p == prefix {
+				shared = false
+			}
+		}
+		for _, c := range e.children[1:] {
+			found := false
+			for _, ca := range c.Attributes {
+				found = found || ca == a
+			}
+			shared = shared && found
+		}
+		if !shared {
+			continue
+		}
+		for _, c := range e.children {
+			c.Attributes = removeAttr(c.Attributes, a)
+			n++
+		}
+		e.Attributes = append(e.Attributes, a)
+	}
+	return n
+}
+
+func removeAttr(attrs []xml.Attr, a xml.Attr) []xml.Attr {
+	res := attrs[:0]
+	for _, b := range attrs {
+		if b != a {
+			res = append(res, b)
+		}
+	}
+	return res
+}
+
+// subtreeUsesPrefix reports whether usesPrefix holds for e or anything
+// below it.
+func subtreeUsesPrefix(e *Element, prefix string) bool {
+	if usesPrefix(e, prefix) {
+		return true
+	}
+	e.pageIn()
+	for _, c := range e.children {
+		if subtreeUsesPrefix(c, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// dropDecls drops the namespace declarations in the tree rooted at e
+// that are not needed, given what scope says is bound above it, and
+// returns how many it dropped.
+func dropDecls(e *Element, scope map[string]string) int {
+	n := 0
+	inner := scope
+	copied := false
+	var kept []xml.Attr
+	for _, a := range e.Attributes {
+		prefix, ok := declPrefix(a)
+		ns, bound := inner[prefix]
+		drop := false
+		switch {
+		case !ok:
+		case bound && ns == a.Value, !declUsed(e, prefix, a.Value, true):
+			drop = true
+		case prefix != "" && !subtreeUsesPrefix(e, prefix):
+			// Another prefix for the same namespace will do.
+			for p, ns := range inner {
+				drop = drop || (p != "" && ns == a.Value)
+			}
+		}
+		if drop {
+			n++
+			continue
+		}
+		if ok {
+			if !copied {
+				// scope still holds for the siblings of e.
+				inner, copied = map[string]string{}, true
+				for p, ns := range scope {
+					inner[p] = ns
+				}
+			}
+			inner[prefix] = a.Value
+		}
+		kept = append(kept, a)
+	}
+	if n > 0 {
+		e.Attributes = kept
+	}
+	e.pageIn()
+	for _, c := range e.children {
+		n += dropDecls(c, inner)
+	}
+	return n
+}
@@ -0,0 +1,58 @@
+package dom
+
+import (
+	"encoding/xml"
+)
+
+// A WhitespacePolicy decides which elements have their text kept as it
+// was written, whitespace and all.  By default, the parser trims the
+// whitespace off the ends of each run of text, and drops runs that are
+// only whitespace, such as the indentation between elements.  In an
+// element the policy preserves, runs of text are kept untouched, and
+// one that is only whitespace is a run like any other, so it becomes
+// the Content of the element if it comes last.  An element is preserved
+// if its parent is, unless the policy says otherwise.
+type WhitespacePolicy struct {
+	// Preserve lists the elements to preserve, along with everything in
+	// them.  A Space of "*" matches any namespace.
+	Preserve []xml.Name
+	// XMLSpace follows xml:space attributes in the document, so that
+	// xml:space="preserve" preserves an element and xml:space="default"
+	// goes back to trimming, whatever Preserve says.
+	XMLSpace bool
+	// Hint, if set, has the last word on each element, such as from
+	// the types a schema gives to elements.  It is called with the
+	// names of the element's ancestors from the top of the document
+	// down, followed by its own name, as ParseOptions.Skip is, and with
+	// whether the rest of the policy would preserve the element.  path
+	// is only valid during the call.
+	Hint func(path []xml.Name, preserve bool) bool
+}
+
+// preserve reports whether w preserves the element tok starts, which
+// is at path and inside an element where the decision was inherited.
+func (w *WhitespacePolicy) preserve(tok xml.StartElement, path []xml.Name, inherited bool) bool {
+	res := inherited
+	for _, n := range w.Preserve {
+		if n.Local == tok.Name.Local && (n.Space == "*" || n.Space == tok.Name.Space) {
+			res = true
+			break
+		}
+	}
+	if w.XMLSpace {
+		for _, a := range tok.Attr {
+			if a.Name.Space == NS_XML && a.Name.Local == "space" {
+				switch a.Value {
+				case "preserve":
+					res = true
+				case "default":
+					res = false
+				}
+			}
+		}
+	}
+	if w.Hint != nil {
+		res = w.Hint(path, res)
+	}
+	return res
+}
@@ -0,0 +1,308 @@
+package dom
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/xml"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// A Spool is a temporary file that subtrees are spilled to, so that
+// programs can work on documents larger than memory with the same
+// Element API.  Spilling an element writes out everything below it and
+// drops it from memory, and it is paged back in when it is looked at:
+// by Children, NumChildren, Child and the methods built on them, or by
+// AddChild, Clone and Export.  Encoding a spilled element, with an
+// Encoder or a StreamWriter, reads what is below it from the Spool
+// without keeping it, so large trees can be written out again without
+// being paged in.  The Content and Attributes of a spilled element stay
+// in memory, since they are fields, and so does the element itself;
+// SpillContent moves large text out as well.
+//
+// What is paged in stays in memory until it is spilled again, so after
+// a walk over the whole tree, such as an Index or a Clone, all of it is
+// in memory.  Evict spills it again.
+//
+// Set ParseOptions.Spool to have the parser spill elements as soon as
+// it is done with them, so that documents can be opened without ever
+// holding all of them in memory.  A Spool is safe for concurrent use,
+// but the trees spilled to it are no more so than any other tree.
+type Spool struct {
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// spilled records where the subtree below an element is in a Spool.
+type spilled struct {
+	spool  *Spool
+	off, n int64
+}
+
+// spillData is what a Spool holds for each element spilled to it.  It
+// is an ElementData, except that the children of an element spilled to
+// the same Spool already are left where they are, and pointed at by Off
+// and N, so that spilling the parent does not read them back in.
+type spillData struct {
+	Name       xml.Name
+	Attributes []xml.Attr
+	Content    []byte
+	Children   []*spillData
+	Pos        Position
+	Spilled    bool
+	Off, N     int64
+	// TextSpilled is set if the text of the element is streamed from
+	// the Spool, from TextOff for TextN bytes, and base64 encoded from
+	// them if TextEncode is set.
+	TextSpilled    bool
+	TextOff, TextN int64
+	TextEncode     bool
+	// ContentRefs and RefAttrs are as in ElementData.
+	ContentRefs bool
+	RefAttrs    []xml.Name
+}
+
+// spillData returns what s is to hold for node.
+func (s *Spool) spillData(node *Element) (*spillData, error) {
+	res := &spillData{Name: node.Name, Attributes: node.Attributes, Content: node.Content, Pos: node.pos}
+	res.ContentRefs, res.RefAttrs = node.HasEntityRefs()
+	if node.ContentStreamed() {
+		// Streamed text is copied into s, unless it is there already.
+		t := node.stream
+		if t.spilled == nil || t.spilled.spool != s {
+			r, err := t.open()
+			if err != nil {
+				return nil, &Error{Op: "spill", Path: node.Path(), Err: err}
+			}
+			off, n, err := s.writeText(r)
+			if err != nil {
+				return nil, &Error{Op: "spill", Path: node.Path(), Err: err}
+			}
+			t = s.text(off, n, t.encode)
+		}
+		res.TextSpilled, res.TextOff, res.TextN, res.TextEncode = true, t.spilled.off, t.spilled.n, t.encode
+	}
+	if node.spill != nil && node.spill.spool == s {
+		res.Spilled, res.Off, res.N = true, node.spill.off, node.spill.n
+		return res, nil
+	}
+	children, err := node.kids()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range children {
+		data, err := s.spillData(c)
+		if err != nil {
+			return nil, err
+		}
+		res.Children = append(res.Children, data)
+	}
+	return res, nil
+}
+
+// element makes the Element data is for, with parent as its parent.  The
+// children that were spilled when data was are left in s.
+func (s *Spool) element(data *spillData, parent *Element) *Element {
+	res := CreateElement(data.Name)
+	res.Attributes = data.Attributes
+	res.Content = data.Content
+	res.pos = data.Pos
+	res.parent = parent
+	res.SetEntityRefs(data.ContentRefs, data.RefAttrs...)
+	if data.TextSpilled {
+		res.stream = s.text(data.TextOff, data.TextN, data.TextEncode)
+	}
+	if data.Spilled {
+		res.spill = &spilled{spool: s, off: data.Off, n: data.N}
+	}
+	for _, c := range data.Children {
+		res.children = append(res.children, s.element(c, res))
+	}
+	return res
+}
+
+// NewSpool creates a Spool in a new temporary file in dir, or in the
+// default directory for temporary files if dir is "".
+func NewSpool(dir string) (*Spool, error) {
+	f, err := os.CreateTemp(dir, "simplexml-spool-*")
+	if err != nil {
+		return nil, err
+	}
+	return &Spool{f: f}, nil
+}
+
+// Close removes the file.  Elements that are still spilled to the Spool
+// cannot be paged in afterwards.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.f.Close()
+	if rerr := os.Remove(s.f.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// Spill writes out the descendants of node and drops them from memory,
+// leaving node in the tree with its Content and Attributes.  Elements
+// below node that are held on to elsewhere are no longer in the tree,
+// and paging in makes new ones.  Spilling an element that was paged in
+// writes it out again, since the file is only ever added to, but the
+// elements below it that are still spilled to s are only pointed at,
+// not read back in and written out again, so that spilling each level
+// of a tree as it is parsed never holds more than one level in memory.
+// When node is paged back in, they come back still spilled.  Streamed
+// text below node is copied into s, unless it is there already, so that
+// it can be read again after node is paged back in.  Spilling an
+// element without children, or one that is already spilled, does
+// nothing.  The subtree is kept as it was, Positions and all.
+func (s *Spool) Spill(node *Element) error {
+	if node.spill != nil || len(node.children) == 0 {
+		return nil
+	}
+	data := make([]*spillData, len(node.children))
+	for i, c := range node.children {
+		var err error
+		if data[i], err = s.spillData(c); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := &byteCounter{w: io.NewOffsetWriter(s.f, s.size)}
+	if err := gob.NewEncoder(out).Encode(data); err != nil {
+		return &Error{Op: "spill", Path: node.Path(), Err: err}
+	}
+	for _, c := range node.children {
+		c.parent = nil
+	}
+	node.spill = &spilled{spool: s, off: s.size, n: out.n}
+	node.children = nil
+	s.size += out.n
+	node.touch()
+	return nil
+}
+
+// Evict spills again the elements depth levels below node that are in
+// memory, as the parser spills the elements at SpillDepth, so that what
+// a walk over a large tree paged in can be dropped from memory once it
+// is done.  Evict(node, 0) spills node itself.  Only the parts of the
+// tree that are in memory are looked at, and elements below the ones
+// spilled that are still spilled are only pointed at, as Spill does.
+func (s *Spool) Evict(node *Element, depth int) error {
+	if depth <= 0 {
+		return s.Spill(node)
+	}
+	for _, c := range node.children {
+		if err := s.Evict(c, depth-1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SpillContent writes the Content of node out to s and drops it from
+// memory, leaving the text of node streamed from s, as
+// SetContentStreamAt would, until LoadContent reads it back in.  It
+// does nothing if node has no Content, or if its Content holds
+// EntityRefs, which streamed text cannot.
+func (s *Spool) SpillContent(node *Element) error {
+	if len(node.Content) == 0 || node.contentRefs() {
+		return nil
+	}
+	off, n, err := s.writeText(bytes.NewReader(node.Content))
+	if err != nil {
+		return &Error{Op: "spill", Path: node.Path(), Err: err}
+	}
+	node.setStream(s.text(off, n, false))
+	return nil
+}
+
+// writeText adds what is read from r to s, and returns where it is.
+func (s *Spool) writeText(r io.Reader) (off, n int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	off = s.size
+	n, err = io.Copy(io.NewOffsetWriter(s.f, off), r)
+	s.size += n
+	return off, n, err
+}
+
+// text returns the streamed text of the n bytes at off in s, or their
+// base64 encoding if encode is set.
+func (s *Spool) text(off, n int64, encode bool) *streamed {
+	res := &streamed{size: n, encode: encode, spilled: &spilled{spool: s, off: off, n: n}, open: func() (io.Reader, error) {
+		return io.NewSectionReader(s.f, off, n), nil
+	}}
+	if encode {
+		res.size = (n + 2) / 3 * 4
+	}
+	return res
+}
+
+// Spilled reports whether what is below node is in a Spool.
+func (node *Element) Spilled() bool {
+	return node.spill != nil
+}
+
+// PageIn reads what is below node back from the Spool it was spilled to,
+// if it was spilled.  Elements are paged in when they are looked at
+// anyway, but only PageIn can say if reading fails; the methods that
+// page in without an error to return panic instead.
+func (node *Element) PageIn() error {
+	if node.spill == nil {
+		return nil
+	}
+	children, err := node.spill.read(node)
+	if err != nil {
+		return err
+	}
+	node.children, node.spill = children, nil
+	node.touch()
+	return nil
+}
+
+// pageIn pages node in for methods that have no error to return.
+func (node *Element) pageIn() {
+	if node.spill != nil {
+		if err := node.PageIn(); err != nil {
+			log.Panic(err)
+		}
+	}
+}
+
+// read returns the children of node that s holds.  They are not put in
+// the tree, but their parent is node.
+func (s *spilled) read(node *Element) ([]*Element, error) {
+	var data []*spillData
+	if err := gob.NewDecoder(io.NewSectionReader(s.spool.f, s.off, s.n)).Decode(&data); err != nil {
+		return nil, &Error{Op: "spill", Path: node.Path(), Err: err}
+	}
+	children := make([]*Element, len(data))
+	for i, d := range data {
+		children[i] = s.spool.element(d, node)
+	}
+	return children, nil
+}
+
+// kids returns the children of node, reading them from the Spool
+// without paging them in if node is spilled.
+func (node *Element) kids() ([]*Element, error) {
+	if node.spill == nil {
+		return node.children, nil
+	}
+	return node.spill.read(node)
+}
+
+// mustKids is kids for walks that have no error to return, which panic
+// instead, as pageIn does.
+func (node *Element) mustKids() []*Element {
+	children, err := node.kids()
+	if err != nil {
+		log.Panic(err)
+	}
+	return children
+}
@@ -0,0 +1,99 @@
+package dom
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"fmt"
+)
+
+// The Scan and Value methods let Documents and Elements be read from and
+// written to XML columns (such as xml in Postgres or XMLTYPE in Oracle)
+// with database/sql.
+
+func scanBytes(src interface{}) ([]byte, error) {
+	switch src := src.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return src, nil
+	case string:
+		return []byte(src), nil
+	}
+	return nil, fmt.Errorf("dom: cannot scan a %T as XML", src)
+}
+
+func compact(encode func(*Encoder) error) (driver.Value, error) {
+	var b bytes.Buffer
+	encoder := NewEncoder(&b)
+	if err := encode(encoder); err != nil {
+		return nil, err
+	}
+	if err := encoder.Flush(); err != nil {
+		return nil, err
+	}
+	return b.String(), nil
+}
+
+// Scan implements sql.Scanner.  It replaces the contents of doc with the
+// document parsed from src, which must be a []byte or a string.  A NULL
+// value leaves doc without a root element.
+func (doc *Document) Scan(src interface{}) error {
+	buf, err := scanBytes(src)
+	if err != nil {
+		return err
+	}
+	return doc.load(buf)
+}
+
+// load replaces the contents of doc with the document in buf.  An empty
+// buf leaves doc without a root element.
+func (doc *Document) load(buf []byte) error {
+	parsed := CreateDocument()
+	if len(buf) != 0 {
+		var err error
+		if parsed, err = Parse(bytes.NewReader(buf)); err != nil {
+			return err
+		}
+	}
+	doc.take(parsed)
+	return nil
+}
+
+// Value implements driver.Valuer.  It returns doc encoded without
+// pretty-printing, or NULL if doc has no root element.
+func (doc *Document) Value() (driver.Value, error) {
+	if doc.root == nil {
+		return nil, nil
+	}
+	return compact(doc.Encode)
+}
+
+// Scan implements sql.Scanner.  It replaces node with the element parsed
+// from src, which must be a []byte or a string holding exactly one
+// element.  node keeps its place in its tree.
+func (node *Element) Scan(src interface{}) error {
+	buf, err := scanBytes(src)
+	if err != nil {
+		return err
+	}
+	if buf == nil {
+		return fmt.Errorf("dom: cannot scan NULL into an Element")
+	}
+	elements, err := ParseElements(bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	if len(elements) != 1 {
+		return fmt.Errorf("dom: expected one element, got %d", len(elements))
+	}
+	parsed := elements[0]
+	node.Replace(parsed)
+	node.pos = parsed.pos
+	return nil
+}
+
+// Value implements driver.Valuer.  It returns node encoded without
+// pretty-printing.
+func (node *Element) Value() (driver.Value, error) {
+	return compact(node.Encode)
+}
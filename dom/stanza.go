@@ -0,0 +1,152 @@
+package dom
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+// A StanzaReader reads a long-lived stream that is one XML document
+// whose root element only ends when the stream does, such as an XMPP or
+// EPP session, and returns each child of the root, a stanza, as soon as
+// its end tag has been read, without waiting for anything after it:
+//    r := NewStanzaReader(conn)
+//    stream, err := r.Stream()
+//    ...
+//    for {
+//        stanza, err := r.Next()
+//        if err == io.EOF {
+//            break
+//        }
+//        ...
+//    }
+// Stanzas are not added to the root, so a StanzaReader only ever holds
+// the one being read.  Their names are resolved against the namespace
+// declarations on the root, but since they have no parent, the
+// declarations are not in scope for ParseQName; look them up on the
+// root instead.
+//
+// opts are the same as for Parse.  MaxTreeBytes, MaxInputBytes and the
+// limits Untrusted sets apply to each stanza on its own, so that a
+// stream can go on for as long as it likes, and MaxDepth counts the
+// root.
+type StanzaReader struct {
+	r      io.Reader
+	opts   *ParseOptions
+	p      *parser
+	stream *Element
+	decl   Declaration
+	err    error
+}
+
+// NewStanzaReader returns a StanzaReader that reads the stream from r.
+// Nothing is read until Stream or Next is called.
+func NewStanzaReader(r io.Reader, opts ...Option) *StanzaReader {
+	return &StanzaReader{r: r, opts: &newSettings(opts).parse}
+}
+
+// Stream reads the stream up to the start tag of its root element, if
+// it has not been read yet, and returns the root, with its name and
+// attributes but no children.  Protocols in which the receiving end
+// answers the start of the stream, as XMPP's does, call it before the
+// first Next.  It returns io.EOF if the stream ends before the root
+// starts.
+func (s *StanzaReader) Stream() (*Element, error) {
+	if s.stream != nil || s.err != nil {
+		return s.stream, s.err
+	}
+	if s.p == nil {
+		// Setting up the parser reads the XML declaration.
+		s.p = newParser(s.r, s.opts)
+	}
+	p := s.p
+	for {
+		p.consumed()
+		tok, pos, err := token(p.decoder)
+		if err == nil && p.untrusted {
+			err = untrusted(tok, pos)
+		}
+		if err != nil {
+			if err != io.EOF {
+				err = p.fail(err)
+			}
+			s.err = err
+			return nil, err
+		}
+		switch rt := tok.(type) {
+		case xml.ProcInst:
+			if rt.Target == "xml" {
+				s.decl = parseDeclaration(string(rt.Inst))
+				if p.xml11 != nil {
+					s.decl.Version = "1.1"
+				}
+			}
+		case xml.StartElement:
+			s.stream = CreateElement(rt.Name)
+			s.stream.Attributes = rt.Attr
+			s.stream.pos, s.stream.uri = pos, p.baseURI
+			if p.refs {
+				findRefs(s.stream)
+			}
+			if p.skip != nil || (p.space != nil && p.space.Hint != nil) {
+				p.path = append(p.path, rt.Name)
+			}
+			if p.space != nil {
+				p.preserve = p.space.preserve(rt, p.path, false)
+			}
+			p.depth = 1
+			return s.stream, nil
+		}
+	}
+}
+
+// Declaration returns what the XML declaration at the start of the
+// stream said, once Stream has read past it.
+func (s *StanzaReader) Declaration() Declaration {
+	return s.decl
+}
+
+// Next returns the next stanza, reading the start of the stream first
+// if Stream has not.  It returns io.EOF once the root has ended.
+// Stanzas that ParseOptions.Skip skips are read past, and text in the
+// root between stanzas, such as the whitespace XMPP sends to keep a
+// connection alive, is ignored.  Errors are final: once Next has
+// returned one, it returns the same one from then on.
+func (s *StanzaReader) Next() (*Element, error) {
+	if _, err := s.Stream(); err != nil {
+		return nil, err
+	}
+	p := s.p
+	for {
+		for _, in := range p.inputs {
+			in.left = in.limit
+		}
+		p.budget = p.limit
+		p.consumed()
+		tok, pos, err := token(p.decoder)
+		if err == nil && p.untrusted {
+			err = untrusted(tok, pos)
+		}
+		if err != nil {
+			s.err = p.fail(err)
+			return nil, s.err
+		}
+		switch rt := tok.(type) {
+		case xml.StartElement:
+			stanza, err := p.element(rt, pos)
+			if err != nil {
+				perr := err.(*Error)
+				perr.Path = "/" + s.stream.Name.Local + perr.Path
+				s.err = perr
+				return nil, s.err
+			}
+			if stanza == nil {
+				continue
+			}
+			stanza.uri = p.baseURI
+			return stanza, nil
+		case xml.EndElement:
+			s.err = io.EOF
+			return nil, s.err
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package dom
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// TreeStats describes the size of a tree, as reported by Stats.
+type TreeStats struct {
+	// Elements and Attributes count the elements and attributes in the
+	// tree.  Namespace declarations count as attributes.
+	Elements, Attributes int
+	// TextBytes is the total length of the Content of all the elements.
+	TextBytes int
+	// MaxDepth is the number of elements on the longest path from the
+	// top of the tree down, so a lone element has a MaxDepth of 1.
+	MaxDepth int
+	// HeapBytes estimates the memory held by the tree: the Elements
+	// themselves, the capacity of their slices, and the strings in their
+	// names and attributes.  Strings shared between elements, as with
+	// ParseOptions.InternNames, are counted once.  Parts of the tree
+	// that share memory with something else, as with
+	// ParseBytesZeroCopy, are counted as if they did not.
+	HeapBytes int64
+}
+
+func (s TreeStats) String() string {
+	return fmt.Sprintf("%d elements, %d attributes, %d text bytes, depth %d, ~%d bytes",
+		s.Elements, s.Attributes, s.TextBytes, s.MaxDepth, s.HeapBytes)
+}
+
+var (
+	elementSize = int64(unsafe.Sizeof(Element{}))
+	attrSize    = int64(unsafe.Sizeof(xml.Attr{}))
+	pointerSize = int64(unsafe.Sizeof(&Element{}))
+)
+
+// Stats reports the size of the tree rooted at node, for capacity
+// planning and for finding out why a document takes up so much memory.
+// The parts of the tree spilled to a Spool are left out, since they are
+// not in memory.
+func Stats(node *Element) TreeStats {
+	res := TreeStats{}
+	seen := map[*byte]bool{}
+	str := func(s string) {
+		if len(s) == 0 {
+			return
+		}
+		if p := unsafe.StringData(s); !seen[p] {
+			seen[p] = true
+			res.HeapBytes += int64(len(s))
+		}
+	}
+	name := func(n xml.Name) {
+		str(n.Space)
+		str(n.Local)
+	}
+	var walk func(e *Element, depth int)
+	walk = func(e *Element, depth int) {
+		res.Elements++
+		if depth > res.MaxDepth {
+			res.MaxDepth = depth
+		}
+		res.Attributes += len(e.Attributes)
+		res.TextBytes += len(e.Content)
+		res.HeapBytes += elementSize + int64(cap(e.children))*pointerSize +
+			int64(cap(e.Attributes))*attrSize + int64(cap(e.Content))
+		name(e.Name)
+		for _, a := range e.Attributes {
+			name(a.Name)
+			str(a.Value)
+		}
+		for _, c := range e.children {
+			walk(c, depth+1)
+		}
+	}
+	walk(node, 1)
+	return res
+}
+
+// Dump returns an outline of the tree rooted at node, with one line for
+// each element, indented by depth, giving its name, how many attributes
+// it has, how long its Content is and where it was parsed from, as in:
+//    {urn:sales}order attrs=1 text=0 pos=1:1
+//      {urn:sales}line attrs=2 text=0 pos=2:3
+//        {urn:sales}sku attrs=0 text=8 pos=3:5
+// It is meant for finding out why a tree is not what it was expected to
+// be, which is easier without all of the markup.
+func (node *Element) Dump() string {
+	var b strings.Builder
+	var walk func(e *Element, depth int)
+	walk = func(e *Element, depth int) {
+		b.WriteString(strings.Repeat("  ", depth))
+		if e.Name.Space != "" {
+			b.WriteString("{" + e.Name.Space + "}")
+		}
+		fmt.Fprintf(&b, "%s attrs=%d text=%d pos=%v\n", e.Name.Local, len(e.Attributes), len(e.Content), e.pos)
+		for _, c := range e.mustKids() {
+			walk(c, depth+1)
+		}
+	}
+	walk(node, 0)
+	return b.String()
+}
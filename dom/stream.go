@@ -0,0 +1,397 @@
+package dom
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// StreamWriter writes a document an element at a time, for producers of
+// documents too large to hold as a tree, as in:
+//    w := NewStreamWriter(out, WithPretty())
+//    w.OpenElement(xml.Name{Local: "orders"})
+//    for _, o := range orders {
+//        w.EmitElement(o.Element())
+//    }
+//    err := w.Close()
+// It writes the same markup an Encoder writes for the same tree, pretty
+// printed the same way, except that since the whole tree is never known
+// up front, namespaces are declared on the outermost element that needs
+// them rather than on the root.  As with the Encoder, names are always
+// written with prefixes, the ones given in xmlns: attributes where they
+// can be used, so default namespace declarations are left out.
+//
+// Everything is checked as it is written, as WellFormed would check it,
+// along with the order of the calls, so the output is always well
+// formed.  Once a call fails, every later call returns the same error,
+// since the output written so far cannot be completed.
+type StreamWriter struct {
+	e    *Encoder
+	open []*streamFrame
+	// pending is set while the start tag of the innermost open element
+	// has not been written, so that attributes can still be added.
+	pending bool
+	started bool
+	done    bool
+	err     error
+}
+
+// streamFrame is an element a StreamWriter has open.
+type streamFrame struct {
+	name  xml.Name
+	attrs []xml.Attr
+	qname string
+	// decls maps the namespaces declared on the element to their
+	// prefixes.
+	decls    map[string]string
+	children bool
+	// refs is where the element holds EntityRefs, if it is written
+	// by EmitElement.
+	refs *entityRefs
+}
+
+// NewStreamWriter returns a StreamWriter that writes to w, set up by the
+// same options as NewEncoder.
+func NewStreamWriter(w io.Writer, opts ...Option) *StreamWriter {
+	return &StreamWriter{e: NewEncoder(w, opts...)}
+}
+
+// path returns the path to the innermost open element, in the form the
+// parser reports them in.
+func (s *StreamWriter) path() string {
+	res := ""
+	for _, f := range s.open {
+		res += "/" + f.name.Local
+	}
+	return res
+}
+
+// fail makes err the error every later call returns.
+func (s *StreamWriter) fail(err error) error {
+	if e, ok := err.(*Error); ok {
+		e.Op, e.Path = "encode", s.path()
+	} else {
+		err = &Error{Op: "encode", Path: s.path(), Err: err}
+	}
+	s.err = err
+	return err
+}
+
+// WriteDeclaration writes an XML declaration, which must come before
+// anything else.
+func (s *StreamWriter) WriteDeclaration(decl Declaration) error {
+	if s.err != nil {
+		return s.err
+	}
+	if s.started {
+		return s.fail(errors.New("the XML declaration must come first"))
+	}
+	s.started = true
+	if err := writeDeclaration(s.e, decl); err != nil {
+		return s.fail(err)
+	}
+	return nil
+}
+
+// OpenElement opens an element inside the one that is open, or the root
+// element if none is.  attrs are its attributes, and more can be added
+// with Attr until anything is written inside it.
+func (s *StreamWriter) OpenElement(name xml.Name, attrs ...xml.Attr) error {
+	if s.err != nil {
+		return s.err
+	}
+	if s.done {
+		return s.fail(ErrTooManyRootElements)
+	}
+	if err := s.start(false); err != nil {
+		return s.fail(err)
+	}
+	s.started = true
+	if n := len(s.open); n > 0 && !s.open[n-1].children {
+		s.open[n-1].children = true
+		if err := s.e.prettyEnd(); err != nil {
+			return s.fail(err)
+		}
+	}
+	s.e.depth = len(s.open)
+	if err := s.e.spaces(); err != nil {
+		return s.fail(err)
+	}
+	s.open = append(s.open, &streamFrame{name: name, attrs: append([]xml.Attr(nil), attrs...)})
+	s.pending = true
+	return nil
+}
+
+// Attr adds an attribute to the element that was just opened.
+func (s *StreamWriter) Attr(name xml.Name, value string) error {
+	if s.err != nil {
+		return s.err
+	}
+	if !s.pending {
+		return s.fail(fmt.Errorf("attribute %s added after the start tag was written", name.Local))
+	}
+	f := s.open[len(s.open)-1]
+	f.attrs = append(f.attrs, xml.Attr{Name: name, Value: value})
+	return nil
+}
+
+// Text writes text inside the open element.
+func (s *StreamWriter) Text(text string) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.text([]byte(text), false)
+}
+
+// EntityRef writes a reference to the named entity inside the open
+// element.
+func (s *StreamWriter) EntityRef(name string) error {
+	if s.err != nil {
+		return s.err
+	}
+	if !isName(name) {
+		return s.fail(fmt.Errorf("invalid entity name %q", name))
+	}
+	return s.text([]byte(EntityRef(name)), true)
+}
+
+// text writes text inside the open element, with its EntityRefs written
+// as entity references if refs is set.
+func (s *StreamWriter) text(text []byte, refs bool) error {
+	if len(s.open) == 0 {
+		return s.fail(errors.New("text outside of the root element"))
+	}
+	if len(text) == 0 {
+		return nil
+	}
+	if msg := checkText(string(text), s.e.xml11); msg != "" {
+		return s.fail(fmt.Errorf("content: %s", msg))
+	}
+	if err := s.start(false); err != nil {
+		return s.fail(err)
+	}
+	if err := escapeRefs(s.e, text, refs); err != nil {
+		return s.fail(err)
+	}
+	return nil
+}
+
+// CloseElement closes the open element.
+func (s *StreamWriter) CloseElement() error {
+	if s.err != nil {
+		return s.err
+	}
+	if len(s.open) == 0 {
+		return s.fail(errors.New("no element is open"))
+	}
+	f := s.open[len(s.open)-1]
+	if s.pending {
+		if err := s.start(true); err != nil {
+			return s.fail(err)
+		}
+	} else {
+		if f.children {
+			s.e.depth = len(s.open) - 1
+			if err := s.e.spaces(); err != nil {
+				return s.fail(err)
+			}
+		}
+		if _, err := s.e.WriteString("</" + f.qname + ">"); err != nil {
+			return s.fail(err)
+		}
+		if err := s.e.prettyEnd(); err != nil {
+			return s.fail(err)
+		}
+	}
+	s.open = s.open[:len(s.open)-1]
+	s.done = len(s.open) == 0
+	return nil
+}
+
+// EmitElement writes the tree rooted at node inside the open element, or
+// as the root element if none is.
+func (s *StreamWriter) EmitElement(node *Element) error {
+	if err := s.OpenElement(node.Name, node.Attributes...); err != nil {
+		return err
+	}
+	s.open[len(s.open)-1].refs = node.refs
+	if len(node.Content) > 0 {
+		if err := s.text(node.Content, node.contentRefs()); err != nil {
+			return err
+		}
+	} else if node.ContentStreamed() {
+		if err := s.start(false); err != nil {
+			return s.fail(err)
+		}
+		if err := node.encodeStream(s.e); err != nil {
+			return s.fail(err)
+		}
+	}
+	children, err := node.kids()
+	if err != nil {
+		return s.fail(err)
+	}
+	for _, c := range children {
+		if err := s.EmitElement(c); err != nil {
+			return err
+		}
+	}
+	return s.CloseElement()
+}
+
+// Flush writes out whatever is buffered.  The start tag of an element
+// that was just opened is held back until something is written inside
+// it, since attributes can still be added to it.
+func (s *StreamWriter) Flush() error {
+	if s.err != nil {
+		return s.err
+	}
+	if err := s.e.Flush(); err != nil {
+		return s.fail(err)
+	}
+	return nil
+}
+
+// Close closes the elements that are still open and flushes the output.
+// It fails if no root element was written.
+func (s *StreamWriter) Close() error {
+	for len(s.open) > 0 {
+		if err := s.CloseElement(); err != nil {
+			return err
+		}
+	}
+	if s.err == nil && !s.done {
+		return s.fail(ErrNoRootElement)
+	}
+	return s.Flush()
+}
+
+// prefix returns the prefix ns is bound to in the open elements.
+func (s *StreamWriter) prefix(ns string) (string, bool) {
+	for i := len(s.open) - 1; i >= 0; i-- {
+		if p, ok := s.open[i].decls[ns]; ok {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// bound reports whether prefix is bound in the open elements.
+func (s *StreamWriter) bound(prefix string) bool {
+	for _, f := range s.open {
+		for _, p := range f.decls {
+			if p == prefix {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// declare binds ns to a prefix on f, if it is not bound already.  As
+// with Encoder.addNamespace, prefix is used if it can be, and a new one
+// is made up if not.  Prefixes that are bound never get rebound inside
+// the element, so no binding is ever hidden.
+func (s *StreamWriter) declare(f *streamFrame, ns, prefix string) {
+	if ns == "" || ns == "xmlns" || ns == NS_XML {
+		return
+	}
+	if _, found := s.prefix(ns); found {
+		return
+	}
+	if prefix == "" || !IsNCName(prefix) || strings.HasPrefix(strings.ToLower(prefix), "xml") || s.bound(prefix) {
+		for {
+			s.e.namespacesAdded++
+			prefix = fmt.Sprintf("ns%v", s.e.namespacesAdded)
+			if !s.bound(prefix) {
+				break
+			}
+		}
+	}
+	f.decls[ns] = prefix
+}
+
+func (s *StreamWriter) qname(name xml.Name) string {
+	switch name.Space {
+	case "":
+		return name.Local
+	case "xmlns":
+		return "xmlns:" + name.Local
+	case NS_XML:
+		return "xml:" + name.Local
+	}
+	p, _ := s.prefix(name.Space)
+	return p + ":" + name.Local
+}
+
+// start writes the start tag of the element that was just opened, if it
+// has not been written yet.  If empty is set, the element is closed in
+// the same tag.
+func (s *StreamWriter) start(empty bool) error {
+	if !s.pending {
+		return nil
+	}
+	s.pending = false
+	f := s.open[len(s.open)-1]
+	check := &Element{Name: f.name, Attributes: f.attrs}
+	if err := check.wellFormed(s.e.xml11); err != nil {
+		return err
+	}
+	f.decls = map[string]string{}
+	for _, a := range f.attrs {
+		if a.Name.Space == "xmlns" {
+			s.declare(f, a.Value, a.Name.Local)
+		}
+	}
+	s.declare(f, f.name.Space, "")
+	for _, a := range f.attrs {
+		s.declare(f, a.Name.Space, "")
+	}
+	f.qname = s.qname(f.name)
+	if _, err := s.e.WriteString("<" + f.qname); err != nil {
+		return err
+	}
+	for _, a := range f.attrs {
+		if isXmlnsAttr(a) {
+			continue
+		}
+		if _, err := s.e.WriteString(" " + s.qname(a.Name) + "=\""); err != nil {
+			return err
+		}
+		if err := escapeRefs(s.e, []byte(a.Value), f.refs.attr(a.Name)); err != nil {
+			return err
+		}
+		if err := s.e.WriteByte('"'); err != nil {
+			return err
+		}
+	}
+	prefixes := make([]string, 0, len(f.decls))
+	uris := make(map[string]string, len(f.decls))
+	for ns, p := range f.decls {
+		prefixes = append(prefixes, p)
+		uris[p] = ns
+	}
+	sort.Strings(prefixes)
+	for _, p := range prefixes {
+		if _, err := s.e.WriteString(" xmlns:" + p + "=\""); err != nil {
+			return err
+		}
+		if err := xml.EscapeText(s.e, []byte(uris[p])); err != nil {
+			return err
+		}
+		if err := s.e.WriteByte('"'); err != nil {
+			return err
+		}
+	}
+	if !empty {
+		_, err := s.e.WriteString(">")
+		return err
+	}
+	if _, err := s.e.WriteString("/>"); err != nil {
+		return err
+	}
+	return s.e.prettyEnd()
+}
@@ -0,0 +1,201 @@
+package dom
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// ErrContentRead is returned when Content streamed from an io.Reader is
+// wanted a second time.
+var ErrContentRead = errors.New("dom: streamed content has already been read")
+
+// The streamed content setters back the text of an element with an
+// io.Reader or io.ReaderAt instead of Content, for payloads too big to
+// hold in memory, such as a file embedded in a document as base64.
+// Encoding the element, with an Encoder or with Bytes or String, reads
+// the text in chunks and escapes each as it goes, so that only a chunk
+// is ever in memory.  Code that reads Content, such as the typed
+// accessors, XPath and canonicalization, does not see the streamed text:
+// call LoadContent to read it into Content first, or OpenContent to read
+// it without keeping it.  The streamed text is only used while Content
+// is nil, so setting Content, or any of the other setters, replaces it.
+// Copies of the element, as made by Clone, share the streamed text.
+
+// streamed is where the text of an element with streamed content comes
+// from.
+type streamed struct {
+	// open returns a reader for the text.  The text is UTF-8, or base64
+	// if encode is set, in which case it is encoded from what open
+	// returns.
+	open   func() (io.Reader, error)
+	size   int64
+	encode bool
+	// spilled, if set, is where in a Spool the text is.
+	spilled *spilled
+}
+
+func (node *Element) setStream(s *streamed) *Element {
+	node.Content = nil
+	node.stream = s
+	node.touch()
+	return node
+}
+
+// SetContentStream makes the text of node what is read from r the first
+// time it is wanted.  size is how long the text is, or -1 if that is not
+// known, and is only reported by ContentSize.  Since r can only be read
+// once, encoding node a second time fails with ErrContentRead.  The
+// return value is node.
+func (node *Element) SetContentStream(r io.Reader, size int64) *Element {
+	used := false
+	return node.setStream(&streamed{size: size, open: func() (io.Reader, error) {
+		if used {
+			return nil, ErrContentRead
+		}
+		used = true
+		return r, nil
+	}})
+}
+
+// SetContentStreamAt makes the text of node the size bytes of r from
+// offset 0, which are read again each time they are wanted, so node can
+// be encoded any number of times.  The return value is node.
+func (node *Element) SetContentStreamAt(r io.ReaderAt, size int64) *Element {
+	return node.setStream(&streamed{size: size, open: func() (io.Reader, error) {
+		return io.NewSectionReader(r, 0, size), nil
+	}})
+}
+
+// SetContentStreamBase64 makes the text of node the base64 encoding of
+// the size bytes of r from offset 0, encoding them as they are written,
+// as SetContentStreamAt does for text.  The return value is node.
+func (node *Element) SetContentStreamBase64(r io.ReaderAt, size int64) *Element {
+	return node.setStream(&streamed{size: (size + 2) / 3 * 4, encode: true, open: func() (io.Reader, error) {
+		return io.NewSectionReader(r, 0, size), nil
+	}})
+}
+
+// ContentStreamed reports whether the text of node is streamed, rather
+// than in Content.
+func (node *Element) ContentStreamed() bool {
+	return node.Content == nil && node.stream != nil
+}
+
+// ContentSize returns the length of the text of node in bytes, which for
+// streamed text is the size it was given, or -1 if that is not known.
+func (node *Element) ContentSize() int64 {
+	if node.ContentStreamed() {
+		return node.stream.size
+	}
+	return int64(len(node.Content))
+}
+
+// OpenContent returns a Reader for the text of node, whether it is
+// streamed or in Content.
+func (node *Element) OpenContent() (io.Reader, error) {
+	if !node.ContentStreamed() {
+		return bytes.NewReader(node.Content), nil
+	}
+	r, err := node.stream.open()
+	if err != nil {
+		return nil, fmt.Errorf("dom: %s: %w", node.Path(), err)
+	}
+	if !node.stream.encode {
+		return r, nil
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		w := base64.NewEncoder(base64.StdEncoding, pw)
+		_, err := io.Copy(w, r)
+		if err == nil {
+			err = w.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// LoadContent reads streamed text into Content, so that everything that
+// reads Content sees it.  It does nothing if the text of node is not
+// streamed.
+func (node *Element) LoadContent() error {
+	if !node.ContentStreamed() {
+		return nil
+	}
+	r, err := node.OpenContent()
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	if node.stream.size > 0 {
+		b.Grow(int(node.stream.size))
+	}
+	if _, err = io.Copy(&b, r); err != nil {
+		return fmt.Errorf("dom: %s: %w", node.Path(), err)
+	}
+	node.Content = b.Bytes()
+	node.stream = nil
+	node.touch()
+	return nil
+}
+
+// streamChunk is how much streamed text is read at a time.
+const streamChunk = 32 * 1024
+
+// escapeWriter escapes text written to it onto an Encoder, holding back
+// the start of a character split between writes.
+type escapeWriter struct {
+	e    *Encoder
+	rest []byte
+}
+
+func (w *escapeWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	if len(w.rest) > 0 {
+		b = append(w.rest, b...)
+		w.rest = nil
+	}
+	i := len(b)
+	// Back up over a character that has not all been written yet.
+	for j := len(b) - 1; j >= 0 && j >= len(b)-utf8.UTFMax; j-- {
+		if utf8.RuneStart(b[j]) {
+			if !utf8.FullRune(b[j:]) {
+				i = j
+			}
+			break
+		}
+	}
+	w.rest = append(w.rest, b[i:]...)
+	if err := escapeText(w.e, b[:i]); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (w *escapeWriter) Close() error {
+	return escapeText(w.e, w.rest)
+}
+
+// encodeStream writes the streamed text of node to e.
+func (node *Element) encodeStream(e *Encoder) error {
+	r, err := node.stream.open()
+	if err != nil {
+		return fmt.Errorf("dom: %s: %w", node.Path(), err)
+	}
+	var w io.WriteCloser = &escapeWriter{e: e}
+	if node.stream.encode {
+		// base64 needs no escaping.
+		w = base64.NewEncoder(base64.StdEncoding, e)
+	}
+	if _, err = io.CopyBuffer(struct{ io.Writer }{w}, r, make([]byte, streamChunk)); err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("dom: %s: %w", node.Path(), err)
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+package dom
+
+import (
+	"encoding"
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Mismatch describes a part of a tree that would be dropped or stored
+// wrongly if the tree was unmarshalled into a Go value with encoding/xml.
+type Mismatch struct {
+	// Element is the element the mismatch was found on.
+	Element *Element
+	// Path is a /-separated path to Element from the top of its tree.
+	Path    string
+	Message string
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%v: %s: %s", m.Element.Pos(), m.Path, m.Message)
+}
+
+type fieldKind int
+
+const (
+	elementField fieldKind = iota
+	attrField
+	chardataField
+	innerxmlField
+	anyField
+	anyAttrField
+	ignoredField
+)
+
+// structField is a field of a struct as encoding/xml sees it.
+type structField struct {
+	goName string
+	kind   fieldKind
+	space  string
+	// path holds the element names leading to the field, from a tag like
+	// "a>b>c", ending with the name of the field's own element.
+	path []string
+	typ  reflect.Type
+}
+
+var (
+	unmarshalerType     = reflect.TypeOf((*xml.Unmarshaler)(nil)).Elem()
+	unmarshalerAttrType = reflect.TypeOf((*xml.UnmarshalerAttr)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	xmlNameType         = reflect.TypeOf(xml.Name{})
+)
+
+// structFields returns the fields of t, with the fields of embedded
+// structs promoted, and the XMLName field if t has one.
+func structFields(t reflect.Type) (fields []structField, xmlName *structField) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("xml")
+		if tag == "-" || (f.PkgPath != "" && !f.Anonymous) {
+			continue
+		}
+		if f.Anonymous && tag == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				inner, name := structFields(ft)
+				fields = append(fields, inner...)
+				if xmlName == nil {
+					xmlName = name
+				}
+				continue
+			}
+		}
+		sf := structField{goName: f.Name, typ: f.Type}
+		name, flags := tag, ""
+		if i := strings.Index(tag, ","); i >= 0 {
+			name, flags = tag[:i], tag[i+1:]
+		}
+		if i := strings.LastIndex(name, " "); i >= 0 {
+			sf.space, name = name[:i], name[i+1:]
+		}
+		has := func(flag string) bool {
+			for _, f := range strings.Split(flags, ",") {
+				if f == flag {
+					return true
+				}
+			}
+			return false
+		}
+		switch {
+		case has("any") && has("attr"):
+			sf.kind = anyAttrField
+		case has("attr"):
+			sf.kind = attrField
+		case has("chardata"), has("cdata"):
+			sf.kind = chardataField
+		case has("innerxml"):
+			sf.kind = innerxmlField
+		case has("any"):
+			sf.kind = anyField
+		case has("comment"):
+			sf.kind = ignoredField
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if f.Name == "XMLName" {
+			if f.Type == xmlNameType {
+				sf.path = []string{name}
+				if tag == "" {
+					sf.path = nil
+				}
+				xmlName = &sf
+			}
+			continue
+		}
+		sf.path = strings.Split(name, ">")
+		fields = append(fields, sf)
+	}
+	return fields, xmlName
+}
+
+type structChecker struct {
+	res []Mismatch
+}
+
+func (c *structChecker) report(e *Element, format string, args ...interface{}) {
+	c.res = append(c.res, Mismatch{Element: e, Path: e.Path(), Message: fmt.Sprintf(format, args...)})
+}
+
+// CheckStruct reports the parts of the tree rooted at e that would be
+// lost or mis-mapped by unmarshalling it into v with xml.Unmarshal: child
+// elements, attributes and text that no field would receive, values that
+// could not be stored in the field's type, repeated elements for fields
+// that can only hold one, and an XMLName that does not match.  v may be
+// a struct, a pointer to one, or a nil pointer of the right type.  Types
+// that implement xml.Unmarshaler are trusted to handle their own
+// elements.
+func CheckStruct(e *Element, v interface{}) ([]Mismatch, error) {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("dom: CheckStruct needs a struct, not %T", v)
+	}
+	c := &structChecker{}
+	c.value(e, t, "")
+	return c.res, nil
+}
+
+func deref(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// value checks e against a value of type t.  field names the field e is
+// going into, for messages.
+func (c *structChecker) value(e *Element, t reflect.Type, field string) {
+	t = deref(t)
+	pt := reflect.PtrTo(t)
+	switch {
+	case pt.Implements(unmarshalerType):
+		return
+	case pt.Implements(textUnmarshalerType) || t.Kind() != reflect.Struct:
+		if t.Kind() == reflect.Interface {
+			c.report(e, "field %s is an interface, so element %s is ignored", field, e.Name.Local)
+			return
+		}
+		for _, child := range e.Children() {
+			c.report(child, "element %s is dropped, since field %s only holds text", child.Name.Local, field)
+		}
+		if err := checkValue(t, string(e.Content)); err != nil {
+			c.report(e, "content %q cannot be stored in field %s: %v", e.Content, field, err)
+		}
+		return
+	}
+	fields, xmlName := structFields(t)
+	if xmlName != nil && len(xmlName.path) > 0 {
+		if e.Name.Local != xmlName.path[0] || (xmlName.space != "" && e.Name.Space != xmlName.space) {
+			c.report(e, "expected element %s, have %s", xmlName.path[0], e.Name.Local)
+			return
+		}
+	}
+	var anyAttr, chardata, innerxml, anyElem *structField
+	for i := range fields {
+		f := &fields[i]
+		switch f.kind {
+		case anyAttrField:
+			anyAttr = f
+		case chardataField:
+			chardata = f
+		case innerxmlField:
+			innerxml = f
+		case anyField:
+			if anyElem == nil {
+				anyElem = f
+			}
+		}
+	}
+	if innerxml != nil && (innerxml.typ.Kind() == reflect.String || innerxml.typ == reflect.TypeOf([]byte{})) {
+		// The field gets the raw XML of the whole element.
+		return
+	}
+Attrs:
+	for _, a := range e.Attributes {
+		if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") {
+			continue
+		}
+		for _, f := range fields {
+			if f.kind == attrField && f.path[0] == a.Name.Local && (f.space == "" || f.space == a.Name.Space) {
+				c.attr(e, a, f)
+				continue Attrs
+			}
+		}
+		if anyAttr == nil {
+			c.report(e, "attribute %s is not mapped to any field", a.Name.Local)
+		}
+	}
+	if strings.TrimSpace(string(e.Content)) != "" {
+		if chardata == nil {
+			c.report(e, "text %q is not mapped to any field", e.Content)
+		} else if err := checkValue(chardata.typ, string(e.Content)); err != nil {
+			c.report(e, "text %q cannot be stored in field %s: %v", e.Content, chardata.goName, err)
+		}
+	}
+	elems := []structField{}
+	for _, f := range fields {
+		if f.kind == elementField {
+			elems = append(elems, f)
+		}
+	}
+	counts := map[string]int{}
+	c.children(e, elems, 0, anyElem, counts)
+	for _, f := range elems {
+		if counts[f.goName] > 1 && !holdsMany(f.typ) {
+			c.report(e, "element %s appears %d times, but field %s holds one value",
+				strings.Join(f.path, ">"), counts[f.goName], f.goName)
+		}
+	}
+}
+
+func holdsMany(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8
+}
+
+// children checks the children of e against the element fields whose
+// path continues below e.  depth is how far down the paths of fields e
+// is.
+func (c *structChecker) children(e *Element, fields []structField, depth int, anyElem *structField, counts map[string]int) {
+Children:
+	for _, child := range e.Children() {
+		below := []structField{}
+		for _, f := range fields {
+			if f.path[depth] != child.Name.Local || (f.space != "" && f.space != child.Name.Space) {
+				continue
+			}
+			if len(f.path) == depth+1 {
+				counts[f.goName]++
+				t := f.typ
+				if holdsMany(t) {
+					t = t.Elem()
+				}
+				c.value(child, t, f.goName)
+				continue Children
+			}
+			below = append(below, f)
+		}
+		switch {
+		case len(below) > 0:
+			c.children(child, below, depth+1, nil, counts)
+		case depth == 0 && anyElem != nil:
+			t := anyElem.typ
+			if holdsMany(t) {
+				t = t.Elem()
+			}
+			c.value(child, t, anyElem.goName)
+		default:
+			c.report(child, "element %s is not mapped to any field", child.Name.Local)
+		}
+	}
+}
+
+func (c *structChecker) attr(e *Element, a xml.Attr, f structField) {
+	t := deref(f.typ)
+	if reflect.PtrTo(t).Implements(unmarshalerAttrType) {
+		return
+	}
+	if t.Kind() == reflect.Interface || t.Kind() == reflect.Struct && !reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		c.report(e, "attribute %s cannot be stored in field %s of type %v", a.Name.Local, f.goName, f.typ)
+		return
+	}
+	if err := checkValue(t, a.Value); err != nil {
+		c.report(e, "attribute %s value %q cannot be stored in field %s: %v", a.Name.Local, a.Value, f.goName, err)
+	}
+}
+
+// checkValue checks whether encoding/xml could store s in a value of type
+// t, following the rules of its copyValue.
+func checkValue(t reflect.Type, s string) error {
+	t = deref(t)
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return reflect.New(t).Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	var err error
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		_, err = strconv.ParseInt(s, 10, t.Bits())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		_, err = strconv.ParseUint(s, 10, t.Bits())
+	case reflect.Float32, reflect.Float64:
+		_, err = strconv.ParseFloat(s, t.Bits())
+	case reflect.Bool:
+		_, err = strconv.ParseBool(s)
+	case reflect.String:
+	case reflect.Slice:
+		if t.Elem().Kind() != reflect.Uint8 {
+			err = fmt.Errorf("cannot hold text in a %v", t)
+		}
+	default:
+		err = fmt.Errorf("cannot hold text in a %v", t)
+	}
+	if ne, ok := err.(*strconv.NumError); ok {
+		err = ne.Err
+	}
+	return err
+}
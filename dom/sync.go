@@ -0,0 +1,53 @@
+package dom
+
+import (
+	"sync"
+)
+
+// SyncDocument guards a Document so that it can be shared between
+// goroutines, such as a configuration tree read by every request a
+// server handles.  Any number of goroutines can read it at once, while
+// changes get it to themselves.
+//
+// The Document, and the Elements in it, must only be used inside the
+// functions passed to Read and Write, and must not be kept around
+// after they return.
+type SyncDocument struct {
+	mu  sync.RWMutex
+	doc *Document
+}
+
+// NewSyncDocument returns a SyncDocument guarding doc.  doc must not be
+// used directly afterwards.
+func NewSyncDocument(doc *Document) *SyncDocument {
+	return &SyncDocument{doc: doc}
+}
+
+// Read calls f with the Document, along with any other readers, and
+// returns what f returns.  f must not change the tree in any way.
+//
+// If indexing is enabled on the Document, the Index is brought up to
+// date before f is called, so that f can use it.
+func (s *SyncDocument) Read(f func(doc *Document) error) error {
+	s.mu.RLock()
+	for s.doc.indexed && (s.doc.index == nil || !s.doc.index.current(s.doc.root)) {
+		// Building the Index changes the Document, so it needs the
+		// write lock.  Someone may change the tree again between
+		// the two locks, hence the loop.
+		s.mu.RUnlock()
+		s.mu.Lock()
+		s.doc.Index()
+		s.mu.Unlock()
+		s.mu.RLock()
+	}
+	defer s.mu.RUnlock()
+	return f(s.doc)
+}
+
+// Write calls f with the Document while no one else can use it, and
+// returns what f returns.  f may change the tree as it likes.
+func (s *SyncDocument) Write(f func(doc *Document) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return f(s.doc)
+}
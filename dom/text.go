@@ -0,0 +1,53 @@
+package dom
+
+import (
+	"encoding/json"
+)
+
+// MarshalText implements encoding.TextMarshaler, so that Documents can be
+// used as flag values and in text-based configuration formats.  It
+// returns doc encoded without pretty-printing, or nothing if doc has no
+// root element.
+func (doc *Document) MarshalText() ([]byte, error) {
+	if doc.root == nil {
+		return []byte{}, nil
+	}
+	v, err := compact(doc.Encode)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(v.(string)), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.  It replaces the
+// contents of doc with the parsed text.  Empty text leaves doc without a
+// root element.
+func (doc *Document) UnmarshalText(text []byte) error {
+	return doc.load(text)
+}
+
+// MarshalJSON implements json.Marshaler.  The document is encoded as a
+// JSON string holding its XML, or null if it has no root element.
+func (doc *Document) MarshalJSON() ([]byte, error) {
+	if doc.root == nil {
+		return []byte("null"), nil
+	}
+	text, err := doc.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(string(text))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.  It accepts what MarshalJSON
+// produces.
+func (doc *Document) UnmarshalJSON(data []byte) error {
+	var text *string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	if text == nil {
+		return doc.load(nil)
+	}
+	return doc.load([]byte(*text))
+}
@@ -0,0 +1,77 @@
+package dom
+
+import (
+	"encoding/xml"
+)
+
+// copyTokens is a TokenReader that hands out copies of the tokens it
+// reads, so that the tree built from them shares no memory with them,
+// and the decoder does not change them when it resolves namespaces.
+type copyTokens struct {
+	tr xml.TokenReader
+}
+
+func (c copyTokens) Token() (xml.Token, error) {
+	tok, err := c.tr.Token()
+	if tok != nil {
+		tok = xml.CopyToken(tok)
+	}
+	return tok, err
+}
+
+// FromTokenReader builds an Element from the next element tr returns, so
+// that token streams produced by other code, such as encoding/xml's
+// Encoder feeding a token recorder, can be captured as trees.  Anything
+// before the start of the element is skipped, and nothing after its end
+// is read, so it can be called again for the next element; it returns
+// io.EOF if tr runs out first.  Names may either be resolved already, as
+// xml.Decoder.Token resolves them, or still have prefixes, as RawToken
+// leaves them, in which case they are resolved against the xmlns
+// attributes in the element.  The tokens are checked for balance as they
+// would be in a document.
+//
+// opts are the same as for Parse, but the ones that work on the text of
+// a document, such as WithCharsetReader, WithKeepEntityRefs,
+// WithNormalizeAttrs and WithKeepSource, have nothing to work on and are
+// ignored.  WithUntrusted still leaves out what untrusted documents may
+// not have, and limits the depth and size of the tree.  The Elements
+// have no Pos, since there is no input for them to have a position in.
+func FromTokenReader(tr xml.TokenReader, opts ...Option) (*Element, error) {
+	o := &newSettings(opts).parse
+	if o.Untrusted {
+		o = o.hardened()
+	}
+	p := tokenParser(xml.NewTokenDecoder(copyTokens{tr}), o)
+	p.tokens = true
+	for {
+		tok, err := p.decoder.Token()
+		if err != nil {
+			if _, ok := err.(*xml.SyntaxError); ok {
+				err = &Error{Op: "parse", Err: err}
+			}
+			return nil, err
+		}
+		if p.untrusted {
+			if err := untrusted(tok, Position{}); err != nil {
+				return nil, err
+			}
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		res, err := p.element(start, Position{})
+		if err != nil {
+			// The decoder only knows where it is in its input when
+			// it has some.
+			perr := p.fail(err)
+			perr.Pos = Position{}
+			return nil, perr
+		}
+		if res == nil {
+			continue
+		}
+		res.uri = o.BaseURI
+		return res, nil
+	}
+}
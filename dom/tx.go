@@ -0,0 +1,166 @@
+package dom
+
+import (
+	"encoding/xml"
+	"errors"
+)
+
+// ErrTxDone is returned when a Tx that has already been committed or
+// rolled back is committed or rolled back again.
+var ErrTxDone = errors.New("dom: transaction has already been committed or rolled back")
+
+// saved is what an element was like when a Tx began.
+type saved struct {
+	name     xml.Name
+	content  []byte
+	attrs    []xml.Attr
+	children []*Element
+	parent   *Element
+	uri      string
+	spill    *spilled
+	stream   *streamed
+	refs     *entityRefs
+}
+
+// Tx is a transaction over the changes made to a tree, which Rollback
+// undoes, for editors that let changes be abandoned and migrations made
+// of several steps, any of which can fail:
+//    tx := doc.Begin()
+//    for _, step := range steps {
+//        if err := step(doc); err != nil {
+//            tx.Rollback()
+//            return err
+//        }
+//    }
+//    if err := doc.WellFormed(); err != nil {
+//        tx.Rollback()
+//        return err
+//    }
+//    tx.Commit()
+// Begin records the state of every element in the tree, and Rollback
+// puts each of them back the way it was, so Elements held on to from
+// before the transaction are the ones in the tree again afterwards, and
+// changes made through the exported fields are undone as well as those
+// made through methods.  Content is restored as the slice it was, so
+// bytes changed inside it in place stay changed; setting it to a new
+// slice, as all the setters do, is undone.  Elements added during the
+// transaction are detached by Rollback, and elements that were moved
+// into other trees during it must not be used there afterwards, since
+// Rollback takes them back without removing them from their new parents.
+//
+// Transactions can be nested, each Begin serving as a savepoint the
+// changes after it can be rolled back to, as long as inner ones are
+// ended first.  A Tx is not safe for concurrent use, and nothing else
+// may change the tree while it is being rolled back.
+type Tx struct {
+	root  *Element
+	doc   *Document
+	state map[*Element]saved
+	// docState is the Document as it was, if the Tx is over one.
+	docState Document
+	done     bool
+}
+
+func (tx *Tx) save(node *Element) {
+	tx.state[node] = saved{
+		name:     node.Name,
+		content:  node.Content,
+		attrs:    append([]xml.Attr(nil), node.Attributes...),
+		children: append([]*Element(nil), node.children...),
+		parent:   node.parent,
+		uri:      node.uri,
+		spill:    node.spill,
+		stream:   node.stream,
+		refs:     node.refs,
+	}
+	for _, c := range node.children {
+		tx.save(c)
+	}
+}
+
+// Begin starts a transaction over node and everything in it: their
+// names, attributes, text and children.  Where node itself is in its
+// parent is not part of the transaction.
+func (node *Element) Begin() *Tx {
+	tx := &Tx{root: node, state: map[*Element]saved{}}
+	tx.save(node)
+	return tx
+}
+
+// Begin starts a transaction over all of doc: everything in its tree,
+// which element is its root and the declarations, comments and
+// processing instructions around it.
+func (doc *Document) Begin() *Tx {
+	tx := &Tx{root: doc.root, doc: doc, state: map[*Element]saved{}}
+	tx.docState = *doc
+	tx.docState.prolog = append([]Misc(nil), doc.prolog...)
+	tx.docState.epilog = append([]Misc(nil), doc.epilog...)
+	if doc.root != nil {
+		tx.save(doc.root)
+	}
+	return tx
+}
+
+// Commit ends tx, keeping the changes made since it began.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done, tx.state = true, nil
+	return nil
+}
+
+// detach drops the parents of the children of node that were added
+// since tx began.
+func (tx *Tx) detach(node *Element) {
+	for _, c := range node.children {
+		if _, ok := tx.state[c]; !ok && c.parent == node {
+			c.parent = nil
+		}
+	}
+}
+
+// Rollback ends tx, undoing the changes made since it began.
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	for e := range tx.state {
+		tx.detach(e)
+	}
+	for e, s := range tx.state {
+		e.Name, e.Content, e.Attributes, e.children = s.name, s.content, s.attrs, s.children
+		if e != tx.root || tx.doc != nil {
+			e.parent = s.parent
+		}
+		e.uri, e.spill, e.stream, e.refs = s.uri, s.spill, s.stream, s.refs
+	}
+	if tx.doc != nil {
+		*tx.doc = tx.docState
+		tx.doc.index = nil
+	}
+	if tx.root != nil {
+		// Indexes built during the transaction are out of date.
+		tx.root.touch()
+	}
+	tx.done, tx.state = true, nil
+	return nil
+}
+
+// Atomically runs f in a transaction over doc, which is rolled back if f
+// returns an error or panics, and committed otherwise.
+func (doc *Document) Atomically(f func() error) (err error) {
+	tx := doc.Begin()
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+	return f()
+}
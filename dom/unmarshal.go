@@ -0,0 +1,78 @@
+package dom
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+type tokenFrame struct {
+	e *Element
+	// children are those of e, read from the Spool without paging them
+	// in if e is spilled.
+	children []*Element
+	// next is the index of the next child to produce, or -1 if the
+	// Content has not been produced yet.
+	next int
+}
+
+type tokenReader struct {
+	root    *Element
+	started bool
+	stack   []tokenFrame
+}
+
+func startToken(e *Element) xml.StartElement {
+	attrs := make([]xml.Attr, len(e.Attributes))
+	copy(attrs, e.Attributes)
+	return xml.StartElement{Name: e.Name, Attr: attrs}
+}
+
+func (r *tokenReader) Token() (xml.Token, error) {
+	if !r.started {
+		r.started = true
+		children, err := r.root.kids()
+		if err != nil {
+			return nil, err
+		}
+		r.stack = append(r.stack, tokenFrame{e: r.root, children: children, next: -1})
+		return startToken(r.root), nil
+	}
+	if len(r.stack) == 0 {
+		return nil, io.EOF
+	}
+	top := &r.stack[len(r.stack)-1]
+	if top.next == -1 {
+		top.next = 0
+		if len(top.e.Content) > 0 {
+			return xml.CharData(top.e.Content).Copy(), nil
+		}
+	}
+	if top.next < len(top.children) {
+		child := top.children[top.next]
+		top.next++
+		children, err := child.kids()
+		if err != nil {
+			return nil, err
+		}
+		r.stack = append(r.stack, tokenFrame{e: child, children: children, next: -1})
+		return startToken(child), nil
+	}
+	r.stack = r.stack[:len(r.stack)-1]
+	return xml.EndElement{Name: top.e.Name}, nil
+}
+
+// TokenReader returns an xml.TokenReader that produces the tokens of the
+// tree rooted at node, as if it was being parsed.  Each element's
+// Content comes before its children.  The tree should not be changed
+// while the tokens are being read.
+func (node *Element) TokenReader() xml.TokenReader {
+	return &tokenReader{root: node}
+}
+
+// Unmarshal decodes the tree rooted at node into v with encoding/xml,
+// exactly as xml.Unmarshal would decode it from its encoded form,
+// without encoding it first.  See CheckStruct for a way to find out
+// which parts of the tree Unmarshal would not use.
+func (node *Element) Unmarshal(v interface{}) error {
+	return xml.NewTokenDecoder(node.TokenReader()).Decode(v)
+}
@@ -0,0 +1,90 @@
+package dom
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The limits ParseOptions.Untrusted enforces, unless lower ones are set.
+const (
+	UntrustedMaxTreeBytes = 32 << 20
+	UntrustedMaxDepth     = 256
+)
+
+// ErrUntrustedInput is the cause of the Error the parser returns for
+// input that ParseOptions.Untrusted does not allow.
+var ErrUntrustedInput = errors.New("not allowed in untrusted input")
+
+// ParseUntrusted parses a document from a source that is not trusted,
+// such as the body of a request from the Internet.  It is like Parse
+// with opts, but with ParseOptions.Untrusted set, which no option can
+// turn off or weaken.
+func ParseUntrusted(r io.Reader, opts ...Option) (*Document, error) {
+	s := newSettings(opts)
+	s.parse.Untrusted = true
+	return ParseWithOptions(r, &s.parse)
+}
+
+// hardened returns a copy of opts with everything ParseOptions.Untrusted
+// says set.
+func (opts *ParseOptions) hardened() *ParseOptions {
+	res := *opts
+	res.CharsetReader = func(label string, r io.Reader) (io.Reader, error) {
+		return nil, fmt.Errorf("%w: encoding %s", ErrUntrustedInput, label)
+	}
+	res.KeepEntityRefs = false
+	if res.MaxTreeBytes <= 0 || res.MaxTreeBytes > UntrustedMaxTreeBytes {
+		res.MaxTreeBytes = UntrustedMaxTreeBytes
+	}
+	if res.MaxDepth <= 0 || res.MaxDepth > UntrustedMaxDepth {
+		res.MaxDepth = UntrustedMaxDepth
+	}
+	return &res
+}
+
+// inputLimit fails reads once more than limit bytes have been read.
+type inputLimit struct {
+	r           io.Reader
+	left, limit int64
+}
+
+func (l *inputLimit) Read(p []byte) (int, error) {
+	if l.left < 0 {
+		return 0, &TreeTooLargeError{Limit: l.limit}
+	}
+	n, err := l.r.Read(p)
+	if l.left -= int64(n); l.left < 0 {
+		return n, &TreeTooLargeError{Limit: l.limit}
+	}
+	return n, err
+}
+
+// untrusted returns an error if tok, found at pos, is not allowed by
+// ParseOptions.Untrusted.
+func untrusted(tok xml.Token, pos Position) error {
+	what := ""
+	switch rt := tok.(type) {
+	case xml.Directive:
+		what = "<!" + string(firstWord(rt)) + "> declaration"
+	case xml.ProcInst:
+		if rt.Target == "xml" {
+			return nil
+		}
+		what = "processing instruction " + rt.Target
+	default:
+		return nil
+	}
+	return &Error{Op: "parse", Pos: pos, Err: fmt.Errorf("%w: %s", ErrUntrustedInput, what)}
+}
+
+// firstWord returns the keyword a directive starts with.
+func firstWord(d []byte) []byte {
+	for i, c := range d {
+		if isTagSpace(c) || c == '[' {
+			return d[:i]
+		}
+	}
+	return d
+}
@@ -0,0 +1,51 @@
+package dom
+
+// A Validator checks each element as the parser reads it, so that a
+// document that is not valid is given up on at the first problem with
+// it, instead of being read to the end and validated afterwards:
+//    v := ValidatorFuncs{OnOpen: func(e *Element) error {
+//        if e.Name.Local == "script" {
+//            return errors.New("scripts are not allowed")
+//        }
+//        return nil
+//    }}
+//    doc, err := Parse(r, WithValidator(v))
+// Open is called once the start tag of an element has been read, when
+// the element has its Name and Attributes but nothing in it yet, and
+// Close once all of it has been, before it is spilled to a Spool.
+// While the document is being parsed, each element already has its
+// Parent, which the Validator can look at the ancestors of the element
+// through and resolve namespace prefixes with, but is not in the
+// Children of the parent until it has been closed.  Elements that are
+// skipped because of ParseOptions.Skip are not seen at all.
+//
+// An error from either stops the parse, which fails with an *Error
+// whose Op is "validate", caused by it.  A Validator that collects
+// problems rather than stopping at the first one returns nil, and is
+// asked for them once the parse is over.
+type Validator interface {
+	Open(e *Element) error
+	Close(e *Element) error
+}
+
+// ValidatorFuncs is a Validator made of two functions, either of which
+// may be nil.
+type ValidatorFuncs struct {
+	OnOpen, OnClose func(e *Element) error
+}
+
+// Open calls v.OnOpen, if it is set.
+func (v ValidatorFuncs) Open(e *Element) error {
+	if v.OnOpen == nil {
+		return nil
+	}
+	return v.OnOpen(e)
+}
+
+// Close calls v.OnClose, if it is set.
+func (v ValidatorFuncs) Close(e *Element) error {
+	if v.OnClose == nil {
+		return nil
+	}
+	return v.OnClose(e)
+}
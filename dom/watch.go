@@ -0,0 +1,187 @@
+package dom
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// fileState is what a Watcher checks a file for changes by.
+type fileState struct {
+	mod  time.Time
+	size int64
+	err  error
+}
+
+func statFile(path string) fileState {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileState{err: err}
+	}
+	return fileState{mod: fi.ModTime(), size: fi.Size()}
+}
+
+func (s fileState) same(o fileState) bool {
+	if s.err != nil || o.err != nil {
+		return s.err != nil && o.err != nil && s.err.Error() == o.err.Error()
+	}
+	return s.mod.Equal(o.mod) && s.size == o.size
+}
+
+// Watcher keeps a Document read from a file up to date with it.  See
+// WatchDocument.
+type Watcher struct {
+	path     string
+	doc      *SyncDocument
+	callback func(doc *Document, err error)
+	settings *settings
+	// mu keeps reloads from overlapping.
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// WatchDocument reads the document in the file at path, and keeps
+// reading it again whenever the file changes, for configuration that can
+// be changed without restarting:
+//    w, err := WatchDocument("config.xml", func(doc *Document, err error) {
+//        if err != nil {
+//            log.Printf("keeping the old configuration: %v", err)
+//        }
+//    }, WithValidate(checkConfig))
+//    ...
+//    defer w.Close()
+//    w.Document().Read(func(doc *Document) error { ... })
+// The file is checked every second, or as WithWatchInterval says, and
+// read once it has stopped changing for 100ms, or as WithDebounce says,
+// so that a file being written is not read half way through.  The new
+// document is parsed with opts and checked with the function given with
+// WithValidate, if there is one, and only if both succeed is it swapped
+// in for the old one, all at once, so readers of Document see either the
+// old tree or the new one.  callback, which may be nil, is called after
+// each attempt, with the new Document, which it must not change, or with
+// the error that kept the old one.  It is called on the Watcher's
+// goroutine, one call at a time.
+//
+// WatchDocument returns an error if the first version of the file cannot
+// be read, parsed or validated.  Files that are replaced by renaming
+// another file over them, as editors and deployment tools do, are
+// watched all the same, since the file is looked up by path each time.
+func WatchDocument(path string, callback func(doc *Document, err error), opts ...Option) (*Watcher, error) {
+	s := newSettings(opts)
+	if s.interval <= 0 {
+		s.interval = time.Second
+	}
+	if s.debounce <= 0 {
+		s.debounce = 100 * time.Millisecond
+	}
+	w := &Watcher{path: path, callback: callback, settings: s, stop: make(chan struct{}), done: make(chan struct{})}
+	state := statFile(path)
+	doc, err := w.load()
+	if err != nil {
+		return nil, err
+	}
+	w.doc = NewSyncDocument(doc)
+	go w.watch(state)
+	return w, nil
+}
+
+// Document returns the SyncDocument holding the latest version of the
+// file that was read successfully.
+func (w *Watcher) Document() *SyncDocument {
+	return w.doc
+}
+
+// load reads, parses and validates the file.
+func (w *Watcher) load() (*Document, error) {
+	f, err := os.Open(w.path)
+	if err != nil {
+		return nil, fmt.Errorf("dom: %w", err)
+	}
+	defer f.Close()
+	doc, err := ParseWithOptions(f, &w.settings.parse)
+	if err != nil {
+		return nil, fmt.Errorf("dom: %s: %w", w.path, err)
+	}
+	if w.settings.validate != nil {
+		if err := w.settings.validate(doc); err != nil {
+			return nil, fmt.Errorf("dom: %s: %w", w.path, err)
+		}
+	}
+	return doc, nil
+}
+
+// Reload reads the file again now, whether or not it has changed, and
+// swaps the new Document in if it can be read, parsed and validated.
+// It returns the error that kept the old one otherwise.  callback is not
+// called.
+func (w *Watcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	doc, err := w.load()
+	if err != nil {
+		return err
+	}
+	w.doc.mu.Lock()
+	w.doc.doc = doc
+	w.doc.mu.Unlock()
+	return nil
+}
+
+// wait waits for d, and reports whether the Watcher was closed meanwhile.
+func (w *Watcher) wait(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-w.stop:
+		return true
+	case <-t.C:
+		return false
+	}
+}
+
+func (w *Watcher) watch(last fileState) {
+	defer close(w.done)
+	for !w.wait(w.settings.interval) {
+		state := statFile(w.path)
+		if state.same(last) {
+			continue
+		}
+		// Wait for the changes to stop.
+		for {
+			if w.wait(w.settings.debounce) {
+				return
+			}
+			now := statFile(w.path)
+			if now.same(state) {
+				break
+			}
+			state = now
+		}
+		last = state
+		var err error
+		var doc *Document
+		if state.err == nil {
+			if err = w.Reload(); err == nil {
+				w.doc.mu.RLock()
+				doc = w.doc.doc
+				w.doc.mu.RUnlock()
+			}
+		} else {
+			err = fmt.Errorf("dom: %w", state.err)
+		}
+		if w.callback != nil {
+			w.callback(doc, err)
+		}
+	}
+}
+
+// Close stops watching the file, waiting for a reload that is under way
+// to finish.  The Document stays as it is.
+func (w *Watcher) Close() error {
+	w.once.Do(func() { close(w.stop) })
+	<-w.done
+	return nil
+}
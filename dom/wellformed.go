@@ -0,0 +1,152 @@
+package dom
+
+import (
+	"encoding/xml"
+	"fmt"
+	"unicode/utf8"
+)
+
+// isNameStart and isNameChar implement the NameStartChar and NameChar
+// productions of XML 1.0 (fifth edition), without the colon.
+func isNameStart(r rune) bool {
+	switch {
+	case r == '_', r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z':
+		return true
+	case r >= 0xC0 && r <= 0xD6, r >= 0xD8 && r <= 0xF6, r >= 0xF8 && r <= 0x2FF,
+		r >= 0x370 && r <= 0x37D, r >= 0x37F && r <= 0x1FFF, r >= 0x200C && r <= 0x200D,
+		r >= 0x2070 && r <= 0x218F, r >= 0x2C00 && r <= 0x2FEF, r >= 0x3001 && r <= 0xD7FF,
+		r >= 0xF900 && r <= 0xFDCF, r >= 0xFDF0 && r <= 0xFFFD, r >= 0x10000 && r <= 0xEFFFF:
+		return true
+	}
+	return false
+}
+
+func isNameChar(r rune) bool {
+	switch {
+	case isNameStart(r), r == '-', r == '.', r >= '0' && r <= '9', r == 0xB7,
+		r >= 0x300 && r <= 0x36F, r >= 0x203F && r <= 0x2040:
+		return true
+	}
+	return false
+}
+
+// IsNCName reports whether s can be used as the local part of an element
+// or attribute name.
+func IsNCName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if !isNameChar(r) || (i == 0 && !isNameStart(r)) {
+			return false
+		}
+		// Invalid UTF-8 decodes as U+FFFD, which is a name character.
+		if r == utf8.RuneError {
+			if _, size := utf8.DecodeRuneInString(s[i:]); size == 1 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// IsXMLChar reports whether r may appear in an XML 1.0 document.
+func IsXMLChar(r rune) bool {
+	switch {
+	case r == 0x9, r == 0xA, r == 0xD, r >= 0x20 && r <= 0xD7FF,
+		r >= 0xE000 && r <= 0xFFFD, r >= 0x10000 && r <= 0x10FFFF:
+		return true
+	}
+	return false
+}
+
+// checkText returns a description of the first thing in s that cannot
+// appear in an XML document, of version 1.1 if xml11 is set, or "" if
+// there is none.
+func checkText(s string, xml11 bool) string {
+	for i, r := range s {
+		if r == utf8.RuneError {
+			if _, size := utf8.DecodeRuneInString(s[i:]); size == 1 {
+				return fmt.Sprintf("invalid UTF-8 at byte %d", i)
+			}
+		}
+		if !IsXMLChar(r) && !(xml11 && IsXML11Char(r)) {
+			return fmt.Sprintf("illegal character %U at byte %d", r, i)
+		}
+	}
+	return ""
+}
+
+func isXmlnsAttr(a xml.Attr) bool {
+	return a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns")
+}
+
+// WellFormed checks that the tree rooted at node can be encoded as a
+// well-formed XML document: all names must be valid, no element may have
+// two attributes with the same name, and content and attribute values
+// may only contain characters allowed by XML 1.0.  It returns an error
+// describing the first problem found, or nil.
+func (node *Element) WellFormed() error {
+	return node.wellFormedTree(false)
+}
+
+// wellFormedTree is WellFormed, allowing the characters of XML 1.1 if
+// xml11 is set.
+// Spilled parts of the tree are read without being paged in.
+func (node *Element) wellFormedTree(xml11 bool) error {
+	if err := node.wellFormed(xml11); err != nil {
+		return err
+	}
+	children, err := node.kids()
+	if err != nil {
+		return err
+	}
+	for _, c := range children {
+		if err := c.wellFormedTree(xml11); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (node *Element) wellFormed(xml11 bool) error {
+	fail := func(format string, args ...interface{}) error {
+		return &Error{Op: "check", Path: node.Path(), Err: fmt.Errorf(format, args...)}
+	}
+	if !IsNCName(node.Name.Local) {
+		return fail("invalid element name %q", node.Name.Local)
+	}
+	seen := map[xml.Name]bool{}
+	for _, a := range node.Attributes {
+		if !IsNCName(a.Name.Local) {
+			return fail("invalid attribute name %q", a.Name.Local)
+		}
+		if seen[a.Name] {
+			return fail("duplicate attribute %s", a.Name.Local)
+		}
+		seen[a.Name] = true
+		if a.Name.Space == "xmlns" && a.Value == "" {
+			return fail("namespace prefix %s is bound to an empty name", a.Name.Local)
+		}
+		if msg := checkText(a.Value, xml11); msg != "" {
+			if isXmlnsAttr(a) {
+				return fail("namespace declaration %s: %s", a.Name.Local, msg)
+			}
+			return fail("attribute %s: %s", a.Name.Local, msg)
+		}
+	}
+	if msg := checkText(string(node.Content), xml11); msg != "" {
+		return fail("content: %s", msg)
+	}
+	return nil
+}
+
+// WellFormed checks that doc has a root element and that its tree is
+// well-formed, as with Element.WellFormed, except that documents
+// declared to be XML 1.1 may have the characters 1.1 allows.
+func (doc *Document) WellFormed() error {
+	if doc.root == nil {
+		return &Error{Op: "check", Err: ErrNoRootElement}
+	}
+	return doc.root.wellFormedTree(doc.decl.Version == "1.1")
+}
@@ -0,0 +1,323 @@
+package dom
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// encoding/xml only reads XML 1.0, so documents that declare themselves
+// to be XML 1.1 are read through an xml11Filter, which makes them into
+// 1.0 documents that mean the same.  It changes the version in the
+// declaration to 1.0, and turns the line ends 1.1 adds, NEL and U+2028,
+// into newlines.  1.1 also allows references to the control characters
+// 1.0 does not, such as &#x1;, which encoding/xml would reject, so the
+// filter puts the noncharacters U+FDD0 to U+FDEF in their place, and the
+// parser turns those back into the control characters once it has the
+// text they are in.  Since that leaves no way to tell the noncharacters
+// apart, they are not allowed in 1.1 documents.  Neither are the C1
+// control characters unless they are written as references, as 1.1
+// says.
+//
+// The filter only ever makes the input shorter, so the positions the
+// parser records in 1.1 documents are where things are in what it
+// made, which is a few columns off on lines with those references or
+// line ends.
+
+// standIn is where the stand-ins for control characters start.
+const standIn = 0xFDD0
+
+const (
+	inText = iota
+	inCDATA
+	inComment
+	inPI
+	inDirective
+)
+
+type xml11Filter struct {
+	r     *bufio.Reader
+	out   []byte
+	state int
+	// depth is how many brackets deep into a DOCTYPE declaration the
+	// filter is.
+	depth int
+	// mapped is set once the filter has put in any stand-ins.
+	mapped bool
+	err    error
+}
+
+// peek reports whether the input goes on with s, only reading as far
+// as it matches.
+func peek(r *bufio.Reader, s string) bool {
+	for i := 1; i <= len(s); i++ {
+		b, _ := r.Peek(i)
+		if len(b) < i || b[i-1] != s[i-1] {
+			return false
+		}
+	}
+	return true
+}
+
+// restricted reports whether c is a control character that XML 1.1
+// only allows as a character reference.
+func restricted(c rune) bool {
+	return (c >= 0x1 && c <= 0x1F && c != '\t' && c != '\n' && c != '\r') || (c >= 0x7F && c <= 0x9F)
+}
+
+// IsXML11Char reports whether r may appear in an XML 1.1 document.  The
+// control characters it allows that IsXMLChar does not can only be
+// written as character references, which is how the Encoder writes them
+// in documents declared to be XML 1.1.
+func IsXML11Char(r rune) bool {
+	switch {
+	case r >= 0x1 && r <= 0xD7FF, r >= 0xE000 && r <= 0xFFFD, r >= 0x10000 && r <= 0x10FFFF:
+		return true
+	}
+	return false
+}
+
+func (f *xml11Filter) Read(p []byte) (int, error) {
+	for len(f.out) < len(p) && f.err == nil {
+		f.step()
+		// Only wait for more input if there is nothing to return,
+		// so that documents read off a stream are parsed as they
+		// arrive.
+		if len(f.out) > 0 && f.r.Buffered() == 0 {
+			break
+		}
+	}
+	n := copy(p, f.out)
+	f.out = f.out[:copy(f.out, f.out[n:])]
+	if n == 0 {
+		return 0, f.err
+	}
+	return n, nil
+}
+
+// step filters the next character of the input.
+func (f *xml11Filter) step() {
+	c, size, err := f.r.ReadRune()
+	if err != nil {
+		f.err = err
+		return
+	}
+	if c == utf8.RuneError && size == 1 {
+		// Pass invalid UTF-8 on for the decoder to complain about.
+		f.r.UnreadRune()
+		b, _ := f.r.ReadByte()
+		f.out = append(f.out, b)
+		return
+	}
+	switch {
+	case c == '\r':
+		if peek(f.r, "\u0085") {
+			f.r.Discard(2)
+			c = '\n'
+		}
+	case c == 0x85 || c == 0x2028:
+		c = '\n'
+	case c >= 0x7F && c <= 0x9F:
+		f.err = fmt.Errorf("character %U must be written as a character reference in XML 1.1", c)
+		return
+	case c >= standIn && c <= standIn+0x1F:
+		f.err = fmt.Errorf("character %U is not supported in XML 1.1 documents", c)
+		return
+	}
+	f.out = utf8.AppendRune(f.out, c)
+	switch f.state {
+	case inText:
+		switch c {
+		case '<':
+			f.markup()
+		case '&':
+			f.charRef()
+		}
+	case inCDATA:
+		f.end(c == ']', "]>")
+	case inComment:
+		f.end(c == '-', "->")
+	case inPI:
+		f.end(c == '?', ">")
+	case inDirective:
+		switch {
+		case c == '[':
+			f.depth++
+		case c == ']':
+			f.depth--
+		case c == '>' && f.depth <= 0:
+			f.state = inText
+		}
+	}
+}
+
+// end passes on rest, and goes back to reading text, if at is set and rest
+// comes next.
+func (f *xml11Filter) end(at bool, rest string) {
+	if at && peek(f.r, rest) {
+		f.r.Discard(len(rest))
+		f.out = append(f.out, rest...)
+		f.state = inText
+	}
+}
+
+// markup passes on what comes after a < for comments, CDATA sections,
+// processing instructions and declarations, and starts reading them.
+func (f *xml11Filter) markup() {
+	for _, m := range []struct {
+		s     string
+		state int
+	}{{"!--", inComment}, {"![CDATA[", inCDATA}, {"?", inPI}, {"!", inDirective}} {
+		if peek(f.r, m.s) {
+			f.r.Discard(len(m.s))
+			f.out = append(f.out, m.s...)
+			f.state, f.depth = m.state, 0
+			return
+		}
+	}
+}
+
+// charRef puts a stand-in in place of the & that has just been passed
+// on, and what follows it, if they are a reference to a control
+// character.
+func (f *xml11Filter) charRef() {
+	if !peek(f.r, "#") {
+		return
+	}
+	for i := 2; i <= 12; i++ {
+		b, _ := f.r.Peek(i)
+		if len(b) < i {
+			return
+		}
+		if b[i-1] != ';' {
+			continue
+		}
+		ref, base := string(b[1:i-1]), 10
+		if strings.HasPrefix(ref, "x") {
+			ref, base = ref[1:], 16
+		}
+		v, err := strconv.ParseUint(ref, base, 8)
+		if err != nil || !restricted(rune(v)) || v >= 0x7F {
+			return
+		}
+		f.r.Discard(i)
+		f.out = utf8.AppendRune(f.out[:len(f.out)-1], standIn+rune(v))
+		f.mapped = true
+		return
+	}
+}
+
+// sniffVersion looks at the XML declaration at the start of r, if there
+// is one.  For XML 1.1 documents, it returns a reader that has them as
+// encoding/xml can read them, and the xml11Filter that does it, which
+// has no input yet if the document is not in UTF-8, since it has to
+// filter what the CharsetReader makes of the rest of the document.
+// Otherwise it returns a reader with the same bytes as r, and nil.
+func sniffVersion(r io.Reader) (io.Reader, *xml11Filter) {
+	br := bufio.NewReader(r)
+	start := ""
+	switch {
+	case peek(br, "<?xml"):
+		start = "<?xml"
+	case peek(br, "\xEF\xBB\xBF<?xml"):
+		start = "\xEF\xBB\xBF<?xml"
+	default:
+		return br, nil
+	}
+	var decl []byte
+	for i := len(start) + 1; i < 512 && decl == nil; i++ {
+		b, _ := br.Peek(i)
+		if len(b) < i {
+			return br, nil
+		}
+		if i == len(start)+1 && !isTagSpace(b[i-1]) {
+			return br, nil
+		}
+		if bytes.HasSuffix(b, []byte("?>")) {
+			decl = b
+		}
+	}
+	if decl == nil {
+		return br, nil
+	}
+	d := parseDeclaration(string(decl[len(start) : len(decl)-2]))
+	if d.Version != "1.1" {
+		return br, nil
+	}
+	// The version comes first in the declaration, so the first 1.1 in
+	// it is the version.
+	i := bytes.Index(decl, []byte("1.1"))
+	decl = append(append(append([]byte{}, decl[:i]...), "1.0"...), decl[i+3:]...)
+	br.Discard(len(decl))
+	f := &xml11Filter{}
+	if d.Encoding != "" && !strings.EqualFold(d.Encoding, "utf-8") {
+		return io.MultiReader(bytes.NewReader(decl), br), f
+	}
+	f.r = br
+	return io.MultiReader(bytes.NewReader(decl), f), f
+}
+
+// charsetReader returns a CharsetReader that filters what cr makes
+// through f.
+func (f *xml11Filter) charsetReader(cr func(string, io.Reader) (io.Reader, error)) func(string, io.Reader) (io.Reader, error) {
+	return func(label string, in io.Reader) (io.Reader, error) {
+		r, err := cr(label, in)
+		if err != nil {
+			return nil, err
+		}
+		f.r = bufio.NewReader(r)
+		return f, nil
+	}
+}
+
+func unmapControl(r rune) rune {
+	if r > standIn && r <= standIn+0x1F {
+		return r - standIn
+	}
+	return r
+}
+
+// restoreControls turns the stand-ins in the Content and attribute
+// values of e back into the control characters they stand for.
+func restoreControls(e *Element) {
+	// The stand-ins all start with these bytes in UTF-8.
+	const prefix = "\xEF\xB7"
+	if bytes.Contains(e.Content, []byte(prefix)) {
+		e.Content = bytes.Map(unmapControl, e.Content)
+	}
+	for i, a := range e.Attributes {
+		if strings.Contains(a.Value, prefix) {
+			e.Attributes[i].Value = strings.Map(unmapControl, a.Value)
+		}
+	}
+}
+
+// escapeText writes s escaped as xml.EscapeText does, except in XML 1.1
+// documents, where the control characters are written as character
+// references, which 1.1 allows, and so are NEL and U+2028, which would
+// otherwise be read back as newlines.
+func escapeText(e *Encoder, s []byte) error {
+	if !e.xml11 {
+		return xml.EscapeText(e, s)
+	}
+	last := 0
+	for i := 0; i < len(s); {
+		c, size := utf8.DecodeRune(s[i:])
+		if restricted(c) || c == 0x2028 {
+			if err := xml.EscapeText(e, s[last:i]); err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(e, "&#x%X;", c); err != nil {
+				return err
+			}
+			last = i + size
+		}
+		i += size
+	}
+	return xml.EscapeText(e, s[last:])
+}
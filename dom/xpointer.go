@@ -0,0 +1,237 @@
+package dom
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// pointerPart is one scheme(data) part of an XPointer.
+type pointerPart struct {
+	scheme, data string
+}
+
+// parsePointer splits ptr, a scheme-based XPointer, into its parts,
+// undoing the ^ escapes in their data.
+func parsePointer(ptr string) ([]pointerPart, error) {
+	res := []pointerPart{}
+	s := strings.TrimSpace(ptr)
+	for s != "" {
+		i := strings.IndexByte(s, '(')
+		if i < 0 {
+			return nil, fmt.Errorf("dom: invalid xpointer %q: %q is not a pointer part", ptr, s)
+		}
+		part := pointerPart{scheme: s[:i]}
+		if prefix, local, ok := strings.Cut(part.scheme, ":"); !IsNCName(prefix) || (ok && !IsNCName(local)) {
+			return nil, fmt.Errorf("dom: invalid xpointer %q: bad scheme name %q", ptr, part.scheme)
+		}
+		var data strings.Builder
+		depth := 0
+		j := i + 1
+	Data:
+		for ; ; j++ {
+			if j == len(s) {
+				return nil, fmt.Errorf("dom: invalid xpointer %q: unbalanced parentheses", ptr)
+			}
+			switch c := s[j]; c {
+			case '^':
+				if j+1 == len(s) || !strings.ContainsRune("()^", rune(s[j+1])) {
+					return nil, fmt.Errorf("dom: invalid xpointer %q: ^ must escape (, ) or ^", ptr)
+				}
+				j++
+				data.WriteByte(s[j])
+			case '(':
+				depth++
+				data.WriteByte(c)
+			case ')':
+				if depth == 0 {
+					break Data
+				}
+				depth--
+				data.WriteByte(c)
+			default:
+				data.WriteByte(c)
+			}
+		}
+		part.data = data.String()
+		res = append(res, part)
+		s = strings.TrimLeft(s[j+1:], " \t\r\n")
+	}
+	return res, nil
+}
+
+// byID returns the first element of the tree under root, in document
+// order, whose xml:id is id, or failing that the first whose id
+// attribute is.
+func byID(root *Element, id string) *Element {
+	var plain *Element
+	var walk func(e *Element) *Element
+	walk = func(e *Element) *Element {
+		for _, a := range e.Attributes {
+			switch {
+			case a.Value != id:
+			case a.Name.Space == NS_XML && a.Name.Local == "id":
+				return e
+			case a.Name.Space == "" && a.Name.Local == "id" && plain == nil:
+				plain = e
+			}
+		}
+		e.pageIn()
+		for _, c := range e.children {
+			if res := walk(c); res != nil {
+				return res
+			}
+		}
+		return nil
+	}
+	if res := walk(root); res != nil {
+		return res
+	}
+	return plain
+}
+
+// elementScheme returns the element the data of an element() pointer
+// part selects in the tree under root, or nil if there is none.
+func elementScheme(root *Element, data string) (*Element, error) {
+	name, steps, _ := strings.Cut(data, "/")
+	var e *Element
+	indexes := []string{}
+	if steps != "" || strings.HasSuffix(data, "/") {
+		indexes = strings.Split(steps, "/")
+	}
+	switch {
+	case name == "":
+		// The child sequence starts above the root element.
+		if len(indexes) == 0 {
+			return nil, fmt.Errorf("dom: invalid element() pointer %q", data)
+		}
+		e = &Element{children: []*Element{root}}
+	case !IsNCName(name):
+		return nil, fmt.Errorf("dom: invalid element() pointer %q", data)
+	default:
+		if e = byID(root, name); e == nil {
+			return nil, nil
+		}
+	}
+	for _, step := range indexes {
+		e.pageIn()
+		i, err := strconv.Atoi(step)
+		if err != nil || i < 1 || step[0] == '0' {
+			return nil, fmt.Errorf("dom: invalid element() pointer %q", data)
+		}
+		if i > len(e.children) {
+			return nil, nil
+		}
+		e = e.children[i-1]
+	}
+	return e, nil
+}
+
+// XPointer returns the element ptr, an XPointer, selects in the tree node
+// is the root of, as XInclude and fragment identifiers of XML documents
+// use them.  ptr can be a shorthand pointer, the xml:id of an element, or
+// failing that the value of an attribute called id, as in
+//    chapter-2
+// or a pointer made of scheme(data) parts, as in
+//    element(chapter-2/3) element(/1/4/3)
+// An element() part gives either the same as a shorthand pointer, or a
+// child sequence of 1-based indexes from the top of the document, in
+// which /1 is the root element, or both, with the indexes counting from
+// the element the ID selects.  The parts are tried in order, and the
+// first that selects an element wins.  Parts of the other schemes, such
+// as xmlns() and xpointer(), are skipped, as the XPointer framework says
+// they are where they are not supported.  It is an error if ptr is not a
+// valid pointer or selects no element.
+func (node *Element) XPointer(ptr string) (*Element, error) {
+	ptr = strings.TrimSpace(ptr)
+	if IsNCName(ptr) {
+		if e := byID(node, ptr); e != nil {
+			return e, nil
+		}
+		return nil, fmt.Errorf("dom: no element has ID %q", ptr)
+	}
+	parts, err := parsePointer(ptr)
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range parts {
+		if part.scheme != "element" {
+			continue
+		}
+		e, err := elementScheme(node, part.data)
+		if err != nil {
+			return nil, err
+		}
+		if e != nil {
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("dom: xpointer %q does not select an element", ptr)
+}
+
+// XPointer returns the element ptr selects in doc, as Element.XPointer
+// does.
+func (doc *Document) XPointer(ptr string) (*Element, error) {
+	if doc.root == nil {
+		return nil, ErrNoRootElement
+	}
+	return doc.root.XPointer(ptr)
+}
+
+// FollowLink returns the element link, a URI reference with an XPointer
+// as its fragment identifier, points to, for following links between
+// documents such as XLink hrefs.  link is resolved against the base URI
+// of node.  If it names no other document, as in #intro, the element is
+// looked for in the tree node is in.  Otherwise the document is read
+// through r and parsed with opts, and the element looked for in it; a
+// link without a fragment identifier points to its root.
+func (node *Element) FollowLink(r Resolver, link string, opts ...Option) (*Element, error) {
+	ref, frag, hasFrag := strings.Cut(link, "#")
+	if unescaped, err := url.PathUnescape(frag); err == nil {
+		frag = unescaped
+	}
+	top := node
+	for top.parent != nil {
+		top = top.parent
+	}
+	if ref == "" {
+		if !hasFrag {
+			return top, nil
+		}
+		return top.XPointer(frag)
+	}
+	uri, err := node.ResolveURI(ref)
+	if err != nil {
+		return nil, err
+	}
+	if top.uri != "" && top.uri == uri {
+		if !hasFrag {
+			return top, nil
+		}
+		return top.XPointer(frag)
+	}
+	if r == nil {
+		return nil, fmt.Errorf("dom: %s: no Resolver to read it with", uri)
+	}
+	rc, err := r.Resolve(uri)
+	if err != nil {
+		return nil, fmt.Errorf("dom: %s: %w", uri, err)
+	}
+	defer rc.Close()
+	doc, err := Parse(rc, append(opts[:len(opts):len(opts)], WithBaseURI(uri))...)
+	if err != nil {
+		return nil, fmt.Errorf("dom: %s: %w", uri, err)
+	}
+	if doc.root == nil {
+		return nil, fmt.Errorf("dom: %s: %w", uri, ErrNoRootElement)
+	}
+	if !hasFrag {
+		return doc.root, nil
+	}
+	e, err := doc.root.XPointer(frag)
+	if err != nil {
+		return nil, fmt.Errorf("dom: %s: %w", uri, err)
+	}
+	return e, nil
+}
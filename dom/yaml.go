@@ -0,0 +1,106 @@
+package dom
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ToYAML encodes the tree rooted at node as YAML, using the same mapping
+// as ToMap.  opts can be nil to use the defaults, which turn
+//    <order id="1"><line>a</line><line>b</line></order>
+// into:
+//    order:
+//        '@id': "1"
+//        line:
+//            - a
+//            - b
+// Content and attribute values are always YAML strings.
+func ToYAML(node *Element, opts *MapOptions) ([]byte, error) {
+	return yaml.Marshal(ToMap(node, opts))
+}
+
+// yamlAliasNodes is how many nodes FromYAML copies through aliases at
+// most, so that aliases of aliases cannot blow a small document up into
+// a huge tree.
+const yamlAliasNodes = 1 << 16
+
+// FromYAML decodes a YAML mapping in the form produced by ToYAML into a
+// tree.  Like FromJSON, scalars are copied into the tree exactly as they
+// were written, so 0x10 stays 0x10 instead of becoming 16.  Aliases are
+// copied in full where they are used, up to yamlAliasNodes nodes in all,
+// and must not refer to a node they are in.
+func FromYAML(data []byte, opts *MapOptions) (*Element, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("dom: invalid YAML: %v", err)
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("dom: invalid YAML: expected a mapping")
+	}
+	y := &yamlReader{expanding: map[*yaml.Node]bool{}}
+	v, err := y.node(doc.Content[0])
+	if err != nil {
+		return nil, err
+	}
+	return FromMap(v.(map[string]interface{}), opts)
+}
+
+// yamlReader converts YAML nodes into the values FromMap understands.
+type yamlReader struct {
+	// expanding holds the anchored nodes whose aliases are being copied.
+	expanding map[*yaml.Node]bool
+	// copied counts the nodes copied through aliases.
+	copied int
+}
+
+// node converts n.
+func (y *yamlReader) node(n *yaml.Node) (interface{}, error) {
+	if len(y.expanding) > 0 {
+		if y.copied++; y.copied > yamlAliasNodes {
+			return nil, fmt.Errorf("dom: invalid YAML: line %d: aliases expand to more than %d nodes", n.Line, yamlAliasNodes)
+		}
+	}
+	switch n.Kind {
+	case yaml.AliasNode:
+		if y.expanding[n.Alias] {
+			return nil, fmt.Errorf("dom: invalid YAML: line %d: alias %s refers to itself", n.Line, n.Value)
+		}
+		y.expanding[n.Alias] = true
+		defer delete(y.expanding, n.Alias)
+		return y.node(n.Alias)
+	case yaml.ScalarNode:
+		if n.Tag == "!!null" {
+			return nil, nil
+		}
+		return n.Value, nil
+	case yaml.SequenceNode:
+		res := make([]interface{}, 0, len(n.Content))
+		for _, c := range n.Content {
+			v, err := y.node(c)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, v)
+		}
+		return res, nil
+	case yaml.MappingNode:
+		res := map[string]interface{}{}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k := n.Content[i]
+			if k.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("dom: invalid YAML: line %d: keys must be scalars", k.Line)
+			}
+			if _, ok := res[k.Value]; ok {
+				return nil, fmt.Errorf("dom: invalid YAML: line %d: duplicate key %s", k.Line, k.Value)
+			}
+			v, err := y.node(n.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			res[k.Value] = v
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("dom: invalid YAML: line %d: unexpected node", n.Line)
+}
@@ -0,0 +1,101 @@
+package dom
+
+import (
+	"bytes"
+	"encoding/xml"
+	"unsafe"
+)
+
+// ParseBytesZeroCopy is like ParseWithOptions, but parses data, and
+// instead of copying Content and attribute values out of it, points them
+// into data wherever the text was written without entity or character
+// references or CDATA sections, and so reads the same in data as in the
+// tree.  This saves copying and keeping a second copy of the text of
+// large documents that are only going to be read.  Documents in XML 1.1,
+// in encodings other than UTF-8, or parsed with KeepEntityRefs are not
+// read byte for byte, and are copied as ParseWithOptions would.
+//
+// The tree borrows data, so data must not be changed for as long as the
+// tree is in use, and neither must the Content of the tree, since it
+// may be part of data.  Attribute values are strings that share memory
+// with data, so changing data later will change them too.  The Pool in
+// opts is not used, and trees parsed this way must not be given to
+// Pool.Release, since their buffers belong to data.  opts may be nil.
+func ParseBytesZeroCopy(data []byte, opts *ParseOptions) (*Document, error) {
+	if opts == nil {
+		opts = defaultOptions()
+	}
+	zc := *opts
+	zc.Pool = nil
+	zc.src = data
+	return ParseWithOptions(bytes.NewReader(data), &zc)
+}
+
+// borrowText sets e's Content to the trimmed part of raw, which reads
+// the same as the decoded text, so that nothing has to be copied.
+func borrowText(e *Element, raw []byte) bool {
+	text := bytes.TrimSpace(raw)
+	if len(text) == 0 {
+		return false
+	}
+	// Cap the slice, so that appending to Content copies rather than
+	// writing over the rest of the source.
+	e.Content = text[:len(text):len(text)]
+	return true
+}
+
+func isTagSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// rawValues returns the attribute values in tag, the source of a start
+// tag, as they were written between their quotes.
+func rawValues(tag []byte) [][]byte {
+	if len(tag) == 0 || tag[0] != '<' {
+		return nil
+	}
+	i := 1
+	for i < len(tag) && !isTagSpace(tag[i]) && tag[i] != '/' && tag[i] != '>' {
+		i++
+	}
+	res := [][]byte{}
+	for {
+		for i < len(tag) && isTagSpace(tag[i]) {
+			i++
+		}
+		if i >= len(tag) || tag[i] == '/' || tag[i] == '>' {
+			return res
+		}
+		eq := bytes.IndexByte(tag[i:], '=')
+		if eq < 0 {
+			return nil
+		}
+		i += eq + 1
+		for i < len(tag) && isTagSpace(tag[i]) {
+			i++
+		}
+		if i >= len(tag) || (tag[i] != '"' && tag[i] != '\'') {
+			return nil
+		}
+		end := bytes.IndexByte(tag[i+1:], tag[i])
+		if end < 0 {
+			return nil
+		}
+		res = append(res, tag[i+1:i+1+end])
+		i += end + 2
+	}
+}
+
+// borrowValues points the values of attrs into tag, the source of their
+// start tag, wherever they read the same there.
+func borrowValues(attrs []xml.Attr, tag []byte) {
+	raw := rawValues(tag)
+	if len(raw) != len(attrs) {
+		return
+	}
+	for i, v := range raw {
+		if len(v) > 0 && string(v) == attrs[i].Value {
+			attrs[i].Value = unsafe.String(&v[0], len(v))
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Package domgolden checks documents produced by tests against golden
+// files, which hold the output a test is expected to produce.
+//
+// Golden files hold the canonical form of a document, as domtest.Canonical
+// returns it: one line per element, with sorted attributes and no
+// namespace prefixes, so they diff cleanly in version control and do not
+// change when the code that produces them reorders attributes or
+// renames prefixes.  When a document does not match its golden file, the
+// failure shows a line diff of the two.
+//
+// Running the tests of a package that uses domgolden with -update, as in
+//
+//	go test ./report -update
+//
+// rewrites its golden files with what the tests got instead of checking
+// them, for when the output is meant to change.  Review the changes
+// before committing them.  Packages that do not use domgolden do not
+// know the flag, so it cannot be passed to go test ./...
+//
+// For some basic usage examples, see domgolden_test.go
+package domgolden
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/VictorLowther/simplexml/domtest"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files with the documents the tests produce")
+
+// Path returns the conventional golden file for the running test:
+// testdata/<test name>.golden, with the slashes in subtest names turned
+// into underscores.
+func Path(t testing.TB) string {
+	return filepath.Join("testdata", strings.ReplaceAll(t.Name(), "/", "_")+".golden")
+}
+
+// Equal is Assert with the golden file Path(t) returns.
+func Equal(t testing.TB, got interface{}) bool {
+	t.Helper()
+	return Assert(t, Path(t), got)
+}
+
+// Assert reports a test failure if got, which can be anything
+// domtest.Canonical accepts, does not match the golden file at path, or
+// rewrites the file if the tests are run with -update.  It returns
+// whether got matched.
+func Assert(t testing.TB, path string, got interface{}) bool {
+	t.Helper()
+	text, err := domtest.Canonical(got)
+	if err != nil {
+		t.Errorf("domgolden: %v", err)
+		return false
+	}
+	text += "\n"
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Errorf("domgolden: %v", err)
+			return false
+		}
+		if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Errorf("domgolden: %v", err)
+			return false
+		}
+		return true
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Errorf("domgolden: %v (run the tests with -update to create it)", err)
+		return false
+	}
+	// Golden files may have been checked out with CRLF line endings.
+	if diff := domtest.DiffText(strings.ReplaceAll(string(want), "\r\n", "\n"), text); diff != "" {
+		t.Errorf("domgolden: %s does not match (-want +got), run the tests with -update to accept:\n%s", path, diff)
+		return false
+	}
+	return true
+}
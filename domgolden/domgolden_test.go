@@ -0,0 +1,68 @@
+package domgolden
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+// recorder collects failures instead of failing the test.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func order(qty string) *dom.Element {
+	return dom.Elem("order", "urn:orders").
+		Attr("status", "", "new").
+		Attr("id", "", "1").
+		AddChildren(
+			dom.ElemC("line", "urn:orders", qty).Attr("sku", "", "X"),
+			dom.ElemC("note", "urn:orders", "rush"))
+}
+
+func TestEqual(t *testing.T) {
+	if Path(t) != filepath.Join("testdata", "TestEqual.golden") {
+		t.Errorf("unexpected path %s", Path(t))
+	}
+	Equal(t, order("2"))
+	doc, _ := dom.Parse(strings.NewReader(`<o:order xmlns:o="urn:orders" id="1" status="new"><o:line sku="X">2</o:line><o:note>rush</o:note></o:order>`))
+	Equal(t, doc)
+
+	r := &recorder{TB: t}
+	if Equal(r, order("3")) || len(r.errors) != 1 {
+		t.Fatalf("expected one failure, got %v", r.errors)
+	}
+	if !strings.Contains(r.errors[0], `-   <{urn:orders}line sku="X">2</{urn:orders}line>`) ||
+		!strings.Contains(r.errors[0], `+   <{urn:orders}line sku="X">3</{urn:orders}line>`) {
+		t.Errorf("unexpected diff %s", r.errors[0])
+	}
+	r = &recorder{TB: t}
+	if Assert(r, filepath.Join(t.TempDir(), "missing.golden"), order("2")) || len(r.errors) != 1 {
+		t.Errorf("expected a failure for a missing file, got %v", r.errors)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "new", "order.golden")
+	*update = true
+	defer func() { *update = false }()
+	if !Assert(t, path, order("2")) {
+		t.Fatal("update failed")
+	}
+	*update = false
+	Assert(t, path, order("2"))
+	b, _ := os.ReadFile(path)
+	os.WriteFile(path, []byte(strings.ReplaceAll(string(b), "\n", "\r\n")), 0644)
+	Assert(t, path, order("2"))
+}
@@ -0,0 +1,251 @@
+// Package domtest contains helpers for testing code that produces XML
+// with the simplexml/dom package.
+//
+// Documents are compared structurally: namespace prefixes, namespace
+// declarations, attribute order and whitespace around content do not
+// matter, while names, namespaces, attribute values, content and the
+// order of child elements do.  When two documents differ, the failure
+// message shows a line diff of their canonical forms, so it is obvious
+// which part of a large document is wrong.
+//
+// Every function that takes a document accepts a *dom.Document, a
+// *dom.Element, or a string or []byte holding XML.
+//
+// Config.Random generates random trees for property-based tests, and
+// Shrink cuts a tree that makes a property fail down to a small one that
+// still does.
+//
+// For some basic usage examples, see domtest_test.go
+package domtest
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/xpath"
+)
+
+func toElement(v interface{}) (*dom.Element, error) {
+	switch v := v.(type) {
+	case *dom.Element:
+		return v, nil
+	case *dom.Document:
+		if v.Root() == nil {
+			return nil, fmt.Errorf("domtest: document has no root element")
+		}
+		return v.Root(), nil
+	case string:
+		return toElement([]byte(v))
+	case []byte:
+		doc, err := dom.Parse(bytes.NewReader(v))
+		if err != nil {
+			return nil, fmt.Errorf("domtest: cannot parse XML: %v", err)
+		}
+		return toElement(doc)
+	}
+	return nil, fmt.Errorf("domtest: cannot use a %T as XML", v)
+}
+
+func clark(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return "{" + n.Space + "}" + n.Local
+}
+
+func escape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func canonical(e *dom.Element, depth int, lines []string) []string {
+	indent := strings.Repeat("  ", depth)
+	attrs := []string{}
+	for _, a := range e.Attributes {
+		if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") {
+			continue
+		}
+		attrs = append(attrs, fmt.Sprintf(" %s=\"%s\"", clark(a.Name), escape(a.Value)))
+	}
+	sort.Strings(attrs)
+	open := indent + "<" + clark(e.Name) + strings.Join(attrs, "")
+	content := strings.TrimSpace(string(e.Content))
+	children := e.Children()
+	switch {
+	case len(children) == 0 && content == "":
+		return append(lines, open+"/>")
+	case len(children) == 0:
+		return append(lines, open+">"+escape(content)+"</"+clark(e.Name)+">")
+	}
+	lines = append(lines, open+">")
+	if content != "" {
+		lines = append(lines, indent+"  "+escape(content))
+	}
+	for _, c := range children {
+		lines = canonical(c, depth+1, lines)
+	}
+	return append(lines, indent+"</"+clark(e.Name)+">")
+}
+
+// Canonical returns the form of v that documents are compared in: one
+// line per element, with names in {namespace}local form and attributes
+// sorted.
+func Canonical(v interface{}) (string, error) {
+	e, err := toElement(v)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(canonical(e, 0, nil), "\n"), nil
+}
+
+// diffLines returns a line diff of a and b, with removed lines starting
+// with "- ", added ones with "+ " and common ones with "  ".
+func diffLines(a, b []string) string {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var res strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			res.WriteString("  " + a[i] + "\n")
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			res.WriteString("- " + a[i] + "\n")
+			i++
+		default:
+			res.WriteString("+ " + b[j] + "\n")
+			j++
+		}
+	}
+	return res.String()
+}
+
+// DiffText returns a line diff of want and got, such as two canonical
+// forms, or "" if they are equal.
+func DiffText(want, got string) string {
+	if want == got {
+		return ""
+	}
+	return diffLines(strings.Split(want, "\n"), strings.Split(got, "\n"))
+}
+
+// Diff compares want and got, and returns a line diff of their canonical
+// forms, or "" if they are equal.
+func Diff(want, got interface{}) (string, error) {
+	w, err := Canonical(want)
+	if err != nil {
+		return "", err
+	}
+	g, err := Canonical(got)
+	if err != nil {
+		return "", err
+	}
+	return DiffText(w, g), nil
+}
+
+// EqualXML reports a test failure if want and got are not the same XML,
+// with a diff of the two.  It returns whether they were equal.
+func EqualXML(t testing.TB, want, got interface{}) bool {
+	t.Helper()
+	diff, err := Diff(want, got)
+	if err != nil {
+		t.Errorf("%v", err)
+		return false
+	}
+	if diff != "" {
+		t.Errorf("XML mismatch (-want +got):\n%s", diff)
+		return false
+	}
+	return true
+}
+
+func selectElements(v interface{}, path string) ([]*dom.Element, error) {
+	e, err := toElement(v)
+	if err != nil {
+		return nil, err
+	}
+	x, err := xpath.Compile(path)
+	if err != nil {
+		return nil, err
+	}
+	n := xpath.FromElement(e)
+	if e.Parent() == nil {
+		// Let relative paths start above the root element, as they would
+		// from a Document.
+		if n, err = rootOf(e); err != nil {
+			return nil, err
+		}
+	}
+	ns, err := x.Nodes(n, nil)
+	if err != nil {
+		return nil, err
+	}
+	return ns.Elements(), nil
+}
+
+// rootOf returns the root node of the tree e is the topmost element of.
+func rootOf(e *dom.Element) (xpath.Node, error) {
+	res, err := xpath.MustCompile("/").Nodes(xpath.FromElement(e), nil)
+	if err != nil {
+		return xpath.Node{}, err
+	}
+	return res[0], nil
+}
+
+// ContainsElement reports a test failure if path, an XPath expression
+// evaluated from the root of doc, does not select any elements.  It
+// returns the elements path selected.  Since there is no way to bind
+// prefixes, elements in a namespace are matched with predicates such as
+// [local-name()='x'] or [namespace-uri()='urn:y'].
+func ContainsElement(t testing.TB, doc interface{}, path string) []*dom.Element {
+	t.Helper()
+	res, err := selectElements(doc, path)
+	if err != nil {
+		t.Errorf("%s: %v", path, err)
+		return nil
+	}
+	if len(res) == 0 {
+		text, _ := Canonical(doc)
+		t.Errorf("%s: no elements matched in\n%s", path, text)
+	}
+	return res
+}
+
+// NoElement reports a test failure if path selects any elements from
+// doc.  It returns whether none were selected.
+func NoElement(t testing.TB, doc interface{}, path string) bool {
+	t.Helper()
+	res, err := selectElements(doc, path)
+	if err != nil {
+		t.Errorf("%s: %v", path, err)
+		return false
+	}
+	for _, e := range res {
+		t.Errorf("%s: unexpected match at %s", path, e.Path())
+	}
+	return len(res) == 0
+}
@@ -0,0 +1,144 @@
+package domtest
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"testing/quick"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+// recorder collects failures instead of failing the test.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+const want = `<?xml version="1.0"?>
+<a:order xmlns:a="urn:orders" id="1" status="new">
+  <a:line sku="X">2</a:line>
+  <a:note>  rush  </a:note>
+</a:order>`
+
+func TestEqualXML(t *testing.T) {
+	got := dom.Elem("order", "urn:orders").
+		Attr("status", "", "new").
+		Attr("id", "", "1").
+		AddChildren(
+			dom.ElemC("line", "urn:orders", "2").Attr("sku", "", "X"),
+			dom.ElemC("note", "urn:orders", "rush"))
+	EqualXML(t, want, got)
+	doc := dom.CreateDocument()
+	doc.SetRoot(got)
+	EqualXML(t, doc, []byte(want))
+
+	r := &recorder{}
+	got.Children()[0].Content = []byte("3")
+	if EqualXML(r, want, got) || len(r.errors) != 1 {
+		t.Fatalf("Expected one failure, got %v", r.errors)
+	}
+	if !strings.Contains(r.errors[0], `-   <{urn:orders}line sku="X">2</{urn:orders}line>`) ||
+		!strings.Contains(r.errors[0], `+   <{urn:orders}line sku="X">3</{urn:orders}line>`) {
+		t.Errorf("Unexpected diff %s", r.errors[0])
+	}
+	r = &recorder{}
+	if EqualXML(r, "<a>", got) || len(r.errors) != 1 {
+		t.Errorf("Expected a parse failure, got %v", r.errors)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	diff, err := Diff(`<a><b/><c/><d/></a>`, `<a><b/><d/><e/></a>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "  <a>\n    <b/>\n-   <c/>\n    <d/>\n+   <e/>\n  </a>\n"
+	if diff != expected {
+		t.Errorf("Expected diff\n%s\ngot\n%s", expected, diff)
+	}
+	if diff, _ := Diff(`<a x="1" y="2"/>`, `<a y="2" x="1"></a>`); diff != "" {
+		t.Errorf("Expected attribute order not to matter, got\n%s", diff)
+	}
+}
+
+func TestContainsElement(t *testing.T) {
+	if res := ContainsElement(t, want, "/*[local-name()='order']/*[@sku='X']"); len(res) != 1 {
+		t.Errorf("Expected one match, got %v", res)
+	}
+	r := &recorder{}
+	if res := ContainsElement(r, want, "//*[@sku='Y']"); len(res) != 0 || len(r.errors) != 1 {
+		t.Errorf("Expected a failure, got %v", r.errors)
+	}
+	NoElement(t, want, "//*[@sku='Y']")
+	r = &recorder{}
+	if NoElement(r, want, "//*[local-name()='line']") || len(r.errors) != 1 {
+		t.Errorf("Expected a failure, got %v", r.errors)
+	}
+	r = &recorder{}
+	if NoElement(r, want, "//[") || len(r.errors) != 1 {
+		t.Errorf("Expected a bad path to fail, got %v", r.errors)
+	}
+}
+
+func TestRandom(t *testing.T) {
+	roundTrip := func(tree Tree) bool {
+		doc := dom.CreateDocument()
+		doc.SetRoot(tree.Root)
+		back, err := dom.Parse(bytes.NewReader(doc.Bytes()))
+		if err != nil {
+			return false
+		}
+		d, err := Diff(tree.Root, back)
+		return err == nil && d == ""
+	}
+	if err := quick.Check(roundTrip, nil); err != nil {
+		t.Error(err)
+	}
+	r := rand.New(rand.NewSource(1))
+	c := Config{Names: []string{"n"}, Namespaces: []string{""}, MaxDepth: 2, MaxChildren: 2, Alphabet: "x"}
+	for i := 0; i < 20; i++ {
+		e := c.Random(r)
+		for _, n := range e.All() {
+			if n.Name.Local != "n" || n.Name.Space != "" || len(strings.Trim(string(n.Content), "x")) > 0 {
+				t.Fatalf("tree does not follow its Config: %s", e)
+			}
+		}
+		if depth := strings.Count(e.Dump(), "\n    "); depth > 0 {
+			t.Fatalf("tree is too deep: %s", e)
+		}
+	}
+}
+
+func TestShrink(t *testing.T) {
+	tree := Config{}.Random(rand.New(rand.NewSource(7)))
+	for len(tree.All()) < 5 {
+		tree.AddChild(dom.Elem("item", "urn:a").Attr("k", "", "v"))
+	}
+	tree.AddChild(dom.Elem("bad", "").AddChild(dom.Elem("deep", "")))
+	before := tree.String()
+	// The property fails on trees with a deep element in them.
+	fails := func(e *dom.Element) bool {
+		for _, n := range e.All() {
+			if n.Name.Local == "deep" {
+				return true
+			}
+		}
+		return false
+	}
+	got := Shrink(tree, fails)
+	if s := strings.TrimSpace(got.String()); s != "<deep/>" {
+		t.Errorf("expected the tree to shrink to the deep element, got %s", got)
+	}
+	if tree.String() != before {
+		t.Errorf("Shrink changed the tree it was given")
+	}
+}
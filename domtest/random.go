@@ -0,0 +1,207 @@
+package domtest
+
+import (
+	"encoding/xml"
+	"math/rand"
+	"reflect"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+// Config says what the trees Random generates are made of.  Its zero
+// value generates trees from the defaults given for each field.
+type Config struct {
+	// Names are the local names of elements and attributes.  They
+	// default to a few short names, one of them not ASCII.
+	Names []string
+	// Namespaces are the namespaces elements and attributes can be in,
+	// with "" for none.  They default to "" and two others.
+	Namespaces []string
+	// MaxDepth is the most levels of elements a tree can have, and
+	// defaults to 4.
+	MaxDepth int
+	// MaxChildren is the most children an element can have, and
+	// MaxAttrs the most attributes.  They default to 4 and 3.
+	MaxChildren, MaxAttrs int
+	// Alphabet is what text and attribute values are made of.  It
+	// defaults to letters, spaces and the characters markup has to
+	// escape, along with a few that are not ASCII.
+	Alphabet string
+	// MaxText is the most characters in a text or value, and defaults
+	// to 8.
+	MaxText int
+}
+
+func (c Config) withDefaults() Config {
+	if len(c.Names) == 0 {
+		c.Names = []string{"a", "b", "item", "x-y", "_z", "été"}
+	}
+	if len(c.Namespaces) == 0 {
+		c.Namespaces = []string{"", "urn:a", "http://example.com/b"}
+	}
+	if c.MaxDepth <= 0 {
+		c.MaxDepth = 4
+	}
+	if c.MaxChildren <= 0 {
+		c.MaxChildren = 4
+	}
+	if c.MaxAttrs <= 0 {
+		c.MaxAttrs = 3
+	}
+	if c.Alphabet == "" {
+		c.Alphabet = "ab yz<>&\"'é世\t\n"
+	}
+	if c.MaxText <= 0 {
+		c.MaxText = 8
+	}
+	return c
+}
+
+func (c *Config) name(r *rand.Rand) xml.Name {
+	return xml.Name{
+		Space: c.Namespaces[r.Intn(len(c.Namespaces))],
+		Local: c.Names[r.Intn(len(c.Names))],
+	}
+}
+
+// text returns a random string from the alphabet.  It never starts or
+// ends with whitespace, since Parse trims that off the Content of
+// elements.
+func (c *Config) text(r *rand.Rand) string {
+	alphabet := []rune(c.Alphabet)
+	b := make([]rune, r.Intn(c.MaxText+1))
+	for i := range b {
+		b[i] = alphabet[r.Intn(len(alphabet))]
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func (c *Config) element(r *rand.Rand, depth int) *dom.Element {
+	name := c.name(r)
+	e := dom.Elem(name.Local, name.Space)
+	for n := r.Intn(c.MaxAttrs + 1); n > 0; n-- {
+		a := xml.Attr{Name: c.name(r), Value: c.text(r)}
+		dup := false
+		for _, b := range e.Attributes {
+			dup = dup || b.Name == a.Name
+		}
+		if !dup {
+			e.Attributes = append(e.Attributes, a)
+		}
+	}
+	if depth < c.MaxDepth {
+		for n := r.Intn(c.MaxChildren + 1); n > 0; n-- {
+			e.AddChild(c.element(r, depth+1))
+		}
+	}
+	if len(e.Children()) == 0 {
+		e.Content = []byte(c.text(r))
+	}
+	return e
+}
+
+// Random returns a random tree generated from r as c says.  Only leaf
+// elements have text, so the trees are the same however they are
+// indented, and they round-trip through Encode and Parse unchanged,
+// except for the namespace declarations the encoder adds.
+func (c Config) Random(r *rand.Rand) *dom.Element {
+	c = c.withDefaults()
+	return c.element(r, 1)
+}
+
+// Tree is a random tree for property-based testing with testing/quick,
+// generated from the default Config:
+//    err := quick.Check(func(tree domtest.Tree) bool {
+//        doc := dom.CreateDocument()
+//        doc.SetRoot(tree.Root)
+//        back, err := dom.Parse(bytes.NewReader(doc.Bytes()))
+//        d, _ := domtest.Diff(tree.Root, back)
+//        return err == nil && d == ""
+//    }, nil)
+// To test with other trees, or with another library such as rapid, use
+// Config.Random on a *rand.Rand seeded from the library instead.
+type Tree struct {
+	Root *dom.Element
+}
+
+// Generate implements quick.Generator.
+func (Tree) Generate(r *rand.Rand, size int) reflect.Value {
+	return reflect.ValueOf(Tree{Root: Config{}.Random(r)})
+}
+
+// String returns the tree as XML, so that testing/quick reports failures
+// readably.
+func (t Tree) String() string {
+	return t.Root.String()
+}
+
+// smaller returns the trees that are one step smaller than e: those with
+// one of its elements dropped, one of its elements replaced by one of
+// its children, one attribute dropped or one text cut in half.
+func smaller(e *dom.Element) []*dom.Element {
+	res := []*dom.Element{}
+	for _, c := range e.Children() {
+		res = append(res, c.Clone())
+	}
+	count := len(e.All())
+	for i := 0; i < count; i++ {
+		// Each candidate is a fresh copy with the ith element changed.
+		edit := func(f func(n *dom.Element) bool) {
+			t := e.Clone()
+			if f(t.All()[i]) {
+				res = append(res, t)
+			}
+		}
+		edit(func(n *dom.Element) bool {
+			if n.Parent() == nil {
+				return false
+			}
+			n.Parent().RemoveChild(n)
+			return true
+		})
+		edit(func(n *dom.Element) bool {
+			if n.Parent() == nil || len(n.Children()) == 0 {
+				return false
+			}
+			n.Parent().ReplaceChild(n, n.Children()...)
+			return true
+		})
+		for j := range e.All()[i].Attributes {
+			j := j
+			edit(func(n *dom.Element) bool {
+				n.Attributes = append(n.Attributes[:j:j], n.Attributes[j+1:]...)
+				return true
+			})
+		}
+		edit(func(n *dom.Element) bool {
+			if len(n.Content) == 0 {
+				return false
+			}
+			text := []rune(string(n.Content))
+			n.Content = []byte(string(text[:len(text)/2]))
+			return true
+		})
+	}
+	return res
+}
+
+// Shrink returns the smallest tree it can find that is like e and still
+// fails, for a tree e that makes a property fail.  It keeps making
+// smaller copies of the tree, by dropping elements, lifting children
+// into the place of their parent, dropping attributes and shortening
+// text, and keeping the first that fails, until none of them do.  e is
+// not changed.
+func Shrink(e *dom.Element, fails func(*dom.Element) bool) *dom.Element {
+	res := e.Clone()
+Smaller:
+	for {
+		for _, t := range smaller(res) {
+			if fails(t) {
+				res = t
+				continue Smaller
+			}
+		}
+		return res
+	}
+}
@@ -0,0 +1,554 @@
+// Package dsig creates and checks XML Signatures over simplexml/dom
+// trees.
+//
+// Signatures use RSA (PKCS #1 v1.5) or ECDSA keys with SHA-256 or
+// SHA-512, and references are canonicalized with the c14n package,
+// exclusively by default.  A Signer can make enveloped signatures, which
+// are placed inside the element they sign as SAML and WS-Security expect,
+// or signatures over any set of elements identified by their ID
+// attributes.
+//
+// A Verifier only trusts the keys and certificates it is given.  Keys
+// and certificates carried in the KeyInfo of a signature are never used,
+// since anybody can put one there.  Verify returns the elements a
+// signature covers, and code that trusts a document because it is signed
+// must only look at those elements: checking that "a signature is valid"
+// and then reading some other part of the document is what signature
+// wrapping attacks exploit.
+//
+// The canonical forms, and so the digests, are those of the dom tree,
+// which the parser builds without whitespace-only text.  Signatures made
+// by other software over indented content therefore do not verify, and
+// signed documents meant for other software must be written without
+// Pretty, which adds indentation.
+//
+// For some basic usage examples, see dsig_test.go
+package dsig
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	// Register the hashes we use.
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	"github.com/VictorLowther/simplexml/c14n"
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+// NS_DSIG is the namespace of XML Signature elements.
+const NS_DSIG = "http://www.w3.org/2000/09/xmldsig#"
+
+// Algorithm identifiers.
+const (
+	EnvelopedSignature = NS_DSIG + "enveloped-signature"
+
+	SHA256 = "http://www.w3.org/2001/04/xmlenc#sha256"
+	SHA512 = "http://www.w3.org/2001/04/xmlenc#sha512"
+
+	RSASHA256   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	RSASHA512   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512"
+	ECDSASHA256 = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256"
+	ECDSASHA512 = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha512"
+)
+
+var digestMethods = map[string]crypto.Hash{
+	SHA256: crypto.SHA256,
+	SHA512: crypto.SHA512,
+}
+
+type signatureMethod struct {
+	hash  crypto.Hash
+	ecdsa bool
+}
+
+var signatureMethods = map[string]signatureMethod{
+	RSASHA256:   {crypto.SHA256, false},
+	RSASHA512:   {crypto.SHA512, false},
+	ECDSASHA256: {crypto.SHA256, true},
+	ECDSASHA512: {crypto.SHA512, true},
+}
+
+// Signer makes signatures.
+type Signer struct {
+	// Key signs.  It must be an *rsa.PrivateKey or *ecdsa.PrivateKey, or
+	// another crypto.Signer with one of their public keys.
+	Key crypto.Signer
+	// Certificates, if any, are put in the KeyInfo of signatures so that
+	// receivers can tell which key was used.
+	Certificates []*x509.Certificate
+	// Hash is crypto.SHA256, the default, or crypto.SHA512.
+	Hash crypto.Hash
+	// Canonicalization is the c14n algorithm to use, c14n.Exclusive by
+	// default.
+	Canonicalization string
+	// IDAttribute is the name of the unqualified attribute that
+	// identifies signed elements.  It defaults to "ID".
+	IDAttribute string
+}
+
+func (s *Signer) hash() crypto.Hash {
+	if s.Hash == 0 {
+		return crypto.SHA256
+	}
+	return s.Hash
+}
+
+func (s *Signer) canonicalization() string {
+	if s.Canonicalization == "" {
+		return c14n.Exclusive
+	}
+	return s.Canonicalization
+}
+
+func (s *Signer) idAttribute() string {
+	if s.IDAttribute == "" {
+		return "ID"
+	}
+	return s.IDAttribute
+}
+
+func (s *Signer) methods() (string, string, error) {
+	var digest string
+	switch s.hash() {
+	case crypto.SHA256:
+		digest = SHA256
+	case crypto.SHA512:
+		digest = SHA512
+	default:
+		return "", "", fmt.Errorf("dsig: unsupported hash %v", s.hash())
+	}
+	for alg, m := range signatureMethods {
+		if m.hash != s.hash() {
+			continue
+		}
+		switch s.Key.Public().(type) {
+		case *rsa.PublicKey:
+			if !m.ecdsa {
+				return digest, alg, nil
+			}
+		case *ecdsa.PublicKey:
+			if m.ecdsa {
+				return digest, alg, nil
+			}
+		}
+	}
+	return "", "", fmt.Errorf("dsig: unsupported key type %T", s.Key.Public())
+}
+
+func ds(name string) *dom.Element {
+	return dom.Elem(name, NS_DSIG)
+}
+
+func algorithm(name, alg string) *dom.Element {
+	return ds(name).Attr("Algorithm", "", alg)
+}
+
+// reference builds a Reference to e.
+func (s *Signer) reference(e *dom.Element, uri string, transforms []string, digest string) (*dom.Element, error) {
+	data, err := transform(e, transforms, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	h := digestMethods[digest].New()
+	h.Write(data)
+	ts := ds("Transforms")
+	for _, t := range transforms {
+		ts.AddChild(algorithm("Transform", t))
+	}
+	return ds("Reference").Attr("URI", "", uri).AddChildren(
+		ts,
+		algorithm("DigestMethod", digest),
+		dom.ElemC("DigestValue", NS_DSIG, base64.StdEncoding.EncodeToString(h.Sum(nil)))), nil
+}
+
+// uri returns the reference URI for e.
+func (s *Signer) uri(e *dom.Element, enveloped bool) (string, error) {
+	if a := e.GetAttr(s.idAttribute(), "", "*"); len(a) > 0 && a[0].Value != "" {
+		return "#" + a[0].Value, nil
+	}
+	if enveloped && e.Parent() == nil {
+		return "", nil
+	}
+	return "", fmt.Errorf("dsig: %s has no %s attribute to refer to it by", e.Path(), s.idAttribute())
+}
+
+// SignEnveloped signs e with an enveloped signature, adds the Signature
+// element as the last child of e, and returns it.  e is referred to by
+// its ID attribute, or as the whole document if it has none and is the
+// root of its tree.  The signature stays valid if it is moved elsewhere
+// inside e.
+func (s *Signer) SignEnveloped(e *dom.Element) (*dom.Element, error) {
+	uri, err := s.uri(e, true)
+	if err != nil {
+		return nil, err
+	}
+	digest, method, err := s.methods()
+	if err != nil {
+		return nil, err
+	}
+	ref, err := s.reference(e, uri, []string{EnvelopedSignature, s.canonicalization()}, digest)
+	if err != nil {
+		return nil, err
+	}
+	sig := s.signature(method, ref)
+	e.AddChild(sig)
+	if err := s.finish(sig); err != nil {
+		e.RemoveChild(sig)
+		return nil, err
+	}
+	return sig, nil
+}
+
+// Sign makes a signature over refs, which must all have ID attributes,
+// and returns the Signature element for the caller to put in the
+// document.  It must not end up inside any of refs, since that would
+// change what they digest to; use SignEnveloped for that.
+func (s *Signer) Sign(refs ...*dom.Element) (*dom.Element, error) {
+	if len(refs) == 0 {
+		return nil, errors.New("dsig: nothing to sign")
+	}
+	digest, method, err := s.methods()
+	if err != nil {
+		return nil, err
+	}
+	res := []*dom.Element{}
+	for _, e := range refs {
+		uri, err := s.uri(e, false)
+		if err != nil {
+			return nil, err
+		}
+		ref, err := s.reference(e, uri, []string{s.canonicalization()}, digest)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, ref)
+	}
+	sig := s.signature(method, res...)
+	if err := s.finish(sig); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+func (s *Signer) signature(method string, refs ...*dom.Element) *dom.Element {
+	info := ds("SignedInfo").AddChildren(
+		algorithm("CanonicalizationMethod", s.canonicalization()),
+		algorithm("SignatureMethod", method))
+	info.AddChildren(refs...)
+	sig := ds("Signature").Attr("ds", "xmlns", NS_DSIG).AddChildren(info, ds("SignatureValue"))
+	if len(s.Certificates) > 0 {
+		data := ds("X509Data")
+		for _, c := range s.Certificates {
+			data.AddChild(dom.ElemC("X509Certificate", NS_DSIG, base64.StdEncoding.EncodeToString(c.Raw)))
+		}
+		sig.AddChild(ds("KeyInfo").AddChild(data))
+	}
+	return sig
+}
+
+// finish fills in the SignatureValue of sig, once it is where it will be
+// in the document.
+func (s *Signer) finish(sig *dom.Element) error {
+	info, value := child(sig, "SignedInfo"), child(sig, "SignatureValue")
+	opts, err := c14n.ForAlgorithm(s.canonicalization())
+	if err != nil {
+		return fmt.Errorf("dsig: %v", err)
+	}
+	data, err := c14n.Canonicalize(info, opts)
+	if err != nil {
+		return err
+	}
+	h := s.hash().New()
+	h.Write(data)
+	raw, err := s.Key.Sign(rand.Reader, h.Sum(nil), s.hash())
+	if err != nil {
+		return fmt.Errorf("dsig: signing failed: %v", err)
+	}
+	if pub, ok := s.Key.Public().(*ecdsa.PublicKey); ok {
+		// XML Signature wants r and s concatenated, not ASN.1.
+		var rs struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(raw, &rs); err != nil {
+			return fmt.Errorf("dsig: bad ECDSA signature: %v", err)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		raw = make([]byte, 2*size)
+		rs.R.FillBytes(raw[:size])
+		rs.S.FillBytes(raw[size:])
+	}
+	value.Content = []byte(base64.StdEncoding.EncodeToString(raw))
+	return nil
+}
+
+// child returns the first child of e in the signature namespace called
+// name, or nil.
+func child(e *dom.Element, name string) *dom.Element {
+	for _, c := range e.Children() {
+		if c.Name.Space == NS_DSIG && c.Name.Local == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func children(e *dom.Element, name string) []*dom.Element {
+	res := []*dom.Element{}
+	for _, c := range e.Children() {
+		if c.Name.Space == NS_DSIG && c.Name.Local == name {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+func attr(e *dom.Element, name string) string {
+	if a := e.GetAttr(name, "", "*"); len(a) > 0 {
+		return a[0].Value
+	}
+	return ""
+}
+
+// transform applies transforms to e and returns the octets to digest.
+// prefixes holds the InclusiveNamespaces PrefixList of each transform,
+// and sig is the Signature the enveloped signature transform removes,
+// which is nil while signing since it has not been added yet.
+func transform(e *dom.Element, transforms []string, prefixes [][]string, sig *dom.Element) ([]byte, error) {
+	var opts *c14n.Options
+	enveloped := false
+	for i, t := range transforms {
+		if t == EnvelopedSignature {
+			enveloped = true
+			continue
+		}
+		if opts != nil {
+			return nil, errors.New("dsig: more than one canonicalization transform")
+		}
+		var err error
+		if opts, err = c14n.ForAlgorithm(t); err != nil {
+			return nil, fmt.Errorf("dsig: unsupported transform %s", t)
+		}
+		if i < len(prefixes) {
+			opts.InclusivePrefixes = prefixes[i]
+		}
+	}
+	if opts == nil {
+		// Node sets are turned into octets with Canonical XML 1.0.
+		opts = &c14n.Options{}
+	}
+	if enveloped && sig != nil {
+		opts.Exclude = func(n *dom.Element) bool { return n == sig }
+	}
+	return c14n.Canonicalize(e, opts)
+}
+
+// Verifier checks signatures.
+type Verifier struct {
+	// Keys are the public keys trusted to sign, *rsa.PublicKey or
+	// *ecdsa.PublicKey.
+	Keys []crypto.PublicKey
+	// Certificates are trusted to sign with their public keys.  Only the
+	// keys are used: expiry and chains are the caller's business.
+	Certificates []*x509.Certificate
+	// IDAttributes are the names of the unqualified attributes that
+	// identify elements.  They default to ID, Id and id.
+	IDAttributes []string
+}
+
+func (v *Verifier) keys() []crypto.PublicKey {
+	res := append([]crypto.PublicKey{}, v.Keys...)
+	for _, c := range v.Certificates {
+		res = append(res, c.PublicKey)
+	}
+	return res
+}
+
+func (v *Verifier) idAttributes() []string {
+	if len(v.IDAttributes) == 0 {
+		return []string{"ID", "Id", "id"}
+	}
+	return v.IDAttributes
+}
+
+// lookup finds the element uri refers to in the tree sig is part of.
+func (v *Verifier) lookup(sig *dom.Element, uri string) (*dom.Element, error) {
+	root := sig
+	for root.Parent() != nil {
+		root = root.Parent()
+	}
+	if uri == "" {
+		return root, nil
+	}
+	if !strings.HasPrefix(uri, "#") || len(uri) == 1 {
+		return nil, fmt.Errorf("dsig: unsupported reference URI %q", uri)
+	}
+	id := uri[1:]
+	var res *dom.Element
+	for _, e := range root.All() {
+		for _, name := range v.idAttributes() {
+			if attr(e, name) != id {
+				continue
+			}
+			if res != nil && res != e {
+				return nil, fmt.Errorf("dsig: ID %q is used more than once", id)
+			}
+			res = e
+		}
+	}
+	if res == nil {
+		return nil, fmt.Errorf("dsig: no element has ID %q", id)
+	}
+	return res, nil
+}
+
+func decode(e *dom.Element) ([]byte, error) {
+	s := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(" \t\r\n", r) {
+			return -1
+		}
+		return r
+	}, string(e.Content))
+	return base64.StdEncoding.DecodeString(s)
+}
+
+func prefixList(e *dom.Element) []string {
+	for _, c := range e.Children() {
+		if c.Name.Space == c14n.Exclusive && c.Name.Local == "InclusiveNamespaces" {
+			return strings.Fields(attr(c, "PrefixList"))
+		}
+	}
+	return nil
+}
+
+// Verify checks sig, a Signature element, and returns the elements its
+// references point to, in order.  It fails unless the signature was made
+// by one of the trusted keys and every reference digests correctly.
+func (v *Verifier) Verify(sig *dom.Element) ([]*dom.Element, error) {
+	if sig.Name.Space != NS_DSIG || sig.Name.Local != "Signature" {
+		return nil, fmt.Errorf("dsig: %s is not a Signature", sig.Path())
+	}
+	info, value := child(sig, "SignedInfo"), child(sig, "SignatureValue")
+	if info == nil || value == nil {
+		return nil, errors.New("dsig: Signature needs SignedInfo and SignatureValue")
+	}
+	cm := child(info, "CanonicalizationMethod")
+	sm := child(info, "SignatureMethod")
+	if cm == nil || sm == nil {
+		return nil, errors.New("dsig: SignedInfo needs CanonicalizationMethod and SignatureMethod")
+	}
+	opts, err := c14n.ForAlgorithm(attr(cm, "Algorithm"))
+	if err != nil {
+		return nil, fmt.Errorf("dsig: %v", err)
+	}
+	opts.InclusivePrefixes = prefixList(cm)
+	method, ok := signatureMethods[attr(sm, "Algorithm")]
+	if !ok {
+		return nil, fmt.Errorf("dsig: unsupported signature method %s", attr(sm, "Algorithm"))
+	}
+	data, err := c14n.Canonicalize(info, opts)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := decode(value)
+	if err != nil {
+		return nil, fmt.Errorf("dsig: bad SignatureValue: %v", err)
+	}
+	h := method.hash.New()
+	h.Write(data)
+	if !v.check(method, h.Sum(nil), raw) {
+		return nil, errors.New("dsig: signature was not made by a trusted key")
+	}
+	refs := children(info, "Reference")
+	if len(refs) == 0 {
+		return nil, errors.New("dsig: SignedInfo has no references")
+	}
+	res := []*dom.Element{}
+	for _, ref := range refs {
+		e, err := v.reference(sig, ref)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, e)
+	}
+	return res, nil
+}
+
+func (v *Verifier) reference(sig, ref *dom.Element) (*dom.Element, error) {
+	uri := attr(ref, "URI")
+	e, err := v.lookup(sig, uri)
+	if err != nil {
+		return nil, err
+	}
+	transforms, prefixes := []string{}, [][]string{}
+	if ts := child(ref, "Transforms"); ts != nil {
+		for _, t := range children(ts, "Transform") {
+			transforms = append(transforms, attr(t, "Algorithm"))
+			prefixes = append(prefixes, prefixList(t))
+		}
+	}
+	dm, dv := child(ref, "DigestMethod"), child(ref, "DigestValue")
+	if dm == nil || dv == nil {
+		return nil, fmt.Errorf("dsig: reference %q needs DigestMethod and DigestValue", uri)
+	}
+	hash, ok := digestMethods[attr(dm, "Algorithm")]
+	if !ok {
+		return nil, fmt.Errorf("dsig: unsupported digest method %s", attr(dm, "Algorithm"))
+	}
+	envelops := false
+	for _, t := range transforms {
+		envelops = envelops || t == EnvelopedSignature
+	}
+	if envelops {
+		inside := false
+		for p := sig; p != nil; p = p.Parent() {
+			inside = inside || p == e
+		}
+		if !inside {
+			return nil, fmt.Errorf("dsig: reference %q is not to an element enclosing the signature", uri)
+		}
+	}
+	data, err := transform(e, transforms, prefixes, sig)
+	if err != nil {
+		return nil, err
+	}
+	want, err := decode(dv)
+	if err != nil {
+		return nil, fmt.Errorf("dsig: bad DigestValue: %v", err)
+	}
+	h := hash.New()
+	h.Write(data)
+	if !bytes.Equal(h.Sum(nil), want) {
+		return nil, fmt.Errorf("dsig: digest of reference %q does not match", uri)
+	}
+	return e, nil
+}
+
+func (v *Verifier) check(method signatureMethod, digest, raw []byte) bool {
+	for _, key := range v.keys() {
+		switch key := key.(type) {
+		case *rsa.PublicKey:
+			if !method.ecdsa && rsa.VerifyPKCS1v15(key, method.hash, digest, raw) == nil {
+				return true
+			}
+		case *ecdsa.PublicKey:
+			if method.ecdsa && len(raw)%2 == 0 {
+				r := new(big.Int).SetBytes(raw[:len(raw)/2])
+				s := new(big.Int).SetBytes(raw[len(raw)/2:])
+				if ecdsa.Verify(key, digest, r, s) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
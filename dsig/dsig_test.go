@@ -0,0 +1,157 @@
+package dsig
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VictorLowther/simplexml/c14n"
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+const assertion = `<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="r1">
+  <saml:Issuer>https://idp.example.com</saml:Issuer>
+  <saml:Assertion ID="a1">
+    <saml:Issuer>https://idp.example.com</saml:Issuer>
+    <saml:Subject><saml:NameID>alice@example.com</saml:NameID></saml:Subject>
+  </saml:Assertion>
+</samlp:Response>`
+
+var (
+	rsaKey, _   = rsa.GenerateKey(rand.Reader, 2048)
+	ecdsaKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+)
+
+func parse(t *testing.T, src string) *dom.Document {
+	doc, err := dom.Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// roundTrip writes doc out and parses it back, like sending it.
+func roundTrip(t *testing.T, doc *dom.Document) *dom.Document {
+	return parse(t, doc.String())
+}
+
+func signature(e *dom.Element) *dom.Element {
+	for _, c := range e.All() {
+		if c.Name.Space == NS_DSIG && c.Name.Local == "Signature" {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestEnveloped(t *testing.T) {
+	for _, signer := range []*Signer{
+		{Key: rsaKey},
+		{Key: rsaKey, Hash: crypto.SHA512, Canonicalization: c14n.Inclusive},
+		{Key: ecdsaKey},
+		{Key: ecdsaKey, Hash: crypto.SHA512},
+	} {
+		doc := parse(t, assertion)
+		a := doc.Root().Children()[1]
+		if _, err := signer.SignEnveloped(a); err != nil {
+			t.Fatalf("Signing failed: %v", err)
+		}
+		if signer.Canonicalization == "" {
+			// Exclusive signatures survive being encoded and parsed again.
+			doc = roundTrip(t, doc)
+			a = doc.Root().Children()[1]
+		}
+		v := &Verifier{Keys: []crypto.PublicKey{signer.Key.Public()}}
+		signed, err := v.Verify(signature(a))
+		if err != nil || len(signed) != 1 || signed[0] != a {
+			t.Fatalf("Verify gave %v, %v", signed, err)
+		}
+		// Tampering with the signed content breaks the digest.
+		a.Children()[1].Children()[0].Content = []byte("mallory@example.com")
+		if _, err := v.Verify(signature(a)); err == nil || !strings.Contains(err.Error(), "digest") {
+			t.Errorf("Expected a digest mismatch, got %v", err)
+		}
+	}
+}
+
+func TestCertificates(t *testing.T) {
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &ecdsaKey.PublicKey, ecdsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	doc := parse(t, assertion)
+	sig, err := (&Signer{Key: ecdsaKey, Certificates: []*x509.Certificate{cert}}).SignEnveloped(doc.Root())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if child(sig, "KeyInfo") == nil {
+		t.Errorf("Expected a KeyInfo in %v", sig)
+	}
+	doc = roundTrip(t, doc)
+	if _, err := (&Verifier{Certificates: []*x509.Certificate{cert}}).Verify(signature(doc.Root())); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+	// The certificate in KeyInfo is not trusted by itself.
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if _, err := (&Verifier{Keys: []crypto.PublicKey{&other.PublicKey}}).Verify(signature(doc.Root())); err == nil {
+		t.Errorf("Expected an untrusted key to be rejected")
+	}
+}
+
+func TestDetached(t *testing.T) {
+	doc := parse(t, assertion)
+	root := doc.Root()
+	a := root.Children()[1]
+	sig, err := (&Signer{Key: rsaKey}).Sign(a, root.Children()[0].Attr("ID", "", "i1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root.AddChild(sig)
+	doc = roundTrip(t, doc)
+	signed, err := (&Verifier{Keys: []crypto.PublicKey{&rsaKey.PublicKey}}).Verify(signature(doc.Root()))
+	if err != nil || len(signed) != 2 || signed[0].Name.Local != "Assertion" || signed[1].Name.Local != "Issuer" {
+		t.Fatalf("Verify gave %v, %v", signed, err)
+	}
+	if _, err := (&Signer{Key: rsaKey}).Sign(dom.Elem("x", "")); err == nil {
+		t.Errorf("Expected an element without an ID to be rejected")
+	}
+}
+
+func TestWrapping(t *testing.T) {
+	doc := parse(t, assertion)
+	a := doc.Root().Children()[1]
+	if _, err := (&Signer{Key: rsaKey}).SignEnveloped(a); err != nil {
+		t.Fatal(err)
+	}
+	// An attacker adds a second element with the same ID, hoping the
+	// verifier checks one and the application reads the other.
+	evil := parse(t, `<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="a1"/>`).Root()
+	doc.Root().AddChild(evil)
+	v := &Verifier{Keys: []crypto.PublicKey{&rsaKey.PublicKey}}
+	if _, err := v.Verify(signature(a)); err == nil || !strings.Contains(err.Error(), "more than once") {
+		t.Errorf("Expected a duplicate ID error, got %v", err)
+	}
+	doc.Root().RemoveChild(evil)
+	// Moving the signature out of what it envelops is not allowed either.
+	sig := signature(a)
+	a.RemoveChild(sig)
+	doc.Root().AddChild(sig)
+	if _, err := v.Verify(sig); err == nil {
+		t.Errorf("Expected an enveloped signature outside its element to be rejected")
+	}
+}
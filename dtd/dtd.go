@@ -0,0 +1,424 @@
+// Package dtd validates simplexml/dom trees against XML 1.0 Document Type
+// Definitions.
+//
+// A DTD can come from the internal subset of a document's DOCTYPE
+// declaration, from a separately supplied DTD file, or both, and
+// FromDocumentWith reads the external subset the DOCTYPE names through a
+// dom.Resolver.  Element
+// content models, attribute declarations (types, #REQUIRED, #FIXED and
+// enumerations) and ID/IDREF constraints are checked.  Internal parameter
+// entities and conditional sections are expanded while parsing; external
+// parameter entities are not supported.  Besides validating, a DTD can
+// fill in the default values of attributes it declares with
+// ApplyDefaults, and say which attributes are tokenized with Tokenized.
+//
+// Names in a DTD are matched against the prefixed names elements and
+// attributes had in their source document.  Namespace declarations are
+// never checked against attribute declarations.
+//
+// For some basic usage examples, see dtd_test.go
+package dtd
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+type modelKind int
+
+const (
+	emptyModel modelKind = iota
+	anyModel
+	mixedModel
+	nameModel
+	seqModel
+	choiceModel
+)
+
+// model is a node in an element content model.  max < 0 means unbounded.
+type model struct {
+	kind     modelKind
+	name     string
+	items    []*model
+	min, max int
+}
+
+func (m *model) String() string {
+	suffix := ""
+	switch {
+	case m.min == 0 && m.max == 1:
+		suffix = "?"
+	case m.min == 0:
+		suffix = "*"
+	case m.max < 0:
+		suffix = "+"
+	}
+	switch m.kind {
+	case emptyModel:
+		return "EMPTY"
+	case anyModel:
+		return "ANY"
+	case nameModel:
+		return m.name + suffix
+	case mixedModel:
+		if len(m.items) == 0 {
+			return "(#PCDATA)"
+		}
+		names := []string{"#PCDATA"}
+		for _, item := range m.items {
+			names = append(names, item.name)
+		}
+		return "(" + strings.Join(names, "|") + ")*"
+	}
+	sep := ","
+	if m.kind == choiceModel {
+		sep = "|"
+	}
+	items := []string{}
+	for _, item := range m.items {
+		items = append(items, item.String())
+	}
+	return "(" + strings.Join(items, sep) + ")" + suffix
+}
+
+type attrType int
+
+const (
+	cdataAttr attrType = iota
+	idAttr
+	idrefAttr
+	idrefsAttr
+	entityAttr
+	entitiesAttr
+	nmtokenAttr
+	nmtokensAttr
+	notationAttr
+	enumAttr
+)
+
+var attrTypes = map[string]attrType{
+	"CDATA":    cdataAttr,
+	"ID":       idAttr,
+	"IDREF":    idrefAttr,
+	"IDREFS":   idrefsAttr,
+	"ENTITY":   entityAttr,
+	"ENTITIES": entitiesAttr,
+	"NMTOKEN":  nmtokenAttr,
+	"NMTOKENS": nmtokensAttr,
+}
+
+type attrDecl struct {
+	name     string
+	typ      attrType
+	values   []string
+	required bool
+	fixed    bool
+	def      *string
+}
+
+// DTD is a parsed Document Type Definition.
+type DTD struct {
+	// Name is the name of the root element given by the DOCTYPE
+	// declaration the DTD was read from, if any.
+	Name string
+	// PublicID and SystemID are the external identifiers given by the
+	// DOCTYPE declaration, if any.
+	PublicID, SystemID string
+	elements           map[string]*model
+	attrs              map[string][]*attrDecl
+	unparsed           map[string]bool
+	notations          map[string]bool
+}
+
+func newDTD() *DTD {
+	return &DTD{
+		elements:  map[string]*model{},
+		attrs:     map[string][]*attrDecl{},
+		unparsed:  map[string]bool{},
+		notations: map[string]bool{},
+	}
+}
+
+// Parse parses an external DTD subset from r.
+func Parse(r io.Reader) (*DTD, error) {
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := newParser(string(buf), newDTD())
+	if err := p.subset(false); err != nil {
+		return nil, err
+	}
+	return p.d, nil
+}
+
+// FromDocument parses the DOCTYPE declaration of doc, including its
+// internal subset.  The external subset named by SystemID is not read.
+func FromDocument(doc *dom.Document) (*DTD, error) {
+	if doc.Doctype() == "" {
+		return nil, errors.New("dtd: document has no DOCTYPE declaration")
+	}
+	p := newParser(doc.Doctype(), newDTD())
+	if err := p.doctype(); err != nil {
+		return nil, err
+	}
+	return p.d, nil
+}
+
+// FromDocumentWith is like FromDocument, but also reads the external
+// subset named by SystemID, if there is one, through r, and merges the
+// two.  SystemID is resolved against the base URI of the root of doc.
+func FromDocumentWith(doc *dom.Document, r dom.Resolver) (*DTD, error) {
+	d, err := FromDocument(doc)
+	if err != nil || d.SystemID == "" {
+		return d, err
+	}
+	location := d.SystemID
+	if root := doc.Root(); root != nil {
+		if location, err = root.ResolveURI(location); err != nil {
+			return nil, fmt.Errorf("dtd: %v", err)
+		}
+	}
+	in, err := r.Resolve(location)
+	if err != nil {
+		return nil, fmt.Errorf("dtd: reading external subset %s: %w", location, err)
+	}
+	defer in.Close()
+	external, err := Parse(in)
+	if err != nil {
+		return nil, fmt.Errorf("dtd: external subset %s: %s", location, strings.TrimPrefix(err.Error(), "dtd: "))
+	}
+	return d.Merge(external), nil
+}
+
+// Merge adds the declarations in other to d, and returns d.  Where both
+// declare the same element or attribute, the declaration in d is kept, so
+// an internal subset should be merged with the external subset and not
+// the other way around.
+func (d *DTD) Merge(other *DTD) *DTD {
+	if d.Name == "" {
+		d.Name = other.Name
+	}
+	for name, m := range other.elements {
+		if _, ok := d.elements[name]; !ok {
+			d.elements[name] = m
+		}
+	}
+	for elem, attrs := range other.attrs {
+		for _, a := range attrs {
+			d.addAttr(elem, a)
+		}
+	}
+	for name := range other.unparsed {
+		d.unparsed[name] = true
+	}
+	for name := range other.notations {
+		d.notations[name] = true
+	}
+	return d
+}
+
+// addAttr adds an attribute declaration for elem, unless there already is
+// one for the same attribute.
+func (d *DTD) addAttr(elem string, a *attrDecl) {
+	for _, old := range d.attrs[elem] {
+		if old.name == a.name {
+			return
+		}
+	}
+	d.attrs[elem] = append(d.attrs[elem], a)
+}
+
+// RefTypes returns the dom.RefTypes for the ID, IDREF and IDREFS
+// attributes d declares, for use with dom.ResolveRefs.  xml:id
+// attributes are IDs too, whether they are declared or not.
+func (d *DTD) RefTypes() dom.RefTypes {
+	return func(e *dom.Element, a xml.Attr) dom.RefKind {
+		if isNamespaceDecl(a) {
+			return dom.NotRef
+		}
+		name := attrName(e, a)
+		for _, decl := range d.attrs[qname(e)] {
+			if decl.name != name {
+				continue
+			}
+			switch decl.typ {
+			case idAttr:
+				return dom.IDAttr
+			case idrefAttr:
+				return dom.IDRefAttr
+			case idrefsAttr:
+				return dom.IDRefsAttr
+			}
+		}
+		return dom.XMLIDs(e, a)
+	}
+}
+
+// Tokenized reports whether d declares a to be of a type other than
+// CDATA, whose value a validating parser would collapse the spaces in.
+// It can be passed to dom.Element.CollapseAttrs.
+func (d *DTD) Tokenized(e *dom.Element, a xml.Attr) bool {
+	if isNamespaceDecl(a) {
+		return false
+	}
+	name := attrName(e, a)
+	for _, decl := range d.attrs[qname(e)] {
+		if decl.name == name {
+			return decl.typ != cdataAttr
+		}
+	}
+	return false
+}
+
+// ApplyDefaults adds the attributes d gives default or #FIXED values for
+// to e and its descendants wherever they are missing, so that the tree
+// reads as it would coming out of a validating parser.  Default values
+// are normalized as values in the document would be.  Defaults for
+// namespace declarations are added as xmlns attributes, but do not
+// change the names of elements that were parsed without them.  Defaults
+// whose prefix is not bound where they would go are left out.
+// The return value is the number of attributes added.
+func (d *DTD) ApplyDefaults(e *dom.Element) int {
+	n := 0
+	for _, el := range e.All() {
+		decls := d.attrs[qname(el)]
+		if len(decls) == 0 {
+			continue
+		}
+		present := map[string]bool{}
+		for _, a := range el.Attributes {
+			switch {
+			case a.Name.Space == "xmlns":
+				present["xmlns:"+a.Name.Local] = true
+			case isNamespaceDecl(a):
+				present["xmlns"] = true
+			default:
+				present[attrName(el, a)] = true
+			}
+		}
+		// Namespace declarations go first, so that the prefixes they
+		// bind can be used by the other defaults.
+		for _, nsDecls := range []bool{true, false} {
+			for _, decl := range decls {
+				isNS := decl.name == "xmlns" || strings.HasPrefix(decl.name, "xmlns:")
+				if decl.def == nil || present[decl.name] || isNS != nsDecls {
+					continue
+				}
+				name, ok := defaultName(el, decl.name)
+				if !ok {
+					continue
+				}
+				el.AddAttr(xml.Attr{Name: name, Value: decl.defaultValue()})
+				present[decl.name] = true
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// defaultName resolves the name of an attribute declaration where it is
+// to be added to e.
+func defaultName(e *dom.Element, name string) (xml.Name, bool) {
+	prefix, local, ok := strings.Cut(name, ":")
+	switch {
+	case !ok:
+		return xml.Name{Local: name}, true
+	case prefix == "xmlns":
+		return xml.Name{Space: prefix, Local: local}, true
+	}
+	res, err := dom.ParseQName(name, e)
+	return res, err == nil
+}
+
+// defaultValue returns the default value of a, decoded and normalized.
+func (a *attrDecl) defaultValue() string {
+	v := strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ", "\t", " ").Replace(*a.def)
+	var tag struct {
+		V string `xml:"v,attr"`
+	}
+	// Predefined entities and character references are decoded, and
+	// anything else is left as it was written.
+	if err := xml.Unmarshal([]byte(`<a v="`+strings.ReplaceAll(v, `"`, "&quot;")+`"/>`), &tag); err == nil {
+		v = tag.V
+	}
+	if a.typ != cdataAttr {
+		v = strings.Join(strings.FieldsFunc(v, func(r rune) bool { return r == ' ' }), " ")
+	}
+	return v
+}
+
+// Violation describes one way in which a tree does not conform to a DTD.
+type Violation struct {
+	// Element is the element the violation was found on.
+	Element *dom.Element
+	// Pos is where Element was found in its source document, if it was
+	// parsed.
+	Pos dom.Position
+	// Path is a /-separated path to Element from the top of its tree.
+	Path    string
+	Message string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%v: %s: %s", v.Pos, v.Path, v.Message)
+}
+
+// Error makes a Violation usable as an error.
+func (v Violation) Error() string {
+	return v.String()
+}
+
+// Validate validates doc against d.  If d came from a DOCTYPE
+// declaration, the root element must have the name it gives.  ID and
+// IDREF violations are reported after all the others.
+func (d *DTD) Validate(doc *dom.Document) []Violation {
+	root := doc.Root()
+	if root == nil {
+		return []Violation{{Path: "/", Message: "document has no root element"}}
+	}
+	v := &validator{d: d, ids: map[string]*dom.Element{}}
+	if d.Name != "" && qname(root) != d.Name {
+		v.report(root, "root element is %s, but the DOCTYPE declares %s", qname(root), d.Name)
+	}
+	v.element(root)
+	v.checkRefs()
+	return v.violations
+}
+
+// ValidateElement validates the subtree rooted at e against d.
+func (d *DTD) ValidateElement(e *dom.Element) []Violation {
+	v := &validator{d: d, ids: map[string]*dom.Element{}}
+	v.element(e)
+	v.checkRefs()
+	return v.violations
+}
+
+// Validate validates doc against the DTD in its DOCTYPE declaration,
+// merged with external if it is not nil.  If doc has no DOCTYPE
+// declaration, external is used by itself.
+func Validate(doc *dom.Document, external *DTD) ([]Violation, error) {
+	var d *DTD
+	switch {
+	case doc.Doctype() != "":
+		internal, err := FromDocument(doc)
+		if err != nil {
+			return nil, err
+		}
+		d = internal
+		if external != nil {
+			d.Merge(external)
+		}
+	case external != nil:
+		d = external
+	default:
+		return nil, errors.New("dtd: document has no DOCTYPE declaration and no DTD was supplied")
+	}
+	return d.Validate(doc), nil
+}
@@ -0,0 +1,297 @@
+package dtd
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+var testDoc = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE library SYSTEM "library.dtd" [
+ <!ENTITY % people "author|editor">
+ <!ELEMENT library (book+, note?)>
+ <!ELEMENT book (title, (%people;)*, (isbn | ref))>
+ <!ATTLIST book
+   id     ID                #REQUIRED
+   lang   NMTOKEN           #IMPLIED
+   status (draft|published) "published"
+   format CDATA             #FIXED "print">
+ <!ELEMENT title (#PCDATA)>
+ <!ELEMENT author (#PCDATA)>
+ <!ELEMENT editor (#PCDATA)>
+ <!ELEMENT isbn (#PCDATA)>
+ <!ELEMENT ref EMPTY>
+ <!ATTLIST ref to IDREF #REQUIRED>
+ <!ELEMENT note (#PCDATA | b | i)*>
+ <!ELEMENT b (#PCDATA)>
+ <!ELEMENT i (#PCDATA)>
+]>
+<library>
+ <book id="b1" lang="en">
+  <title>Go</title>
+  <author>A</author>
+  <editor>E</editor>
+  <isbn>123</isbn>
+ </book>
+ <book id="b2" status="draft" format="print">
+  <title>Go Again</title>
+  <ref to="b1"/>
+ </book>
+ <note>See <b>both</b></note>
+</library>
+`
+
+func parse(t *testing.T, src string) *dom.Document {
+	doc, err := dom.Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("Cannot parse test document: %v", err)
+	}
+	return doc
+}
+
+func TestFromDocument(t *testing.T) {
+	doc := parse(t, testDoc)
+	d, err := FromDocument(doc)
+	if err != nil {
+		t.Fatalf("Cannot read DTD: %v", err)
+	}
+	if d.Name != "library" || d.SystemID != "library.dtd" {
+		t.Errorf("Bad DOCTYPE name or system id: %q %q", d.Name, d.SystemID)
+	}
+	if got := d.elements["book"].String(); got != "(title,(author|editor)*,(isbn|ref))" {
+		t.Errorf("Unexpected content model for book: %s", got)
+	}
+	if vs := d.Validate(doc); len(vs) != 0 {
+		t.Errorf("Expected valid document, got %v", vs)
+	}
+}
+
+func TestViolations(t *testing.T) {
+	tests := []struct {
+		from, to string
+		path     string
+		message  string
+	}{
+		{`id="b2" `, ``, "/library/book[2]", "missing required attribute id"},
+		{`lang="en"`, `lang="e n"`, "/library/book[1]", "not a valid NMTOKEN"},
+		{`status="draft"`, `status="lost"`, "/library/book[2]", "must be one of draft, published"},
+		{`format="print"`, `format="ebook"`, "/library/book[2]", "must be \"print\""},
+		{`<book id="b1" lang="en">`, `<book id="b1" colour="red">`, "/library/book[1]", "attribute colour is not declared"},
+		{`id="b2"`, `id="b1"`, "/library/book[2]", "ID \"b1\" is already used by /library/book[1]"},
+		{`<ref to="b1"/>`, `<ref to="b3"/>`, "/library/book[2]/ref", "IDREF \"b3\" does not match any ID"},
+		{`<isbn>123</isbn>`, ``, "/library/book[1]", "missing element, expected author, editor, isbn, ref"},
+		{`<title>Go</title>`, ``, "/library/book[1]/author", "unexpected element author, expected title"},
+		{`<isbn>123</isbn>`, `<isbn>123</isbn><isbn>4</isbn>`, "/library/book[1]/isbn[2]", "unexpected element isbn"},
+		{`<ref to="b1"/>`, `<ref to="b1">x</ref>`, "/library/book[2]/ref", "element ref must be empty"},
+		{`<b>both</b>`, `<title>both</title>`, "/library/note/title", "element title is not allowed in note"},
+		{`<title>Go</title>`, `<title>Go<b/></title>`, "/library/book[1]/title/b", "element b is not allowed in title"},
+		{`<library>`, `<libary>`, "/libary", "root element is libary"},
+	}
+	for _, test := range tests {
+		src := strings.Replace(testDoc, test.from, test.to, 1)
+		if test.from == "<library>" {
+			src = strings.Replace(src, "</library>", "</libary>", 1)
+		}
+		vs, err := Validate(parse(t, src), nil)
+		if err != nil {
+			t.Errorf("Replacing %q with %q: %v", test.from, test.to, err)
+			continue
+		}
+		if test.from == "<library>" {
+			if len(vs) != 2 || vs[1].Message != "element libary is not declared" {
+				t.Errorf("Expected libary not to be declared, got %v", vs)
+				continue
+			}
+			vs = vs[:1]
+		}
+		if len(vs) != 1 {
+			t.Errorf("Replacing %q with %q: expected 1 violation, got %v", test.from, test.to, vs)
+			continue
+		}
+		if vs[0].Path != test.path || !strings.Contains(vs[0].Message, test.message) {
+			t.Errorf("Replacing %q with %q: expected %s: %s, got %s: %s",
+				test.from, test.to, test.path, test.message, vs[0].Path, vs[0].Message)
+		}
+	}
+}
+
+var externalDTD = `<!-- an external subset -->
+<!ENTITY % inline "b|i">
+<![INCLUDE[
+<!ELEMENT p:doc (p:para)+>
+<!ATTLIST p:doc xml:lang NMTOKEN #IMPLIED>
+]]>
+<![IGNORE[
+<!ELEMENT p:doc EMPTY>
+<![ INCLUDE [ <!ELEMENT p:para EMPTY> ]]>
+]]>
+<!ELEMENT p:para (#PCDATA|%inline;)*>
+<!ATTLIST p:para kind CDATA "normal">
+<!ELEMENT b (#PCDATA)>
+<!ELEMENT i (#PCDATA)>
+`
+
+func TestExternal(t *testing.T) {
+	ext, err := Parse(strings.NewReader(externalDTD))
+	if err != nil {
+		t.Fatalf("Cannot parse external DTD: %v", err)
+	}
+	doc := parse(t, `<p:doc xmlns:p="urn:x" xml:lang="en"><p:para>a <i>b</i></p:para></p:doc>`)
+	vs, err := Validate(doc, ext)
+	if err != nil {
+		t.Fatalf("Cannot validate: %v", err)
+	}
+	if len(vs) != 0 {
+		t.Errorf("Expected valid document, got %v", vs)
+	}
+	// The internal subset overrides the external one.
+	doc = parse(t, `<!DOCTYPE p:doc [<!ATTLIST p:para kind (a|b) #REQUIRED>]><p:doc xmlns:p="urn:x"><p:para/></p:doc>`)
+	vs, err = Validate(doc, ext)
+	if err != nil {
+		t.Fatalf("Cannot validate: %v", err)
+	}
+	if len(vs) != 1 || !strings.Contains(vs[0].Message, "missing required attribute kind") {
+		t.Errorf("Expected the internal attribute declaration to win, got %v", vs)
+	}
+	if _, err := Validate(parse(t, `<doc/>`), nil); err == nil {
+		t.Error("Expected validation without any DTD to fail")
+	}
+}
+
+func TestFromDocumentWith(t *testing.T) {
+	opened := ""
+	r := dom.ResolverFunc(func(uri string) (io.ReadCloser, error) {
+		opened = uri
+		if uri != "dtds/para.dtd" {
+			return nil, os.ErrNotExist
+		}
+		return io.NopCloser(strings.NewReader(externalDTD)), nil
+	})
+	src := `<!DOCTYPE p:doc SYSTEM "../dtds/para.dtd" [<!ATTLIST p:para kind (a|b) #REQUIRED>]><p:doc xmlns:p="urn:x"><p:para kind="a"/></p:doc>`
+	doc, err := dom.Parse(strings.NewReader(src), dom.WithBaseURI("docs/doc.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := FromDocumentWith(doc, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vs := d.Validate(doc); len(vs) != 0 || opened != "dtds/para.dtd" {
+		t.Errorf("expected a valid document with %s read, got %v after reading %s", "dtds/para.dtd", vs, opened)
+	}
+	doc, _ = dom.Parse(strings.NewReader(`<!DOCTYPE doc SYSTEM "missing.dtd"><doc/>`))
+	if _, err := FromDocumentWith(doc, r); err == nil || !strings.Contains(err.Error(), "missing.dtd") {
+		t.Errorf("expected an error for the missing external subset, got %v", err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, src := range []string{
+		`<!ELEMENT a (b,c|d)>`,
+		`<!ELEMENT a (#PCDATA|b)>`,
+		`<!ELEMENT a>`,
+		`<!ATTLIST a b BOGUS #IMPLIED>`,
+		`<!ELEMENT a (%undeclared;)>`,
+		`<!ENTITY % ext SYSTEM "x.dtd"> %ext;`,
+		`<![INCLUDE[ <!ELEMENT a EMPTY>`,
+		`<!ELEMENT a EMPTY><!ELEMENT a ANY>`,
+	} {
+		if _, err := Parse(strings.NewReader(src)); err == nil {
+			t.Errorf("Expected %q to fail to parse", src)
+		}
+	}
+}
+
+func TestDoctypeRoundTrip(t *testing.T) {
+	doc := parse(t, testDoc)
+	again := parse(t, doc.String())
+	if again.Doctype() != doc.Doctype() {
+		t.Errorf("DOCTYPE did not survive encoding: %q", again.Doctype())
+	}
+}
+
+func TestRefTypes(t *testing.T) {
+	doc := parse(t, testDoc)
+	d, err := FromDocument(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs := dom.ResolveRefs(doc.Root(), d.RefTypes())
+	books := doc.Root().Children()
+	if len(refs.IDs) != 2 || refs.IDs["b1"] != books[0] || refs.IDs["b2"] != books[1] {
+		t.Errorf("Unexpected IDs %v", refs.IDs)
+	}
+	if len(refs.Refs) != 1 || refs.Refs[0].From != books[1].Children()[1] || refs.Refs[0].To != books[0] {
+		t.Errorf("Unexpected references %v", refs.Refs)
+	}
+}
+
+func TestTokenized(t *testing.T) {
+	src := strings.Replace(testDoc, `<ref to="b1"/>`, "<ref to=\"\n  b1 \"/>", 1)
+	src = strings.Replace(src, `format="print"`, `format=" print"`, 1)
+	doc, err := dom.Parse(strings.NewReader(src), dom.WithNormalizeAttrs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := FromDocument(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.Root().CollapseAttrs(d.Tokenized)
+	book := doc.Root().Children()[1]
+	if to := book.Children()[1].Attributes[0].Value; to != "b1" {
+		t.Errorf("Unexpected IDREF value %q", to)
+	}
+	if format := book.Attributes[2].Value; format != " print" {
+		t.Errorf("CDATA value should be left alone, got %q", format)
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	src := `<!DOCTYPE doc [
+<!ELEMENT doc (item*)>
+<!ELEMENT item EMPTY>
+<!ATTLIST doc xmlns:p CDATA #FIXED "urn:p" version CDATA "1&amp;2">
+<!ATTLIST item kind (a|b) " a " p:note CDATA 'x&#10;y' given CDATA "no" opt CDATA #IMPLIED>
+]>
+<doc><item given="yes"/><item kind="b"/></doc>`
+	doc := parse(t, src)
+	d, err := FromDocument(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := d.ApplyDefaults(doc.Root()); n != 6 {
+		t.Errorf("Expected 6 defaults, got %d", n)
+	}
+	root := doc.Root()
+	if v := root.GetAttr("p", "xmlns", "*"); len(v) != 1 || v[0].Value != "urn:p" {
+		t.Errorf("Missing namespace default: %v", root.Attributes)
+	}
+	if v := root.GetAttr("version", "", "*"); len(v) != 1 || v[0].Value != "1&2" {
+		t.Errorf("Bad version default: %v", root.Attributes)
+	}
+	items := root.Children()
+	for i, want := range []string{"a", "b"} {
+		if v := items[i].GetAttr("kind", "", "*"); len(v) != 1 || v[0].Value != want {
+			t.Errorf("item %d: bad kind: %v", i, items[i].Attributes)
+		}
+		if v := items[i].GetAttr("note", "urn:p", "*"); len(v) != 1 || v[0].Value != "x\ny" {
+			t.Errorf("item %d: bad note: %v", i, items[i].Attributes)
+		}
+		if len(items[i].GetAttr("opt", "", "*")) != 0 {
+			t.Errorf("item %d: #IMPLIED attribute should not be added", i)
+		}
+	}
+	if v := items[0].GetAttr("given", "", "*"); len(v) != 1 || v[0].Value != "yes" {
+		t.Errorf("Given value was overwritten: %v", items[0].Attributes)
+	}
+	if n := d.ApplyDefaults(doc.Root()); n != 0 {
+		t.Errorf("Defaults applied twice: %d", n)
+	}
+	if vs := d.Validate(doc); len(vs) != 0 {
+		t.Errorf("Unexpected violations %v", vs)
+	}
+}
@@ -0,0 +1,684 @@
+package dtd
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxExpansions limits how many parameter entity references will be
+// expanded, to keep entity bombs from running us out of memory.
+const maxExpansions = 10000
+
+type parser struct {
+	s          string
+	pos        int
+	d          *DTD
+	pe         map[string]string
+	externalPE map[string]bool
+	expansions int
+	condDepth  int
+}
+
+func newParser(s string, d *DTD) *parser {
+	return &parser{s: s, d: d, pe: map[string]string{}, externalPE: map[string]bool{}}
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	line := 1 + strings.Count(p.s[:p.pos], "\n")
+	return fmt.Errorf("dtd: near line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *parser) has(prefix string) bool {
+	return strings.HasPrefix(p.s[p.pos:], prefix)
+}
+
+func (p *parser) expect(prefix string) error {
+	if !p.has(prefix) {
+		return p.errorf("expected %q", prefix)
+	}
+	p.pos += len(prefix)
+	return nil
+}
+
+func isNameStart(r rune) bool {
+	return r == '_' || r == ':' || unicode.IsLetter(r)
+}
+
+func isNameChar(r rune) bool {
+	return isNameStart(r) || r == '-' || r == '.' || unicode.IsDigit(r) ||
+		unicode.Is(unicode.Mn, r) || r == 0xB7
+}
+
+// skipSpace skips whitespace, expanding any parameter entity references
+// it finds along the way.
+func (p *parser) skipSpace() error {
+	for !p.eof() {
+		switch c := p.s[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			p.pos++
+		case c == '%' && p.pos+1 < len(p.s):
+			r, _ := utf8.DecodeRuneInString(p.s[p.pos+1:])
+			if !isNameStart(r) {
+				return nil
+			}
+			if err := p.expandPE(); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// space is like skipSpace, but there must be some space to skip.
+func (p *parser) space() error {
+	start := p.pos
+	if err := p.skipSpace(); err != nil {
+		return err
+	}
+	if p.pos == start && !p.eof() {
+		return p.errorf("expected whitespace")
+	}
+	return nil
+}
+
+func (p *parser) expandPE() error {
+	start := p.pos
+	p.pos++
+	name, err := p.name()
+	if err != nil {
+		return err
+	}
+	if err := p.expect(";"); err != nil {
+		return err
+	}
+	if p.externalPE[name] {
+		return p.errorf("external parameter entity %%%s; is not supported", name)
+	}
+	value, ok := p.pe[name]
+	if !ok {
+		return p.errorf("parameter entity %%%s; is not declared", name)
+	}
+	if p.expansions++; p.expansions > maxExpansions {
+		return p.errorf("too many parameter entity expansions")
+	}
+	p.s = p.s[:start] + " " + value + " " + p.s[p.pos:]
+	p.pos = start
+	return nil
+}
+
+func (p *parser) name() (string, error) {
+	start := p.pos
+	for !p.eof() {
+		r, size := utf8.DecodeRuneInString(p.s[p.pos:])
+		if !isNameChar(r) || (p.pos == start && !isNameStart(r)) {
+			break
+		}
+		p.pos += size
+	}
+	if p.pos == start {
+		return "", p.errorf("expected a name")
+	}
+	return p.s[start:p.pos], nil
+}
+
+// nmtoken is like name, but the first character can be any name character.
+func (p *parser) nmtoken() (string, error) {
+	start := p.pos
+	for !p.eof() {
+		r, size := utf8.DecodeRuneInString(p.s[p.pos:])
+		if !isNameChar(r) {
+			break
+		}
+		p.pos += size
+	}
+	if p.pos == start {
+		return "", p.errorf("expected a name token")
+	}
+	return p.s[start:p.pos], nil
+}
+
+func (p *parser) literal() (string, error) {
+	if p.eof() || (p.s[p.pos] != '"' && p.s[p.pos] != '\'') {
+		return "", p.errorf("expected a quoted string")
+	}
+	q := p.s[p.pos]
+	end := strings.IndexByte(p.s[p.pos+1:], q)
+	if end < 0 {
+		return "", p.errorf("unterminated quoted string")
+	}
+	res := p.s[p.pos+1 : p.pos+1+end]
+	p.pos += end + 2
+	return res, nil
+}
+
+func (p *parser) skipPast(end string) error {
+	i := strings.Index(p.s[p.pos:], end)
+	if i < 0 {
+		return p.errorf("expected %q", end)
+	}
+	p.pos += i + len(end)
+	return nil
+}
+
+// doctype parses a DOCTYPE declaration, as returned by dom.Document.Doctype.
+func (p *parser) doctype() error {
+	if err := p.expect("DOCTYPE"); err != nil {
+		return err
+	}
+	if err := p.space(); err != nil {
+		return err
+	}
+	name, err := p.name()
+	if err != nil {
+		return err
+	}
+	p.d.Name = name
+	if err := p.skipSpace(); err != nil {
+		return err
+	}
+	if p.has("SYSTEM") || p.has("PUBLIC") {
+		if p.d.PublicID, p.d.SystemID, err = p.externalID(); err != nil {
+			return err
+		}
+		if err := p.skipSpace(); err != nil {
+			return err
+		}
+	}
+	if p.has("[") {
+		p.pos++
+		if err := p.subset(true); err != nil {
+			return err
+		}
+		if err := p.expect("]"); err != nil {
+			return err
+		}
+		if err := p.skipSpace(); err != nil {
+			return err
+		}
+	}
+	if !p.eof() {
+		return p.errorf("unexpected %q in DOCTYPE declaration", p.s[p.pos:])
+	}
+	return nil
+}
+
+func (p *parser) externalID() (public, system string, err error) {
+	switch {
+	case p.has("SYSTEM"):
+		p.pos += len("SYSTEM")
+		if err = p.space(); err != nil {
+			return
+		}
+		system, err = p.literal()
+	case p.has("PUBLIC"):
+		p.pos += len("PUBLIC")
+		if err = p.space(); err != nil {
+			return
+		}
+		if public, err = p.literal(); err != nil {
+			return
+		}
+		if err = p.skipSpace(); err != nil {
+			return
+		}
+		// The system literal is optional in NOTATION declarations.
+		if p.has("\"") || p.has("'") {
+			system, err = p.literal()
+		}
+	default:
+		err = p.errorf("expected SYSTEM or PUBLIC")
+	}
+	return
+}
+
+// subset parses markup declarations until the end of the input, or the
+// closing ] of an internal subset.
+func (p *parser) subset(internal bool) error {
+	for {
+		if err := p.skipSpace(); err != nil {
+			return err
+		}
+		if p.eof() {
+			if internal {
+				return p.errorf("unterminated internal subset")
+			}
+			if p.condDepth > 0 {
+				return p.errorf("unterminated conditional section")
+			}
+			return nil
+		}
+		var err error
+		switch {
+		case p.has("<!--"):
+			err = p.skipPast("-->")
+		case p.has("<?"):
+			err = p.skipPast("?>")
+		case p.has("<!["):
+			if internal {
+				return p.errorf("conditional sections are not allowed in the internal subset")
+			}
+			err = p.conditional()
+		case p.has("]]>") && p.condDepth > 0:
+			p.pos += 3
+			p.condDepth--
+		case p.has("]") && internal:
+			return nil
+		case p.has("<!ELEMENT"):
+			err = p.elementDecl()
+		case p.has("<!ATTLIST"):
+			err = p.attlistDecl()
+		case p.has("<!ENTITY"):
+			err = p.entityDecl()
+		case p.has("<!NOTATION"):
+			err = p.notationDecl()
+		default:
+			return p.errorf("unexpected %q", p.s[p.pos:p.pos+1])
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (p *parser) conditional() error {
+	p.pos += len("<![")
+	if err := p.skipSpace(); err != nil {
+		return err
+	}
+	keyword, err := p.name()
+	if err != nil {
+		return err
+	}
+	if err := p.skipSpace(); err != nil {
+		return err
+	}
+	if err := p.expect("["); err != nil {
+		return err
+	}
+	switch keyword {
+	case "INCLUDE":
+		p.condDepth++
+		return nil
+	case "IGNORE":
+		for depth := 1; depth > 0; {
+			open := strings.Index(p.s[p.pos:], "<![")
+			end := strings.Index(p.s[p.pos:], "]]>")
+			switch {
+			case end < 0:
+				return p.errorf("unterminated conditional section")
+			case open >= 0 && open < end:
+				depth++
+				p.pos += open + 3
+			default:
+				depth--
+				p.pos += end + 3
+			}
+		}
+		return nil
+	}
+	return p.errorf("expected INCLUDE or IGNORE, not %s", keyword)
+}
+
+func (p *parser) endDecl() error {
+	if err := p.skipSpace(); err != nil {
+		return err
+	}
+	return p.expect(">")
+}
+
+func (p *parser) elementDecl() error {
+	p.pos += len("<!ELEMENT")
+	if err := p.space(); err != nil {
+		return err
+	}
+	name, err := p.name()
+	if err != nil {
+		return err
+	}
+	if err := p.space(); err != nil {
+		return err
+	}
+	var m *model
+	switch {
+	case p.has("EMPTY"):
+		p.pos += len("EMPTY")
+		m = &model{kind: emptyModel}
+	case p.has("ANY"):
+		p.pos += len("ANY")
+		m = &model{kind: anyModel}
+	case p.has("("):
+		p.pos++
+		if err := p.skipSpace(); err != nil {
+			return err
+		}
+		if p.has("#PCDATA") {
+			m, err = p.mixed()
+		} else {
+			m, err = p.group()
+		}
+		if err != nil {
+			return err
+		}
+	default:
+		return p.errorf("expected a content specification for %s", name)
+	}
+	if err := p.endDecl(); err != nil {
+		return err
+	}
+	if _, dup := p.d.elements[name]; dup {
+		return p.errorf("element %s is declared more than once", name)
+	}
+	p.d.elements[name] = m
+	return nil
+}
+
+func (p *parser) mixed() (*model, error) {
+	p.pos += len("#PCDATA")
+	m := &model{kind: mixedModel, min: 0, max: -1}
+	for {
+		if err := p.skipSpace(); err != nil {
+			return nil, err
+		}
+		if p.has(")") {
+			p.pos++
+			break
+		}
+		if err := p.expect("|"); err != nil {
+			return nil, err
+		}
+		if err := p.skipSpace(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		m.items = append(m.items, &model{kind: nameModel, name: name, min: 1, max: 1})
+	}
+	if p.has("*") {
+		p.pos++
+	} else if len(m.items) > 0 {
+		return nil, p.errorf("mixed content with element names must end with )*")
+	}
+	return m, nil
+}
+
+// group parses a sequence or choice after its opening parenthesis.
+func (p *parser) group() (*model, error) {
+	m := &model{kind: seqModel}
+	sep := byte(0)
+	for {
+		if err := p.skipSpace(); err != nil {
+			return nil, err
+		}
+		item, err := p.cp()
+		if err != nil {
+			return nil, err
+		}
+		m.items = append(m.items, item)
+		if err := p.skipSpace(); err != nil {
+			return nil, err
+		}
+		if p.eof() {
+			return nil, p.errorf("unterminated content model")
+		}
+		c := p.s[p.pos]
+		p.pos++
+		if c == ')' {
+			break
+		}
+		if (c != ',' && c != '|') || (sep != 0 && c != sep) {
+			return nil, p.errorf("unexpected %q in content model", c)
+		}
+		sep = c
+	}
+	if sep == '|' {
+		m.kind = choiceModel
+	}
+	p.occurrence(m)
+	return m, nil
+}
+
+func (p *parser) cp() (*model, error) {
+	if p.has("(") {
+		p.pos++
+		return p.group()
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	m := &model{kind: nameModel, name: name}
+	p.occurrence(m)
+	return m, nil
+}
+
+func (p *parser) occurrence(m *model) {
+	m.min, m.max = 1, 1
+	if p.eof() {
+		return
+	}
+	switch p.s[p.pos] {
+	case '?':
+		m.min = 0
+	case '*':
+		m.min, m.max = 0, -1
+	case '+':
+		m.max = -1
+	default:
+		return
+	}
+	p.pos++
+}
+
+func (p *parser) attlistDecl() error {
+	p.pos += len("<!ATTLIST")
+	if err := p.space(); err != nil {
+		return err
+	}
+	elem, err := p.name()
+	if err != nil {
+		return err
+	}
+	for {
+		if err := p.skipSpace(); err != nil {
+			return err
+		}
+		if p.has(">") {
+			p.pos++
+			return nil
+		}
+		a := &attrDecl{}
+		if a.name, err = p.name(); err != nil {
+			return err
+		}
+		if err := p.space(); err != nil {
+			return err
+		}
+		if err := p.attrType(a); err != nil {
+			return err
+		}
+		if err := p.space(); err != nil {
+			return err
+		}
+		switch {
+		case p.has("#REQUIRED"):
+			p.pos += len("#REQUIRED")
+			a.required = true
+		case p.has("#IMPLIED"):
+			p.pos += len("#IMPLIED")
+		default:
+			if p.has("#FIXED") {
+				p.pos += len("#FIXED")
+				a.fixed = true
+				if err := p.space(); err != nil {
+					return err
+				}
+			}
+			def, err := p.literal()
+			if err != nil {
+				return err
+			}
+			a.def = &def
+		}
+		p.d.addAttr(elem, a)
+	}
+}
+
+func (p *parser) attrType(a *attrDecl) error {
+	if p.has("(") {
+		a.typ = enumAttr
+		return p.enumeration(a, p.nmtoken)
+	}
+	typ, err := p.name()
+	if err != nil {
+		return err
+	}
+	if typ == "NOTATION" {
+		a.typ = notationAttr
+		if err := p.space(); err != nil {
+			return err
+		}
+		return p.enumeration(a, p.name)
+	}
+	t, ok := attrTypes[typ]
+	if !ok {
+		return p.errorf("unknown attribute type %s", typ)
+	}
+	a.typ = t
+	return nil
+}
+
+func (p *parser) enumeration(a *attrDecl, token func() (string, error)) error {
+	if err := p.expect("("); err != nil {
+		return err
+	}
+	for {
+		if err := p.skipSpace(); err != nil {
+			return err
+		}
+		v, err := token()
+		if err != nil {
+			return err
+		}
+		a.values = append(a.values, v)
+		if err := p.skipSpace(); err != nil {
+			return err
+		}
+		if p.has(")") {
+			p.pos++
+			return nil
+		}
+		if err := p.expect("|"); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *parser) entityDecl() error {
+	p.pos += len("<!ENTITY")
+	// Parameter entity references are not recognised between <!ENTITY
+	// and the % that marks a parameter entity declaration.
+	for !p.eof() && strings.IndexByte(" \t\r\n", p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+	param := false
+	if p.has("%") {
+		p.pos++
+		param = true
+		if err := p.space(); err != nil {
+			return err
+		}
+	}
+	name, err := p.name()
+	if err != nil {
+		return err
+	}
+	if err := p.space(); err != nil {
+		return err
+	}
+	if p.has("\"") || p.has("'") {
+		value, err := p.literal()
+		if err != nil {
+			return err
+		}
+		if param {
+			if _, dup := p.pe[name]; !dup {
+				p.pe[name] = p.expandLiteral(value)
+			}
+		}
+		return p.endDecl()
+	}
+	if _, _, err := p.externalID(); err != nil {
+		return err
+	}
+	if param {
+		p.externalPE[name] = true
+		return p.endDecl()
+	}
+	if err := p.skipSpace(); err != nil {
+		return err
+	}
+	if p.has("NDATA") {
+		p.pos += len("NDATA")
+		if err := p.space(); err != nil {
+			return err
+		}
+		if _, err := p.name(); err != nil {
+			return err
+		}
+		p.d.unparsed[name] = true
+	}
+	return p.endDecl()
+}
+
+// expandLiteral expands the parameter entity references in an entity
+// value.  References to undeclared entities are left alone.
+func (p *parser) expandLiteral(v string) string {
+	for i := 0; i < len(v); i++ {
+		if v[i] != '%' {
+			continue
+		}
+		end := strings.IndexByte(v[i:], ';')
+		if end < 0 {
+			break
+		}
+		repl, ok := p.pe[v[i+1:i+end]]
+		if !ok {
+			continue
+		}
+		if p.expansions++; p.expansions > maxExpansions {
+			break
+		}
+		v = v[:i] + repl + v[i+end+1:]
+		i += len(repl) - 1
+	}
+	return v
+}
+
+func (p *parser) notationDecl() error {
+	p.pos += len("<!NOTATION")
+	if err := p.space(); err != nil {
+		return err
+	}
+	name, err := p.name()
+	if err != nil {
+		return err
+	}
+	if err := p.space(); err != nil {
+		return err
+	}
+	if _, _, err := p.externalID(); err != nil {
+		return err
+	}
+	p.d.notations[name] = true
+	return p.endDecl()
+}
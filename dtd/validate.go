@@ -0,0 +1,374 @@
+package dtd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+func isNamespaceDecl(a xml.Attr) bool {
+	return a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns")
+}
+
+// prefix finds the prefix bound to space where e is.  Names whose prefix
+// was never declared are left with the prefix as their space by
+// encoding/xml, so that is what we fall back to.
+func prefix(e *dom.Element, space string, allowDefault bool) string {
+	if space == dom.NS_XML {
+		return "xml"
+	}
+	for n := e; n != nil; n = n.Parent() {
+		for _, a := range n.Attributes {
+			if a.Value != space {
+				continue
+			}
+			if a.Name.Space == "xmlns" {
+				return a.Name.Local
+			}
+			if allowDefault && a.Name.Space == "" && a.Name.Local == "xmlns" {
+				return ""
+			}
+		}
+	}
+	return space
+}
+
+func join(prefix, local string) string {
+	if prefix == "" {
+		return local
+	}
+	return prefix + ":" + local
+}
+
+// qname returns the name of e as it would have been written in its
+// source document.
+func qname(e *dom.Element) string {
+	if e.Name.Space == "" {
+		return e.Name.Local
+	}
+	return join(prefix(e, e.Name.Space, true), e.Name.Local)
+}
+
+func attrName(e *dom.Element, a xml.Attr) string {
+	if a.Name.Space == "" {
+		return a.Name.Local
+	}
+	return join(prefix(e, a.Name.Space, false), a.Name.Local)
+}
+
+func isName(s string) bool {
+	for i, r := range s {
+		if !isNameChar(r) || (i == 0 && !isNameStart(r)) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func isNmtoken(s string) bool {
+	for _, r := range s {
+		if !isNameChar(r) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+type ref struct {
+	e  *dom.Element
+	id string
+}
+
+type validator struct {
+	d          *DTD
+	violations []Violation
+	ids        map[string]*dom.Element
+	refs       []ref
+}
+
+func (v *validator) report(e *dom.Element, format string, args ...interface{}) {
+	v.violations = append(v.violations, Violation{
+		Element: e,
+		Pos:     e.Pos(),
+		Path:    e.Path(),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) element(e *dom.Element) {
+	name := qname(e)
+	m, ok := v.d.elements[name]
+	if !ok {
+		v.report(e, "element %s is not declared", name)
+	} else {
+		v.attributes(e, name)
+		v.content(e, name, m)
+	}
+	for _, c := range e.Children() {
+		v.element(c)
+	}
+}
+
+func (v *validator) content(e *dom.Element, name string, m *model) {
+	children := e.Children()
+	switch m.kind {
+	case anyModel:
+	case emptyModel:
+		if len(children) > 0 || len(e.Content) > 0 {
+			v.report(e, "element %s must be empty", name)
+		}
+	case mixedModel:
+		for _, c := range children {
+			allowed := false
+			for _, item := range m.items {
+				if item.name == qname(c) {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				v.report(c, "element %s is not allowed in %s, which has content model %v", qname(c), name, m)
+			}
+		}
+	default:
+		if len(e.Content) > 0 {
+			v.report(e, "text is not allowed in element %s", name)
+		}
+		names := make([]string, len(children))
+		for i, c := range children {
+			names[i] = qname(c)
+		}
+		mt := &matcher{names: names, expected: map[string]bool{}}
+		for _, end := range mt.match(m, []int{0}) {
+			if end == len(names) {
+				return
+			}
+		}
+		switch {
+		case mt.furthest < len(names) && len(mt.expected) > 0:
+			v.report(children[mt.furthest], "unexpected element %s, expected %s",
+				names[mt.furthest], mt.expectedList())
+		case mt.furthest < len(names):
+			v.report(children[mt.furthest], "unexpected element %s", names[mt.furthest])
+		default:
+			v.report(e, "missing element, expected %s", mt.expectedList())
+		}
+	}
+}
+
+func (v *validator) attributes(e *dom.Element, elem string) {
+	decls := v.d.attrs[elem]
+	present := map[string]bool{}
+	for _, a := range e.Attributes {
+		if isNamespaceDecl(a) {
+			continue
+		}
+		name := attrName(e, a)
+		present[name] = true
+		var decl *attrDecl
+		for _, d := range decls {
+			if d.name == name {
+				decl = d
+				break
+			}
+		}
+		if decl == nil {
+			v.report(e, "attribute %s is not declared for element %s", name, elem)
+			continue
+		}
+		v.attrValue(e, decl, a.Value)
+	}
+	for _, d := range decls {
+		if d.required && !present[d.name] {
+			v.report(e, "missing required attribute %s", d.name)
+		}
+	}
+}
+
+func (v *validator) attrValue(e *dom.Element, decl *attrDecl, value string) {
+	if decl.typ != cdataAttr {
+		value = strings.Join(strings.Fields(value), " ")
+	}
+	if decl.fixed {
+		fixed := *decl.def
+		if decl.typ != cdataAttr {
+			fixed = strings.Join(strings.Fields(fixed), " ")
+		}
+		if value != fixed {
+			v.report(e, "attribute %s must be %q", decl.name, fixed)
+			return
+		}
+	}
+	bad := func(what string) {
+		v.report(e, "attribute %s: %q is not a valid %s", decl.name, value, what)
+	}
+	switch decl.typ {
+	case idAttr:
+		if !isName(value) {
+			bad("ID")
+		} else if other, dup := v.ids[value]; dup {
+			v.report(e, "ID %q is already used by %s", value, other.Path())
+		} else {
+			v.ids[value] = e
+		}
+	case idrefAttr, idrefsAttr:
+		ids := strings.Fields(value)
+		if len(ids) == 0 || (decl.typ == idrefAttr && len(ids) > 1) {
+			bad("IDREF")
+		}
+		for _, id := range ids {
+			if !isName(id) {
+				bad("IDREF")
+				return
+			}
+			v.refs = append(v.refs, ref{e, id})
+		}
+	case entityAttr, entitiesAttr:
+		names := strings.Fields(value)
+		if len(names) == 0 || (decl.typ == entityAttr && len(names) > 1) {
+			bad("ENTITY")
+		}
+		for _, n := range names {
+			if !v.d.unparsed[n] {
+				v.report(e, "attribute %s: %s is not an unparsed entity", decl.name, n)
+			}
+		}
+	case nmtokenAttr:
+		if !isNmtoken(value) {
+			bad("NMTOKEN")
+		}
+	case nmtokensAttr:
+		toks := strings.Fields(value)
+		if len(toks) == 0 {
+			bad("NMTOKENS")
+		}
+		for _, t := range toks {
+			if !isNmtoken(t) {
+				bad("NMTOKENS")
+				return
+			}
+		}
+	case enumAttr, notationAttr:
+		for _, allowed := range decl.values {
+			if value == allowed {
+				return
+			}
+		}
+		v.report(e, "attribute %s must be one of %s", decl.name, strings.Join(decl.values, ", "))
+	}
+}
+
+// checkRefs reports IDREFs that do not refer to an ID once all the IDs
+// in the tree have been seen.
+func (v *validator) checkRefs() {
+	for _, r := range v.refs {
+		if _, ok := v.ids[r.id]; !ok {
+			v.report(r.e, "IDREF %q does not match any ID", r.id)
+		}
+	}
+}
+
+// matcher matches a list of element names against a content model.  It
+// works on sets of positions in the names reached by different ways of
+// matching the model so far.
+type matcher struct {
+	names    []string
+	furthest int
+	expected map[string]bool
+}
+
+func (m *matcher) expect(pos int, name string) {
+	if pos > m.furthest {
+		m.furthest = pos
+		m.expected = map[string]bool{}
+	}
+	if pos == m.furthest {
+		m.expected[name] = true
+	}
+}
+
+func (m *matcher) reached(pos int) {
+	if pos > m.furthest {
+		m.furthest = pos
+		m.expected = map[string]bool{}
+	}
+}
+
+func (m *matcher) expectedList() string {
+	names := []string{}
+	for n := range m.expected {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func dedupe(positions []int) []int {
+	seen := map[int]bool{}
+	res := []int{}
+	for _, p := range positions {
+		if !seen[p] {
+			seen[p] = true
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+func (m *matcher) match(md *model, positions []int) []int {
+	res := []int{}
+	if md.min == 0 {
+		res = append(res, positions...)
+	}
+	seen := map[int]bool{}
+	for _, p := range positions {
+		seen[p] = true
+	}
+	cur := positions
+	for count := 1; len(cur) > 0 && (md.max < 0 || count <= md.max); count++ {
+		next := dedupe(m.once(md, cur))
+		if count >= md.min {
+			res = append(res, next...)
+			fresh := []int{}
+			for _, p := range next {
+				if !seen[p] {
+					seen[p] = true
+					fresh = append(fresh, p)
+				}
+			}
+			next = fresh
+		}
+		cur = next
+		if count > len(m.names)+md.min {
+			break
+		}
+	}
+	return dedupe(res)
+}
+
+func (m *matcher) once(md *model, positions []int) []int {
+	res := []int{}
+	switch md.kind {
+	case nameModel:
+		for _, p := range positions {
+			m.expect(p, md.name)
+			if p < len(m.names) && m.names[p] == md.name {
+				res = append(res, p+1)
+				m.reached(p + 1)
+			}
+		}
+	case seqModel:
+		res = positions
+		for _, item := range md.items {
+			res = m.match(item, res)
+		}
+	case choiceModel:
+		for _, item := range md.items {
+			res = append(res, m.match(item, positions)...)
+		}
+	}
+	return res
+}
@@ -0,0 +1,297 @@
+// Package feeds reads RSS 2.0 and Atom 1.0 feeds into a small common
+// model.
+//
+// Every Feed and Entry keeps the Element it was read from, so that
+// extension elements the model does not know about can still be found
+// with the dom, search or xpath packages.  Dates that cannot be parsed
+// are left as the zero time.
+//
+// For some basic usage examples, see feeds_test.go
+package feeds
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+// Namespaces used by feeds.
+const (
+	NS_ATOM    = "http://www.w3.org/2005/Atom"
+	NS_CONTENT = "http://purl.org/rss/1.0/modules/content/"
+	NS_DC      = "http://purl.org/dc/elements/1.1/"
+)
+
+// Format is the format a feed was written in.
+type Format int
+
+const (
+	RSS Format = iota + 1
+	Atom
+)
+
+func (f Format) String() string {
+	if f == Atom {
+		return "Atom"
+	}
+	return "RSS"
+}
+
+// Link is a link from a feed or an entry.
+type Link struct {
+	Href  string
+	Rel   string
+	Type  string
+	Title string
+}
+
+// Feed is an RSS channel or an Atom feed.
+type Feed struct {
+	Format      Format
+	Title       string
+	Description string
+	ID          string
+	Links       []Link
+	Updated     time.Time
+	Entries     []*Entry
+	// Element is the channel or feed element.
+	Element *dom.Element
+}
+
+// Entry is an RSS item or an Atom entry.
+type Entry struct {
+	Title   string
+	ID      string
+	Summary string
+	Content string
+	Links   []Link
+	// Published and Updated are the same for RSS, which only has
+	// pubDate.
+	Published  time.Time
+	Updated    time.Time
+	Authors    []string
+	Categories []string
+	// Element is the item or entry element.
+	Element *dom.Element
+}
+
+// alternate returns the href of the first link with rel alternate (or no
+// rel) in links.
+func alternate(links []Link) string {
+	for _, l := range links {
+		if l.Rel == "" || l.Rel == "alternate" {
+			return l.Href
+		}
+	}
+	return ""
+}
+
+// Link returns the address of the web page for f.
+func (f *Feed) Link() string {
+	return alternate(f.Links)
+}
+
+// Link returns the address of the web page for e.
+func (e *Entry) Link() string {
+	return alternate(e.Links)
+}
+
+// Parse reads a feed from r.
+func Parse(r io.Reader) (*Feed, error) {
+	doc, err := dom.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	return FromDocument(doc)
+}
+
+// FromDocument reads the feed in doc.
+func FromDocument(doc *dom.Document) (*Feed, error) {
+	root := doc.Root()
+	switch {
+	case root == nil:
+		return nil, errors.New("feeds: document has no root element")
+	case root.Name.Local == "rss" && root.Name.Space == "":
+		for _, c := range root.Children() {
+			if c.Name.Local == "channel" && c.Name.Space == "" {
+				return rss(c), nil
+			}
+		}
+		return nil, errors.New("feeds: rss element has no channel")
+	case root.Name.Local == "feed" && root.Name.Space == NS_ATOM:
+		return atom(root), nil
+	}
+	return nil, fmt.Errorf("feeds: %s is not a feed", root.Name.Local)
+}
+
+func text(e *dom.Element) string {
+	return strings.TrimSpace(string(e.Content))
+}
+
+func attr(e *dom.Element, name string) string {
+	if a := e.GetAttr(name, "", "*"); len(a) > 0 {
+		return a[0].Value
+	}
+	return ""
+}
+
+var rssLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 MST",
+	"Mon, 02 Jan 06 15:04:05 -0700",
+	"Mon, 02 Jan 06 15:04:05 MST",
+	time.RFC3339,
+}
+
+func rssDate(s string) time.Time {
+	for _, layout := range rssLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func atomDate(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, s)
+	return t
+}
+
+func atomLink(e *dom.Element) Link {
+	return Link{Href: attr(e, "href"), Rel: attr(e, "rel"), Type: attr(e, "type"), Title: attr(e, "title")}
+}
+
+func rss(channel *dom.Element) *Feed {
+	f := &Feed{Format: RSS, Element: channel}
+	for _, c := range channel.Children() {
+		switch {
+		case c.Name.Space == NS_ATOM && c.Name.Local == "link":
+			f.Links = append(f.Links, atomLink(c))
+		case c.Name.Space != "":
+		case c.Name.Local == "title":
+			f.Title = text(c)
+		case c.Name.Local == "link":
+			f.Links = append(f.Links, Link{Href: text(c)})
+		case c.Name.Local == "description":
+			f.Description = text(c)
+		case c.Name.Local == "lastBuildDate":
+			f.Updated = rssDate(text(c))
+		case c.Name.Local == "pubDate" && f.Updated.IsZero():
+			f.Updated = rssDate(text(c))
+		case c.Name.Local == "item":
+			f.Entries = append(f.Entries, rssItem(c))
+		}
+	}
+	f.ID = f.Link()
+	return f
+}
+
+func rssItem(item *dom.Element) *Entry {
+	e := &Entry{Element: item}
+	for _, c := range item.Children() {
+		switch {
+		case c.Name.Space == NS_CONTENT && c.Name.Local == "encoded":
+			e.Content = text(c)
+		case c.Name.Space == NS_DC && c.Name.Local == "creator":
+			e.Authors = append(e.Authors, text(c))
+		case c.Name.Space == NS_ATOM && c.Name.Local == "link":
+			e.Links = append(e.Links, atomLink(c))
+		case c.Name.Space != "":
+		case c.Name.Local == "title":
+			e.Title = text(c)
+		case c.Name.Local == "link":
+			e.Links = append(e.Links, Link{Href: text(c)})
+		case c.Name.Local == "description":
+			e.Summary = text(c)
+		case c.Name.Local == "guid":
+			e.ID = text(c)
+		case c.Name.Local == "pubDate":
+			e.Published = rssDate(text(c))
+			e.Updated = e.Published
+		case c.Name.Local == "author":
+			e.Authors = append(e.Authors, text(c))
+		case c.Name.Local == "category":
+			e.Categories = append(e.Categories, text(c))
+		case c.Name.Local == "enclosure":
+			e.Links = append(e.Links, Link{Href: attr(c, "url"), Rel: "enclosure", Type: attr(c, "type")})
+		}
+	}
+	if e.ID == "" {
+		e.ID = e.Link()
+	}
+	return e
+}
+
+func atomAuthor(e *dom.Element) string {
+	for _, c := range e.Children() {
+		if c.Name.Space == NS_ATOM && c.Name.Local == "name" {
+			return text(c)
+		}
+	}
+	return ""
+}
+
+func atom(feed *dom.Element) *Feed {
+	f := &Feed{Format: Atom, Element: feed}
+	for _, c := range feed.Children() {
+		if c.Name.Space != NS_ATOM {
+			continue
+		}
+		switch c.Name.Local {
+		case "title":
+			f.Title = text(c)
+		case "subtitle":
+			f.Description = text(c)
+		case "id":
+			f.ID = text(c)
+		case "link":
+			f.Links = append(f.Links, atomLink(c))
+		case "updated":
+			f.Updated = atomDate(text(c))
+		case "entry":
+			f.Entries = append(f.Entries, atomEntry(c))
+		}
+	}
+	return f
+}
+
+func atomEntry(entry *dom.Element) *Entry {
+	e := &Entry{Element: entry}
+	for _, c := range entry.Children() {
+		if c.Name.Space != NS_ATOM {
+			continue
+		}
+		switch c.Name.Local {
+		case "title":
+			e.Title = text(c)
+		case "id":
+			e.ID = text(c)
+		case "summary":
+			e.Summary = text(c)
+		case "content":
+			e.Content = text(c)
+		case "link":
+			e.Links = append(e.Links, atomLink(c))
+		case "published":
+			e.Published = atomDate(text(c))
+		case "updated":
+			e.Updated = atomDate(text(c))
+		case "author":
+			e.Authors = append(e.Authors, atomAuthor(c))
+		case "category":
+			e.Categories = append(e.Categories, attr(c, "term"))
+		}
+	}
+	if e.Published.IsZero() {
+		e.Published = e.Updated
+	}
+	return e
+}
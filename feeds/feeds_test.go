@@ -0,0 +1,104 @@
+package feeds
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const rssFeed = `<?xml version="1.0"?>
+<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom" xmlns:content="http://purl.org/rss/1.0/modules/content/" xmlns:media="http://search.yahoo.com/mrss/">
+<channel>
+  <title>Example</title>
+  <link>http://example.com/</link>
+  <atom:link href="http://example.com/rss" rel="self" type="application/rss+xml"/>
+  <description>An example feed</description>
+  <lastBuildDate>Mon, 01 Apr 2013 12:00:00 +0000</lastBuildDate>
+  <item>
+    <title>First</title>
+    <link>http://example.com/1</link>
+    <description>Summary one</description>
+    <content:encoded>Full text one</content:encoded>
+    <pubDate>Sun, 31 Mar 2013 08:30:00 GMT</pubDate>
+    <category>go</category>
+    <category>xml</category>
+    <media:thumbnail url="http://example.com/1.png"/>
+  </item>
+  <item>
+    <title>Second</title>
+    <guid>urn:2</guid>
+    <pubDate>not a date</pubDate>
+    <enclosure url="http://example.com/2.mp3" type="audio/mpeg" length="1"/>
+  </item>
+</channel>
+</rss>`
+
+const atomFeed = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Example</title>
+  <subtitle>An example feed</subtitle>
+  <link href="http://example.com/"/>
+  <link rel="self" href="http://example.com/atom"/>
+  <id>urn:feed</id>
+  <updated>2013-04-01T12:00:00Z</updated>
+  <entry>
+    <title>First</title>
+    <link href="http://example.com/1"/>
+    <id>urn:1</id>
+    <updated>2013-03-31T08:30:00+02:00</updated>
+    <author><name>Alice</name></author>
+    <category term="go"/>
+    <summary>Summary one</summary>
+    <content type="text">Full text one</content>
+  </entry>
+</feed>`
+
+func TestRSS(t *testing.T) {
+	f, err := Parse(strings.NewReader(rssFeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Format != RSS || f.Title != "Example" || f.Link() != "http://example.com/" || len(f.Links) != 2 ||
+		!f.Updated.Equal(time.Date(2013, 4, 1, 12, 0, 0, 0, time.UTC)) || len(f.Entries) != 2 {
+		t.Fatalf("Unexpected feed %+v", f)
+	}
+	e := f.Entries[0]
+	if e.Title != "First" || e.ID != "http://example.com/1" || e.Summary != "Summary one" || e.Content != "Full text one" ||
+		len(e.Categories) != 2 || e.Published.Hour() != 8 {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+	// Extensions are still reachable through the element.
+	thumb := e.Element.Children()[len(e.Element.Children())-1]
+	if thumb.Name.Local != "thumbnail" || len(thumb.GetAttr("url", "", "http://example.com/1.png")) != 1 {
+		t.Errorf("Expected the media:thumbnail element, got %v", thumb)
+	}
+	e = f.Entries[1]
+	if e.ID != "urn:2" || !e.Published.IsZero() || len(e.Links) != 1 || e.Links[0].Rel != "enclosure" {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+}
+
+func TestAtom(t *testing.T) {
+	f, err := Parse(strings.NewReader(atomFeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Format != Atom || f.ID != "urn:feed" || f.Description != "An example feed" || f.Link() != "http://example.com/" ||
+		f.Updated.IsZero() || len(f.Entries) != 1 {
+		t.Fatalf("Unexpected feed %+v", f)
+	}
+	e := f.Entries[0]
+	if e.Title != "First" || e.Link() != "http://example.com/1" || len(e.Authors) != 1 || e.Authors[0] != "Alice" ||
+		e.Categories[0] != "go" || e.Content != "Full text one" || !e.Published.Equal(e.Updated) ||
+		e.Updated.UTC().Hour() != 6 {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+}
+
+func TestNotAFeed(t *testing.T) {
+	for _, src := range []string{`<html/>`, `<rss version="2.0"/>`, `<feed/>`} {
+		if _, err := Parse(strings.NewReader(src)); err == nil {
+			t.Errorf("Expected %s to be rejected", src)
+		}
+	}
+}
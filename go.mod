@@ -1,3 +1,7 @@
 module github.com/VictorLowther/simplexml
 
 go 1.20
+
+require golang.org/x/net v0.17.0
+
+require gopkg.in/yaml.v3 v3.0.1
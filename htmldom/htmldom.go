@@ -0,0 +1,162 @@
+// Package htmldom converts between golang.org/x/net/html trees and
+// simplexml/dom trees, so that scraped HTML can be handled with the dom,
+// search and xpath packages.
+//
+// HTML elements and attributes are put in no namespace, so that they can
+// be found by their plain names.  SVG and MathML elements get their usual
+// namespaces, as do xlink and xml attributes.  Since dom Elements have a
+// single Content field, the text directly inside an element is joined up
+// with single spaces, and ends up before its child elements.  Comments
+// are dropped, as are attributes whose names cannot be used in XML and
+// characters that XML does not allow.
+//
+// For some basic usage examples, see htmldom_test.go
+package htmldom
+
+import (
+	"io"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Namespaces of the foreign content HTML can hold.
+const (
+	NS_SVG    = "http://www.w3.org/2000/svg"
+	NS_MATHML = "http://www.w3.org/1998/Math/MathML"
+	NS_XLINK  = "http://www.w3.org/1999/xlink"
+)
+
+// namespaces maps the namespace names used by x/net/html to URIs.
+var namespaces = map[string]string{
+	"svg":   NS_SVG,
+	"math":  NS_MATHML,
+	"xlink": NS_XLINK,
+	"xml":   dom.NS_XML,
+}
+
+func nsName(uri string) string {
+	for name, u := range namespaces {
+		if u == uri {
+			return name
+		}
+	}
+	return ""
+}
+
+// Parse parses an HTML document from r and converts it.
+func Parse(r io.Reader) (*dom.Document, error) {
+	n, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	return ConvertDocument(n), nil
+}
+
+// ConvertDocument converts n, which should be an html.DocumentNode, to a
+// Document.  The first element inside n becomes the root, and a doctype
+// is kept.
+func ConvertDocument(n *html.Node) *dom.Document {
+	doc := dom.CreateDocument()
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case html.DoctypeNode:
+			doc.SetDoctype("DOCTYPE " + c.Data)
+		case html.ElementNode:
+			if doc.Root() == nil {
+				doc.SetRoot(Convert(c))
+			}
+		}
+	}
+	return doc
+}
+
+func xmlText(s string) string {
+	return strings.Map(func(r rune) rune {
+		if dom.IsXMLChar(r) {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// Convert converts the html.ElementNode n and everything in it to an
+// Element tree.  If n is a document node, its first element is
+// converted.  It returns nil if there is no element to convert.
+func Convert(n *html.Node) *dom.Element {
+	switch n.Type {
+	case html.DocumentNode:
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode {
+				return Convert(c)
+			}
+		}
+		return nil
+	case html.ElementNode:
+	default:
+		return nil
+	}
+	e := dom.Elem(n.Data, namespaces[n.Namespace])
+	for _, a := range n.Attr {
+		if !dom.IsNCName(a.Key) || a.Namespace == "xmlns" || (a.Namespace == "" && a.Key == "xmlns") {
+			continue
+		}
+		e.Attr(a.Key, namespaces[a.Namespace], xmlText(a.Val))
+	}
+	text := []string{}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case html.TextNode:
+			if t := strings.TrimSpace(c.Data); t != "" {
+				text = append(text, t)
+			}
+		case html.ElementNode:
+			e.AddChild(Convert(c))
+		}
+	}
+	if len(text) > 0 {
+		e.Content = []byte(xmlText(strings.Join(text, " ")))
+	}
+	return e
+}
+
+// ToHTML converts the tree rooted at e to an html.Node tree.  Content
+// becomes a text node before the children of each element.
+func ToHTML(e *dom.Element) *html.Node {
+	n := &html.Node{
+		Type:      html.ElementNode,
+		Data:      e.Name.Local,
+		Namespace: nsName(e.Name.Space),
+	}
+	if n.Namespace == "" {
+		n.DataAtom = atom.Lookup([]byte(e.Name.Local))
+	}
+	for _, a := range e.Attributes {
+		if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") {
+			continue
+		}
+		n.Attr = append(n.Attr, html.Attribute{Namespace: nsName(a.Name.Space), Key: a.Name.Local, Val: a.Value})
+	}
+	if len(e.Content) > 0 {
+		n.AppendChild(&html.Node{Type: html.TextNode, Data: string(e.Content)})
+	}
+	for _, c := range e.Children() {
+		n.AppendChild(ToHTML(c))
+	}
+	return n
+}
+
+// ToHTMLDocument converts doc to an html.DocumentNode, ready to be
+// rendered with html.Render.
+func ToHTMLDocument(doc *dom.Document) *html.Node {
+	n := &html.Node{Type: html.DocumentNode}
+	if dt := strings.Fields(doc.Doctype()); len(dt) > 1 && dt[0] == "DOCTYPE" {
+		n.AppendChild(&html.Node{Type: html.DoctypeNode, Data: dt[1]})
+	}
+	if doc.Root() != nil {
+		n.AppendChild(ToHTML(doc.Root()))
+	}
+	return n
+}
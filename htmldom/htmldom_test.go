@@ -0,0 +1,75 @@
+package htmldom
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/xpath"
+	"golang.org/x/net/html"
+)
+
+const page = `<!DOCTYPE html>
+<html>
+<head><title>Scraped</title></head>
+<body>
+  <p class="intro" @click="go()">Hello <b>world</b>, again</p>
+  <ul><li>one<li>two</ul>
+  <svg viewBox="0 0 10 10"><a xlink:href="#x"><circle r="1"/></a></svg>
+  <!-- dropped -->
+</body>
+</html>`
+
+func TestParse(t *testing.T) {
+	doc, err := Parse(strings.NewReader(page))
+	if err != nil {
+		t.Fatalf("Cannot parse page: %v", err)
+	}
+	if doc.Doctype() != "DOCTYPE html" || doc.Root().Name.Local != "html" {
+		t.Fatalf("Unexpected document %v", doc)
+	}
+	items, err := xpath.Select(doc.Root(), "//ul/li")
+	if err != nil || len(items) != 2 || string(items[1].Content) != "two" {
+		t.Errorf("Unexpected list items %v, %v", items, err)
+	}
+	p, _ := xpath.Select(doc.Root(), "//p[@class='intro']")
+	if len(p) != 1 || string(p[0].Content) != "Hello , again" || len(p[0].Attributes) != 1 {
+		t.Errorf("Unexpected paragraph %v", p)
+	}
+	circles, _ := xpath.Select(doc.Root(), "//*[local-name()='circle']")
+	if len(circles) != 1 || circles[0].Name.Space != NS_SVG {
+		t.Errorf("Expected an SVG circle, got %v", circles)
+	}
+	if a := circles[0].Parent(); len(a.GetAttr("href", NS_XLINK, "#x")) != 1 {
+		t.Errorf("Expected an xlink:href attribute, got %v", a.Attributes)
+	}
+	if err := doc.WellFormed(); err != nil {
+		t.Errorf("Expected a well-formed tree, got %v", err)
+	}
+}
+
+func TestToHTML(t *testing.T) {
+	doc := dom.CreateDocument()
+	doc.SetDoctype("DOCTYPE html")
+	doc.SetRoot(dom.Elem("html", "").AddChild(
+		dom.Elem("body", "").AddChildren(
+			dom.ElemC("p", "", "a < b").Attr("id", "", "x"),
+			dom.Elem("svg", NS_SVG).AddChild(dom.Elem("rect", NS_SVG)))))
+	var b bytes.Buffer
+	if err := html.Render(&b, ToHTMLDocument(doc)); err != nil {
+		t.Fatal(err)
+	}
+	expected := `<!DOCTYPE html><html><body><p id="x">a &lt; b</p><svg><rect></rect></svg></body></html>`
+	if b.String() != expected {
+		t.Errorf("Expected %s, got %s", expected, b.String())
+	}
+	back, err := Parse(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := back.Root().Children()[1]
+	if body.Name.Local != "body" || body.Children()[1].Name.Space != NS_SVG {
+		t.Errorf("Unexpected round trip %v", back)
+	}
+}
@@ -0,0 +1,217 @@
+package mmdom
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The index starts with a header, which is followed by a table of
+// elements in document order, a table of attributes, a table of strings
+// and the bytes of the strings.  All numbers are little-endian.
+const (
+	magic      = "SXIDX\x00\x00\x01"
+	headerSize = 40
+	nodeSize   = 64
+	attrSize   = 12
+	none       = ^uint32(0)
+)
+
+// The fields of an element are 32-bit words, except for the offsets into
+// the file, which take up two.  Names and attribute values are indexes
+// into the table of strings, and the attributes of an element are
+// NumAttrs consecutive entries in the table of attributes, starting at
+// Attrs.  Last is the index of the last descendant of the element, or
+// of the element itself if it has no children.
+const (
+	fSpace = iota
+	fLocal
+	fParent
+	fNext
+	fLast
+	fAttrs
+	fNumAttrs
+	_
+	fStart
+	fEnd       = fStart + 2
+	fTextStart = fEnd + 2
+	fTextEnd   = fTextStart + 2
+)
+
+type header struct {
+	size, modTime      uint64
+	nodes, attrs, strs uint32
+}
+
+func readHeader(b []byte) (header, bool) {
+	if len(b) < headerSize || string(b[:8]) != magic {
+		return header{}, false
+	}
+	le := binary.LittleEndian
+	return header{
+		size:    le.Uint64(b[8:]),
+		modTime: le.Uint64(b[16:]),
+		nodes:   le.Uint32(b[24:]),
+		attrs:   le.Uint32(b[28:]),
+		strs:    le.Uint32(b[32:]),
+	}, true
+}
+
+// node is an element while it is being indexed.  textStart and
+// textEnd are where its last run of text is in the file.
+type node struct {
+	fields                         [fStart]uint32
+	start, end, textStart, textEnd int64
+}
+
+// indexer builds the index of a file.
+type indexer struct {
+	nodes  []node
+	attrs  []uint32
+	strs   []string
+	strIdx map[string]uint32
+}
+
+func (x *indexer) str(s string) uint32 {
+	if i, ok := x.strIdx[s]; ok {
+		return i
+	}
+	i := uint32(len(x.strs))
+	x.strs = append(x.strs, s)
+	x.strIdx[s] = i
+	return i
+}
+
+// Index parses the XML file at path and writes the index Open needs next
+// to it, at IndexPath(path), replacing any index that is already there.
+// It only holds the index in memory, not the document, but the index
+// holds every name and attribute value in the document, so it takes up
+// about as much as a dom tree without Content would.
+func Index(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	x := &indexer{strIdx: map[string]uint32{}}
+	if err := x.parse(f); err != nil {
+		return fmt.Errorf("mmdom: %s: %v", path, err)
+	}
+	tmp := IndexPath(path) + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = x.write(out, info)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, IndexPath(path))
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+func (x *indexer) parse(r io.Reader) error {
+	d := xml.NewDecoder(bufio.NewReader(r))
+	// Like the dom parser, assume UTF-8 whatever the document says.
+	d.CharsetReader = func(_ string, r io.Reader) (io.Reader, error) { return r, nil }
+	var stack, lastChild []uint32
+	for {
+		start := d.InputOffset()
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if len(stack) == 0 && len(x.nodes) > 0 {
+				return fmt.Errorf("more than one root element")
+			}
+			i := uint32(len(x.nodes))
+			n := node{start: start}
+			n.fields[fSpace] = x.str(t.Name.Space)
+			n.fields[fLocal] = x.str(t.Name.Local)
+			n.fields[fParent], n.fields[fNext], n.fields[fLast] = none, none, i
+			n.fields[fAttrs] = uint32(len(x.attrs) / 3)
+			n.fields[fNumAttrs] = uint32(len(t.Attr))
+			for _, a := range t.Attr {
+				x.attrs = append(x.attrs, x.str(a.Name.Space), x.str(a.Name.Local), x.str(a.Value))
+			}
+			if top := len(stack) - 1; top >= 0 {
+				n.fields[fParent] = stack[top]
+				if prev := lastChild[top]; prev != none {
+					x.nodes[prev].fields[fNext] = i
+				}
+				lastChild[top] = i
+			}
+			x.nodes = append(x.nodes, n)
+			stack, lastChild = append(stack, i), append(lastChild, none)
+		case xml.EndElement:
+			i := stack[len(stack)-1]
+			stack, lastChild = stack[:len(stack)-1], lastChild[:len(lastChild)-1]
+			x.nodes[i].end = d.InputOffset()
+			x.nodes[i].fields[fLast] = uint32(len(x.nodes) - 1)
+		case xml.CharData:
+			if len(stack) > 0 && len(bytes.TrimSpace(t)) > 0 {
+				n := &x.nodes[stack[len(stack)-1]]
+				n.textStart, n.textEnd = start, d.InputOffset()
+			}
+		}
+	}
+	if len(x.nodes) == 0 {
+		return fmt.Errorf("no root element")
+	}
+	return nil
+}
+
+func (x *indexer) write(w io.Writer, info os.FileInfo) error {
+	bw := bufio.NewWriter(w)
+	le := binary.LittleEndian
+	b := make([]byte, 0, nodeSize)
+	b = append(b, magic...)
+	b = le.AppendUint64(b, uint64(info.Size()))
+	b = le.AppendUint64(b, uint64(info.ModTime().UnixNano()))
+	b = le.AppendUint32(b, uint32(len(x.nodes)))
+	b = le.AppendUint32(b, uint32(len(x.attrs)/3))
+	b = le.AppendUint32(b, uint32(len(x.strs)))
+	b = le.AppendUint32(b, 0)
+	bw.Write(b)
+	for _, n := range x.nodes {
+		b = b[:0]
+		for _, f := range n.fields {
+			b = le.AppendUint32(b, f)
+		}
+		for _, off := range []int64{n.start, n.end, n.textStart, n.textEnd} {
+			b = le.AppendUint64(b, uint64(off))
+		}
+		bw.Write(b)
+	}
+	for _, a := range x.attrs {
+		bw.Write(le.AppendUint32(b[:0], a))
+	}
+	off := uint32(0)
+	for _, s := range x.strs {
+		b = le.AppendUint32(b[:0], off)
+		bw.Write(le.AppendUint32(b, uint32(len(s))))
+		off += uint32(len(s))
+	}
+	for _, s := range x.strs {
+		bw.WriteString(s)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package mmdom
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile reads the first size bytes of f, on systems where they cannot
+// be mapped into memory.
+func mapFile(f *os.File, size int64) ([]byte, func() error, error) {
+	b := make([]byte, size)
+	if _, err := io.ReadFull(f, b); err != nil {
+		return nil, nil, err
+	}
+	return b, func() error { return nil }, nil
+}
@@ -0,0 +1,304 @@
+// Package mmdom is a read-only tree over an XML file that is mapped into
+// memory, for query-heavy workloads over large documents that are read
+// far more often than they change.
+//
+// Index parses a file once and writes an index of its elements next to
+// it.  Open then maps the file and its index into memory without parsing
+// anything, so opening even a huge document is close to instant, and
+// only the parts of it that are looked at are ever read from disk.
+// Nodes are cheap values that read what they need from the index as
+// they are asked for it, and Element turns any subtree into a
+// simplexml/dom tree for the packages that work on those.
+//
+// For some basic usage examples, see mmdom_test.go
+package mmdom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+// ErrStale is returned by Open when the file has changed since it was
+// indexed, or has no index at all.
+var ErrStale = errors.New("mmdom: index is missing or out of date")
+
+// IndexPath returns the name of the index file for the XML file at path.
+func IndexPath(path string) string {
+	return path + ".sxi"
+}
+
+// Doc is an indexed XML file mapped into memory.  It is safe for
+// concurrent use, and so are its Nodes.
+type Doc struct {
+	src, idx []byte
+	nodes    []byte
+	attrs    []byte
+	strs     []byte
+	data     []byte
+	unmap    []func() error
+}
+
+// Open maps the XML file at path and its index into memory.  It returns
+// ErrStale if the file has no index or has changed since it was last
+// indexed, in which case it can be indexed again with Index.  The Doc
+// must be closed once it is no longer needed, and none of its Nodes or
+// what they returned may be used after that.
+func Open(path string) (*Doc, error) {
+	d := &Doc{}
+	var err error
+	var info os.FileInfo
+	if d.src, info, err = d.mapFile(path); err != nil {
+		return nil, err
+	}
+	if d.idx, _, err = d.mapFile(IndexPath(path)); err != nil {
+		d.Close()
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrStale
+		}
+		return nil, err
+	}
+	h, ok := readHeader(d.idx)
+	if ok && (h.size != uint64(info.Size()) || h.modTime != uint64(info.ModTime().UnixNano())) {
+		d.Close()
+		return nil, ErrStale
+	}
+	if !ok || !d.split(h) {
+		d.Close()
+		return nil, fmt.Errorf("mmdom: %s is not a valid index", IndexPath(path))
+	}
+	return d, nil
+}
+
+func (d *Doc) mapFile(path string) ([]byte, os.FileInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	b, unmap, err := mapFile(f, info.Size())
+	if err != nil {
+		return nil, nil, err
+	}
+	d.unmap = append(d.unmap, unmap)
+	return b, info, nil
+}
+
+// split finds the tables of the index described by h, and reports
+// whether they fit in it.
+func (d *Doc) split(h header) bool {
+	rest := d.idx[headerSize:]
+	take := func(n uint64) []byte {
+		if uint64(len(rest)) < n {
+			return nil
+		}
+		res := rest[:n]
+		rest = rest[n:]
+		return res
+	}
+	d.nodes = take(uint64(h.nodes) * nodeSize)
+	d.attrs = take(uint64(h.attrs) * attrSize)
+	d.strs = take(uint64(h.strs) * 8)
+	d.data = rest
+	return d.nodes != nil && d.attrs != nil && d.strs != nil && h.nodes > 0
+}
+
+// Close unmaps the file and its index.
+func (d *Doc) Close() error {
+	var err error
+	for _, unmap := range d.unmap {
+		if uerr := unmap(); err == nil {
+			err = uerr
+		}
+	}
+	d.unmap = nil
+	return err
+}
+
+// Len returns how many elements the document has.
+func (d *Doc) Len() int {
+	return len(d.nodes) / nodeSize
+}
+
+// Root returns the root element.
+func (d *Doc) Root() Node {
+	return Node{d: d, i: 0}
+}
+
+// Node returns the i'th element of the document, in document order,
+// counting from 0, which is the root.  It panics if i is out of range.
+func (d *Doc) Node(i int) Node {
+	if i < 0 || i >= d.Len() {
+		panic(fmt.Sprintf("mmdom: node %d out of range", i))
+	}
+	return Node{d: d, i: uint32(i)}
+}
+
+func (d *Doc) str(i uint32) string {
+	off := binary.LittleEndian.Uint32(d.strs[i*8:])
+	n := binary.LittleEndian.Uint32(d.strs[i*8+4:])
+	return string(d.data[off : off+n])
+}
+
+// Node is an element of a Doc.  The zero Node is not in any Doc, and is
+// what methods that have no Node to return return.
+type Node struct {
+	d *Doc
+	i uint32
+}
+
+func (n Node) field(f int) uint32 {
+	return binary.LittleEndian.Uint32(n.d.nodes[int(n.i)*nodeSize+f*4:])
+}
+
+func (n Node) offset(f int) int64 {
+	return int64(binary.LittleEndian.Uint64(n.d.nodes[int(n.i)*nodeSize+f*4:]))
+}
+
+func (n Node) node(i uint32) Node {
+	if i == none {
+		return Node{}
+	}
+	return Node{d: n.d, i: i}
+}
+
+// IsValid reports whether n is in a Doc.
+func (n Node) IsValid() bool {
+	return n.d != nil
+}
+
+// Index returns the position of n in document order, as Doc.Node takes.
+func (n Node) Index() int {
+	return int(n.i)
+}
+
+// Name returns the name of the element.
+func (n Node) Name() xml.Name {
+	return xml.Name{Space: n.d.str(n.field(fSpace)), Local: n.d.str(n.field(fLocal))}
+}
+
+// Attributes returns the attributes of the element, with namespace
+// declarations among them, as the dom parser would give them.
+func (n Node) Attributes() []xml.Attr {
+	start, count := n.field(fAttrs), n.field(fNumAttrs)
+	if count == 0 {
+		return nil
+	}
+	res := make([]xml.Attr, count)
+	for i := range res {
+		a := n.d.attrs[(start+uint32(i))*attrSize:]
+		res[i] = xml.Attr{
+			Name:  xml.Name{Space: n.d.str(binary.LittleEndian.Uint32(a)), Local: n.d.str(binary.LittleEndian.Uint32(a[4:]))},
+			Value: n.d.str(binary.LittleEndian.Uint32(a[8:])),
+		}
+	}
+	return res
+}
+
+// Attr returns the value of the attribute called name in the namespace
+// space, and whether there is one.
+func (n Node) Attr(name, space string) (string, bool) {
+	for _, a := range n.Attributes() {
+		if a.Name.Local == name && a.Name.Space == space {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// Content returns the text of the element as dom.Element's Content has
+// it: the last run of text in it, trimmed of whitespace.
+func (n Node) Content() []byte {
+	start, end := n.offset(fTextStart), n.offset(fTextEnd)
+	if start == end {
+		return nil
+	}
+	return decodeText(n.d.src[start:end])
+}
+
+// Raw returns the markup of the element, start tag to end tag, as it is
+// in the file.  The slice is mapped from the file, so it must not be
+// changed, nor used after the Doc is closed.
+func (n Node) Raw() []byte {
+	return n.d.src[n.offset(fStart):n.offset(fEnd)]
+}
+
+// Parent returns the parent of the element, or the zero Node for the
+// root.
+func (n Node) Parent() Node {
+	return n.node(n.field(fParent))
+}
+
+// FirstChild returns the first child of the element, or the zero Node if
+// it has none.
+func (n Node) FirstChild() Node {
+	if n.field(fLast) == n.i {
+		return Node{}
+	}
+	return n.node(n.i + 1)
+}
+
+// NextSibling returns the element after n in its parent, or the zero
+// Node if n is the last one.
+func (n Node) NextSibling() Node {
+	return n.node(n.field(fNext))
+}
+
+// Children returns the children of the element.
+func (n Node) Children() []Node {
+	res := []Node{}
+	for c := n.FirstChild(); c.IsValid(); c = c.NextSibling() {
+		res = append(res, c)
+	}
+	return res
+}
+
+// Descendants returns the descendants of the element in document order.
+// Unlike dom.Element.Descendants, which goes breadth first, this takes
+// no more than making the slice, since the index keeps the elements in
+// document order.
+func (n Node) Descendants() []Node {
+	last := n.field(fLast)
+	res := make([]Node, 0, last-n.i)
+	for i := n.i + 1; i <= last; i++ {
+		res = append(res, Node{d: n.d, i: i})
+	}
+	return res
+}
+
+// Element copies the subtree rooted at n into a new dom tree.
+func (n Node) Element() *dom.Element {
+	res := dom.CreateElement(n.Name())
+	res.Attributes = n.Attributes()
+	res.Content = n.Content()
+	for c := n.FirstChild(); c.IsValid(); c = c.NextSibling() {
+		res.AddChild(c.Element())
+	}
+	return res
+}
+
+// decodeText returns the text that raw, a run of character data as it
+// was written, stands for.
+func decodeText(raw []byte) []byte {
+	d := xml.NewDecoder(bytes.NewReader(raw))
+	var res []byte
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			break
+		}
+		if text, ok := tok.(xml.CharData); ok {
+			res = append(res, text...)
+		}
+	}
+	return bytes.TrimSpace(res)
+}
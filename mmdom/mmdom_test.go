@@ -0,0 +1,99 @@
+package mmdom
+
+import (
+	"encoding/xml"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+const sample = `<?xml version="1.0"?>
+<lib xmlns:x="urn:x">
+  <book id="1" x:lang="en"><title>Go &amp; XML</title><note><![CDATA[<raw>]]></note></book>
+  <book id="2">
+    last words
+    <title>Second</title>
+  </book>
+  <x:extra/>
+</lib>
+`
+
+func indexed(t *testing.T) (string, *Doc) {
+	path := filepath.Join(t.TempDir(), "lib.xml")
+	if err := os.WriteFile(path, []byte(sample), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err != ErrStale {
+		t.Errorf("Opening a file without an index should fail with ErrStale, got %v", err)
+	}
+	if err := Index(path); err != nil {
+		t.Fatal(err)
+	}
+	d, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return path, d
+}
+
+func TestDoc(t *testing.T) {
+	_, d := indexed(t)
+	root := d.Root()
+	if d.Len() != 7 || root.Name().Local != "lib" || root.Parent().IsValid() {
+		t.Fatalf("Unexpected root %v of %d elements", root.Name(), d.Len())
+	}
+	books := root.Children()
+	if len(books) != 3 || books[2].Name() != (xml.Name{Space: "urn:x", Local: "extra"}) {
+		t.Fatalf("Unexpected children %v", books)
+	}
+	if v, ok := books[0].Attr("lang", "urn:x"); !ok || v != "en" {
+		t.Errorf("Unexpected attribute %q", v)
+	}
+	title := books[0].FirstChild()
+	if string(title.Content()) != "Go & XML" || title.Parent().Index() != books[0].Index() {
+		t.Errorf("Unexpected title %q", title.Content())
+	}
+	if got := string(title.NextSibling().Content()); got != "<raw>" {
+		t.Errorf("Unexpected CDATA content %q", got)
+	}
+	if got := string(books[1].Content()); got != "last words" {
+		t.Errorf("Unexpected content %q", got)
+	}
+	if got := string(books[1].FirstChild().Raw()); got != "<title>Second</title>" {
+		t.Errorf("Unexpected markup %q", got)
+	}
+	if n := len(books[0].Descendants()); n != 2 || len(root.Descendants()) != 6 || books[2].FirstChild().IsValid() {
+		t.Errorf("Unexpected descendants")
+	}
+	parsed, err := dom.Parse(strings.NewReader(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := parsed.Root().StringWith()
+	if got, _ := root.Element().StringWith(); got != want {
+		t.Errorf("Element should build the same tree as the parser\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestStale(t *testing.T) {
+	path, _ := indexed(t)
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(path, later, later)
+	if _, err := Open(path); !errors.Is(err, ErrStale) {
+		t.Errorf("Expected ErrStale, got %v", err)
+	}
+	os.WriteFile(IndexPath(path), []byte("junk"), 0666)
+	if _, err := Open(path); err == nil || errors.Is(err, ErrStale) {
+		t.Errorf("Expected an invalid index, got %v", err)
+	}
+	os.WriteFile(path, []byte("<a/><b/>"), 0666)
+	if err := Index(path); err == nil {
+		t.Errorf("Indexing a file with two roots should fail")
+	}
+}
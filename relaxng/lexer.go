@@ -0,0 +1,195 @@
+package relaxng
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	// tokIdent is an identifier or keyword.  Escaped identifiers
+	// (\element) are never keywords.
+	tokIdent
+	// tokCName is a prefixed name, prefix:local.
+	tokCName
+	// tokNsName is prefix:*.
+	tokNsName
+	tokLiteral
+	tokPunct
+)
+
+type token struct {
+	kind    tokKind
+	text    string
+	escaped bool
+	line    int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of input"
+	case tokLiteral:
+		return fmt.Sprintf("%q", t.text)
+	}
+	return t.text
+}
+
+func isNameStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isNameChar(r rune) bool {
+	return isNameStart(r) || r == '-' || r == '.' || unicode.IsDigit(r) ||
+		unicode.Is(unicode.Mn, r) || r == 0xB7
+}
+
+type lexer struct {
+	s    string
+	pos  int
+	line int
+	toks []token
+}
+
+func (l *lexer) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("relaxng: line %d: %s", l.line, fmt.Sprintf(format, args...))
+}
+
+// lex splits src into tokens.  Annotations are dropped, since they never
+// affect validation.
+func lex(src string) ([]token, error) {
+	l := &lexer{s: src, line: 1}
+	depth := 0
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case tok.kind == tokPunct && tok.text == "[":
+			depth++
+			continue
+		case tok.kind == tokPunct && tok.text == "]":
+			if depth == 0 {
+				return nil, l.errorf("unbalanced ]")
+			}
+			depth--
+			continue
+		case tok.kind == tokPunct && tok.text == ">>":
+			// An annotation element: >> name [ ... ].  The brackets are
+			// dropped above, we just have to drop the name.
+			if _, err := l.next(); err != nil {
+				return nil, err
+			}
+			continue
+		case depth > 0 && tok.kind != tokEOF:
+			continue
+		}
+		l.toks = append(l.toks, tok)
+		if tok.kind == tokEOF {
+			if depth > 0 {
+				return nil, l.errorf("unterminated annotation")
+			}
+			return l.toks, nil
+		}
+	}
+}
+
+func (l *lexer) skipSpace() {
+	for l.pos < len(l.s) {
+		switch c := l.s[l.pos]; {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			l.pos++
+		case c == '#':
+			for l.pos < len(l.s) && l.s[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (l *lexer) ncname() string {
+	start := l.pos
+	for l.pos < len(l.s) {
+		r, size := utf8.DecodeRuneInString(l.s[l.pos:])
+		if !isNameChar(r) || (l.pos == start && !isNameStart(r)) {
+			break
+		}
+		l.pos += size
+	}
+	return l.s[start:l.pos]
+}
+
+func (l *lexer) next() (token, error) {
+	l.skipSpace()
+	if l.pos >= len(l.s) {
+		return token{kind: tokEOF, line: l.line}, nil
+	}
+	tok := token{line: l.line}
+	rest := l.s[l.pos:]
+	switch {
+	case strings.HasPrefix(rest, "|=") || strings.HasPrefix(rest, "&=") || strings.HasPrefix(rest, ">>"):
+		tok.kind, tok.text = tokPunct, rest[:2]
+		l.pos += 2
+		return tok, nil
+	case strings.IndexByte("={}()[],&|?*+-~", rest[0]) >= 0:
+		tok.kind, tok.text = tokPunct, rest[:1]
+		l.pos++
+		return tok, nil
+	case rest[0] == '"' || rest[0] == '\'':
+		lit, err := l.literal()
+		if err != nil {
+			return tok, err
+		}
+		tok.kind, tok.text = tokLiteral, lit
+		return tok, nil
+	case rest[0] == '\\':
+		l.pos++
+		tok.escaped = true
+	}
+	name := l.ncname()
+	if name == "" {
+		r, _ := utf8.DecodeRuneInString(rest)
+		return tok, l.errorf("unexpected %q", r)
+	}
+	tok.kind, tok.text = tokIdent, name
+	if !tok.escaped && l.pos < len(l.s) && l.s[l.pos] == ':' {
+		switch l.pos++; {
+		case l.pos < len(l.s) && l.s[l.pos] == '*':
+			l.pos++
+			tok.kind, tok.text = tokNsName, name
+		default:
+			local := l.ncname()
+			if local == "" {
+				return tok, l.errorf("bad name after %s:", name)
+			}
+			tok.kind, tok.text = tokCName, name+":"+local
+		}
+	}
+	return tok, nil
+}
+
+func (l *lexer) literal() (string, error) {
+	q := l.s[l.pos : l.pos+1]
+	if strings.HasPrefix(l.s[l.pos:], q+q+q) {
+		q = q + q + q
+	}
+	l.pos += len(q)
+	end := strings.Index(l.s[l.pos:], q)
+	if end < 0 || (len(q) == 1 && strings.Contains(l.s[l.pos:l.pos+end], "\n")) {
+		return "", l.errorf("unterminated literal")
+	}
+	lit := l.s[l.pos : l.pos+end]
+	l.line += strings.Count(lit, "\n")
+	l.pos += end + len(q)
+	return lit, nil
+}
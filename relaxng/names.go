@@ -0,0 +1,78 @@
+package relaxng
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/schema"
+)
+
+type ncKind int
+
+const (
+	simpleName ncKind = iota
+	nsName
+	anyName
+	ncChoice
+)
+
+type nameClass struct {
+	kind   ncKind
+	name   xml.Name
+	c1, c2 *nameClass
+	except *nameClass
+}
+
+func (nc *nameClass) contains(n xml.Name) bool {
+	switch nc.kind {
+	case simpleName:
+		return nc.name == n
+	case nsName:
+		return nc.name.Space == n.Space && (nc.except == nil || !nc.except.contains(n))
+	case anyName:
+		return nc.except == nil || !nc.except.contains(n)
+	}
+	return nc.c1.contains(n) || nc.c2.contains(n)
+}
+
+func (nc *nameClass) String() string {
+	switch nc.kind {
+	case simpleName:
+		if nc.name.Space == "" {
+			return nc.name.Local
+		}
+		return "{" + nc.name.Space + "}" + nc.name.Local
+	case nsName:
+		return "{" + nc.name.Space + "}*"
+	case anyName:
+		return "*"
+	}
+	return nc.c1.String() + "|" + nc.c2.String()
+}
+
+// datatype is a datatype from one of the supported datatype libraries.
+type datatype interface {
+	allows(v string) bool
+	equal(a, b string) bool
+}
+
+// The built-in RELAX NG datatype library.
+type stringType struct{}
+
+func (stringType) allows(string) bool     { return true }
+func (stringType) equal(a, b string) bool { return a == b }
+
+type tokenType struct{}
+
+func (tokenType) allows(string) bool { return true }
+func (tokenType) equal(a, b string) bool {
+	return strings.Join(strings.Fields(a), " ") == strings.Join(strings.Fields(b), " ")
+}
+
+// xsdType is an XML Schema datatype.
+type xsdType struct {
+	dt *schema.Datatype
+}
+
+func (x xsdType) allows(v string) bool   { return x.dt.Validate(v) == nil }
+func (x xsdType) equal(a, b string) bool { return x.dt.Equal(a, b) }
@@ -0,0 +1,645 @@
+package relaxng
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/schema"
+)
+
+type grammar struct {
+	parent  *grammar
+	start   *define
+	defines map[string]*define
+	order   []*define
+}
+
+func newGrammar(parent *grammar) *grammar {
+	return &grammar{parent: parent, start: &define{name: "start"}, defines: map[string]*define{}}
+}
+
+func (g *grammar) lookup(name string) *define {
+	d, ok := g.defines[name]
+	if !ok {
+		d = &define{name: name}
+		g.defines[name] = d
+		g.order = append(g.order, d)
+	}
+	return d
+}
+
+// check makes sure everything referred to in g was defined.
+func (g *grammar) check() error {
+	if g.start.p == nil {
+		return fmt.Errorf("relaxng: grammar has no start pattern")
+	}
+	for _, d := range g.order {
+		if d.p == nil {
+			return fmt.Errorf("relaxng: %s is referred to but never defined", d.name)
+		}
+	}
+	return nil
+}
+
+type parser struct {
+	toks       []token
+	pos        int
+	namespaces map[string]string
+	defaultNS  string
+	datatypes  map[string]string
+	g          *grammar
+	grammars   []*grammar
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) peekAt(n int) token {
+	if p.pos+n >= len(p.toks) {
+		return p.toks[len(p.toks)-1]
+	}
+	return p.toks[p.pos+n]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("relaxng: line %d: %s", p.peek().line, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) isPunct(s string) bool {
+	t := p.peek()
+	return t.kind == tokPunct && t.text == s
+}
+
+func (p *parser) isKeyword(s string) bool {
+	t := p.peek()
+	return t.kind == tokIdent && !t.escaped && t.text == s
+}
+
+func (p *parser) expect(s string) error {
+	if !p.isPunct(s) {
+		return p.errorf("expected %s, found %v", s, p.peek())
+	}
+	p.next()
+	return nil
+}
+
+// literal parses a literal, concatenating any ~ separated parts.
+func (p *parser) literal() (string, error) {
+	t := p.next()
+	if t.kind != tokLiteral {
+		return "", p.errorf("expected a literal, found %v", t)
+	}
+	res := t.text
+	for p.isPunct("~") {
+		p.next()
+		t = p.next()
+		if t.kind != tokLiteral {
+			return "", p.errorf("expected a literal after ~, found %v", t)
+		}
+		res += t.text
+	}
+	return res, nil
+}
+
+var keywords = map[string]bool{
+	"attribute": true, "default": true, "datatypes": true, "div": true,
+	"element": true, "empty": true, "external": true, "grammar": true,
+	"include": true, "inherit": true, "list": true, "mixed": true,
+	"namespace": true, "notAllowed": true, "parent": true, "start": true,
+	"string": true, "text": true, "token": true,
+}
+
+func parseCompact(src string) (*pattern, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{
+		toks:       toks,
+		namespaces: map[string]string{"xml": dom.NS_XML},
+		datatypes:  map[string]string{"xsd": dom.NS_XSD},
+	}
+	if err := p.decls(); err != nil {
+		return nil, err
+	}
+	var start *pattern
+	if p.atGrammarContent() {
+		p.g = newGrammar(nil)
+		p.grammars = append(p.grammars, p.g)
+		for p.peek().kind != tokEOF {
+			if err := p.grammarContent(); err != nil {
+				return nil, err
+			}
+		}
+		start = &pattern{kind: refPattern, ref: p.g.start}
+	} else {
+		// A bare pattern is short for a grammar with just a start.
+		p.g = newGrammar(nil)
+		if start, err = p.pattern(); err != nil {
+			return nil, err
+		}
+		if p.peek().kind != tokEOF {
+			return nil, p.errorf("unexpected %v after pattern", p.peek())
+		}
+		p.g.start.p = start
+		p.grammars = append(p.grammars, p.g)
+	}
+	for _, g := range p.grammars {
+		if err := g.check(); err != nil {
+			return nil, err
+		}
+		for _, d := range append([]*define{g.start}, g.order...) {
+			if err := checkCycles(d, map[*define]bool{}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return start, nil
+}
+
+// checkCycles rejects references that lead back to d without passing
+// through an element, which would leave the validator looping forever.
+func checkCycles(d *define, active map[*define]bool) error {
+	if active[d] {
+		return fmt.Errorf("relaxng: %s refers to itself outside of an element", d.name)
+	}
+	active[d] = true
+	defer delete(active, d)
+	var walk func(p *pattern) error
+	walk = func(p *pattern) error {
+		switch p.kind {
+		case refPattern:
+			return checkCycles(p.ref, active)
+		case elementPattern:
+			return nil
+		case dataPattern:
+			if p.except != nil {
+				return walk(p.except)
+			}
+		}
+		for _, c := range []*pattern{p.p1, p.p2} {
+			if c != nil {
+				if err := walk(c); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return walk(d.p)
+}
+
+func (p *parser) decls() error {
+	for {
+		switch {
+		case p.isKeyword("namespace"):
+			p.next()
+			prefix := p.next()
+			if prefix.kind != tokIdent {
+				return p.errorf("expected a namespace prefix, found %v", prefix)
+			}
+			if err := p.expect("="); err != nil {
+				return err
+			}
+			uri, err := p.nsURI()
+			if err != nil {
+				return err
+			}
+			p.namespaces[prefix.text] = uri
+		case p.isKeyword("default"):
+			p.next()
+			if !p.isKeyword("namespace") {
+				return p.errorf("expected namespace after default")
+			}
+			p.next()
+			prefix := ""
+			if p.peek().kind == tokIdent {
+				prefix = p.next().text
+			}
+			if err := p.expect("="); err != nil {
+				return err
+			}
+			uri, err := p.nsURI()
+			if err != nil {
+				return err
+			}
+			p.defaultNS = uri
+			if prefix != "" {
+				p.namespaces[prefix] = uri
+			}
+		case p.isKeyword("datatypes"):
+			p.next()
+			prefix := p.next()
+			if prefix.kind != tokIdent {
+				return p.errorf("expected a datatypes prefix, found %v", prefix)
+			}
+			if err := p.expect("="); err != nil {
+				return err
+			}
+			uri, err := p.literal()
+			if err != nil {
+				return err
+			}
+			p.datatypes[prefix.text] = uri
+		default:
+			return nil
+		}
+	}
+}
+
+func (p *parser) nsURI() (string, error) {
+	if p.isKeyword("inherit") {
+		p.next()
+		return "", nil
+	}
+	return p.literal()
+}
+
+func (p *parser) isAssign(t token) bool {
+	return t.kind == tokPunct && (t.text == "=" || t.text == "|=" || t.text == "&=")
+}
+
+func (p *parser) atGrammarContent() bool {
+	t := p.peek()
+	if t.kind != tokIdent {
+		return false
+	}
+	if !t.escaped && (t.text == "start" || t.text == "div" || t.text == "include") {
+		return true
+	}
+	return (t.escaped || !keywords[t.text]) && p.isAssign(p.peekAt(1))
+}
+
+func (p *parser) grammarContent() error {
+	switch {
+	case p.isKeyword("div"):
+		p.next()
+		if err := p.expect("{"); err != nil {
+			return err
+		}
+		for !p.isPunct("}") {
+			if p.peek().kind == tokEOF {
+				return p.errorf("unterminated div")
+			}
+			if err := p.grammarContent(); err != nil {
+				return err
+			}
+		}
+		p.next()
+		return nil
+	case p.isKeyword("include"):
+		return p.errorf("include is not supported")
+	case p.isKeyword("start"):
+		p.next()
+		return p.definition(p.g.start)
+	case p.peek().kind == tokIdent && (p.peek().escaped || !keywords[p.peek().text]):
+		return p.definition(p.g.lookup(p.next().text))
+	}
+	return p.errorf("expected a definition, found %v", p.peek())
+}
+
+func (p *parser) definition(d *define) error {
+	op := p.next()
+	if !p.isAssign(op) {
+		return p.errorf("expected =, |= or &= after %s", d.name)
+	}
+	body, err := p.pattern()
+	if err != nil {
+		return err
+	}
+	switch {
+	case d.p == nil:
+		d.p = body
+		if op.text != "=" {
+			d.combine = op.text
+		}
+	case op.text == "=":
+		return p.errorf("%s is defined more than once", d.name)
+	case d.combine != "" && d.combine != op.text:
+		return p.errorf("%s is combined with both |= and &=", d.name)
+	case op.text == "|=":
+		d.p = choice(d.p, body)
+		d.combine = op.text
+	default:
+		d.p = interleave(d.p, body)
+		d.combine = op.text
+	}
+	return nil
+}
+
+func (p *parser) pattern() (*pattern, error) {
+	res, err := p.particle()
+	if err != nil {
+		return nil, err
+	}
+	op := ""
+	for p.isPunct(",") || p.isPunct("|") || p.isPunct("&") {
+		t := p.next()
+		if op != "" && t.text != op {
+			return nil, p.errorf("cannot mix %s and %s without parentheses", op, t.text)
+		}
+		op = t.text
+		next, err := p.particle()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case ",":
+			res = &pattern{kind: groupPattern, p1: res, p2: next}
+		case "|":
+			res = &pattern{kind: choicePattern, p1: res, p2: next}
+		default:
+			res = &pattern{kind: interleavePattern, p1: res, p2: next}
+		}
+	}
+	return res, nil
+}
+
+func (p *parser) particle() (*pattern, error) {
+	res, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case p.isPunct("?"):
+		p.next()
+		return &pattern{kind: choicePattern, p1: res, p2: empty}, nil
+	case p.isPunct("*"):
+		p.next()
+		return &pattern{kind: choicePattern, p1: &pattern{kind: oneOrMorePattern, p1: res}, p2: empty}, nil
+	case p.isPunct("+"):
+		p.next()
+		return &pattern{kind: oneOrMorePattern, p1: res}, nil
+	}
+	return res, nil
+}
+
+// braced parses { pattern }.
+func (p *parser) braced() (*pattern, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	res, err := p.pattern()
+	if err != nil {
+		return nil, err
+	}
+	return res, p.expect("}")
+}
+
+func (p *parser) primary() (*pattern, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokPunct && t.text == "(":
+		p.next()
+		res, err := p.pattern()
+		if err != nil {
+			return nil, err
+		}
+		return res, p.expect(")")
+	case t.kind == tokLiteral:
+		v, err := p.literal()
+		if err != nil {
+			return nil, err
+		}
+		return &pattern{kind: valuePattern, dt: tokenType{}, value: v}, nil
+	case t.kind == tokCName:
+		return p.data()
+	case t.kind != tokIdent:
+		return nil, p.errorf("expected a pattern, found %v", t)
+	case t.escaped || !keywords[t.text]:
+		p.next()
+		return &pattern{kind: refPattern, ref: p.g.lookup(t.text)}, nil
+	}
+	switch t.text {
+	case "element", "attribute":
+		p.next()
+		nc, err := p.nameClass(t.text == "element")
+		if err != nil {
+			return nil, err
+		}
+		body, err := p.braced()
+		if err != nil {
+			return nil, err
+		}
+		kind := elementPattern
+		if t.text == "attribute" {
+			kind = attributePattern
+		}
+		return &pattern{kind: kind, nc: nc, p1: body}, nil
+	case "list":
+		p.next()
+		body, err := p.braced()
+		if err != nil {
+			return nil, err
+		}
+		return &pattern{kind: listPattern, p1: body}, nil
+	case "mixed":
+		p.next()
+		body, err := p.braced()
+		if err != nil {
+			return nil, err
+		}
+		return &pattern{kind: interleavePattern, p1: body, p2: text}, nil
+	case "empty":
+		p.next()
+		return empty, nil
+	case "text":
+		p.next()
+		return text, nil
+	case "notAllowed":
+		p.next()
+		return notAllowed, nil
+	case "parent":
+		p.next()
+		name := p.next()
+		if name.kind != tokIdent {
+			return nil, p.errorf("expected a name after parent, found %v", name)
+		}
+		if p.g.parent == nil {
+			return nil, p.errorf("parent %s used outside of a nested grammar", name.text)
+		}
+		return &pattern{kind: refPattern, ref: p.g.parent.lookup(name.text)}, nil
+	case "grammar":
+		p.next()
+		if err := p.expect("{"); err != nil {
+			return nil, err
+		}
+		p.g = newGrammar(p.g)
+		p.grammars = append(p.grammars, p.g)
+		for !p.isPunct("}") {
+			if p.peek().kind == tokEOF {
+				return nil, p.errorf("unterminated grammar")
+			}
+			if err := p.grammarContent(); err != nil {
+				return nil, err
+			}
+		}
+		p.next()
+		res := &pattern{kind: refPattern, ref: p.g.start}
+		p.g = p.g.parent
+		return res, nil
+	case "string", "token":
+		return p.data()
+	case "external":
+		return nil, p.errorf("external is not supported")
+	}
+	return nil, p.errorf("unexpected keyword %s", t.text)
+}
+
+// data parses a datatype pattern or a typed value.
+func (p *parser) data() (*pattern, error) {
+	t := p.next()
+	var dt datatype
+	var xsdName string
+	switch t.text {
+	case "string":
+		dt = stringType{}
+	case "token":
+		dt = tokenType{}
+	default:
+		i := strings.IndexByte(t.text, ':')
+		lib, ok := p.datatypes[t.text[:i]]
+		if !ok {
+			return nil, p.errorf("datatypes prefix %s is not declared", t.text[:i])
+		}
+		if lib != dom.NS_XSD {
+			return nil, p.errorf("datatype library %s is not supported", lib)
+		}
+		xsdName = t.text[i+1:]
+	}
+	if p.peek().kind == tokLiteral {
+		v, err := p.literal()
+		if err != nil {
+			return nil, err
+		}
+		if dt == nil {
+			x, err := schema.NewDatatype(xsdName)
+			if err != nil {
+				return nil, p.errorf("%v", err)
+			}
+			if err := x.Validate(v); err != nil {
+				return nil, p.errorf("%q is not a valid %s: %v", v, xsdName, err)
+			}
+			dt = xsdType{x}
+		}
+		return &pattern{kind: valuePattern, dt: dt, value: v}, nil
+	}
+	facets := []schema.Facet{}
+	if p.isPunct("{") {
+		p.next()
+		for !p.isPunct("}") {
+			name := p.next()
+			if name.kind != tokIdent {
+				return nil, p.errorf("expected a parameter name, found %v", name)
+			}
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+			v, err := p.literal()
+			if err != nil {
+				return nil, err
+			}
+			facets = append(facets, schema.Facet{Name: name.text, Value: v})
+		}
+		p.next()
+	}
+	if dt == nil {
+		x, err := schema.NewDatatype(xsdName, facets...)
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		dt = xsdType{x}
+	} else if len(facets) > 0 {
+		return nil, p.errorf("%s does not take parameters", t.text)
+	}
+	res := &pattern{kind: dataPattern, dt: dt}
+	if p.isPunct("-") {
+		p.next()
+		except, err := p.primary()
+		if err != nil {
+			return nil, err
+		}
+		res.except = except
+	}
+	return res, nil
+}
+
+func (p *parser) nameClass(element bool) (*nameClass, error) {
+	res, err := p.basicNameClass(element)
+	if err != nil {
+		return nil, err
+	}
+	for p.isPunct("|") {
+		p.next()
+		next, err := p.basicNameClass(element)
+		if err != nil {
+			return nil, err
+		}
+		res = &nameClass{kind: ncChoice, c1: res, c2: next}
+	}
+	return res, nil
+}
+
+func (p *parser) prefix(prefix string) (string, error) {
+	ns, ok := p.namespaces[prefix]
+	if !ok {
+		return "", p.errorf("namespace prefix %s is not declared", prefix)
+	}
+	return ns, nil
+}
+
+func (p *parser) basicNameClass(element bool) (*nameClass, error) {
+	t := p.next()
+	var res *nameClass
+	switch {
+	case t.kind == tokIdent:
+		n := xml.Name{Local: t.text}
+		if element {
+			n.Space = p.defaultNS
+		}
+		return &nameClass{kind: simpleName, name: n}, nil
+	case t.kind == tokCName:
+		i := strings.IndexByte(t.text, ':')
+		ns, err := p.prefix(t.text[:i])
+		if err != nil {
+			return nil, err
+		}
+		return &nameClass{kind: simpleName, name: xml.Name{Space: ns, Local: t.text[i+1:]}}, nil
+	case t.kind == tokNsName:
+		ns, err := p.prefix(t.text)
+		if err != nil {
+			return nil, err
+		}
+		res = &nameClass{kind: nsName, name: xml.Name{Space: ns}}
+	case t.kind == tokPunct && t.text == "*":
+		res = &nameClass{kind: anyName}
+	case t.kind == tokPunct && t.text == "(":
+		res, err := p.nameClass(element)
+		if err != nil {
+			return nil, err
+		}
+		return res, p.expect(")")
+	default:
+		return nil, p.errorf("expected a name class, found %v", t)
+	}
+	if p.isPunct("-") {
+		p.next()
+		except, err := p.basicNameClass(element)
+		if err != nil {
+			return nil, err
+		}
+		res.except = except
+	}
+	return res, nil
+}
@@ -0,0 +1,318 @@
+package relaxng
+
+import (
+	"encoding/xml"
+	"sort"
+	"strings"
+)
+
+// The validator is the derivative algorithm from James Clark's "An
+// algorithm for RELAX NG validation".
+
+type patternKind int
+
+const (
+	emptyPattern patternKind = iota
+	notAllowedPattern
+	textPattern
+	choicePattern
+	interleavePattern
+	groupPattern
+	oneOrMorePattern
+	listPattern
+	dataPattern
+	valuePattern
+	attributePattern
+	elementPattern
+	afterPattern
+	refPattern
+)
+
+type pattern struct {
+	kind   patternKind
+	p1, p2 *pattern
+	nc     *nameClass
+	dt     datatype
+	value  string
+	// except is the excepted pattern of a data pattern.
+	except *pattern
+	ref    *define
+}
+
+type define struct {
+	name    string
+	p       *pattern
+	combine string
+}
+
+var (
+	empty      = &pattern{kind: emptyPattern}
+	notAllowed = &pattern{kind: notAllowedPattern}
+	text       = &pattern{kind: textPattern}
+)
+
+func deref(p *pattern) *pattern {
+	for p.kind == refPattern {
+		p = p.ref.p
+	}
+	return p
+}
+
+func choice(p1, p2 *pattern) *pattern {
+	switch {
+	case p1.kind == notAllowedPattern:
+		return p2
+	case p2.kind == notAllowedPattern:
+		return p1
+	case p1 == p2:
+		return p1
+	case p1.kind == emptyPattern && p2.kind == emptyPattern:
+		return p1
+	}
+	return &pattern{kind: choicePattern, p1: p1, p2: p2}
+}
+
+func group(p1, p2 *pattern) *pattern {
+	switch {
+	case p1.kind == notAllowedPattern || p2.kind == notAllowedPattern:
+		return notAllowed
+	case p1.kind == emptyPattern:
+		return p2
+	case p2.kind == emptyPattern:
+		return p1
+	}
+	return &pattern{kind: groupPattern, p1: p1, p2: p2}
+}
+
+func interleave(p1, p2 *pattern) *pattern {
+	switch {
+	case p1.kind == notAllowedPattern || p2.kind == notAllowedPattern:
+		return notAllowed
+	case p1.kind == emptyPattern:
+		return p2
+	case p2.kind == emptyPattern:
+		return p1
+	}
+	return &pattern{kind: interleavePattern, p1: p1, p2: p2}
+}
+
+func after(p1, p2 *pattern) *pattern {
+	if p1.kind == notAllowedPattern || p2.kind == notAllowedPattern {
+		return notAllowed
+	}
+	return &pattern{kind: afterPattern, p1: p1, p2: p2}
+}
+
+func oneOrMore(p *pattern) *pattern {
+	if p.kind == notAllowedPattern || p.kind == emptyPattern {
+		return p
+	}
+	return &pattern{kind: oneOrMorePattern, p1: p}
+}
+
+func nullable(p *pattern) bool {
+	p = deref(p)
+	switch p.kind {
+	case groupPattern, interleavePattern:
+		return nullable(p.p1) && nullable(p.p2)
+	case choicePattern:
+		return nullable(p.p1) || nullable(p.p2)
+	case oneOrMorePattern:
+		return nullable(p.p1)
+	case emptyPattern, textPattern:
+		return true
+	}
+	return false
+}
+
+func isWhitespace(s string) bool {
+	return strings.TrimSpace(s) == ""
+}
+
+func textDeriv(p *pattern, s string) *pattern {
+	p = deref(p)
+	switch p.kind {
+	case choicePattern:
+		return choice(textDeriv(p.p1, s), textDeriv(p.p2, s))
+	case interleavePattern:
+		return choice(interleave(textDeriv(p.p1, s), p.p2), interleave(p.p1, textDeriv(p.p2, s)))
+	case groupPattern:
+		res := group(textDeriv(p.p1, s), p.p2)
+		if nullable(p.p1) {
+			return choice(res, textDeriv(p.p2, s))
+		}
+		return res
+	case afterPattern:
+		return after(textDeriv(p.p1, s), p.p2)
+	case oneOrMorePattern:
+		return group(textDeriv(p.p1, s), choice(oneOrMore(p.p1), empty))
+	case textPattern:
+		return p
+	case valuePattern:
+		if p.dt.equal(p.value, s) {
+			return empty
+		}
+	case dataPattern:
+		if p.dt.allows(s) && (p.except == nil || !nullable(textDeriv(p.except, s))) {
+			return empty
+		}
+	case listPattern:
+		res := p.p1
+		for _, word := range strings.Fields(s) {
+			res = textDeriv(res, word)
+		}
+		if nullable(res) {
+			return empty
+		}
+	}
+	return notAllowed
+}
+
+func applyAfter(f func(*pattern) *pattern, p *pattern) *pattern {
+	switch p.kind {
+	case afterPattern:
+		return after(p.p1, f(p.p2))
+	case choicePattern:
+		return choice(applyAfter(f, p.p1), applyAfter(f, p.p2))
+	}
+	return notAllowed
+}
+
+func startTagOpenDeriv(p *pattern, name xml.Name) *pattern {
+	p = deref(p)
+	switch p.kind {
+	case choicePattern:
+		return choice(startTagOpenDeriv(p.p1, name), startTagOpenDeriv(p.p2, name))
+	case elementPattern:
+		if p.nc.contains(name) {
+			return after(p.p1, empty)
+		}
+	case interleavePattern:
+		return choice(
+			applyAfter(func(x *pattern) *pattern { return interleave(x, p.p2) }, startTagOpenDeriv(p.p1, name)),
+			applyAfter(func(x *pattern) *pattern { return interleave(p.p1, x) }, startTagOpenDeriv(p.p2, name)))
+	case oneOrMorePattern:
+		return applyAfter(func(x *pattern) *pattern {
+			return group(x, choice(oneOrMore(p.p1), empty))
+		}, startTagOpenDeriv(p.p1, name))
+	case groupPattern:
+		res := applyAfter(func(x *pattern) *pattern { return group(x, p.p2) }, startTagOpenDeriv(p.p1, name))
+		if nullable(p.p1) {
+			return choice(res, startTagOpenDeriv(p.p2, name))
+		}
+		return res
+	case afterPattern:
+		return applyAfter(func(x *pattern) *pattern { return after(x, p.p2) }, startTagOpenDeriv(p.p1, name))
+	}
+	return notAllowed
+}
+
+func valueMatch(p *pattern, s string) bool {
+	return (nullable(p) && isWhitespace(s)) || nullable(textDeriv(p, s))
+}
+
+// attDeriv is the derivative of p with respect to the attribute a.  If
+// anyValue is set, attribute patterns match a whatever its value.
+func attDeriv(p *pattern, a xml.Attr, anyValue bool) *pattern {
+	p = deref(p)
+	switch p.kind {
+	case afterPattern:
+		return after(attDeriv(p.p1, a, anyValue), p.p2)
+	case choicePattern:
+		return choice(attDeriv(p.p1, a, anyValue), attDeriv(p.p2, a, anyValue))
+	case groupPattern:
+		return choice(group(attDeriv(p.p1, a, anyValue), p.p2), group(p.p1, attDeriv(p.p2, a, anyValue)))
+	case interleavePattern:
+		return choice(interleave(attDeriv(p.p1, a, anyValue), p.p2), interleave(p.p1, attDeriv(p.p2, a, anyValue)))
+	case oneOrMorePattern:
+		return group(attDeriv(p.p1, a, anyValue), choice(oneOrMore(p.p1), empty))
+	case attributePattern:
+		if p.nc.contains(a.Name) && (anyValue || valueMatch(p.p1, a.Value)) {
+			return empty
+		}
+	}
+	return notAllowed
+}
+
+func startTagCloseDeriv(p *pattern) *pattern {
+	p = deref(p)
+	switch p.kind {
+	case afterPattern:
+		return after(startTagCloseDeriv(p.p1), p.p2)
+	case choicePattern:
+		return choice(startTagCloseDeriv(p.p1), startTagCloseDeriv(p.p2))
+	case groupPattern:
+		return group(startTagCloseDeriv(p.p1), startTagCloseDeriv(p.p2))
+	case interleavePattern:
+		return interleave(startTagCloseDeriv(p.p1), startTagCloseDeriv(p.p2))
+	case oneOrMorePattern:
+		return oneOrMore(startTagCloseDeriv(p.p1))
+	case attributePattern:
+		return notAllowed
+	}
+	return p
+}
+
+func endTagDeriv(p *pattern) *pattern {
+	switch p.kind {
+	case choicePattern:
+		return choice(endTagDeriv(p.p1), endTagDeriv(p.p2))
+	case afterPattern:
+		if nullable(p.p1) {
+			return p.p2
+		}
+	}
+	return notAllowed
+}
+
+// tails returns the patterns that follow the current element in p, the
+// result of startTagOpenDeriv.  It is used to carry on validating after
+// an element that did not match.
+func tails(p *pattern) *pattern {
+	switch p.kind {
+	case choicePattern:
+		return choice(tails(p.p1), tails(p.p2))
+	case afterPattern:
+		return p.p2
+	}
+	return notAllowed
+}
+
+// expected describes the elements and attributes that p could match
+// next, for error messages.
+func expected(p *pattern, what patternKind) string {
+	names := map[string]bool{}
+	seen := map[*pattern]bool{}
+	var walk func(p *pattern)
+	walk = func(p *pattern) {
+		p = deref(p)
+		if seen[p] {
+			return
+		}
+		seen[p] = true
+		switch p.kind {
+		case elementPattern, attributePattern:
+			if p.kind == what {
+				names[p.nc.String()] = true
+			}
+		case choicePattern, interleavePattern:
+			walk(p.p1)
+			walk(p.p2)
+		case groupPattern:
+			// Attributes are not ordered.
+			walk(p.p1)
+			if what == attributePattern || nullable(p.p1) {
+				walk(p.p2)
+			}
+		case oneOrMorePattern, afterPattern:
+			walk(p.p1)
+		}
+	}
+	walk(p)
+	res := []string{}
+	for n := range names {
+		res = append(res, n)
+	}
+	sort.Strings(res)
+	return strings.Join(res, ", ")
+}
@@ -0,0 +1,199 @@
+// Package relaxng validates simplexml/dom trees against RELAX NG schemas
+// written in the compact syntax.
+//
+// The whole of the compact syntax is understood except for include and
+// external, which would need other files to be read.  Datatypes can come
+// from the built-in library (string and token) or from the XML Schema
+// datatypes, with parameters as facets.  Annotations are ignored.
+//
+// Since dom Elements keep their text content in one place, text is
+// treated as if it came before any child elements when matching.
+//
+// For some basic usage examples, see relaxng_test.go
+package relaxng
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+// Schema is a compiled RELAX NG schema.  A Schema is safe for concurrent
+// use.
+type Schema struct {
+	start *pattern
+}
+
+// ParseCompact reads a schema in the compact syntax from r and compiles it.
+func ParseCompact(r io.Reader) (*Schema, error) {
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return CompileCompact(string(buf))
+}
+
+// CompileCompact compiles a schema in the compact syntax.
+func CompileCompact(src string) (*Schema, error) {
+	start, err := parseCompact(src)
+	if err != nil {
+		return nil, err
+	}
+	return &Schema{start: start}, nil
+}
+
+// MustCompileCompact is like CompileCompact, but panics if src cannot be
+// compiled.
+func MustCompileCompact(src string) *Schema {
+	s, err := CompileCompact(src)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Violation describes one way in which a tree does not conform to a
+// Schema.
+type Violation struct {
+	// Element is the element the violation was found on.
+	Element *dom.Element
+	// Pos is where Element was found in its source document, if it was
+	// parsed.
+	Pos dom.Position
+	// Path is a /-separated path to Element from the top of its tree.
+	Path    string
+	Message string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%v: %s: %s", v.Pos, v.Path, v.Message)
+}
+
+// Error makes a Violation usable as an error.
+func (v Violation) Error() string {
+	return v.String()
+}
+
+// Validate validates doc against s, and returns the violations it found.
+// A nil return means doc is valid.
+func (s *Schema) Validate(doc *dom.Document) []Violation {
+	if doc.Root() == nil {
+		return []Violation{{Path: "/", Message: "document has no root element"}}
+	}
+	return s.ValidateElement(doc.Root())
+}
+
+// ValidateElement validates the subtree rooted at e against s as if e
+// was the root of a document.
+func (s *Schema) ValidateElement(e *dom.Element) []Violation {
+	v := &validator{}
+	if rest := v.element(s.start, e); !nullable(rest) {
+		v.report(e, "document is incomplete")
+	}
+	return v.violations
+}
+
+type validator struct {
+	violations []Violation
+}
+
+func (v *validator) report(e *dom.Element, format string, args ...interface{}) {
+	v.violations = append(v.violations, Violation{
+		Element: e,
+		Pos:     e.Pos(),
+		Path:    e.Path(),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func fmtName(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return "{" + n.Space + "}" + n.Local
+}
+
+func isNamespaceDecl(a xml.Attr) bool {
+	return a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns")
+}
+
+func describe(names string) string {
+	if names == "" {
+		return "nothing"
+	}
+	return names
+}
+
+// element matches e against p and returns what p allows after it.  When
+// e does not match, it reports why and carries on as well as it can, so
+// that one mistake does not hide all the others.
+func (v *validator) element(p *pattern, e *dom.Element) *pattern {
+	open := startTagOpenDeriv(p, e.Name)
+	if open.kind == notAllowedPattern {
+		v.report(e, "element %s is not allowed here, expected %s",
+			fmtName(e.Name), describe(expected(p, elementPattern)))
+		return p
+	}
+	rest := tails(open)
+	cur := open
+	for _, a := range e.Attributes {
+		if isNamespaceDecl(a) {
+			continue
+		}
+		next := attDeriv(cur, a, false)
+		if next.kind == notAllowedPattern {
+			if next = attDeriv(cur, a, true); next.kind == notAllowedPattern {
+				v.report(e, "attribute %s is not allowed", fmtName(a.Name))
+				continue
+			}
+			v.report(e, "attribute %s has an invalid value %q", fmtName(a.Name), a.Value)
+		}
+		cur = next
+	}
+	closed := startTagCloseDeriv(cur)
+	if closed.kind == notAllowedPattern {
+		v.report(e, "element %s is missing required attributes %s",
+			fmtName(e.Name), describe(expected(cur, attributePattern)))
+		return rest
+	}
+	cur = closed
+	children := e.Children()
+	content := string(e.Content)
+	switch {
+	case len(children) == 0:
+		// A lone text node that is only whitespace may also be ignored.
+		next := textDeriv(cur, content)
+		if isWhitespace(content) {
+			next = choice(cur, next)
+		}
+		if next.kind == notAllowedPattern {
+			if len(content) == 0 {
+				v.report(e, "element %s is missing content, expected %s",
+					fmtName(e.Name), describe(expected(cur, elementPattern)))
+			} else {
+				v.report(e, "invalid content %q", content)
+			}
+			return rest
+		}
+		cur = next
+	case !isWhitespace(content):
+		next := textDeriv(cur, content)
+		if next.kind == notAllowedPattern {
+			v.report(e, "text is not allowed in element %s", fmtName(e.Name))
+		} else {
+			cur = next
+		}
+	}
+	for _, c := range children {
+		cur = v.element(cur, c)
+	}
+	end := endTagDeriv(cur)
+	if end.kind == notAllowedPattern {
+		v.report(e, "element %s is incomplete, expected %s",
+			fmtName(e.Name), describe(expected(cur, elementPattern)))
+		return rest
+	}
+	return end
+}
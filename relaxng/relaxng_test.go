@@ -0,0 +1,162 @@
+package relaxng
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+var testSchema = `
+# A cut down Atom feed.
+default namespace atom = "http://www.w3.org/2005/Atom"
+namespace local = ""
+
+start = feed
+
+## The feed itself.
+feed = element feed {
+  attribute version { "1.0" | "2.0" }?,
+  (element id { xsd:anyURI }
+   & element title { text }
+   & element updated { xsd:dateTime }),
+  entry*
+}
+
+entry = [ a:doc = "an entry" ] element entry {
+  attribute local:priority { xsd:int { minInclusive = "1" maxInclusive = "5" } }?,
+  element id { xsd:anyURI },
+  element title { text },
+  element category { attribute term { token - "draft" } }*,
+  element content { mixed { element b { text }* } }?,
+  element tags { list { xsd:NCName+ } }?,
+  anyForeign*
+}
+
+anyForeign = element * - atom:* { anyAttribute*, (text | anyForeign)* }
+anyAttribute |= attribute * { text }
+`
+
+var validFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom" version="2.0">
+ <title>Example</title>
+ <updated>2013-04-01T12:00:00Z</updated>
+ <id>urn:feed</id>
+ <entry priority="3">
+  <id>urn:entry:1</id>
+  <title>One</title>
+  <category term="go"/>
+  <category term="xml"/>
+  <content>Some <b>bold</b> text</content>
+  <tags>go xml dom</tags>
+  <x:extra xmlns:x="urn:x" x:a="1">foo<x:more/></x:extra>
+ </entry>
+ <entry>
+  <id>urn:entry:2</id>
+  <title>Two</title>
+ </entry>
+</feed>
+`
+
+const atomNS = "{http://www.w3.org/2005/Atom}"
+
+func validate(t *testing.T, s *Schema, src string) []Violation {
+	doc, err := dom.Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("Cannot parse instance: %v", err)
+	}
+	return s.Validate(doc)
+}
+
+func TestValid(t *testing.T) {
+	s, err := ParseCompact(strings.NewReader(testSchema))
+	if err != nil {
+		t.Fatalf("Cannot compile test schema: %v", err)
+	}
+	if vs := validate(t, s, validFeed); len(vs) != 0 {
+		t.Errorf("Expected valid document, got %v", vs)
+	}
+}
+
+func TestViolations(t *testing.T) {
+	s := MustCompileCompact(testSchema)
+	tests := []struct {
+		from, to string
+		path     string
+		message  string
+	}{
+		{`version="2.0"`, `version="3.0"`, "/feed", `attribute version has an invalid value "3.0"`},
+		{`version="2.0"`, `colour="red"`, "/feed", "attribute colour is not allowed"},
+		{`<title>Example</title>`, ``, "/feed/entry[1]", "element " + atomNS + "entry is not allowed here, expected " + atomNS + "title"},
+		{`<updated>2013-04-01T12:00:00Z</updated>`, `<updated>yesterday</updated>`, "/feed/updated", `invalid content "yesterday"`},
+		{`priority="3"`, `priority="9"`, "/feed/entry[1]", `attribute priority has an invalid value "9"`},
+		{`<category term="go"/>`, `<category term="draft"/>`, "/feed/entry[1]/category[1]", "invalid value"},
+		{`<category term="go"/>`, `<category/>`, "/feed/entry[1]/category[1]", "missing required attributes term"},
+		{`<tags>go xml dom</tags>`, `<tags>go 1x</tags>`, "/feed/entry[1]/tags", "invalid content"},
+		{`<content>Some <b>bold</b> text</content>`, `<content>Some <i>bold</i></content>`, "/feed/entry[1]/content/i", "element " + atomNS + "i is not allowed here"},
+		{`<title>Two</title>`, ``, "/feed/entry[2]", "element " + atomNS + "entry is incomplete, expected " + atomNS + "title"},
+		{`<title>Two</title>`, `<title>Two</title><id>again</id>`, "/feed/entry[2]/id[2]", "is not allowed here"},
+		{`<x:extra xmlns:x="urn:x"`, `<x:extra xmlns:x="http://www.w3.org/2005/Atom"`, "/feed/entry[1]/extra", "is not allowed here"},
+		{`<id>urn:entry:2</id>`, `<id>urn:entry:2<b/></id>`, "/feed/entry[2]/id/b", "is not allowed here, expected nothing"},
+	}
+	for _, test := range tests {
+		src := strings.Replace(validFeed, test.from, test.to, 1)
+		// Later violations may be knock-on effects of the first.
+		vs := validate(t, s, src)
+		if len(vs) == 0 {
+			t.Errorf("Replacing %q with %q: expected a violation", test.from, test.to)
+			continue
+		}
+		if vs[0].Path != test.path || !strings.Contains(vs[0].Message, test.message) {
+			t.Errorf("Replacing %q with %q: expected %s: %s, got %s: %s",
+				test.from, test.to, test.path, test.message, vs[0].Path, vs[0].Message)
+		}
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	s := MustCompileCompact(testSchema)
+	src := strings.Replace(validFeed, `<category term="go"/>`, `<category/>`, 1)
+	src = strings.Replace(src, `<title>Two</title>`, `<title>Two</title><bogus/>`, 1)
+	vs := validate(t, s, src)
+	if len(vs) != 2 {
+		t.Fatalf("Expected both mistakes to be reported, got %v", vs)
+	}
+	if vs[0].Path != "/feed/entry[1]/category[1]" || vs[1].Path != "/feed/entry[2]/bogus" {
+		t.Errorf("Unexpected violations %v", vs)
+	}
+}
+
+func TestPattern(t *testing.T) {
+	// A schema can be a bare pattern.
+	s := MustCompileCompact(`element doc { attribute n { xsd:integer }, (element a { empty } | element b { string "x" })+ }`)
+	for src, ok := range map[string]bool{
+		`<doc n="1"><a/><b>x</b><a/></doc>`: true,
+		`<doc n="1"><b>y</b></doc>`:         false,
+		`<doc n="1"/>`:                      false,
+		`<doc n="x"><a/></doc>`:             false,
+		`<doc><a/></doc>`:                   false,
+	} {
+		if vs := validate(t, s, src); (len(vs) == 0) != ok {
+			t.Errorf("%s: expected valid=%v, got %v", src, ok, vs)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, src := range []string{
+		`element a { b }`,
+		`start = a a = a | element b { empty }`,
+		`start = element a { empty } start = element b { empty }`,
+		`element a { empty , text | empty }`,
+		`element p:a { empty }`,
+		`element a { xsd:nonsense }`,
+		`element a { xsd:int { bogus = "1" } }`,
+		`include "other.rnc"`,
+		`element a { "unterminated }`,
+	} {
+		if _, err := CompileCompact(src); err == nil {
+			t.Errorf("Expected %q to fail to compile", src)
+		}
+	}
+}
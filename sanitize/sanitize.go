@@ -0,0 +1,198 @@
+// Package sanitize filters simplexml/dom trees against an allowlist, so
+// that user-supplied XML or XHTML fragments can be embedded safely.
+//
+// A Policy names the elements that are allowed and the attributes that
+// are allowed on each of them.  Everything else is dropped, unwrapped or
+// escaped, as the Policy says, and attributes holding URLs are only kept
+// if their scheme is one the Policy allows.
+//
+// For some basic usage examples, see sanitize_test.go
+package sanitize
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+// Action is what happens to an element a Policy does not allow.
+type Action int
+
+const (
+	// Drop removes the element along with everything in it.
+	Drop Action = iota
+	// Unwrap removes the element but keeps what is in it: its children
+	// take its place in its parent, and its Content is added to the
+	// end of its parent's.
+	Unwrap
+	// Escape replaces the element with its markup, which is added to
+	// the end of its parent's Content as text.
+	Escape
+)
+
+// Policy says what is allowed in a sanitized tree.  Names are local
+// names, and matched without regard to case.
+type Policy struct {
+	// Elements maps the names of allowed elements to the names of the
+	// attributes allowed on them, besides the ones in Attrs.
+	Elements map[string][]string
+	// Attrs are allowed on every allowed element.  An attribute in the
+	// XML namespace, such as xml:lang, is named here with the xml:
+	// prefix.  Attributes in other namespaces are never allowed.
+	Attrs []string
+	// Namespaces are the namespaces allowed elements may be in, such as
+	// the XHTML one.  Elements without a namespace are always allowed.
+	Namespaces []string
+	// URLAttrs are the attributes whose values are URLs.  They default
+	// to href, src, cite and action.
+	URLAttrs []string
+	// Schemes are the schemes URLs may have.  They default to http,
+	// https and mailto.  Relative URLs are always allowed.
+	Schemes []string
+	// Action is what happens to elements that are not allowed.
+	Action Action
+	// Remove names elements that are always dropped with everything in
+	// them, whatever Action says, such as script and style, whose
+	// content is no use outside them.
+	Remove []string
+}
+
+func has(list []string, name string) bool {
+	for _, s := range list {
+		if strings.EqualFold(s, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) urlAttrs() []string {
+	if p.URLAttrs == nil {
+		return []string{"href", "src", "cite", "action"}
+	}
+	return p.URLAttrs
+}
+
+func (p *Policy) schemes() []string {
+	if p.Schemes == nil {
+		return []string{"http", "https", "mailto"}
+	}
+	return p.Schemes
+}
+
+// allowedAttrs returns the attributes allowed on e, and whether e is
+// allowed at all.
+func (p *Policy) allowedAttrs(e *dom.Element) ([]string, bool) {
+	if e.Name.Space != "" && !has(p.Namespaces, e.Name.Space) {
+		return nil, false
+	}
+	for name, attrs := range p.Elements {
+		if strings.EqualFold(name, e.Name.Local) {
+			return attrs, true
+		}
+	}
+	return nil, false
+}
+
+// Sanitize filters the tree rooted at e in place.  e itself is checked
+// like every other element, but has no parent to be dropped from or
+// unwrapped into, so if it is not allowed Sanitize returns false and e
+// should not be used, though what is in it is still sanitized.
+func (p *Policy) Sanitize(e *dom.Element) bool {
+	attrs, ok := p.allowedAttrs(e)
+	if ok {
+		p.filterAttrs(e, attrs)
+	}
+	p.children(e)
+	return ok
+}
+
+// children sanitizes the children of e.
+func (p *Policy) children(e *dom.Element) {
+	for i := 0; i < e.NumChildren(); {
+		c := e.Child(i)
+		attrs, ok := p.allowedAttrs(c)
+		if ok {
+			p.filterAttrs(c, attrs)
+			p.children(c)
+			i++
+			continue
+		}
+		action := p.Action
+		if has(p.Remove, c.Name.Local) {
+			action = Drop
+		}
+		switch action {
+		case Unwrap:
+			// The children of c are checked once they are in e.
+			e.ReplaceChild(c, c.Children()...)
+			if len(c.Content) > 0 {
+				e.Content = append(e.Content, c.Content...)
+			}
+		case Escape:
+			markup, err := c.StringWith()
+			if err == nil {
+				e.Content = append(e.Content, markup...)
+			}
+			e.RemoveChild(c)
+		default:
+			e.RemoveChild(c)
+		}
+	}
+}
+
+// filterAttrs drops the attributes of e that are not in allowed or
+// Attrs, and URL attributes with schemes that are not allowed.
+func (p *Policy) filterAttrs(e *dom.Element, allowed []string) {
+	kept := []xml.Attr{}
+	for _, a := range e.Attributes {
+		name := a.Name.Local
+		switch a.Name.Space {
+		case "":
+		case dom.NS_XML:
+			name = "xml:" + name
+		case "xmlns":
+			if has(p.Namespaces, a.Value) {
+				kept = append(kept, a)
+			}
+			continue
+		default:
+			continue
+		}
+		if name == "xmlns" {
+			// A default namespace declaration.
+			if has(p.Namespaces, a.Value) {
+				kept = append(kept, a)
+			}
+			continue
+		}
+		if !has(allowed, name) && !has(p.Attrs, name) {
+			continue
+		}
+		if has(p.urlAttrs(), name) && !p.allowedURL(a.Value) {
+			continue
+		}
+		kept = append(kept, a)
+	}
+	if len(kept) != len(e.Attributes) {
+		e.Attributes = kept
+	}
+}
+
+// allowedURL reports whether u is relative or has one of the allowed
+// schemes.  Whitespace and control characters are ignored, as browsers
+// ignore them, so that "java\tscript:" is caught too.
+func (p *Policy) allowedURL(u string) bool {
+	u = strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, u)
+	end := strings.IndexAny(u, ":/?#")
+	if end < 0 || u[end] != ':' {
+		return true
+	}
+	return has(p.schemes(), u[:end])
+}
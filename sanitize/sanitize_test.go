@@ -0,0 +1,105 @@
+package sanitize
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+const xhtml = "http://www.w3.org/1999/xhtml"
+
+func policy(action Action) *Policy {
+	return &Policy{
+		Elements: map[string][]string{
+			"div": nil,
+			"p":   nil,
+			"a":   {"href", "title"},
+			"img": {"src", "alt"},
+			"b":   nil,
+		},
+		Attrs:      []string{"class", "xml:lang"},
+		Namespaces: []string{xhtml},
+		Action:     action,
+		Remove:     []string{"script"},
+	}
+}
+
+func parse(t *testing.T, src string) *dom.Element {
+	doc, err := dom.Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("Cannot parse %s: %v", src, err)
+	}
+	return doc.Root()
+}
+
+func TestSanitize(t *testing.T) {
+	src := `<div xmlns:x="urn:evil" class="c" onclick="evil()">` +
+		`<p xml:lang="en" x:attr="1">Hi<font>there<b>bold</b></font></p>` +
+		`<a href="JaVa&#9;script:alert(1)" title="t">bad</a>` +
+		`<a href="https://example.com/">good</a><a href="/rel">rel</a>` +
+		`<img src="data:image/png;base64,AAAA" alt="i"/>` +
+		`<script>alert(1)</script><x:thing>t</x:thing></div>`
+	for _, test := range []struct {
+		action Action
+		want   string
+	}{
+		{Drop, `<div class="c"><p xml:lang="en">Hi</p><a title="t">bad</a><a href="https://example.com/">good</a><a href="/rel">rel</a><img alt="i"/></div>`},
+		{Unwrap, `<div class="c">t<p xml:lang="en">Hithere<b>bold</b></p><a title="t">bad</a><a href="https://example.com/">good</a><a href="/rel">rel</a><img alt="i"/></div>`},
+		{Escape, `<div class="c">&lt;ns1:thing xmlns:ns1=&#34;urn:evil&#34;&gt;t&lt;/ns1:thing&gt;<p xml:lang="en">Hi&lt;font&gt;there&lt;b&gt;bold&lt;/b&gt;&lt;/font&gt;</p><a title="t">bad</a><a href="https://example.com/">good</a><a href="/rel">rel</a><img alt="i"/></div>`},
+	} {
+		root := parse(t, src)
+		if !policy(test.action).Sanitize(root) {
+			t.Errorf("Action %d: root should be allowed", test.action)
+		}
+		got, err := root.StringWith()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("Action %d: unexpected result\n%s\nwant\n%s", test.action, got, test.want)
+		}
+	}
+}
+
+func TestSanitizeRoot(t *testing.T) {
+	root := parse(t, `<script><b onclick="x">b</b></script>`)
+	if policy(Drop).Sanitize(root) {
+		t.Errorf("script should not be allowed")
+	}
+	if got, _ := root.Child(0).StringWith(); got != "<b>b</b>" {
+		t.Errorf("The contents should still be sanitized, got %s", got)
+	}
+	if policy(Drop).Sanitize(parse(t, `<b xmlns="urn:other"/>`)) {
+		t.Errorf("elements in other namespaces should not be allowed")
+	}
+	root = parse(t, `<h:p xmlns:h="http://www.w3.org/1999/xhtml" xmlns:o="urn:other"><h:b>b</h:b><o:b>o</o:b></h:p>`)
+	if !policy(Drop).Sanitize(root) {
+		t.Errorf("elements in allowed namespaces should be allowed")
+	}
+	if got, _ := root.StringWith(); got != `<h:p xmlns:h="http://www.w3.org/1999/xhtml"><h:b>b</h:b></h:p>` {
+		t.Errorf("Unexpected result %s", got)
+	}
+}
+
+func TestAllowedURL(t *testing.T) {
+	p := &Policy{}
+	for u, want := range map[string]bool{
+		"http://a/":          true,
+		" HTTPS://a/":        true,
+		"mailto:a@b":         true,
+		"rel/path:x":         true,
+		"?q=javascript:x":    true,
+		"#frag":              true,
+		"javascript:x":       false,
+		"java\nscript:x":     false,
+		"\x01javascript:x":   false,
+		"vbscript:x":         false,
+		":x":                 false,
+		"data:text/html,<x>": false,
+	} {
+		if got := p.allowedURL(u); got != want {
+			t.Errorf("allowedURL(%q) = %v", u, got)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package schema
+
+import "fmt"
+
+// Facet is a constraining facet, such as maxLength or pattern, given by
+// name.
+type Facet struct {
+	Name, Value string
+}
+
+// Datatype is one of the XSD built-in datatypes, possibly restricted by
+// some facets.  It lets other validators share the datatype support in
+// this package.
+type Datatype struct {
+	st *simpleType
+}
+
+// NewDatatype returns the built-in datatype called name from the XML
+// Schema namespace, restricted by facets.
+func NewDatatype(name string, facets ...Facet) (*Datatype, error) {
+	base, ok := builtinTypes[name]
+	if !ok {
+		return nil, fmt.Errorf("schema: unknown built-in type %s", name)
+	}
+	if len(facets) == 0 {
+		return &Datatype{st: base}, nil
+	}
+	st := &simpleType{}
+	st.setBase(base)
+	for _, f := range facets {
+		ok, err := st.facets.set(f.Name, f.Value)
+		if err != nil {
+			return nil, fmt.Errorf("schema: facet %s: %v", f.Name, err)
+		}
+		if !ok {
+			return nil, fmt.Errorf("schema: unknown facet %s", f.Name)
+		}
+	}
+	return &Datatype{st: st}, nil
+}
+
+// Validate checks that v is a valid value of d.
+func (d *Datatype) Validate(v string) error {
+	return d.st.validate(v)
+}
+
+// Equal reports whether a and b are the same value of d.
+func (d *Datatype) Equal(a, b string) bool {
+	return d.st.equal(a, b)
+}
@@ -0,0 +1,342 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// goTypes are the Go types of the built-in datatypes encoding/xml can
+// read and write.  The others, including the date and time types, whose
+// lexical forms time.Time does not take, are strings.
+var goTypes = map[string]string{
+	"boolean":            "bool",
+	"float":              "float32",
+	"double":             "float64",
+	"decimal":            "float64",
+	"integer":            "int64",
+	"long":               "int64",
+	"nonPositiveInteger": "int64",
+	"negativeInteger":    "int64",
+	"int":                "int32",
+	"short":              "int16",
+	"byte":               "int8",
+	"nonNegativeInteger": "uint64",
+	"positiveInteger":    "uint64",
+	"unsignedLong":       "uint64",
+	"unsignedInt":        "uint32",
+	"unsignedShort":      "uint16",
+	"unsignedByte":       "uint8",
+}
+
+// goName makes an XML name into an exported Go identifier, dropping the
+// characters Go does not allow and starting a new word after them.
+func goName(s string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range s {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			upper = true
+		case upper:
+			if b.Len() == 0 && unicode.IsDigit(r) {
+				b.WriteByte('X')
+			}
+			b.WriteRune(unicode.ToUpper(r))
+			upper = false
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() == 0 {
+		return "X"
+	}
+	return b.String()
+}
+
+// goField is one field of a generated struct.
+type goField struct {
+	name, typ, tag string
+}
+
+// goGen generates the Go types for a Schema.
+type goGen struct {
+	s *Schema
+	// names maps the declarations and types that have been given a Go
+	// type to its name, and used holds the names taken.
+	names map[interface{}]string
+	used  map[string]bool
+	// decls holds the source of each struct, in the order they were
+	// named, so that structs come before the ones in them.
+	decls []string
+	// anyElement is set once a wildcard needs the AnyElement type.
+	anyElement bool
+}
+
+// unique returns name, or name with a number after it if it is taken,
+// and takes it.
+func (g *goGen) unique(name string) string {
+	res := name
+	for i := 2; g.used[res]; i++ {
+		res = fmt.Sprintf("%s%d", name, i)
+	}
+	g.used[res] = true
+	return res
+}
+
+func tagName(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + " " + n.Local
+}
+
+// simpleGoType returns the Go type of values of st.
+func simpleGoType(st *simpleType) string {
+	if st.variety != atomic {
+		return "string"
+	}
+	for st.base != nil {
+		st = st.base
+	}
+	if t, ok := goTypes[st.prim.name]; ok {
+		return t
+	}
+	return "string"
+}
+
+// elementType returns the Go type of the content of d, a child of the
+// struct parent, declaring it if it has not been yet, and whether it is a
+// struct.
+func (g *goGen) elementType(d *elementDecl, parent string) (string, bool) {
+	switch t := d.typ.(type) {
+	case *simpleType:
+		return simpleGoType(t), false
+	case *complexType:
+		if t == anyType {
+			g.anyElement = true
+			return "AnyElement", true
+		}
+		if t.simple != nil && len(t.attrs) == 0 && t.anyAttr == nil {
+			return simpleGoType(t.simple), false
+		}
+		if g.s.elements[d.name] == d {
+			return g.global(d), true
+		}
+		return g.complexType(t, parent+goName(d.name.Local),
+			fmt.Sprintf("what the %s elements in %s hold", d.name.Local, parent)), true
+	}
+	return "string", false
+}
+
+// global declares the struct for the global element d, with an XMLName
+// for its name.
+func (g *goGen) global(d *elementDecl) string {
+	if name, ok := g.names[d]; ok {
+		return name
+	}
+	name := g.unique(goName(d.name.Local))
+	g.names[d] = name
+	slot := g.slot()
+	ct := d.typ.(*complexType)
+	fields := []goField{{"XMLName", "xml.Name", tagName(d.name)}}
+	if ct.name.Local != "" {
+		// The named type holds the content, so that local elements of the
+		// type share it.
+		fields = append(fields, goField{typ: g.complexType(ct, "", "")})
+	} else {
+		fields = append(fields, g.fields(ct, name)...)
+	}
+	g.declare(slot, fmt.Sprintf("%s is the %s element.", name, d.name.Local), name, fields)
+	return name
+}
+
+// complexType declares the struct for ct, and returns its name.  If ct is
+// anonymous, the struct is called hint, and doc says what it is.
+func (g *goGen) complexType(ct *complexType, hint, doc string) string {
+	if name, ok := g.names[ct]; ok {
+		return name
+	}
+	name := hint
+	if ct.name.Local != "" {
+		name = goName(ct.name.Local)
+		if g.used[name] {
+			name += "Type"
+		}
+		doc = "the " + ct.name.Local + " type"
+	}
+	name = g.unique(name)
+	g.names[ct] = name
+	slot := g.slot()
+	g.declare(slot, fmt.Sprintf("%s is %s.", name, doc), name, g.fields(ct, name))
+	return name
+}
+
+// fields returns the fields of the struct for ct, whose Go type is
+// called parent.
+func (g *goGen) fields(ct *complexType, parent string) []goField {
+	var res []goField
+	taken := map[string]bool{"XMLName": true}
+	add := func(name, typ, tag string) {
+		f := goField{name, typ, tag}
+		for i := 2; taken[f.name]; i++ {
+			f.name = fmt.Sprintf("%s%d", name, i)
+		}
+		taken[f.name] = true
+		res = append(res, f)
+	}
+	for _, a := range ct.attrs {
+		if a.prohibited {
+			continue
+		}
+		tag := tagName(a.name) + ",attr"
+		if !a.required {
+			tag += ",omitempty"
+		}
+		add(goName(a.name.Local), simpleGoType(a.typ), tag)
+	}
+	if ct.anyAttr != nil {
+		add("Attrs", "[]xml.Attr", ",any,attr")
+	}
+	switch {
+	case ct.simple != nil:
+		add("Value", simpleGoType(ct.simple), ",chardata")
+	case ct.mixed:
+		add("Text", "string", ",chardata")
+	}
+	var walk func(p *particle, optional, repeated bool)
+	walk = func(p *particle, optional, repeated bool) {
+		optional = optional || p.min == 0
+		repeated = repeated || p.max < 0 || p.max > 1
+		switch p.kind {
+		case elementParticle:
+			name := goName(p.elem.name.Local)
+			typ, complex := g.elementType(p.elem, parent)
+			tag := tagName(p.elem.name)
+			switch {
+			case repeated:
+				typ = "[]" + typ
+			case optional && complex:
+				typ = "*" + typ
+			case optional:
+				tag += ",omitempty"
+			}
+			add(name, typ, tag)
+		case anyParticle:
+			g.anyElement = true
+			add("Any", "[]AnyElement", ",any")
+		case choiceParticle:
+			for _, c := range p.items {
+				walk(c, optional || len(p.items) > 1, repeated)
+			}
+		default:
+			for _, c := range p.items {
+				walk(c, optional, repeated)
+			}
+		}
+	}
+	if ct.content != nil {
+		walk(ct.content, false, false)
+	}
+	return res
+}
+
+// slot keeps a place in decls for a struct that is being declared.
+func (g *goGen) slot() int {
+	g.decls = append(g.decls, "")
+	return len(g.decls) - 1
+}
+
+func (g *goGen) declare(slot int, doc, name string, fields []goField) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n// %s\ntype %s struct {\n", doc, name)
+	for _, f := range fields {
+		if f.name == "" {
+			fmt.Fprintf(&b, "\t%s\n", f.typ)
+			continue
+		}
+		fmt.Fprintf(&b, "\t%s %s `xml:%q`\n", f.name, f.typ, f.tag)
+	}
+	b.WriteString("}\n")
+	g.decls[slot] = b.String()
+}
+
+// GoStructs returns the source of a Go file in package pkg with a struct
+// type for each global element of s with complex content, and for the
+// types those need, to use with encoding/xml.  It is meant as a start on
+// moving code that walks dom trees over to typed structs: the structs
+// read the documents s describes, but what they check is up to
+// encoding/xml.
+//
+// Each global element gets a struct with an XMLName naming it, and each
+// named complex type one that elements of the type share.  Anonymous
+// complex types are named after their element and the elements they are
+// in.  Children that can repeat become slices, those that can be left
+// out pointers or omitempty fields, attributes attr fields, simple
+// content a chardata Value field and wildcards fields of an AnyElement
+// type, which is declared if anything needs it.  Numbers and booleans
+// get Go types of their own, and the other simple types are strings.
+//
+// To generate structs for documents without a schema, infer one:
+//    xsd, err := Infer(docs...)
+//    ...
+//    s, err := Load(xsd)
+//    ...
+//    src, err := s.GoStructs("feed")
+func (s *Schema) GoStructs(pkg string) ([]byte, error) {
+	g := &goGen{s: s, names: map[interface{}]string{}, used: map[string]bool{"AnyElement": true}}
+	names := []xml.Name{}
+	for n, d := range s.elements {
+		if ct, ok := d.typ.(*complexType); ok && ct != anyType {
+			names = append(names, n)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i].Local != names[j].Local {
+			return names[i].Local < names[j].Local
+		}
+		return names[i].Space < names[j].Space
+	})
+	for _, n := range names {
+		g.global(s.elements[n])
+	}
+	typeNames := []xml.Name{}
+	for n, t := range s.types {
+		if ct, ok := t.(*complexType); ok && ct != anyType {
+			typeNames = append(typeNames, n)
+		}
+	}
+	sort.Slice(typeNames, func(i, j int) bool {
+		return typeNames[i].Local < typeNames[j].Local
+	})
+	for _, n := range typeNames {
+		g.complexType(s.types[n].(*complexType), "", "")
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated from an XML Schema; DO NOT EDIT.\n\npackage %s\n", pkg)
+	if len(g.decls) > 0 {
+		b.WriteString("\nimport \"encoding/xml\"\n")
+	}
+	if g.anyElement {
+		b.WriteString(`
+// AnyElement holds an element a wildcard matched, as it was.
+type AnyElement struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr ` + "`xml:\",any,attr\"`" + `
+	Inner   []byte     ` + "`xml:\",innerxml\"`" + `
+}
+`)
+	}
+	for _, d := range g.decls {
+		b.WriteString(d)
+	}
+	res, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("schema: generating Go source: %v", err)
+	}
+	return res, nil
+}
@@ -0,0 +1,320 @@
+package schema
+
+import (
+	"encoding/xml"
+	"errors"
+	"sort"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+// inferTypes are the types Infer tries for text, in the order it prefers
+// them.  integer comes before boolean so that 0 and 1 are numbers, and
+// string takes anything, so there is always one left.
+var inferTypes = []string{"integer", "decimal", "boolean", "date", "dateTime", "time", "string"}
+
+// types is the set of inferTypes that every value seen so far is valid
+// for.
+type types []string
+
+func (ts types) narrow(v string) types {
+	res := ts[:0]
+	for _, t := range ts {
+		if builtinTypes[t].validate(v) == nil {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+func allTypes() types {
+	return append(types{}, inferTypes...)
+}
+
+type inferredAttr struct {
+	count int
+	types types
+}
+
+type inferredChild struct {
+	min, max int
+}
+
+// inferredElement is what Infer has seen of the elements with one name.
+type inferredElement struct {
+	name      xml.Name
+	instances int
+	attrs     map[string]*inferredAttr
+	attrOrder []string
+	// anyAttr is set if the elements had attributes in a namespace.
+	anyAttr  bool
+	children map[xml.Name]*inferredChild
+	// order holds the names of the children in the order they were
+	// first seen, and before which ones came before which others.
+	// unordered is set once they came in an order a sequence cannot
+	// describe.
+	order     []xml.Name
+	before    map[[2]xml.Name]bool
+	unordered bool
+	hasText   bool
+	text      types
+}
+
+type inferrer struct {
+	tns      string
+	elements map[xml.Name]*inferredElement
+	order    []xml.Name
+	roots    map[xml.Name]bool
+}
+
+func (in *inferrer) element(e *dom.Element) {
+	ie, ok := in.elements[e.Name]
+	if !ok {
+		ie = &inferredElement{
+			name:     e.Name,
+			attrs:    map[string]*inferredAttr{},
+			children: map[xml.Name]*inferredChild{},
+			before:   map[[2]xml.Name]bool{},
+			text:     allTypes(),
+		}
+		in.elements[e.Name] = ie
+		in.order = append(in.order, e.Name)
+	}
+	ie.instances++
+	for _, a := range e.Attributes {
+		switch {
+		case a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns"):
+		case a.Name.Space != "":
+			ie.anyAttr = true
+		default:
+			ia, ok := ie.attrs[a.Name.Local]
+			if !ok {
+				ia = &inferredAttr{types: allTypes()}
+				ie.attrs[a.Name.Local] = ia
+				ie.attrOrder = append(ie.attrOrder, a.Name.Local)
+			}
+			ia.count++
+			ia.types = ia.types.narrow(a.Value)
+		}
+	}
+	children := e.Children()
+	if len(e.Content) > 0 {
+		ie.hasText = true
+	}
+	if len(children) == 0 {
+		ie.text = ie.text.narrow(string(e.Content))
+	}
+	counts := map[xml.Name]int{}
+	var runs []xml.Name
+	for _, c := range children {
+		if len(runs) == 0 || runs[len(runs)-1] != c.Name {
+			if counts[c.Name] > 0 {
+				// The name comes back after others.
+				ie.unordered = true
+			}
+			runs = append(runs, c.Name)
+		}
+		counts[c.Name]++
+	}
+	for i, a := range runs {
+		for _, b := range runs[i+1:] {
+			ie.before[[2]xml.Name{a, b}] = true
+			if ie.before[[2]xml.Name{b, a}] {
+				ie.unordered = true
+			}
+		}
+	}
+	for name, ic := range ie.children {
+		if counts[name] < ic.min {
+			ic.min = counts[name]
+		}
+	}
+	for _, name := range runs {
+		ic, ok := ie.children[name]
+		if !ok {
+			ic = &inferredChild{min: counts[name]}
+			if ie.instances > 1 {
+				ic.min = 0
+			}
+			ie.children[name] = ic
+			ie.order = append(ie.order, name)
+		}
+		if counts[name] > ic.max {
+			ic.max = counts[name]
+		}
+	}
+	for _, c := range children {
+		in.element(c)
+	}
+}
+
+// sequence returns the children of ie in an order consistent with the
+// one they came in everywhere, keeping to the order they were first seen
+// in where that leaves a choice.  It returns false if there is no such
+// order.
+func (ie *inferredElement) sequence() ([]xml.Name, bool) {
+	res := []xml.Name{}
+	placed := map[xml.Name]bool{}
+	for len(res) < len(ie.order) {
+		n := len(res)
+	Names:
+		for _, n := range ie.order {
+			if placed[n] {
+				continue
+			}
+			for _, m := range ie.order {
+				if !placed[m] && m != n && ie.before[[2]xml.Name{m, n}] {
+					continue Names
+				}
+			}
+			placed[n] = true
+			res = append(res, n)
+			break
+		}
+		if len(res) == n {
+			return nil, false
+		}
+	}
+	return res, true
+}
+
+func (in *inferrer) xsName(n xml.Name) string {
+	if n.Space != "" {
+		return "tns:" + n.Local
+	}
+	return n.Local
+}
+
+func xs(local string) *dom.Element {
+	return dom.Elem(local, dom.NS_XS)
+}
+
+// particle returns the declaration of the child n of elements that had
+// it between min and max times.
+func (in *inferrer) particle(n xml.Name, min, max int) *dom.Element {
+	var res *dom.Element
+	if n.Space == in.tns {
+		res = xs("element").Attr("ref", "", in.xsName(n))
+	} else {
+		ns := n.Space
+		if ns == "" {
+			ns = "##local"
+		}
+		res = xs("any").Attr("namespace", "", ns).Attr("processContents", "", "lax")
+	}
+	if min == 0 {
+		res.Attr("minOccurs", "", "0")
+	}
+	if max > 1 {
+		res.Attr("maxOccurs", "", "unbounded")
+	}
+	return res
+}
+
+func (in *inferrer) declare(ie *inferredElement) *dom.Element {
+	decl := xs("element").Attr("name", "", ie.name.Local)
+	if len(ie.children) == 0 && len(ie.attrs) == 0 && !ie.anyAttr {
+		if ie.hasText {
+			return decl.Attr("type", "", "xs:"+ie.text[0])
+		}
+		return decl.AddChild(xs("complexType"))
+	}
+	ct := xs("complexType")
+	decl.AddChild(ct)
+	body := ct
+	switch {
+	case len(ie.children) > 0:
+		if ie.hasText {
+			ct.Attr("mixed", "", "true")
+		}
+		var group *dom.Element
+		if seq, ok := ie.sequence(); ok && !ie.unordered {
+			group = xs("sequence")
+			for _, n := range seq {
+				ic := ie.children[n]
+				group.AddChild(in.particle(n, ic.min, ic.max))
+			}
+		} else {
+			group = xs("choice").Attr("minOccurs", "", "0").Attr("maxOccurs", "", "unbounded")
+			for _, n := range ie.order {
+				group.AddChild(in.particle(n, 1, 1))
+			}
+		}
+		ct.AddChild(group)
+	case ie.hasText:
+		ext := xs("extension").Attr("base", "", "xs:"+ie.text[0])
+		ct.AddChild(xs("simpleContent").AddChild(ext))
+		body = ext
+	}
+	for _, name := range ie.attrOrder {
+		ia := ie.attrs[name]
+		a := xs("attribute").Attr("name", "", name).Attr("type", "", "xs:"+ia.types[0])
+		if ia.count == ie.instances {
+			a.Attr("use", "", "required")
+		}
+		body.AddChild(a)
+	}
+	if ie.anyAttr {
+		body.AddChild(xs("anyAttribute").Attr("namespace", "", "##any").Attr("processContents", "", "lax"))
+	}
+	return decl
+}
+
+// Infer writes a draft XML Schema that docs are all valid against, to
+// start a schema for documents that come without one from.  Every
+// element name in the namespace of the first root element gets a global
+// declaration, so elements with the same name are declared the same
+// wherever they are, and children in other namespaces are only matched
+// by wildcards, as are attributes in any namespace.  Children become a
+// sequence if they always came in the same order and a repeated choice
+// otherwise, with minOccurs="0" for those that were left out somewhere
+// and maxOccurs="unbounded" for those that were repeated.  Text and
+// attribute values get the first of integer, decimal, boolean, date,
+// dateTime, time and string all their values were valid for, and
+// attributes are required if they were always there.  The result is
+// meant to be read and edited: it says no more than the samples do, so
+// an integer that happened to be small in all of them is not made a
+// byte.
+func Infer(docs ...*dom.Document) (*dom.Document, error) {
+	in := &inferrer{elements: map[xml.Name]*inferredElement{}, roots: map[xml.Name]bool{}}
+	for _, doc := range docs {
+		root := doc.Root()
+		if root == nil {
+			continue
+		}
+		if len(in.roots) == 0 {
+			in.tns = root.Name.Space
+		}
+		if root.Name.Space != in.tns {
+			return nil, errors.New("schema: the root elements are in different namespaces")
+		}
+		in.roots[root.Name] = true
+		in.element(root)
+	}
+	if len(in.roots) == 0 {
+		return nil, errors.New("schema: no documents to infer a schema from")
+	}
+	s := xs("schema").Attr("xs", "xmlns", dom.NS_XS)
+	if in.tns != "" {
+		s.Attr("targetNamespace", "", in.tns).Attr("tns", "xmlns", in.tns).Attr("elementFormDefault", "", "qualified")
+	}
+	// Roots first, then the rest by name.
+	names := []xml.Name{}
+	for _, n := range in.order {
+		if n.Space == in.tns {
+			names = append(names, n)
+		}
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		if in.roots[names[i]] != in.roots[names[j]] {
+			return in.roots[names[i]]
+		}
+		return !in.roots[names[i]] && names[i].Local < names[j].Local
+	})
+	for _, n := range names {
+		s.AddChild(in.declare(in.elements[n]))
+	}
+	res := dom.CreateDocument()
+	res.SetRoot(s)
+	return res, nil
+}
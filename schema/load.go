@@ -0,0 +1,901 @@
+package schema
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+type attrGroup struct {
+	attrs []*attrUse
+	any   *wildcard
+}
+
+// deferred holds the parts of a complex type derivation that can only be
+// worked out once the base type has been completely compiled.
+type deferred struct {
+	base      *complexType
+	extension bool
+	// restriction is the simple type created by a simpleContent
+	// restriction, whose base is the simple content of base.
+	restriction *simpleType
+	done, busy  bool
+}
+
+type loader struct {
+	s             *Schema
+	root          *dom.Element
+	tns           string
+	elemQualified bool
+	attrQualified bool
+	rawElements   map[xml.Name]*dom.Element
+	rawTypes      map[xml.Name]*dom.Element
+	rawAttrs      map[xml.Name]*dom.Element
+	rawGroups     map[xml.Name]*dom.Element
+	rawAttrGroups map[xml.Name]*dom.Element
+	groups        map[xml.Name]*particle
+	attrGroups    map[xml.Name]*attrGroup
+	busy          map[xml.Name]bool
+	derived       map[*complexType]*deferred
+	order         []*complexType
+	// resolver reads the schema documents xs:include elements name, and
+	// included holds the ones already read.
+	resolver dom.Resolver
+	included map[string]bool
+}
+
+func newLoader(root *dom.Element) *loader {
+	return &loader{
+		s: &Schema{
+			elements: map[xml.Name]*elementDecl{},
+			types:    map[xml.Name]typeDef{},
+			attrs:    map[xml.Name]*attrUse{},
+		},
+		root:          root,
+		rawElements:   map[xml.Name]*dom.Element{},
+		rawTypes:      map[xml.Name]*dom.Element{},
+		rawAttrs:      map[xml.Name]*dom.Element{},
+		rawGroups:     map[xml.Name]*dom.Element{},
+		rawAttrGroups: map[xml.Name]*dom.Element{},
+		groups:        map[xml.Name]*particle{},
+		attrGroups:    map[xml.Name]*attrGroup{},
+		busy:          map[xml.Name]bool{},
+		derived:       map[*complexType]*deferred{},
+		included:      map[string]bool{},
+	}
+}
+
+// attr returns the value of the unqualified attribute name on el.
+func attr(el *dom.Element, name string) (string, bool) {
+	for _, a := range el.Attributes {
+		if a.Name.Space == "" && a.Name.Local == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// xsChildren returns the children of el in the XML Schema namespace,
+// skipping annotations.
+func xsChildren(el *dom.Element) []*dom.Element {
+	res := []*dom.Element{}
+	for _, c := range el.Children() {
+		if c.Name.Space == dom.NS_XS && c.Name.Local != "annotation" {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+func errorAt(el *dom.Element, format string, args ...interface{}) error {
+	return fmt.Errorf("schema: %v: <%s>: %s", el.Pos(), el.Name.Local, fmt.Sprintf(format, args...))
+}
+
+// resolveQName resolves a prefixed name against the namespace
+// declarations in scope at el.
+func resolveQName(el *dom.Element, qname string) (xml.Name, error) {
+	prefix, local := "", qname
+	if i := strings.IndexByte(qname, ':'); i >= 0 {
+		prefix, local = qname[:i], qname[i+1:]
+	}
+	if prefix == "xml" {
+		return xml.Name{Space: dom.NS_XML, Local: local}, nil
+	}
+	for e := el; e != nil; e = e.Parent() {
+		for _, a := range e.Attributes {
+			if (prefix == "" && a.Name.Space == "" && a.Name.Local == "xmlns") ||
+				(prefix != "" && a.Name.Space == "xmlns" && a.Name.Local == prefix) {
+				return xml.Name{Space: a.Value, Local: local}, nil
+			}
+		}
+	}
+	if prefix == "" {
+		return xml.Name{Local: local}, nil
+	}
+	return xml.Name{}, errorAt(el, "namespace prefix %q is not declared", prefix)
+}
+
+func (l *loader) qnameAttr(el *dom.Element, name string) (xml.Name, bool, error) {
+	v, ok := attr(el, name)
+	if !ok {
+		return xml.Name{}, false, nil
+	}
+	n, err := resolveQName(el, v)
+	return n, true, err
+}
+
+func (l *loader) load() error {
+	l.s.TargetNamespace, _ = attr(l.root, "targetNamespace")
+	l.tns = l.s.TargetNamespace
+	if v, _ := attr(l.root, "elementFormDefault"); v == "qualified" {
+		l.elemQualified = true
+	}
+	if v, _ := attr(l.root, "attributeFormDefault"); v == "qualified" {
+		l.attrQualified = true
+	}
+	if err := l.collect(l.root); err != nil {
+		return err
+	}
+	for name := range l.rawTypes {
+		if _, err := l.namedType(name); err != nil {
+			return err
+		}
+	}
+	for name := range l.rawElements {
+		if _, err := l.globalElement(name); err != nil {
+			return err
+		}
+	}
+	for name := range l.rawAttrs {
+		if _, err := l.globalAttr(name); err != nil {
+			return err
+		}
+	}
+	for _, ct := range l.order {
+		if err := l.finish(ct); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collect records the global declarations in the schema document root,
+// and in the ones it includes.
+func (l *loader) collect(root *dom.Element) error {
+	for _, c := range xsChildren(root) {
+		var raw map[xml.Name]*dom.Element
+		switch c.Name.Local {
+		case "element":
+			raw = l.rawElements
+		case "simpleType", "complexType":
+			raw = l.rawTypes
+		case "attribute":
+			raw = l.rawAttrs
+		case "group":
+			raw = l.rawGroups
+		case "attributeGroup":
+			raw = l.rawAttrGroups
+		case "import":
+			if _, ok := attr(c, "schemaLocation"); ok {
+				return errorAt(c, "importing schema documents is not supported")
+			}
+			continue
+		case "include":
+			if err := l.include(c); err != nil {
+				return err
+			}
+			continue
+		case "notation":
+			continue
+		default:
+			return errorAt(c, "not supported")
+		}
+		name, ok := attr(c, "name")
+		if !ok {
+			return errorAt(c, "global declarations must have a name")
+		}
+		qn := xml.Name{Space: l.tns, Local: name}
+		if _, dup := raw[qn]; dup {
+			return errorAt(c, "%s is declared more than once", name)
+		}
+		raw[qn] = c
+	}
+	return nil
+}
+
+// include reads the schema document the xs:include inc names, and
+// collects its declarations.  Included documents must have the same
+// target namespace and form defaults as the including one, and each is
+// only read once.
+func (l *loader) include(inc *dom.Element) error {
+	loc, ok := attr(inc, "schemaLocation")
+	if !ok {
+		return errorAt(inc, "schemaLocation is missing")
+	}
+	if l.resolver == nil {
+		return errorAt(inc, "including schema documents needs a Resolver, see LoadWith")
+	}
+	loc, err := inc.ResolveURI(strings.TrimSpace(loc))
+	if err != nil {
+		return errorAt(inc, "%v", err)
+	}
+	if l.included[loc] {
+		return nil
+	}
+	l.included[loc] = true
+	r, err := l.resolver.Resolve(loc)
+	if err != nil {
+		return errorAt(inc, "cannot read %s: %v", loc, err)
+	}
+	defer r.Close()
+	doc, err := dom.Parse(r, dom.WithBaseURI(loc))
+	if err != nil {
+		return errorAt(inc, "cannot parse %s: %v", loc, err)
+	}
+	root := doc.Root()
+	if root == nil || root.Name.Space != dom.NS_XS || root.Name.Local != "schema" {
+		return errorAt(inc, "%s is not an XML Schema", loc)
+	}
+	if tns, _ := attr(root, "targetNamespace"); tns != l.tns {
+		return errorAt(inc, "%s has target namespace %q, not %q", loc, tns, l.tns)
+	}
+	elemForm, _ := attr(root, "elementFormDefault")
+	attrForm, _ := attr(root, "attributeFormDefault")
+	if (elemForm == "qualified") != l.elemQualified || (attrForm == "qualified") != l.attrQualified {
+		return errorAt(inc, "%s has other form defaults", loc)
+	}
+	return l.collect(root)
+}
+
+func (l *loader) namedType(name xml.Name) (typeDef, error) {
+	if name.Space == dom.NS_XS {
+		if name.Local == "anyType" {
+			return anyType, nil
+		}
+		if st, ok := builtinTypes[name.Local]; ok {
+			return st, nil
+		}
+		return nil, fmt.Errorf("schema: unknown built-in type %s", name.Local)
+	}
+	if t, ok := l.s.types[name]; ok {
+		return t, nil
+	}
+	raw, ok := l.rawTypes[name]
+	if !ok {
+		return nil, fmt.Errorf("schema: type %s is not defined", fmtName(name))
+	}
+	if raw.Name.Local == "simpleType" {
+		if l.busy[name] {
+			return nil, errorAt(raw, "simple type %s is defined in terms of itself", name.Local)
+		}
+		l.busy[name] = true
+		st := &simpleType{name: name}
+		if err := l.simpleType(raw, st); err != nil {
+			return nil, err
+		}
+		delete(l.busy, name)
+		l.s.types[name] = st
+		return st, nil
+	}
+	ct := &complexType{name: name}
+	l.s.types[name] = ct
+	if err := l.complexType(raw, ct); err != nil {
+		return nil, err
+	}
+	return ct, nil
+}
+
+func (l *loader) simpleTypeRef(el *dom.Element, attrName string) (*simpleType, error) {
+	name, ok, err := l.qnameAttr(el, attrName)
+	if err != nil || !ok {
+		return nil, err
+	}
+	t, err := l.namedType(name)
+	if err != nil {
+		return nil, err
+	}
+	st, ok := t.(*simpleType)
+	if !ok {
+		return nil, errorAt(el, "%s is not a simple type", fmtName(name))
+	}
+	return st, nil
+}
+
+// inlineSimpleType compiles the single anonymous simpleType child of el,
+// if there is one.
+func (l *loader) inlineSimpleType(el *dom.Element) (*simpleType, error) {
+	for _, c := range xsChildren(el) {
+		if c.Name.Local == "simpleType" {
+			st := &simpleType{}
+			return st, l.simpleType(c, st)
+		}
+	}
+	return nil, nil
+}
+
+func (l *loader) simpleType(el *dom.Element, st *simpleType) error {
+	for _, c := range xsChildren(el) {
+		switch c.Name.Local {
+		case "restriction":
+			base, err := l.simpleTypeRef(c, "base")
+			if err != nil {
+				return err
+			}
+			if base == nil {
+				if base, err = l.inlineSimpleType(c); err != nil {
+					return err
+				}
+			}
+			if base == nil {
+				return errorAt(c, "restriction has no base type")
+			}
+			st.setBase(base)
+			return l.facets(c, st)
+		case "list":
+			item, err := l.simpleTypeRef(c, "itemType")
+			if err != nil {
+				return err
+			}
+			if item == nil {
+				if item, err = l.inlineSimpleType(c); err != nil {
+					return err
+				}
+			}
+			if item == nil {
+				return errorAt(c, "list has no item type")
+			}
+			st.variety = list
+			st.item = item
+			return nil
+		case "union":
+			st.variety = union
+			if v, ok := attr(c, "memberTypes"); ok {
+				for _, m := range strings.Fields(v) {
+					name, err := resolveQName(c, m)
+					if err != nil {
+						return err
+					}
+					t, err := l.namedType(name)
+					if err != nil {
+						return err
+					}
+					mst, ok := t.(*simpleType)
+					if !ok {
+						return errorAt(c, "%s is not a simple type", m)
+					}
+					st.members = append(st.members, mst)
+				}
+			}
+			for _, m := range xsChildren(c) {
+				if m.Name.Local == "simpleType" {
+					mst := &simpleType{}
+					if err := l.simpleType(m, mst); err != nil {
+						return err
+					}
+					st.members = append(st.members, mst)
+				}
+			}
+			if len(st.members) == 0 {
+				return errorAt(c, "union has no member types")
+			}
+			return nil
+		}
+	}
+	return errorAt(el, "simple type must have a restriction, list or union")
+}
+
+func (st *simpleType) setBase(base *simpleType) {
+	st.base = base
+	st.variety = base.variety
+	st.prim = base.prim
+	st.item = base.item
+}
+
+// facets reads the constraining facets in restriction into st.  Children
+// that are not facets are ignored.
+func (l *loader) facets(restriction *dom.Element, st *simpleType) error {
+	for _, c := range xsChildren(restriction) {
+		v, _ := attr(c, "value")
+		if _, err := st.facets.set(c.Name.Local, v); err != nil {
+			return errorAt(c, "%v", err)
+		}
+	}
+	return nil
+}
+
+func occurs(el *dom.Element) (int, int, error) {
+	min, max := 1, 1
+	if v, ok := attr(el, "minOccurs"); ok {
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 0 {
+			return 0, 0, errorAt(el, "bad minOccurs %q", v)
+		}
+		min = i
+	}
+	if v, ok := attr(el, "maxOccurs"); ok {
+		if v == "unbounded" {
+			max = -1
+		} else {
+			i, err := strconv.Atoi(v)
+			if err != nil || i < 0 {
+				return 0, 0, errorAt(el, "bad maxOccurs %q", v)
+			}
+			max = i
+		}
+	}
+	if max >= 0 && min > max {
+		return 0, 0, errorAt(el, "minOccurs is greater than maxOccurs")
+	}
+	return min, max, nil
+}
+
+func isParticle(el *dom.Element) bool {
+	switch el.Name.Local {
+	case "element", "sequence", "choice", "all", "group", "any":
+		return true
+	}
+	return false
+}
+
+func (l *loader) particle(el *dom.Element) (*particle, error) {
+	min, max, err := occurs(el)
+	if err != nil {
+		return nil, err
+	}
+	p := &particle{min: min, max: max}
+	switch el.Name.Local {
+	case "element":
+		p.kind = elementParticle
+		p.elem, err = l.localElement(el)
+		if err != nil {
+			return nil, err
+		}
+	case "sequence", "choice", "all":
+		p.kind = map[string]particleKind{
+			"sequence": sequenceParticle,
+			"choice":   choiceParticle,
+			"all":      allParticle,
+		}[el.Name.Local]
+		for _, c := range xsChildren(el) {
+			if !isParticle(c) {
+				continue
+			}
+			item, err := l.particle(c)
+			if err != nil {
+				return nil, err
+			}
+			p.items = append(p.items, item)
+		}
+	case "group":
+		name, ok, err := l.qnameAttr(el, "ref")
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, errorAt(el, "local groups must be references")
+		}
+		g, err := l.group(name)
+		if err != nil {
+			return nil, err
+		}
+		group := *g
+		group.min, group.max = min, max
+		return &group, nil
+	case "any":
+		p.kind = anyParticle
+		p.any = l.wildcard(el)
+	}
+	return p, nil
+}
+
+func (l *loader) group(name xml.Name) (*particle, error) {
+	if g, ok := l.groups[name]; ok {
+		return g, nil
+	}
+	raw, ok := l.rawGroups[name]
+	if !ok {
+		return nil, fmt.Errorf("schema: group %s is not defined", fmtName(name))
+	}
+	if l.busy[name] {
+		return nil, errorAt(raw, "group %s refers to itself", name.Local)
+	}
+	l.busy[name] = true
+	defer delete(l.busy, name)
+	for _, c := range xsChildren(raw) {
+		if isParticle(c) {
+			g, err := l.particle(c)
+			if err != nil {
+				return nil, err
+			}
+			l.groups[name] = g
+			return g, nil
+		}
+	}
+	return nil, errorAt(raw, "group has no content model")
+}
+
+func (l *loader) wildcard(el *dom.Element) *wildcard {
+	w := &wildcard{tns: l.tns, process: "strict", allowed: map[string]bool{}}
+	if v, ok := attr(el, "processContents"); ok {
+		w.process = v
+	}
+	ns, ok := attr(el, "namespace")
+	if !ok {
+		ns = "##any"
+	}
+	for _, tok := range strings.Fields(ns) {
+		switch tok {
+		case "##any":
+			w.any = true
+		case "##other":
+			w.other = true
+		case "##targetNamespace":
+			w.allowed[l.tns] = true
+		case "##local":
+			w.allowed[""] = true
+		default:
+			w.allowed[tok] = true
+		}
+	}
+	return w
+}
+
+func (l *loader) globalElement(name xml.Name) (*elementDecl, error) {
+	if d, ok := l.s.elements[name]; ok {
+		return d, nil
+	}
+	raw, ok := l.rawElements[name]
+	if !ok {
+		return nil, fmt.Errorf("schema: element %s is not declared", fmtName(name))
+	}
+	d := &elementDecl{name: name}
+	l.s.elements[name] = d
+	return d, l.elementDecl(raw, d)
+}
+
+func (l *loader) localElement(el *dom.Element) (*elementDecl, error) {
+	ref, ok, err := l.qnameAttr(el, "ref")
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return l.globalElement(ref)
+	}
+	name, ok := attr(el, "name")
+	if !ok {
+		return nil, errorAt(el, "element must have a name or a ref")
+	}
+	d := &elementDecl{name: xml.Name{Local: name}}
+	form, _ := attr(el, "form")
+	if form == "qualified" || (form == "" && l.elemQualified) {
+		d.name.Space = l.tns
+	}
+	return d, l.elementDecl(el, d)
+}
+
+func (l *loader) elementDecl(el *dom.Element, d *elementDecl) error {
+	if v, ok := attr(el, "nillable"); ok {
+		d.nillable = v == "true" || v == "1"
+	}
+	if v, ok := attr(el, "fixed"); ok {
+		d.fixed = &v
+	}
+	if v, ok := attr(el, "default"); ok {
+		d.def = &v
+	}
+	name, ok, err := l.qnameAttr(el, "type")
+	if err != nil {
+		return err
+	}
+	if ok {
+		d.typ, err = l.namedType(name)
+		return err
+	}
+	for _, c := range xsChildren(el) {
+		switch c.Name.Local {
+		case "simpleType":
+			st := &simpleType{}
+			d.typ = st
+			return l.simpleType(c, st)
+		case "complexType":
+			ct := &complexType{}
+			d.typ = ct
+			return l.complexType(c, ct)
+		}
+	}
+	d.typ = anyType
+	return nil
+}
+
+func (l *loader) globalAttr(name xml.Name) (*attrUse, error) {
+	if a, ok := l.s.attrs[name]; ok {
+		return a, nil
+	}
+	raw, ok := l.rawAttrs[name]
+	if !ok {
+		return nil, fmt.Errorf("schema: attribute %s is not declared", fmtName(name))
+	}
+	a := &attrUse{name: name}
+	l.s.attrs[name] = a
+	return a, l.attrType(raw, a)
+}
+
+func (l *loader) attrType(el *dom.Element, a *attrUse) error {
+	if v, ok := attr(el, "fixed"); ok {
+		a.fixed = &v
+	}
+	if v, ok := attr(el, "default"); ok {
+		a.def = &v
+	}
+	st, err := l.simpleTypeRef(el, "type")
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		if st, err = l.inlineSimpleType(el); err != nil {
+			return err
+		}
+	}
+	if st == nil {
+		st = builtinTypes["anySimpleType"]
+	}
+	a.typ = st
+	return nil
+}
+
+func (l *loader) localAttr(el *dom.Element) (*attrUse, error) {
+	a := &attrUse{}
+	ref, ok, err := l.qnameAttr(el, "ref")
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		global, err := l.globalAttr(ref)
+		if err != nil {
+			return nil, err
+		}
+		*a = *global
+		if v, ok := attr(el, "fixed"); ok {
+			a.fixed = &v
+		}
+		if v, ok := attr(el, "default"); ok {
+			a.def = &v
+		}
+	} else {
+		name, ok := attr(el, "name")
+		if !ok {
+			return nil, errorAt(el, "attribute must have a name or a ref")
+		}
+		a.name = xml.Name{Local: name}
+		form, _ := attr(el, "form")
+		if form == "qualified" || (form == "" && l.attrQualified) {
+			a.name.Space = l.tns
+		}
+		if err := l.attrType(el, a); err != nil {
+			return nil, err
+		}
+	}
+	switch v, _ := attr(el, "use"); v {
+	case "required":
+		a.required = true
+	case "prohibited":
+		a.prohibited = true
+	}
+	return a, nil
+}
+
+func mergeAttr(attrs []*attrUse, a *attrUse) []*attrUse {
+	for i, old := range attrs {
+		if old.name == a.name {
+			attrs[i] = a
+			return attrs
+		}
+	}
+	return append(attrs, a)
+}
+
+// attrDecl adds the attribute declarations, attribute group references
+// and attribute wildcard in el to attrs and any.
+func (l *loader) attrDecl(el *dom.Element, attrs *[]*attrUse, any **wildcard) error {
+	switch el.Name.Local {
+	case "attribute":
+		a, err := l.localAttr(el)
+		if err != nil {
+			return err
+		}
+		*attrs = mergeAttr(*attrs, a)
+	case "attributeGroup":
+		name, ok, err := l.qnameAttr(el, "ref")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errorAt(el, "local attribute groups must be references")
+		}
+		g, err := l.attrGroup(name)
+		if err != nil {
+			return err
+		}
+		for _, a := range g.attrs {
+			*attrs = mergeAttr(*attrs, a)
+		}
+		if g.any != nil {
+			*any = g.any
+		}
+	case "anyAttribute":
+		*any = l.wildcard(el)
+	}
+	return nil
+}
+
+func (l *loader) attrGroup(name xml.Name) (*attrGroup, error) {
+	if g, ok := l.attrGroups[name]; ok {
+		return g, nil
+	}
+	raw, ok := l.rawAttrGroups[name]
+	if !ok {
+		return nil, fmt.Errorf("schema: attribute group %s is not defined", fmtName(name))
+	}
+	if l.busy[name] {
+		return nil, errorAt(raw, "attribute group %s refers to itself", name.Local)
+	}
+	l.busy[name] = true
+	defer delete(l.busy, name)
+	g := &attrGroup{}
+	for _, c := range xsChildren(raw) {
+		if err := l.attrDecl(c, &g.attrs, &g.any); err != nil {
+			return nil, err
+		}
+	}
+	l.attrGroups[name] = g
+	return g, nil
+}
+
+func boolAttr(el *dom.Element, name string) bool {
+	v, _ := attr(el, name)
+	return v == "true" || v == "1"
+}
+
+func (l *loader) complexType(el *dom.Element, ct *complexType) error {
+	l.order = append(l.order, ct)
+	ct.mixed = boolAttr(el, "mixed")
+	ct.abstract = boolAttr(el, "abstract")
+	for _, c := range xsChildren(el) {
+		var err error
+		switch {
+		case c.Name.Local == "simpleContent" || c.Name.Local == "complexContent":
+			err = l.derivation(c, ct)
+		case isParticle(c):
+			ct.content, err = l.particle(c)
+		default:
+			err = l.attrDecl(c, &ct.attrs, &ct.anyAttr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// derivation handles the simpleContent and complexContent children of a
+// complex type definition.
+func (l *loader) derivation(el *dom.Element, ct *complexType) error {
+	simple := el.Name.Local == "simpleContent"
+	if v, ok := attr(el, "mixed"); ok {
+		ct.mixed = v == "true" || v == "1"
+	}
+	for _, d := range xsChildren(el) {
+		if d.Name.Local != "extension" && d.Name.Local != "restriction" {
+			continue
+		}
+		extension := d.Name.Local == "extension"
+		baseName, ok, err := l.qnameAttr(d, "base")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errorAt(d, "derivation has no base type")
+		}
+		base, err := l.namedType(baseName)
+		if err != nil {
+			return err
+		}
+		for _, c := range xsChildren(d) {
+			switch {
+			case isParticle(c):
+				if simple {
+					return errorAt(c, "simple content cannot have child elements")
+				}
+				ct.content, err = l.particle(c)
+			default:
+				err = l.attrDecl(c, &ct.attrs, &ct.anyAttr)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		switch bt := base.(type) {
+		case *simpleType:
+			if !simple {
+				return errorAt(d, "complex content cannot be derived from simple type %s", fmtName(baseName))
+			}
+			ct.simple = bt
+			if !extension {
+				st := &simpleType{}
+				st.setBase(bt)
+				if err := l.facets(d, st); err != nil {
+					return err
+				}
+				ct.simple = st
+			}
+		case *complexType:
+			def := &deferred{base: bt, extension: extension}
+			if simple && !extension {
+				def.restriction = &simpleType{}
+				if err := l.facets(d, def.restriction); err != nil {
+					return err
+				}
+			}
+			l.derived[ct] = def
+		}
+		return nil
+	}
+	return errorAt(el, "must contain an extension or a restriction")
+}
+
+// finish completes the derivation of ct from its base type, once the base
+// has been completely compiled.
+func (l *loader) finish(ct *complexType) error {
+	def, ok := l.derived[ct]
+	if !ok || def.done {
+		return nil
+	}
+	if def.busy {
+		return fmt.Errorf("schema: type %s is derived from itself", fmtName(ct.name))
+	}
+	def.busy = true
+	if err := l.finish(def.base); err != nil {
+		return err
+	}
+	bt := def.base
+	own := ct.attrs
+	ct.attrs = append([]*attrUse{}, bt.attrs...)
+	for _, a := range own {
+		ct.attrs = mergeAttr(ct.attrs, a)
+	}
+	if ct.anyAttr == nil {
+		ct.anyAttr = bt.anyAttr
+	}
+	switch {
+	case def.restriction != nil:
+		if bt.simple == nil {
+			return fmt.Errorf("schema: type %s restricts %s, which does not have simple content",
+				fmtName(ct.name), fmtName(bt.name))
+		}
+		def.restriction.setBase(bt.simple)
+		ct.simple = def.restriction
+	case bt.simple != nil && def.extension:
+		ct.simple = bt.simple
+	case def.extension:
+		if bt != anyType && bt.mixed {
+			ct.mixed = true
+		}
+		switch {
+		case bt.content == nil:
+		case ct.content == nil:
+			ct.content = bt.content
+		default:
+			ct.content = &particle{
+				kind:  sequenceParticle,
+				min:   1,
+				max:   1,
+				items: []*particle{bt.content, ct.content},
+			}
+		}
+	}
+	def.done = true
+	return nil
+}
@@ -0,0 +1,236 @@
+// Package schema validates simplexml/dom trees against W3C XML Schemas.
+//
+// It supports the commonly used parts of XSD 1.0:
+//
+// 1. Global and local element and attribute declarations, including refs,
+// minOccurs/maxOccurs, nillable, fixed and default values.
+//
+// 2. Named and anonymous complex types with sequence, choice, all, group
+// refs, attribute groups, wildcards, mixed content, and simple and complex
+// content derived by extension or restriction.
+//
+// 3. Named and anonymous simple types derived by restriction (with all the
+// facets except whiteSpace on non-string types), list and union, on top
+// of the built-in datatypes.
+//
+// xs:include is supported when the schema is loaded with LoadWith, which
+// reads the included schema documents through a dom.Resolver.  Identity
+// constraints, substitution groups, redefine, and xs:import with a
+// schemaLocation are not supported.
+//
+// Documents can be validated after they have been parsed, with Validate,
+// or while they are, with a StreamValidator, which can stop the parse at
+// the first Violation.
+//
+// Infer goes the other way, drafting a schema from sample documents, and
+// GoStructs writes encoding/xml structs for the documents a schema
+// describes.
+//
+// For some basic usage examples, see schema_test.go
+package schema
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+// typeDef is either a *simpleType or a *complexType.
+type typeDef interface {
+	typeName() xml.Name
+}
+
+type particleKind int
+
+const (
+	elementParticle particleKind = iota
+	sequenceParticle
+	choiceParticle
+	allParticle
+	anyParticle
+)
+
+// particle is a node in a content model.  max < 0 means unbounded.
+type particle struct {
+	kind     particleKind
+	min, max int
+	elem     *elementDecl
+	items    []*particle
+	any      *wildcard
+}
+
+type elementDecl struct {
+	name     xml.Name
+	typ      typeDef
+	nillable bool
+	fixed    *string
+	def      *string
+}
+
+type attrUse struct {
+	name       xml.Name
+	typ        *simpleType
+	required   bool
+	prohibited bool
+	fixed      *string
+	def        *string
+}
+
+type wildcard struct {
+	any     bool
+	other   bool
+	allowed map[string]bool
+	tns     string
+	process string
+}
+
+func (w *wildcard) allows(ns string) bool {
+	switch {
+	case w.any:
+		return true
+	case w.other:
+		return ns != w.tns && ns != ""
+	}
+	return w.allowed[ns]
+}
+
+type complexType struct {
+	name     xml.Name
+	mixed    bool
+	content  *particle
+	simple   *simpleType
+	attrs    []*attrUse
+	anyAttr  *wildcard
+	abstract bool
+}
+
+func (ct *complexType) typeName() xml.Name {
+	return ct.name
+}
+
+func (ct *complexType) attr(name xml.Name) *attrUse {
+	for _, a := range ct.attrs {
+		if a.name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+var anyType = &complexType{
+	name:  xml.Name{Space: dom.NS_XS, Local: "anyType"},
+	mixed: true,
+	content: &particle{
+		kind: anyParticle,
+		min:  0,
+		max:  -1,
+		any:  &wildcard{any: true, process: "lax"},
+	},
+	anyAttr: &wildcard{any: true, process: "lax"},
+}
+
+// builtinTypes holds a simple type for each of the builtins.
+var builtinTypes = map[string]*simpleType{}
+
+// Schema is a compiled XML Schema.  A Schema is safe for concurrent use.
+type Schema struct {
+	// TargetNamespace is the namespace the schema declares components in.
+	TargetNamespace string
+	elements        map[xml.Name]*elementDecl
+	types           map[xml.Name]typeDef
+	attrs           map[xml.Name]*attrUse
+}
+
+// Load compiles the schema held in doc.
+func Load(doc *dom.Document) (*Schema, error) {
+	return LoadWith(doc, nil)
+}
+
+// LoadWith is like Load, but reads the schema documents xs:include
+// elements name through r, resolving their schemaLocation against the
+// base URI of the xs:include.  The included documents must have the
+// same target namespace and form defaults as doc.
+func LoadWith(doc *dom.Document, r dom.Resolver) (*Schema, error) {
+	root := doc.Root()
+	if root == nil || root.Name.Space != dom.NS_XS || root.Name.Local != "schema" {
+		return nil, fmt.Errorf("schema: document is not an XML Schema")
+	}
+	l := newLoader(root)
+	l.resolver = r
+	if base, _ := root.BaseURI(); base != "" {
+		// Schema documents that include each other are read once.
+		l.included[base] = true
+	}
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	return l.s, nil
+}
+
+// Parse parses an XML Schema from r and compiles it.
+func Parse(r io.Reader) (*Schema, error) {
+	doc, err := dom.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	return Load(doc)
+}
+
+// Violation describes one way in which a tree does not conform to a
+// Schema.
+type Violation struct {
+	// Element is the element the violation was found on.
+	Element *dom.Element
+	// Pos is where Element was found in its source document, if it was
+	// parsed.
+	Pos dom.Position
+	// Path is a /-separated path to Element from the top of its tree.
+	Path    string
+	Message string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%v: %s: %s", v.Pos, v.Path, v.Message)
+}
+
+// Error makes a Violation usable as an error.
+func (v Violation) Error() string {
+	return v.String()
+}
+
+// Path returns a path to e from the top of its tree, as used in
+// Violations.  It is the same as e.Path().
+func Path(e *dom.Element) string {
+	return e.Path()
+}
+
+// Validate validates doc against s, and returns all the violations it
+// found in document order.  A nil return means doc is valid.
+func (s *Schema) Validate(doc *dom.Document) []Violation {
+	if doc.Root() == nil {
+		return []Violation{{Path: "/", Message: "document has no root element"}}
+	}
+	return s.ValidateElement(doc.Root())
+}
+
+// ValidateElement validates the subtree rooted at e against the global
+// element declaration for e's name.
+func (s *Schema) ValidateElement(e *dom.Element) []Violation {
+	v := &validator{s: s}
+	decl, ok := s.elements[e.Name]
+	if !ok {
+		v.report(e, "no global declaration for element %s", fmtName(e.Name))
+		return v.violations
+	}
+	v.element(e, decl)
+	return v.violations
+}
+
+func fmtName(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return "{" + n.Space + "}" + n.Local
+}
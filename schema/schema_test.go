@@ -0,0 +1,459 @@
+package schema
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+var testSchema = `<?xml version="1.0" encoding="UTF-8"?>
+<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema"
+           xmlns:o="urn:orders"
+           targetNamespace="urn:orders"
+           elementFormDefault="qualified">
+ <xs:simpleType name="sku">
+  <xs:restriction base="xs:string">
+   <xs:pattern value="\d{3}-[A-Z]{2}"/>
+  </xs:restriction>
+ </xs:simpleType>
+ <xs:simpleType name="sizes">
+  <xs:list>
+   <xs:simpleType>
+    <xs:restriction base="xs:token">
+     <xs:enumeration value="S"/>
+     <xs:enumeration value="M"/>
+     <xs:enumeration value="L"/>
+    </xs:restriction>
+   </xs:simpleType>
+  </xs:list>
+ </xs:simpleType>
+ <xs:complexType name="price">
+  <xs:simpleContent>
+   <xs:extension base="xs:decimal">
+    <xs:attribute name="currency" type="xs:string" use="required"/>
+   </xs:extension>
+  </xs:simpleContent>
+ </xs:complexType>
+ <xs:complexType name="party">
+  <xs:sequence>
+   <xs:element name="name" type="xs:string"/>
+   <xs:element name="email" type="xs:string" minOccurs="0" maxOccurs="unbounded"/>
+  </xs:sequence>
+ </xs:complexType>
+ <xs:complexType name="customer">
+  <xs:complexContent>
+   <xs:extension base="o:party">
+    <xs:sequence>
+     <xs:element name="vip" type="xs:boolean" minOccurs="0"/>
+    </xs:sequence>
+    <xs:attribute name="id" type="xs:positiveInteger"/>
+   </xs:extension>
+  </xs:complexContent>
+ </xs:complexType>
+ <xs:group name="lineContents">
+  <xs:sequence>
+   <xs:element name="sku" type="o:sku"/>
+   <xs:choice>
+    <xs:element name="qty">
+     <xs:simpleType>
+      <xs:restriction base="xs:int">
+       <xs:minInclusive value="1"/>
+       <xs:maxExclusive value="100"/>
+      </xs:restriction>
+     </xs:simpleType>
+    </xs:element>
+    <xs:element name="sizes" type="o:sizes"/>
+   </xs:choice>
+   <xs:element name="price" type="o:price"/>
+  </xs:sequence>
+ </xs:group>
+ <xs:element name="order">
+  <xs:complexType>
+   <xs:sequence>
+    <xs:element name="customer" type="o:customer"/>
+    <xs:element name="line" maxOccurs="unbounded">
+     <xs:complexType>
+      <xs:group ref="o:lineContents"/>
+     </xs:complexType>
+    </xs:element>
+    <xs:element name="note" type="xs:string" minOccurs="0" nillable="true"/>
+    <xs:any namespace="##other" processContents="lax" minOccurs="0" maxOccurs="unbounded"/>
+   </xs:sequence>
+   <xs:attribute name="date" type="xs:date" use="required"/>
+   <xs:attribute name="version" type="xs:string" fixed="1"/>
+  </xs:complexType>
+ </xs:element>
+</xs:schema>
+`
+
+var validOrder = `<?xml version="1.0" encoding="UTF-8"?>
+<order xmlns="urn:orders" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" date="2013-04-01">
+ <customer id="7">
+  <name>Fred</name>
+  <email>fred@example.com</email>
+  <email>fred@example.org</email>
+  <vip>true</vip>
+ </customer>
+ <line>
+  <sku>123-AB</sku>
+  <qty>3</qty>
+  <price currency="EUR">9.99</price>
+ </line>
+ <line>
+  <sku>456-CD</sku>
+  <sizes>S M L</sizes>
+  <price currency="USD">1</price>
+ </line>
+ <note xsi:nil="true"/>
+ <x:extra xmlns:x="urn:extra"><x:anything/></x:extra>
+</order>
+`
+
+func loadSchema(t *testing.T) *Schema {
+	s, err := Parse(strings.NewReader(testSchema))
+	if err != nil {
+		t.Fatalf("Cannot load test schema: %v", err)
+	}
+	return s
+}
+
+func validate(t *testing.T, s *Schema, src string) []Violation {
+	doc, err := dom.Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("Cannot parse instance: %v", err)
+	}
+	return s.Validate(doc)
+}
+
+func TestValid(t *testing.T) {
+	s := loadSchema(t)
+	if vs := validate(t, s, validOrder); len(vs) != 0 {
+		t.Errorf("Expected valid document, got %v", vs)
+	}
+}
+
+func TestViolations(t *testing.T) {
+	s := loadSchema(t)
+	tests := []struct {
+		from, to string
+		path     string
+		message  string
+	}{
+		{`date="2013-04-01"`, `date="yesterday"`, "/order", "invalid value for attribute date"},
+		{` date="2013-04-01"`, ``, "/order", "missing required attribute date"},
+		{`<order `, `<order version="2" `, "/order", "attribute version must be \"1\""},
+		{`<order `, `<order bogus="1" `, "/order", "attribute bogus is not allowed"},
+		{`<sku>123-AB</sku>`, `<sku>12-AB</sku>`, "/order/line[1]/sku", "invalid value"},
+		{`<qty>3</qty>`, `<qty>100</qty>`, "/order/line[1]/qty", "invalid value"},
+		{`<sizes>S M L</sizes>`, `<sizes>S XL</sizes>`, "/order/line[2]/sizes", "invalid value"},
+		{`<price currency="EUR">9.99</price>`, `<price>9.99</price>`, "/order/line[1]/price", "missing required attribute currency"},
+		{`<price currency="EUR">9.99</price>`, `<price currency="EUR">cheap</price>`, "/order/line[1]/price", "invalid value"},
+		{`<name>Fred</name>`, ``, "/order/customer/email[1]", "unexpected element {urn:orders}email, expected {urn:orders}name"},
+		{`<vip>true</vip>`, `<vip>true</vip><vip>false</vip>`, "/order/customer/vip[2]", "unexpected element {urn:orders}vip"},
+		{`<qty>3</qty>`, `<qty>3</qty><sizes>S</sizes>`, "/order/line[1]/sizes", "unexpected element {urn:orders}sizes, expected {urn:orders}price"},
+		{`<price currency="USD">1</price>`, ``, "/order/line[2]", "missing element, expected {urn:orders}price"},
+		{`<customer id="7">`, `<customer id="0">`, "/order/customer", "invalid value for attribute id"},
+		{`<note xsi:nil="true"/>`, `<note xsi:nil="true">text</note>`, "/order/note", "nil element must be empty"},
+		{`<name>Fred</name>`, `<name>Fred<b/></name>`, "/order/customer/name", "may not have child elements"},
+		{`<vip>true</vip>`, `<vip>true</vip>stray`, "/order/customer", "text content is not allowed"},
+		{`<x:extra xmlns:x="urn:extra">`, `<x:extra xmlns:x="urn:orders">`, "/order/extra", "unexpected element {urn:orders}extra"},
+	}
+	for _, test := range tests {
+		src := strings.Replace(validOrder, test.from, test.to, 1)
+		vs := validate(t, s, src)
+		if len(vs) != 1 {
+			t.Errorf("Replacing %q with %q: expected 1 violation, got %v", test.from, test.to, vs)
+			continue
+		}
+		if vs[0].Path != test.path || !strings.Contains(vs[0].Message, test.message) {
+			t.Errorf("Replacing %q with %q: expected %s: %s, got %s: %s",
+				test.from, test.to, test.path, test.message, vs[0].Path, vs[0].Message)
+		}
+		if !vs[0].Pos.IsValid() {
+			t.Errorf("Replacing %q with %q: violation has no position", test.from, test.to)
+		}
+	}
+}
+
+func TestViolationPosition(t *testing.T) {
+	s := loadSchema(t)
+	vs := validate(t, s, strings.Replace(validOrder, "<qty>3</qty>", "<qty>0</qty>", 1))
+	if len(vs) != 1 {
+		t.Fatalf("Expected 1 violation, got %v", vs)
+	}
+	if vs[0].Pos.Line != 11 || vs[0].Pos.Column != 3 {
+		t.Errorf("Expected violation at 11:3, got %v", vs[0].Pos)
+	}
+	if !strings.HasPrefix(vs[0].Error(), "11:3: /order/line[1]/qty: ") {
+		t.Errorf("Unexpected error string %q", vs[0].Error())
+	}
+}
+
+func TestBuiltModel(t *testing.T) {
+	s := loadSchema(t)
+	const ns = "urn:orders"
+	order := dom.Elem("order", ns).Attr("date", "", "2013-01-01").AddChildren(
+		dom.Elem("customer", ns).AddChild(dom.ElemC("name", ns, "x")),
+		dom.Elem("line", ns).AddChildren(
+			dom.ElemC("sku", ns, "000-ZZ"),
+			dom.ElemC("qty", ns, "5"),
+			dom.ElemC("price", ns, "2.50").Attr("currency", "", "GBP")))
+	if vs := s.ValidateElement(order); len(vs) != 0 {
+		t.Errorf("Expected built tree to be valid, got %v", vs)
+	}
+	if vs := s.ValidateElement(dom.Elem("line", ns)); len(vs) != 1 {
+		t.Errorf("Expected local element to have no global declaration, got %v", vs)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, src := range []string{
+		`<root/>`,
+		`<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema"><xs:include schemaLocation="other.xsd"/></xs:schema>`,
+		`<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema"><xs:element name="a" type="nope"/></xs:schema>`,
+		`<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema"><xs:element name="a" type="q:t"/></xs:schema>`,
+		`<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema"><xs:simpleType name="t"><xs:restriction base="xs:string"><xs:pattern value="[a-z-[aeiou]]"/></xs:restriction></xs:simpleType></xs:schema>`,
+	} {
+		if _, err := Parse(strings.NewReader(src)); err == nil {
+			t.Errorf("Expected %s to fail to load", src)
+		}
+	}
+}
+
+func TestInclude(t *testing.T) {
+	files := map[string]string{
+		"xsd/types.xsd": `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" targetNamespace="urn:t" elementFormDefault="qualified">
+ <xs:include schemaLocation="main.xsd"/>
+ <xs:simpleType name="code"><xs:restriction base="xs:string"><xs:length value="3"/></xs:restriction></xs:simpleType>
+</xs:schema>`,
+		"xsd/other.xsd": `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" targetNamespace="urn:other"/>`,
+	}
+	r := dom.ResolverFunc(func(uri string) (io.ReadCloser, error) {
+		src, ok := files[uri]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return io.NopCloser(strings.NewReader(src)), nil
+	})
+	src := `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" xmlns:t="urn:t" targetNamespace="urn:t" elementFormDefault="qualified">
+ <xs:include schemaLocation="types.xsd"/>
+ <xs:element name="item" type="t:code"/>
+</xs:schema>`
+	doc, err := dom.Parse(strings.NewReader(src), dom.WithBaseURI("xsd/main.xsd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := LoadWith(doc, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vs := s.ValidateElement(dom.ElemC("item", "urn:t", "abc")); len(vs) != 0 {
+		t.Errorf("expected a valid item, got %v", vs)
+	}
+	if vs := s.ValidateElement(dom.ElemC("item", "urn:t", "abcd")); len(vs) != 1 {
+		t.Errorf("expected the included type to be checked, got %v", vs)
+	}
+	if _, err := Load(doc); err == nil {
+		t.Errorf("expected xs:include to fail without a Resolver")
+	}
+	files["xsd/types.xsd"] = strings.Replace(files["xsd/types.xsd"], `<xs:include schemaLocation="main.xsd"/>`, `<xs:include schemaLocation="other.xsd"/>`, 1)
+	if _, err := LoadWith(doc, r); err == nil || !strings.Contains(err.Error(), "target namespace") {
+		t.Errorf("expected a target namespace mismatch, got %v", err)
+	}
+}
+
+func TestDatatype(t *testing.T) {
+	dt, err := NewDatatype("decimal", Facet{"maxInclusive", "10"}, Facet{"fractionDigits", "1"})
+	if err != nil {
+		t.Fatalf("Cannot make datatype: %v", err)
+	}
+	for v, ok := range map[string]bool{"1.5": true, " 10 ": true, "10.5": false, "1.25": false, "x": false} {
+		if err := dt.Validate(v); (err == nil) != ok {
+			t.Errorf("Validate(%q): expected ok=%v, got %v", v, ok, err)
+		}
+	}
+	if !dt.Equal("1.0", "1") {
+		t.Error("Expected 1.0 and 1 to be equal decimals")
+	}
+	if _, err := NewDatatype("decimal", Facet{"colour", "red"}); err == nil {
+		t.Error("Expected an unknown facet to fail")
+	}
+}
+
+func TestInfer(t *testing.T) {
+	samples := []string{
+		`<o:order xmlns:o="urn:o" xmlns:x="urn:x" id="1" x:note="n">
+  <o:date>2024-01-02</o:date>
+  <o:line sku="a" qty="2"><o:price>1.50</o:price></o:line>
+  <o:line sku="b" qty="1"><o:price>3</o:price></o:line>
+  <o:paid>true</o:paid>
+  <x:extra/>
+</o:order>`,
+		`<o:order xmlns:o="urn:o" id="2">
+  <o:date>2024-02-03</o:date>
+  <o:line sku="c" qty="4" gift="yes"><o:price>2</o:price></o:line>
+  <o:p>some <o:b>bold</o:b> text <o:i>and</o:i> <o:b>more</o:b></o:p>
+</o:order>`,
+	}
+	docs := []*dom.Document{}
+	for _, src := range samples {
+		doc, err := dom.Parse(strings.NewReader(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, doc)
+	}
+	xsd, err := Infer(docs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Load(xsd)
+	if err != nil {
+		t.Fatalf("cannot load the inferred schema %s: %v", xsd, err)
+	}
+	for _, doc := range docs {
+		if vs := s.Validate(doc); len(vs) != 0 {
+			t.Errorf("expected the samples to be valid against %s, got %v", xsd, vs)
+		}
+	}
+	out := xsd.String()
+	for _, want := range []string{
+		`<xs:element name="order">`,
+		`<xs:element ref="tns:line" maxOccurs="unbounded"/>`,
+		`<xs:element ref="tns:paid" minOccurs="0"/>`,
+		`<xs:any namespace="urn:x" processContents="lax" minOccurs="0"/>`,
+		`<xs:attribute name="id" type="xs:integer" use="required"/>`,
+		`<xs:attribute name="gift" type="xs:string"/>`,
+		`<xs:element name="date" type="xs:date"/>`,
+		`<xs:element name="price" type="xs:decimal"/>`,
+		`<xs:element name="paid" type="xs:boolean"/>`,
+		`<xs:complexType mixed="true">`,
+		`<xs:choice minOccurs="0" maxOccurs="unbounded">`,
+		`<xs:anyAttribute namespace="##any" processContents="lax"/>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %s in\n%s", want, out)
+		}
+	}
+	if !strings.HasPrefix(out[strings.Index(out, "<xs:element"):], `<xs:element name="order">`) {
+		t.Errorf("expected the root element to be declared first in\n%s", out)
+	}
+	bad, _ := dom.Parse(strings.NewReader(`<o:order xmlns:o="urn:o" id="x"/>`))
+	if vs := s.Validate(bad); len(vs) == 0 {
+		t.Errorf("expected a non-integer id to be invalid")
+	}
+	if _, err := Infer(); err == nil {
+		t.Errorf("expected Infer to fail without documents")
+	}
+}
+
+func TestGoStructs(t *testing.T) {
+	s, err := Parse(strings.NewReader(`<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema"
+    xmlns:tns="urn:o" targetNamespace="urn:o" elementFormDefault="qualified">
+  <xs:complexType name="address">
+    <xs:sequence><xs:element name="city" type="xs:string"/></xs:sequence>
+  </xs:complexType>
+  <xs:element name="order">
+    <xs:complexType>
+      <xs:sequence>
+        <xs:element name="ship-to" type="tns:address"/>
+        <xs:element ref="tns:line" maxOccurs="unbounded"/>
+        <xs:element name="note" type="xs:string" minOccurs="0"/>
+        <xs:element name="gift" minOccurs="0">
+          <xs:complexType><xs:attribute name="wrap" type="xs:boolean"/></xs:complexType>
+        </xs:element>
+        <xs:any namespace="##other" minOccurs="0"/>
+      </xs:sequence>
+      <xs:attribute name="id" type="xs:int" use="required"/>
+    </xs:complexType>
+  </xs:element>
+  <xs:element name="line">
+    <xs:complexType>
+      <xs:simpleContent>
+        <xs:extension base="xs:decimal"><xs:attribute name="sku" type="xs:string"/></xs:extension>
+      </xs:simpleContent>
+    </xs:complexType>
+  </xs:element>
+</xs:schema>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := s.GoStructs("orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(src)
+	for _, want := range []string{
+		"package orders\n",
+		"type AnyElement struct {",
+		"// Address is the address type.\ntype Address struct {\n\tCity string `xml:\"urn:o city\"`\n}",
+		"\tXMLName xml.Name     `xml:\"urn:o order\"`",
+		"\tId      int32        `xml:\"id,attr\"`",
+		"\tShipTo  Address      `xml:\"urn:o ship-to\"`",
+		"\tLine    []Line       `xml:\"urn:o line\"`",
+		"\tNote    string       `xml:\"urn:o note,omitempty\"`",
+		"\tGift    *OrderGift   `xml:\"urn:o gift\"`",
+		"\tAny     []AnyElement `xml:\",any\"`",
+		"// OrderGift is what the gift elements in Order hold.\ntype OrderGift struct {\n\tWrap bool `xml:\"wrap,attr,omitempty\"`\n}",
+		"\tSku     string   `xml:\"sku,attr,omitempty\"`\n\tValue   float64  `xml:\",chardata\"`",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in\n%s", want, out)
+		}
+	}
+	if strings.Index(out, "type Line ") > strings.Index(out, "type Order ") {
+		t.Errorf("expected the structs in order of their elements' names in\n%s", out)
+	}
+}
+
+func TestStreamValidator(t *testing.T) {
+	s := loadSchema(t)
+	sv := NewStreamValidator(s, 0)
+	if _, err := dom.Parse(strings.NewReader(validOrder), dom.WithValidator(sv)); err != nil || len(sv.Violations()) != 0 {
+		t.Fatalf("Expected valid document, got %v, %v", err, sv.Violations())
+	}
+	// Each of the violations Validate finds is found while parsing.
+	for _, test := range []struct{ from, to, message string }{
+		{`date="2013-04-01"`, `date="yesterday"`, "invalid value for attribute date"},
+		{`<sku>123-AB</sku>`, `<sku>12-AB</sku>`, "invalid value"},
+		{`<name>Fred</name>`, ``, "unexpected element {urn:orders}email, expected {urn:orders}name"},
+		{`<price currency="USD">1</price>`, ``, "missing element, expected {urn:orders}price"},
+		{`<note xsi:nil="true"/>`, `<note xsi:nil="true">text</note>`, "nil element must be empty"},
+		{`<name>Fred</name>`, `<name>Fred<b/></name>`, "may not have child elements"},
+		{`<x:extra xmlns:x="urn:extra">`, `<x:extra xmlns:x="urn:orders">`, "unexpected element {urn:orders}extra"},
+	} {
+		src := strings.Replace(validOrder, test.from, test.to, 1)
+		sv := NewStreamValidator(s, 0)
+		if _, err := dom.Parse(strings.NewReader(src), dom.WithValidator(sv)); err != nil {
+			t.Errorf("Replacing %q with %q: %v", test.from, test.to, err)
+			continue
+		}
+		if vs := sv.Violations(); len(vs) != 1 || !strings.Contains(vs[0].Message, test.message) {
+			t.Errorf("Replacing %q with %q: expected %q, got %v", test.from, test.to, test.message, vs)
+		}
+	}
+	// With a limit, the parse stops at the first violation.
+	src := strings.Replace(validOrder, `<qty>3</qty>`, `<qty>0</qty>`, 1)
+	sv = NewStreamValidator(s, 1)
+	opened := 0
+	counted := dom.ValidatorFuncs{OnOpen: func(e *dom.Element) error {
+		opened++
+		return sv.Open(e)
+	}, OnClose: sv.Close}
+	_, err := dom.Parse(strings.NewReader(src), dom.WithValidator(counted))
+	var v Violation
+	var derr *dom.Error
+	if !errors.As(err, &v) || !errors.As(err, &derr) {
+		t.Fatalf("Expected the parse to fail with a Violation, got %v", err)
+	}
+	if derr.Op != "validate" || derr.Path != "/order/line/qty" || v.Path != "/order/line/qty" || v.Pos.Line != 11 {
+		t.Errorf("Unexpected error %v: %v", derr, v)
+	}
+	if opened != 9 || len(sv.Violations()) != 1 {
+		t.Errorf("Expected the parse to stop after 9 elements and 1 violation, got %d and %v", opened, sv.Violations())
+	}
+}
@@ -0,0 +1,184 @@
+package schema
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+// How a StreamValidator deals with an element.
+const (
+	// checkElement validates the element against its declaration.
+	checkElement = iota
+	// laxElement validates whatever in the element has a global
+	// declaration.
+	laxElement
+	// skipElement leaves the element and everything in it alone.
+	skipElement
+)
+
+// frame is what a StreamValidator knows about an open element.
+type frame struct {
+	mode int
+	decl *elementDecl
+}
+
+// StreamValidator validates a document against a Schema while the dom
+// parser reads it, for documents too large to read in full before
+// finding out they are not valid:
+//    sv := NewStreamValidator(s, 1)
+//    doc, err := dom.Parse(r, dom.WithValidator(sv))
+//    var v Violation
+//    if errors.As(err, &v) {
+//        ...
+//    }
+// It finds the same Violations as Validate does, in the order it finds
+// them, which is the order the elements end in, and stops the parse
+// once it has found as many as its limit.  It works out which
+// declaration each element is validated against as the element is
+// opened, from the name of the element and the content model of its
+// parent, which gives the same result as Validate for schemas whose
+// content models have one declaration for each name, as XSD requires.
+// The Paths of its Violations count only the siblings before each
+// element, as the parser knows of no others yet.
+//
+// A StreamValidator is for one parse at a time, and not safe for
+// concurrent use.
+type StreamValidator struct {
+	v     *validator
+	limit int
+	stack []frame
+}
+
+// NewStreamValidator returns a StreamValidator for s that stops the
+// parse with the limit'th Violation it finds, or never stops it if
+// limit is not positive.
+func NewStreamValidator(s *Schema, limit int) *StreamValidator {
+	return &StreamValidator{v: &validator{s: s, shallow: true}, limit: limit}
+}
+
+// Violations returns all the Violations sv has found.  Unlike Validate,
+// it does not report documents without a root element.
+func (sv *StreamValidator) Violations() []Violation {
+	return sv.v.violations
+}
+
+// stop returns the Violation that reached the limit, if one has.
+func (sv *StreamValidator) stop() error {
+	if sv.limit > 0 && len(sv.v.violations) >= sv.limit {
+		return sv.v.violations[sv.limit-1]
+	}
+	return nil
+}
+
+// find returns the declaration for name in the content model p, or
+// failing that the first wildcard in it that allows name.
+func find(p *particle, name xml.Name) (*elementDecl, *wildcard) {
+	if p == nil {
+		return nil, nil
+	}
+	switch p.kind {
+	case elementParticle:
+		if p.elem.name == name {
+			return p.elem, nil
+		}
+	case anyParticle:
+		if p.any.allows(name.Space) {
+			return nil, p.any
+		}
+	default:
+		var w *wildcard
+		for _, item := range p.items {
+			decl, any := find(item, name)
+			if decl != nil {
+				return decl, nil
+			}
+			if w == nil {
+				w = any
+			}
+		}
+		return nil, w
+	}
+	return nil, nil
+}
+
+// global returns how to deal with an element that a parent in lax mode,
+// or a wildcard that processes its contents as process, lets in.
+func (sv *StreamValidator) global(e *dom.Element, process string) frame {
+	if decl, ok := sv.v.s.elements[e.Name]; ok {
+		return frame{mode: checkElement, decl: decl}
+	}
+	if process == "strict" {
+		sv.v.report(e, "no declaration for element %s", fmtName(e.Name))
+		return frame{mode: skipElement}
+	}
+	return frame{mode: laxElement}
+}
+
+// child returns how to deal with e, given how its parent is dealt with.
+func (sv *StreamValidator) child(e *dom.Element, parent frame) frame {
+	switch parent.mode {
+	case skipElement:
+		return parent
+	case laxElement:
+		return sv.global(e, "lax")
+	}
+	p := e.Parent()
+	if nilv, ok := xsiAttr(p, "nil"); ok && (strings.TrimSpace(nilv) == "true" || strings.TrimSpace(nilv) == "1") {
+		// Validate only says the parent must be empty.
+		return frame{mode: skipElement}
+	}
+	typ := parent.decl.typ
+	if tn, ok := xsiAttr(p, "type"); ok {
+		name, err := resolveQName(p, strings.TrimSpace(tn))
+		if err != nil {
+			return frame{mode: skipElement}
+		}
+		typ = sv.v.lookupType(name)
+	}
+	ct, ok := typ.(*complexType)
+	if !ok || ct.simple != nil {
+		// The parent may not have children at all.
+		return frame{mode: skipElement}
+	}
+	decl, any := find(ct.content, e.Name)
+	switch {
+	case decl != nil:
+		return frame{mode: checkElement, decl: decl}
+	case any == nil:
+		// The parent reports e as unexpected.
+		return frame{mode: laxElement}
+	case any.process == "skip":
+		return frame{mode: skipElement}
+	}
+	return sv.global(e, any.process)
+}
+
+// Open works out which declaration e is to be validated against.
+func (sv *StreamValidator) Open(e *dom.Element) error {
+	var f frame
+	if len(sv.stack) == 0 {
+		decl, ok := sv.v.s.elements[e.Name]
+		if ok {
+			f = frame{mode: checkElement, decl: decl}
+		} else {
+			sv.v.report(e, "no global declaration for element %s", fmtName(e.Name))
+			f = frame{mode: skipElement}
+		}
+	} else {
+		f = sv.child(e, sv.stack[len(sv.stack)-1])
+	}
+	sv.stack = append(sv.stack, f)
+	return sv.stop()
+}
+
+// Close validates e, now that all of it has been read.
+func (sv *StreamValidator) Close(e *dom.Element) error {
+	f := sv.stack[len(sv.stack)-1]
+	sv.stack = sv.stack[:len(sv.stack)-1]
+	if f.mode == checkElement {
+		sv.v.element(e, f.decl)
+	}
+	return sv.stop()
+}
@@ -0,0 +1,614 @@
+package schema
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+type variety int
+
+const (
+	atomic variety = iota
+	list
+	union
+)
+
+type whitespace int
+
+const (
+	wsPreserve whitespace = iota
+	wsReplace
+	wsCollapse
+)
+
+// ordering is how values of a primitive type are compared by the
+// min/max facets.
+type ordering int
+
+const (
+	unordered ordering = iota
+	decimalOrder
+	floatOrder
+	timeOrder
+)
+
+// builtin describes one of the XSD built-in datatypes.
+type builtin struct {
+	name  string
+	ws    whitespace
+	order ordering
+	// binary types measure length in octets rather than characters.
+	binary bool
+	check  func(string) error
+	// timeLayouts are used to order date and time types.
+	timeLayouts []string
+}
+
+// simpleType is a compiled simple type definition.  Built-in types have a
+// prim and no base.  Restrictions have a base, and share their variety,
+// prim and item type with it.
+type simpleType struct {
+	name    xml.Name
+	variety variety
+	base    *simpleType
+	prim    *builtin
+	item    *simpleType
+	members []*simpleType
+	facets  facets
+}
+
+type facets struct {
+	length, minLength, maxLength *int
+	totalDigits, fractionDigits  *int
+	minIncl, maxIncl             *string
+	minExcl, maxExcl             *string
+	enums                        []string
+	patterns                     []*regexp.Regexp
+	patternSrc                   []string
+	ws                           *whitespace
+}
+
+func intFacet(v string) (*int, error) {
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 {
+		return nil, fmt.Errorf("%q is not a valid facet value", v)
+	}
+	return &i, nil
+}
+
+// set sets the facet called name to v, and reports whether name is a
+// facet at all.
+func (f *facets) set(name, v string) (bool, error) {
+	var err error
+	switch name {
+	case "length":
+		f.length, err = intFacet(v)
+	case "minLength":
+		f.minLength, err = intFacet(v)
+	case "maxLength":
+		f.maxLength, err = intFacet(v)
+	case "totalDigits":
+		f.totalDigits, err = intFacet(v)
+	case "fractionDigits":
+		f.fractionDigits, err = intFacet(v)
+	case "minInclusive":
+		f.minIncl = &v
+	case "maxInclusive":
+		f.maxIncl = &v
+	case "minExclusive":
+		f.minExcl = &v
+	case "maxExclusive":
+		f.maxExcl = &v
+	case "enumeration":
+		f.enums = append(f.enums, v)
+	case "pattern":
+		re, perr := translatePattern(v)
+		if perr != nil {
+			return true, perr
+		}
+		f.patterns = append(f.patterns, re)
+		f.patternSrc = append(f.patternSrc, v)
+	case "whiteSpace":
+		ws := map[string]whitespace{"preserve": wsPreserve, "replace": wsReplace, "collapse": wsCollapse}
+		w, ok := ws[v]
+		if !ok {
+			return true, fmt.Errorf("%q is not a valid whiteSpace value", v)
+		}
+		f.ws = &w
+	default:
+		return false, nil
+	}
+	return true, err
+}
+
+func (st *simpleType) typeName() xml.Name {
+	return st.name
+}
+
+func (st *simpleType) whitespace() whitespace {
+	for t := st; t != nil; t = t.base {
+		if t.facets.ws != nil {
+			return *t.facets.ws
+		}
+		if t.prim != nil && t.base == nil {
+			return t.prim.ws
+		}
+	}
+	return wsCollapse
+}
+
+func normalizeSpace(v string, ws whitespace) string {
+	switch ws {
+	case wsReplace:
+		return strings.Map(func(r rune) rune {
+			if r == '\t' || r == '\n' || r == '\r' {
+				return ' '
+			}
+			return r
+		}, v)
+	case wsCollapse:
+		return strings.Join(strings.Fields(v), " ")
+	}
+	return v
+}
+
+// validate checks that v is a valid lexical representation of st.
+func (st *simpleType) validate(v string) error {
+	if st.variety == atomic {
+		v = normalizeSpace(v, st.whitespace())
+	} else {
+		v = normalizeSpace(v, wsCollapse)
+	}
+	switch {
+	case st.base != nil:
+		if err := st.base.validate(v); err != nil {
+			return err
+		}
+	case st.variety == list:
+		for _, item := range strings.Fields(v) {
+			if err := st.item.validate(item); err != nil {
+				return err
+			}
+		}
+	case st.variety == union:
+		matched := false
+		for _, m := range st.members {
+			if m.validate(v) == nil {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%q is not valid for any member of the union", v)
+		}
+	case st.prim != nil && st.prim.check != nil:
+		if err := st.prim.check(v); err != nil {
+			return err
+		}
+	}
+	return st.checkFacets(v)
+}
+
+func (st *simpleType) lengthOf(v string) int {
+	if st.variety == list {
+		return len(strings.Fields(v))
+	}
+	if st.prim != nil && st.prim.binary {
+		if st.prim.name == "hexBinary" {
+			return len(v) / 2
+		}
+		b, _ := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(v), ""))
+		return len(b)
+	}
+	return utf8.RuneCountInString(v)
+}
+
+func (st *simpleType) checkFacets(v string) error {
+	f := &st.facets
+	if f.length != nil || f.minLength != nil || f.maxLength != nil {
+		l := st.lengthOf(v)
+		if f.length != nil && l != *f.length {
+			return fmt.Errorf("%q must have length %d", v, *f.length)
+		}
+		if f.minLength != nil && l < *f.minLength {
+			return fmt.Errorf("%q is shorter than the minimum length %d", v, *f.minLength)
+		}
+		if f.maxLength != nil && l > *f.maxLength {
+			return fmt.Errorf("%q is longer than the maximum length %d", v, *f.maxLength)
+		}
+	}
+	if len(f.patterns) > 0 {
+		matched := false
+		for _, re := range f.patterns {
+			if re.MatchString(v) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%q does not match pattern %s", v, strings.Join(f.patternSrc, " | "))
+		}
+	}
+	if len(f.enums) > 0 {
+		found := false
+		for _, e := range f.enums {
+			if st.equal(v, e) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%q is not one of the allowed values %s", v, strings.Join(f.enums, ", "))
+		}
+	}
+	bounds := []struct {
+		bound *string
+		ok    func(int) bool
+		desc  string
+	}{
+		{f.minIncl, func(c int) bool { return c >= 0 }, "less than"},
+		{f.maxIncl, func(c int) bool { return c <= 0 }, "greater than"},
+		{f.minExcl, func(c int) bool { return c > 0 }, "less than or equal to"},
+		{f.maxExcl, func(c int) bool { return c < 0 }, "greater than or equal to"},
+	}
+	for _, b := range bounds {
+		if b.bound == nil {
+			continue
+		}
+		c, err := st.compare(v, *b.bound)
+		if err != nil {
+			return err
+		}
+		if !b.ok(c) {
+			return fmt.Errorf("%q is %s %s", v, b.desc, *b.bound)
+		}
+	}
+	if f.totalDigits != nil || f.fractionDigits != nil {
+		whole, frac := digits(v)
+		if f.totalDigits != nil && whole+frac > *f.totalDigits {
+			return fmt.Errorf("%q has more than %d digits", v, *f.totalDigits)
+		}
+		if f.fractionDigits != nil && frac > *f.fractionDigits {
+			return fmt.Errorf("%q has more than %d fraction digits", v, *f.fractionDigits)
+		}
+	}
+	return nil
+}
+
+// digits counts the significant integer and fraction digits of a decimal.
+func digits(v string) (int, int) {
+	v = strings.TrimLeft(v, "+-")
+	whole, frac := v, ""
+	if i := strings.IndexByte(v, '.'); i >= 0 {
+		whole, frac = v[:i], v[i+1:]
+	}
+	whole = strings.TrimLeft(whole, "0")
+	frac = strings.TrimRight(frac, "0")
+	return len(whole), len(frac)
+}
+
+// equal compares two values in the value space of st where that is
+// cheap to do, and lexically otherwise.
+func (st *simpleType) equal(a, b string) bool {
+	if st.variety == atomic && st.prim != nil && st.prim.order != unordered {
+		if c, err := st.compare(a, b); err == nil {
+			return c == 0
+		}
+	}
+	ws := wsCollapse
+	if st.variety == atomic {
+		ws = st.whitespace()
+	}
+	return normalizeSpace(a, ws) == normalizeSpace(b, ws)
+}
+
+func (st *simpleType) compare(a, b string) (int, error) {
+	if st.variety != atomic || st.prim == nil {
+		return 0, errors.New("values of list and union types are not ordered")
+	}
+	switch st.prim.order {
+	case decimalOrder:
+		x, ok1 := new(big.Rat).SetString(a)
+		y, ok2 := new(big.Rat).SetString(b)
+		if !ok1 || !ok2 {
+			return 0, fmt.Errorf("cannot compare %q and %q as decimals", a, b)
+		}
+		return x.Cmp(y), nil
+	case floatOrder:
+		x, err1 := parseFloat(a)
+		y, err2 := parseFloat(b)
+		if err1 != nil || err2 != nil {
+			return 0, fmt.Errorf("cannot compare %q and %q as numbers", a, b)
+		}
+		switch {
+		case x < y:
+			return -1, nil
+		case x > y:
+			return 1, nil
+		}
+		return 0, nil
+	case timeOrder:
+		x, err1 := parseTime(st.prim.timeLayouts, a)
+		y, err2 := parseTime(st.prim.timeLayouts, b)
+		if err1 != nil || err2 != nil {
+			return 0, fmt.Errorf("cannot compare %q and %q as %s values", a, b, st.prim.name)
+		}
+		switch {
+		case x.Before(y):
+			return -1, nil
+		case x.After(y):
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, fmt.Errorf("values of type %s are not ordered", st.prim.name)
+}
+
+func parseFloat(v string) (float64, error) {
+	switch v {
+	case "INF":
+		return math.Inf(1), nil
+	case "-INF":
+		return math.Inf(-1), nil
+	case "NaN":
+		return math.NaN(), nil
+	}
+	if strings.ContainsAny(v, "iInN") {
+		return 0, fmt.Errorf("%q is not a valid number", v)
+	}
+	return strconv.ParseFloat(v, 64)
+}
+
+func parseTime(layouts []string, v string) (time.Time, error) {
+	for _, l := range layouts {
+		if t, err := time.Parse(l, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a valid time", v)
+}
+
+func reCheck(re *regexp.Regexp, what string) func(string) error {
+	return func(v string) error {
+		if !re.MatchString(v) {
+			return fmt.Errorf("%q is not a valid %s", v, what)
+		}
+		return nil
+	}
+}
+
+var (
+	decimalRE  = regexp.MustCompile(`^[+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)$`)
+	integerRE  = regexp.MustCompile(`^[+-]?[0-9]+$`)
+	ncNameRE   = regexp.MustCompile(`^[\p{L}_][\p{L}\p{N}\p{Mn}\p{Mc}._\-]*$`)
+	nameRE     = regexp.MustCompile(`^[\p{L}_:][\p{L}\p{N}\p{Mn}\p{Mc}._:\-]*$`)
+	qNameRE    = regexp.MustCompile(`^([\p{L}_][\p{L}\p{N}\p{Mn}\p{Mc}._\-]*:)?[\p{L}_][\p{L}\p{N}\p{Mn}\p{Mc}._\-]*$`)
+	nmtokenRE  = regexp.MustCompile(`^[\p{L}\p{N}\p{Mn}\p{Mc}._:\-]+$`)
+	languageRE = regexp.MustCompile(`^[a-zA-Z]{1,8}(-[a-zA-Z0-9]{1,8})*$`)
+	durationRE = regexp.MustCompile(`^-?P(([0-9]+Y)?([0-9]+M)?([0-9]+D)?(T([0-9]+H)?([0-9]+M)?([0-9]+(\.[0-9]+)?S)?)?)$`)
+	tz         = `(Z|[+-][0-9]{2}:[0-9]{2})?`
+	dateTimeRE = regexp.MustCompile(`^-?[0-9]{4,}-[0-9]{2}-[0-9]{2}T[0-9]{2}:[0-9]{2}:[0-9]{2}(\.[0-9]+)?` + tz + `$`)
+	dateRE     = regexp.MustCompile(`^-?[0-9]{4,}-[0-9]{2}-[0-9]{2}` + tz + `$`)
+	timeRE     = regexp.MustCompile(`^[0-9]{2}:[0-9]{2}:[0-9]{2}(\.[0-9]+)?` + tz + `$`)
+	gYearRE    = regexp.MustCompile(`^-?[0-9]{4,}` + tz + `$`)
+	gYMRE      = regexp.MustCompile(`^-?[0-9]{4,}-[0-9]{2}` + tz + `$`)
+	gMonthRE   = regexp.MustCompile(`^--[0-9]{2}` + tz + `$`)
+	gMonthDRE  = regexp.MustCompile(`^--[0-9]{2}-[0-9]{2}` + tz + `$`)
+	gDayRE     = regexp.MustCompile(`^---[0-9]{2}` + tz + `$`)
+)
+
+var (
+	dateTimeLayouts = []string{"2006-01-02T15:04:05.999999999Z07:00", "2006-01-02T15:04:05.999999999"}
+	dateLayouts     = []string{"2006-01-02Z07:00", "2006-01-02"}
+	timeLayouts     = []string{"15:04:05.999999999Z07:00", "15:04:05.999999999"}
+)
+
+// integerCheck returns a check for integers in the range [min, max].  Nil
+// bounds are unbounded.
+func integerCheck(what string, min, max *big.Int) func(string) error {
+	return func(v string) error {
+		if !integerRE.MatchString(v) {
+			return fmt.Errorf("%q is not a valid %s", v, what)
+		}
+		i, _ := new(big.Int).SetString(strings.TrimPrefix(v, "+"), 10)
+		if (min != nil && i.Cmp(min) < 0) || (max != nil && i.Cmp(max) > 0) {
+			return fmt.Errorf("%q is out of range for %s", v, what)
+		}
+		return nil
+	}
+}
+
+func bigInt(s string) *big.Int {
+	i, _ := new(big.Int).SetString(s, 10)
+	return i
+}
+
+func listCheck(re *regexp.Regexp, what string) func(string) error {
+	return func(v string) error {
+		items := strings.Fields(v)
+		if len(items) == 0 {
+			return fmt.Errorf("%s must have at least one item", what)
+		}
+		for _, item := range items {
+			if !re.MatchString(item) {
+				return fmt.Errorf("%q is not a valid %s item", item, what)
+			}
+		}
+		return nil
+	}
+}
+
+var builtins = map[string]*builtin{}
+
+func addBuiltin(b *builtin) {
+	builtins[b.name] = b
+	builtinTypes[b.name] = &simpleType{
+		name: xml.Name{Space: dom.NS_XS, Local: b.name},
+		prim: b,
+	}
+}
+
+func init() {
+	for _, name := range []string{"anySimpleType", "string", "anyURI"} {
+		addBuiltin(&builtin{name: name, ws: wsPreserve})
+	}
+	builtins["anyURI"].ws = wsCollapse
+	addBuiltin(&builtin{name: "normalizedString", ws: wsReplace})
+	addBuiltin(&builtin{name: "token", ws: wsCollapse})
+	addBuiltin(&builtin{name: "language", ws: wsCollapse, check: reCheck(languageRE, "language")})
+	addBuiltin(&builtin{name: "Name", ws: wsCollapse, check: reCheck(nameRE, "Name")})
+	for _, name := range []string{"NCName", "ID", "IDREF", "ENTITY"} {
+		addBuiltin(&builtin{name: name, ws: wsCollapse, check: reCheck(ncNameRE, name)})
+	}
+	addBuiltin(&builtin{name: "NMTOKEN", ws: wsCollapse, check: reCheck(nmtokenRE, "NMTOKEN")})
+	addBuiltin(&builtin{name: "NMTOKENS", ws: wsCollapse, check: listCheck(nmtokenRE, "NMTOKENS")})
+	addBuiltin(&builtin{name: "IDREFS", ws: wsCollapse, check: listCheck(ncNameRE, "IDREFS")})
+	addBuiltin(&builtin{name: "ENTITIES", ws: wsCollapse, check: listCheck(ncNameRE, "ENTITIES")})
+	addBuiltin(&builtin{name: "QName", ws: wsCollapse, check: reCheck(qNameRE, "QName")})
+	addBuiltin(&builtin{name: "NOTATION", ws: wsCollapse, check: reCheck(qNameRE, "NOTATION")})
+	addBuiltin(&builtin{name: "boolean", ws: wsCollapse, check: func(v string) error {
+		switch v {
+		case "true", "false", "1", "0":
+			return nil
+		}
+		return fmt.Errorf("%q is not a valid boolean", v)
+	}})
+	addBuiltin(&builtin{name: "decimal", ws: wsCollapse, order: decimalOrder, check: reCheck(decimalRE, "decimal")})
+	integers := []struct {
+		name     string
+		min, max string
+	}{
+		{"integer", "", ""},
+		{"nonPositiveInteger", "", "0"},
+		{"negativeInteger", "", "-1"},
+		{"nonNegativeInteger", "0", ""},
+		{"positiveInteger", "1", ""},
+		{"long", "-9223372036854775808", "9223372036854775807"},
+		{"int", "-2147483648", "2147483647"},
+		{"short", "-32768", "32767"},
+		{"byte", "-128", "127"},
+		{"unsignedLong", "0", "18446744073709551615"},
+		{"unsignedInt", "0", "4294967295"},
+		{"unsignedShort", "0", "65535"},
+		{"unsignedByte", "0", "255"},
+	}
+	for _, i := range integers {
+		var min, max *big.Int
+		if i.min != "" {
+			min = bigInt(i.min)
+		}
+		if i.max != "" {
+			max = bigInt(i.max)
+		}
+		addBuiltin(&builtin{name: i.name, ws: wsCollapse, order: decimalOrder, check: integerCheck(i.name, min, max)})
+	}
+	for _, name := range []string{"float", "double"} {
+		what := name
+		addBuiltin(&builtin{name: name, ws: wsCollapse, order: floatOrder, check: func(v string) error {
+			if _, err := parseFloat(v); err != nil {
+				return fmt.Errorf("%q is not a valid %s", v, what)
+			}
+			return nil
+		}})
+	}
+	addBuiltin(&builtin{name: "dateTime", ws: wsCollapse, order: timeOrder, timeLayouts: dateTimeLayouts, check: reCheck(dateTimeRE, "dateTime")})
+	addBuiltin(&builtin{name: "date", ws: wsCollapse, order: timeOrder, timeLayouts: dateLayouts, check: reCheck(dateRE, "date")})
+	addBuiltin(&builtin{name: "time", ws: wsCollapse, order: timeOrder, timeLayouts: timeLayouts, check: reCheck(timeRE, "time")})
+	addBuiltin(&builtin{name: "duration", ws: wsCollapse, check: func(v string) error {
+		if !durationRE.MatchString(v) || strings.HasSuffix(v, "P") || strings.HasSuffix(v, "T") {
+			return fmt.Errorf("%q is not a valid duration", v)
+		}
+		return nil
+	}})
+	addBuiltin(&builtin{name: "gYear", ws: wsCollapse, check: reCheck(gYearRE, "gYear")})
+	addBuiltin(&builtin{name: "gYearMonth", ws: wsCollapse, check: reCheck(gYMRE, "gYearMonth")})
+	addBuiltin(&builtin{name: "gMonth", ws: wsCollapse, check: reCheck(gMonthRE, "gMonth")})
+	addBuiltin(&builtin{name: "gMonthDay", ws: wsCollapse, check: reCheck(gMonthDRE, "gMonthDay")})
+	addBuiltin(&builtin{name: "gDay", ws: wsCollapse, check: reCheck(gDayRE, "gDay")})
+	addBuiltin(&builtin{name: "hexBinary", ws: wsCollapse, binary: true, check: func(v string) error {
+		if _, err := hex.DecodeString(v); err != nil {
+			return fmt.Errorf("%q is not valid hexBinary", v)
+		}
+		return nil
+	}})
+	addBuiltin(&builtin{name: "base64Binary", ws: wsCollapse, binary: true, check: func(v string) error {
+		if _, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(v), "")); err != nil {
+			return fmt.Errorf("%q is not valid base64Binary", v)
+		}
+		return nil
+	}})
+}
+
+// translatePattern converts an XSD regular expression into the RE2 syntax
+// used by the regexp package.  XSD patterns are implicitly anchored, treat
+// ^ and $ as ordinary characters, and have a few extra escapes.
+func translatePattern(p string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString(`^(?:`)
+	inClass := false
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch {
+		case c == '\\' && i+1 < len(p):
+			i++
+			e := p[i]
+			switch e {
+			case 'i':
+				if inClass {
+					b.WriteString(`\p{L}_:`)
+				} else {
+					b.WriteString(`[\p{L}_:]`)
+				}
+			case 'I':
+				b.WriteString(`[^\p{L}_:]`)
+			case 'c':
+				if inClass {
+					b.WriteString(`\p{L}\p{N}\p{Mn}\p{Mc}._:\-`)
+				} else {
+					b.WriteString(`[\p{L}\p{N}\p{Mn}\p{Mc}._:\-]`)
+				}
+			case 'C':
+				b.WriteString(`[^\p{L}\p{N}\p{Mn}\p{Mc}._:\-]`)
+			case 'd':
+				b.WriteString(`\p{Nd}`)
+			case 'D':
+				b.WriteString(`\P{Nd}`)
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(e)
+			}
+		case c == '[':
+			if inClass {
+				return nil, fmt.Errorf("character class subtraction in pattern %q is not supported", p)
+			}
+			inClass = true
+			b.WriteByte(c)
+			if i+1 < len(p) && p[i+1] == '^' {
+				b.WriteByte('^')
+				i++
+			}
+		case c == ']':
+			inClass = false
+			b.WriteByte(c)
+		case (c == '^' || c == '$') && !inClass:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteString(`)$`)
+	return regexp.Compile(b.String())
+}
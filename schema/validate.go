@@ -0,0 +1,400 @@
+package schema
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+type validator struct {
+	s          *Schema
+	violations []Violation
+	// shallow leaves the children of elements out, for a
+	// StreamValidator, which sees each of them on its own.
+	shallow bool
+}
+
+func (v *validator) report(e *dom.Element, format string, args ...interface{}) {
+	v.violations = append(v.violations, Violation{
+		Element: e,
+		Pos:     e.Pos(),
+		Path:    Path(e),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func isNamespaceDecl(a xml.Attr) bool {
+	return a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns")
+}
+
+func xsiAttr(e *dom.Element, local string) (string, bool) {
+	for _, a := range e.Attributes {
+		if a.Name.Space == dom.NS_XSI && a.Name.Local == local {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// lookupType finds a type by name for xsi:type.
+func (v *validator) lookupType(name xml.Name) typeDef {
+	if name.Space == dom.NS_XS {
+		if name.Local == "anyType" {
+			return anyType
+		}
+		if st, ok := builtinTypes[name.Local]; ok {
+			return st
+		}
+		return nil
+	}
+	return v.s.types[name]
+}
+
+func (v *validator) element(e *dom.Element, decl *elementDecl) {
+	typ := decl.typ
+	if tn, ok := xsiAttr(e, "type"); ok {
+		name, err := resolveQName(e, strings.TrimSpace(tn))
+		if err != nil {
+			v.report(e, "bad xsi:type %q", tn)
+			return
+		}
+		if typ = v.lookupType(name); typ == nil {
+			v.report(e, "xsi:type %s is not defined", fmtName(name))
+			return
+		}
+	}
+	if nilv, ok := xsiAttr(e, "nil"); ok && (strings.TrimSpace(nilv) == "true" || strings.TrimSpace(nilv) == "1") {
+		if !decl.nillable {
+			v.report(e, "element is not nillable")
+		}
+		if len(e.Children()) > 0 || len(e.Content) > 0 {
+			v.report(e, "nil element must be empty")
+		}
+		if ct, ok := typ.(*complexType); ok {
+			v.attributes(e, ct)
+		}
+		return
+	}
+	switch t := typ.(type) {
+	case *simpleType:
+		for _, a := range e.Attributes {
+			if !isNamespaceDecl(a) && a.Name.Space != dom.NS_XSI {
+				v.report(e, "attribute %s is not allowed", fmtName(a.Name))
+			}
+		}
+		if len(e.Children()) > 0 {
+			v.report(e, "element of simple type %s may not have child elements", fmtName(t.name))
+			return
+		}
+		v.value(e, decl, t)
+	case *complexType:
+		if t.abstract {
+			v.report(e, "type %s is abstract", fmtName(t.name))
+		}
+		v.attributes(e, t)
+		if t.simple != nil {
+			if len(e.Children()) > 0 {
+				v.report(e, "element of type %s may not have child elements", fmtName(t.name))
+				return
+			}
+			v.value(e, decl, t.simple)
+			return
+		}
+		if len(e.Content) > 0 && !t.mixed {
+			v.report(e, "text content is not allowed")
+		}
+		v.content(e, t.content)
+	}
+}
+
+// value validates the text content of e.
+func (v *validator) value(e *dom.Element, decl *elementDecl, st *simpleType) {
+	text := string(e.Content)
+	if text == "" && decl.def != nil {
+		text = *decl.def
+	}
+	if decl.fixed != nil && !st.equal(text, *decl.fixed) {
+		v.report(e, "value must be %q", *decl.fixed)
+		return
+	}
+	if err := st.validate(text); err != nil {
+		v.report(e, "invalid value: %v", err)
+	}
+}
+
+func (v *validator) attributes(e *dom.Element, ct *complexType) {
+	seen := map[xml.Name]bool{}
+	for _, a := range e.Attributes {
+		if isNamespaceDecl(a) || a.Name.Space == dom.NS_XSI {
+			continue
+		}
+		seen[a.Name] = true
+		use := ct.attr(a.Name)
+		switch {
+		case use != nil && use.prohibited:
+			v.report(e, "attribute %s is not allowed", fmtName(a.Name))
+		case use != nil:
+			v.attrValue(e, a, use)
+		case ct.anyAttr != nil && ct.anyAttr.allows(a.Name.Space):
+			if ct.anyAttr.process == "skip" {
+				continue
+			}
+			if global, ok := v.s.attrs[a.Name]; ok {
+				v.attrValue(e, a, global)
+			} else if ct.anyAttr.process == "strict" && a.Name.Space != dom.NS_XML {
+				v.report(e, "no declaration for attribute %s", fmtName(a.Name))
+			}
+		case a.Name.Space == dom.NS_XML:
+			// xml:lang and friends would need xml.xsd to be imported,
+			// which is not supported, so let them through.
+		default:
+			v.report(e, "attribute %s is not allowed", fmtName(a.Name))
+		}
+	}
+	for _, use := range ct.attrs {
+		if use.required && !seen[use.name] {
+			v.report(e, "missing required attribute %s", fmtName(use.name))
+		}
+	}
+}
+
+func (v *validator) attrValue(e *dom.Element, a xml.Attr, use *attrUse) {
+	if use.fixed != nil && !use.typ.equal(a.Value, *use.fixed) {
+		v.report(e, "attribute %s must be %q", fmtName(a.Name), *use.fixed)
+		return
+	}
+	if err := use.typ.validate(a.Value); err != nil {
+		v.report(e, "invalid value for attribute %s: %v", fmtName(a.Name), err)
+	}
+}
+
+// binding records which declaration or wildcard matched a child element.
+// Bindings form a linked list running backwards through the children.
+type binding struct {
+	prev *binding
+	decl *elementDecl
+	any  *wildcard
+}
+
+type state struct {
+	pos int
+	b   *binding
+}
+
+// matcher matches a list of children against a content model.  It works
+// on sets of states, each one a position in the children reached by a
+// different way of matching the model so far.
+type matcher struct {
+	children []*dom.Element
+	furthest int
+	expected map[string]bool
+}
+
+func (m *matcher) expect(pos int, what string) {
+	if pos > m.furthest {
+		m.furthest = pos
+		m.expected = map[string]bool{}
+	}
+	if pos == m.furthest {
+		m.expected[what] = true
+	}
+}
+
+func (m *matcher) reached(pos int) {
+	if pos > m.furthest {
+		m.furthest = pos
+		m.expected = map[string]bool{}
+	}
+}
+
+// dedupe keeps the first state for each position.
+func dedupe(states []state) []state {
+	seen := map[int]bool{}
+	res := []state{}
+	for _, s := range states {
+		if !seen[s.pos] {
+			seen[s.pos] = true
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+func (m *matcher) match(p *particle, states []state) []state {
+	res := []state{}
+	if p.min == 0 {
+		res = append(res, states...)
+	}
+	cur := states
+	seen := map[int]bool{}
+	for _, s := range states {
+		seen[s.pos] = true
+	}
+	for count := 1; len(cur) > 0 && (p.max < 0 || count <= p.max); count++ {
+		next := dedupe(m.once(p, cur))
+		if count >= p.min {
+			res = append(res, next...)
+			// Once the minimum has been reached, only states at new
+			// positions can lead anywhere that has not been seen.
+			fresh := []state{}
+			for _, s := range next {
+				if !seen[s.pos] {
+					seen[s.pos] = true
+					fresh = append(fresh, s)
+				}
+			}
+			next = fresh
+		}
+		cur = next
+		if count > len(m.children)+p.min {
+			break
+		}
+	}
+	return dedupe(res)
+}
+
+func (m *matcher) once(p *particle, states []state) []state {
+	res := []state{}
+	switch p.kind {
+	case elementParticle:
+		for _, s := range states {
+			m.expect(s.pos, fmtName(p.elem.name))
+			if s.pos < len(m.children) && m.children[s.pos].Name == p.elem.name {
+				res = append(res, state{s.pos + 1, &binding{prev: s.b, decl: p.elem}})
+				m.reached(s.pos + 1)
+			}
+		}
+	case anyParticle:
+		for _, s := range states {
+			m.expect(s.pos, "any element")
+			if s.pos < len(m.children) && p.any.allows(m.children[s.pos].Name.Space) {
+				res = append(res, state{s.pos + 1, &binding{prev: s.b, any: p.any}})
+				m.reached(s.pos + 1)
+			}
+		}
+	case sequenceParticle:
+		res = states
+		for _, item := range p.items {
+			res = m.match(item, res)
+		}
+	case choiceParticle:
+		for _, item := range p.items {
+			res = append(res, m.match(item, states)...)
+		}
+	case allParticle:
+		for _, s := range states {
+			res = append(res, m.all(p, s, 0)...)
+		}
+	}
+	return res
+}
+
+// all matches the children of an all group from s, in any order, given
+// that the items in used have already been matched.
+func (m *matcher) all(p *particle, s state, used uint64) []state {
+	res := []state{}
+	complete := true
+	for i, item := range p.items {
+		if used&(1<<uint(i)) != 0 {
+			continue
+		}
+		if item.min > 0 {
+			complete = false
+		}
+		for _, next := range m.match(&particle{kind: item.kind, min: 1, max: 1, elem: item.elem, any: item.any, items: item.items}, []state{s}) {
+			res = append(res, m.all(p, next, used|1<<uint(i))...)
+		}
+	}
+	if complete {
+		res = append(res, s)
+	}
+	return res
+}
+
+func (m *matcher) expectedList() string {
+	names := []string{}
+	for n := range m.expected {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// content validates the children of e against the content model p.
+func (v *validator) content(e *dom.Element, p *particle) {
+	children := e.Children()
+	m := &matcher{children: children, expected: map[string]bool{}}
+	var end *state
+	best := state{}
+	if p == nil {
+		if len(children) == 0 {
+			end = &best
+		}
+	} else {
+		for _, s := range m.match(p, []state{{}}) {
+			if s.pos == len(children) {
+				s := s
+				end = &s
+				break
+			}
+			if s.pos > best.pos {
+				best = s
+			}
+		}
+	}
+	bound := end
+	if bound == nil {
+		bound = &best
+		switch {
+		case m.furthest < len(children) && len(m.expected) > 0:
+			v.report(children[m.furthest], "unexpected element %s, expected %s",
+				fmtName(children[m.furthest].Name), m.expectedList())
+		case m.furthest < len(children):
+			v.report(children[m.furthest], "unexpected element %s", fmtName(children[m.furthest].Name))
+		default:
+			v.report(e, "missing element, expected %s", m.expectedList())
+		}
+	}
+	if v.shallow {
+		return
+	}
+	bindings := make([]*binding, bound.pos)
+	for b, i := bound.b, bound.pos-1; b != nil; b, i = b.prev, i-1 {
+		bindings[i] = b
+	}
+	for i, child := range children {
+		if i >= len(bindings) {
+			v.lax(child)
+			continue
+		}
+		b := bindings[i]
+		switch {
+		case b.decl != nil:
+			v.element(child, b.decl)
+		case b.any.process == "skip":
+		default:
+			if decl, ok := v.s.elements[child.Name]; ok {
+				v.element(child, decl)
+			} else if b.any.process == "strict" {
+				v.report(child, "no declaration for element %s", fmtName(child.Name))
+			} else {
+				v.lax(child)
+			}
+		}
+	}
+}
+
+// lax validates the parts of the subtree under e that have global
+// declarations.
+func (v *validator) lax(e *dom.Element) {
+	for _, child := range e.Children() {
+		if decl, ok := v.s.elements[child.Name]; ok {
+			v.element(child, decl)
+		} else {
+			v.lax(child)
+		}
+	}
+}
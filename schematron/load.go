@@ -0,0 +1,277 @@
+package schematron
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/xpath"
+)
+
+type loader struct {
+	ns       string
+	s        *Schema
+	abstract map[string]*rule
+	// extends holds the rules that extend abstract ones, which are
+	// filled in once all the abstract rules are known.
+	extends []extension
+}
+
+type extension struct {
+	r    *rule
+	from string
+	at   *dom.Element
+}
+
+func attr(e *dom.Element, name string) (string, bool) {
+	for _, a := range e.Attributes {
+		if a.Name.Space == "" && a.Name.Local == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+func errorAt(e *dom.Element, format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	if p := e.Pos(); p.IsValid() {
+		return fmt.Errorf("schematron: %v: %s", p, msg)
+	}
+	return fmt.Errorf("schematron: %s", msg)
+}
+
+func (l *loader) is(e *dom.Element, name string) bool {
+	return e.Name.Space == l.ns && e.Name.Local == name
+}
+
+func (l *loader) required(e *dom.Element, name string) (string, error) {
+	v, ok := attr(e, name)
+	if !ok || strings.TrimSpace(v) == "" {
+		return "", errorAt(e, "%s is missing its %s attribute", e.Name.Local, name)
+	}
+	return v, nil
+}
+
+func (l *loader) expr(e *dom.Element, name string) (*xpath.Expr, error) {
+	src, err := l.required(e, name)
+	if err != nil {
+		return nil, err
+	}
+	x, err := xpath.Compile(src)
+	if err != nil {
+		return nil, errorAt(e, "%s %s: %v", e.Name.Local, name, err)
+	}
+	return x, nil
+}
+
+func (l *loader) let(e *dom.Element) (let, error) {
+	name, err := l.required(e, "name")
+	if err != nil {
+		return let{}, err
+	}
+	value, err := l.expr(e, "value")
+	return let{name: name, value: value}, err
+}
+
+func (l *loader) schema(root *dom.Element) error {
+	defaultPhase, _ := attr(root, "defaultPhase")
+	l.s.defaultPhase = defaultPhase
+	for _, c := range root.Children() {
+		if c.Name.Space != l.ns {
+			continue
+		}
+		switch c.Name.Local {
+		case "title":
+			l.s.Title = strings.TrimSpace(string(c.Content))
+		case "ns":
+			prefix, err := l.required(c, "prefix")
+			if err != nil {
+				return err
+			}
+			uri, err := l.required(c, "uri")
+			if err != nil {
+				return err
+			}
+			l.s.env.Namespace(prefix, uri)
+		case "let":
+			lt, err := l.let(c)
+			if err != nil {
+				return err
+			}
+			l.s.lets = append(l.s.lets, lt)
+		case "phase":
+			id, err := l.required(c, "id")
+			if err != nil {
+				return err
+			}
+			active := []string{}
+			for _, a := range c.Children() {
+				if l.is(a, "active") {
+					p, err := l.required(a, "pattern")
+					if err != nil {
+						return err
+					}
+					active = append(active, p)
+				}
+			}
+			l.s.phases[id] = active
+		case "pattern":
+			p, err := l.pattern(c)
+			if err != nil {
+				return err
+			}
+			l.s.patterns = append(l.s.patterns, p)
+		case "include", "extends", "diagnostics":
+			return errorAt(c, "%s is not supported", c.Name.Local)
+		}
+	}
+	for _, x := range l.extends {
+		a, ok := l.abstract[x.from]
+		if !ok {
+			return errorAt(x.at, "no abstract rule %s", x.from)
+		}
+		x.r.lets = append(x.r.lets, a.lets...)
+		x.r.checks = append(x.r.checks, a.checks...)
+	}
+	if l.s.defaultPhase != "" && l.s.defaultPhase != "#ALL" {
+		if _, ok := l.s.phases[l.s.defaultPhase]; !ok {
+			return errorAt(root, "default phase %s is not declared", l.s.defaultPhase)
+		}
+	}
+	for id, active := range l.s.phases {
+		for _, name := range active {
+			found := false
+			for _, p := range l.s.patterns {
+				found = found || p.id == name
+			}
+			if !found {
+				return fmt.Errorf("schematron: phase %s refers to unknown pattern %s", id, name)
+			}
+		}
+	}
+	return nil
+}
+
+func (l *loader) pattern(e *dom.Element) (*pattern, error) {
+	if v, _ := attr(e, "abstract"); v == "true" {
+		return nil, errorAt(e, "abstract patterns are not supported")
+	}
+	if _, ok := attr(e, "is-a"); ok {
+		return nil, errorAt(e, "abstract patterns are not supported")
+	}
+	p := &pattern{}
+	p.id, _ = attr(e, "id")
+	for _, c := range e.Children() {
+		if c.Name.Space != l.ns {
+			continue
+		}
+		switch c.Name.Local {
+		case "let":
+			lt, err := l.let(c)
+			if err != nil {
+				return nil, err
+			}
+			p.lets = append(p.lets, lt)
+		case "rule":
+			r, err := l.rule(c)
+			if err != nil {
+				return nil, err
+			}
+			if !r.abstract {
+				p.rules = append(p.rules, r)
+			}
+		}
+	}
+	return p, nil
+}
+
+func (l *loader) rule(e *dom.Element) (*rule, error) {
+	r := &rule{}
+	r.id, _ = attr(e, "id")
+	if v, _ := attr(e, "abstract"); v == "true" {
+		if r.id == "" {
+			return nil, errorAt(e, "abstract rule has no id")
+		}
+		r.abstract = true
+		l.abstract[r.id] = r
+	} else {
+		x, err := l.expr(e, "context")
+		if err != nil {
+			return nil, err
+		}
+		r.context = x
+	}
+	for _, c := range e.Children() {
+		if c.Name.Space != l.ns {
+			continue
+		}
+		switch c.Name.Local {
+		case "let":
+			lt, err := l.let(c)
+			if err != nil {
+				return nil, err
+			}
+			r.lets = append(r.lets, lt)
+		case "assert", "report":
+			ch, err := l.check(c)
+			if err != nil {
+				return nil, err
+			}
+			r.checks = append(r.checks, ch)
+		case "extends":
+			from, err := l.required(c, "rule")
+			if err != nil {
+				return nil, err
+			}
+			l.extends = append(l.extends, extension{r: r, from: from, at: c})
+		}
+	}
+	return r, nil
+}
+
+func (l *loader) check(e *dom.Element) (*check, error) {
+	test, err := l.expr(e, "test")
+	if err != nil {
+		return nil, err
+	}
+	c := &check{report: e.Name.Local == "report", test: test}
+	c.id, _ = attr(e, "id")
+	c.role, _ = attr(e, "role")
+	c.flag, _ = attr(e, "flag")
+	if c.message, err = l.message(e); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (l *loader) message(e *dom.Element) ([]part, error) {
+	res := []part{}
+	for _, c := range e.Children() {
+		switch {
+		case l.is(c, "value-of"):
+			x, err := l.expr(c, "select")
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, part{expr: x})
+		case l.is(c, "name"):
+			path, ok := attr(c, "path")
+			if !ok {
+				path = "."
+			}
+			x, err := xpath.Compile("name(" + path + ")")
+			if err != nil {
+				return nil, errorAt(c, "name path: %v", err)
+			}
+			res = append(res, part{expr: x})
+		default:
+			// emph, dir, span and foreign elements just contribute their
+			// text.
+			res = append(res, part{text: xpath.FromElement(c).Value()})
+		}
+	}
+	if len(e.Content) > 0 {
+		res = append(res, part{text: string(e.Content)})
+	}
+	return res, nil
+}
@@ -0,0 +1,381 @@
+// Package schematron evaluates ISO Schematron schemas over simplexml/dom
+// trees, for business rules that structural schemas cannot express.
+//
+// Schemas are loaded from their XML form.  Tests, rule contexts and let
+// values are XPath 1.0 expressions, evaluated with the xpath package.
+// Supported are ns, let (at schema, pattern and rule level), phase and
+// active, pattern, rule (including abstract rules and extends), assert
+// and report, and value-of and name in assertion messages.  Abstract
+// patterns, includes and diagnostics are not supported.
+//
+// Since dom Elements keep only their last run of text, the text of an
+// assertion message comes after the values of any value-of and name
+// elements in it.
+//
+// The result of validation is a Report, which has the same structure as
+// an SVRL report.
+//
+// For some basic usage examples, see schematron_test.go
+package schematron
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/xpath"
+)
+
+// NS_SCHEMATRON is the ISO Schematron namespace.
+const NS_SCHEMATRON = "http://purl.oclc.org/dsdl/schematron"
+
+// NS_SCHEMATRON_15 is the namespace of Schematron 1.5, which is accepted
+// as well.
+const NS_SCHEMATRON_15 = "http://www.ascc.net/xml/schematron"
+
+type let struct {
+	name  string
+	value *xpath.Expr
+}
+
+// part is a piece of an assertion message: either some text, or an
+// expression whose string value goes in the message.
+type part struct {
+	text string
+	expr *xpath.Expr
+}
+
+type check struct {
+	report  bool
+	test    *xpath.Expr
+	id      string
+	role    string
+	flag    string
+	message []part
+}
+
+type rule struct {
+	id       string
+	context  *xpath.Expr
+	abstract bool
+	lets     []let
+	checks   []*check
+}
+
+type pattern struct {
+	id    string
+	lets  []let
+	rules []*rule
+}
+
+// Schema is a loaded Schematron schema.
+type Schema struct {
+	// Title is the title of the schema, if it has one.
+	Title string
+	env   *xpath.Env
+	lets  []let
+	// patterns holds all the patterns, including the ones not in any
+	// phase.
+	patterns     []*pattern
+	phases       map[string][]string
+	defaultPhase string
+}
+
+// Load loads the Schematron schema in doc.
+func Load(doc *dom.Document) (*Schema, error) {
+	root := doc.Root()
+	if root == nil || root.Name.Local != "schema" ||
+		(root.Name.Space != NS_SCHEMATRON && root.Name.Space != NS_SCHEMATRON_15) {
+		return nil, errors.New("schematron: document is not a Schematron schema")
+	}
+	l := &loader{
+		ns:       root.Name.Space,
+		s:        &Schema{env: xpath.NewEnv(), phases: map[string][]string{}},
+		abstract: map[string]*rule{},
+	}
+	if qb, ok := attr(root, "queryBinding"); ok && qb != "xpath" && qb != "xpath1" && qb != "xslt" {
+		return nil, fmt.Errorf("schematron: query binding %s is not supported", qb)
+	}
+	if err := l.schema(root); err != nil {
+		return nil, err
+	}
+	return l.s, nil
+}
+
+// Parse parses a Schematron schema from r and loads it.
+func Parse(r io.Reader) (*Schema, error) {
+	doc, err := dom.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	return Load(doc)
+}
+
+// Func makes fn callable from the tests in s, as with xpath.Env.Func.
+// The return value is s.
+func (s *Schema) Func(name string, fn xpath.Function) *Schema {
+	s.env.Func(name, fn)
+	return s
+}
+
+// Phases returns the ids of the phases s declares.
+func (s *Schema) Phases() []string {
+	res := []string{}
+	for id := range s.phases {
+		res = append(res, id)
+	}
+	return res
+}
+
+// Result is a failed assertion or a successful report.
+type Result struct {
+	// Pattern is the id of the pattern the rule is in.
+	Pattern string
+	// Context is the context of the rule the assertion is in.
+	Context string
+	// Test is the test that failed or succeeded.
+	Test string
+	// ID, Role and Flag come from the assert or report element.
+	ID, Role, Flag string
+	// Location is a path to the node that was tested.
+	Location string
+	// Node is the node that was tested, and Pos is where it is in the
+	// source document, if it was parsed.
+	Node xpath.Node
+	Pos  dom.Position
+	// Text is the message, with value-of and name elements filled in.
+	Text string
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("%v: %s: %s", r.Pos, r.Location, r.Text)
+}
+
+// FiredRule records that a rule matched a node.
+type FiredRule struct {
+	Pattern, Context, ID string
+	Location             string
+}
+
+// Report is the outcome of checking a document against a schema.
+type Report struct {
+	Title string
+	// Phase is the phase that was checked, or "#ALL".
+	Phase             string
+	ActivePatterns    []string
+	FiredRules        []FiredRule
+	FailedAsserts     []Result
+	SuccessfulReports []Result
+}
+
+// Valid reports whether no assertion failed.  Successful reports do not
+// make a document invalid.
+func (r *Report) Valid() bool {
+	return len(r.FailedAsserts) == 0
+}
+
+// Validate checks doc against the patterns in the default phase of s, or
+// all of them if there is no default phase.  The error is only set if an
+// expression could not be evaluated.
+func (s *Schema) Validate(doc *dom.Document) (*Report, error) {
+	return s.ValidatePhase(doc, s.defaultPhase)
+}
+
+// ValidatePhase checks doc against the patterns in the named phase.  The
+// phase can be "" or "#ALL" to use all the patterns.
+func (s *Schema) ValidatePhase(doc *dom.Document, phase string) (*Report, error) {
+	if doc.Root() == nil {
+		return nil, errors.New("schematron: document has no root element")
+	}
+	if phase == "" || phase == "#DEFAULT" {
+		phase = "#ALL"
+	}
+	active := s.patterns
+	if phase != "#ALL" {
+		ids, ok := s.phases[phase]
+		if !ok {
+			return nil, fmt.Errorf("schematron: phase %s is not declared", phase)
+		}
+		active = []*pattern{}
+		for _, id := range ids {
+			for _, p := range s.patterns {
+				if p.id == id {
+					active = append(active, p)
+				}
+			}
+		}
+	}
+	r := &Report{Title: s.Title, Phase: phase}
+	v := &validator{s: s, r: r, root: xpath.FromDocument(doc)}
+	all, err := xpath.MustCompile("/ | //node() | //@*").Nodes(v.root, nil)
+	if err != nil {
+		return nil, err
+	}
+	v.all = all
+	env := s.env.Clone()
+	if err := v.bind(env, s.lets, v.root); err != nil {
+		return nil, err
+	}
+	for _, p := range active {
+		r.ActivePatterns = append(r.ActivePatterns, p.id)
+		if err := v.pattern(p, env); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Location returns a path to n, like dom.Element.Path, with a final
+// attribute or text() step if n is not an element.
+func Location(n xpath.Node) string {
+	switch n.Type() {
+	case xpath.RootNode:
+		return "/"
+	case xpath.AttributeNode:
+		a := n.Attr()
+		return n.Element().Path() + "/@" + a.Name.Local
+	case xpath.TextNode:
+		return n.Element().Path() + "/text()"
+	}
+	return n.Element().Path()
+}
+
+func pos(n xpath.Node) dom.Position {
+	if e := n.Element(); e != nil {
+		return e.Pos()
+	}
+	return dom.Position{}
+}
+
+type validator struct {
+	s    *Schema
+	r    *Report
+	root xpath.Node
+	all  xpath.NodeSet
+}
+
+func (v *validator) bind(env *xpath.Env, lets []let, n xpath.Node) error {
+	for _, l := range lets {
+		val, err := l.value.Evaluate(n, env)
+		if err != nil {
+			return fmt.Errorf("schematron: let %s: %v", l.name, err)
+		}
+		env.Var(l.name, val)
+	}
+	return nil
+}
+
+// matches works out which nodes match the context of r.  A node matches
+// if evaluating the context from it or one of its ancestors selects it,
+// which is the same as evaluating it from every node.
+func (v *validator) matches(r *rule, env *xpath.Env) (map[xpath.Node]bool, error) {
+	res := map[xpath.Node]bool{}
+	from := v.all
+	if strings.HasPrefix(strings.TrimSpace(r.context.String()), "/") {
+		from = xpath.NodeSet{v.root}
+	}
+	for _, n := range from {
+		if n.Type() != xpath.RootNode && n.Type() != xpath.ElementNode {
+			continue
+		}
+		ns, err := r.context.Nodes(n, env)
+		if err != nil {
+			return nil, fmt.Errorf("schematron: rule context %s: %v", r.context, err)
+		}
+		for _, m := range ns {
+			res[m] = true
+		}
+	}
+	return res, nil
+}
+
+func (v *validator) pattern(p *pattern, env *xpath.Env) error {
+	penv := env
+	if len(p.lets) > 0 {
+		penv = env.Clone()
+		if err := v.bind(penv, p.lets, v.root); err != nil {
+			return err
+		}
+	}
+	matched := make([]map[xpath.Node]bool, len(p.rules))
+	for i, r := range p.rules {
+		m, err := v.matches(r, penv)
+		if err != nil {
+			return err
+		}
+		matched[i] = m
+	}
+	for _, n := range v.all {
+		for i, r := range p.rules {
+			if !matched[i][n] {
+				continue
+			}
+			// Only the first rule in a pattern that matches a node fires.
+			if err := v.fire(p, r, n, penv); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	return nil
+}
+
+func (v *validator) fire(p *pattern, r *rule, n xpath.Node, env *xpath.Env) error {
+	loc := Location(n)
+	v.r.FiredRules = append(v.r.FiredRules, FiredRule{Pattern: p.id, Context: r.context.String(), ID: r.id, Location: loc})
+	if len(r.lets) > 0 {
+		env = env.Clone()
+		if err := v.bind(env, r.lets, n); err != nil {
+			return err
+		}
+	}
+	for _, c := range r.checks {
+		res, err := c.test.Evaluate(n, env)
+		if err != nil {
+			return fmt.Errorf("schematron: test %s: %v", c.test, err)
+		}
+		if xpath.Boolean(res) != c.report {
+			continue
+		}
+		text, err := message(c.message, n, env)
+		if err != nil {
+			return err
+		}
+		out := Result{
+			Pattern:  p.id,
+			Context:  r.context.String(),
+			Test:     c.test.String(),
+			ID:       c.id,
+			Role:     c.role,
+			Flag:     c.flag,
+			Location: loc,
+			Node:     n,
+			Pos:      pos(n),
+			Text:     text,
+		}
+		if c.report {
+			v.r.SuccessfulReports = append(v.r.SuccessfulReports, out)
+		} else {
+			v.r.FailedAsserts = append(v.r.FailedAsserts, out)
+		}
+	}
+	return nil
+}
+
+func message(parts []part, n xpath.Node, env *xpath.Env) (string, error) {
+	res := []string{}
+	for _, p := range parts {
+		if p.expr == nil {
+			res = append(res, p.text)
+			continue
+		}
+		val, err := p.expr.Evaluate(n, env)
+		if err != nil {
+			return "", fmt.Errorf("schematron: message %s: %v", p.expr, err)
+		}
+		res = append(res, xpath.String(val))
+	}
+	return strings.Join(strings.Fields(strings.Join(res, " ")), " "), nil
+}
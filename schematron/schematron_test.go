@@ -0,0 +1,176 @@
+package schematron
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/xpath"
+)
+
+var testSchema = `<?xml version="1.0"?>
+<schema xmlns="http://purl.oclc.org/dsdl/schematron" queryBinding="xslt">
+  <title>Orders</title>
+  <ns prefix="o" uri="urn:orders"/>
+  <let name="limit" value="100"/>
+  <phase id="quick">
+    <active pattern="totals"/>
+  </phase>
+  <pattern id="totals">
+    <rule context="o:order">
+      <let name="sum" value="sum(o:line/@price)"/>
+      <assert test="@total = $sum" id="total" role="error"><value-of select="@total"/> is not the sum of the lines</assert>
+      <report test="$sum &gt; $limit" id="big" role="info"><name/> is large</report>
+    </rule>
+  </pattern>
+  <pattern id="lines">
+    <rule abstract="true" id="priced">
+      <assert test="@price &gt; 0">prices must be positive</assert>
+    </rule>
+    <rule context="o:line[@free]">
+      <assert test="@price = 0">free lines cost nothing</assert>
+    </rule>
+    <rule context="o:line">
+      <extends rule="priced"/>
+      <assert test="string-length(@sku) = 6" flag="sku"><value-of select="@sku"/> is not a valid SKU</assert>
+    </rule>
+  </pattern>
+</schema>
+`
+
+var validOrders = `<orders xmlns="urn:orders">
+  <order total="30">
+    <line sku="ABC123" price="10"/>
+    <line sku="DEF456" price="20"/>
+    <line sku="FRE000" price="0" free="yes"/>
+  </order>
+  <order total="150">
+    <line sku="XYZ789" price="150"/>
+  </order>
+</orders>
+`
+
+func run(t *testing.T, s *Schema, src, phase string) *Report {
+	doc, err := dom.Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("Cannot parse instance: %v", err)
+	}
+	r, err := s.ValidatePhase(doc, phase)
+	if err != nil {
+		t.Fatalf("Cannot validate: %v", err)
+	}
+	return r
+}
+
+func TestValid(t *testing.T) {
+	s, err := Parse(strings.NewReader(testSchema))
+	if err != nil {
+		t.Fatalf("Cannot load test schema: %v", err)
+	}
+	r := run(t, s, validOrders, "")
+	if !r.Valid() {
+		t.Fatalf("Expected valid document, got %v", r.FailedAsserts)
+	}
+	if r.Title != "Orders" || r.Phase != "#ALL" || len(r.ActivePatterns) != 2 {
+		t.Errorf("Unexpected report header %+v", r)
+	}
+	// Two orders and four lines.
+	if len(r.FiredRules) != 6 {
+		t.Errorf("Expected 6 fired rules, got %v", r.FiredRules)
+	}
+	if len(r.SuccessfulReports) != 1 {
+		t.Fatalf("Expected one report, got %v", r.SuccessfulReports)
+	}
+	rep := r.SuccessfulReports[0]
+	if rep.ID != "big" || rep.Role != "info" || rep.Location != "/orders/order[2]" || rep.Text != "order is large" {
+		t.Errorf("Unexpected report %+v", rep)
+	}
+}
+
+func TestFailedAsserts(t *testing.T) {
+	s, err := Parse(strings.NewReader(testSchema))
+	if err != nil {
+		t.Fatalf("Cannot load test schema: %v", err)
+	}
+	tests := []struct {
+		from, to string
+		location string
+		text     string
+	}{
+		{`total="30"`, `total="31"`, "/orders/order[1]", "31 is not the sum of the lines"},
+		{`price="10"`, `price="-10"`, "/orders/order[1]/line[1]", "prices must be positive"},
+		{`sku="DEF456"`, `sku="DEF45"`, "/orders/order[1]/line[2]", "DEF45 is not a valid SKU"},
+		{`price="0" free`, `price="5" free`, "/orders/order[1]/line[3]", "free lines cost nothing"},
+	}
+	for _, test := range tests {
+		src := strings.Replace(validOrders, test.from, test.to, 1)
+		r := run(t, s, src, "#ALL")
+		found := false
+		for _, f := range r.FailedAsserts {
+			found = found || (f.Location == test.location && f.Text == test.text)
+		}
+		if !found {
+			t.Errorf("Replacing %q with %q: expected %s: %s, got %v",
+				test.from, test.to, test.location, test.text, r.FailedAsserts)
+		}
+	}
+	// Only the first matching rule fires, so the free line is not held
+	// to the SKU rule.
+	src := strings.Replace(validOrders, `sku="FRE000"`, `sku="FREE"`, 1)
+	if r := run(t, s, src, ""); !r.Valid() {
+		t.Errorf("Expected free line to skip the SKU check, got %v", r.FailedAsserts)
+	}
+}
+
+func TestPhase(t *testing.T) {
+	s, err := Parse(strings.NewReader(testSchema))
+	if err != nil {
+		t.Fatalf("Cannot load test schema: %v", err)
+	}
+	src := strings.Replace(validOrders, `sku="DEF456"`, `sku="DEF45"`, 1)
+	r := run(t, s, src, "quick")
+	if !r.Valid() || len(r.ActivePatterns) != 1 || r.ActivePatterns[0] != "totals" {
+		t.Errorf("Unexpected report for quick phase: %+v", r)
+	}
+	doc, _ := dom.Parse(strings.NewReader(src))
+	if _, err := s.ValidatePhase(doc, "slow"); err == nil {
+		t.Errorf("Expected an undeclared phase to fail")
+	}
+}
+
+func TestFunc(t *testing.T) {
+	s, err := Parse(strings.NewReader(`<schema xmlns="http://purl.oclc.org/dsdl/schematron">
+  <pattern>
+    <rule context="item">
+      <assert test="upper(@code) = @code">codes are upper case</assert>
+    </rule>
+  </pattern>
+</schema>`))
+	if err != nil {
+		t.Fatalf("Cannot load schema: %v", err)
+	}
+	s.Func("upper", func(c *xpath.Context, args []xpath.Value) (xpath.Value, error) {
+		return strings.ToUpper(xpath.String(args[0])), nil
+	})
+	r := run(t, s, `<items><item code="AB"/><item code="cd"/></items>`, "")
+	if len(r.FailedAsserts) != 1 || r.FailedAsserts[0].Location != "/items/item[2]" {
+		t.Errorf("Unexpected failures %v", r.FailedAsserts)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, src := range []string{
+		`<schema/>`,
+		`<schema xmlns="http://purl.oclc.org/dsdl/schematron" queryBinding="xslt2"/>`,
+		`<schema xmlns="http://purl.oclc.org/dsdl/schematron"><pattern><rule><assert test="1"/></rule></pattern></schema>`,
+		`<schema xmlns="http://purl.oclc.org/dsdl/schematron"><pattern><rule context="a"><assert test="((("/></rule></pattern></schema>`,
+		`<schema xmlns="http://purl.oclc.org/dsdl/schematron"><pattern><rule context="a"><extends rule="nope"/></rule></pattern></schema>`,
+		`<schema xmlns="http://purl.oclc.org/dsdl/schematron" defaultPhase="p"/>`,
+		`<schema xmlns="http://purl.oclc.org/dsdl/schematron"><phase id="p"><active pattern="q"/></phase></schema>`,
+		`<schema xmlns="http://purl.oclc.org/dsdl/schematron"><pattern abstract="true" id="x"/></schema>`,
+	} {
+		if _, err := Parse(strings.NewReader(src)); err == nil {
+			t.Errorf("Expected %s to fail to load", src)
+		}
+	}
+}
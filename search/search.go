@@ -4,6 +4,7 @@ package search
 
 import (
 	"bytes"
+	"encoding/xml"
 	"regexp"
 
 	"github.com/VictorLowther/simplexml/dom"
@@ -140,6 +141,57 @@ func First(fn Match, nodes []*dom.Element) *dom.Element {
 	return nil
 }
 
+// Find returns all the elements of doc that fn matches, in document
+// order.  Since a Match can be any function, Find looks at every element;
+// FindTag and FindAttr are quicker for what they look for, on documents
+// with an Index.
+func Find(fn Match, doc *dom.Document) []*dom.Element {
+	res := make([]*dom.Element, 0, 0)
+	var walk func(e *dom.Element)
+	walk = func(e *dom.Element) {
+		if fn(e) {
+			res = append(res, e)
+		}
+		for _, c := range e.Children() {
+			walk(c)
+		}
+	}
+	if doc.Root() != nil {
+		walk(doc.Root())
+	}
+	return res
+}
+
+// FindTag returns all the elements of doc that Tag(name, space) matches,
+// in document order.  If indexing is enabled on doc and name is not "*",
+// they are looked up in its Index instead of searched for.
+func FindTag(name, space string, doc *dom.Document) []*dom.Element {
+	idx := doc.Index()
+	switch {
+	case idx == nil || name == "*":
+		return Find(Tag(name, space), doc)
+	case space == "*":
+		return append(make([]*dom.Element, 0, 0), idx.ByLocalName(name)...)
+	}
+	return append(make([]*dom.Element, 0, 0), idx.ByName(xml.Name{Space: space, Local: name})...)
+}
+
+// FindAttr returns all the elements of doc that Attr(name, space, value)
+// matches, in document order.  If indexing is enabled on doc and neither
+// name nor space is "*", they are looked up in its Index instead of
+// searched for.
+func FindAttr(name, space, value string, doc *dom.Document) []*dom.Element {
+	idx := doc.Index()
+	n := xml.Name{Space: space, Local: name}
+	switch {
+	case idx == nil || name == "*" || space == "*":
+		return Find(Attr(name, space, value), doc)
+	case value == "*":
+		return append(make([]*dom.Element, 0, 0), idx.ByAttr(n)...)
+	}
+	return append(make([]*dom.Element, 0, 0), idx.ByAttrValue(n, value)...)
+}
+
 // Tag is a helper function for matching against a specific tag.
 // It takes a name and a namespace URL to match against.
 // If either name or space are "*", then they will match
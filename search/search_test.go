@@ -282,3 +282,69 @@ func TestNever(t *testing.T) {
 		t.Error("Never returned true")
 	}
 }
+
+func TestTextIndex(t *testing.T) {
+	doc, err := dom.Parse(strings.NewReader(`<docs>
+ <d id="a">The quick brown fox jumps over the lazy dog</d>
+ <d id="b">Fox</d>
+ <d id="c">A dog, a DOG and another dog</d>
+ <d id="d">Nothing to see here<sub id="e">fox and dog</sub></d>
+</docs>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := NewTextIndex(doc.Root())
+	ids := func(query string) string {
+		res := []string{}
+		for _, e := range x.Search(query) {
+			res = append(res, e.GetAttr("id", "", "*")[0].Value)
+		}
+		return strings.Join(res, ",")
+	}
+	for query, want := range map[string]string{
+		"fox":         "b,e,a",
+		"dog":         "c,e,a",
+		"FOX dog fox": "e,b,a,c",
+		"missing":     "",
+		"":            "",
+	} {
+		if got := ids(query); got != want {
+			t.Errorf("Search(%q) = %s, want %s", query, got, want)
+		}
+	}
+}
+
+func TestFindIndexed(t *testing.T) {
+	doc := parseDoc()
+	queries := []func() []*dom.Element{
+		func() []*dom.Element { return FindTag("node2", "", doc) },
+		func() []*dom.Element { return FindTag("root", "*", doc) },
+		func() []*dom.Element { return FindTag("*", "", doc) },
+		func() []*dom.Element { return FindAttr("order", "", "*", doc) },
+		func() []*dom.Element { return FindAttr("idx", "", "5", doc) },
+		func() []*dom.Element { return FindAttr("*", "*", "bar", doc) },
+	}
+	idxs := func(res []*dom.Element) string {
+		out := []string{}
+		for _, e := range res {
+			out = append(out, e.GetAttr("idx", "", "*")[0].Value)
+		}
+		return strings.Join(out, ",")
+	}
+	want := []string{"2,5,3", "0", "1,4,2,5,3", "2,5,3", "5", "1"}
+	for i, q := range queries {
+		if got := idxs(q()); got != want[i] {
+			t.Errorf("query %d without an index: expected %s, got %s", i, want[i], got)
+		}
+	}
+	doc.EnableIndex()
+	for i, q := range queries {
+		if got := idxs(q()); got != want[i] {
+			t.Errorf("query %d with an index: expected %s, got %s", i, want[i], got)
+		}
+	}
+	doc.Root().Child(0).AddChild(dom.Elem("node2", "").Attr("idx", "", "6"))
+	if got := idxs(FindTag("node2", "", doc)); got != "6,2,5,3" {
+		t.Errorf("the index should follow changes, got %s", got)
+	}
+}
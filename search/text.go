@@ -0,0 +1,113 @@
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+// TextIndex is an inverted index over the Content of the elements in a
+// tree, for finding elements by the words in them, the way a document
+// browser's search box would.  It is built once from the tree, and does
+// not see changes made to it afterwards; build a new one instead.  A
+// TextIndex is safe for concurrent searches.
+type TextIndex struct {
+	elems []*dom.Element
+	// lengths holds the number of words in each element, and avg the
+	// average of them.
+	lengths []int
+	avg     float64
+	// postings maps each word to the elements it is in, in document
+	// order, and the number of times it is in each.
+	postings map[string][]posting
+}
+
+type posting struct {
+	elem, count int
+}
+
+// words splits text into lower-case words, which are runs of letters
+// and digits.
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// NewTextIndex indexes the Content of root and its descendants.
+func NewTextIndex(root *dom.Element) *TextIndex {
+	x := &TextIndex{postings: map[string][]posting{}}
+	total := 0
+	var walk func(e *dom.Element)
+	walk = func(e *dom.Element) {
+		if ws := words(string(e.Content)); len(ws) > 0 {
+			i := len(x.elems)
+			x.elems = append(x.elems, e)
+			x.lengths = append(x.lengths, len(ws))
+			total += len(ws)
+			for _, w := range ws {
+				p := x.postings[w]
+				if n := len(p); n > 0 && p[n-1].elem == i {
+					p[n-1].count++
+				} else {
+					x.postings[w] = append(p, posting{elem: i, count: 1})
+				}
+			}
+		}
+		for i := 0; i < e.NumChildren(); i++ {
+			walk(e.Child(i))
+		}
+	}
+	walk(root)
+	if len(x.elems) > 0 {
+		x.avg = float64(total) / float64(len(x.elems))
+	}
+	return x
+}
+
+// Search returns the elements whose Content has any of the words in
+// query, best match first.  Matches are ranked with BM25, so elements
+// with more of the words, with rarer words, with the words more often,
+// and with less other text around them, come first.  Words are matched
+// without regard to case, and elements that match equally well are
+// returned in document order.
+func (x *TextIndex) Search(query string) []*dom.Element {
+	const k1, b = 1.2, 0.75
+	scores := map[int]float64{}
+	seen := map[string]bool{}
+	n := float64(len(x.elems))
+	for _, w := range words(query) {
+		if seen[w] {
+			continue
+		}
+		seen[w] = true
+		p := x.postings[w]
+		if len(p) == 0 {
+			continue
+		}
+		idf := math.Log(1 + (n-float64(len(p))+0.5)/(float64(len(p))+0.5))
+		for _, post := range p {
+			tf := float64(post.count)
+			norm := k1 * (1 - b + b*float64(x.lengths[post.elem])/x.avg)
+			scores[post.elem] += idf * tf * (k1 + 1) / (tf + norm)
+		}
+	}
+	hits := make([]int, 0, len(scores))
+	for i := range scores {
+		hits = append(hits, i)
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if si, sj := scores[hits[i]], scores[hits[j]]; si != sj {
+			return si > sj
+		}
+		return hits[i] < hits[j]
+	})
+	res := make([]*dom.Element, len(hits))
+	for i, h := range hits {
+		res[i] = x.elems[h]
+	}
+	return res
+}
@@ -0,0 +1,192 @@
+package soap
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+// Fault is a SOAP fault.  The same type is used for both versions; the
+// fields that one version does not have are ignored when building a
+// fault for it.
+type Fault struct {
+	// Code is the fault code.  The standard codes are in the envelope
+	// namespace, see Version.Code.
+	Code xml.Name
+	// Subcodes are the nested subcodes of a SOAP 1.2 fault, outermost
+	// first.
+	Subcodes []xml.Name
+	// Reason is the human-readable explanation of the fault: the
+	// faultstring in SOAP 1.1, the first Reason text in SOAP 1.2.
+	Reason string
+	// Role is the faultactor in SOAP 1.1 or the Role in SOAP 1.2.
+	Role string
+	// Node is the Node of a SOAP 1.2 fault.
+	Node string
+	// Detail holds the detail entries of the fault.
+	Detail []*dom.Element
+}
+
+func (f *Fault) Error() string {
+	return fmt.Sprintf("soap: %s fault: %s", f.Code.Local, f.Reason)
+}
+
+// Code returns the name of the standard fault code local in the
+// envelope namespace of v, such as "Server" for SOAP 1.1 or "Receiver"
+// for SOAP 1.2.
+func (v Version) Code(local string) xml.Name {
+	return xml.Name{Space: v.Namespace(), Local: local}
+}
+
+// prefixFor returns a prefix bound to ns at e, adding a declaration to
+// e (or to the envelope, for the envelope namespace) if there is none.
+func (env *Envelope) prefixFor(e *dom.Element, ns string) string {
+	used := map[string]bool{}
+	for p := e; p != nil; p = p.Parent() {
+		for _, a := range p.Attributes {
+			if a.Name.Space == "xmlns" {
+				if a.Value == ns {
+					return a.Name.Local
+				}
+				used[a.Name.Local] = true
+			}
+		}
+	}
+	if ns == env.Version.Namespace() && !used["soap"] {
+		env.Element.Attr("soap", "xmlns", ns)
+		return "soap"
+	}
+	prefix := ""
+	for i := 0; prefix == "" || used[prefix]; i++ {
+		prefix = fmt.Sprintf("fc%d", i)
+	}
+	e.Attr(prefix, "xmlns", ns)
+	return prefix
+}
+
+func (env *Envelope) qname(e *dom.Element, n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return env.prefixFor(e, n.Space) + ":" + n.Local
+}
+
+// resolve turns the QName in the content of e into a name.
+func resolve(e *dom.Element) xml.Name {
+	text := strings.TrimSpace(string(e.Content))
+	prefix, local := "", text
+	if i := strings.Index(text, ":"); i >= 0 {
+		prefix, local = text[:i], text[i+1:]
+	}
+	for p := e; p != nil; p = p.Parent() {
+		for _, a := range p.Attributes {
+			if (prefix != "" && a.Name.Space == "xmlns" && a.Name.Local == prefix) ||
+				(prefix == "" && a.Name.Space == "" && a.Name.Local == "xmlns") {
+				return xml.Name{Space: a.Value, Local: local}
+			}
+		}
+	}
+	return xml.Name{Space: prefix, Local: local}
+}
+
+// SetFault replaces the contents of the Body of env with f.  The return
+// value is env.
+func (env *Envelope) SetFault(f *Fault) *Envelope {
+	body := env.Body()
+	for _, c := range body.Children() {
+		body.RemoveChild(c)
+	}
+	ns := env.Version.Namespace()
+	fault := dom.Elem("Fault", ns)
+	body.AddChild(fault)
+	if env.Version == SOAP11 {
+		fault.AddChild(dom.ElemC("faultcode", "", env.qname(fault, f.Code)))
+		fault.AddChild(dom.ElemC("faultstring", "", f.Reason))
+		if f.Role != "" {
+			fault.AddChild(dom.ElemC("faultactor", "", f.Role))
+		}
+		if len(f.Detail) > 0 {
+			fault.AddChild(dom.Elem("detail", "").AddChildren(f.Detail...))
+		}
+		return env
+	}
+	code := dom.Elem("Code", ns)
+	fault.AddChild(code)
+	code.AddChild(dom.ElemC("Value", ns, env.qname(fault, f.Code)))
+	for _, sc := range f.Subcodes {
+		sub := dom.Elem("Subcode", ns)
+		code.AddChild(sub)
+		sub.AddChild(dom.ElemC("Value", ns, env.qname(fault, sc)))
+		code = sub
+	}
+	fault.AddChild(dom.Elem("Reason", ns).AddChild(
+		dom.ElemC("Text", ns, f.Reason).Attr("lang", dom.NS_XML, "en")))
+	if f.Node != "" {
+		fault.AddChild(dom.ElemC("Node", ns, f.Node))
+	}
+	if f.Role != "" {
+		fault.AddChild(dom.ElemC("Role", ns, f.Role))
+	}
+	if len(f.Detail) > 0 {
+		fault.AddChild(dom.Elem("Detail", ns).AddChildren(f.Detail...))
+	}
+	return env
+}
+
+func childNamed(e *dom.Element, local string) *dom.Element {
+	for _, c := range e.Children() {
+		if c.Name.Local == local {
+			return c
+		}
+	}
+	return nil
+}
+
+func text(e *dom.Element) string {
+	if e == nil {
+		return ""
+	}
+	return strings.TrimSpace(string(e.Content))
+}
+
+// Fault returns the fault in the Body of env, or nil if there is none.
+func (env *Envelope) Fault() *Fault {
+	fault := env.Payload()
+	if fault == nil || fault.Name.Local != "Fault" || fault.Name.Space != env.Version.Namespace() {
+		return nil
+	}
+	f := &Fault{}
+	if env.Version == SOAP11 {
+		if c := childNamed(fault, "faultcode"); c != nil {
+			f.Code = resolve(c)
+		}
+		f.Reason = text(childNamed(fault, "faultstring"))
+		f.Role = text(childNamed(fault, "faultactor"))
+		if d := childNamed(fault, "detail"); d != nil {
+			f.Detail = d.Children()
+		}
+		return f
+	}
+	for code, first := childNamed(fault, "Code"), true; code != nil; code, first = childNamed(code, "Subcode"), false {
+		v := childNamed(code, "Value")
+		if v == nil {
+			break
+		}
+		if first {
+			f.Code = resolve(v)
+		} else {
+			f.Subcodes = append(f.Subcodes, resolve(v))
+		}
+	}
+	if r := childNamed(fault, "Reason"); r != nil {
+		f.Reason = text(childNamed(r, "Text"))
+	}
+	f.Node = text(childNamed(fault, "Node"))
+	f.Role = text(childNamed(fault, "Role"))
+	if d := childNamed(fault, "Detail"); d != nil {
+		f.Detail = d.Children()
+	}
+	return f
+}
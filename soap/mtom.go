@@ -0,0 +1,264 @@
+package soap
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+// Namespaces of XOP includes and of the xmime:contentType attribute that
+// says what an attachment holds.
+const (
+	NS_XOP   = "http://www.w3.org/2004/08/xop/include"
+	NS_XMIME = "http://www.w3.org/2005/05/xmlmime"
+)
+
+// MediaTypeXOP is the media type of the envelope part of an MTOM message.
+const MediaTypeXOP = "application/xop+xml"
+
+// Attachment is a binary part of an MTOM message, which an xop:Include
+// in the envelope refers to by its ContentID instead of having it in the
+// tree as base64 text.
+type Attachment struct {
+	// ContentID identifies the attachment, without the angle brackets
+	// it has in the Content-ID header.
+	ContentID   string
+	ContentType string
+	// Body is what the attachment holds.  It is only read when the
+	// message is written, so it can stream from a file.  For
+	// attachments read by an MTOMReader, it is only valid until the
+	// next call to Next.
+	Body io.Reader
+}
+
+// Include replaces the Content and children of e with an xop:Include of
+// a, so that e holds what a does, and returns e.
+func (env *Envelope) Include(e *dom.Element, a *Attachment) *dom.Element {
+	for _, c := range e.Children() {
+		e.RemoveChild(c)
+	}
+	e.Content = nil
+	if a.ContentType != "" {
+		e.Attr("contentType", NS_XMIME, a.ContentType)
+	}
+	inc := dom.Elem("Include", NS_XOP).Attr("xop", "xmlns", NS_XOP)
+	return e.AddChild(inc.Attr("href", "", "cid:"+url.PathEscape(a.ContentID)))
+}
+
+// References returns the elements in env that hold attachments, by the
+// ContentID of the attachment each xop:Include in them refers to.
+func (env *Envelope) References() map[string]*dom.Element {
+	res := map[string]*dom.Element{}
+	for _, e := range env.Element.All() {
+		if e.Name.Local != "Include" || e.Name.Space != NS_XOP || e.Parent() == nil {
+			continue
+		}
+		for _, a := range e.Attributes {
+			if a.Name.Space != "" || a.Name.Local != "href" || !strings.HasPrefix(a.Value, "cid:") {
+				continue
+			}
+			if id, err := url.PathUnescape(a.Value[len("cid:"):]); err == nil {
+				res[id] = e.Parent()
+			}
+		}
+	}
+	return res
+}
+
+// Inline puts what a holds back into env as base64 text, in place of the
+// xop:Include that refers to it, for consumers that want the whole
+// message in the tree.  It fails if nothing in env refers to a.
+func (env *Envelope) Inline(a *Attachment) error {
+	e, ok := env.References()[a.ContentID]
+	if !ok {
+		return fmt.Errorf("soap: nothing refers to attachment %s", a.ContentID)
+	}
+	for _, c := range e.Children() {
+		if c.Name.Local == "Include" && c.Name.Space == NS_XOP {
+			e.RemoveChild(c)
+		}
+	}
+	return e.SetContentReaderBase64(a.Body)
+}
+
+// Optimize makes attachments of the leaf elements in the Body of env
+// whose Content is base64 text at least minSize bytes long, replacing
+// them with xop:Includes of the attachments, and returns the
+// attachments.  The elements are not decoded until the attachments are
+// written.  The content type of each is taken from its
+// xmime:contentType attribute, and is application/octet-stream if it
+// has none.
+func (env *Envelope) Optimize(minSize int) ([]*Attachment, error) {
+	var tag [8]byte
+	if _, err := rand.Read(tag[:]); err != nil {
+		return nil, err
+	}
+	var res []*Attachment
+	for _, e := range env.Body().All() {
+		if len(e.Children()) > 0 || len(e.Content) < minSize || len(e.Content) == 0 {
+			continue
+		}
+		// A copy of the element keeps the text to decode, and checks
+		// that it is base64 without holding it twice.
+		holder := dom.Elem(e.Name.Local, e.Name.Space)
+		holder.Content = e.Content
+		if _, err := io.Copy(io.Discard, holder.ContentReaderBase64()); err != nil {
+			continue
+		}
+		a := &Attachment{
+			ContentID:   fmt.Sprintf("%d.%s@simplexml", len(res), hex.EncodeToString(tag[:])),
+			ContentType: "application/octet-stream",
+			Body:        holder.ContentReaderBase64(),
+		}
+		if v, ok := attr(e, NS_XMIME, "contentType"); ok {
+			a.ContentType = v
+		}
+		env.Include(e, a)
+		res = append(res, a)
+	}
+	return res, nil
+}
+
+func attr(e *dom.Element, space, local string) (string, bool) {
+	for _, a := range e.Attributes {
+		if a.Name.Space == space && a.Name.Local == local {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// startInfo returns the media type of env without its parameters.
+func (env *Envelope) startInfo() string {
+	t, _, _ := mime.ParseMediaType(env.Version.ContentType())
+	return t
+}
+
+// rootID is the Content-ID of the envelope part of the messages
+// WriteMTOM writes.
+const rootID = "envelope@simplexml"
+
+// WriteMTOM writes env and atts to w as an MTOM message, a
+// multipart/related package with env in its first part and the
+// attachments after it, and returns the Content-Type the message has to
+// be sent with, as in:
+//    atts, err := env.Optimize(1024)
+//    ...
+//    var body bytes.Buffer
+//    ct, err := WriteMTOM(&body, env, atts)
+//    ...
+//    resp, err := http.Post(url, ct, &body)
+// The attachments are copied from their Body as they are written, so
+// that they need never all be in memory.
+func WriteMTOM(w io.Writer, env *Envelope, atts []*Attachment) (string, error) {
+	mw := multipart.NewWriter(w)
+	info := env.startInfo()
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", mime.FormatMediaType(MediaTypeXOP, map[string]string{"charset": "UTF-8", "type": info}))
+	h.Set("Content-Transfer-Encoding", "binary")
+	h.Set("Content-ID", "<"+rootID+">")
+	part, err := mw.CreatePart(h)
+	if err != nil {
+		return "", err
+	}
+	e := dom.NewEncoder(part)
+	if err = env.Document().Encode(e); err == nil {
+		err = e.Flush()
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, a := range atts {
+		h := textproto.MIMEHeader{}
+		ct := a.ContentType
+		if ct == "" {
+			ct = "application/octet-stream"
+		}
+		h.Set("Content-Type", ct)
+		h.Set("Content-Transfer-Encoding", "binary")
+		h.Set("Content-ID", "<"+a.ContentID+">")
+		if part, err = mw.CreatePart(h); err != nil {
+			return "", err
+		}
+		if _, err = io.Copy(part, a.Body); err != nil {
+			return "", fmt.Errorf("soap: attachment %s: %w", a.ContentID, err)
+		}
+	}
+	if err = mw.Close(); err != nil {
+		return "", err
+	}
+	return mime.FormatMediaType("multipart/related", map[string]string{
+		"boundary":   mw.Boundary(),
+		"type":       MediaTypeXOP,
+		"start":      "<" + rootID + ">",
+		"start-info": info,
+	}), nil
+}
+
+// MTOMReader reads an MTOM message as it arrives: the envelope first,
+// and then the attachments one at a time, so that they can be streamed
+// to wherever they are going without ever being held in memory.
+type MTOMReader struct {
+	mr  *multipart.Reader
+	env *Envelope
+}
+
+// ReadMTOM reads the envelope of the MTOM message in r, which was sent
+// with contentType.  The envelope has to be in the first part, as the
+// MTOM specification says it should be.
+func ReadMTOM(r io.Reader, contentType string) (*MTOMReader, error) {
+	t, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("soap: invalid Content-Type: %w", err)
+	}
+	if t != "multipart/related" || params["boundary"] == "" {
+		return nil, fmt.Errorf("soap: %s is not an MTOM message", t)
+	}
+	mr := multipart.NewReader(r, params["boundary"])
+	part, err := mr.NextPart()
+	if err != nil {
+		return nil, fmt.Errorf("soap: reading the envelope part: %w", err)
+	}
+	if start := params["start"]; start != "" && part.Header.Get("Content-ID") != start {
+		return nil, fmt.Errorf("soap: the first part is not the envelope %s", start)
+	}
+	if t, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type")); t != MediaTypeXOP {
+		return nil, fmt.Errorf("soap: the envelope part is %s, not %s", t, MediaTypeXOP)
+	}
+	env, err := Parse(part)
+	if err != nil {
+		return nil, err
+	}
+	return &MTOMReader{mr: mr, env: env}, nil
+}
+
+// Envelope returns the envelope of the message, whose References say
+// where the attachments go.
+func (m *MTOMReader) Envelope() *Envelope {
+	return m.env
+}
+
+// Next returns the next attachment, or io.EOF once there are no more.
+func (m *MTOMReader) Next() (*Attachment, error) {
+	part, err := m.mr.NextPart()
+	if err == io.EOF {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("soap: reading an attachment: %w", err)
+	}
+	id := strings.TrimSuffix(strings.TrimPrefix(part.Header.Get("Content-ID"), "<"), ">")
+	if id == "" {
+		return nil, errors.New("soap: attachment has no Content-ID")
+	}
+	return &Attachment{ContentID: id, ContentType: part.Header.Get("Content-Type"), Body: part}, nil
+}
@@ -0,0 +1,248 @@
+// Package soap builds and takes apart SOAP 1.1 and 1.2 envelopes on top
+// of simplexml/dom.
+//
+// An Envelope wraps the Envelope element of a message.  NewEnvelope
+// creates one with empty Header and Body elements, and Parse and
+// FromDocument read an existing one, working out the SOAP version from
+// its namespace.  Faults can be put into and read out of the Body as
+// typed Fault values, and CheckMustUnderstand implements the processing
+// rule for header blocks marked with mustUnderstand.
+//
+// Large binary payloads can be sent as MTOM attachments instead of base64
+// text in the Body: Optimize and Include replace them with xop:Include
+// references, WriteMTOM writes a message with the attachments streamed
+// after the envelope, and an MTOMReader reads one back.
+//
+// For some basic usage examples, see soap_test.go
+package soap
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+// Envelope namespaces of the two SOAP versions.
+const (
+	NS_SOAP11 = "http://schemas.xmlsoap.org/soap/envelope/"
+	NS_SOAP12 = "http://www.w3.org/2003/05/soap-envelope"
+)
+
+// Version is a SOAP version.
+type Version int
+
+const (
+	SOAP11 Version = iota + 1
+	SOAP12
+)
+
+// Namespace returns the envelope namespace of v.
+func (v Version) Namespace() string {
+	if v == SOAP12 {
+		return NS_SOAP12
+	}
+	return NS_SOAP11
+}
+
+// ContentType returns the HTTP Content-Type of messages using v.
+func (v Version) ContentType() string {
+	if v == SOAP12 {
+		return "application/soap+xml; charset=utf-8"
+	}
+	return "text/xml; charset=utf-8"
+}
+
+func (v Version) String() string {
+	if v == SOAP12 {
+		return "SOAP 1.2"
+	}
+	return "SOAP 1.1"
+}
+
+// Roles a SOAP 1.2 node always plays, and the SOAP 1.1 equivalent of
+// RoleNext.
+const (
+	RoleNext             = NS_SOAP12 + "/role/next"
+	RoleNone             = NS_SOAP12 + "/role/none"
+	RoleUltimateReceiver = NS_SOAP12 + "/role/ultimateReceiver"
+	ActorNext            = "http://schemas.xmlsoap.org/soap/actor/next"
+)
+
+// Envelope is a SOAP envelope.
+type Envelope struct {
+	Version Version
+	// Element is the Envelope element itself.
+	Element *dom.Element
+}
+
+// NewEnvelope creates an envelope with an empty Header and Body.
+func NewEnvelope(v Version) *Envelope {
+	ns := v.Namespace()
+	e := dom.Elem("Envelope", ns).Attr("soap", "xmlns", ns)
+	e.AddChildren(dom.Elem("Header", ns), dom.Elem("Body", ns))
+	return &Envelope{Version: v, Element: e}
+}
+
+// FromDocument wraps the envelope in doc.
+func FromDocument(doc *dom.Document) (*Envelope, error) {
+	root := doc.Root()
+	if root == nil {
+		return nil, errors.New("soap: document has no root element")
+	}
+	if root.Name.Local != "Envelope" {
+		return nil, fmt.Errorf("soap: root element is %s, not Envelope", root.Name.Local)
+	}
+	env := &Envelope{Element: root}
+	switch root.Name.Space {
+	case NS_SOAP11:
+		env.Version = SOAP11
+	case NS_SOAP12:
+		env.Version = SOAP12
+	default:
+		return nil, fmt.Errorf("soap: unknown envelope namespace %q", root.Name.Space)
+	}
+	if env.Body() == nil {
+		return nil, errors.New("soap: envelope has no Body")
+	}
+	return env, nil
+}
+
+// Parse reads an envelope from r.
+func Parse(r io.Reader) (*Envelope, error) {
+	doc, err := dom.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	return FromDocument(doc)
+}
+
+// Document returns a Document holding env.
+func (env *Envelope) Document() *dom.Document {
+	doc := dom.CreateDocument()
+	doc.SetRoot(env.Element)
+	return doc
+}
+
+func (env *Envelope) child(name string) *dom.Element {
+	for _, c := range env.Element.Children() {
+		if c.Name.Local == name && c.Name.Space == env.Version.Namespace() {
+			return c
+		}
+	}
+	return nil
+}
+
+// Header returns the Header element of env, or nil if it has none.
+func (env *Envelope) Header() *dom.Element {
+	return env.child("Header")
+}
+
+// Body returns the Body element of env.
+func (env *Envelope) Body() *dom.Element {
+	return env.child("Body")
+}
+
+// Headers returns the header blocks in env.
+func (env *Envelope) Headers() []*dom.Element {
+	if h := env.Header(); h != nil {
+		return h.Children()
+	}
+	return nil
+}
+
+// AddHeader adds header blocks to env, creating its Header if needed.
+// The return value is env.
+func (env *Envelope) AddHeader(blocks ...*dom.Element) *Envelope {
+	h := env.Header()
+	if h == nil {
+		h = dom.Elem("Header", env.Version.Namespace())
+		rest := env.Element.Children()
+		for _, c := range rest {
+			env.Element.RemoveChild(c)
+		}
+		env.Element.AddChild(h).AddChildren(rest...)
+	}
+	h.AddChildren(blocks...)
+	return env
+}
+
+// AddBody adds elements to the Body of env.  The return value is env.
+func (env *Envelope) AddBody(elems ...*dom.Element) *Envelope {
+	env.Body().AddChildren(elems...)
+	return env
+}
+
+// Payload returns the first element in the Body, or nil if it is empty.
+func (env *Envelope) Payload() *dom.Element {
+	if c := env.Body().Children(); len(c) > 0 {
+		return c[0]
+	}
+	return nil
+}
+
+func (env *Envelope) attr(e *dom.Element, name string) (string, bool) {
+	for _, a := range e.Attributes {
+		if a.Name.Local == name && a.Name.Space == env.Version.Namespace() {
+			return strings.TrimSpace(a.Value), true
+		}
+	}
+	return "", false
+}
+
+// SetMustUnderstand marks the header block h as one that must be
+// understood by the node it is targeted at.  The return value is h.
+func (env *Envelope) SetMustUnderstand(h *dom.Element) *dom.Element {
+	v := "1"
+	if env.Version == SOAP12 {
+		v = "true"
+	}
+	return h.Attr("mustUnderstand", env.Version.Namespace(), v)
+}
+
+// MustUnderstand reports whether the header block h is marked with
+// mustUnderstand.
+func (env *Envelope) MustUnderstand(h *dom.Element) bool {
+	v, _ := env.attr(h, "mustUnderstand")
+	return v == "1" || (env.Version == SOAP12 && v == "true")
+}
+
+// targeted reports whether the header block h is meant for a node that
+// plays roles, on top of the ones every node plays.
+func (env *Envelope) targeted(h *dom.Element, roles []string) bool {
+	role, ok := env.attr(h, "role")
+	if env.Version == SOAP11 {
+		role, ok = env.attr(h, "actor")
+	}
+	if !ok || role == "" || role == RoleNext || role == ActorNext ||
+		(env.Version == SOAP12 && role == RoleUltimateReceiver) {
+		return true
+	}
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckMustUnderstand applies the mustUnderstand rule to env: every
+// header block targeted at this node, which plays the standard roles and
+// the extra ones in roles, that is marked with mustUnderstand must be
+// understood.  It returns a MustUnderstand Fault for the first block
+// for which understood returns false, or nil.
+func (env *Envelope) CheckMustUnderstand(understood func(h *dom.Element) bool, roles ...string) *Fault {
+	for _, h := range env.Headers() {
+		if !env.targeted(h, roles) || !env.MustUnderstand(h) || understood(h) {
+			continue
+		}
+		return &Fault{
+			Code:   xml.Name{Space: env.Version.Namespace(), Local: "MustUnderstand"},
+			Reason: fmt.Sprintf("header block {%s}%s was not understood", h.Name.Space, h.Name.Local),
+		}
+	}
+	return nil
+}
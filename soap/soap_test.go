@@ -0,0 +1,165 @@
+package soap
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+const wsman = "http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd"
+
+func roundTrip(t *testing.T, env *Envelope) *Envelope {
+	res, err := Parse(bytes.NewReader(env.Document().Bytes()))
+	if err != nil {
+		t.Fatalf("Cannot parse %s: %v", env.Document(), err)
+	}
+	return res
+}
+
+func TestEnvelope(t *testing.T) {
+	for _, v := range []Version{SOAP11, SOAP12} {
+		env := NewEnvelope(v)
+		env.AddHeader(env.SetMustUnderstand(dom.ElemC("ResourceURI", wsman, "urn:r")))
+		env.AddBody(dom.Elem("Get", "urn:test"))
+		back := roundTrip(t, env)
+		if back.Version != v || len(back.Headers()) != 1 || back.Payload().Name.Local != "Get" {
+			t.Errorf("%v: unexpected envelope %s", v, back.Document())
+		}
+		if !back.MustUnderstand(back.Headers()[0]) {
+			t.Errorf("%v: expected mustUnderstand on %v", v, back.Headers()[0])
+		}
+		if back.Fault() != nil {
+			t.Errorf("%v: unexpected fault", v)
+		}
+	}
+	env := &Envelope{Version: SOAP11, Element: dom.Elem("Envelope", NS_SOAP11).AddChild(dom.Elem("Body", NS_SOAP11))}
+	env.AddHeader(dom.Elem("h", "urn:h"))
+	if c := env.Element.Children(); len(c) != 2 || c[0].Name.Local != "Header" {
+		t.Errorf("Expected the Header to come first, got %v", env.Element)
+	}
+	for _, src := range []string{
+		`<Envelope/>`,
+		`<Envelope xmlns="http://schemas.xmlsoap.org/soap/envelope/"/>`,
+		`<foo xmlns="http://schemas.xmlsoap.org/soap/envelope/"><Body/></foo>`,
+	} {
+		if _, err := Parse(strings.NewReader(src)); err == nil {
+			t.Errorf("Expected %s to be rejected", src)
+		}
+	}
+}
+
+func TestFault(t *testing.T) {
+	for _, v := range []Version{SOAP11, SOAP12} {
+		f := &Fault{
+			Code:     v.Code("Server"),
+			Subcodes: []xml.Name{{Space: wsman, Local: "InternalError"}},
+			Reason:   "it broke",
+			Role:     "urn:role",
+			Node:     "urn:node",
+			Detail:   []*dom.Element{dom.ElemC("why", "urn:test", "because")},
+		}
+		env := NewEnvelope(v).AddBody(dom.Elem("ignored", ""))
+		env.SetFault(f)
+		back := roundTrip(t, env).Fault()
+		if back == nil {
+			t.Fatalf("%v: expected a fault in %s", v, env.Document())
+		}
+		if back.Code != f.Code || back.Reason != f.Reason || back.Role != f.Role ||
+			len(back.Detail) != 1 || string(back.Detail[0].Content) != "because" {
+			t.Errorf("%v: unexpected fault %+v", v, back)
+		}
+		if v == SOAP12 && (len(back.Subcodes) != 1 || back.Subcodes[0] != f.Subcodes[0] || back.Node != f.Node) {
+			t.Errorf("%v: unexpected subcodes %+v", v, back)
+		}
+		if !strings.Contains(back.Error(), "it broke") {
+			t.Errorf("Unexpected error text %s", back.Error())
+		}
+	}
+	src := `<e:Envelope xmlns:e="http://schemas.xmlsoap.org/soap/envelope/"><e:Body><e:Fault>
+  <faultcode>e:Client</faultcode><faultstring>bad input</faultstring>
+</e:Fault></e:Body></e:Envelope>`
+	env, err := Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := env.Fault(); f == nil || f.Code != SOAP11.Code("Client") || f.Reason != "bad input" {
+		t.Errorf("Unexpected fault %+v", f)
+	}
+}
+
+func TestMustUnderstand(t *testing.T) {
+	env := NewEnvelope(SOAP12)
+	known := dom.Elem("Known", "urn:h")
+	other := dom.Elem("Other", "urn:h").Attr("role", NS_SOAP12, "urn:someone-else")
+	mine := dom.Elem("Mine", "urn:h").Attr("role", NS_SOAP12, "urn:me")
+	env.AddHeader(env.SetMustUnderstand(known), env.SetMustUnderstand(other), dom.Elem("Optional", "urn:h"))
+	understood := func(h *dom.Element) bool { return h.Name.Local == "Known" }
+	if f := env.CheckMustUnderstand(understood); f != nil {
+		t.Errorf("Unexpected fault %v", f)
+	}
+	env.AddHeader(env.SetMustUnderstand(mine))
+	if f := env.CheckMustUnderstand(understood); f != nil {
+		t.Errorf("Unexpected fault for a role we do not play: %v", f)
+	}
+	f := env.CheckMustUnderstand(understood, "urn:me")
+	if f == nil || f.Code != SOAP12.Code("MustUnderstand") || !strings.Contains(f.Reason, "Mine") {
+		t.Errorf("Expected a MustUnderstand fault, got %v", f)
+	}
+}
+
+func TestMTOM(t *testing.T) {
+	payload := bytes.Repeat([]byte("\x00\x01binary\xff"), 200)
+	env := NewEnvelope(SOAP12)
+	img := dom.Elem("image", "urn:test").Attr("contentType", NS_XMIME, "image/png").SetContentBytesBase64(payload)
+	env.AddBody(dom.Elem("Upload", "urn:test").AddChildren(dom.ElemC("name", "urn:test", "small"), img))
+	atts, err := env.Optimize(1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(atts) != 1 || atts[0].ContentType != "image/png" || len(img.Content) != 0 {
+		t.Fatalf("expected one image/png attachment, got %v in %s", atts, env.Document())
+	}
+	var msg bytes.Buffer
+	ct, err := WriteMTOM(&msg, env, atts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(ct, `type="application/xop+xml"`) || !strings.Contains(ct, `start-info="application/soap+xml"`) {
+		t.Errorf("unexpected Content-Type %s", ct)
+	}
+	r, err := ReadMTOM(&msg, ct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back := r.Envelope()
+	refs := back.References()
+	if e, ok := refs[atts[0].ContentID]; !ok || e.Name.Local != "image" {
+		t.Fatalf("expected a reference to %s, got %v", atts[0].ContentID, refs)
+	}
+	a, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.ContentID != atts[0].ContentID || a.ContentType != "image/png" {
+		t.Errorf("unexpected attachment %+v", a)
+	}
+	if err := back.Inline(a); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := refs[a.ContentID].ContentBytesBase64(); err != nil || !bytes.Equal(got, payload) {
+		t.Errorf("attachment did not come back whole: %v", err)
+	}
+	if len(back.References()) != 0 {
+		t.Errorf("expected no references left in %s", back.Document())
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+	if _, err := ReadMTOM(strings.NewReader(""), "text/xml"); err == nil {
+		t.Errorf("expected a plain SOAP message to be rejected")
+	}
+}
@@ -0,0 +1,123 @@
+package transform
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/xpath"
+)
+
+func attr(e *dom.Element, name string) (string, bool) {
+	for _, a := range e.Attributes {
+		if a.Name.Space == "" && a.Name.Local == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+func errorAt(e *dom.Element, format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	if p := e.Pos(); p.IsValid() {
+		return fmt.Errorf("transform: %v: %s", p, msg)
+	}
+	return fmt.Errorf("transform: %s", msg)
+}
+
+func required(e *dom.Element, name string) (string, error) {
+	v, ok := attr(e, name)
+	if !ok || strings.TrimSpace(v) == "" {
+		return "", errorAt(e, "%s is missing its %s attribute", e.Name.Local, name)
+	}
+	return v, nil
+}
+
+// Load reads Rules from a rules element, as shown in the package
+// documentation.  Its children are the rules, in order, each named for
+// its Action:
+//    <rename match="..." to="name"/>
+//    <move match="..." under="..."/>
+//    <drop match="..."/>
+//    <unwrap match="..."/>
+//    <set-attr match="..." name="name" value="..."/>
+//    <remove-attr match="..." name="name"/>
+// match and under are XPath expressions, and to and name are prefixed
+// names.  Both use the namespace prefixes declared on the rules
+// element, but unprefixed names are never in a namespace.  A
+// fixpoint attribute of true on rules sets Fixpoint, and max-passes sets
+// MaxPasses.  The expressions are checked when Load is called.
+func Load(rules *dom.Element) (*Rules, error) {
+	if rules.Name.Local != "rules" {
+		return nil, errorAt(rules, "%s is not a rules element", rules.Name.Local)
+	}
+	rs := &Rules{Env: xpath.NewEnv()}
+	if v, ok := attr(rules, "fixpoint"); ok {
+		rs.Fixpoint = strings.TrimSpace(v) == "true"
+	}
+	if v, ok := attr(rules, "max-passes"); ok {
+		if _, err := fmt.Sscan(v, &rs.MaxPasses); err != nil {
+			return nil, errorAt(rules, "invalid max-passes %q", v)
+		}
+	}
+	for _, a := range rules.Attributes {
+		if a.Name.Space == "xmlns" {
+			rs.Env.Namespace(a.Name.Local, a.Value)
+		}
+	}
+	for _, c := range rules.Children() {
+		r, err := loadRule(c)
+		if err != nil {
+			return nil, err
+		}
+		rs.Rules = append(rs.Rules, r)
+	}
+	if _, err := rs.compile(); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+func loadRule(e *dom.Element) (Rule, error) {
+	r := Rule{}
+	found := false
+	for a, name := range actionNames {
+		if name == e.Name.Local {
+			r.Action, found = a, true
+		}
+	}
+	if !found {
+		return r, errorAt(e, "unknown rule %s", e.Name.Local)
+	}
+	var err error
+	if r.Match, err = required(e, "match"); err != nil {
+		return r, err
+	}
+	name := ""
+	switch r.Action {
+	case Rename:
+		name, err = required(e, "to")
+	case Move:
+		r.Target, err = required(e, "under")
+	case SetAttr:
+		if name, err = required(e, "name"); err == nil {
+			r.Value, _ = attr(e, "value")
+		}
+	case RemoveAttr:
+		name, err = required(e, "name")
+	}
+	if err != nil || name == "" {
+		return r, err
+	}
+	if strings.IndexByte(name, ':') < 0 {
+		if !dom.IsNCName(name) {
+			return r, errorAt(e, "invalid name %q", name)
+		}
+		r.Name.Local = name
+		return r, nil
+	}
+	if r.Name, err = dom.ParseQName(name, e); err != nil {
+		return r, errorAt(e, "%v", err)
+	}
+	return r, nil
+}
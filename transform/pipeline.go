@@ -0,0 +1,230 @@
+package transform
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+// Step is one stage of a Pipeline.
+type Step struct {
+	// Name identifies the step in reports and errors.
+	Name string
+	// Do runs the step on the tree rooted at root, and says what it
+	// did with the Changed and Problem methods of r.  Returning an
+	// error, or panicking, stops the Pipeline.
+	Do func(root *dom.Element, r *StepReport) error
+}
+
+// Problem is something a Step found wrong with a tree, but could carry
+// on past, such as a validation error.
+type Problem struct {
+	// Step is the Name of the Step that found it.
+	Step string
+	// Element is the element it was found on.
+	Element *dom.Element
+	// Pos is where Element was found in its source document, if it was
+	// parsed.
+	Pos dom.Position
+	// Path is a /-separated path to Element from the top of its tree.
+	Path    string
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%v: %s: %s: %s", p.Pos, p.Step, p.Path, p.Message)
+}
+
+// StepReport is what one Step of a Pipeline did.
+type StepReport struct {
+	Name string
+	// Changes is how many changes the Step made to the tree.
+	Changes  int
+	Problems []Problem
+	Elapsed  time.Duration
+	// Err is the error that stopped the Step, if there was one.
+	Err error
+}
+
+// Changed records that the Step made n more changes.
+func (r *StepReport) Changed(n int) {
+	r.Changes += n
+}
+
+// Problem records a Problem with e.
+func (r *StepReport) Problem(e *dom.Element, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, Problem{
+		Step:    r.Name,
+		Element: e,
+		Pos:     e.Pos(),
+		Path:    e.Path(),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Report is what a run of a Pipeline did, step by step.  Steps that were
+// not run because an earlier one stopped the Pipeline are not in it.
+type Report struct {
+	Steps []*StepReport
+}
+
+// Changes returns how many changes the steps made in all.
+func (r *Report) Changes() int {
+	n := 0
+	for _, s := range r.Steps {
+		n += s.Changes
+	}
+	return n
+}
+
+// Problems returns the Problems the steps found, in the order they found
+// them.
+func (r *Report) Problems() []Problem {
+	res := []Problem{}
+	for _, s := range r.Steps {
+		res = append(res, s.Problems...)
+	}
+	return res
+}
+
+// Pipeline is a series of Steps run over trees one after the other, so
+// that the stages a kind of document goes through, such as
+//    p := NewPipeline(Normalize(), StripNamespaces(), RenameAll(from, to), validate)
+// are set up once and run on every document of that kind.  A Pipeline
+// is not changed by running it, so it can run on any number of trees at
+// once as long as its Steps can.
+type Pipeline struct {
+	Steps []Step
+	// Strict stops the Pipeline after the first Step that finds
+	// Problems, and makes Run return an error for them.
+	Strict bool
+	// Notify, if not nil, is called with the report of each Step as it
+	// finishes, for logging or progress reports.
+	Notify func(*StepReport)
+}
+
+// NewPipeline returns a Pipeline that runs steps in order.
+func NewPipeline(steps ...Step) *Pipeline {
+	return &Pipeline{Steps: steps}
+}
+
+// Then adds steps to the end of p, and returns p.
+func (p *Pipeline) Then(steps ...Step) *Pipeline {
+	p.Steps = append(p.Steps, steps...)
+	return p
+}
+
+// Run runs the Steps of p on the tree rooted at root, and returns what
+// they did.  The Report is returned even if a Step fails, in which case
+// the last StepReport in it is the failed Step's, and the error names
+// the Step.  Steps that panic fail with the value they panicked with, so
+// that one bad document does not take down a job working through many.
+// The tree is left as the failed Step left it.
+func (p *Pipeline) Run(root *dom.Element) (*Report, error) {
+	report := &Report{}
+	for _, s := range p.Steps {
+		r := &StepReport{Name: s.Name}
+		report.Steps = append(report.Steps, r)
+		start := time.Now()
+		r.Err = s.run(root, r)
+		r.Elapsed = time.Since(start)
+		if p.Notify != nil {
+			p.Notify(r)
+		}
+		if r.Err != nil {
+			return report, fmt.Errorf("transform: step %s: %w", s.Name, r.Err)
+		}
+		if p.Strict && len(r.Problems) > 0 {
+			return report, fmt.Errorf("transform: step %s: %d problems, the first of which is %s: %s",
+				s.Name, len(r.Problems), r.Problems[0].Path, r.Problems[0].Message)
+		}
+	}
+	return report, nil
+}
+
+func (s Step) run(root *dom.Element, r *StepReport) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			if e, ok := v.(error); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("%v", v)
+			}
+		}
+	}()
+	return s.Do(root, r)
+}
+
+// Normalize returns a Step that trims the whitespace off the ends of the
+// Content and attribute values of every element, and replaces each run
+// of whitespace inside them with a single space, as XPath's
+// normalize-space does.
+func Normalize() Step {
+	return Step{Name: "normalize", Do: func(root *dom.Element, r *StepReport) error {
+		for _, e := range root.All() {
+			if len(e.Content) > 0 {
+				if v := strings.Join(strings.Fields(string(e.Content)), " "); v != string(e.Content) {
+					e.Content = []byte(v)
+					r.Changed(1)
+				}
+			}
+			for i, a := range e.Attributes {
+				if v := strings.Join(strings.Fields(a.Value), " "); v != a.Value {
+					e.Attributes[i].Value = v
+					r.Changed(1)
+				}
+			}
+		}
+		return nil
+	}}
+}
+
+// StripNamespaces returns a Step that takes every element and attribute
+// out of its namespace and drops all namespace declarations, for
+// consumers that only care about local names.  Attributes in the xml
+// namespace, such as xml:lang, are kept as they are.  If that leaves an
+// element with two attributes of the same name, the last one is kept.
+func StripNamespaces() Step {
+	return Step{Name: "strip-namespaces", Do: func(root *dom.Element, r *StepReport) error {
+		for _, e := range root.All() {
+			if e.Name.Space != "" {
+				e.SetName("", e.Name.Local)
+				r.Changed(1)
+			}
+			attrs := e.Attributes
+			e.Attributes = nil
+			for _, a := range attrs {
+				switch {
+				case a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns"):
+					r.Changed(1)
+					continue
+				case a.Name.Space != "" && a.Name.Space != dom.NS_XML:
+					a.Name.Space = ""
+					r.Changed(1)
+				}
+				e.AddAttr(a)
+			}
+		}
+		return nil
+	}}
+}
+
+// RenameAll returns a Step that renames elements as dom.RenameAll does.
+func RenameAll(from, to xml.Name) Step {
+	return Step{Name: "rename", Do: func(root *dom.Element, r *StepReport) error {
+		r.Changed(dom.RenameAll(root, from, to))
+		return nil
+	}}
+}
+
+// ApplyRules returns a Step that applies rs.
+func ApplyRules(rs *Rules) Step {
+	return Step{Name: "rules", Do: func(root *dom.Element, r *StepReport) error {
+		n, err := rs.Apply(root)
+		r.Changed(n)
+		return err
+	}}
+}
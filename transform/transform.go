@@ -0,0 +1,262 @@
+// Package transform rewrites simplexml/dom trees with rules that are
+// data rather than code, for the fix-ups that documents from one source
+// or another keep needing.
+//
+// A Rule pairs an XPath expression that picks elements out of a tree
+// with an Action to take on each of them: rename it, move it under
+// another element, drop it, unwrap it, or set or remove one of its
+// attributes.  Rules apply them in order, either once or over and over
+// until the tree stops changing.  Rules can be written out as Go values,
+// or loaded from an XML document with Load, as in:
+//    <rules xmlns:old="urn:example:old" fixpoint="true">
+//        <rename match="//old:colour" to="color"/>
+//        <move match="//note" under="ancestor::chapter[1]/notes"/>
+//        <drop match="//draft"/>
+//    </rules>
+//
+// A Pipeline runs a series of Steps over trees, such as applying Rules,
+// stripping namespaces and validating, so that the stages a kind of
+// document goes through are declared once, with errors and what each
+// stage did reported the same way for all of them.
+//
+// For some basic usage examples, see transform_test.go
+package transform
+
+import (
+	"encoding/xml"
+	"fmt"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/xpath"
+)
+
+// Action is what a Rule does to the elements it matches.
+type Action int
+
+const (
+	// Rename gives the elements Name.
+	Rename Action = iota
+	// Move makes the elements the last children of the first element
+	// Target selects.
+	Move
+	// Drop removes the elements along with everything in them.
+	Drop
+	// Unwrap removes the elements but keeps what is in them: their
+	// children take their place in their parents, and their Content is
+	// added to the end of their parents'.
+	Unwrap
+	// SetAttr gives the elements an attribute called Name with Value,
+	// replacing any they have already.
+	SetAttr
+	// RemoveAttr removes the attribute called Name from the elements.
+	RemoveAttr
+)
+
+var actionNames = map[Action]string{
+	Rename:     "rename",
+	Move:       "move",
+	Drop:       "drop",
+	Unwrap:     "unwrap",
+	SetAttr:    "set-attr",
+	RemoveAttr: "remove-attr",
+}
+
+func (a Action) String() string {
+	if s, ok := actionNames[a]; ok {
+		return s
+	}
+	return fmt.Sprintf("Action(%d)", int(a))
+}
+
+// Rule is one fix-up.
+type Rule struct {
+	// Match is an XPath expression that selects the elements to act
+	// on.  It is evaluated with the top of the tree as the context
+	// node, so both "//note" and "chapter/note" work.
+	Match string
+	// Action is what to do to them.
+	Action Action
+	// Name is the new name for Rename, or the name of the attribute
+	// for SetAttr and RemoveAttr.
+	Name xml.Name
+	// Value is the value of the attribute for SetAttr.
+	Value string
+	// Target is an XPath expression that selects where Move puts each
+	// element.  It is evaluated with the element as the context node.
+	// Elements it selects nothing for are left where they are.
+	Target string
+}
+
+// Rules is a set of Rules applied to trees together.  Rules are not
+// changed by applying them, so they can be applied to any number of
+// trees at once.
+type Rules struct {
+	Rules []Rule
+	// Env binds the namespace prefixes, variables and functions the
+	// expressions in the Rules use.  It may be nil.
+	Env *xpath.Env
+	// Fixpoint makes Apply go through the Rules again and again until
+	// a pass changes nothing, so that rules can act on what other
+	// rules, or they themselves, have done.  Otherwise it goes through
+	// them once.
+	Fixpoint bool
+	// MaxPasses bounds how many passes Fixpoint allows, for rules that
+	// would otherwise undo each other forever.  It defaults to 100.
+	MaxPasses int
+}
+
+// compiled is a Rule with its expressions compiled.
+type compiled struct {
+	*Rule
+	match, target *xpath.Expr
+}
+
+func (rs *Rules) compile() ([]compiled, error) {
+	res := make([]compiled, len(rs.Rules))
+	for i := range rs.Rules {
+		r := &rs.Rules[i]
+		if _, ok := actionNames[r.Action]; !ok {
+			return nil, fmt.Errorf("transform: rule %d: unknown action %v", i+1, r.Action)
+		}
+		match, err := xpath.Compile(r.Match)
+		if err != nil {
+			return nil, fmt.Errorf("transform: rule %d: %v", i+1, err)
+		}
+		res[i] = compiled{Rule: r, match: match}
+		if r.Action == Move {
+			if res[i].target, err = xpath.Compile(r.Target); err != nil {
+				return nil, fmt.Errorf("transform: rule %d: %v", i+1, err)
+			}
+		}
+	}
+	return res, nil
+}
+
+// Apply applies the Rules to the tree rooted at root, in order, and
+// returns how many changes they made.  Each rule acts on what the rules
+// before it have left, and on the elements it matches in document order.
+// root itself is never moved, dropped or unwrapped, and neither is
+// anything a rule has taken out of the tree already, such as the
+// children of an element it dropped.  Rules that change nothing, such as
+// renaming an element to the name it already has, are not counted, which
+// is how Fixpoint knows when to stop.
+func (rs *Rules) Apply(root *dom.Element) (int, error) {
+	rules, err := rs.compile()
+	if err != nil {
+		return 0, err
+	}
+	max := rs.MaxPasses
+	if max <= 0 {
+		max = 100
+	}
+	total := 0
+	for pass := 1; ; pass++ {
+		n := 0
+		for i := range rules {
+			changed, err := rules[i].apply(root, rs.Env)
+			if err != nil {
+				return total + n, fmt.Errorf("transform: rule %d: %v", i+1, err)
+			}
+			n += changed
+		}
+		total += n
+		if !rs.Fixpoint || n == 0 {
+			return total, nil
+		}
+		if pass == max {
+			return total, fmt.Errorf("transform: rules still change the tree after %d passes", max)
+		}
+	}
+}
+
+// inTree reports whether e is root or below it.
+func inTree(e, root *dom.Element) bool {
+	for ; e != nil; e = e.Parent() {
+		if e == root {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *compiled) apply(root *dom.Element, env *xpath.Env) (int, error) {
+	matched, err := r.match.Select(root, env)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, e := range matched {
+		if !inTree(e, root) {
+			continue
+		}
+		changed, err := r.do(e, root, env)
+		if err != nil {
+			return n, fmt.Errorf("%s: %v", e.Path(), err)
+		}
+		if changed {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// do takes the Action of r on e, and reports whether that changed
+// anything.
+func (r *compiled) do(e, root *dom.Element, env *xpath.Env) (bool, error) {
+	parent := e.Parent()
+	switch r.Action {
+	case Rename:
+		if e.Name == r.Name {
+			return false, nil
+		}
+		e.SetName(r.Name.Space, r.Name.Local)
+	case SetAttr:
+		for _, a := range e.Attributes {
+			if a.Name == r.Name && a.Value == r.Value {
+				return false, nil
+			}
+		}
+		e.AddAttr(xml.Attr{Name: r.Name, Value: r.Value})
+	case RemoveAttr:
+		kept := e.Attributes[:0]
+		for _, a := range e.Attributes {
+			if a.Name != r.Name {
+				kept = append(kept, a)
+			}
+		}
+		if len(kept) == len(e.Attributes) {
+			return false, nil
+		}
+		e.Attributes = kept
+	case Drop:
+		if e == root {
+			return false, nil
+		}
+		parent.RemoveChild(e)
+	case Unwrap:
+		if e == root {
+			return false, nil
+		}
+		parent.ReplaceChild(e, e.Children()...)
+		parent.Content = append(parent.Content, e.Content...)
+	case Move:
+		if e == root {
+			return false, nil
+		}
+		targets, err := r.target.Select(e, env)
+		if err != nil {
+			return false, err
+		}
+		if len(targets) == 0 || targets[0] == parent {
+			return false, nil
+		}
+		if inTree(targets[0], e) {
+			return false, fmt.Errorf("cannot move an element under itself")
+		}
+		if !inTree(targets[0], root) {
+			return false, fmt.Errorf("cannot move an element out of the tree")
+		}
+		targets[0].AddChild(e)
+	}
+	return true, nil
+}
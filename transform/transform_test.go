@@ -0,0 +1,147 @@
+package transform
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+func parse(t *testing.T, src string) *dom.Element {
+	doc, err := dom.Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("Cannot parse %s: %v", src, err)
+	}
+	return doc.Root()
+}
+
+func str(t *testing.T, e *dom.Element) string {
+	res, err := e.StringWith()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+const book = `<book><chapter><title>One</title><colour/><notes/><p>a<note>n1</note></p></chapter>` +
+	`<chapter><notes/><note>n2</note><draft>x</draft><span>s<b/></span></chapter></book>`
+
+func TestRules(t *testing.T) {
+	rs := &Rules{Rules: []Rule{
+		{Match: "//colour", Action: Rename, Name: xml.Name{Local: "color"}},
+		{Match: "//note", Action: Move, Target: "ancestor::chapter[1]/notes"},
+		{Match: "//draft", Action: Drop},
+		{Match: "//span", Action: Unwrap},
+		{Match: "chapter", Action: SetAttr, Name: xml.Name{Local: "checked"}, Value: "yes"},
+	}}
+	root := parse(t, book)
+	n, err := rs.Apply(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<book><chapter checked="yes"><title>One</title><color/><notes><note>n1</note></notes><p>a</p></chapter>` +
+		`<chapter checked="yes">s<notes><note>n2</note></notes><b/></chapter></book>`
+	if got := str(t, root); got != want || n != 7 {
+		t.Errorf("Got %d changes:\n%s\nwant 7:\n%s", n, got, want)
+	}
+	if n, err = rs.Apply(root); err != nil || n != 0 {
+		t.Errorf("Applying again made %d changes, %v", n, err)
+	}
+	if _, err := (&Rules{Rules: []Rule{{Match: "//p", Action: Move, Target: "."}}}).Apply(root); err == nil {
+		t.Errorf("Moving an element under itself should fail")
+	}
+}
+
+func TestFixpoint(t *testing.T) {
+	// Each pass unwraps one level of nested wrappers.
+	rs := &Rules{Rules: []Rule{{Match: "//wrap[not(wrap)]", Action: Unwrap}}}
+	root := parse(t, `<a><wrap><wrap><wrap><b/></wrap></wrap></wrap></a>`)
+	if n, err := rs.Apply(root); err != nil || n != 1 {
+		t.Errorf("One pass made %d changes, %v", n, err)
+	}
+	rs.Fixpoint = true
+	if n, err := rs.Apply(root); err != nil || n != 2 {
+		t.Errorf("Fixpoint made %d changes, %v", n, err)
+	}
+	if got := str(t, root); got != `<a><b/></a>` {
+		t.Errorf("Unexpected result %s", got)
+	}
+	flip := &Rules{Fixpoint: true, MaxPasses: 5, Rules: []Rule{
+		{Match: "//x", Action: Rename, Name: xml.Name{Local: "y"}},
+		{Match: "//y", Action: Rename, Name: xml.Name{Local: "x"}},
+	}}
+	if _, err := flip.Apply(parse(t, `<a><x/></a>`)); err == nil {
+		t.Errorf("Rules that never settle should fail")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	rs, err := Load(parse(t, `<rules xmlns:o="urn:old" fixpoint="true">`+
+		`<rename match="//o:colour" to="color"/>`+
+		`<remove-attr match="//*" name="o:style"/>`+
+		`<drop match="//o:draft"/></rules>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := parse(t, `<doc xmlns:x="urn:old"><x:colour x:style="s" id="1"/><x:draft/></doc>`)
+	if n, err := rs.Apply(root); err != nil || n != 3 {
+		t.Errorf("Got %d changes, %v", n, err)
+	}
+	if got := str(t, root); got != `<doc xmlns:x="urn:old"><color id="1"/></doc>` {
+		t.Errorf("Unexpected result %s", got)
+	}
+	for _, src := range []string{
+		`<rules><shuffle match="//a"/></rules>`,
+		`<rules><drop/></rules>`,
+		`<rules><drop match="//["/></rules>`,
+		`<rules><rename match="//a" to="p:b"/></rules>`,
+	} {
+		if _, err := Load(parse(t, src)); err == nil {
+			t.Errorf("Loading %s should fail", src)
+		}
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	check := Step{Name: "check", Do: func(root *dom.Element, r *StepReport) error {
+		for _, e := range root.All() {
+			if e.Name.Local == "item" && len(e.Content) == 0 {
+				r.Problem(e, "item is empty")
+			}
+		}
+		return nil
+	}}
+	notified := []string{}
+	p := NewPipeline(Normalize(), StripNamespaces()).
+		Then(RenameAll(xml.Name{Local: "entry"}, xml.Name{Local: "item"}), check)
+	p.Notify = func(r *StepReport) { notified = append(notified, r.Name) }
+	root := parse(t, `<list xmlns="urn:list" xmlns:a="urn:a" a:kind=" x  y "><entry>  one
+		two </entry><entry/></list>`)
+	report, err := p.Run(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := str(t, root); got != `<list kind="x y"><item>one two</item><item/></list>` {
+		t.Errorf("Unexpected result %s", got)
+	}
+	if got := strings.Join(notified, ","); got != "normalize,strip-namespaces,rename,check" {
+		t.Errorf("Notified of %s", got)
+	}
+	problems := report.Problems()
+	if len(problems) != 1 || problems[0].Path != "/list/item[2]" || problems[0].Step != "check" {
+		t.Errorf("Unexpected problems %v", problems)
+	}
+	if report.Steps[0].Changes != 2 || report.Steps[2].Changes != 2 {
+		t.Errorf("Unexpected changes %d, %d", report.Steps[0].Changes, report.Steps[2].Changes)
+	}
+	p.Strict = true
+	if _, err := p.Run(parse(t, `<list><entry/></list>`)); err == nil {
+		t.Errorf("Strict pipeline should fail on problems")
+	}
+	boom := NewPipeline(Step{Name: "boom", Do: func(*dom.Element, *StepReport) error { panic("boom") }}, check)
+	report, err = boom.Run(parse(t, `<list/>`))
+	if err == nil || !strings.Contains(err.Error(), "boom") || len(report.Steps) != 1 {
+		t.Errorf("Unexpected result of a panicking step: %v, %d steps", err, len(report.Steps))
+	}
+}
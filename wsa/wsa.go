@@ -0,0 +1,231 @@
+// Package wsa builds and reads WS-Addressing message headers for SOAP
+// envelopes made with the soap package.
+//
+// Both WS-Addressing 1.0 and the 2004/08 member submission (which is what
+// WS-Management uses) are supported; which one is produced is chosen by
+// Headers.Namespace.
+//
+// For some basic usage examples, see wsa_test.go
+package wsa
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/soap"
+)
+
+// The WS-Addressing namespaces, and their anonymous endpoint addresses.
+const (
+	NS_WSA10      = "http://www.w3.org/2005/08/addressing"
+	NS_WSA2004    = "http://schemas.xmlsoap.org/ws/2004/08/addressing"
+	Anonymous10   = NS_WSA10 + "/anonymous"
+	Anonymous2004 = NS_WSA2004 + "/role/anonymous"
+)
+
+// EndpointReference is the address of an endpoint, along with the
+// reference parameters that have to be sent as headers to it.
+type EndpointReference struct {
+	Address             string
+	ReferenceParameters []*dom.Element
+}
+
+// Headers are the WS-Addressing headers of a message.  Empty fields are
+// not written.
+type Headers struct {
+	// Namespace is NS_WSA10 or NS_WSA2004.  It defaults to NS_WSA10.
+	Namespace string
+	To        string
+	Action    string
+	MessageID string
+	From      *EndpointReference
+	ReplyTo   *EndpointReference
+	FaultTo   *EndpointReference
+	RelatesTo string
+	// RelationshipType is the type of RelatesTo.  It can be left empty
+	// for a reply.
+	RelationshipType string
+	// ReferenceParameters are the reference parameters of To, which are
+	// sent as header blocks of their own.
+	ReferenceParameters []*dom.Element
+	// MustUnderstand marks To and Action with mustUnderstand, as
+	// WS-Management requires.
+	MustUnderstand bool
+}
+
+// NewMessageID returns a new random message id, as a urn:uuid: URI.
+func NewMessageID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func (h *Headers) ns() string {
+	if h.Namespace == "" {
+		return NS_WSA10
+	}
+	return h.Namespace
+}
+
+// Anonymous returns the anonymous address for the namespace of h.
+func (h *Headers) Anonymous() string {
+	if h.ns() == NS_WSA2004 {
+		return Anonymous2004
+	}
+	return Anonymous10
+}
+
+func (h *Headers) epr(name string, epr *EndpointReference) *dom.Element {
+	ns := h.ns()
+	res := dom.Elem(name, ns).AddChild(dom.ElemC("Address", ns, epr.Address))
+	if len(epr.ReferenceParameters) > 0 {
+		params := dom.Elem("ReferenceParameters", ns)
+		for _, p := range epr.ReferenceParameters {
+			params.AddChild(p.Export().Import())
+		}
+		res.AddChild(params)
+	}
+	return res
+}
+
+// Elements returns the header blocks for h.  env is used to mark blocks
+// with mustUnderstand.
+func (h *Headers) Elements(env *soap.Envelope) []*dom.Element {
+	ns := h.ns()
+	res := []*dom.Element{}
+	simple := func(name, value string, must bool) {
+		if value == "" {
+			return
+		}
+		e := dom.ElemC(name, ns, value)
+		if must {
+			env.SetMustUnderstand(e)
+		}
+		res = append(res, e)
+	}
+	simple("To", h.To, h.MustUnderstand)
+	simple("Action", h.Action, h.MustUnderstand)
+	simple("MessageID", h.MessageID, false)
+	if h.RelatesTo != "" {
+		e := dom.ElemC("RelatesTo", ns, h.RelatesTo)
+		if h.RelationshipType != "" {
+			e.Attr("RelationshipType", "", h.RelationshipType)
+		}
+		res = append(res, e)
+	}
+	if h.From != nil {
+		res = append(res, h.epr("From", h.From))
+	}
+	if h.ReplyTo != nil {
+		res = append(res, h.epr("ReplyTo", h.ReplyTo))
+	}
+	if h.FaultTo != nil {
+		res = append(res, h.epr("FaultTo", h.FaultTo))
+	}
+	for _, p := range h.ReferenceParameters {
+		p = p.Export().Import()
+		if ns == NS_WSA10 {
+			p.Attr("IsReferenceParameter", ns, "true")
+		}
+		res = append(res, p)
+	}
+	return res
+}
+
+// AddTo adds the headers in h to env.  The return value is env.
+func (h *Headers) AddTo(env *soap.Envelope) *soap.Envelope {
+	return env.AddHeader(h.Elements(env)...)
+}
+
+func text(e *dom.Element) string {
+	return strings.TrimSpace(string(e.Content))
+}
+
+func readEPR(e *dom.Element, ns string) *EndpointReference {
+	res := &EndpointReference{}
+	for _, c := range e.Children() {
+		if c.Name.Space != ns {
+			continue
+		}
+		switch c.Name.Local {
+		case "Address":
+			res.Address = text(c)
+		case "ReferenceParameters", "ReferenceProperties":
+			res.ReferenceParameters = append(res.ReferenceParameters, c.Children()...)
+		}
+	}
+	return res
+}
+
+// Read returns the WS-Addressing headers of env.  The namespace is taken
+// from the first addressing header found; if there are none, the result
+// has only its Namespace set to the default.  Reference parameters are
+// only recognized for WS-Addressing 1.0, where they are marked.
+func Read(env *soap.Envelope) *Headers {
+	h := &Headers{}
+	blocks := env.Headers()
+	for _, b := range blocks {
+		if b.Name.Space == NS_WSA10 || b.Name.Space == NS_WSA2004 {
+			h.Namespace = b.Name.Space
+			break
+		}
+	}
+	ns := h.ns()
+	h.Namespace = ns
+	for _, b := range blocks {
+		if b.Name.Space != ns {
+			if isRef := b.GetAttr("IsReferenceParameter", NS_WSA10, "*"); ns == NS_WSA10 && len(isRef) > 0 &&
+				(isRef[0].Value == "true" || isRef[0].Value == "1") {
+				h.ReferenceParameters = append(h.ReferenceParameters, b)
+			}
+			continue
+		}
+		switch b.Name.Local {
+		case "To":
+			h.To = text(b)
+			h.MustUnderstand = h.MustUnderstand || env.MustUnderstand(b)
+		case "Action":
+			h.Action = text(b)
+			h.MustUnderstand = h.MustUnderstand || env.MustUnderstand(b)
+		case "MessageID":
+			h.MessageID = text(b)
+		case "RelatesTo":
+			h.RelatesTo = text(b)
+			if a := b.GetAttr("RelationshipType", "", "*"); len(a) > 0 {
+				h.RelationshipType = a[0].Value
+			}
+		case "From":
+			h.From = readEPR(b, ns)
+		case "ReplyTo":
+			h.ReplyTo = readEPR(b, ns)
+		case "FaultTo":
+			h.FaultTo = readEPR(b, ns)
+		}
+	}
+	return h
+}
+
+// Reply returns the headers for a reply to a message with headers h: it
+// is sent to the ReplyTo endpoint (or the anonymous one, if there is no
+// ReplyTo), relates to the MessageID of h and has a new MessageID.
+func (h *Headers) Reply(action string) *Headers {
+	res := &Headers{
+		Namespace:      h.ns(),
+		To:             h.Anonymous(),
+		Action:         action,
+		MessageID:      NewMessageID(),
+		RelatesTo:      h.MessageID,
+		MustUnderstand: h.MustUnderstand,
+	}
+	if h.ReplyTo != nil && h.ReplyTo.Address != "" {
+		res.To = h.ReplyTo.Address
+		res.ReferenceParameters = h.ReplyTo.ReferenceParameters
+	}
+	return res
+}
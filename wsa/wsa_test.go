@@ -0,0 +1,67 @@
+package wsa
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/soap"
+)
+
+func TestRoundTrip(t *testing.T) {
+	for _, ns := range []string{NS_WSA10, NS_WSA2004} {
+		h := &Headers{
+			Namespace: ns,
+			To:        "http://host/wsman",
+			Action:    "http://schemas.xmlsoap.org/ws/2004/09/transfer/Get",
+			MessageID: NewMessageID(),
+			ReplyTo: &EndpointReference{
+				Address:             "http://client/replies",
+				ReferenceParameters: []*dom.Element{dom.ElemC("Session", "urn:app", "42")},
+			},
+			MustUnderstand: true,
+		}
+		env := h.AddTo(soap.NewEnvelope(soap.SOAP12))
+		back, err := soap.Parse(bytes.NewReader(env.Document().Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := Read(back)
+		if got.Namespace != ns || got.To != h.To || got.Action != h.Action || got.MessageID != h.MessageID ||
+			!got.MustUnderstand || got.ReplyTo == nil || got.ReplyTo.Address != h.ReplyTo.Address ||
+			len(got.ReplyTo.ReferenceParameters) != 1 {
+			t.Errorf("%s: unexpected headers %+v", ns, got)
+		}
+		reply := got.Reply("urn:GetResponse")
+		if reply.To != "http://client/replies" || reply.RelatesTo != h.MessageID || reply.MessageID == h.MessageID {
+			t.Errorf("%s: unexpected reply headers %+v", ns, reply)
+		}
+		renv := reply.AddTo(soap.NewEnvelope(soap.SOAP12))
+		rback := Read(renv)
+		if ns == NS_WSA10 && (len(rback.ReferenceParameters) != 1 || string(rback.ReferenceParameters[0].Content) != "42") {
+			t.Errorf("%s: expected the reference parameter to be echoed, got %+v", ns, rback)
+		}
+		if len(renv.Headers()) != 5 {
+			t.Errorf("%s: expected 5 reply headers, got %v", ns, renv.Headers())
+		}
+	}
+}
+
+func TestAnonymousReply(t *testing.T) {
+	h := &Headers{Namespace: NS_WSA2004, MessageID: "urn:uuid:1"}
+	if r := h.Reply("urn:a"); r.To != Anonymous2004 || r.RelatesTo != "urn:uuid:1" {
+		t.Errorf("Unexpected reply %+v", r)
+	}
+	if h := Read(soap.NewEnvelope(soap.SOAP11)); h.Namespace != NS_WSA10 || h.To != "" {
+		t.Errorf("Unexpected headers from an empty envelope %+v", h)
+	}
+}
+
+func TestNewMessageID(t *testing.T) {
+	re := regexp.MustCompile(`^urn:uuid:[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	a, b := NewMessageID(), NewMessageID()
+	if !re.MatchString(a) || a == b {
+		t.Errorf("Unexpected message ids %s, %s", a, b)
+	}
+}
@@ -0,0 +1,309 @@
+// Package xinclude implements XInclude 1.0 over simplexml/dom trees.
+//
+// Process replaces every xi:include element in a tree with what it points
+// to: the root element of another XML document, or the text of any
+// resource with parse="text".  If a resource cannot be included and the
+// xi:include has an xi:fallback child, the contents of the fallback are
+// used instead.  Included documents are processed in turn, and including
+// a document from inside itself is an error.
+//
+// Resources are read through a Resolver, so documents can come from
+// files, embedded data, or anywhere else, including the resolvers dom
+// provides.  The xpointer attribute can be
+// a bare ID or use the element() scheme, as dom.Element.XPointer reads
+// it.  dom keeps a single run of
+// Content per element, so included text is appended to the Content of the
+// parent of the xi:include.
+//
+// For some basic usage examples, see xinclude_test.go
+package xinclude
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+// NS_XINCLUDE is the XInclude namespace.
+const NS_XINCLUDE = "http://www.w3.org/2001/XInclude"
+
+// Resolver opens the resource at location, an href resolved against the
+// base of the including document.  Its Resolve method makes it a
+// dom.Resolver, and the Resolve method of any dom.Resolver can be used
+// as one, as in:
+//
+//	p := &xinclude.Processor{Resolver: dom.DirResolver(dir).Resolve}
+type Resolver func(location string) (io.ReadCloser, error)
+
+// Resolve calls r.
+func (r Resolver) Resolve(location string) (io.ReadCloser, error) {
+	return r(location)
+}
+
+// FS returns a Resolver that reads relative locations from fsys.
+// Locations with a scheme, absolute paths and paths leading out of fsys
+// are refused.
+func FS(fsys fs.FS) Resolver {
+	return func(location string) (io.ReadCloser, error) {
+		u, err := url.Parse(location)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "" || u.Host != "" || !fs.ValidPath(u.Path) {
+			return nil, &fs.PathError{Op: "open", Path: location, Err: fs.ErrInvalid}
+		}
+		return fsys.Open(u.Path)
+	}
+}
+
+// Processor resolves xi:include elements.
+type Processor struct {
+	// Resolver reads included resources.
+	Resolver Resolver
+	// MaxDepth limits how deeply inclusions can nest.  It defaults to 32.
+	MaxDepth int
+}
+
+func (p *Processor) maxDepth() int {
+	if p.MaxDepth <= 0 {
+		return 32
+	}
+	return p.MaxDepth
+}
+
+// Parse parses a document from r and processes it.  base is the location
+// of the document, which relative hrefs are resolved against.
+func Parse(r io.Reader, base string, resolver Resolver) (*dom.Document, error) {
+	doc, err := dom.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := (&Processor{Resolver: resolver}).Process(doc, base); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// Process processes the xi:include elements in doc, whose location is
+// base.
+func (p *Processor) Process(doc *dom.Document, base string) error {
+	root := doc.Root()
+	if root == nil {
+		return nil
+	}
+	if isInclude(root) {
+		return errors.New("xinclude: the root element cannot be an include")
+	}
+	return p.process(root, base, []string{base})
+}
+
+// ProcessElement processes the xi:include elements below e, which is
+// part of a document at base.
+func (p *Processor) ProcessElement(e *dom.Element, base string) error {
+	return p.process(e, base, []string{base})
+}
+
+func isInclude(e *dom.Element) bool {
+	return e.Name.Space == NS_XINCLUDE && e.Name.Local == "include"
+}
+
+func attr(e *dom.Element, name, space string) (string, bool) {
+	if a := e.GetAttr(name, space, "*"); len(a) > 0 {
+		return a[0].Value, true
+	}
+	return "", false
+}
+
+// resolve resolves ref against base.
+func resolve(base, ref string) (string, error) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("xinclude: bad base %q: %v", base, err)
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("xinclude: bad href %q: %v", ref, err)
+	}
+	if b.Scheme == "" && b.Host == "" && r.Scheme == "" && r.Host == "" && !strings.HasPrefix(r.Path, "/") {
+		// url would make the result absolute, which would stop relative
+		// locations from working with FS.
+		return path.Join(path.Dir(b.Path), r.Path), nil
+	}
+	return b.ResolveReference(r).String(), nil
+}
+
+// baseOf returns the base URI in effect at e, taking xml:base attributes
+// into account.
+func baseOf(e *dom.Element, base string) (string, error) {
+	bases := []string{}
+	for n := e; n != nil; n = n.Parent() {
+		if b, ok := attr(n, "base", dom.NS_XML); ok {
+			bases = append(bases, b)
+		}
+	}
+	var err error
+	for i := len(bases) - 1; i >= 0 && err == nil; i-- {
+		base, err = resolve(base, bases[i])
+	}
+	return base, err
+}
+
+// process processes the includes in the subtree below e.  stack holds
+// the locations being included, outermost first.
+func (p *Processor) process(e *dom.Element, base string, stack []string) error {
+	for _, c := range e.Children() {
+		var err error
+		switch {
+		case isInclude(c):
+			err = p.include(c, base, stack)
+		case c.Name.Space == NS_XINCLUDE && c.Name.Local == "fallback":
+			err = fmt.Errorf("xinclude: %s: fallback outside of an include", c.Path())
+		default:
+			err = p.process(c, base, stack)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Processor) include(inc *dom.Element, base string, stack []string) error {
+	res, text, err := p.load(inc, base, stack)
+	if err == nil {
+		replace(inc, res, text)
+		return nil
+	}
+	var fallback *dom.Element
+	for _, c := range inc.Children() {
+		if c.Name.Space == NS_XINCLUDE && c.Name.Local == "fallback" {
+			if fallback != nil {
+				return fmt.Errorf("xinclude: %s: more than one fallback", inc.Path())
+			}
+			fallback = c
+		}
+	}
+	if fallback == nil {
+		return err
+	}
+	if err := p.process(fallback, base, stack); err != nil {
+		return err
+	}
+	replace(inc, fallback.Children(), string(fallback.Content))
+	return nil
+}
+
+// replace puts elements and text in place of inc.
+func replace(inc *dom.Element, elements []*dom.Element, text string) {
+	parent := inc.Parent()
+	if text != "" {
+		parent.Content = append(parent.Content, text...)
+	}
+	parent.ReplaceChild(inc, elements...)
+}
+
+// load returns what inc refers to.
+func (p *Processor) load(inc *dom.Element, base string, stack []string) ([]*dom.Element, string, error) {
+	href, _ := attr(inc, "href", "")
+	xpointer, hasXPointer := attr(inc, "xpointer", "")
+	mode, _ := attr(inc, "parse", "")
+	switch {
+	case strings.Contains(href, "#"):
+		return nil, "", fmt.Errorf("xinclude: %s: href %q has a fragment", inc.Path(), href)
+	case mode == "text" && hasXPointer:
+		return nil, "", fmt.Errorf("xinclude: %s: xpointer cannot be used with parse=\"text\"", inc.Path())
+	case mode != "" && mode != "xml" && mode != "text":
+		return nil, "", fmt.Errorf("xinclude: %s: unknown parse mode %q", inc.Path(), mode)
+	case href == "" && !hasXPointer:
+		return nil, "", fmt.Errorf("xinclude: %s: needs an href or an xpointer", inc.Path())
+	}
+	base, err := baseOf(inc, base)
+	if err != nil {
+		return nil, "", err
+	}
+	if href == "" {
+		// The document the include is in.
+		top := inc
+		for top.Parent() != nil {
+			top = top.Parent()
+		}
+		e, err := top.XPointer(xpointer)
+		if err != nil {
+			return nil, "", fmt.Errorf("xinclude: %s: %v", inc.Path(), err)
+		}
+		for n := inc; n != nil; n = n.Parent() {
+			if n == e {
+				return nil, "", fmt.Errorf("xinclude: %s: includes an element containing itself", inc.Path())
+			}
+		}
+		res := e.Export().Import()
+		if err := p.process(res, base, stack); err != nil {
+			return nil, "", err
+		}
+		return []*dom.Element{res}, "", nil
+	}
+	location, err := resolve(base, href)
+	if err != nil {
+		return nil, "", err
+	}
+	if mode != "text" {
+		for _, l := range stack {
+			if l == location {
+				return nil, "", fmt.Errorf("xinclude: %s: %s includes itself", inc.Path(), location)
+			}
+		}
+		if len(stack) > p.maxDepth() {
+			return nil, "", fmt.Errorf("xinclude: %s: includes nested more than %d deep", inc.Path(), p.maxDepth())
+		}
+	}
+	if p.Resolver == nil {
+		return nil, "", errors.New("xinclude: no Resolver")
+	}
+	r, err := p.Resolver(location)
+	if err != nil {
+		return nil, "", fmt.Errorf("xinclude: %s: %v", inc.Path(), err)
+	}
+	defer r.Close()
+	if mode == "text" {
+		var buf bytes.Buffer
+		if _, err := io.Copy(&buf, r); err != nil {
+			return nil, "", fmt.Errorf("xinclude: %s: %v", inc.Path(), err)
+		}
+		return nil, buf.String(), nil
+	}
+	doc, err := dom.Parse(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("xinclude: %s: %v", location, err)
+	}
+	res := doc.Root()
+	if res == nil {
+		return nil, "", fmt.Errorf("xinclude: %s has no root element", location)
+	}
+	if hasXPointer {
+		if res, err = res.XPointer(xpointer); err != nil {
+			return nil, "", fmt.Errorf("xinclude: %s: %v", location, err)
+		}
+		if res.Parent() != nil {
+			res.Parent().RemoveChild(res)
+		}
+	}
+	stack = append(stack[:len(stack):len(stack)], location)
+	if isInclude(res) {
+		return nil, "", fmt.Errorf("xinclude: %s: the root element cannot be an include", location)
+	}
+	if err := p.process(res, location, stack); err != nil {
+		return nil, "", err
+	}
+	// Keep relative references in the included element working.
+	if _, ok := attr(res, "base", dom.NS_XML); !ok && path.Dir(location) != path.Dir(base) {
+		res.Attr("base", dom.NS_XML, href)
+	}
+	return []*dom.Element{res}, "", nil
+}
@@ -0,0 +1,72 @@
+package xinclude
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/domtest"
+)
+
+var files = fstest.MapFS{
+	"book/book.xml": {Data: []byte(`<book xmlns:xi="http://www.w3.org/2001/XInclude">
+  <title>Guide</title>
+  <xi:include href="chapters/one.xml"/>
+  <xi:include href="chapters/missing.xml"><xi:fallback><chapter>TBD</chapter></xi:fallback></xi:include>
+  <xi:include href="common.xml" xpointer="legal"/>
+  <xi:include href="common.xml" xpointer="element(/1/2)"/>
+  <xi:include xpointer="element(/1/1)"/>
+</book>`)},
+	"book/chapters/one.xml": {Data: []byte(`<chapter xmlns:xi="http://www.w3.org/2001/XInclude">
+  <xi:include href="intro.txt" parse="text"/>
+  <xi:include href="../common.xml" xpointer="thanks"/>
+</chapter>`)},
+	"book/chapters/intro.txt": {Data: []byte("Once upon a time")},
+	"book/common.xml":         {Data: []byte(`<common><legal id="legal">Do not copy</legal><thanks xml:id="thanks">Thanks</thanks></common>`)},
+	"loop/a.xml":              {Data: []byte(`<a xmlns:xi="http://www.w3.org/2001/XInclude"><xi:include href="b.xml"/></a>`)},
+	"loop/b.xml":              {Data: []byte(`<b xmlns:xi="http://www.w3.org/2001/XInclude"><xi:include href="a.xml"/></b>`)},
+}
+
+func parse(t *testing.T, name string) (*dom.Document, error) {
+	f, err := files.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	return Parse(f, name, FS(files))
+}
+
+func TestInclude(t *testing.T) {
+	doc, err := parse(t, "book/book.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	domtest.EqualXML(t, `<book>
+  <title>Guide</title>
+  <chapter xml:base="chapters/one.xml">Once upon a time<thanks xml:id="thanks" xml:base="../common.xml">Thanks</thanks></chapter>
+  <chapter>TBD</chapter>
+  <legal id="legal">Do not copy</legal>
+  <thanks xml:id="thanks">Thanks</thanks>
+  <title>Guide</title>
+</book>`, doc)
+}
+
+func TestErrors(t *testing.T) {
+	if _, err := parse(t, "loop/a.xml"); err == nil || !strings.Contains(err.Error(), "includes itself") {
+		t.Errorf("Expected a loop to be found, got %v", err)
+	}
+	for _, src := range []string{
+		`<a xmlns:xi="http://www.w3.org/2001/XInclude"><xi:include href="nope.xml"/></a>`,
+		`<a xmlns:xi="http://www.w3.org/2001/XInclude"><xi:include href="../../etc/passwd" parse="text"/></a>`,
+		`<a xmlns:xi="http://www.w3.org/2001/XInclude"><xi:include href="x.xml#frag"/></a>`,
+		`<a xmlns:xi="http://www.w3.org/2001/XInclude"><xi:include xpointer="missing"/></a>`,
+		`<a xmlns:xi="http://www.w3.org/2001/XInclude" id="a"><xi:include xpointer="a"/></a>`,
+		`<a xmlns:xi="http://www.w3.org/2001/XInclude"><xi:fallback/></a>`,
+		`<xi:include xmlns:xi="http://www.w3.org/2001/XInclude" href="book/common.xml"/>`,
+	} {
+		if _, err := Parse(strings.NewReader(src), "", FS(files)); err == nil {
+			t.Errorf("Expected %s to fail", src)
+		}
+	}
+}
@@ -0,0 +1,478 @@
+// Package xmlenc encrypts and decrypts parts of simplexml/dom trees with
+// XML Encryption.
+//
+// An Encrypter replaces an element, or the content of one, with an
+// EncryptedData element.  The data is encrypted with AES in GCM or CBC
+// mode, under a fresh key that is itself encrypted to the recipient's RSA
+// key with OAEP and carried in an EncryptedKey, or under a key shared in
+// advance.  A Decrypter reverses that, putting the decrypted elements
+// back where the EncryptedData was.
+//
+// CBC mode is only there for talking to older software: it does not
+// detect tampering, so prefer GCM, or sign the ciphertext.
+//
+// For some basic usage examples, see xmlenc_test.go
+package xmlenc
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	// Register the hashes used with OAEP.
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/dsig"
+)
+
+// Namespaces of XML Encryption 1.0 and 1.1.
+const (
+	NS_XMLENC   = "http://www.w3.org/2001/04/xmlenc#"
+	NS_XMLENC11 = "http://www.w3.org/2009/xmlenc11#"
+)
+
+// Types of EncryptedData.
+const (
+	TypeElement = NS_XMLENC + "Element"
+	TypeContent = NS_XMLENC + "Content"
+)
+
+// Algorithm identifiers.
+const (
+	AES128CBC = NS_XMLENC + "aes128-cbc"
+	AES256CBC = NS_XMLENC + "aes256-cbc"
+	AES128GCM = NS_XMLENC11 + "aes128-gcm"
+	AES256GCM = NS_XMLENC11 + "aes256-gcm"
+
+	// RSAOAEPMGF1P is RSA-OAEP with SHA-1.
+	RSAOAEPMGF1P = NS_XMLENC + "rsa-oaep-mgf1p"
+	// RSAOAEP is RSA-OAEP with the digest and mask generation function
+	// given in the EncryptionMethod.  Encrypter uses SHA-256 for both.
+	RSAOAEP = NS_XMLENC11 + "rsa-oaep"
+
+	SHA1       = dsig.NS_DSIG + "sha1"
+	MGF1SHA1   = NS_XMLENC11 + "mgf1sha1"
+	MGF1SHA256 = NS_XMLENC11 + "mgf1sha256"
+)
+
+type blockCipher struct {
+	keySize int
+	gcm     bool
+}
+
+var blockCiphers = map[string]blockCipher{
+	AES128CBC: {16, false},
+	AES256CBC: {32, false},
+	AES128GCM: {16, true},
+	AES256GCM: {32, true},
+}
+
+var hashes = map[string]crypto.Hash{
+	SHA1:        crypto.SHA1,
+	dsig.SHA256: crypto.SHA256,
+	dsig.SHA512: crypto.SHA512,
+	MGF1SHA1:    crypto.SHA1,
+	MGF1SHA256:  crypto.SHA256,
+}
+
+// Encrypter encrypts elements.
+type Encrypter struct {
+	// Algorithm is the block cipher to use, AES256GCM by default.
+	Algorithm string
+	// PublicKey is the key of the recipient.  If it is set, every
+	// encryption uses a new random key, which is sent along encrypted to
+	// PublicKey.
+	PublicKey *rsa.PublicKey
+	// KeyTransport is how keys are encrypted to PublicKey, RSAOAEP by
+	// default.
+	KeyTransport string
+	// Key is a key shared with the recipient in advance, of the size
+	// Algorithm needs.  It is only used if PublicKey is nil.
+	Key []byte
+}
+
+func (enc *Encrypter) algorithm() string {
+	if enc.Algorithm == "" {
+		return AES256GCM
+	}
+	return enc.Algorithm
+}
+
+func (enc *Encrypter) keyTransport() string {
+	if enc.KeyTransport == "" {
+		return RSAOAEP
+	}
+	return enc.KeyTransport
+}
+
+func xenc(name string) *dom.Element {
+	return dom.Elem(name, NS_XMLENC)
+}
+
+func method(name, space, alg string) *dom.Element {
+	return dom.Elem(name, space).Attr("Algorithm", "", alg)
+}
+
+func cipherData(data []byte) *dom.Element {
+	return xenc("CipherData").AddChild(dom.ElemC("CipherValue", NS_XMLENC, base64.StdEncoding.EncodeToString(data)))
+}
+
+// encrypt builds an EncryptedData of type typ holding plaintext.
+func (enc *Encrypter) encrypt(plaintext []byte, typ string) (*dom.Element, error) {
+	bc, ok := blockCiphers[enc.algorithm()]
+	if !ok {
+		return nil, fmt.Errorf("xmlenc: unsupported algorithm %s", enc.algorithm())
+	}
+	res := xenc("EncryptedData").Attr("xenc", "xmlns", NS_XMLENC).Attr("Type", "", typ)
+	res.AddChild(method("EncryptionMethod", NS_XMLENC, enc.algorithm()))
+	key := enc.Key
+	if enc.PublicKey != nil {
+		key = make([]byte, bc.keySize)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return nil, err
+		}
+		ek, err := enc.encryptedKey(key)
+		if err != nil {
+			return nil, err
+		}
+		res.AddChild(dom.Elem("KeyInfo", dsig.NS_DSIG).Attr("ds", "xmlns", dsig.NS_DSIG).AddChild(ek))
+	}
+	if len(key) != bc.keySize {
+		return nil, fmt.Errorf("xmlenc: %s needs a %d byte key, not %d", enc.algorithm(), bc.keySize, len(key))
+	}
+	data, err := seal(bc, key, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return res.AddChild(cipherData(data)), nil
+}
+
+func (enc *Encrypter) encryptedKey(key []byte) (*dom.Element, error) {
+	m := method("EncryptionMethod", NS_XMLENC, enc.keyTransport())
+	var hash crypto.Hash
+	switch enc.keyTransport() {
+	case RSAOAEPMGF1P:
+		hash = crypto.SHA1
+		m.AddChild(method("DigestMethod", dsig.NS_DSIG, SHA1))
+	case RSAOAEP:
+		hash = crypto.SHA256
+		m.AddChildren(
+			method("DigestMethod", dsig.NS_DSIG, dsig.SHA256),
+			method("MGF", NS_XMLENC11, MGF1SHA256).Attr("xenc11", "xmlns", NS_XMLENC11))
+	default:
+		return nil, fmt.Errorf("xmlenc: unsupported key transport %s", enc.keyTransport())
+	}
+	data, err := rsa.EncryptOAEP(hash.New(), rand.Reader, enc.PublicKey, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("xmlenc: cannot encrypt key: %v", err)
+	}
+	return xenc("EncryptedKey").AddChildren(m, cipherData(data)), nil
+}
+
+func seal(bc blockCipher, key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if bc.gcm {
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return nil, err
+		}
+		return aead.Seal(nonce, nonce, plaintext, nil), nil
+	}
+	// XML Encryption pads with arbitrary bytes, the last of which is the
+	// length of the padding.
+	pad := aes.BlockSize - len(plaintext)%aes.BlockSize
+	buf := make([]byte, aes.BlockSize+len(plaintext)+pad)
+	if _, err := io.ReadFull(rand.Reader, buf[:aes.BlockSize]); err != nil {
+		return nil, err
+	}
+	copy(buf[aes.BlockSize:], plaintext)
+	buf[len(buf)-1] = byte(pad)
+	cipher.NewCBCEncrypter(block, buf[:aes.BlockSize]).CryptBlocks(buf[aes.BlockSize:], buf[aes.BlockSize:])
+	return buf, nil
+}
+
+func open(bc blockCipher, key, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if bc.gcm {
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < aead.NonceSize() {
+			return nil, errors.New("xmlenc: ciphertext too short")
+		}
+		res, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+		if err != nil {
+			return nil, errors.New("xmlenc: decryption failed")
+		}
+		return res, nil
+	}
+	if len(data) < 2*aes.BlockSize || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("xmlenc: ciphertext is not a whole number of blocks")
+	}
+	res := make([]byte, len(data)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, data[:aes.BlockSize]).CryptBlocks(res, data[aes.BlockSize:])
+	pad := int(res[len(res)-1])
+	if pad == 0 || pad > aes.BlockSize {
+		return nil, errors.New("xmlenc: decryption failed")
+	}
+	return res[:len(res)-pad], nil
+}
+
+func encode(e *dom.Element, w io.Writer) error {
+	encoder := dom.NewEncoder(w)
+	if err := e.Encode(encoder); err != nil {
+		return err
+	}
+	return encoder.Flush()
+}
+
+// EncryptElement encrypts e and puts the EncryptedData in its place,
+// which it returns.  e itself is left unchanged, and removed from its
+// parent.
+func (enc *Encrypter) EncryptElement(e *dom.Element) (*dom.Element, error) {
+	var buf bytes.Buffer
+	if err := encode(e, &buf); err != nil {
+		return nil, err
+	}
+	res, err := enc.encrypt(buf.Bytes(), TypeElement)
+	if err != nil {
+		return nil, err
+	}
+	if p := e.Parent(); p != nil {
+		p.ReplaceChild(e, res)
+	}
+	return res, nil
+}
+
+// EncryptContent encrypts the Content and children of e, and replaces
+// them with the EncryptedData, which it returns.
+func (enc *Encrypter) EncryptContent(e *dom.Element) (*dom.Element, error) {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, e.Content)
+	for _, c := range e.Children() {
+		if err := encode(c, &buf); err != nil {
+			return nil, err
+		}
+	}
+	res, err := enc.encrypt(buf.Bytes(), TypeContent)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range e.Children() {
+		e.RemoveChild(c)
+	}
+	e.Content = nil
+	e.AddChild(res)
+	return res, nil
+}
+
+// Decrypter decrypts EncryptedData elements.
+type Decrypter struct {
+	// PrivateKey decrypts EncryptedKey elements.
+	PrivateKey *rsa.PrivateKey
+	// Key is used when there is no EncryptedKey.
+	Key []byte
+}
+
+func child(e *dom.Element, space, name string) *dom.Element {
+	for _, c := range e.Children() {
+		if c.Name.Space == space && c.Name.Local == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func algorithm(e *dom.Element) string {
+	if a := e.GetAttr("Algorithm", "", "*"); len(a) > 0 {
+		return a[0].Value
+	}
+	return ""
+}
+
+func cipherValue(e *dom.Element) ([]byte, error) {
+	cv := child(e, NS_XMLENC, "CipherData")
+	if cv != nil {
+		cv = child(cv, NS_XMLENC, "CipherValue")
+	}
+	if cv == nil {
+		return nil, fmt.Errorf("xmlenc: %s has no CipherValue", e.Name.Local)
+	}
+	s := strings.Join(strings.Fields(string(cv.Content)), "")
+	res, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("xmlenc: bad CipherValue: %v", err)
+	}
+	return res, nil
+}
+
+// encryptedKey returns the EncryptedKey for ed: the one in its KeyInfo,
+// or failing that one next to it, as SAML puts them.
+func encryptedKey(ed *dom.Element) *dom.Element {
+	if ki := child(ed, dsig.NS_DSIG, "KeyInfo"); ki != nil {
+		if ek := child(ki, NS_XMLENC, "EncryptedKey"); ek != nil {
+			return ek
+		}
+	}
+	if p := ed.Parent(); p != nil {
+		return child(p, NS_XMLENC, "EncryptedKey")
+	}
+	return nil
+}
+
+func (d *Decrypter) key(ed *dom.Element) ([]byte, error) {
+	ek := encryptedKey(ed)
+	if ek == nil || d.PrivateKey == nil {
+		if d.Key == nil {
+			return nil, errors.New("xmlenc: no key to decrypt with")
+		}
+		return d.Key, nil
+	}
+	m := child(ek, NS_XMLENC, "EncryptionMethod")
+	if m == nil {
+		return nil, errors.New("xmlenc: EncryptedKey has no EncryptionMethod")
+	}
+	opts := &rsa.OAEPOptions{Hash: crypto.SHA1, MGFHash: crypto.SHA1}
+	switch algorithm(m) {
+	case RSAOAEPMGF1P:
+	case RSAOAEP:
+		if mgf := child(m, NS_XMLENC11, "MGF"); mgf != nil {
+			h, ok := hashes[algorithm(mgf)]
+			if !ok {
+				return nil, fmt.Errorf("xmlenc: unsupported MGF %s", algorithm(mgf))
+			}
+			opts.MGFHash = h
+		}
+	default:
+		return nil, fmt.Errorf("xmlenc: unsupported key transport %s", algorithm(m))
+	}
+	if dm := child(m, dsig.NS_DSIG, "DigestMethod"); dm != nil {
+		h, ok := hashes[algorithm(dm)]
+		if !ok {
+			return nil, fmt.Errorf("xmlenc: unsupported digest %s", algorithm(dm))
+		}
+		opts.Hash = h
+	}
+	data, err := cipherValue(ek)
+	if err != nil {
+		return nil, err
+	}
+	res, err := d.PrivateKey.Decrypt(nil, data, opts)
+	if err != nil {
+		return nil, errors.New("xmlenc: cannot decrypt key")
+	}
+	return res, nil
+}
+
+// fragment parses plaintext as content inside e, so that it can use the
+// namespace prefixes in scope there.
+func fragment(e *dom.Element, plaintext []byte) (*dom.Element, error) {
+	var buf bytes.Buffer
+	buf.WriteString("<fragment")
+	seen := map[string]bool{}
+	for n := e; n != nil; n = n.Parent() {
+		for _, a := range n.Attributes {
+			isNS := a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns")
+			key := a.Name.Space + ":" + a.Name.Local
+			if !isNS || seen[key] {
+				continue
+			}
+			seen[key] = true
+			name := "xmlns"
+			if a.Name.Space == "xmlns" {
+				name += ":" + a.Name.Local
+			}
+			fmt.Fprintf(&buf, " %s=\"", name)
+			xml.EscapeText(&buf, []byte(a.Value))
+			buf.WriteString("\"")
+		}
+	}
+	buf.WriteString(">")
+	buf.Write(plaintext)
+	buf.WriteString("</fragment>")
+	doc, err := dom.Parse(&buf)
+	if err != nil {
+		return nil, fmt.Errorf("xmlenc: decrypted data is not XML: %v", err)
+	}
+	return doc.Root(), nil
+}
+
+// Decrypt decrypts ed, an EncryptedData element, and puts what it held in
+// its place.  For an encrypted element, the decrypted element is
+// returned; for encrypted content, the parent of ed is.
+func (d *Decrypter) Decrypt(ed *dom.Element) (*dom.Element, error) {
+	if ed.Name.Space != NS_XMLENC || ed.Name.Local != "EncryptedData" {
+		return nil, fmt.Errorf("xmlenc: %s is not an EncryptedData", ed.Path())
+	}
+	m := child(ed, NS_XMLENC, "EncryptionMethod")
+	if m == nil {
+		return nil, errors.New("xmlenc: EncryptedData has no EncryptionMethod")
+	}
+	bc, ok := blockCiphers[algorithm(m)]
+	if !ok {
+		return nil, fmt.Errorf("xmlenc: unsupported algorithm %s", algorithm(m))
+	}
+	key, err := d.key(ed)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != bc.keySize {
+		return nil, fmt.Errorf("xmlenc: %s needs a %d byte key, not %d", algorithm(m), bc.keySize, len(key))
+	}
+	data, err := cipherValue(ed)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := open(bc, key, data)
+	if err != nil {
+		return nil, err
+	}
+	parent := ed.Parent()
+	scopeOf := parent
+	if scopeOf == nil {
+		scopeOf = ed
+	}
+	frag, err := fragment(scopeOf, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	children := frag.Children()
+	switch typ := ed.GetAttr("Type", "", "*"); {
+	case len(typ) > 0 && typ[0].Value == TypeContent:
+		if parent == nil {
+			return nil, errors.New("xmlenc: encrypted content needs a parent element")
+		}
+		parent.Content = frag.Content
+		parent.ReplaceChild(ed, children...)
+		return parent, nil
+	case len(children) != 1 || len(frag.Content) != 0:
+		return nil, errors.New("xmlenc: decrypted data is not a single element")
+	}
+	frag.RemoveChild(children[0])
+	if parent != nil {
+		parent.ReplaceChild(ed, children[0])
+	}
+	return children[0], nil
+}
@@ -0,0 +1,99 @@
+package xmlenc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/domtest"
+)
+
+const order = `<order xmlns="urn:orders" xmlns:p="urn:payment">
+  <customer>Bob</customer>
+  <p:card number="4111111111111111">Bob Smith<p:expiry>12/30</p:expiry></p:card>
+</order>`
+
+var key, _ = rsa.GenerateKey(rand.Reader, 2048)
+
+func parse(t *testing.T, src string) *dom.Document {
+	doc, err := dom.Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestElement(t *testing.T) {
+	for _, enc := range []*Encrypter{
+		{PublicKey: &key.PublicKey},
+		{PublicKey: &key.PublicKey, Algorithm: AES128CBC, KeyTransport: RSAOAEPMGF1P},
+		{Key: []byte("0123456789abcdef"), Algorithm: AES128GCM},
+	} {
+		doc := parse(t, order)
+		want := doc.String()
+		card := doc.Root().Children()[1]
+		ed, err := enc.EncryptElement(card)
+		if err != nil {
+			t.Fatalf("Encryption failed: %v", err)
+		}
+		if ed.Parent() != doc.Root() || card.Parent() != nil || strings.Contains(doc.String(), "4111") {
+			t.Fatalf("Unexpected document %s", doc)
+		}
+		// Send it.
+		doc = parse(t, doc.String())
+		dec := &Decrypter{PrivateKey: key, Key: enc.Key}
+		res, err := dec.Decrypt(doc.Root().Children()[1])
+		if err != nil {
+			t.Fatalf("Decryption failed: %v", err)
+		}
+		if res.Name.Local != "card" || res.Parent() != doc.Root() {
+			t.Errorf("Unexpected result %s", res)
+		}
+		domtest.EqualXML(t, want, doc)
+	}
+}
+
+func TestContent(t *testing.T) {
+	doc := parse(t, order)
+	want := doc.String()
+	card := doc.Root().Children()[1]
+	ed, err := (&Encrypter{PublicKey: &key.PublicKey}).EncryptContent(card)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(card.Content) != 0 || len(card.Children()) != 1 || card.Children()[0] != ed ||
+		len(card.GetAttr("number", "", "*")) != 1 {
+		t.Fatalf("Unexpected element %s", card)
+	}
+	doc = parse(t, doc.String())
+	res, err := (&Decrypter{PrivateKey: key}).Decrypt(doc.Root().Children()[1].Children()[0])
+	if err != nil || res != doc.Root().Children()[1] {
+		t.Fatalf("Unexpected result %s, %v", res, err)
+	}
+	domtest.EqualXML(t, want, doc)
+}
+
+func TestErrors(t *testing.T) {
+	doc := parse(t, order)
+	ed, err := (&Encrypter{PublicKey: &key.PublicKey}).EncryptElement(doc.Root().Children()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	if _, err := (&Decrypter{PrivateKey: other}).Decrypt(ed); err == nil {
+		t.Errorf("Expected the wrong key to fail")
+	}
+	cv := ed.Children()[2].Children()[0]
+	cv.Content[10] ^= 1
+	if _, err := (&Decrypter{PrivateKey: key}).Decrypt(ed); err == nil {
+		t.Errorf("Expected tampered ciphertext to fail")
+	}
+	if _, err := (&Encrypter{Key: []byte("short")}).EncryptElement(dom.Elem("x", "")); err == nil {
+		t.Errorf("Expected a short key to be rejected")
+	}
+	if _, err := (&Decrypter{}).Decrypt(dom.Elem("x", "")); err == nil {
+		t.Errorf("Expected a non-EncryptedData element to be rejected")
+	}
+}
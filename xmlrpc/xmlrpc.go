@@ -0,0 +1,386 @@
+// Package xmlrpc encodes and decodes XML-RPC calls and responses as
+// simplexml/dom trees.
+//
+// Go values map to XML-RPC types as follows:
+//    int, int8 ... int64, uint8 ... uint32  <i4>  (if they fit in 32 bits)
+//    bool                                   <boolean>
+//    string                                 <string>
+//    float32, float64                       <double>
+//    time.Time                              <dateTime.iso8601>
+//    []byte                                 <base64>
+//    slices and arrays                      <array>
+//    maps with string keys, structs         <struct>
+// Struct fields are named by an `xmlrpc:"name"` tag, or by their Go
+// name; fields tagged `xmlrpc:"-"` are skipped.  Decoding produces the
+// first type of each line (int for <i4> and <int>), []interface{} for
+// arrays and map[string]interface{} for structs.
+//
+// For some basic usage examples, see xmlrpc_test.go
+package xmlrpc
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+// TimeLayout is the layout of dateTime.iso8601 values.
+const TimeLayout = "20060102T15:04:05"
+
+// Fault is an XML-RPC fault response.  It is returned as an error by
+// ParseResponse.
+type Fault struct {
+	Code    int
+	Message string
+}
+
+func (f *Fault) Error() string {
+	return fmt.Sprintf("xmlrpc: fault %d: %s", f.Code, f.Message)
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Marshal returns the <value> element for v.
+func Marshal(v interface{}) (*dom.Element, error) {
+	inner, err := marshal(reflect.ValueOf(v))
+	if err != nil {
+		return nil, err
+	}
+	return dom.Elem("value", "").AddChild(inner), nil
+}
+
+func marshal(v reflect.Value) (*dom.Element, error) {
+	if !v.IsValid() {
+		return nil, errors.New("xmlrpc: cannot encode nil")
+	}
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, errors.New("xmlrpc: cannot encode nil")
+		}
+		v = v.Elem()
+	}
+	if v.Type() == timeType {
+		return dom.ElemC("dateTime.iso8601", "", v.Interface().(time.Time).Format(TimeLayout)), nil
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := v.Int()
+		if i < math.MinInt32 || i > math.MaxInt32 {
+			return nil, fmt.Errorf("xmlrpc: %d does not fit in an i4", i)
+		}
+		return dom.ElemC("i4", "", strconv.FormatInt(i, 10)), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i := v.Uint()
+		if i > math.MaxInt32 {
+			return nil, fmt.Errorf("xmlrpc: %d does not fit in an i4", i)
+		}
+		return dom.ElemC("i4", "", strconv.FormatUint(i, 10)), nil
+	case reflect.Bool:
+		if v.Bool() {
+			return dom.ElemC("boolean", "", "1"), nil
+		}
+		return dom.ElemC("boolean", "", "0"), nil
+	case reflect.String:
+		return dom.ElemC("string", "", v.String()), nil
+	case reflect.Float32, reflect.Float64:
+		return dom.ElemC("double", "", strconv.FormatFloat(v.Float(), 'f', -1, 64)), nil
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			buf := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(buf), v)
+			return dom.ElemC("base64", "", base64.StdEncoding.EncodeToString(buf)), nil
+		}
+		data := dom.Elem("data", "")
+		for i := 0; i < v.Len(); i++ {
+			item, err := marshal(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			data.AddChild(dom.Elem("value", "").AddChild(item))
+		}
+		return dom.Elem("array", "").AddChild(data), nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("xmlrpc: cannot encode a %v, keys must be strings", v.Type())
+		}
+		keys := []string{}
+		for _, k := range v.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		sort.Strings(keys)
+		res := dom.Elem("struct", "")
+		for _, k := range keys {
+			m, err := member(k, v.MapIndex(reflect.ValueOf(k).Convert(v.Type().Key())))
+			if err != nil {
+				return nil, err
+			}
+			res.AddChild(m)
+		}
+		return res, nil
+	case reflect.Struct:
+		res := dom.Elem("struct", "")
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := f.Tag.Get("xmlrpc")
+			if f.PkgPath != "" || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			m, err := member(name, v.Field(i))
+			if err != nil {
+				return nil, err
+			}
+			res.AddChild(m)
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("xmlrpc: cannot encode a %v", v.Type())
+}
+
+func member(name string, v reflect.Value) (*dom.Element, error) {
+	inner, err := marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("%v (in member %s)", err, name)
+	}
+	return dom.Elem("member", "").AddChildren(
+		dom.ElemC("name", "", name),
+		dom.Elem("value", "").AddChild(inner)), nil
+}
+
+func text(e *dom.Element) string {
+	return strings.TrimSpace(string(e.Content))
+}
+
+func only(e *dom.Element, name string) (*dom.Element, error) {
+	children := e.Children()
+	if len(children) != 1 || children[0].Name.Local != name {
+		return nil, fmt.Errorf("xmlrpc: %s: expected a single %s element", e.Path(), name)
+	}
+	return children[0], nil
+}
+
+// Unmarshal decodes the <value> element e.
+func Unmarshal(e *dom.Element) (interface{}, error) {
+	if e.Name.Local != "value" {
+		return nil, fmt.Errorf("xmlrpc: %s: expected a value element", e.Path())
+	}
+	children := e.Children()
+	switch len(children) {
+	case 0:
+		// A value without a type is a string.
+		return string(e.Content), nil
+	case 1:
+	default:
+		return nil, fmt.Errorf("xmlrpc: %s: a value can only hold one element", e.Path())
+	}
+	v := children[0]
+	bad := func() (interface{}, error) {
+		return nil, fmt.Errorf("xmlrpc: %s: invalid %s %q", v.Path(), v.Name.Local, v.Content)
+	}
+	switch v.Name.Local {
+	case "i4", "int":
+		i, err := strconv.ParseInt(text(v), 10, 32)
+		if err != nil {
+			return bad()
+		}
+		return int(i), nil
+	case "boolean":
+		switch text(v) {
+		case "1":
+			return true, nil
+		case "0":
+			return false, nil
+		}
+		return bad()
+	case "string":
+		return string(v.Content), nil
+	case "double":
+		f, err := strconv.ParseFloat(text(v), 64)
+		if err != nil {
+			return bad()
+		}
+		return f, nil
+	case "dateTime.iso8601":
+		t, err := time.Parse(TimeLayout, text(v))
+		if err != nil {
+			return bad()
+		}
+		return t, nil
+	case "base64":
+		buf, err := base64.StdEncoding.DecodeString(text(v))
+		if err != nil {
+			return bad()
+		}
+		return buf, nil
+	case "array":
+		data, err := only(v, "data")
+		if err != nil {
+			return nil, err
+		}
+		res := []interface{}{}
+		for _, item := range data.Children() {
+			val, err := Unmarshal(item)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, val)
+		}
+		return res, nil
+	case "struct":
+		res := map[string]interface{}{}
+		for _, m := range v.Children() {
+			var name, value *dom.Element
+			for _, c := range m.Children() {
+				switch c.Name.Local {
+				case "name":
+					name = c
+				case "value":
+					value = c
+				}
+			}
+			if m.Name.Local != "member" || name == nil || value == nil {
+				return nil, fmt.Errorf("xmlrpc: %s: expected a member with a name and a value", m.Path())
+			}
+			val, err := Unmarshal(value)
+			if err != nil {
+				return nil, err
+			}
+			res[string(name.Content)] = val
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("xmlrpc: %s: unknown type %s", v.Path(), v.Name.Local)
+}
+
+func params(values []interface{}) (*dom.Element, error) {
+	res := dom.Elem("params", "")
+	for _, p := range values {
+		v, err := Marshal(p)
+		if err != nil {
+			return nil, err
+		}
+		res.AddChild(dom.Elem("param", "").AddChild(v))
+	}
+	return res, nil
+}
+
+func document(root *dom.Element) *dom.Document {
+	doc := dom.CreateDocument()
+	doc.SetRoot(root)
+	return doc
+}
+
+// NewCall returns a <methodCall> document calling method with params.
+func NewCall(method string, args ...interface{}) (*dom.Document, error) {
+	p, err := params(args)
+	if err != nil {
+		return nil, err
+	}
+	return document(dom.Elem("methodCall", "").AddChildren(dom.ElemC("methodName", "", method), p)), nil
+}
+
+// NewResponse returns a <methodResponse> document holding result.
+func NewResponse(result interface{}) (*dom.Document, error) {
+	p, err := params([]interface{}{result})
+	if err != nil {
+		return nil, err
+	}
+	return document(dom.Elem("methodResponse", "").AddChild(p)), nil
+}
+
+// NewFaultResponse returns a <methodResponse> document holding f.
+func NewFaultResponse(f *Fault) *dom.Document {
+	v, _ := Marshal(map[string]interface{}{"faultCode": f.Code, "faultString": f.Message})
+	return document(dom.Elem("methodResponse", "").AddChild(dom.Elem("fault", "").AddChild(v)))
+}
+
+func readParams(e *dom.Element) ([]interface{}, error) {
+	res := []interface{}{}
+	for _, p := range e.Children() {
+		v, err := only(p, "value")
+		if err != nil || p.Name.Local != "param" {
+			return nil, fmt.Errorf("xmlrpc: %s: expected a param holding a value", p.Path())
+		}
+		val, err := Unmarshal(v)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, val)
+	}
+	return res, nil
+}
+
+// ParseCall returns the method name and parameters of the <methodCall>
+// in doc.
+func ParseCall(doc *dom.Document) (string, []interface{}, error) {
+	root := doc.Root()
+	if root == nil || root.Name.Local != "methodCall" {
+		return "", nil, errors.New("xmlrpc: document is not a methodCall")
+	}
+	method, args := "", []interface{}{}
+	found := false
+	for _, c := range root.Children() {
+		switch c.Name.Local {
+		case "methodName":
+			method, found = text(c), true
+		case "params":
+			var err error
+			if args, err = readParams(c); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+	if !found {
+		return "", nil, errors.New("xmlrpc: methodCall has no methodName")
+	}
+	return method, args, nil
+}
+
+// ParseResponse returns the result in the <methodResponse> in doc.  If
+// the response is a fault, the error is a *Fault.
+func ParseResponse(doc *dom.Document) (interface{}, error) {
+	root := doc.Root()
+	if root == nil || root.Name.Local != "methodResponse" || len(root.Children()) != 1 {
+		return nil, errors.New("xmlrpc: document is not a methodResponse")
+	}
+	c := root.Children()[0]
+	switch c.Name.Local {
+	case "params":
+		args, err := readParams(c)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) != 1 {
+			return nil, fmt.Errorf("xmlrpc: expected one result, got %d", len(args))
+		}
+		return args[0], nil
+	case "fault":
+		v, err := only(c, "value")
+		if err != nil {
+			return nil, err
+		}
+		val, err := Unmarshal(v)
+		if err != nil {
+			return nil, err
+		}
+		m, _ := val.(map[string]interface{})
+		code, ok1 := m["faultCode"].(int)
+		msg, ok2 := m["faultString"].(string)
+		if !ok1 || !ok2 {
+			return nil, errors.New("xmlrpc: fault must have an int faultCode and a string faultString")
+		}
+		return nil, &Fault{Code: code, Message: msg}
+	}
+	return nil, fmt.Errorf("xmlrpc: unexpected %s in methodResponse", c.Name.Local)
+}
@@ -0,0 +1,105 @@
+package xmlrpc
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+type point struct {
+	X      int     `xmlrpc:"x"`
+	Y      float64 `xmlrpc:"y"`
+	Hidden string  `xmlrpc:"-"`
+	Label  string
+}
+
+func reparse(t *testing.T, doc *dom.Document) *dom.Document {
+	res, err := dom.Parse(bytes.NewReader(doc.Bytes()))
+	if err != nil {
+		t.Fatalf("Cannot parse %s: %v", doc, err)
+	}
+	return res
+}
+
+func TestCall(t *testing.T) {
+	when := time.Date(2013, 4, 1, 12, 30, 0, 0, time.UTC)
+	doc, err := NewCall("geo.move", 42, true, "hi", 1.5, when, []byte{1, 2, 3},
+		[]string{"a", "b"}, map[string]int{"n": 1}, &point{X: 1, Y: 2.5, Hidden: "no", Label: "p"})
+	if err != nil {
+		t.Fatalf("NewCall failed: %v", err)
+	}
+	method, args, err := ParseCall(reparse(t, doc))
+	if err != nil {
+		t.Fatalf("ParseCall failed: %v", err)
+	}
+	expected := []interface{}{42, true, "hi", 1.5, when, []byte{1, 2, 3},
+		[]interface{}{"a", "b"}, map[string]interface{}{"n": 1},
+		map[string]interface{}{"x": 1, "y": 2.5, "Label": "p"}}
+	if method != "geo.move" || !reflect.DeepEqual(args, expected) {
+		t.Errorf("Unexpected call %s %#v", method, args)
+	}
+	for _, bad := range []interface{}{nil, int64(1) << 40, map[int]int{1: 1}, make(chan int)} {
+		if _, err := NewCall("m", bad); err == nil {
+			t.Errorf("Expected %v not to encode", bad)
+		}
+	}
+}
+
+func TestResponse(t *testing.T) {
+	doc, err := NewResponse([]interface{}{1, "two"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := ParseResponse(reparse(t, doc))
+	if err != nil || !reflect.DeepEqual(res, []interface{}{1, "two"}) {
+		t.Errorf("Unexpected response %v, %v", res, err)
+	}
+	_, err = ParseResponse(reparse(t, NewFaultResponse(&Fault{Code: 4, Message: "Too many parameters."})))
+	if f, ok := err.(*Fault); !ok || f.Code != 4 || f.Message != "Too many parameters." {
+		t.Errorf("Expected a fault, got %v", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	src := `<?xml version="1.0"?>
+<methodCall>
+  <methodName>examples.getStateName</methodName>
+  <params>
+    <param><value><int>41</int></value></param>
+    <param><value>untyped</value></param>
+  </params>
+</methodCall>`
+	doc, err := dom.Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	method, args, err := ParseCall(doc)
+	if err != nil || method != "examples.getStateName" || !reflect.DeepEqual(args, []interface{}{41, "untyped"}) {
+		t.Errorf("Unexpected call %s %v, %v", method, args, err)
+	}
+	for _, src := range []string{
+		`<methodCall><params/></methodCall>`,
+		`<methodCall><methodName>m</methodName><params><param><value><i4>x</i4></value></param></params></methodCall>`,
+		`<methodCall><methodName>m</methodName><params><param><value><boolean>yes</boolean></value></param></params></methodCall>`,
+		`<methodCall><methodName>m</methodName><params><param><value><nil/></value></param></params></methodCall>`,
+		`<methodCall><methodName>m</methodName><params><param><value><struct><member><name>a</name></member></struct></value></param></params></methodCall>`,
+		`<methodResponse/>`,
+	} {
+		doc, err := dom.Parse(strings.NewReader(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if doc.Root().Name.Local == "methodCall" {
+			_, _, err = ParseCall(doc)
+		} else {
+			_, err = ParseResponse(doc)
+		}
+		if err == nil {
+			t.Errorf("Expected %s to be rejected", src)
+		}
+	}
+}
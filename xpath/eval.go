@@ -0,0 +1,586 @@
+package xpath
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+// docOrder lazily numbers the elements of every tree it is asked about,
+// so that nodes can be sorted into document order.
+type docOrder struct {
+	seq  map[*dom.Element]int
+	root map[*dom.Element]int
+	next int
+}
+
+func newDocOrder() *docOrder {
+	return &docOrder{
+		seq:  map[*dom.Element]int{},
+		root: map[*dom.Element]int{},
+	}
+}
+
+func (o *docOrder) number(top *dom.Element) {
+	o.root[top] = o.next
+	o.next++
+	var walk func(e *dom.Element)
+	walk = func(e *dom.Element) {
+		o.seq[e] = o.next
+		o.next++
+		for _, c := range e.Children() {
+			walk(c)
+		}
+	}
+	walk(top)
+}
+
+// key returns a pair that orders n relative to every other node.
+func (o *docOrder) key(n Node) (int, int) {
+	if n.elem == nil {
+		return -1, 0
+	}
+	if n.kind == RootNode {
+		if _, ok := o.root[n.elem]; !ok {
+			o.number(n.elem)
+		}
+		return o.root[n.elem], 0
+	}
+	s, ok := o.seq[n.elem]
+	if !ok {
+		o.number(n.root().elem)
+		s = o.seq[n.elem]
+	}
+	switch n.kind {
+	case AttributeNode:
+		return s, 1 + n.attr
+	case TextNode:
+		return s, 1 + len(n.elem.Attributes)
+	}
+	return s, 0
+}
+
+// sort puts ns into document order and removes duplicates.
+func (o *docOrder) sort(ns NodeSet) NodeSet {
+	if len(ns) < 2 {
+		return ns
+	}
+	type keyed struct {
+		n    Node
+		a, b int
+	}
+	ks := make([]keyed, len(ns))
+	for i, n := range ns {
+		a, b := o.key(n)
+		ks[i] = keyed{n, a, b}
+	}
+	sort.SliceStable(ks, func(i, j int) bool {
+		if ks[i].a != ks[j].a {
+			return ks[i].a < ks[j].a
+		}
+		return ks[i].b < ks[j].b
+	})
+	res := make(NodeSet, 0, len(ks))
+	for i, k := range ks {
+		if i > 0 && k.n == ks[i-1].n {
+			continue
+		}
+		res = append(res, k.n)
+	}
+	return res
+}
+
+func descendants(n Node, res []Node) []Node {
+	for _, c := range n.children() {
+		res = append(res, c)
+		res = descendants(c, res)
+	}
+	return res
+}
+
+// siblings returns the nodes before and after n in its parent's list of
+// children.
+func siblings(n Node) (before, after []Node) {
+	if n.kind == RootNode || n.kind == AttributeNode {
+		return nil, nil
+	}
+	p, ok := n.parent()
+	if !ok {
+		return nil, nil
+	}
+	kids := p.children()
+	for i, k := range kids {
+		if k == n {
+			return kids[:i], kids[i+1:]
+		}
+	}
+	return nil, nil
+}
+
+func reverseNodes(ns []Node) []Node {
+	for i, j := 0, len(ns)-1; i < j; i, j = i+1, j-1 {
+		ns[i], ns[j] = ns[j], ns[i]
+	}
+	return ns
+}
+
+// axisNodes returns the nodes on axis a from n, in proximity order.
+func axisNodes(n Node, a axis) []Node {
+	switch a {
+	case axisChild:
+		return n.children()
+	case axisDescendant:
+		return descendants(n, nil)
+	case axisDescendantOrSelf:
+		return descendants(n, []Node{n})
+	case axisSelf:
+		return []Node{n}
+	case axisParent:
+		if p, ok := n.parent(); ok {
+			return []Node{p}
+		}
+		return nil
+	case axisAncestor, axisAncestorOrSelf:
+		res := []Node{}
+		if a == axisAncestorOrSelf {
+			res = append(res, n)
+		}
+		for p, ok := n.parent(); ok; p, ok = p.parent() {
+			res = append(res, p)
+		}
+		return res
+	case axisFollowingSibling:
+		_, after := siblings(n)
+		return append([]Node{}, after...)
+	case axisPrecedingSibling:
+		before, _ := siblings(n)
+		return reverseNodes(append([]Node{}, before...))
+	case axisFollowing:
+		res := []Node{}
+		cur := n
+		if n.kind == AttributeNode {
+			cur = Node{kind: ElementNode, elem: n.elem}
+			res = descendants(cur, res)
+		}
+		for ok := true; ok; cur, ok = cur.parent() {
+			_, after := siblings(cur)
+			for _, s := range after {
+				res = append(res, s)
+				res = descendants(s, res)
+			}
+		}
+		return res
+	case axisPreceding:
+		res := []Node{}
+		cur := n
+		if n.kind == AttributeNode {
+			cur = Node{kind: ElementNode, elem: n.elem}
+		}
+		for ok := true; ok; cur, ok = cur.parent() {
+			before, _ := siblings(cur)
+			for i := len(before) - 1; i >= 0; i-- {
+				sub := descendants(before[i], []Node{before[i]})
+				res = append(res, reverseNodes(sub)...)
+			}
+		}
+		return res
+	case axisAttribute:
+		return n.attributes()
+	}
+	return nil
+}
+
+func (t nodeTest) matches(n Node, a axis, env *Env) (bool, error) {
+	switch t.kind {
+	case testNode:
+		return true, nil
+	case testText:
+		return n.kind == TextNode, nil
+	case testComment, testPI:
+		return false, nil
+	}
+	principal := ElementNode
+	if a == axisAttribute {
+		principal = AttributeNode
+	}
+	if n.kind != principal {
+		return false, nil
+	}
+	name := n.Name()
+	if t.prefix == "" {
+		if t.local == "*" {
+			return true, nil
+		}
+		return name.Space == "" && name.Local == t.local, nil
+	}
+	uri, err := env.resolve(t.prefix)
+	if err != nil {
+		return false, err
+	}
+	return name.Space == uri && (t.local == "*" || name.Local == t.local), nil
+}
+
+// filter applies predicates to nodes, which must be in proximity order.
+func filter(c *Context, nodes []Node, preds []expr) ([]Node, error) {
+	for _, pred := range preds {
+		kept := make([]Node, 0, len(nodes))
+		for i, n := range nodes {
+			v, err := pred.eval(c.with(n, i+1, len(nodes)))
+			if err != nil {
+				return nil, err
+			}
+			if f, ok := v.(float64); ok {
+				if f == float64(i+1) {
+					kept = append(kept, n)
+				}
+			} else if Boolean(v) {
+				kept = append(kept, n)
+			}
+		}
+		nodes = kept
+	}
+	return nodes, nil
+}
+
+func (s *step) apply(c *Context, n Node) ([]Node, error) {
+	candidates := axisNodes(n, s.axis)
+	matched := make([]Node, 0, len(candidates))
+	for _, cand := range candidates {
+		ok, err := s.test.matches(cand, s.axis, c.env)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, cand)
+		}
+	}
+	return filter(c, matched, s.preds)
+}
+
+func (e *pathExpr) eval(c *Context) (Value, error) {
+	var current NodeSet
+	switch {
+	case e.start != nil:
+		v, err := e.start.eval(c)
+		if err != nil {
+			return nil, err
+		}
+		ns, ok := v.(NodeSet)
+		if !ok {
+			return nil, fmt.Errorf("xpath: cannot apply a location path to a %s", typeName(v))
+		}
+		current = ns
+	case e.absolute:
+		current = NodeSet{c.Node.root()}
+	default:
+		current = NodeSet{c.Node}
+	}
+	steps := e.steps
+	if ns, ok, err := e.indexed(c); err != nil {
+		return nil, err
+	} else if ok {
+		current, steps = ns, steps[2:]
+	}
+	for _, s := range steps {
+		next := NodeSet{}
+		for _, n := range current {
+			res, err := s.apply(c, n)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, res...)
+		}
+		current = c.order.sort(next)
+	}
+	return current, nil
+}
+
+// indexed evaluates the //name prefix of an absolute path using the
+// Index of the document being searched, if there is one.  The boolean
+// return is false if the Index could not be used.
+func (e *pathExpr) indexed(c *Context) (NodeSet, bool, error) {
+	if c.index == nil || e.start != nil || !e.absolute || len(e.steps) < 2 ||
+		c.Node.root().elem != c.index.Root() || c.Node.elem == nil {
+		return nil, false, nil
+	}
+	first, second := e.steps[0], e.steps[1]
+	if first.axis != axisDescendantOrSelf || first.test.kind != testNode || len(first.preds) > 0 ||
+		second.axis != axisChild || second.test.kind != testName {
+		return nil, false, nil
+	}
+	var candidates []*dom.Element
+	preds := second.preds
+	space := ""
+	if second.test.prefix != "" {
+		uri, err := c.env.resolve(second.test.prefix)
+		if err != nil {
+			return nil, false, err
+		}
+		space = uri
+	}
+	if name, value, ok := attrEquals(preds, c.env); ok {
+		candidates = c.index.ByAttrValue(name, value)
+		preds = preds[1:]
+	} else if second.test.local != "*" {
+		candidates = c.index.ByName(xml.Name{Space: space, Local: second.test.local})
+	} else {
+		return nil, false, nil
+	}
+	// Predicates are evaluated relative to the children of each parent,
+	// so group the candidates by parent before filtering them.
+	groups := map[*dom.Element][]Node{}
+	parents := []*dom.Element{}
+	for _, cand := range candidates {
+		n := FromElement(cand)
+		if ok, err := second.test.matches(n, axisChild, c.env); err != nil || !ok {
+			if err != nil {
+				return nil, false, err
+			}
+			continue
+		}
+		p := cand.Parent()
+		if _, found := groups[p]; !found {
+			parents = append(parents, p)
+		}
+		groups[p] = append(groups[p], n)
+	}
+	res := NodeSet{}
+	for _, p := range parents {
+		kept, err := filter(c, groups[p], preds)
+		if err != nil {
+			return nil, false, err
+		}
+		res = append(res, kept...)
+	}
+	return c.order.sort(res), true, nil
+}
+
+// attrEquals checks if the first of preds is of the form @name = 'value'.
+func attrEquals(preds []expr, env *Env) (xml.Name, string, bool) {
+	if len(preds) == 0 {
+		return xml.Name{}, "", false
+	}
+	b, ok := preds[0].(*binaryExpr)
+	if !ok || b.op != "=" {
+		return xml.Name{}, "", false
+	}
+	path, lit := b.left, b.right
+	if _, isLit := path.(literalExpr); isLit {
+		path, lit = lit, path
+	}
+	p, ok := path.(*pathExpr)
+	value, isLit := lit.(literalExpr)
+	if !ok || !isLit || p.start != nil || p.absolute || len(p.steps) != 1 {
+		return xml.Name{}, "", false
+	}
+	s := p.steps[0]
+	if s.axis != axisAttribute || s.test.kind != testName || s.test.local == "*" || len(s.preds) > 0 {
+		return xml.Name{}, "", false
+	}
+	name := xml.Name{Local: s.test.local}
+	if s.test.prefix != "" {
+		uri, err := env.resolve(s.test.prefix)
+		if err != nil {
+			return xml.Name{}, "", false
+		}
+		name.Space = uri
+	}
+	if isNamespaceDecl(xml.Attr{Name: name}) {
+		return xml.Name{}, "", false
+	}
+	return name, string(value), true
+}
+
+func (e *filterExpr) eval(c *Context) (Value, error) {
+	v, err := e.primary.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	ns, ok := v.(NodeSet)
+	if !ok {
+		return nil, fmt.Errorf("xpath: cannot apply a predicate to a %s", typeName(v))
+	}
+	res, err := filter(c, c.order.sort(ns), e.preds)
+	if err != nil {
+		return nil, err
+	}
+	return NodeSet(res), nil
+}
+
+func (e literalExpr) eval(c *Context) (Value, error) {
+	return string(e), nil
+}
+
+func (e numberExpr) eval(c *Context) (Value, error) {
+	return float64(e), nil
+}
+
+func (e varExpr) eval(c *Context) (Value, error) {
+	return c.env.variable(string(e))
+}
+
+func (e *negExpr) eval(c *Context) (Value, error) {
+	v, err := e.operand.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	return -Number(v), nil
+}
+
+func (e *callExpr) eval(c *Context) (Value, error) {
+	fn, ok := c.env.function(e.name)
+	if !ok {
+		return nil, fmt.Errorf("xpath: unknown function %s()", e.name)
+	}
+	args := make([]Value, len(e.args))
+	for i, a := range e.args {
+		v, err := a.eval(c)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	v, err := fn(c, args)
+	if err != nil {
+		return nil, err
+	}
+	return normalize(v)
+}
+
+func (e *binaryExpr) eval(c *Context) (Value, error) {
+	left, err := e.left.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "or", "and":
+		b := Boolean(left)
+		if (e.op == "or") == b {
+			return b, nil
+		}
+		right, err := e.right.eval(c)
+		if err != nil {
+			return nil, err
+		}
+		return Boolean(right), nil
+	}
+	right, err := e.right.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "|":
+		l, lok := left.(NodeSet)
+		r, rok := right.(NodeSet)
+		if !lok || !rok {
+			return nil, fmt.Errorf("xpath: the operands of | must be node-sets")
+		}
+		return c.order.sort(append(append(NodeSet{}, l...), r...)), nil
+	case "+":
+		return Number(left) + Number(right), nil
+	case "-":
+		return Number(left) - Number(right), nil
+	case "*":
+		return Number(left) * Number(right), nil
+	case "div":
+		return Number(left) / Number(right), nil
+	case "mod":
+		return math.Mod(Number(left), Number(right)), nil
+	}
+	return compare(e.op, left, right), nil
+}
+
+func typeName(v Value) string {
+	switch v.(type) {
+	case NodeSet:
+		return "node-set"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// compare implements the comparison operators as described in section 3.4
+// of the XPath 1.0 spec.
+func compare(op string, left, right Value) bool {
+	l, lok := left.(NodeSet)
+	r, rok := right.(NodeSet)
+	switch {
+	case lok && rok:
+		for _, ln := range l {
+			for _, rn := range r {
+				if compareAtomic(op, ln.Value(), rn.Value()) {
+					return true
+				}
+			}
+		}
+		return false
+	case lok:
+		if b, ok := right.(bool); ok {
+			return compareAtomic(op, Boolean(l), b)
+		}
+		for _, ln := range l {
+			if compareAtomic(op, atomize(ln, right), right) {
+				return true
+			}
+		}
+		return false
+	case rok:
+		if b, ok := left.(bool); ok {
+			return compareAtomic(op, b, Boolean(r))
+		}
+		for _, rn := range r {
+			if compareAtomic(op, left, atomize(rn, left)) {
+				return true
+			}
+		}
+		return false
+	}
+	return compareAtomic(op, left, right)
+}
+
+// atomize converts n to a value of the same type as other.
+func atomize(n Node, other Value) Value {
+	if _, ok := other.(float64); ok {
+		return parseNumber(n.Value())
+	}
+	return n.Value()
+}
+
+func compareAtomic(op string, left, right Value) bool {
+	if op == "=" || op == "!=" {
+		var eq bool
+		_, lb := left.(bool)
+		_, rb := right.(bool)
+		_, ln := left.(float64)
+		_, rn := right.(float64)
+		switch {
+		case lb || rb:
+			eq = Boolean(left) == Boolean(right)
+		case ln || rn:
+			eq = Number(left) == Number(right)
+		default:
+			eq = String(left) == String(right)
+		}
+		return eq == (op == "=")
+	}
+	lf, rf := Number(left), Number(right)
+	switch op {
+	case "<":
+		return lf < rf
+	case "<=":
+		return lf <= rf
+	case ">":
+		return lf > rf
+	case ">=":
+		return lf >= rf
+	}
+	return false
+}
@@ -0,0 +1,420 @@
+package xpath
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// coreFunctions is the XPath 1.0 core function library.
+var coreFunctions map[string]Function
+
+func init() {
+	coreFunctions = map[string]Function{
+		"last":             fnLast,
+		"position":         fnPosition,
+		"count":            fnCount,
+		"id":               fnID,
+		"local-name":       fnLocalName,
+		"namespace-uri":    fnNamespaceURI,
+		"name":             fnName,
+		"string":           fnString,
+		"concat":           fnConcat,
+		"starts-with":      fnStartsWith,
+		"contains":         fnContains,
+		"substring-before": fnSubstringBefore,
+		"substring-after":  fnSubstringAfter,
+		"substring":        fnSubstring,
+		"string-length":    fnStringLength,
+		"normalize-space":  fnNormalizeSpace,
+		"translate":        fnTranslate,
+		"boolean":          fnBoolean,
+		"not":              fnNot,
+		"true":             fnTrue,
+		"false":            fnFalse,
+		"lang":             fnLang,
+		"number":           fnNumber,
+		"sum":              fnSum,
+		"floor":            fnFloor,
+		"ceiling":          fnCeiling,
+		"round":            fnRound,
+	}
+}
+
+func arity(name string, args []Value, min, max int) error {
+	if len(args) < min || (max >= 0 && len(args) > max) {
+		return fmt.Errorf("xpath: wrong number of arguments to %s()", name)
+	}
+	return nil
+}
+
+func nodeSetArg(name string, v Value) (NodeSet, error) {
+	ns, ok := v.(NodeSet)
+	if !ok {
+		return nil, fmt.Errorf("xpath: argument to %s() must be a node-set, not a %s", name, typeName(v))
+	}
+	return ns, nil
+}
+
+// firstNode returns the node that the node name functions operate on:
+// the context node if there are no arguments, otherwise the first node in
+// the argument.
+func firstNode(name string, c *Context, args []Value) (Node, bool, error) {
+	if err := arity(name, args, 0, 1); err != nil {
+		return Node{}, false, err
+	}
+	if len(args) == 0 {
+		return c.Node, true, nil
+	}
+	ns, err := nodeSetArg(name, args[0])
+	if err != nil || len(ns) == 0 {
+		return Node{}, false, err
+	}
+	return c.order.sort(ns)[0], true, nil
+}
+
+// stringArg returns the string value of the optional single argument,
+// defaulting to the string value of the context node.
+func stringArg(name string, c *Context, args []Value) (string, error) {
+	if err := arity(name, args, 0, 1); err != nil {
+		return "", err
+	}
+	if len(args) == 0 {
+		return c.Node.Value(), nil
+	}
+	return String(args[0]), nil
+}
+
+func fnLast(c *Context, args []Value) (Value, error) {
+	if err := arity("last", args, 0, 0); err != nil {
+		return nil, err
+	}
+	return float64(c.Size), nil
+}
+
+func fnPosition(c *Context, args []Value) (Value, error) {
+	if err := arity("position", args, 0, 0); err != nil {
+		return nil, err
+	}
+	return float64(c.Position), nil
+}
+
+func fnCount(c *Context, args []Value) (Value, error) {
+	if err := arity("count", args, 1, 1); err != nil {
+		return nil, err
+	}
+	ns, err := nodeSetArg("count", args[0])
+	if err != nil {
+		return nil, err
+	}
+	return float64(len(ns)), nil
+}
+
+// fnID implements id().  Without a DTD there is no way to know which
+// attributes are IDs, so only xml:id attributes are considered.  If
+// several elements share an id, only the first one is returned.
+func fnID(c *Context, args []Value) (Value, error) {
+	if err := arity("id", args, 1, 1); err != nil {
+		return nil, err
+	}
+	ids := map[string]bool{}
+	if ns, ok := args[0].(NodeSet); ok {
+		for _, n := range ns {
+			for _, id := range strings.Fields(n.Value()) {
+				ids[id] = true
+			}
+		}
+	} else {
+		for _, id := range strings.Fields(String(args[0])) {
+			ids[id] = true
+		}
+	}
+	res := NodeSet{}
+	if c.index != nil && c.index.Root() == c.Node.root().elem {
+		for id := range ids {
+			if e := c.index.ByID(id); e != nil {
+				res = append(res, FromElement(e))
+			}
+		}
+		return c.order.sort(res), nil
+	}
+	for _, n := range descendants(c.Node.root(), nil) {
+		if n.kind != ElementNode {
+			continue
+		}
+		for _, a := range n.elem.Attributes {
+			if a.Name.Space == xmlURL && a.Name.Local == "id" && ids[a.Value] {
+				res = append(res, n)
+				delete(ids, a.Value)
+				break
+			}
+		}
+	}
+	return res, nil
+}
+
+func fnLocalName(c *Context, args []Value) (Value, error) {
+	n, ok, err := firstNode("local-name", c, args)
+	if err != nil || !ok {
+		return "", err
+	}
+	return n.Name().Local, nil
+}
+
+func fnNamespaceURI(c *Context, args []Value) (Value, error) {
+	n, ok, err := firstNode("namespace-uri", c, args)
+	if err != nil || !ok {
+		return "", err
+	}
+	return n.Name().Space, nil
+}
+
+// prefixFor finds the prefix bound to uri by the namespace declarations in
+// scope at n.
+func prefixFor(n Node, uri string) string {
+	if uri == xmlURL {
+		return "xml"
+	}
+	for e := n.elem; e != nil; e = e.Parent() {
+		for _, a := range e.Attributes {
+			if a.Name.Space == "xmlns" && a.Value == uri {
+				return a.Name.Local
+			}
+		}
+	}
+	return ""
+}
+
+func fnName(c *Context, args []Value) (Value, error) {
+	n, ok, err := firstNode("name", c, args)
+	if err != nil || !ok {
+		return "", err
+	}
+	name := n.Name()
+	if name.Space == "" {
+		return name.Local, nil
+	}
+	if prefix := prefixFor(n, name.Space); prefix != "" {
+		return prefix + ":" + name.Local, nil
+	}
+	return name.Local, nil
+}
+
+func fnString(c *Context, args []Value) (Value, error) {
+	if err := arity("string", args, 0, 1); err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return c.Node.Value(), nil
+	}
+	return String(args[0]), nil
+}
+
+func fnConcat(c *Context, args []Value) (Value, error) {
+	if err := arity("concat", args, 2, -1); err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	for _, a := range args {
+		b.WriteString(String(a))
+	}
+	return b.String(), nil
+}
+
+func fnStartsWith(c *Context, args []Value) (Value, error) {
+	if err := arity("starts-with", args, 2, 2); err != nil {
+		return nil, err
+	}
+	return strings.HasPrefix(String(args[0]), String(args[1])), nil
+}
+
+func fnContains(c *Context, args []Value) (Value, error) {
+	if err := arity("contains", args, 2, 2); err != nil {
+		return nil, err
+	}
+	return strings.Contains(String(args[0]), String(args[1])), nil
+}
+
+func fnSubstringBefore(c *Context, args []Value) (Value, error) {
+	if err := arity("substring-before", args, 2, 2); err != nil {
+		return nil, err
+	}
+	s, sep := String(args[0]), String(args[1])
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], nil
+	}
+	return "", nil
+}
+
+func fnSubstringAfter(c *Context, args []Value) (Value, error) {
+	if err := arity("substring-after", args, 2, 2); err != nil {
+		return nil, err
+	}
+	s, sep := String(args[0]), String(args[1])
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[i+len(sep):], nil
+	}
+	return "", nil
+}
+
+func fnSubstring(c *Context, args []Value) (Value, error) {
+	if err := arity("substring", args, 2, 3); err != nil {
+		return nil, err
+	}
+	runes := []rune(String(args[0]))
+	start := round(Number(args[1]))
+	end := math.Inf(1)
+	if len(args) == 3 {
+		end = start + round(Number(args[2]))
+	}
+	var b strings.Builder
+	for i, r := range runes {
+		if p := float64(i + 1); p >= start && p < end {
+			b.WriteRune(r)
+		}
+	}
+	return b.String(), nil
+}
+
+func fnStringLength(c *Context, args []Value) (Value, error) {
+	s, err := stringArg("string-length", c, args)
+	if err != nil {
+		return nil, err
+	}
+	return float64(utf8.RuneCountInString(s)), nil
+}
+
+func fnNormalizeSpace(c *Context, args []Value) (Value, error) {
+	s, err := stringArg("normalize-space", c, args)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Join(strings.Fields(s), " "), nil
+}
+
+func fnTranslate(c *Context, args []Value) (Value, error) {
+	if err := arity("translate", args, 3, 3); err != nil {
+		return nil, err
+	}
+	from, to := []rune(String(args[1])), []rune(String(args[2]))
+	mapping := map[rune]int{}
+	for i, r := range from {
+		if _, ok := mapping[r]; !ok {
+			mapping[r] = i
+		}
+	}
+	var b strings.Builder
+	for _, r := range String(args[0]) {
+		i, ok := mapping[r]
+		switch {
+		case !ok:
+			b.WriteRune(r)
+		case i < len(to):
+			b.WriteRune(to[i])
+		}
+	}
+	return b.String(), nil
+}
+
+func fnBoolean(c *Context, args []Value) (Value, error) {
+	if err := arity("boolean", args, 1, 1); err != nil {
+		return nil, err
+	}
+	return Boolean(args[0]), nil
+}
+
+func fnNot(c *Context, args []Value) (Value, error) {
+	if err := arity("not", args, 1, 1); err != nil {
+		return nil, err
+	}
+	return !Boolean(args[0]), nil
+}
+
+func fnTrue(c *Context, args []Value) (Value, error) {
+	if err := arity("true", args, 0, 0); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+func fnFalse(c *Context, args []Value) (Value, error) {
+	if err := arity("false", args, 0, 0); err != nil {
+		return nil, err
+	}
+	return false, nil
+}
+
+func fnLang(c *Context, args []Value) (Value, error) {
+	if err := arity("lang", args, 1, 1); err != nil {
+		return nil, err
+	}
+	want := strings.ToLower(String(args[0]))
+	for e := c.Node.elem; e != nil; e = e.Parent() {
+		for _, a := range e.Attributes {
+			if a.Name.Space == xmlURL && a.Name.Local == "lang" {
+				have := strings.ToLower(a.Value)
+				return have == want || strings.HasPrefix(have, want+"-"), nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func fnNumber(c *Context, args []Value) (Value, error) {
+	if err := arity("number", args, 0, 1); err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return parseNumber(c.Node.Value()), nil
+	}
+	return Number(args[0]), nil
+}
+
+func fnSum(c *Context, args []Value) (Value, error) {
+	if err := arity("sum", args, 1, 1); err != nil {
+		return nil, err
+	}
+	ns, err := nodeSetArg("sum", args[0])
+	if err != nil {
+		return nil, err
+	}
+	total := 0.0
+	for _, n := range ns {
+		total += parseNumber(n.Value())
+	}
+	return total, nil
+}
+
+func fnFloor(c *Context, args []Value) (Value, error) {
+	if err := arity("floor", args, 1, 1); err != nil {
+		return nil, err
+	}
+	return math.Floor(Number(args[0])), nil
+}
+
+func fnCeiling(c *Context, args []Value) (Value, error) {
+	if err := arity("ceiling", args, 1, 1); err != nil {
+		return nil, err
+	}
+	return math.Ceil(Number(args[0])), nil
+}
+
+// round implements the rounding rules of the XPath round() function,
+// which rounds halves towards positive infinity.
+func round(f float64) float64 {
+	if math.IsNaN(f) || math.IsInf(f, 0) || f == 0 {
+		return f
+	}
+	if f < 0 && f >= -0.5 {
+		return math.Copysign(0, -1)
+	}
+	return math.Floor(f + 0.5)
+}
+
+func fnRound(c *Context, args []Value) (Value, error) {
+	if err := arity("round", args, 1, 1); err != nil {
+		return nil, err
+	}
+	return round(Number(args[0])), nil
+}
@@ -0,0 +1,208 @@
+package xpath
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokName
+	tokNumber
+	tokLiteral
+	tokVariable
+	tokOperator
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	val  string
+	pos  int
+}
+
+func isNameStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isNameChar(r rune) bool {
+	return isNameStart(r) || r == '-' || r == '.' || unicode.IsDigit(r) ||
+		unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Mc, r)
+}
+
+// operatorNames are the NCNames that are treated as operators when they
+// follow something that can end an operand.
+var operatorNames = map[string]bool{
+	"and": true,
+	"or":  true,
+	"div": true,
+	"mod": true,
+}
+
+// precedesOperand reports whether, according to the lexical rules in
+// section 3.7 of the XPath 1.0 spec, a following '*' or NCName must be
+// read as an operator rather than a name test.
+func precedesOperand(prev *token) bool {
+	if prev == nil {
+		return false
+	}
+	switch prev.kind {
+	case tokOperator:
+		return false
+	case tokPunct:
+		switch prev.val {
+		case "@", "::", "(", "[", ",":
+			return false
+		}
+	}
+	return true
+}
+
+func scanName(s string, i int) int {
+	r, w := utf8.DecodeRuneInString(s[i:])
+	if !isNameStart(r) {
+		return i
+	}
+	i += w
+	for i < len(s) {
+		r, w = utf8.DecodeRuneInString(s[i:])
+		if !isNameChar(r) {
+			break
+		}
+		i += w
+	}
+	return i
+}
+
+func lex(s string) ([]token, error) {
+	toks := []token{}
+	var prev *token
+	i := 0
+	for {
+		for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\n' || s[i] == '\r') {
+			i++
+		}
+		if len(toks) > 0 {
+			prev = &toks[len(toks)-1]
+		}
+		if i >= len(s) {
+			toks = append(toks, token{kind: tokEOF, pos: i})
+			return toks, nil
+		}
+		start := i
+		c := s[i]
+		switch {
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(s) && s[end] != c {
+				end++
+			}
+			if end >= len(s) {
+				return nil, fmt.Errorf("xpath: unterminated literal at offset %d", start)
+			}
+			toks = append(toks, token{kind: tokLiteral, val: s[i+1 : end], pos: start})
+			i = end + 1
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9':
+			for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+				i++
+			}
+			if i < len(s) && s[i] == '.' {
+				i++
+				for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+					i++
+				}
+			}
+			toks = append(toks, token{kind: tokNumber, val: s[start:i], pos: start})
+		case c == '$':
+			i++
+			end := scanQName(s, i)
+			if end == i {
+				return nil, fmt.Errorf("xpath: expected variable name at offset %d", start)
+			}
+			toks = append(toks, token{kind: tokVariable, val: s[i:end], pos: start})
+			i = end
+		case c == '*':
+			i++
+			if precedesOperand(prev) {
+				toks = append(toks, token{kind: tokOperator, val: "*", pos: start})
+			} else {
+				toks = append(toks, token{kind: tokName, val: "*", pos: start})
+			}
+		case c == '/':
+			i++
+			if i < len(s) && s[i] == '/' {
+				i++
+				toks = append(toks, token{kind: tokOperator, val: "//", pos: start})
+			} else {
+				toks = append(toks, token{kind: tokOperator, val: "/", pos: start})
+			}
+		case c == '|' || c == '+' || c == '-' || c == '=':
+			i++
+			toks = append(toks, token{kind: tokOperator, val: string(c), pos: start})
+		case c == '!':
+			if i+1 >= len(s) || s[i+1] != '=' {
+				return nil, fmt.Errorf("xpath: unexpected '!' at offset %d", start)
+			}
+			i += 2
+			toks = append(toks, token{kind: tokOperator, val: "!=", pos: start})
+		case c == '<' || c == '>':
+			i++
+			op := string(c)
+			if i < len(s) && s[i] == '=' {
+				i++
+				op += "="
+			}
+			toks = append(toks, token{kind: tokOperator, val: op, pos: start})
+		case c == '.':
+			i++
+			if i < len(s) && s[i] == '.' {
+				i++
+				toks = append(toks, token{kind: tokPunct, val: "..", pos: start})
+			} else {
+				toks = append(toks, token{kind: tokPunct, val: ".", pos: start})
+			}
+		case c == ':':
+			if i+1 >= len(s) || s[i+1] != ':' {
+				return nil, fmt.Errorf("xpath: unexpected ':' at offset %d", start)
+			}
+			i += 2
+			toks = append(toks, token{kind: tokPunct, val: "::", pos: start})
+		case c == '(' || c == ')' || c == '[' || c == ']' || c == '@' || c == ',':
+			i++
+			toks = append(toks, token{kind: tokPunct, val: string(c), pos: start})
+		default:
+			end := scanQName(s, i)
+			if end == i {
+				return nil, fmt.Errorf("xpath: unexpected character %q at offset %d", s[i], start)
+			}
+			name := s[i:end]
+			i = end
+			if operatorNames[name] && precedesOperand(prev) {
+				toks = append(toks, token{kind: tokOperator, val: name, pos: start})
+			} else {
+				toks = append(toks, token{kind: tokName, val: name, pos: start})
+			}
+		}
+	}
+}
+
+// scanQName scans an NCName, a QName, or a prefix:* name test starting
+// at i and returns the offset just past it.
+func scanQName(s string, i int) int {
+	end := scanName(s, i)
+	if end == i {
+		return i
+	}
+	if end+1 < len(s) && s[end] == ':' && s[end+1] != ':' {
+		if s[end+1] == '*' {
+			return end + 2
+		}
+		if local := scanName(s, end+1); local > end+1 {
+			return local
+		}
+	}
+	return end
+}
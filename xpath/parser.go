@@ -0,0 +1,446 @@
+package xpath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type axis int
+
+const (
+	axisChild axis = iota
+	axisDescendant
+	axisDescendantOrSelf
+	axisSelf
+	axisParent
+	axisAncestor
+	axisAncestorOrSelf
+	axisFollowingSibling
+	axisPrecedingSibling
+	axisFollowing
+	axisPreceding
+	axisAttribute
+	axisNamespace
+)
+
+var axisNames = map[string]axis{
+	"child":              axisChild,
+	"descendant":         axisDescendant,
+	"descendant-or-self": axisDescendantOrSelf,
+	"self":               axisSelf,
+	"parent":             axisParent,
+	"ancestor":           axisAncestor,
+	"ancestor-or-self":   axisAncestorOrSelf,
+	"following-sibling":  axisFollowingSibling,
+	"preceding-sibling":  axisPrecedingSibling,
+	"following":          axisFollowing,
+	"preceding":          axisPreceding,
+	"attribute":          axisAttribute,
+	"namespace":          axisNamespace,
+}
+
+func (a axis) reverse() bool {
+	switch a {
+	case axisParent, axisAncestor, axisAncestorOrSelf, axisPrecedingSibling, axisPreceding:
+		return true
+	}
+	return false
+}
+
+type testKind int
+
+const (
+	testName testKind = iota
+	testNode
+	testText
+	testComment
+	testPI
+)
+
+var nodeTypeTests = map[string]testKind{
+	"node":                   testNode,
+	"text":                   testText,
+	"comment":                testComment,
+	"processing-instruction": testPI,
+}
+
+// nodeTest is a parsed node test.  For name tests, prefix is the
+// unresolved namespace prefix and local is the local name or "*".
+type nodeTest struct {
+	kind   testKind
+	prefix string
+	local  string
+}
+
+type step struct {
+	axis  axis
+	test  nodeTest
+	preds []expr
+}
+
+// The expression tree.  Each node knows how to evaluate itself.
+type expr interface {
+	eval(c *Context) (Value, error)
+}
+
+type (
+	binaryExpr struct {
+		op          string
+		left, right expr
+	}
+	negExpr struct {
+		operand expr
+	}
+	literalExpr string
+	numberExpr  float64
+	varExpr     string
+	callExpr    struct {
+		name string
+		args []expr
+	}
+	filterExpr struct {
+		primary expr
+		preds   []expr
+	}
+	pathExpr struct {
+		// start is nil for location paths.
+		start    expr
+		absolute bool
+		steps    []*step
+	}
+)
+
+type parser struct {
+	toks []token
+	pos  int
+	src  string
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) peekN(n int) token {
+	if p.pos+n >= len(p.toks) {
+		return p.toks[len(p.toks)-1]
+	}
+	return p.toks[p.pos+n]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) is(kind tokenKind, val string) bool {
+	t := p.peek()
+	return t.kind == kind && t.val == val
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("xpath: %s at offset %d in %q",
+		fmt.Sprintf(format, args...), p.peek().pos, p.src)
+}
+
+func (p *parser) expect(kind tokenKind, val string) error {
+	if !p.is(kind, val) {
+		return p.errorf("expected %q", val)
+	}
+	p.next()
+	return nil
+}
+
+func parse(src string) (expr, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks, src: src}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEOF {
+		return nil, p.errorf("unexpected %q", p.peek().val)
+	}
+	return e, nil
+}
+
+// parseBinary parses a left-associative chain of operators in ops, with
+// operands parsed by sub.
+func (p *parser) parseBinary(sub func() (expr, error), ops ...string) (expr, error) {
+	left, err := sub()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokOperator {
+			return left, nil
+		}
+		found := false
+		for _, op := range ops {
+			if t.val == op {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return left, nil
+		}
+		p.next()
+		right, err := sub()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: t.val, left: left, right: right}
+	}
+}
+
+func (p *parser) parseOr() (expr, error) {
+	return p.parseBinary(p.parseAnd, "or")
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	return p.parseBinary(p.parseEquality, "and")
+}
+
+func (p *parser) parseEquality() (expr, error) {
+	return p.parseBinary(p.parseRelational, "=", "!=")
+}
+
+func (p *parser) parseRelational() (expr, error) {
+	return p.parseBinary(p.parseAdditive, "<", "<=", ">", ">=")
+}
+
+func (p *parser) parseAdditive() (expr, error) {
+	return p.parseBinary(p.parseMultiplicative, "+", "-")
+}
+
+func (p *parser) parseMultiplicative() (expr, error) {
+	return p.parseBinary(p.parseUnary, "*", "div", "mod")
+}
+
+func (p *parser) parseUnary() (expr, error) {
+	if p.is(tokOperator, "-") {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &negExpr{operand: operand}, nil
+	}
+	return p.parseUnion()
+}
+
+func (p *parser) parseUnion() (expr, error) {
+	return p.parseBinary(p.parsePath, "|")
+}
+
+// startsStep reports whether the current token can begin a location step.
+func (p *parser) startsStep() bool {
+	t := p.peek()
+	switch t.kind {
+	case tokName:
+		next := p.peekN(1)
+		if next.kind == tokPunct && next.val == "(" {
+			_, isNodeType := nodeTypeTests[t.val]
+			return isNodeType
+		}
+		return true
+	case tokPunct:
+		return t.val == "@" || t.val == "." || t.val == ".."
+	}
+	return false
+}
+
+func (p *parser) parsePath() (expr, error) {
+	path := &pathExpr{}
+	switch {
+	case p.is(tokOperator, "/"):
+		p.next()
+		path.absolute = true
+		if !p.startsStep() {
+			return path, nil
+		}
+	case p.is(tokOperator, "//"):
+		p.next()
+		path.absolute = true
+		path.steps = append(path.steps, descendantOrSelfStep())
+	case p.startsStep():
+	default:
+		primary, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		preds, err := p.parsePredicates()
+		if err != nil {
+			return nil, err
+		}
+		var start expr = primary
+		if len(preds) > 0 {
+			start = &filterExpr{primary: primary, preds: preds}
+		}
+		if !p.is(tokOperator, "/") && !p.is(tokOperator, "//") {
+			return start, nil
+		}
+		path.start = start
+		if p.next().val == "//" {
+			path.steps = append(path.steps, descendantOrSelfStep())
+		}
+	}
+	if err := p.parseRelativePath(path); err != nil {
+		return nil, err
+	}
+	return path, nil
+}
+
+func descendantOrSelfStep() *step {
+	return &step{axis: axisDescendantOrSelf, test: nodeTest{kind: testNode}}
+}
+
+func (p *parser) parseRelativePath(path *pathExpr) error {
+	for {
+		s, err := p.parseStep()
+		if err != nil {
+			return err
+		}
+		path.steps = append(path.steps, s)
+		switch {
+		case p.is(tokOperator, "/"):
+			p.next()
+		case p.is(tokOperator, "//"):
+			p.next()
+			path.steps = append(path.steps, descendantOrSelfStep())
+		default:
+			return nil
+		}
+	}
+}
+
+func (p *parser) parseStep() (*step, error) {
+	if p.is(tokPunct, ".") {
+		p.next()
+		return &step{axis: axisSelf, test: nodeTest{kind: testNode}}, nil
+	}
+	if p.is(tokPunct, "..") {
+		p.next()
+		return &step{axis: axisParent, test: nodeTest{kind: testNode}}, nil
+	}
+	s := &step{axis: axisChild}
+	if p.is(tokPunct, "@") {
+		p.next()
+		s.axis = axisAttribute
+	} else if t := p.peek(); t.kind == tokName && p.peekN(1).kind == tokPunct && p.peekN(1).val == "::" {
+		a, ok := axisNames[t.val]
+		if !ok {
+			return nil, p.errorf("unknown axis %q", t.val)
+		}
+		s.axis = a
+		p.next()
+		p.next()
+	}
+	t := p.peek()
+	if t.kind != tokName {
+		return nil, p.errorf("expected node test")
+	}
+	p.next()
+	if kind, ok := nodeTypeTests[t.val]; ok && p.is(tokPunct, "(") {
+		p.next()
+		if kind == testPI && p.peek().kind == tokLiteral {
+			p.next()
+		}
+		if err := p.expect(tokPunct, ")"); err != nil {
+			return nil, err
+		}
+		s.test = nodeTest{kind: kind}
+	} else {
+		s.test = nodeTest{kind: testName, local: t.val}
+		if i := strings.IndexByte(t.val, ':'); i >= 0 {
+			s.test.prefix = t.val[:i]
+			s.test.local = t.val[i+1:]
+		}
+	}
+	preds, err := p.parsePredicates()
+	if err != nil {
+		return nil, err
+	}
+	s.preds = preds
+	return s, nil
+}
+
+func (p *parser) parsePredicates() ([]expr, error) {
+	var preds []expr
+	for p.is(tokPunct, "[") {
+		p.next()
+		pred, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokPunct, "]"); err != nil {
+			return nil, err
+		}
+		preds = append(preds, pred)
+	}
+	return preds, nil
+}
+
+func (p *parser) parsePrimary() (expr, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokLiteral:
+		p.next()
+		return literalExpr(t.val), nil
+	case tokNumber:
+		p.next()
+		f, err := strconv.ParseFloat(t.val, 64)
+		if err != nil {
+			return nil, p.errorf("bad number %q", t.val)
+		}
+		return numberExpr(f), nil
+	case tokVariable:
+		p.next()
+		return varExpr(t.val), nil
+	case tokPunct:
+		if t.val == "(" {
+			p.next()
+			e, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(tokPunct, ")"); err != nil {
+				return nil, err
+			}
+			return e, nil
+		}
+	case tokName:
+		if p.peekN(1).kind == tokPunct && p.peekN(1).val == "(" {
+			p.next()
+			p.next()
+			call := &callExpr{name: t.val}
+			for !p.is(tokPunct, ")") {
+				arg, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				call.args = append(call.args, arg)
+				if !p.is(tokPunct, ",") {
+					break
+				}
+				p.next()
+			}
+			if err := p.expect(tokPunct, ")"); err != nil {
+				return nil, err
+			}
+			return call, nil
+		}
+	}
+	if t.kind == tokEOF {
+		return nil, p.errorf("unexpected end of expression")
+	}
+	return nil, p.errorf("unexpected %q", t.val)
+}
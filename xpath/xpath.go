@@ -0,0 +1,595 @@
+// Package xpath implements XPath 1.0 expressions over simplexml/dom trees.
+//
+// The dom package does not keep text, comment or processing instruction
+// nodes, so the data model used here is a little smaller than the one in
+// the XPath spec:
+//
+// 1. Every element with non-empty Content has a single text node child,
+// which comes before any element children in document order.
+//
+// 2. Namespace declarations (xmlns and xmlns:* attributes) are not
+// attributes, and the namespace axis is always empty.
+//
+// 3. comment() and processing-instruction() never match anything.
+//
+// Unprefixed names in expressions only match elements and attributes that
+// are not in a namespace.  To match namespaced names, bind a prefix in the
+// Env used for evaluation.
+//
+// For some basic usage examples, see xpath_test.go
+package xpath
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+// NodeType is the type of a Node.
+type NodeType int
+
+const (
+	// RootNode is the root of a tree.  Its only child is the
+	// topmost Element of the tree.
+	RootNode NodeType = iota
+	// ElementNode is an Element.
+	ElementNode
+	// AttributeNode is one of the Attributes of an Element.
+	AttributeNode
+	// TextNode is the Content of an Element.
+	TextNode
+)
+
+// Node is a node in the XPath data model.  Nodes are comparable, and
+// two Nodes are equal if they refer to the same node in the same tree.
+type Node struct {
+	kind NodeType
+	elem *dom.Element
+	attr int
+}
+
+// FromElement returns the Node for e.
+func FromElement(e *dom.Element) Node {
+	return Node{kind: ElementNode, elem: e}
+}
+
+// FromDocument returns the root Node of doc.
+func FromDocument(doc *dom.Document) Node {
+	return Node{kind: RootNode, elem: doc.Root()}
+}
+
+// Type returns the type of this node.
+func (n Node) Type() NodeType {
+	return n.kind
+}
+
+// Element returns the Element this node refers to.  For attribute and
+// text nodes this is the Element that owns them, for the root node it is
+// the topmost Element of the tree (which can be nil for an empty
+// Document).
+func (n Node) Element() *dom.Element {
+	return n.elem
+}
+
+// Attr returns the attribute an AttributeNode refers to.  For any other
+// type of node, it returns the zero xml.Attr.
+func (n Node) Attr() xml.Attr {
+	if n.kind != AttributeNode {
+		return xml.Attr{}
+	}
+	return n.elem.Attributes[n.attr]
+}
+
+// Name returns the expanded name of the node.  Only elements and
+// attributes have names.
+func (n Node) Name() xml.Name {
+	switch n.kind {
+	case ElementNode:
+		return n.elem.Name
+	case AttributeNode:
+		return n.elem.Attributes[n.attr].Name
+	}
+	return xml.Name{}
+}
+
+// Value returns the string-value of the node, as defined by the XPath
+// spec.
+func (n Node) Value() string {
+	switch n.kind {
+	case AttributeNode:
+		return n.elem.Attributes[n.attr].Value
+	case TextNode:
+		return string(n.elem.Content)
+	}
+	if n.elem == nil {
+		return ""
+	}
+	var b strings.Builder
+	var walk func(e *dom.Element)
+	walk = func(e *dom.Element) {
+		b.Write(e.Content)
+		for _, c := range e.Children() {
+			walk(c)
+		}
+	}
+	walk(n.elem)
+	return b.String()
+}
+
+func isNamespaceDecl(a xml.Attr) bool {
+	return a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns")
+}
+
+func (n Node) root() Node {
+	if n.elem == nil {
+		return n
+	}
+	top := n.elem
+	for top.Parent() != nil {
+		top = top.Parent()
+	}
+	return Node{kind: RootNode, elem: top}
+}
+
+func (n Node) parent() (Node, bool) {
+	switch n.kind {
+	case RootNode:
+		return Node{}, false
+	case ElementNode:
+		if p := n.elem.Parent(); p != nil {
+			return Node{kind: ElementNode, elem: p}, true
+		}
+		return Node{kind: RootNode, elem: n.elem}, true
+	}
+	return Node{kind: ElementNode, elem: n.elem}, true
+}
+
+func (n Node) children() []Node {
+	switch n.kind {
+	case RootNode:
+		if n.elem == nil {
+			return nil
+		}
+		return []Node{{kind: ElementNode, elem: n.elem}}
+	case ElementNode:
+		kids := n.elem.Children()
+		res := make([]Node, 0, len(kids)+1)
+		if len(n.elem.Content) > 0 {
+			res = append(res, Node{kind: TextNode, elem: n.elem})
+		}
+		for _, c := range kids {
+			res = append(res, Node{kind: ElementNode, elem: c})
+		}
+		return res
+	}
+	return nil
+}
+
+func (n Node) attributes() []Node {
+	if n.kind != ElementNode {
+		return nil
+	}
+	res := []Node{}
+	for i, a := range n.elem.Attributes {
+		if !isNamespaceDecl(a) {
+			res = append(res, Node{kind: AttributeNode, elem: n.elem, attr: i})
+		}
+	}
+	return res
+}
+
+// NodeSet is an unordered collection of Nodes.  NodeSets returned from
+// evaluating an expression are always in document order and contain no
+// duplicates.
+type NodeSet []Node
+
+// Elements returns the Elements in ns, skipping any nodes that are not
+// elements.
+func (ns NodeSet) Elements() []*dom.Element {
+	res := []*dom.Element{}
+	for _, n := range ns {
+		if n.kind == ElementNode {
+			res = append(res, n.elem)
+		}
+	}
+	return res
+}
+
+// Value is the result of evaluating an expression or calling a Function.
+// It is one of NodeSet, string, float64, or bool.
+type Value interface{}
+
+// String converts v to a string following the rules of the XPath string()
+// function.
+func String(v Value) string {
+	switch rv := v.(type) {
+	case NodeSet:
+		if len(rv) == 0 {
+			return ""
+		}
+		return rv[0].Value()
+	case string:
+		return rv
+	case float64:
+		return formatNumber(rv)
+	case bool:
+		if rv {
+			return "true"
+		}
+		return "false"
+	}
+	return ""
+}
+
+// Number converts v to a float64 following the rules of the XPath
+// number() function.
+func Number(v Value) float64 {
+	switch rv := v.(type) {
+	case NodeSet:
+		return parseNumber(String(rv))
+	case string:
+		return parseNumber(rv)
+	case float64:
+		return rv
+	case bool:
+		if rv {
+			return 1
+		}
+		return 0
+	}
+	return math.NaN()
+}
+
+// Boolean converts v to a bool following the rules of the XPath boolean()
+// function.
+func Boolean(v Value) bool {
+	switch rv := v.(type) {
+	case NodeSet:
+		return len(rv) > 0
+	case string:
+		return len(rv) > 0
+	case float64:
+		return rv != 0 && !math.IsNaN(rv)
+	case bool:
+		return rv
+	}
+	return false
+}
+
+var numberRE = regexp.MustCompile(`^-?([0-9]+(\.[0-9]*)?|\.[0-9]+)$`)
+
+func parseNumber(s string) float64 {
+	s = strings.Trim(s, " \t\r\n")
+	if !numberRE.MatchString(s) {
+		return math.NaN()
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return f
+}
+
+func formatNumber(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	case f == 0:
+		return "0"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// normalize converts the result of a Function into one of the types a
+// Value can have.
+func normalize(v interface{}) (Value, error) {
+	switch rv := v.(type) {
+	case NodeSet, string, float64, bool:
+		return rv, nil
+	case []Node:
+		return NodeSet(rv), nil
+	case Node:
+		return NodeSet{rv}, nil
+	case *dom.Element:
+		return NodeSet{FromElement(rv)}, nil
+	case []*dom.Element:
+		res := make(NodeSet, len(rv))
+		for i, e := range rv {
+			res[i] = FromElement(e)
+		}
+		return res, nil
+	case int:
+		return float64(rv), nil
+	case int64:
+		return float64(rv), nil
+	case float32:
+		return float64(rv), nil
+	}
+	return nil, fmt.Errorf("xpath: cannot use %T as a value", v)
+}
+
+// A Function is a Go function that can be called from an expression.
+// args holds the already-evaluated arguments of the call.  The returned
+// value should be one of the types a Value can have, although ints and
+// Elements are converted as a convenience.
+type Function func(c *Context, args []Value) (Value, error)
+
+// Env holds the bindings that an expression is evaluated with.
+type Env struct {
+	// Namespaces maps the prefixes used in name tests to namespace URIs.
+	// The xml prefix is always bound.
+	Namespaces map[string]string
+	// Functions maps function names, as written in expressions, to the Go
+	// functions that implement them.  Functions are looked up here before
+	// the XPath core function library.
+	Functions map[string]Function
+	// Variables maps variable names, without the leading $, to their
+	// values.  Values are converted the same way Function results are.
+	Variables map[string]interface{}
+}
+
+// NewEnv creates a new, empty Env.
+func NewEnv() *Env {
+	return &Env{
+		Namespaces: map[string]string{},
+		Functions:  map[string]Function{},
+		Variables:  map[string]interface{}{},
+	}
+}
+
+// Namespace binds prefix to uri.  The return value is env.
+func (env *Env) Namespace(prefix, uri string) *Env {
+	if env.Namespaces == nil {
+		env.Namespaces = map[string]string{}
+	}
+	env.Namespaces[prefix] = uri
+	return env
+}
+
+// Func registers fn as a function callable from expressions.  name is the
+// function name as it will be written in expressions, including any
+// prefix, so after:
+//    env.Func("my:normalize-id", normalizeID)
+// the expression
+//    //item[my:normalize-id(@id) = 'abc']
+// will call normalizeID with the id attribute of each item.
+// The return value is env.
+func (env *Env) Func(name string, fn Function) *Env {
+	if env.Functions == nil {
+		env.Functions = map[string]Function{}
+	}
+	env.Functions[name] = fn
+	return env
+}
+
+// Var binds the variable $name to v, so that values supplied at
+// evaluation time never have to be spliced into the expression text:
+//    x := xpath.MustCompile("//user[@name = $name]")
+//    x.Select(root, xpath.NewEnv().Var("name", untrusted))
+// The return value is env.
+func (env *Env) Var(name string, v interface{}) *Env {
+	if env.Variables == nil {
+		env.Variables = map[string]interface{}{}
+	}
+	env.Variables[name] = v
+	return env
+}
+
+// Clone returns a copy of env whose bindings can be changed without
+// affecting env.
+func (env *Env) Clone() *Env {
+	res := NewEnv()
+	if env == nil {
+		return res
+	}
+	for k, v := range env.Namespaces {
+		res.Namespaces[k] = v
+	}
+	for k, v := range env.Functions {
+		res.Functions[k] = v
+	}
+	for k, v := range env.Variables {
+		res.Variables[k] = v
+	}
+	return res
+}
+
+func (env *Env) variable(name string) (Value, error) {
+	var v interface{}
+	found := false
+	if env != nil {
+		v, found = env.Variables[name]
+	}
+	if !found {
+		return nil, fmt.Errorf("xpath: variable $%s is not bound", name)
+	}
+	return normalize(v)
+}
+
+const xmlURL = "http://www.w3.org/XML/1998/namespace"
+
+func (env *Env) resolve(prefix string) (string, error) {
+	if env != nil {
+		if uri, ok := env.Namespaces[prefix]; ok {
+			return uri, nil
+		}
+	}
+	if prefix == "xml" {
+		return xmlURL, nil
+	}
+	return "", fmt.Errorf("xpath: namespace prefix %q is not bound", prefix)
+}
+
+func (env *Env) function(name string) (Function, bool) {
+	if env != nil {
+		if fn, ok := env.Functions[name]; ok {
+			return fn, true
+		}
+	}
+	fn, ok := coreFunctions[name]
+	return fn, ok
+}
+
+// Context is the context an expression or Function is evaluated in.
+type Context struct {
+	// Node is the context node.
+	Node Node
+	// Position is the context position, starting from 1.
+	Position int
+	// Size is the context size.
+	Size  int
+	env   *Env
+	order *docOrder
+	index *dom.Index
+}
+
+// Env returns the Env the expression is being evaluated with.  It can
+// be nil.
+func (c *Context) Env() *Env {
+	return c.env
+}
+
+func (c *Context) with(n Node, pos, size int) *Context {
+	return &Context{Node: n, Position: pos, Size: size, env: c.env, order: c.order, index: c.index}
+}
+
+// Expr is a compiled XPath expression.  An Expr is safe for concurrent
+// use.
+type Expr struct {
+	src  string
+	root expr
+}
+
+// Compile parses an XPath expression.
+func Compile(src string) (*Expr, error) {
+	root, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+// MustCompile is like Compile, but it panics if the expression cannot be
+// parsed.
+func MustCompile(src string) *Expr {
+	x, err := Compile(src)
+	if err != nil {
+		panic(err)
+	}
+	return x
+}
+
+// String returns the source text of the expression.
+func (x *Expr) String() string {
+	return x.src
+}
+
+// Evaluate evaluates the expression with n as the context node.
+// env may be nil.
+func (x *Expr) Evaluate(n Node, env *Env) (Value, error) {
+	c := &Context{Node: n, Position: 1, Size: 1, env: env, order: newDocOrder()}
+	return x.root.eval(c)
+}
+
+// EvaluateAt is like Evaluate, but with the given context position and
+// size, for when n is one of size nodes being processed in turn.
+func (x *Expr) EvaluateAt(n Node, pos, size int, env *Env) (Value, error) {
+	c := &Context{Node: n, Position: pos, Size: size, env: env, order: newDocOrder()}
+	return x.root.eval(c)
+}
+
+// EvaluateDocument evaluates the expression with the root of doc as the
+// context node.  If doc has indexing enabled, its Index is used to speed
+// up //name steps and the id() function.
+func (x *Expr) EvaluateDocument(doc *dom.Document, env *Env) (Value, error) {
+	c := &Context{Node: FromDocument(doc), Position: 1, Size: 1, env: env, order: newDocOrder()}
+	c.index = doc.Index()
+	return x.root.eval(c)
+}
+
+// SelectDocument is like Select, but evaluates the expression against doc
+// the same way EvaluateDocument does.
+func (x *Expr) SelectDocument(doc *dom.Document, env *Env) ([]*dom.Element, error) {
+	v, err := x.EvaluateDocument(doc, env)
+	if err != nil {
+		return nil, err
+	}
+	ns, ok := v.(NodeSet)
+	if !ok {
+		return nil, fmt.Errorf("xpath: %q does not evaluate to a node-set", x.src)
+	}
+	return ns.Elements(), nil
+}
+
+// Nodes evaluates the expression with n as the context node, and returns
+// the resulting NodeSet.  It is an error if the expression does not
+// evaluate to a NodeSet.
+func (x *Expr) Nodes(n Node, env *Env) (NodeSet, error) {
+	v, err := x.Evaluate(n, env)
+	if err != nil {
+		return nil, err
+	}
+	ns, ok := v.(NodeSet)
+	if !ok {
+		return nil, fmt.Errorf("xpath: %q does not evaluate to a node-set", x.src)
+	}
+	return ns, nil
+}
+
+// Select evaluates the expression with e as the context node, and returns
+// the Elements in the resulting NodeSet in document order.
+func (x *Expr) Select(e *dom.Element, env *Env) ([]*dom.Element, error) {
+	ns, err := x.Nodes(FromElement(e), env)
+	if err != nil {
+		return nil, err
+	}
+	return ns.Elements(), nil
+}
+
+// Select compiles expr and evaluates it against e.  It is equivalent to:
+//    x, err := Compile(expr)
+//    x.Select(e, nil)
+func Select(e *dom.Element, expr string) ([]*dom.Element, error) {
+	x, err := Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return x.Select(e, nil)
+}
+
+// RemoveAll evaluates the expression with e as the context node, and
+// removes the Elements in the resulting NodeSet from the tree, as
+// dom.Element.RemoveAll does.  e itself is never removed.  The removed
+// Elements are returned in document order.
+func (x *Expr) RemoveAll(e *dom.Element, env *Env) ([]*dom.Element, error) {
+	selected, err := x.Select(e, env)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[*dom.Element]bool, len(selected))
+	for _, s := range selected {
+		set[s] = true
+	}
+	return e.RemoveAll(func(c *dom.Element) bool { return set[c] }), nil
+}
+
+// RemoveAll compiles expr and removes what it selects from e.  It is
+// equivalent to:
+//    x, err := Compile(expr)
+//    x.RemoveAll(e, nil)
+func RemoveAll(e *dom.Element, expr string) ([]*dom.Element, error) {
+	x, err := Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return x.RemoveAll(e, nil)
+}
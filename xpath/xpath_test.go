@@ -0,0 +1,305 @@
+package xpath
+
+import (
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+var testDoc string = `<?xml version="1.0" encoding="UTF-8"?>
+<a:root idx="0" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing">
+ <node1 foo="bar" idx="1">
+  <sub idx="4"/>
+ </node1>
+ <node2 order="0" idx="2">I am Node 2
+  <node2 order="2" idx="5">I am Groot</node2>
+ </node2>
+ <node2 order="1" idx="3">I am a different Node 2</node2>
+</a:root>
+`
+
+const addressing = "http://schemas.xmlsoap.org/ws/2004/08/addressing"
+
+func parseDoc() *dom.Document {
+	doc, err := dom.Parse(strings.NewReader(testDoc))
+	if err != nil {
+		log.Panicf("Cannot parse test document. Error: %v", err)
+	}
+	return doc
+}
+
+func env() *Env {
+	return NewEnv().Namespace("a", addressing)
+}
+
+func idxs(elems []*dom.Element) string {
+	res := []string{}
+	for _, e := range elems {
+		for _, a := range e.Attributes {
+			if a.Name.Local == "idx" {
+				res = append(res, a.Value)
+			}
+		}
+	}
+	return strings.Join(res, ",")
+}
+
+var selectTests = []struct {
+	expr string
+	idxs string
+}{
+	{"/a:root", "0"},
+	{"/a:root/*", "1,2,3"},
+	{"//node2", "2,5,3"},
+	{"//node2[@order='1']", "3"},
+	{"//node2[2]", "3"},
+	{"(//node2)[2]", "5"},
+	{"//node2[last()]", "5,3"},
+	{"(//node2)[last()]", "3"},
+	{"//*[@foo]", "1"},
+	{"//sub/..", "1"},
+	{"//sub/ancestor::*", "0,1"},
+	{"//sub/ancestor::*[1]", "1"},
+	{"/a:root/node1/following-sibling::*", "2,3"},
+	{"/a:root/node2[2]/preceding-sibling::*[1]", "2"},
+	{"//sub/following::*", "2,5,3"},
+	{"//node2[node2]", "2"},
+	{"//node2[contains(., 'Groot')]", "2,5"},
+	{"//*[text()='I am Groot']", "5"},
+	{"//node1 | //sub", "1,4"},
+	{"//*[@idx > 2 and @idx < 5]", "4,3"},
+	{"//*[@idx mod 2 = 1]", "1,5,3"},
+	{"//*[local-name() = 'root']", "0"},
+	{"//*[namespace-uri() != '']", "0"},
+	{"//*[name() = 'a:root']", "0"},
+	{"//node2[not(@order = '0')]", "5,3"},
+	{"//node2[@order = '2']", "5"},
+	{"//*[@order = '1']", "3"},
+	{"//*['0' = @order]/node2", "5"},
+	{"//node2[@order = '0'][1]", "2"},
+	{"//node2[1]", "2,5"},
+	{"//a:root", "0"},
+	{"//*[@foo='bar']/sub", "4"},
+}
+
+func TestSelect(t *testing.T) {
+	doc := parseDoc()
+	for _, test := range selectTests {
+		x, err := Compile(test.expr)
+		if err != nil {
+			t.Errorf("Cannot compile %s: %v", test.expr, err)
+			continue
+		}
+		res, err := x.Select(doc.Root(), env())
+		if err != nil {
+			t.Errorf("Cannot evaluate %s: %v", test.expr, err)
+			continue
+		}
+		if got := idxs(res); got != test.idxs {
+			t.Errorf("Expected %s to select %s, got %s", test.expr, test.idxs, got)
+		}
+	}
+}
+
+func TestSelectIndexed(t *testing.T) {
+	doc := parseDoc()
+	doc.EnableIndex()
+	for _, test := range selectTests {
+		res, err := MustCompile(test.expr).SelectDocument(doc, env())
+		if err != nil {
+			t.Errorf("Cannot evaluate %s: %v", test.expr, err)
+			continue
+		}
+		if got := idxs(res); got != test.idxs {
+			t.Errorf("Expected %s to select %s with an index, got %s", test.expr, test.idxs, got)
+		}
+	}
+	// The index must notice changes to the tree.
+	doc.Root().AddChild(dom.Elem("node2", "").Attr("idx", "", "6").Attr("xml:id", "", "ignored"))
+	doc.Root().AddChild(dom.Elem("node3", "").Attr("idx", "", "7").Attr("id", dom.NS_XML, "seven"))
+	res, err := MustCompile("//node2").SelectDocument(doc, nil)
+	if err != nil {
+		t.Fatalf("Cannot evaluate: %v", err)
+	}
+	if got := idxs(res); got != "2,5,3,6" {
+		t.Errorf("Expected index to be rebuilt after adding a child, got %s", got)
+	}
+	res, err = MustCompile("id('seven nothing')").SelectDocument(doc, nil)
+	if err != nil {
+		t.Fatalf("Cannot evaluate: %v", err)
+	}
+	if got := idxs(res); got != "7" {
+		t.Errorf("Expected id() to find node3, got %s", got)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	doc := parseDoc()
+	tests := []struct {
+		expr   string
+		result string
+	}{
+		{"count(//node2)", "3"},
+		{"sum(//@idx)", "15"},
+		{"string(//node2[2])", "I am a different Node 2"},
+		{"string(//node2/node2)", "I am Groot"},
+		{"concat('a', 'b', 1)", "ab1"},
+		{"substring('12345', 1.5, 2.6)", "234"},
+		{"substring('12345', 0, 3)", "12"},
+		{"substring-before('1999/04/01', '/')", "1999"},
+		{"substring-after('1999/04/01', '/')", "04/01"},
+		{"normalize-space('  a   b ')", "a b"},
+		{"translate('bar', 'abc', 'ABC')", "BAr"},
+		{"translate('--aaa--', 'abc-', 'ABC')", "AAA"},
+		{"string-length('héllo')", "5"},
+		{"1 div 0", "Infinity"},
+		{"0 div 0", "NaN"},
+		{"round(2.5)", "3"},
+		{"round(-2.5)", "-2"},
+		{"floor(-1.5)", "-2"},
+		{"ceiling(1.2)", "2"},
+		{"7 mod -2", "1"},
+		{"-(3 - 5)", "2"},
+		{"2 * 3 + 1", "7"},
+		{"1 = 1 and 2 > 1", "true"},
+		{"//node2/@order = 2", "true"},
+		{"//node2/@order = 7", "false"},
+		{"//node2/@order != 0", "true"},
+		{"boolean(//nothing)", "false"},
+		{"number('  12.5 ')", "12.5"},
+		{"number('1e5')", "NaN"},
+		{"starts-with(name(/*), 'a:')", "true"},
+	}
+	for _, test := range tests {
+		x, err := Compile(test.expr)
+		if err != nil {
+			t.Errorf("Cannot compile %s: %v", test.expr, err)
+			continue
+		}
+		v, err := x.Evaluate(FromDocument(doc), env())
+		if err != nil {
+			t.Errorf("Cannot evaluate %s: %v", test.expr, err)
+			continue
+		}
+		if got := String(v); got != test.result {
+			t.Errorf("Expected %s to evaluate to %s, got %s", test.expr, test.result, got)
+		}
+	}
+}
+
+func TestTextNodes(t *testing.T) {
+	doc := parseDoc()
+	ns, err := MustCompile("//node2/text()").Nodes(FromDocument(doc), nil)
+	if err != nil {
+		t.Fatalf("Cannot evaluate: %v", err)
+	}
+	if len(ns) != 3 {
+		t.Fatalf("Expected 3 text nodes, got %d", len(ns))
+	}
+	for _, n := range ns {
+		if n.Type() != TextNode {
+			t.Errorf("Expected a text node, got %v", n.Type())
+		}
+	}
+	if ns[0].Value() != "I am Node 2" || ns[1].Value() != "I am Groot" {
+		t.Errorf("Text nodes are out of order: %q, %q", ns[0].Value(), ns[1].Value())
+	}
+}
+
+func TestAttributeNodes(t *testing.T) {
+	doc := parseDoc()
+	ns, err := MustCompile("/a:root/@*").Nodes(FromDocument(doc), env())
+	if err != nil {
+		t.Fatalf("Cannot evaluate: %v", err)
+	}
+	// The xmlns:a declaration is not an attribute.
+	if len(ns) != 1 || ns[0].Attr().Name.Local != "idx" {
+		t.Errorf("Expected only the idx attribute on root, got %v", ns)
+	}
+}
+
+func TestCustomFunction(t *testing.T) {
+	doc := parseDoc()
+	e := env().Func("my:double-idx", func(c *Context, args []Value) (Value, error) {
+		return 2 * Number(args[0]), nil
+	})
+	e.Func("my:self", func(c *Context, args []Value) (Value, error) {
+		return c.Node.Element(), nil
+	})
+	res, err := MustCompile("//*[my:double-idx(@idx) = 6]").Select(doc.Root(), e)
+	if err != nil {
+		t.Fatalf("Cannot evaluate: %v", err)
+	}
+	if got := idxs(res); got != "3" {
+		t.Errorf("Expected custom function to select 3, got %s", got)
+	}
+	res, err = MustCompile("my:self()/node1").Select(doc.Root(), e)
+	if err != nil {
+		t.Fatalf("Cannot evaluate: %v", err)
+	}
+	if got := idxs(res); got != "1" {
+		t.Errorf("Expected custom function returning an Element to select 1, got %s", got)
+	}
+	if _, err := MustCompile("my:missing()").Evaluate(FromDocument(doc), e); err == nil {
+		t.Error("Calling an unregistered function did not fail")
+	}
+}
+
+func TestUnboundPrefix(t *testing.T) {
+	doc := parseDoc()
+	if _, err := Select(doc.Root(), "/b:root"); err == nil {
+		t.Error("Expected unbound prefix to fail")
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, expr := range []string{"", "/a:root[", "foo(", "1 +", "'unterminated", "bogus::node()", "a ! b"} {
+		if _, err := Compile(expr); err == nil {
+			t.Errorf("Expected %q to fail to compile", expr)
+		}
+	}
+}
+
+func TestVariables(t *testing.T) {
+	doc := parseDoc()
+	x := MustCompile("//node2[@order = $order]")
+	for order, idx := range map[string]string{"0": "2", "1": "3", "' or '1'='1": ""} {
+		res, err := x.Select(doc.Root(), NewEnv().Var("order", order))
+		if err != nil {
+			t.Fatalf("Cannot evaluate with $order = %q: %v", order, err)
+		}
+		if got := idxs(res); got != idx {
+			t.Errorf("Expected $order = %q to select %q, got %q", order, idx, got)
+		}
+	}
+	sel := NewEnv().Var("n", 2).Var("nodes", doc.Root().Children())
+	v, err := MustCompile("count($nodes[@idx >= $n])").Evaluate(FromDocument(doc), sel)
+	if err != nil {
+		t.Fatalf("Cannot evaluate: %v", err)
+	}
+	if String(v) != "2" {
+		t.Errorf("Expected 2 nodes, got %s", String(v))
+	}
+	if _, err := x.Select(doc.Root(), nil); err == nil {
+		t.Error("Expected an unbound variable to fail")
+	}
+}
+
+func TestRemoveAll(t *testing.T) {
+	doc := parseDoc()
+	removed, err := RemoveAll(doc.Root(), "//node2 | //sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := idxs(removed); got != "4,2,3" {
+		t.Errorf("Expected to remove 4,2,3, got %s", got)
+	}
+	if got := idxs(doc.Root().All()); got != "0,1" {
+		t.Errorf("Expected 0,1 to be left, got %s", got)
+	}
+	if _, err := RemoveAll(doc.Root(), "count(*)"); err == nil {
+		t.Errorf("Expected an error for a non node-set expression")
+	}
+}
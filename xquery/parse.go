@@ -0,0 +1,573 @@
+package xquery
+
+import (
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/xpath"
+)
+
+type parser struct {
+	src string
+	pos int
+	// scopes holds the namespace prefixes declared by the prolog and by
+	// the element constructors being parsed, innermost last.
+	scopes []map[string]string
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("xquery: %s at offset %d in %q", fmt.Sprintf(format, args...), p.pos, p.src)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c == '-' || c == '.' || c >= '0' && c <= '9' ||
+		c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.src) && isSpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+func (p *parser) eof() bool {
+	p.skipSpace()
+	return p.pos >= len(p.src)
+}
+
+// keywordAt reports whether the keyword kw starts at offset i as a whole
+// word.
+func (p *parser) keywordAt(i int, kw string) bool {
+	if !strings.HasPrefix(p.src[i:], kw) {
+		return false
+	}
+	end := i + len(kw)
+	return end == len(p.src) || !isNameChar(p.src[end]) && p.src[end] != ':'
+}
+
+// keyword skips over kw if it comes next.
+func (p *parser) keyword(kw string) bool {
+	p.skipSpace()
+	if !p.keywordAt(p.pos, kw) {
+		return false
+	}
+	p.pos += len(kw)
+	return true
+}
+
+func (p *parser) expect(s string) error {
+	p.skipSpace()
+	if !strings.HasPrefix(p.src[p.pos:], s) {
+		return p.errorf("expected %q", s)
+	}
+	p.pos += len(s)
+	return nil
+}
+
+func (p *parser) name() (string, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) && (isNameChar(p.src[p.pos]) || p.src[p.pos] == ':') {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf("expected a name")
+	}
+	return p.src[start:p.pos], nil
+}
+
+func (p *parser) variable() (string, error) {
+	if err := p.expect("$"); err != nil {
+		return "", err
+	}
+	return p.name()
+}
+
+func (p *parser) literal() (string, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) || (p.src[p.pos] != '"' && p.src[p.pos] != '\'') {
+		return "", p.errorf("expected a string literal")
+	}
+	end := strings.IndexByte(p.src[p.pos+1:], p.src[p.pos])
+	if end < 0 {
+		return "", p.errorf("unterminated literal")
+	}
+	res := p.src[p.pos+1 : p.pos+1+end]
+	p.pos += end + 2
+	return res, nil
+}
+
+// startsFLWOR reports whether a for or let clause comes next.
+func (p *parser) startsFLWOR() bool {
+	p.skipSpace()
+	for _, kw := range []string{"for", "let"} {
+		if p.keywordAt(p.pos, kw) {
+			i := p.pos + len(kw)
+			for i < len(p.src) && isSpace(p.src[i]) {
+				i++
+			}
+			return i < len(p.src) && p.src[i] == '$'
+		}
+	}
+	return false
+}
+
+// startsConstructor reports whether an element constructor comes next.
+func (p *parser) startsConstructor() bool {
+	p.skipSpace()
+	return p.pos+1 < len(p.src) && p.src[p.pos] == '<' && isNameChar(p.src[p.pos+1])
+}
+
+// xpath parses the XPath expression that starts at the current offset.
+// The expression ends at the end of the query, at a top-level comma or
+// closing brace, or at a top-level keyword in stops that follows
+// whitespace.
+func (p *parser) xpath(stops ...string) (*xpath.Expr, error) {
+	p.skipSpace()
+	start, depth := p.pos, 0
+Scan:
+	for ; p.pos < len(p.src); p.pos++ {
+		c := p.src[p.pos]
+		switch {
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(p.src[p.pos+1:], c)
+			if end < 0 {
+				return nil, p.errorf("unterminated literal")
+			}
+			p.pos += end + 1
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			if depth == 0 {
+				break Scan
+			}
+			depth--
+		case depth > 0:
+		case c == ',' || c == '}' || c == '{':
+			break Scan
+		case isSpace(c):
+			for _, kw := range stops {
+				i := p.pos
+				for i < len(p.src) && isSpace(p.src[i]) {
+					i++
+				}
+				if p.keywordAt(i, kw) {
+					break Scan
+				}
+			}
+		}
+	}
+	src := strings.TrimSpace(p.src[start:p.pos])
+	if src == "" {
+		return nil, p.errorf("expected an expression")
+	}
+	x, err := xpath.Compile(src)
+	if err != nil {
+		return nil, fmt.Errorf("xquery: %v", err)
+	}
+	return x, nil
+}
+
+// startsSequence reports whether a parenthesized sequence, rather than an
+// XPath expression that starts with a parenthesis, comes next.  That is
+// the case when the parentheses are empty or hold a top-level comma.
+func (p *parser) startsSequence() bool {
+	p.skipSpace()
+	if p.pos >= len(p.src) || p.src[p.pos] != '(' {
+		return false
+	}
+	depth, comma := 0, false
+	for i := p.pos; i < len(p.src); i++ {
+		switch c := p.src[i]; c {
+		case '"', '\'':
+			end := strings.IndexByte(p.src[i+1:], c)
+			if end < 0 {
+				return false
+			}
+			i += end + 1
+		case '(', '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+		case ',':
+			comma = comma || depth == 1
+		case ')':
+			if depth--; depth == 0 {
+				return comma || strings.TrimSpace(p.src[p.pos+1:i]) == ""
+			}
+		}
+	}
+	return false
+}
+
+// expr parses a FLWOR expression, an element constructor, a
+// parenthesized sequence or an XPath expression.
+func (p *parser) expr(stops ...string) (expr, error) {
+	switch {
+	case p.startsFLWOR():
+		return p.flwor(stops)
+	case p.startsConstructor():
+		return p.constructor()
+	case p.startsSequence():
+		p.pos++
+		if p.expect(")") == nil {
+			return seqExpr{}, nil
+		}
+		e, err := p.sequence()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+	x, err := p.xpath(stops...)
+	if err != nil {
+		return nil, err
+	}
+	return pathExpr{x}, nil
+}
+
+// sequence parses a comma-separated list of expressions.
+func (p *parser) sequence() (expr, error) {
+	res := seqExpr{}
+	for {
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, e)
+		p.skipSpace()
+		if p.pos >= len(p.src) || p.src[p.pos] != ',' {
+			break
+		}
+		p.pos++
+	}
+	if len(res) == 1 {
+		return res[0], nil
+	}
+	return res, nil
+}
+
+var clauseStops = []string{"for", "let", "where", "order", "return"}
+
+func (p *parser) flwor(stops []string) (expr, error) {
+	f := &flworExpr{}
+	for {
+		switch {
+		case p.keyword("for"):
+			for {
+				cl := clause{}
+				var err error
+				if cl.name, err = p.variable(); err != nil {
+					return nil, err
+				}
+				if p.keyword("at") {
+					if cl.at, err = p.variable(); err != nil {
+						return nil, err
+					}
+				}
+				if !p.keyword("in") {
+					return nil, p.errorf("expected in")
+				}
+				if cl.value, err = p.expr(clauseStops...); err != nil {
+					return nil, err
+				}
+				f.clauses = append(f.clauses, cl)
+				if p.expect(",") != nil {
+					break
+				}
+			}
+			continue
+		case p.keyword("let"):
+			for {
+				cl := clause{let: true}
+				var err error
+				if cl.name, err = p.variable(); err != nil {
+					return nil, err
+				}
+				if err := p.expect(":="); err != nil {
+					return nil, err
+				}
+				if cl.value, err = p.expr(clauseStops...); err != nil {
+					return nil, err
+				}
+				f.clauses = append(f.clauses, cl)
+				if p.expect(",") != nil {
+					break
+				}
+			}
+			continue
+		}
+		break
+	}
+	if p.keyword("where") {
+		x, err := p.xpath("order", "return")
+		if err != nil {
+			return nil, err
+		}
+		f.where = x
+	}
+	if p.keyword("order") {
+		if !p.keyword("by") {
+			return nil, p.errorf("expected by")
+		}
+		for {
+			x, err := p.xpath("ascending", "descending", "return")
+			if err != nil {
+				return nil, err
+			}
+			k := orderKey{x: x}
+			if p.keyword("descending") {
+				k.descending = true
+			} else {
+				p.keyword("ascending")
+			}
+			f.order = append(f.order, k)
+			if p.expect(",") != nil {
+				break
+			}
+		}
+	}
+	if !p.keyword("return") {
+		return nil, p.errorf("expected return")
+	}
+	var err error
+	if f.ret, err = p.expr(stops...); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (p *parser) prolog() (map[string]string, error) {
+	res := map[string]string{}
+	for p.keyword("declare") {
+		if !p.keyword("namespace") {
+			return nil, p.errorf("expected namespace")
+		}
+		prefix, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("="); err != nil {
+			return nil, err
+		}
+		uri, err := p.literal()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(";"); err != nil {
+			return nil, err
+		}
+		res[prefix] = uri
+	}
+	return res, nil
+}
+
+func (p *parser) qname(s string, elem bool) (xml.Name, error) {
+	prefix, local := "", s
+	if i := strings.Index(s, ":"); i >= 0 {
+		prefix, local = s[:i], s[i+1:]
+	} else if !elem {
+		return xml.Name{Local: s}, nil
+	}
+	if prefix == "xml" {
+		return xml.Name{Space: "http://www.w3.org/XML/1998/namespace", Local: local}, nil
+	}
+	for i := len(p.scopes) - 1; i >= 0; i-- {
+		if uri, ok := p.scopes[i][prefix]; ok {
+			return xml.Name{Space: uri, Local: local}, nil
+		}
+	}
+	if prefix == "" {
+		return xml.Name{Local: local}, nil
+	}
+	return xml.Name{}, p.errorf("namespace prefix %s is not declared", prefix)
+}
+
+var (
+	entities = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&", "&quot;", `"`, "&apos;", "'")
+	charRef  = regexp.MustCompile(`&#(x[0-9a-fA-F]+|[0-9]+);`)
+)
+
+func unescape(s string) string {
+	s = charRef.ReplaceAllStringFunc(s, func(ref string) string {
+		num, base := ref[2:len(ref)-1], 10
+		if num[0] == 'x' {
+			num, base = num[1:], 16
+		}
+		r, err := strconv.ParseInt(num, base, 32)
+		if err != nil {
+			return ref
+		}
+		return string(rune(r))
+	})
+	return entities.Replace(s)
+}
+
+// content parses literal text and enclosed expressions up to one of the
+// bytes in end, which is not consumed.
+func (p *parser) content(end string) ([]expr, error) {
+	res := []expr{}
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			res = append(res, textExpr(unescape(text.String())))
+			text.Reset()
+		}
+	}
+	for p.pos < len(p.src) && strings.IndexByte(end, p.src[p.pos]) < 0 {
+		c := p.src[p.pos]
+		switch {
+		case strings.HasPrefix(p.src[p.pos:], "{{"), strings.HasPrefix(p.src[p.pos:], "}}"):
+			text.WriteByte(c)
+			p.pos += 2
+		case c == '{':
+			flush()
+			p.pos++
+			e, err := p.sequence()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("}"); err != nil {
+				return nil, err
+			}
+			res = append(res, enclosedExpr{e})
+		case c == '}':
+			return nil, p.errorf("unexpected }")
+		default:
+			text.WriteByte(c)
+			p.pos++
+		}
+	}
+	flush()
+	return res, nil
+}
+
+func (p *parser) constructor() (expr, error) {
+	p.pos++
+	tag, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	type rawAttr struct {
+		name  string
+		value []expr
+	}
+	raw := []rawAttr{}
+	scope := map[string]string{}
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("unterminated element constructor")
+		}
+		if p.src[p.pos] == '/' || p.src[p.pos] == '>' {
+			break
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("="); err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.pos >= len(p.src) || (p.src[p.pos] != '"' && p.src[p.pos] != '\'') {
+			return nil, p.errorf("expected a quoted attribute value")
+		}
+		quote := p.src[p.pos : p.pos+1]
+		p.pos++
+		value, err := p.content(quote)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(quote); err != nil {
+			return nil, err
+		}
+		switch {
+		case name == "xmlns" || strings.HasPrefix(name, "xmlns:"):
+			uri := ""
+			for _, v := range value {
+				t, ok := v.(textExpr)
+				if !ok {
+					return nil, p.errorf("namespace declarations cannot hold expressions")
+				}
+				uri += string(t)
+			}
+			scope[strings.TrimPrefix(strings.TrimPrefix(name, "xmlns"), ":")] = uri
+		}
+		raw = append(raw, rawAttr{name, value})
+	}
+	p.scopes = append(p.scopes, scope)
+	defer func() { p.scopes = p.scopes[:len(p.scopes)-1] }()
+	e := &elementExpr{}
+	if e.name, err = p.qname(tag, true); err != nil {
+		return nil, err
+	}
+	for _, a := range raw {
+		ae := attrExpr{value: a.value}
+		switch {
+		case a.name == "xmlns":
+			ae.name = xml.Name{Local: "xmlns"}
+		case strings.HasPrefix(a.name, "xmlns:"):
+			ae.name = xml.Name{Space: "xmlns", Local: a.name[len("xmlns:"):]}
+		default:
+			if ae.name, err = p.qname(a.name, false); err != nil {
+				return nil, err
+			}
+		}
+		e.attrs = append(e.attrs, ae)
+	}
+	if strings.HasPrefix(p.src[p.pos:], "/>") {
+		p.pos += 2
+		return e, nil
+	}
+	p.pos++
+	for {
+		content, err := p.content("<")
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range content {
+			// Whitespace-only text between tags and enclosed
+			// expressions is boundary whitespace, and is dropped.
+			if t, ok := c.(textExpr); ok && strings.TrimSpace(string(t)) == "" {
+				continue
+			}
+			e.content = append(e.content, c)
+		}
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("%s is not closed", tag)
+		}
+		if strings.HasPrefix(p.src[p.pos:], "</") {
+			p.pos += 2
+			end, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if end != tag {
+				return nil, p.errorf("%s is closed by %s", tag, end)
+			}
+			if err := p.expect(">"); err != nil {
+				return nil, err
+			}
+			return e, nil
+		}
+		if !p.startsConstructor() {
+			return nil, p.errorf("unexpected <")
+		}
+		child, err := p.constructor()
+		if err != nil {
+			return nil, err
+		}
+		e.content = append(e.content, child)
+	}
+}
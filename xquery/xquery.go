@@ -0,0 +1,469 @@
+// Package xquery evaluates a small, FLWOR-based subset of XQuery over
+// simplexml/dom trees, for extracting and reshaping data declaratively.
+//
+// A query is a comma-separated sequence of expressions, optionally after
+// a prolog of namespace declarations.  Each expression is one of:
+//
+// 1. An XPath 1.0 expression, evaluated by the xpath package.
+//
+// 2. A FLWOR expression: any number of for and let clauses, then an
+// optional where clause, an optional order by clause, and a return
+// clause, as in:
+//
+//	for $b at $i in //book
+//	let $p := $b/price
+//	where $p > 10
+//	order by $b/title descending, number($p)
+//	return <item n="{$i}">{$b/title/text()}</item>
+//
+// 3. A parenthesized, comma-separated sequence of expressions, or () for
+// the empty sequence.
+//
+// 4. A direct element constructor, whose attribute values and content can
+// hold enclosed expressions in braces.  Use {{ and }} for literal braces.
+//
+// Variables hold sequences, but XPath 1.0 only knows about node-sets and
+// single values, so a variable used in an XPath expression must hold
+// only nodes, or a single string, number or boolean.  Keys in order by
+// that evaluate to numbers are compared as numbers, and everything else
+// is compared as strings; empty keys sort first.
+//
+// The clause keywords (for, let, at, in, where, order by, ascending,
+// descending, return) end an XPath expression wherever they appear after
+// whitespace outside of brackets and string literals, so an element
+// with one of those names must be written without whitespace before it,
+// as in $x/return.  As with xslt, the content of a constructed element
+// is joined into its single Content, and whitespace-only text between
+// tags is dropped.  Namespace prefixes in constructors are resolved
+// against the prolog and the xmlns attributes of enclosing constructors;
+// prefixes in XPath expressions are also resolved against the prolog, as
+// well as the Env passed to Evaluate.
+//
+// For some basic usage examples, see xquery_test.go
+package xquery
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/xpath"
+)
+
+// Sequence is the result of evaluating a query.  Each item is an
+// xpath.Node, a string, a float64 or a bool.  Elements made by
+// constructors are new Elements with no parent.
+type Sequence []interface{}
+
+// Elements returns the Elements in s, skipping any items that are not
+// element nodes.
+func (s Sequence) Elements() []*dom.Element {
+	res := []*dom.Element{}
+	for _, item := range s {
+		if n, ok := item.(xpath.Node); ok && n.Type() == xpath.ElementNode {
+			res = append(res, n.Element())
+		}
+	}
+	return res
+}
+
+// Strings returns the string value of each item in s.
+func (s Sequence) Strings() []string {
+	res := make([]string, len(s))
+	for i, item := range s {
+		res[i] = itemString(item)
+	}
+	return res
+}
+
+func itemString(item interface{}) string {
+	if n, ok := item.(xpath.Node); ok {
+		return n.Value()
+	}
+	return xpath.String(item)
+}
+
+func items(v xpath.Value) Sequence {
+	if ns, ok := v.(xpath.NodeSet); ok {
+		res := make(Sequence, len(ns))
+		for i, n := range ns {
+			res[i] = n
+		}
+		return res
+	}
+	return Sequence{v}
+}
+
+// value converts s into something an XPath expression can use.
+func (s Sequence) value(name string) (xpath.Value, error) {
+	ns := xpath.NodeSet{}
+	for _, item := range s {
+		n, ok := item.(xpath.Node)
+		if !ok {
+			if len(s) == 1 {
+				return item, nil
+			}
+			return nil, fmt.Errorf("xquery: $%s holds a sequence of %d values, which XPath cannot use", name, len(s))
+		}
+		ns = append(ns, n)
+	}
+	return ns, nil
+}
+
+// Query is a compiled query.  A Query is safe for concurrent use.
+type Query struct {
+	src        string
+	root       expr
+	namespaces map[string]string
+}
+
+// Compile parses a query.
+func Compile(src string) (*Query, error) {
+	p := &parser{src: src}
+	ns, err := p.prolog()
+	if err != nil {
+		return nil, err
+	}
+	p.scopes = []map[string]string{ns}
+	root, err := p.sequence()
+	if err != nil {
+		return nil, err
+	}
+	if !p.eof() {
+		return nil, p.errorf("unexpected %q", p.src[p.pos:p.pos+1])
+	}
+	return &Query{src: src, root: root, namespaces: ns}, nil
+}
+
+// MustCompile is like Compile, but panics if the query cannot be parsed.
+func MustCompile(src string) *Query {
+	q, err := Compile(src)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+// String returns the source text of the query.
+func (q *Query) String() string {
+	return q.src
+}
+
+// Evaluate evaluates the query with n as the context node.  env may be
+// nil, and is not changed.
+func (q *Query) Evaluate(n xpath.Node, env *xpath.Env) (Sequence, error) {
+	env = env.Clone()
+	for prefix, uri := range q.namespaces {
+		env.Namespace(prefix, uri)
+	}
+	return q.root.eval(&context{node: n, env: env})
+}
+
+// EvaluateDocument evaluates the query with the root of doc as the
+// context node.
+func (q *Query) EvaluateDocument(doc *dom.Document, env *xpath.Env) (Sequence, error) {
+	return q.Evaluate(xpath.FromDocument(doc), env)
+}
+
+// Evaluate compiles query and evaluates it against doc.  It is equivalent
+// to:
+//
+//	q, err := Compile(query)
+//	q.EvaluateDocument(doc, nil)
+func Evaluate(doc *dom.Document, query string) (Sequence, error) {
+	q, err := Compile(query)
+	if err != nil {
+		return nil, err
+	}
+	return q.EvaluateDocument(doc, nil)
+}
+
+type context struct {
+	node xpath.Node
+	env  *xpath.Env
+}
+
+type expr interface {
+	eval(c *context) (Sequence, error)
+}
+
+type pathExpr struct {
+	x *xpath.Expr
+}
+
+func (e pathExpr) eval(c *context) (Sequence, error) {
+	v, err := e.x.Evaluate(c.node, c.env)
+	if err != nil {
+		return nil, fmt.Errorf("xquery: %v", err)
+	}
+	return items(v), nil
+}
+
+type seqExpr []expr
+
+func (e seqExpr) eval(c *context) (Sequence, error) {
+	res := Sequence{}
+	for _, item := range e {
+		s, err := item.eval(c)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, s...)
+	}
+	return res, nil
+}
+
+type textExpr string
+
+func (e textExpr) eval(c *context) (Sequence, error) {
+	return Sequence{string(e)}, nil
+}
+
+type enclosedExpr struct {
+	e expr
+}
+
+func (e enclosedExpr) eval(c *context) (Sequence, error) {
+	return e.e.eval(c)
+}
+
+type clause struct {
+	let      bool
+	name, at string
+	value    expr
+}
+
+type orderKey struct {
+	x          *xpath.Expr
+	descending bool
+}
+
+type flworExpr struct {
+	clauses []clause
+	where   *xpath.Expr
+	order   []orderKey
+	ret     expr
+}
+
+type binding struct {
+	name  string
+	value xpath.Value
+}
+
+// bind sets the variables in tuple, and returns a function that undoes
+// that.
+func (c *context) bind(tuple []binding) func() {
+	type saved struct {
+		v   interface{}
+		had bool
+	}
+	old := make([]saved, len(tuple))
+	for i, b := range tuple {
+		old[i].v, old[i].had = c.env.Variables[b.name]
+		c.env.Variables[b.name] = b.value
+	}
+	return func() {
+		for i := len(tuple) - 1; i >= 0; i-- {
+			if old[i].had {
+				c.env.Variables[tuple[i].name] = old[i].v
+			} else {
+				delete(c.env.Variables, tuple[i].name)
+			}
+		}
+	}
+}
+
+func extend(tuple []binding, more ...binding) []binding {
+	res := make([]binding, 0, len(tuple)+len(more))
+	return append(append(res, tuple...), more...)
+}
+
+func (e *flworExpr) tuples(c *context) ([][]binding, error) {
+	tuples := [][]binding{{}}
+	for _, cl := range e.clauses {
+		next := [][]binding{}
+		for _, t := range tuples {
+			undo := c.bind(t)
+			s, err := cl.value.eval(c)
+			undo()
+			if err != nil {
+				return nil, err
+			}
+			if cl.let {
+				v, err := s.value(cl.name)
+				if err != nil {
+					return nil, err
+				}
+				next = append(next, extend(t, binding{cl.name, v}))
+				continue
+			}
+			for i, item := range s {
+				v := xpath.Value(item)
+				if n, ok := item.(xpath.Node); ok {
+					v = xpath.NodeSet{n}
+				}
+				b := []binding{{cl.name, v}}
+				if cl.at != "" {
+					b = append(b, binding{cl.at, float64(i + 1)})
+				}
+				next = append(next, extend(t, b...))
+			}
+		}
+		tuples = next
+	}
+	return tuples, nil
+}
+
+// compareKeys orders the values of order by keys.
+func compareKeys(a, b xpath.Value) int {
+	empty := func(v xpath.Value) bool {
+		ns, ok := v.(xpath.NodeSet)
+		if f, isNum := v.(float64); isNum {
+			return math.IsNaN(f)
+		}
+		return ok && len(ns) == 0
+	}
+	switch ea, eb := empty(a), empty(b); {
+	case ea && eb:
+		return 0
+	case ea:
+		return -1
+	case eb:
+		return 1
+	}
+	fa, aNum := a.(float64)
+	fb, bNum := b.(float64)
+	if aNum && bNum {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(xpath.String(a), xpath.String(b))
+}
+
+func (e *flworExpr) eval(c *context) (Sequence, error) {
+	tuples, err := e.tuples(c)
+	if err != nil {
+		return nil, err
+	}
+	kept := [][]binding{}
+	keys := [][]xpath.Value{}
+	for _, t := range tuples {
+		undo := c.bind(t)
+		err := func() error {
+			if e.where != nil {
+				v, err := e.where.Evaluate(c.node, c.env)
+				if err != nil {
+					return fmt.Errorf("xquery: %v", err)
+				}
+				if !xpath.Boolean(v) {
+					return nil
+				}
+			}
+			k := make([]xpath.Value, len(e.order))
+			for i, o := range e.order {
+				v, err := o.x.Evaluate(c.node, c.env)
+				if err != nil {
+					return fmt.Errorf("xquery: %v", err)
+				}
+				k[i] = v
+			}
+			kept = append(kept, t)
+			keys = append(keys, k)
+			return nil
+		}()
+		undo()
+		if err != nil {
+			return nil, err
+		}
+	}
+	idx := make([]int, len(kept))
+	for i := range idx {
+		idx[i] = i
+	}
+	if len(e.order) > 0 {
+		sort.SliceStable(idx, func(a, b int) bool {
+			for i, o := range e.order {
+				cmp := compareKeys(keys[idx[a]][i], keys[idx[b]][i])
+				if o.descending {
+					cmp = -cmp
+				}
+				if cmp != 0 {
+					return cmp < 0
+				}
+			}
+			return false
+		})
+	}
+	res := Sequence{}
+	for _, i := range idx {
+		undo := c.bind(kept[i])
+		s, err := e.ret.eval(c)
+		undo()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, s...)
+	}
+	return res, nil
+}
+
+type attrExpr struct {
+	name  xml.Name
+	value []expr
+}
+
+type elementExpr struct {
+	name    xml.Name
+	attrs   []attrExpr
+	content []expr
+}
+
+func (e *elementExpr) eval(c *context) (Sequence, error) {
+	res := dom.CreateElement(e.name)
+	for _, a := range e.attrs {
+		var b strings.Builder
+		for _, part := range a.value {
+			s, err := part.eval(c)
+			if err != nil {
+				return nil, err
+			}
+			b.WriteString(strings.Join(s.Strings(), " "))
+		}
+		res.AddAttr(xml.Attr{Name: a.name, Value: b.String()})
+	}
+	for _, part := range e.content {
+		s, err := part.eval(c)
+		if err != nil {
+			return nil, err
+		}
+		atomic := false
+		for _, item := range s {
+			n, ok := item.(xpath.Node)
+			switch {
+			case !ok:
+				// Adjacent values are separated by spaces.
+				if atomic {
+					res.Content = append(res.Content, ' ')
+				}
+				res.Content = append(res.Content, itemString(item)...)
+			case n.Type() == xpath.AttributeNode:
+				res.AddAttr(n.Attr())
+			case n.Type() == xpath.TextNode:
+				res.Content = append(res.Content, n.Value()...)
+			case n.Element() != nil:
+				res.AddChild(n.Element().Export().Import())
+			}
+			_, isText := part.(textExpr)
+			atomic = !ok && !isText
+		}
+	}
+	return Sequence{xpath.FromElement(res)}, nil
+}
@@ -0,0 +1,157 @@
+package xquery
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/domtest"
+	"github.com/VictorLowther/simplexml/xpath"
+)
+
+const input = `<library xmlns:d="urn:dc">
+  <book id="b1" year="1999"><title>Zebras</title><price>10</price><d:creator>Ann</d:creator></book>
+  <book id="b2" year="2005"><title>Apples</title><price>30</price><d:creator>Bob</d:creator></book>
+  <book id="b3" year="2001"><title>Moths</title><price>20</price><d:creator>Ann</d:creator></book>
+</library>`
+
+func doc(t *testing.T) *dom.Document {
+	d, err := dom.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func strings_(t *testing.T, query string, env *xpath.Env) []string {
+	q, err := Compile(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := q.EvaluateDocument(doc(t), env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.Strings()
+}
+
+func TestFLWOR(t *testing.T) {
+	for _, test := range []struct {
+		query string
+		want  []string
+	}{
+		{`for $b in //book return $b/title`, []string{"Zebras", "Apples", "Moths"}},
+		{`for $b in //book where $b/price > 15 return string($b/@id)`, []string{"b2", "b3"}},
+		{`for $b in //book order by $b/title return $b/title`, []string{"Apples", "Moths", "Zebras"}},
+		{`for $b in //book order by number($b/price) descending return $b/price`, []string{"30", "20", "10"}},
+		{`for $b in //book order by $b/d:creator, $b/title descending return $b/title`,
+			[]string{"Zebras", "Moths", "Apples"}},
+		{`for $b at $i in //book return $i * 10`, []string{"10", "20", "30"}},
+		{`for $b in //book let $p := $b/price * 2 where $p < 50 return $p`, []string{"20", "40"}},
+		{`let $all := //book return count($all)`, []string{"3"}},
+		{`for $a in (1), $b in //book[@year > 2000] return concat($a, $b/@id)`, []string{"1b2", "1b3"}},
+		{`for $b in //book return for $t in $b/title where $b/@year < 2000 return $t`, []string{"Zebras"}},
+		{`count(//book), sum(//price)`, []string{"3", "60"}},
+		{`declare namespace dc = "urn:dc"; for $c in //dc:creator[. = 'Bob'] return $c`, []string{"Bob"}},
+	} {
+		got := strings_(t, test.query, xpath.NewEnv().Namespace("d", "urn:dc"))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.query, got, test.want)
+		}
+	}
+}
+
+func TestConstructors(t *testing.T) {
+	q := MustCompile(`
+<report count="{count(//book)}" made="{{now}}">
+  {
+    for $b in //book
+    where $b/d:creator = $who
+    order by $b/title
+    return <row id="{$b/@id}">{$b/title/text()} ({string($b/@year)})</row>
+  }
+  <copy>{//book[1]/@year, //book[1]/price}</copy>
+  <nums>{1, 2, 'x'}</nums>
+</report>`)
+	env := xpath.NewEnv().Namespace("d", "urn:dc").Var("who", "Ann")
+	res, err := q.EvaluateDocument(doc(t), env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	els := res.Elements()
+	if len(res) != 1 || len(els) != 1 {
+		t.Fatalf("expected one element, got %v", res)
+	}
+	domtest.EqualXML(t, els[0], `<report count="3" made="{now}">
+  <row id="b3">Moths (2001)</row>
+  <row id="b1">Zebras (1999)</row>
+  <copy year="1999"><price>10</price></copy>
+  <nums>1 2 x</nums>
+</report>`)
+	if _, ok := env.Variables["b"]; ok {
+		t.Errorf("evaluation leaked bindings into the Env")
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	res, err := Evaluate(doc(t), `declare namespace dc = "urn:dc";
+<x:out xmlns:x="urn:x">{for $c in //dc:creator return <x:name>{string($c)}</x:name>}</x:out>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := res.Elements()[0]
+	if out.Name.Space != "urn:x" || out.Children()[0].Name.Space != "urn:x" {
+		t.Errorf("constructed elements are not in urn:x: %s", out)
+	}
+	domtest.EqualXML(t, out, `<x:out xmlns:x="urn:x"><x:name>Ann</x:name><x:name>Bob</x:name><x:name>Ann</x:name></x:out>`)
+}
+
+func TestErrors(t *testing.T) {
+	for _, query := range []string{
+		`for $b in //book`,
+		`for $b //book return $b`,
+		`for $b in //book order $b return $b`,
+		`<a><b></a>`,
+		`<a>{1}`,
+		`<p:a/>`,
+		`//book)`,
+		`for $b in //book return $b[`,
+	} {
+		if _, err := Compile(query); err == nil {
+			t.Errorf("expected an error compiling %s", query)
+		}
+	}
+	for _, query := range []string{
+		`let $x := (1, 2) return $x`,
+		`let $x := <a/>, $y := ('a', 'b') return $y`,
+		`for $b in //book return $nope`,
+	} {
+		q, err := Compile(query)
+		if err != nil {
+			t.Errorf("%s: %v", query, err)
+			continue
+		}
+		if _, err := q.EvaluateDocument(doc(t), nil); err == nil {
+			t.Errorf("expected an error evaluating %s", query)
+		}
+	}
+}
+
+func TestSequences(t *testing.T) {
+	for _, test := range []struct {
+		query string
+		want  []string
+	}{
+		{`()`, []string{}},
+		{`(1, (2, 3), ())`, []string{"1", "2", "3"}},
+		{`for $b in //book[price < 25] return ($b/title, string($b/price))`, []string{"Zebras", "10", "Moths", "20"}},
+		{`(//book)[2]/title`, []string{"Apples"}},
+		{`for $x in (1, 'a', true()) return $x`, []string{"1", "a", "true"}},
+	} {
+		got := strings_(t, test.query, nil)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.query, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,690 @@
+package xslt
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/xpath"
+)
+
+// maxDepth limits how deeply templates can call each other, so that
+// runaway recursion in a stylesheet ends in an error.
+const maxDepth = 1000
+
+var childNodes = xpath.MustCompile("node()")
+
+// context is the state an instruction is executed in.
+type context struct {
+	node      xpath.Node
+	pos, size int
+	mode      xml.Name
+}
+
+type matchKey struct {
+	pattern *xpath.Expr
+	top     *dom.Element
+}
+
+// run holds the state of one transformation.
+type run struct {
+	s       *Stylesheet
+	env     *xpath.Env
+	current xpath.Node
+	matches map[matchKey]map[xpath.Node]bool
+	// fragments holds the elements that hold the value of variables
+	// bound to result tree fragments.
+	fragments map[*dom.Element]bool
+	depth     int
+}
+
+func (s *Stylesheet) newRun() *run {
+	r := &run{
+		s:         s,
+		env:       s.env.Clone(),
+		matches:   map[matchKey]map[xpath.Node]bool{},
+		fragments: map[*dom.Element]bool{},
+	}
+	r.env.Func("current", func(c *xpath.Context, args []xpath.Value) (xpath.Value, error) {
+		if len(args) != 0 {
+			return nil, errors.New("xslt: current() takes no arguments")
+		}
+		return xpath.NodeSet{r.current}, nil
+	})
+	return r
+}
+
+func (r *run) eval(x *xpath.Expr, c context) (xpath.Value, error) {
+	saved := r.current
+	r.current = c.node
+	defer func() { r.current = saved }()
+	return x.EvaluateAt(c.node, c.pos, c.size, r.env)
+}
+
+func (r *run) nodes(n *node, x *xpath.Expr, c context) (xpath.NodeSet, error) {
+	v, err := r.eval(x, c)
+	if err != nil {
+		return nil, n.errorf("%v", err)
+	}
+	ns, ok := v.(xpath.NodeSet)
+	if !ok {
+		return nil, n.errorf("%s does not select nodes", x)
+	}
+	return ns, nil
+}
+
+func (r *run) avt(n *node, a avt, c context) (string, error) {
+	var b strings.Builder
+	for _, part := range a {
+		switch part := part.(type) {
+		case string:
+			b.WriteString(part)
+		case *xpath.Expr:
+			v, err := r.eval(part, c)
+			if err != nil {
+				return "", n.errorf("%v", err)
+			}
+			b.WriteString(xpath.String(v))
+		}
+	}
+	return b.String(), nil
+}
+
+// bind binds the variable name to v, and returns a function that undoes
+// that.
+func (r *run) bind(name string, v interface{}) func() {
+	old, had := r.env.Variables[name]
+	r.env.Variables[name] = v
+	return func() {
+		if had {
+			r.env.Variables[name] = old
+		} else {
+			delete(r.env.Variables, name)
+		}
+	}
+}
+
+func (r *run) start(doc *dom.Document, params map[string]interface{}) (*dom.Element, error) {
+	root := xpath.FromDocument(doc)
+	c := context{node: root, pos: 1, size: 1}
+	for _, g := range r.s.globals {
+		name, _ := g.attr("name")
+		if v, ok := params[name]; ok && g.is("param") {
+			r.bind(name, v)
+			continue
+		}
+		v, err := r.value(g, c)
+		if err != nil {
+			return nil, err
+		}
+		r.bind(name, v)
+	}
+	out := dom.Elem("", "")
+	if err := r.apply(xpath.NodeSet{root}, xml.Name{}, nil, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Transform runs the stylesheet against doc, and returns the result tree.
+// params sets the values of global xsl:param elements.  The result must
+// be a single element.
+func (s *Stylesheet) Transform(doc *dom.Document, params map[string]interface{}) (*dom.Document, error) {
+	out, err := s.newRun().start(doc, params)
+	if err != nil {
+		return nil, err
+	}
+	children := out.Children()
+	if len(children) != 1 || !isWhitespace(string(out.Content)) {
+		return nil, errors.New("xslt: the result is not a single element")
+	}
+	res := out.RemoveChild(children[0])
+	// Declare the namespaces of the result with the prefixes the
+	// stylesheet used for them, rather than made up ones.
+	declared := map[string]bool{}
+	declare := func(uri string) {
+		if p, ok := s.prefixes[uri]; ok && p != "" && !declared[uri] {
+			declared[uri] = true
+			res.Attr(p, "xmlns", uri)
+		}
+	}
+	for _, e := range res.All() {
+		declare(e.Name.Space)
+		for _, a := range e.Attributes {
+			if a.Name.Space != "xmlns" {
+				declare(a.Name.Space)
+			}
+		}
+	}
+	doc = dom.CreateDocument()
+	doc.SetRoot(res)
+	return doc, nil
+}
+
+// TransformText runs the stylesheet against doc, and returns the text of
+// the result, as the text output method does.
+func (s *Stylesheet) TransformText(doc *dom.Document, params map[string]interface{}) (string, error) {
+	out, err := s.newRun().start(doc, params)
+	if err != nil {
+		return "", err
+	}
+	return xpath.FromElement(out).Value(), nil
+}
+
+func (r *run) matchesRule(rl *rule, n xpath.Node) (bool, error) {
+	top := n.Element()
+	for top != nil && top.Parent() != nil {
+		top = top.Parent()
+	}
+	key := matchKey{rl.pattern, top}
+	set, ok := r.matches[key]
+	if !ok {
+		set = map[xpath.Node]bool{}
+		if top != nil {
+			root, err := xpath.MustCompile("/").Nodes(xpath.FromElement(top), nil)
+			if err != nil {
+				return false, err
+			}
+			ns, err := rl.pattern.Nodes(root[0], r.env)
+			if err != nil {
+				return false, fmt.Errorf("xslt: pattern %s: %v", rl.pattern, err)
+			}
+			for _, m := range ns {
+				set[m] = true
+			}
+		}
+		r.matches[key] = set
+	}
+	return set[n], nil
+}
+
+func (r *run) find(n xpath.Node, mode xml.Name) (*template, error) {
+	var best *rule
+	for _, rl := range r.s.rules {
+		if rl.t.mode != mode || (best != nil && rl.priority < best.priority) {
+			continue
+		}
+		ok, err := r.matchesRule(rl, n)
+		if err != nil {
+			return nil, err
+		}
+		// Of the rules with the highest priority, the last one wins.
+		if ok {
+			best = rl
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+	return best.t, nil
+}
+
+func (r *run) apply(ns xpath.NodeSet, mode xml.Name, params map[string]interface{}, out *dom.Element) error {
+	for i, n := range ns {
+		c := context{node: n, pos: i + 1, size: len(ns), mode: mode}
+		t, err := r.find(n, mode)
+		if err != nil {
+			return err
+		}
+		if t != nil {
+			err = r.call(t, c, params, out)
+		} else {
+			err = r.builtin(c, out)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// builtin runs the built-in template rules for c.node.
+func (r *run) builtin(c context, out *dom.Element) error {
+	switch c.node.Type() {
+	case xpath.RootNode, xpath.ElementNode:
+		ns, err := childNodes.Nodes(c.node, nil)
+		if err != nil {
+			return err
+		}
+		return r.apply(ns, c.mode, nil, out)
+	}
+	appendText(out, c.node.Value())
+	return nil
+}
+
+func (r *run) call(t *template, c context, params map[string]interface{}, out *dom.Element) error {
+	if r.depth >= maxDepth {
+		return t.body.errorf("templates nested more than %d deep", maxDepth)
+	}
+	r.depth++
+	defer func() { r.depth-- }()
+	return r.exec(t.body.children, c, params, out)
+}
+
+func appendText(e *dom.Element, s string) {
+	e.Content = append(e.Content, s...)
+}
+
+func setAttr(e *dom.Element, a xml.Attr) {
+	for i := range e.Attributes {
+		if e.Attributes[i].Name == a.Name {
+			e.Attributes[i].Value = a.Value
+			return
+		}
+	}
+	e.AddAttr(a)
+}
+
+// value returns the value of a variable, param or with-param.
+func (r *run) value(n *node, c context) (interface{}, error) {
+	if x, ok := n.exprs["select"]; ok {
+		v, err := r.eval(x, c)
+		if err != nil {
+			return nil, n.errorf("%v", err)
+		}
+		return v, nil
+	}
+	if len(n.children) == 0 {
+		return "", nil
+	}
+	frag := dom.Elem("", "")
+	if err := r.exec(n.children, c, nil, frag); err != nil {
+		return nil, err
+	}
+	r.fragments[frag] = true
+	return xpath.NodeSet{xpath.FromElement(frag)}, nil
+}
+
+// text returns the string value of the result of running the children of
+// n.
+func (r *run) text(n *node, c context) (string, error) {
+	frag := dom.Elem("", "")
+	if err := r.exec(n.children, c, nil, frag); err != nil {
+		return "", err
+	}
+	return xpath.FromElement(frag).Value(), nil
+}
+
+// exec runs children, the contents of a template or an instruction.
+// params holds the values passed to the template for its xsl:params.
+func (r *run) exec(children []interface{}, c context, params map[string]interface{}, out *dom.Element) error {
+	undo := []func(){}
+	defer func() {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+	}()
+	for _, child := range children {
+		n, ok := child.(*node)
+		switch {
+		case !ok:
+			appendText(out, child.(string))
+		case n.name.Space != NS_XSLT:
+			if err := r.literal(n, c, out); err != nil {
+				return err
+			}
+		case n.is("variable") || n.is("param"):
+			name, _ := n.attr("name")
+			v, passed := params[name]
+			if !passed || n.is("variable") {
+				var err error
+				if v, err = r.value(n, c); err != nil {
+					return err
+				}
+			}
+			undo = append(undo, r.bind(name, v))
+		default:
+			if err := r.instruction(n, c, out); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *run) literal(n *node, c context, out *dom.Element) error {
+	e := dom.CreateElement(n.name)
+	for _, a := range n.attrs {
+		v, ok := n.avts[a.Name.Space+" "+a.Name.Local]
+		if !ok {
+			continue
+		}
+		s, err := r.avt(n, v, c)
+		if err != nil {
+			return err
+		}
+		e.AddAttr(xml.Attr{Name: a.Name, Value: s})
+	}
+	out.AddChild(e)
+	return r.exec(n.children, c, nil, e)
+}
+
+func (r *run) params(n *node, c context) (map[string]interface{}, error) {
+	res := map[string]interface{}{}
+	for _, child := range n.children {
+		if p, ok := child.(*node); ok && p.is("with-param") {
+			name, _ := p.attr("name")
+			v, err := r.value(p, c)
+			if err != nil {
+				return nil, err
+			}
+			res[name] = v
+		}
+	}
+	return res, nil
+}
+
+// name works out the name given to xsl:element or xsl:attribute.
+func (r *run) name(n *node, c context) (xml.Name, error) {
+	a, ok := n.avts["name"]
+	if !ok {
+		return xml.Name{}, n.errorf("needs a name")
+	}
+	s, err := r.avt(n, a, c)
+	if err != nil {
+		return xml.Name{}, err
+	}
+	if a, ok := n.avts["namespace"]; ok {
+		space, err := r.avt(n, a, c)
+		if err != nil {
+			return xml.Name{}, err
+		}
+		if i := strings.Index(s, ":"); i >= 0 {
+			s = s[i+1:]
+		}
+		return xml.Name{Space: space, Local: s}, nil
+	}
+	return r.s.qname(n, s)
+}
+
+func (r *run) copy(n xpath.Node, out *dom.Element) {
+	switch n.Type() {
+	case xpath.AttributeNode:
+		setAttr(out, n.Attr())
+	case xpath.TextNode:
+		appendText(out, n.Value())
+	default:
+		e := n.Element()
+		if e == nil {
+			return
+		}
+		if !r.fragments[e] {
+			out.AddChild(e.Export().Import())
+			return
+		}
+		appendText(out, string(e.Content))
+		for _, c := range e.Children() {
+			out.AddChild(c.Export().Import())
+		}
+	}
+}
+
+type sortKey struct {
+	x       *xpath.Expr
+	number  bool
+	reverse bool
+}
+
+func (r *run) sort(n *node, ns xpath.NodeSet, c context) (xpath.NodeSet, error) {
+	keys := []sortKey{}
+	for _, child := range n.children {
+		s, ok := child.(*node)
+		if !ok || !s.is("sort") {
+			continue
+		}
+		k := sortKey{x: s.exprs["select"]}
+		if k.x == nil {
+			k.x = xpath.MustCompile(".")
+		}
+		if a, ok := s.avts["data-type"]; ok {
+			v, err := r.avt(s, a, c)
+			if err != nil {
+				return nil, err
+			}
+			k.number = v == "number"
+		}
+		if a, ok := s.avts["order"]; ok {
+			v, err := r.avt(s, a, c)
+			if err != nil {
+				return nil, err
+			}
+			k.reverse = v == "descending"
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		return ns, nil
+	}
+	values := make([][]xpath.Value, len(ns))
+	for i, m := range ns {
+		for _, k := range keys {
+			v, err := r.eval(k.x, context{node: m, pos: i + 1, size: len(ns)})
+			if err != nil {
+				return nil, n.errorf("%v", err)
+			}
+			values[i] = append(values[i], v)
+		}
+	}
+	idx := make([]int, len(ns))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		for i, k := range keys {
+			cmp := compare(values[idx[a]][i], values[idx[b]][i], k.number)
+			if k.reverse {
+				cmp = -cmp
+			}
+			if cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return false
+	})
+	res := make(xpath.NodeSet, len(ns))
+	for i, j := range idx {
+		res[i] = ns[j]
+	}
+	return res, nil
+}
+
+func compare(a, b xpath.Value, number bool) int {
+	if number {
+		x, y := xpath.Number(a), xpath.Number(b)
+		switch {
+		case math.IsNaN(x) && math.IsNaN(y):
+			return 0
+		case math.IsNaN(x):
+			return -1
+		case math.IsNaN(y):
+			return 1
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(xpath.String(a), xpath.String(b))
+}
+
+func (r *run) instruction(n *node, c context, out *dom.Element) error {
+	switch n.name.Local {
+	case "value-of":
+		x, ok := n.exprs["select"]
+		if !ok {
+			return n.errorf("needs a select")
+		}
+		v, err := r.eval(x, c)
+		if err != nil {
+			return n.errorf("%v", err)
+		}
+		appendText(out, xpath.String(v))
+	case "text":
+		for _, child := range n.children {
+			if s, ok := child.(string); ok {
+				appendText(out, s)
+			}
+		}
+	case "apply-templates":
+		x, ok := n.exprs["select"]
+		if !ok {
+			x = childNodes
+		}
+		ns, err := r.nodes(n, x, c)
+		if err != nil {
+			return err
+		}
+		if ns, err = r.sort(n, ns, c); err != nil {
+			return err
+		}
+		var mode xml.Name
+		if v, ok := n.attr("mode"); ok {
+			if mode, err = r.s.qname(n, v); err != nil {
+				return err
+			}
+		}
+		params, err := r.params(n, c)
+		if err != nil {
+			return err
+		}
+		return r.apply(ns, mode, params, out)
+	case "call-template":
+		v, _ := n.attr("name")
+		name, err := r.s.qname(n, v)
+		if err != nil {
+			return err
+		}
+		t, ok := r.s.named[name]
+		if !ok {
+			return n.errorf("no template is called %s", v)
+		}
+		params, err := r.params(n, c)
+		if err != nil {
+			return err
+		}
+		return r.call(t, c, params, out)
+	case "for-each":
+		x, ok := n.exprs["select"]
+		if !ok {
+			return n.errorf("needs a select")
+		}
+		ns, err := r.nodes(n, x, c)
+		if err != nil {
+			return err
+		}
+		if ns, err = r.sort(n, ns, c); err != nil {
+			return err
+		}
+		body := []interface{}{}
+		for _, child := range n.children {
+			if s, ok := child.(*node); !ok || !s.is("sort") {
+				body = append(body, child)
+			}
+		}
+		for i, m := range ns {
+			if err := r.exec(body, context{node: m, pos: i + 1, size: len(ns), mode: c.mode}, nil, out); err != nil {
+				return err
+			}
+		}
+	case "if":
+		ok, err := r.test(n, c)
+		if err != nil || !ok {
+			return err
+		}
+		return r.exec(n.children, c, nil, out)
+	case "choose":
+		for _, child := range n.children {
+			w, _ := child.(*node)
+			switch {
+			case w != nil && w.is("when"):
+				ok, err := r.test(w, c)
+				if err != nil {
+					return err
+				}
+				if ok {
+					return r.exec(w.children, c, nil, out)
+				}
+			case w != nil && w.is("otherwise"):
+				return r.exec(w.children, c, nil, out)
+			default:
+				return n.errorf("can only hold xsl:when and xsl:otherwise")
+			}
+		}
+	case "element":
+		name, err := r.name(n, c)
+		if err != nil {
+			return err
+		}
+		e := dom.CreateElement(name)
+		out.AddChild(e)
+		return r.exec(n.children, c, nil, e)
+	case "attribute":
+		name, err := r.name(n, c)
+		if err != nil {
+			return err
+		}
+		v, err := r.text(n, c)
+		if err != nil {
+			return err
+		}
+		setAttr(out, xml.Attr{Name: name, Value: v})
+	case "copy":
+		switch c.node.Type() {
+		case xpath.ElementNode:
+			e := dom.CreateElement(c.node.Name())
+			out.AddChild(e)
+			return r.exec(n.children, c, nil, e)
+		case xpath.RootNode:
+			return r.exec(n.children, c, nil, out)
+		}
+		r.copy(c.node, out)
+	case "copy-of":
+		x, ok := n.exprs["select"]
+		if !ok {
+			return n.errorf("needs a select")
+		}
+		v, err := r.eval(x, c)
+		if err != nil {
+			return n.errorf("%v", err)
+		}
+		ns, ok := v.(xpath.NodeSet)
+		if !ok {
+			appendText(out, xpath.String(v))
+			return nil
+		}
+		for _, m := range ns {
+			r.copy(m, out)
+		}
+	case "message":
+		text, err := r.text(n, c)
+		if err != nil {
+			return err
+		}
+		if v, _ := n.attr("terminate"); v == "yes" {
+			return n.errorf("terminated: %s", text)
+		}
+	case "comment", "processing-instruction", "sort", "with-param":
+		// There is nowhere to put comments and processing instructions,
+		// and the other two are handled by their parents.
+	default:
+		return n.errorf("xsl:%s is not allowed here", n.name.Local)
+	}
+	return nil
+}
+
+func (r *run) test(n *node, c context) (bool, error) {
+	x, ok := n.exprs["test"]
+	if !ok {
+		return false, n.errorf("needs a test")
+	}
+	v, err := r.eval(x, c)
+	if err != nil {
+		return false, n.errorf("%v", err)
+	}
+	return xpath.Boolean(v), nil
+}
@@ -0,0 +1,485 @@
+// Package xslt runs XSLT 1.0 stylesheets against simplexml/dom trees.
+//
+// The commonly used core of XSLT 1.0 is supported: templates with match
+// patterns, names, modes, priorities and parameters, apply-templates and
+// call-template, value-of, text, for-each and sort, if and choose,
+// variables and params, literal result elements with attribute value
+// templates, element, attribute, copy and copy-of.  Importing and
+// including other stylesheets, keys, number, attribute sets and
+// decimal formats are not, and Load and Parse report stylesheets that use
+// them.  The current() function works as usual, and Go functions can be
+// made callable with Func.
+//
+// Parse reads a stylesheet directly, keeping the text in templates as it
+// was written.  Load takes an already parsed Document, which only keeps
+// one run of trimmed text per element, so templates with mixed content
+// such as <p>Hello <b>you</b>!</p> should be read with Parse.  Either way
+// whitespace-only text is stripped from the stylesheet except inside
+// xsl:text, as XSLT requires.  The result is a dom tree, so text written
+// into an element is joined into its single Content.  Comments and
+// processing instructions are silently dropped from the result.
+//
+// xsl:output is ignored: call Transform for a result tree and
+// TransformText for the text output method.  Namespace prefixes in
+// expressions, and in the names given to xsl:element and xsl:attribute,
+// are resolved against all the declarations in the stylesheet, not just
+// the ones in scope.  Global variables can only refer to the ones before
+// them.
+//
+// For some basic usage examples, see xslt_test.go
+package xslt
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/xpath"
+)
+
+// NS_XSLT is the XSLT namespace.
+const NS_XSLT = "http://www.w3.org/1999/XSL/Transform"
+
+// node is an element of the stylesheet.  children holds *nodes and the
+// strings of text between them.
+type node struct {
+	name     xml.Name
+	attrs    []xml.Attr
+	children []interface{}
+	parent   *node
+	// exprs holds the compiled select and test attributes.
+	exprs map[string]*xpath.Expr
+	// avts holds the compiled attribute value templates.
+	avts map[string]avt
+}
+
+func (n *node) attr(name string) (string, bool) {
+	for _, a := range n.attrs {
+		if a.Name.Space == "" && a.Name.Local == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+func (n *node) is(local string) bool {
+	return n.name.Space == NS_XSLT && n.name.Local == local
+}
+
+func (n *node) path() string {
+	steps := []string{}
+	for p := n; p != nil; p = p.parent {
+		steps = append([]string{p.name.Local}, steps...)
+	}
+	return "/" + strings.Join(steps, "/")
+}
+
+func (n *node) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("xslt: %s: %s", n.path(), fmt.Sprintf(format, args...))
+}
+
+// avt is a compiled attribute value template: literal strings and
+// expressions, in order.
+type avt []interface{}
+
+func compileAVT(s string) (avt, error) {
+	res := avt{}
+	var lit strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "{{"):
+			lit.WriteByte('{')
+			i++
+		case strings.HasPrefix(s[i:], "}}"):
+			lit.WriteByte('}')
+			i++
+		case s[i] == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated { in %q", s)
+			}
+			x, err := xpath.Compile(s[i+1 : i+end])
+			if err != nil {
+				return nil, err
+			}
+			if lit.Len() > 0 {
+				res = append(res, lit.String())
+				lit.Reset()
+			}
+			res = append(res, x)
+			i += end
+		case s[i] == '}':
+			return nil, fmt.Errorf("unmatched } in %q", s)
+		default:
+			lit.WriteByte(s[i])
+		}
+	}
+	if lit.Len() > 0 {
+		res = append(res, lit.String())
+	}
+	return res, nil
+}
+
+// rule is one alternative of the match pattern of a template.
+type rule struct {
+	t        *template
+	pattern  *xpath.Expr
+	priority float64
+}
+
+type template struct {
+	name  xml.Name
+	mode  xml.Name
+	body  *node
+	order int
+}
+
+// Stylesheet is a compiled stylesheet.  It is safe for concurrent use
+// once Func is no longer being called.
+type Stylesheet struct {
+	env     *xpath.Env
+	rules   []*rule
+	named   map[xml.Name]*template
+	globals []*node
+	// prefixes maps namespace URIs to the prefixes declared for them,
+	// to declare them on results.
+	prefixes map[string]string
+}
+
+// Parse reads and compiles a stylesheet.
+func Parse(r io.Reader) (*Stylesheet, error) {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = true
+	var root, cur *node
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("xslt: %v", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &node{name: t.Name, attrs: t.Copy().Attr, parent: cur}
+			if cur == nil {
+				root = n
+			} else {
+				cur.children = append(cur.children, n)
+			}
+			cur = n
+		case xml.EndElement:
+			cur = cur.parent
+		case xml.CharData:
+			if cur == nil {
+				break
+			}
+			if last := len(cur.children) - 1; last >= 0 {
+				if text, ok := cur.children[last].(string); ok {
+					cur.children[last] = text + string(t)
+					break
+				}
+			}
+			cur.children = append(cur.children, string(t))
+		}
+	}
+	if root == nil {
+		return nil, errors.New("xslt: no stylesheet element")
+	}
+	return compile(root)
+}
+
+// Load compiles the stylesheet in doc.
+func Load(doc *dom.Document) (*Stylesheet, error) {
+	if doc.Root() == nil {
+		return nil, errors.New("xslt: no stylesheet element")
+	}
+	var convert func(e *dom.Element, parent *node) *node
+	convert = func(e *dom.Element, parent *node) *node {
+		n := &node{name: e.Name, attrs: e.Attributes, parent: parent}
+		if len(e.Content) > 0 {
+			n.children = append(n.children, string(e.Content))
+		}
+		for _, c := range e.Children() {
+			n.children = append(n.children, convert(c, n))
+		}
+		return n
+	}
+	return compile(convert(doc.Root(), nil))
+}
+
+// Func registers fn as a function callable from expressions in s, as
+// xpath.Env.Func does.  The return value is s.
+func (s *Stylesheet) Func(name string, fn xpath.Function) *Stylesheet {
+	s.env.Func(name, fn)
+	return s
+}
+
+func isWhitespace(s string) bool {
+	return strings.Trim(s, " \t\r\n") == ""
+}
+
+// strip removes whitespace-only text from the stylesheet, except inside
+// xsl:text, and collects namespace declarations.
+func (s *Stylesheet) strip(n *node) {
+	for _, a := range n.attrs {
+		if a.Name.Space == "xmlns" {
+			if _, ok := s.env.Namespaces[a.Name.Local]; !ok {
+				s.env.Namespace(a.Name.Local, a.Value)
+			}
+			if _, ok := s.prefixes[a.Value]; !ok && a.Value != NS_XSLT {
+				s.prefixes[a.Value] = a.Name.Local
+			}
+		}
+	}
+	if n.is("text") {
+		return
+	}
+	kept := n.children[:0]
+	for _, c := range n.children {
+		switch c := c.(type) {
+		case string:
+			if isWhitespace(c) {
+				continue
+			}
+		case *node:
+			s.strip(c)
+		}
+		kept = append(kept, c)
+	}
+	n.children = kept
+}
+
+func compile(root *node) (*Stylesheet, error) {
+	if !root.is("stylesheet") && !root.is("transform") {
+		return nil, errors.New("xslt: root element must be xsl:stylesheet or xsl:transform")
+	}
+	s := &Stylesheet{env: xpath.NewEnv(), named: map[xml.Name]*template{}, prefixes: map[string]string{}}
+	s.strip(root)
+	if v, ok := root.attr("exclude-result-prefixes"); ok {
+		for _, p := range strings.Fields(v) {
+			if p == "#default" {
+				p = ""
+			}
+			if uri, ok := s.env.Namespaces[p]; ok {
+				delete(s.prefixes, uri)
+			}
+		}
+	}
+	for i, c := range root.children {
+		n, ok := c.(*node)
+		if !ok {
+			return nil, root.errorf("text is not allowed at the top level")
+		}
+		if n.name.Space != NS_XSLT {
+			continue
+		}
+		if err := s.compileNode(n); err != nil {
+			return nil, err
+		}
+		switch n.name.Local {
+		case "template":
+			if err := s.template(n, i); err != nil {
+				return nil, err
+			}
+		case "variable", "param":
+			s.globals = append(s.globals, n)
+		case "output", "strip-space", "preserve-space":
+			// The caller picks the output method, and the parser already
+			// drops whitespace-only text.
+		default:
+			return nil, n.errorf("xsl:%s is not supported", n.name.Local)
+		}
+	}
+	return s, nil
+}
+
+var instructions = map[string]bool{
+	"apply-templates": true, "call-template": true, "value-of": true, "text": true,
+	"for-each": true, "if": true, "choose": true, "when": true, "otherwise": true,
+	"variable": true, "param": true, "with-param": true, "sort": true,
+	"element": true, "attribute": true, "copy": true, "copy-of": true,
+	"comment": true, "processing-instruction": true, "message": true,
+	"template": true, "output": true, "strip-space": true, "preserve-space": true,
+}
+
+// compileNode compiles the expressions and attribute value templates in
+// the subtree rooted at n.
+func (s *Stylesheet) compileNode(n *node) error {
+	n.exprs = map[string]*xpath.Expr{}
+	n.avts = map[string]avt{}
+	if n.name.Space == NS_XSLT {
+		if !instructions[n.name.Local] {
+			return n.errorf("xsl:%s is not supported", n.name.Local)
+		}
+		for _, name := range []string{"select", "test"} {
+			if v, ok := n.attr(name); ok {
+				x, err := xpath.Compile(v)
+				if err != nil {
+					return n.errorf("%v", err)
+				}
+				n.exprs[name] = x
+			}
+		}
+		if n.is("element") || n.is("attribute") {
+			for _, name := range []string{"name", "namespace"} {
+				if v, ok := n.attr(name); ok {
+					a, err := compileAVT(v)
+					if err != nil {
+						return n.errorf("%v", err)
+					}
+					n.avts[name] = a
+				}
+			}
+		}
+		if n.is("sort") {
+			for _, name := range []string{"order", "data-type"} {
+				if v, ok := n.attr(name); ok {
+					a, err := compileAVT(v)
+					if err != nil {
+						return n.errorf("%v", err)
+					}
+					n.avts[name] = a
+				}
+			}
+		}
+	} else {
+		for _, a := range n.attrs {
+			if a.Name.Space == "xmlns" || a.Name.Space == NS_XSLT || (a.Name.Space == "" && a.Name.Local == "xmlns") {
+				continue
+			}
+			v, err := compileAVT(a.Value)
+			if err != nil {
+				return n.errorf("%v", err)
+			}
+			n.avts[a.Name.Space+" "+a.Name.Local] = v
+		}
+	}
+	for _, c := range n.children {
+		if c, ok := c.(*node); ok {
+			if err := s.compileNode(c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// qname resolves a prefixed name written in the stylesheet.
+func (s *Stylesheet) qname(n *node, name string) (xml.Name, error) {
+	i := strings.Index(name, ":")
+	if i < 0 {
+		return xml.Name{Local: name}, nil
+	}
+	uri, ok := s.env.Namespaces[name[:i]]
+	if !ok {
+		return xml.Name{}, n.errorf("namespace prefix %s is not declared", name[:i])
+	}
+	return xml.Name{Space: uri, Local: name[i+1:]}, nil
+}
+
+var (
+	nameTest     = regexp.MustCompile(`^(child::|attribute::|@)?[^\s:*/\[()|]+(:[^\s:*/\[()|]+)?$`)
+	prefixTest   = regexp.MustCompile(`^(child::|attribute::|@)?[^\s:*/\[()|]+:\*$`)
+	wildcardTest = regexp.MustCompile(`^(child::|attribute::|@)?(\*|node\(\)|text\(\)|comment\(\)|processing-instruction\(\))$`)
+)
+
+// defaultPriority returns the priority of a pattern alternative without
+// an explicit priority, following section 5.5 of the XSLT spec.
+func defaultPriority(p string) float64 {
+	switch {
+	case nameTest.MatchString(p):
+		return 0
+	case prefixTest.MatchString(p):
+		return -0.25
+	case wildcardTest.MatchString(p):
+		return -0.5
+	}
+	return 0.5
+}
+
+// alternatives splits a pattern at the | operators outside predicates
+// and literals.
+func alternatives(p string) []string {
+	res := []string{}
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[' || c == '(':
+			depth++
+		case c == ']' || c == ')':
+			depth--
+		case c == '|' && depth == 0:
+			res = append(res, strings.TrimSpace(p[start:i]))
+			start = i + 1
+		}
+	}
+	return append(res, strings.TrimSpace(p[start:]))
+}
+
+func (s *Stylesheet) template(n *node, order int) error {
+	t := &template{body: n, order: order}
+	if v, ok := n.attr("mode"); ok {
+		mode, err := s.qname(n, v)
+		if err != nil {
+			return err
+		}
+		t.mode = mode
+	}
+	if v, ok := n.attr("name"); ok {
+		name, err := s.qname(n, v)
+		if err != nil {
+			return err
+		}
+		t.name = name
+		s.named[name] = t
+	}
+	match, ok := n.attr("match")
+	if !ok {
+		if t.name.Local == "" {
+			return n.errorf("template needs a match or a name")
+		}
+		return nil
+	}
+	priority, explicit := 0.0, false
+	if v, ok := n.attr("priority"); ok {
+		var err error
+		if priority, err = strconv.ParseFloat(strings.TrimSpace(v), 64); err != nil {
+			return n.errorf("bad priority %q", v)
+		}
+		explicit = true
+	}
+	for _, alt := range alternatives(match) {
+		if alt == "" {
+			return n.errorf("bad pattern %q", match)
+		}
+		src := alt
+		if !strings.HasPrefix(alt, "/") && !strings.HasPrefix(alt, "id(") {
+			// A node matches a relative pattern if the pattern selects it
+			// from some node, which is what // does.
+			src = "//" + alt
+		}
+		x, err := xpath.Compile(src)
+		if err != nil {
+			return n.errorf("bad pattern %q: %v", match, err)
+		}
+		r := &rule{t: t, pattern: x, priority: priority}
+		if !explicit {
+			r.priority = defaultPriority(alt)
+		}
+		s.rules = append(s.rules, r)
+	}
+	return nil
+}
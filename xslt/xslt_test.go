@@ -0,0 +1,249 @@
+package xslt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/domtest"
+	"github.com/VictorLowther/simplexml/xpath"
+)
+
+const input = `<library>
+  <book id="b1" year="1999"><title>Zebras</title><price>10</price></book>
+  <book id="b2" year="2005"><title>Apples</title><price>30</price></book>
+  <book id="b3" year="2001"><title>Moths</title><price>20</price></book>
+</library>`
+
+func doc(t *testing.T, s string) *dom.Document {
+	d, err := dom.Parse(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func stylesheet(t *testing.T, body string) *Stylesheet {
+	s, err := Parse(strings.NewReader(`<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">` +
+		body + `</xsl:stylesheet>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func transform(t *testing.T, body string, params map[string]interface{}) *dom.Document {
+	res, err := stylesheet(t, body).Transform(doc(t, input), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestTemplates(t *testing.T) {
+	res := transform(t, `
+<xsl:template match="/">
+  <ul><xsl:apply-templates select="library/book"/></ul>
+</xsl:template>
+<xsl:template match="book">
+  <li id="{@id}"><xsl:value-of select="title"/></li>
+</xsl:template>
+<xsl:template match="book[price &gt; 25]">
+  <li id="{@id}" class="pricey"><xsl:value-of select="title"/></li>
+</xsl:template>`, nil)
+	domtest.EqualXML(t, res.Root(), `<ul>
+  <li id="b1">Zebras</li>
+  <li id="b2" class="pricey">Apples</li>
+  <li id="b3">Moths</li>
+</ul>`)
+}
+
+func TestBuiltinAndModes(t *testing.T) {
+	res := transform(t, `
+<xsl:template match="library">
+  <out>
+    <toc><xsl:apply-templates mode="toc"/></toc>
+    <xsl:apply-templates/>
+  </out>
+</xsl:template>
+<xsl:template match="book" mode="toc"><entry><xsl:value-of select="@id"/></entry></xsl:template>
+<xsl:template match="price"/>
+<xsl:template match="title"><t><xsl:apply-templates/></t></xsl:template>`, nil)
+	domtest.EqualXML(t, res.Root(), `<out>
+  <toc><entry>b1</entry><entry>b2</entry><entry>b3</entry></toc>
+  <t>Zebras</t><t>Apples</t><t>Moths</t>
+</out>`)
+}
+
+func TestForEachSort(t *testing.T) {
+	res := transform(t, `
+<xsl:template match="/">
+  <out>
+    <xsl:for-each select="//book">
+      <xsl:sort select="title"/>
+      <a pos="{position()}"><xsl:value-of select="title"/></a>
+    </xsl:for-each>
+    <xsl:for-each select="//book">
+      <xsl:sort select="price" data-type="number" order="descending"/>
+      <b><xsl:value-of select="price"/></b>
+    </xsl:for-each>
+  </out>
+</xsl:template>`, nil)
+	domtest.EqualXML(t, res.Root(), `<out>
+  <a pos="1">Apples</a><a pos="2">Moths</a><a pos="3">Zebras</a>
+  <b>30</b><b>20</b><b>10</b>
+</out>`)
+}
+
+func TestConditionals(t *testing.T) {
+	res := transform(t, `
+<xsl:template match="/">
+  <out><xsl:apply-templates select="//book"/></out>
+</xsl:template>
+<xsl:template match="book">
+  <xsl:if test="@year &gt; 2000"><new><xsl:value-of select="@id"/></new></xsl:if>
+  <xsl:choose>
+    <xsl:when test="price &lt; 15"><cheap/></xsl:when>
+    <xsl:when test="price &lt; 25"><fair/></xsl:when>
+    <xsl:otherwise><dear/></xsl:otherwise>
+  </xsl:choose>
+</xsl:template>`, nil)
+	domtest.EqualXML(t, res.Root(), `<out><cheap/><new>b2</new><dear/><new>b3</new><fair/></out>`)
+}
+
+func TestVariablesAndParams(t *testing.T) {
+	body := `
+<xsl:param name="limit" select="15"/>
+<xsl:variable name="count" select="count(//book)"/>
+<xsl:template match="/">
+  <out total="{$count}">
+    <xsl:variable name="sum" select="sum(//price)"/>
+    <sum><xsl:value-of select="$sum"/></sum>
+    <xsl:for-each select="//book[price &gt; $limit]">
+      <xsl:call-template name="show">
+        <xsl:with-param name="label" select="title"/>
+      </xsl:call-template>
+    </xsl:for-each>
+    <xsl:call-template name="show"/>
+  </out>
+</xsl:template>
+<xsl:template name="show">
+  <xsl:param name="label">none</xsl:param>
+  <item at="{@id}"><xsl:value-of select="$label"/></item>
+</xsl:template>`
+	res := transform(t, body, nil)
+	domtest.EqualXML(t, res.Root(), `<out total="3">
+  <sum>60</sum>
+  <item at="b2">Apples</item><item at="b3">Moths</item>
+  <item at="">none</item>
+</out>`)
+	res = transform(t, body, map[string]interface{}{"limit": 25})
+	domtest.EqualXML(t, res.Root(), `<out total="3">
+  <sum>60</sum>
+  <item at="b2">Apples</item>
+  <item at="">none</item>
+</out>`)
+}
+
+func TestConstructors(t *testing.T) {
+	res := transform(t, `
+<xsl:template match="/">
+  <xsl:element name="{name(*)}s" namespace="urn:x">
+    <xsl:attribute name="n"><xsl:value-of select="count(//book)"/></xsl:attribute>
+    <xsl:copy-of select="//book[1]/title"/>
+    <xsl:apply-templates select="//book[2]"/>
+  </xsl:element>
+</xsl:template>
+<xsl:template match="book">
+  <xsl:copy>
+    <xsl:copy-of select="@id"/>
+    <xsl:variable name="frag"><p>one</p><p>two</p></xsl:variable>
+    <xsl:copy-of select="$frag"/>
+  </xsl:copy>
+</xsl:template>`, nil)
+	root := res.Root()
+	if root.Name.Space != "urn:x" || root.Name.Local != "librarys" {
+		t.Fatalf("unexpected root %v", root.Name)
+	}
+	domtest.EqualXML(t, root, `<x:librarys xmlns:x="urn:x" n="3">
+  <title>Zebras</title>
+  <book id="b2"><p>one</p><p>two</p></book>
+</x:librarys>`)
+}
+
+func TestMixedContent(t *testing.T) {
+	s := stylesheet(t, `
+<xsl:template match="/">
+  <xsl:for-each select="//book">
+    <xsl:if test="position() != 1">, </xsl:if>
+    <xsl:value-of select="title"/> (<xsl:value-of select="@year"/>)<xsl:text> </xsl:text>
+  </xsl:for-each>
+</xsl:template>`)
+	res, err := s.TransformText(doc(t, input), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != "Zebras (1999) , Apples (2005) , Moths (2001) " {
+		t.Errorf("unexpected text %q", res)
+	}
+}
+
+func TestCurrentAndFunc(t *testing.T) {
+	s := stylesheet(t, `
+<xsl:template match="/">
+  <out><xsl:apply-templates select="//book"/></out>
+</xsl:template>
+<xsl:template match="book">
+  <b cheaper="{count(//book[price &lt; current()/price])}" shout="{shout(title)}"/>
+</xsl:template>`)
+	s.Func("shout", func(c *xpath.Context, args []xpath.Value) (xpath.Value, error) {
+		return strings.ToUpper(xpath.String(args[0])), nil
+	})
+	res, err := s.Transform(doc(t, input), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	domtest.EqualXML(t, res.Root(), `<out>
+  <b cheaper="0" shout="ZEBRAS"/><b cheaper="2" shout="APPLES"/><b cheaper="1" shout="MOTHS"/>
+</out>`)
+}
+
+func TestLoad(t *testing.T) {
+	s, err := Load(doc(t, `<xsl:transform version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:template match="/"><n><xsl:value-of select="count(//title)"/></n></xsl:template>
+</xsl:transform>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Transform(doc(t, input), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	domtest.EqualXML(t, res.Root(), `<n>3</n>`)
+}
+
+func TestErrors(t *testing.T) {
+	for _, body := range []string{
+		`<xsl:key name="k" match="book" use="@id"/>`,
+		`<xsl:import href="other.xsl"/>`,
+		`<xsl:template match="/"><xsl:number/></xsl:template>`,
+		`<xsl:template match="/"><xsl:value-of select="(("/></xsl:template>`,
+	} {
+		_, err := Parse(strings.NewReader(`<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">` +
+			body + `</xsl:stylesheet>`))
+		if err == nil {
+			t.Errorf("expected an error for %s", body)
+		}
+	}
+	for _, body := range []string{
+		`<xsl:template match="/"><a/><b/></xsl:template>`,
+		`<xsl:template match="/">text</xsl:template>`,
+		`<xsl:template match="/"><xsl:call-template name="nope"/></xsl:template>`,
+		`<xsl:template match="/"><xsl:message terminate="yes">stop</xsl:message></xsl:template>`,
+		`<xsl:template match="/"><xsl:apply-templates select="."/></xsl:template>`,
+	} {
+		if _, err := stylesheet(t, body).Transform(doc(t, input), nil); err == nil {
+			t.Errorf("expected an error for %s", body)
+		}
+	}
+}